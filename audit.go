@@ -0,0 +1,357 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains auditing of rows exported from sensitive tables.
+// For each audited table the number of exported rows and the range of
+// primary key values is recorded into operation log and into manifest, so it
+// is known exactly what left the database.
+//
+// Optionally a rolling hash chain of exported rows is computed: hash of each
+// row is computed from hash of previous row and from the row written as CSV
+// record. The final hash (and hashes of every Nth row when checkpoints are
+// enabled) is recorded into manifest, so any later tampering with exported
+// data can be detected at row granularity.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/audit.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// messages
+const (
+	auditedTableExported = "Audited table exported"
+	auditRowsMsg         = "rows"
+	auditKeyColumnMsg    = "key column"
+	auditMinKeyMsg       = "min key"
+	auditMaxKeyMsg       = "max key"
+	auditChainHashMsg    = "chain hash"
+	unknownAuditChecksum = "unknown checksum algorithm %s"
+)
+
+// Checksum algorithms used to compute hash chain of audited rows
+const (
+	noAuditChecksum     = "none"
+	sha256AuditChecksum = "sha256"
+	sha512AuditChecksum = "sha512"
+)
+
+// TableAudit describes rows exported from one audited table. Key range is
+// filled in only for tables with single column primary key (PostgreSQL
+// only).
+type TableAudit struct {
+	Rows      int    `json:"rows"`
+	KeyColumn string `json:"key_column,omitempty"`
+	MinKey    string `json:"min_key,omitempty"`
+	MaxKey    string `json:"max_key,omitempty"`
+
+	// Checksum is algorithm used to compute hash chain of exported rows,
+	// ChainHash is hash of the last row
+	Checksum    string            `json:"checksum,omitempty"`
+	ChainHash   string            `json:"chain_hash,omitempty"`
+	Checkpoints []AuditCheckpoint `json:"checkpoints,omitempty"`
+}
+
+// AuditCheckpoint contains hash chain computed up to given row (rows are
+// numbered from 1)
+type AuditCheckpoint struct {
+	Row  int    `json:"row"`
+	Hash string `json:"hash"`
+}
+
+// ExportAudit contains audits of all tables exported by one run.
+//
+// All methods can be called on nil pointer - in this case no table is
+// audited. Methods are safe to be called from several goroutines.
+type ExportAudit struct {
+	mutex   sync.Mutex
+	audited map[TableName]bool
+	tables  map[TableName]TableAudit
+
+	// checksum and checkpointRows select computation of hash chain
+	checksum       string
+	checkpointRows int
+}
+
+// NewExportAudit function constructs audit of given tables. Nil is returned
+// when no table needs to be audited.
+func NewExportAudit(tableNames []string) *ExportAudit {
+	if len(tableNames) == 0 {
+		return nil
+	}
+
+	audited := make(map[TableName]bool, len(tableNames))
+	for _, tableName := range tableNames {
+		audited[TableName(tableName)] = true
+	}
+
+	return &ExportAudit{
+		audited: audited,
+		tables:  make(map[TableName]TableAudit),
+	}
+}
+
+// checkAuditChecksum function checks if given checksum algorithm is
+// supported
+func checkAuditChecksum(algorithm string) error {
+	switch algorithm {
+	case "", noAuditChecksum, sha256AuditChecksum, sha512AuditChecksum:
+		return nil
+	default:
+		return fmt.Errorf(unknownAuditChecksum, algorithm)
+	}
+}
+
+// newAuditHash function returns constructor of hash for given checksum
+// algorithm, nil is returned when hash chain is not computed
+func newAuditHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case sha256AuditChecksum:
+		return sha256.New
+	case sha512AuditChecksum:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// EnableChecksums method enables computation of hash chain of rows exported
+// from audited tables. Hash computed up to every checkpointRows-th row is
+// recorded too when checkpointRows is positive.
+func (audit *ExportAudit) EnableChecksums(algorithm string, checkpointRows int) {
+	if audit == nil {
+		return
+	}
+
+	audit.checksum = algorithm
+	audit.checkpointRows = checkpointRows
+}
+
+// Audited method checks if given table needs to be audited
+func (audit *ExportAudit) Audited(tableName TableName) bool {
+	if audit == nil {
+		return false
+	}
+
+	return audit.audited[tableName]
+}
+
+// Record method records audit of one exported table
+func (audit *ExportAudit) Record(tableName TableName, tableAudit TableAudit) {
+	if audit == nil {
+		return
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	audit.tables[tableName] = tableAudit
+}
+
+// Table method returns audit of given table if it has been recorded
+func (audit *ExportAudit) Table(tableName TableName) (TableAudit, bool) {
+	if audit == nil {
+		return TableAudit{}, false
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	tableAudit, found := audit.tables[tableName]
+	return tableAudit, found
+}
+
+// keyLess function compares two values of primary key. Integer keys are
+// compared numerically, all other keys by their textual form.
+func keyLess(a, b interface{}) bool {
+	intA, okA := a.(int64)
+	intB, okB := b.(int64)
+	if okA && okB {
+		return intA < intB
+	}
+
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// tableAuditor accumulates audit of one table while its rows are exported,
+// so the rows don't need to be kept in memory. Nil auditor is used for
+// tables that are not audited.
+type tableAuditor struct {
+	audit  TableAudit
+	minKey interface{}
+	maxKey interface{}
+
+	// hash chain of exported rows, it is computed only when newHash is set
+	newHash        func() hash.Hash
+	checkpointRows int
+	chain          []byte
+	record         bytes.Buffer
+}
+
+// chainRow method adds one row into hash chain. Row is hashed as CSV record
+// (with values ordered by colNames) together with hash of previous row.
+func (auditor *tableAuditor) chainRow(colNames []string, row M) {
+	auditor.record.Reset()
+	writer, release := newCSVWriter(&auditor.record)
+	defer release()
+	// writing into memory buffer can't fail
+	_ = writer.Write(csvValues(colNames, row))
+	writer.Flush()
+
+	h := auditor.newHash()
+	h.Write(auditor.chain)
+	h.Write(auditor.record.Bytes())
+	auditor.chain = h.Sum(nil)
+
+	rows := auditor.audit.Rows + 1
+	if auditor.checkpointRows > 0 && rows%auditor.checkpointRows == 0 {
+		auditor.audit.Checkpoints = append(auditor.audit.Checkpoints,
+			AuditCheckpoint{Row: rows, Hash: hex.EncodeToString(auditor.chain)})
+	}
+}
+
+// Add method records one exported row
+func (auditor *tableAuditor) Add(colNames []string, row M) {
+	if auditor == nil {
+		return
+	}
+
+	if auditor.newHash != nil {
+		auditor.chainRow(colNames, row)
+	}
+
+	if auditor.audit.KeyColumn != "" {
+		key := row[auditor.audit.KeyColumn]
+		if auditor.audit.Rows == 0 || keyLess(key, auditor.minKey) {
+			auditor.minKey = key
+		}
+		if auditor.audit.Rows == 0 || keyLess(auditor.maxKey, key) {
+			auditor.maxKey = key
+		}
+	}
+
+	auditor.audit.Rows++
+}
+
+// Reset method forgets all recorded rows, it is used when the table is read
+// again
+func (auditor *tableAuditor) Reset() {
+	if auditor == nil {
+		return
+	}
+
+	auditor.audit.Rows = 0
+	auditor.audit.Checkpoints = nil
+	auditor.minKey = nil
+	auditor.maxKey = nil
+	auditor.chain = nil
+}
+
+// keyRangeOfRows function returns the lowest and the highest value of given
+// column in all rows
+func keyRangeOfRows(rows []M, keyColumn string) (interface{}, interface{}) {
+	auditor := tableAuditor{
+		audit: TableAudit{KeyColumn: keyColumn},
+	}
+
+	for _, row := range rows {
+		auditor.Add(nil, row)
+	}
+
+	return auditor.minKey, auditor.maxKey
+}
+
+// newTableAuditor method constructs auditor of given table. Nil is returned
+// when the table does not need to be audited.
+func (storage DBStorage) newTableAuditor(ctx context.Context, tableName TableName) (*tableAuditor, error) {
+	if !storage.audit.Audited(tableName) {
+		return nil, nil
+	}
+
+	auditor := &tableAuditor{
+		newHash:        newAuditHash(storage.audit.checksum),
+		checkpointRows: storage.audit.checkpointRows,
+	}
+	if auditor.newHash != nil {
+		auditor.audit.Checksum = storage.audit.checksum
+	}
+
+	// primary key is read from PostgreSQL catalog
+	if storage.dbDriverType == DBDriverPostgres {
+		keyColumn, _, err := storage.readPrimaryKey(ctx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		auditor.audit.KeyColumn = keyColumn
+	}
+
+	return auditor, nil
+}
+
+// recordTableAudit method records number of exported rows and range of
+// primary key values when all rows of given table have been exported
+func (storage DBStorage) recordTableAudit(tableName TableName, auditor *tableAuditor) {
+	if auditor == nil {
+		return
+	}
+
+	tableAudit := auditor.audit
+	if tableAudit.KeyColumn != "" && tableAudit.Rows > 0 {
+		tableAudit.MinKey = fmt.Sprint(auditor.minKey)
+		tableAudit.MaxKey = fmt.Sprint(auditor.maxKey)
+	}
+	if auditor.chain != nil {
+		tableAudit.ChainHash = hex.EncodeToString(auditor.chain)
+	}
+
+	storage.audit.Record(tableName, tableAudit)
+}
+
+// logTableAudit function writes audit of given table into log and into
+// operation log
+func logTableAudit(logger, operationLogger *zerolog.Logger, audit *ExportAudit,
+	tableName TableName) {
+	tableAudit, found := audit.Table(tableName)
+	if !found {
+		return
+	}
+
+	for _, l := range []*zerolog.Logger{logger, operationLogger} {
+		l.Info().
+			Str(tableNameMsg, string(tableName)).
+			Int(auditRowsMsg, tableAudit.Rows).
+			Str(auditKeyColumnMsg, tableAudit.KeyColumn).
+			Str(auditMinKeyMsg, tableAudit.MinKey).
+			Str(auditMaxKeyMsg, tableAudit.MaxKey).
+			Str(auditChainHashMsg, tableAudit.ChainHash).
+			Msg(auditedTableExported)
+	}
+}
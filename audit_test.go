@@ -0,0 +1,202 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/audit_test.html
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestExportAuditNil checks that nil audit does not audit any table
+func TestExportAuditNil(t *testing.T) {
+	audit := main.NewExportAudit(nil)
+	assert.Nil(t, audit)
+
+	assert.False(t, audit.Audited("report"))
+
+	audit.Record("report", main.TableAudit{Rows: 1})
+	_, found := audit.Table("report")
+	assert.False(t, found)
+}
+
+// TestExportAudit checks that audits are recorded for audited tables
+func TestExportAudit(t *testing.T) {
+	audit := main.NewExportAudit([]string{"report"})
+
+	assert.True(t, audit.Audited("report"))
+	assert.False(t, audit.Audited("rule_hit"))
+
+	_, found := audit.Table("report")
+	assert.False(t, found)
+
+	audit.Record("report", main.TableAudit{Rows: 2, KeyColumn: "id"})
+	tableAudit, found := audit.Table("report")
+	assert.True(t, found)
+	assert.Equal(t, main.TableAudit{Rows: 2, KeyColumn: "id"}, tableAudit)
+}
+
+// TestKeyRangeOfRows checks the function keyRangeOfRows
+func TestKeyRangeOfRows(t *testing.T) {
+	// integer keys are compared numerically
+	minKey, maxKey := main.KeyRangeOfRows([]main.M{
+		{"id": int64(9)}, {"id": int64(10)}, {"id": int64(-1)},
+	}, "id")
+	assert.Equal(t, int64(-1), minKey)
+	assert.Equal(t, int64(10), maxKey)
+
+	// other keys are compared by their textual form
+	minKey, maxKey = main.KeyRangeOfRows([]main.M{
+		{"id": "b"}, {"id": "c"}, {"id": "a"},
+	}, "id")
+	assert.Equal(t, "a", minKey)
+	assert.Equal(t, "c", maxKey)
+
+	// no rows
+	minKey, maxKey = main.KeyRangeOfRows(nil, "id")
+	assert.Nil(t, minKey)
+	assert.Nil(t, maxKey)
+}
+
+// TestWriteTableContentAudited checks that rows and key range exported from
+// audited table are recorded
+func TestWriteTableContentAudited(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	// primary key is read before rows, so key range is computed while
+	// they are written
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name`).
+		WillReturnRows(rangeRows(mock, 10, 2, 5))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	audit := main.NewExportAudit([]string{"table_name"})
+	main.SetAudit(storage, audit)
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "10,row\n2,row\n5,row\n", output)
+
+	tableAudit, found := audit.Table("table_name")
+	assert.True(t, found)
+	assert.Equal(t, main.TableAudit{
+		Rows:      3,
+		KeyColumn: "id",
+		MinKey:    "2",
+		MaxKey:    "10",
+	}, tableAudit)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// chainHash function computes hash chain of given CSV records
+func chainHash(records ...string) []byte {
+	var chain []byte
+	for _, record := range records {
+		h := sha256.New()
+		h.Write(chain)
+		h.Write([]byte(record))
+		chain = h.Sum(nil)
+	}
+	return chain
+}
+
+// TestWriteTableContentAuditedChecksums checks that hash chain of rows
+// exported from audited table is recorded together with checkpoints
+func TestWriteTableContentAuditedChecksums(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name`).
+		WillReturnRows(rangeRows(mock, 10, 2, 5))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	audit := main.NewExportAudit([]string{"table_name"})
+	audit.EnableChecksums("sha256", 2)
+	main.SetAudit(storage, audit)
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "10,row\n2,row\n5,row\n", output)
+
+	tableAudit, found := audit.Table("table_name")
+	assert.True(t, found)
+	assert.Equal(t, main.TableAudit{
+		Rows:      3,
+		KeyColumn: "id",
+		MinKey:    "2",
+		MaxKey:    "10",
+		Checksum:  "sha256",
+		ChainHash: hex.EncodeToString(chainHash("10,row\n", "2,row\n", "5,row\n")),
+		Checkpoints: []main.AuditCheckpoint{
+			{Row: 2, Hash: hex.EncodeToString(chainHash("10,row\n", "2,row\n"))},
+		},
+	}, tableAudit)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckAuditChecksum checks the function checkAuditChecksum
+func TestCheckAuditChecksum(t *testing.T) {
+	assert.NoError(t, main.CheckAuditChecksum(""))
+	assert.NoError(t, main.CheckAuditChecksum("none"))
+	assert.NoError(t, main.CheckAuditChecksum("sha256"))
+	assert.NoError(t, main.CheckAuditChecksum("sha512"))
+	assert.EqualError(t, main.CheckAuditChecksum("md5"), "unknown checksum algorithm md5")
+}
+
+// TestWriteTableContentNotAudited checks that tables that are not audited
+// are exported without reading primary key
+func TestWriteTableContentNotAudited(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name`).
+		WillReturnRows(rangeRows(mock, 1))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	audit := main.NewExportAudit([]string{"report"})
+	main.SetAudit(storage, audit)
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+
+	_, found := audit.Table("table_name")
+	assert.False(t, found)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
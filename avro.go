@@ -0,0 +1,318 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains table writer that produces Avro Object Container
+// Files. The record schema is derived from column types read from database
+// and it is embedded into file header, so the file can be consumed by Kafka
+// Connect and other schema-registry-based tools directly.
+//
+// Avro specification:
+// https://avro.apache.org/docs/1.11.1/specification/
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/avro.html
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Avro container file constants
+const (
+	avroMagic          = "Obj\x01"
+	avroSyncMarkerSize = 16
+	avroSchemaKey      = "avro.schema"
+	avroCodecKey       = "avro.codec"
+	avroNullCodec      = "null"
+
+	// number of rows stored in one data block
+	avroBlockSize = 1000
+)
+
+// Avro primitive types used in generated schemas
+const (
+	avroNull    = "null"
+	avroBoolean = "boolean"
+	avroInt     = "int"
+	avroLong    = "long"
+	avroDouble  = "double"
+	avroBytes   = "bytes"
+	avroString  = "string"
+)
+
+// avroField represents one field in Avro record schema. All fields are
+// nullable, so the type is union of null and the type derived from column
+// type.
+type avroField struct {
+	Name    string      `json:"name"`
+	Type    []string    `json:"type"`
+	Default interface{} `json:"default"`
+}
+
+// avroSchema represents Avro record schema for one table
+type avroSchema struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Fields []avroField `json:"fields"`
+}
+
+// avroTypeForColumn function returns Avro type for given database column
+// type. Types need to follow the types used to scan values from database
+// (see fillInScanArgs), other types are exported as strings.
+func avroTypeForColumn(databaseType string) string {
+	switch databaseType {
+	case "BOOL":
+		return avroBoolean
+	case "INT2", "INT4":
+		return avroInt
+	case "INT8":
+		return avroLong
+	case "FLOAT4", "FLOAT8":
+		return avroDouble
+	case "BYTEA":
+		return avroBytes
+	default:
+		return avroString
+	}
+}
+
+// avroName function converts given identifier into valid Avro name, ie. name
+// that matches the regexp [A-Za-z_][A-Za-z0-9_]*
+func avroName(identifier string) string {
+	name := []byte(identifier)
+
+	for i, c := range name {
+		valid := c == '_' ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+
+	// name can not be empty and can not start with digit
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// newAvroSchema function constructs Avro record schema for given table
+func newAvroSchema(tableName TableName, columns []Column) avroSchema {
+	fields := make([]avroField, 0, len(columns))
+
+	for _, column := range columns {
+		fields = append(fields, avroField{
+			Name:    avroName(column.Name),
+			Type:    []string{avroNull, avroTypeForColumn(column.DatabaseType)},
+			Default: nil,
+		})
+	}
+
+	return avroSchema{
+		Type:   "record",
+		Name:   avroName(string(tableName)),
+		Fields: fields,
+	}
+}
+
+// appendAvroLong function appends long value encoded as zig-zag variable
+// length integer. The same encoding is used for int values.
+func appendAvroLong(buffer []byte, value int64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], uint64((value<<1)^(value>>63)))
+	return append(buffer, encoded[:n]...)
+}
+
+// appendAvroBytes function appends byte sequence prefixed by its length. The
+// same encoding is used for strings.
+func appendAvroBytes(buffer, value []byte) []byte {
+	buffer = appendAvroLong(buffer, int64(len(value)))
+	return append(buffer, value...)
+}
+
+// appendAvroValue function appends one value encoded according to given Avro
+// type. Null values are encoded as the first branch of the union.
+func appendAvroValue(buffer []byte, avroType string, value interface{}) ([]byte, error) {
+	if b, ok := value.(ByteaValue); ok && b == nil {
+		value = nil
+	}
+
+	if value == nil {
+		return appendAvroLong(buffer, 0), nil
+	}
+
+	// second branch of union
+	buffer = appendAvroLong(buffer, 1)
+
+	switch avroType {
+	case avroBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("value %v can not be stored as Avro %s", value, avroType)
+		}
+		if b {
+			return append(buffer, 1), nil
+		}
+		return append(buffer, 0), nil
+	case avroInt, avroLong:
+		switch v := value.(type) {
+		case int64:
+			return appendAvroLong(buffer, v), nil
+		case int32:
+			return appendAvroLong(buffer, int64(v)), nil
+		case int:
+			return appendAvroLong(buffer, int64(v)), nil
+		default:
+			return nil, fmt.Errorf("value %v can not be stored as Avro %s", value, avroType)
+		}
+	case avroDouble:
+		v, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("value %v can not be stored as Avro %s", value, avroType)
+		}
+		var encoded [8]byte
+		binary.LittleEndian.PutUint64(encoded[:], math.Float64bits(v))
+		return append(buffer, encoded[:]...), nil
+	case avroBytes:
+		v, ok := value.([]byte)
+		if b, isBytea := value.(ByteaValue); isBytea {
+			v, ok = b, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("value %v can not be stored as Avro %s", value, avroType)
+		}
+		return appendAvroBytes(buffer, v), nil
+	default:
+		return appendAvroBytes(buffer, []byte(fmt.Sprintf("%v", value))), nil
+	}
+}
+
+// avroTableWriter writes table content as Avro Object Container File. Rows
+// are collected into blocks, each block is written when it is full or when
+// the writer is flushed.
+type avroTableWriter struct {
+	writer     io.Writer
+	schema     avroSchema
+	syncMarker [avroSyncMarkerSize]byte
+	block      []byte
+	rows       int64
+}
+
+// newAvroTableWriter function constructs Avro table writer with schema
+// derived from given columns
+func newAvroTableWriter(writer io.Writer, tableName TableName, columns []Column) (*avroTableWriter, error) {
+	avroWriter := avroTableWriter{
+		writer: writer,
+		schema: newAvroSchema(tableName, columns),
+	}
+
+	// sync marker should be random
+	_, err := rand.Read(avroWriter.syncMarker[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &avroWriter, nil
+}
+
+// WriteHeader method writes file header with embedded schema, column names
+// are part of the schema
+func (w *avroTableWriter) WriteHeader(_ []string) error {
+	schema, err := json.Marshal(w.schema)
+	if err != nil {
+		return err
+	}
+
+	header := []byte(avroMagic)
+
+	// file metadata is stored as map with two items
+	header = appendAvroLong(header, 2)
+	header = appendAvroBytes(header, []byte(avroSchemaKey))
+	header = appendAvroBytes(header, schema)
+	header = appendAvroBytes(header, []byte(avroCodecKey))
+	header = appendAvroBytes(header, []byte(avroNullCodec))
+	header = appendAvroLong(header, 0)
+
+	header = append(header, w.syncMarker[:]...)
+
+	_, err = w.writer.Write(header)
+	return err
+}
+
+// WriteRow method encodes one row into current data block. Row is added to
+// the block only when all its values have been encoded.
+func (w *avroTableWriter) WriteRow(colNames []string, row M) error {
+	var encoded []byte
+	var err error
+
+	for i, colName := range colNames {
+		avroType := avroString
+		if i < len(w.schema.Fields) {
+			avroType = w.schema.Fields[i].Type[1]
+		}
+
+		encoded, err = appendAvroValue(encoded, avroType, row[colName])
+		if err != nil {
+			return RowConversionError{err}
+		}
+	}
+	w.block = append(w.block, encoded...)
+
+	w.rows++
+	if w.rows >= avroBlockSize {
+		return w.writeBlock()
+	}
+	return nil
+}
+
+// writeBlock method writes current data block followed by sync marker
+func (w *avroTableWriter) writeBlock() error {
+	if w.rows == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(appendAvroLong(nil, w.rows))
+	buffer.Write(appendAvroLong(nil, int64(len(w.block))))
+	buffer.Write(w.block)
+	buffer.Write(w.syncMarker[:])
+
+	_, err := w.writer.Write(buffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	w.block = w.block[:0]
+	w.rows = 0
+	return nil
+}
+
+// Flush method writes the last (possibly incomplete) data block
+func (w *avroTableWriter) Flush() error {
+	return w.writeBlock()
+}
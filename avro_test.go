@@ -0,0 +1,151 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/avro_test.html
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// avroReader is minimal reader of Avro values used to check the output
+type avroReader struct {
+	t      *testing.T
+	reader *bytes.Reader
+}
+
+// readLong method reads one zig-zag encoded long value
+func (r avroReader) readLong() int64 {
+	value, err := binary.ReadUvarint(r.reader)
+	assert.NoError(r.t, err)
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+// readBytes method reads byte sequence prefixed by its length
+func (r avroReader) readBytes() []byte {
+	length := r.readLong()
+	buffer := make([]byte, length)
+	_, err := r.reader.Read(buffer)
+	assert.NoError(r.t, err)
+	return buffer
+}
+
+// readFixed method reads byte sequence with given length
+func (r avroReader) readFixed(length int) []byte {
+	buffer := make([]byte, length)
+	_, err := r.reader.Read(buffer)
+	assert.NoError(r.t, err)
+	return buffer
+}
+
+// TestAvroTableWriter checks writing table into Avro container file
+func TestAvroTableWriter(t *testing.T) {
+	output := writeTestTable(t, "avro", testRows)
+	r := avroReader{t, bytes.NewReader([]byte(output))}
+
+	// file header
+	assert.Equal(t, []byte("Obj\x01"), r.readFixed(4))
+
+	metadata := map[string]string{}
+	assert.Equal(t, int64(2), r.readLong())
+	for i := 0; i < 2; i++ {
+		key := string(r.readBytes())
+		metadata[key] = string(r.readBytes())
+	}
+	assert.Equal(t, int64(0), r.readLong())
+	assert.Equal(t, "null", metadata["avro.codec"])
+
+	var schema map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(metadata["avro.schema"]), &schema))
+	assert.Equal(t, "record", schema["type"])
+	assert.Equal(t, "test_table", schema["name"])
+	assert.Len(t, schema["fields"], 3)
+
+	expectedSchema := `{"type":"record","name":"test_table","fields":[` +
+		`{"name":"id","type":["null","int"],"default":null},` +
+		`{"name":"name","type":["null","string"],"default":null},` +
+		`{"name":"valid","type":["null","boolean"],"default":null}]}`
+	assert.JSONEq(t, expectedSchema, metadata["avro.schema"])
+
+	syncMarker := r.readFixed(16)
+
+	// one data block with two rows
+	assert.Equal(t, int64(2), r.readLong())
+	r.readLong() // block size
+
+	for _, row := range testRows {
+		assert.Equal(t, int64(1), r.readLong())
+		assert.Equal(t, row["id"], r.readLong())
+		assert.Equal(t, int64(1), r.readLong())
+		assert.Equal(t, row["name"], string(r.readBytes()))
+		assert.Equal(t, int64(1), r.readLong())
+		valid := r.readFixed(1)[0] == 1
+		assert.Equal(t, row["valid"], valid)
+	}
+
+	assert.Equal(t, syncMarker, r.readFixed(16))
+	assert.Equal(t, 0, r.reader.Len())
+}
+
+// TestAvroTableWriterNullValue checks that null values are encoded as the
+// first branch of union
+func TestAvroTableWriterNullValue(t *testing.T) {
+	rows := []main.M{
+		{"id": nil, "name": nil, "valid": nil},
+	}
+	output := writeTestTable(t, "avro", rows)
+
+	// last row is encoded just before sync marker: three null branches
+	data := []byte(output)
+	assert.Equal(t, []byte{0, 0, 0}, data[len(data)-16-3:len(data)-16])
+}
+
+// TestAvroTableWriterEmptyTable checks that only header is written for empty
+// table
+func TestAvroTableWriterEmptyTable(t *testing.T) {
+	output := writeTestTable(t, "avro", nil)
+	assert.True(t, bytes.HasPrefix([]byte(output), []byte("Obj\x01")))
+}
+
+// TestAvroTableWriterWrongValue checks that value not matching the schema is
+// refused
+func TestAvroTableWriterWrongValue(t *testing.T) {
+	writer, err := main.NewTableWriter("avro", new(bytes.Buffer), "test_table", testTableColumns)
+	assert.NoError(t, err)
+
+	err = writer.WriteRow(testColumns, main.M{"id": "not a number", "name": "foo", "valid": true})
+	assert.Error(t, err)
+}
+
+// TestAvroName checks conversion of identifiers into Avro names
+func TestAvroName(t *testing.T) {
+	assert.Equal(t, "report_info", main.AvroName("report_info"))
+	assert.Equal(t, "_1st_column", main.AvroName("1st-column"))
+	assert.Equal(t, "public_table", main.AvroName("public.table"))
+	assert.Equal(t, "_", main.AvroName(""))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains pools of buffers and buffered writers used by
+// all CSV generation paths. Buffers are reused by tables exported one after
+// another and by tables exported in parallel, so large runs allocate less
+// memory and put less pressure on garbage collector. All functions are safe
+// to be called from several goroutines.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/bufferpool.html
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"sync"
+)
+
+// bufferedWriterSize is size of pooled buffered writers, it is larger than
+// default size of bufio writer, so fewer writes are made into output
+const bufferedWriterSize = 64 * 1024
+
+// maxPooledBufferSize is capacity of buffer above which the buffer is not
+// returned into pool, so memory taken by exceptionally large table is
+// released
+const maxPooledBufferSize = 64 * 1024 * 1024
+
+// bufferPool contains buffers tables and reports are serialized into
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// bufferedWriterPool contains buffered writers CSV is written through
+var bufferedWriterPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, bufferedWriterSize)
+	},
+}
+
+// getBuffer function returns empty buffer from pool. Buffer needs to be
+// returned by putBuffer when its content is not used anymore.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer function returns buffer into pool, nil buffer is ignored
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer == nil || buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+// getBufferedWriter function returns buffered writer from pool that writes
+// into given output. Writer needs to be returned by putBufferedWriter when
+// it has been flushed.
+func getBufferedWriter(output io.Writer) *bufio.Writer {
+	writer := bufferedWriterPool.Get().(*bufio.Writer)
+	writer.Reset(output)
+	return writer
+}
+
+// putBufferedWriter function returns buffered writer into pool. Data not
+// flushed yet are discarded.
+func putBufferedWriter(writer *bufio.Writer) {
+	if writer == nil {
+		return
+	}
+
+	// output is not referenced by pooled writer
+	writer.Reset(nil)
+	bufferedWriterPool.Put(writer)
+}
+
+// newCSVWriter function constructs CSV writer that writes into given output
+// through pooled buffered writer. Returned function needs to be called when
+// the CSV writer has been flushed and it is not used anymore.
+func newCSVWriter(output io.Writer) (*csv.Writer, func()) {
+	buffered := getBufferedWriter(output)
+
+	// CSV writer uses given buffered writer as it is, because it is large
+	// enough
+	return csv.NewWriter(buffered), func() {
+		putBufferedWriter(buffered)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/bufferpool_test.html
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestGetBufferEmpty checks that buffers taken from pool are always empty
+func TestGetBufferEmpty(t *testing.T) {
+	buffer := main.GetBuffer()
+	buffer.WriteString("content of previous table")
+	main.PutBuffer(buffer)
+
+	for i := 0; i < 10; i++ {
+		buffer := main.GetBuffer()
+		assert.Zero(t, buffer.Len())
+		main.PutBuffer(buffer)
+	}
+
+	// nil buffer is ignored
+	main.PutBuffer(nil)
+}
+
+// TestNewCSVWriter checks that CSV written through pooled writers is not
+// mixed when more writers are used concurrently
+func TestNewCSVWriter(t *testing.T) {
+	const writers = 8
+
+	outputs := make([]*bytes.Buffer, writers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		outputs[i] = new(bytes.Buffer)
+		wg.Add(1)
+		go func(output *bytes.Buffer, i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				writer, release := main.NewCSVWriter(output)
+				assert.NoError(t, writer.Write([]string{fmt.Sprint(i), "a,b"}))
+				writer.Flush()
+				assert.NoError(t, writer.Error())
+				release()
+			}
+		}(outputs[i], i)
+	}
+	wg.Wait()
+
+	for i, output := range outputs {
+		expected := fmt.Sprintf("%d,\"a,b\"\n", i)
+		assert.Equal(t, bytes.Repeat([]byte(expected), 100), output.Bytes())
+	}
+}
+
+// TestCSVTableWriterFlushTwice checks that CSV table writer can be flushed
+// more times, its buffered writer is returned into pool by the first flush
+func TestCSVTableWriterFlushTwice(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := main.NewCSVTableWriter(buffer, "")
+
+	assert.NoError(t, writer.WriteHeader([]string{"id"}))
+	assert.NoError(t, writer.Flush())
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, "id\n", buffer.String())
+}
@@ -0,0 +1,280 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions to bundle the whole export (all
+// tables, list of tables, metadata, disabled rules and operation log) into
+// one tar.gz or zip archive. All files are exported into temporary directory
+// first and the archive is constructed from its content.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/bundle.html
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Supported bundle types
+const (
+	tarGzBundle = "tar.gz"
+	zipBundle   = "zip"
+)
+
+// Extensions and content types of bundles
+const (
+	tarGzBundleExtension   = ".tar.gz"
+	zipBundleExtension     = ".zip"
+	tarGzBundleContentType = "application/gzip"
+	zipBundleContentType   = "application/zip"
+)
+
+// name of file or object with bundled export, extension depends on bundle
+// type
+const bundleFile = "export"
+
+// messages
+const (
+	unknownBundle     = "Unknown bundle type: %s"
+	unsupportedBundle = "Bundle can not be used with output %s"
+)
+
+// checkBundle function checks if given bundle type is supported and if it
+// can be used with selected output
+func checkBundle(bundle, output string) error {
+	switch bundle {
+	case "":
+		return nil
+	case tarGzBundle, zipBundle:
+	default:
+		return fmt.Errorf(unknownBundle, bundle)
+	}
+
+	if output != fileOutput && output != s3Output && output != sftpOutput {
+		return fmt.Errorf(unsupportedBundle, output)
+	}
+
+	return nil
+}
+
+// bundleExtension function returns extension of bundle file or object
+func bundleExtension(bundle string) string {
+	if bundle == zipBundle {
+		return zipBundleExtension
+	}
+	return tarGzBundleExtension
+}
+
+// bundleContentType function returns content type of bundle stored into S3
+func bundleContentType(bundle string) string {
+	if bundle == zipBundle {
+		return zipBundleContentType
+	}
+	return tarGzBundleContentType
+}
+
+// bundleFiles function returns names of all regular files in given
+// directory in sorted order
+func bundleFiles(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNames []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+
+	return fileNames, nil
+}
+
+// writeBundle function writes all files from given directory into archive of
+// selected type
+func writeBundle(writer io.Writer, bundle, directory string) error {
+	fileNames, err := bundleFiles(directory)
+	if err != nil {
+		return err
+	}
+
+	switch bundle {
+	case tarGzBundle:
+		return writeTarGzBundle(writer, directory, fileNames)
+	case zipBundle:
+		return writeZipBundle(writer, directory, fileNames)
+	default:
+		return fmt.Errorf(unknownBundle, bundle)
+	}
+}
+
+// writeTarGzBundle function writes given files into tar archive compressed
+// by gzip
+func writeTarGzBundle(writer io.Writer, directory string, fileNames []string) error {
+	compressor := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressor)
+
+	for _, fileName := range fileNames {
+		err := addFileIntoBundle(directory, fileName, func(info os.FileInfo) (io.Writer, error) {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return nil, err
+			}
+			header.Name = fileName
+			return archive, archive.WriteHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return err
+	}
+
+	return compressor.Close()
+}
+
+// writeZipBundle function writes given files into zip archive
+func writeZipBundle(writer io.Writer, directory string, fileNames []string) error {
+	archive := zip.NewWriter(writer)
+
+	for _, fileName := range fileNames {
+		err := addFileIntoBundle(directory, fileName, func(info os.FileInfo) (io.Writer, error) {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return nil, err
+			}
+			header.Name = fileName
+			header.Method = zip.Deflate
+			return archive.CreateHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// addFileIntoBundle function copies content of one file into archive entry
+// created by given function
+func addFileIntoBundle(directory, fileName string,
+	createEntry func(info os.FileInfo) (io.Writer, error)) error {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(filepath.Join(directory, fileName)) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	entry, err := createEntry(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// storeBundleIntoFile function stores all files from given directory into
+// bundle file, the bundle is encrypted when encryption is given. Extension
+// of encrypted files is added to file name.
+func storeBundleIntoFile(fileName, bundle, directory string, encryption *Encryption) error {
+	fout, err := createCompressedFile(fileName, noCompression, encryption)
+	if err != nil {
+		return err
+	}
+
+	err = writeBundle(fout, bundle, directory)
+	if err != nil {
+		// error during write is more important than error during close
+		_ = fout.Close()
+		return err
+	}
+
+	return fout.Close()
+}
+
+// storeBundleIntoS3 function stores all files from given directory into
+// bundle object in configured bucket, the bundle is encrypted when
+// encryption is given
+func storeBundleIntoS3(configuration *ConfigStruct, bundle, directory string,
+	encryption *Encryption) error {
+	buffer := new(bytes.Buffer)
+
+	err := writeBundle(buffer, bundle, directory)
+	if err != nil {
+		return err
+	}
+
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return err
+	}
+
+	objectName := session.ObjectName(bundleFile + bundleExtension(bundle))
+
+	// compression configured for exported files is applied inside bundle
+	return putObject(session.Context(), session, session.Bucket(), objectName,
+		bundleContentType(bundle), buffer.Bytes(), noCompression, encryption)
+}
+
+// storeBundle function stores bundle with the whole export into selected
+// output
+func storeBundle(configuration *ConfigStruct, cliFlags CliFlags) (int, error) {
+	// files inside bundle are not encrypted, the bundle is
+	encryption, err := configuredEncryption(configuration)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	if cliFlags.Output == s3Output {
+		err := storeBundleIntoS3(configuration, cliFlags.Bundle, cliFlags.OutputDirectory,
+			encryption)
+		if err != nil {
+			return ExitStatusS3Error, err
+		}
+		return ExitStatusOK, nil
+	}
+
+	err = storeBundleIntoFile(bundleFile+bundleExtension(cliFlags.Bundle),
+		cliFlags.Bundle, cliFlags.OutputDirectory, encryption)
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+	return ExitStatusOK, nil
+}
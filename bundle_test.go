@@ -0,0 +1,148 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/bundle_test.html
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// bundledFiles contains files exported into bundle directory
+var bundledFiles = map[string]string{
+	"_tables.csv":   "Table name\nreport\n",
+	"_metadata.csv": "Table name,Records\nreport,1\n",
+	"report.csv":    "org_id,cluster\n1,first\n",
+	"_logs.txt":     "{\"message\":\"Memory logger initialized\"}\n",
+}
+
+// prepareBundleDirectory helper function creates directory with exported
+// files
+func prepareBundleDirectory(t *testing.T) string {
+	directory := t.TempDir()
+
+	for name, content := range bundledFiles {
+		err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0600)
+		assert.NoError(t, err)
+	}
+
+	// directories are not bundled
+	assert.NoError(t, os.Mkdir(filepath.Join(directory, "subdirectory"), 0700))
+
+	return directory
+}
+
+// TestCheckBundle checks which bundle types and outputs are supported
+func TestCheckBundle(t *testing.T) {
+	assert.NoError(t, main.CheckBundle("", "duckdb"))
+	assert.NoError(t, main.CheckBundle("tar.gz", "file"))
+	assert.NoError(t, main.CheckBundle("zip", "S3"))
+	assert.NoError(t, main.CheckBundle("zip", "sftp"))
+
+	assert.EqualError(t, main.CheckBundle("rar", "file"), "Unknown bundle type: rar")
+	assert.EqualError(t, main.CheckBundle("zip", "duckdb"), "Bundle can not be used with output duckdb")
+}
+
+// TestWriteBundleTarGz checks that all files are stored into tar.gz bundle
+func TestWriteBundleTarGz(t *testing.T) {
+	directory := prepareBundleDirectory(t)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.WriteBundle(buffer, "tar.gz", directory))
+
+	decompressed, err := gzip.NewReader(buffer)
+	assert.NoError(t, err)
+
+	archive := tar.NewReader(decompressed)
+	files := map[string]string{}
+	var names []string
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		content, err := io.ReadAll(archive)
+		assert.NoError(t, err)
+		files[header.Name] = string(content)
+		names = append(names, header.Name)
+	}
+
+	assert.Equal(t, bundledFiles, files)
+
+	// files are sorted by name
+	assert.Equal(t, []string{"_logs.txt", "_metadata.csv", "_tables.csv", "report.csv"}, names)
+}
+
+// TestWriteBundleZip checks that all files are stored into zip bundle
+func TestWriteBundleZip(t *testing.T) {
+	directory := prepareBundleDirectory(t)
+
+	fileName := filepath.Join(t.TempDir(), "export.zip")
+	assert.NoError(t, main.StoreBundleIntoFile(fileName, "zip", directory, nil))
+
+	archive, err := zip.OpenReader(fileName)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, archive.Close())
+	}()
+
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+
+		files[file.Name] = string(content)
+	}
+
+	assert.Equal(t, bundledFiles, files)
+}
+
+// TestWriteBundleUnknownType checks that unknown bundle type is refused
+func TestWriteBundleUnknownType(t *testing.T) {
+	err := main.WriteBundle(new(bytes.Buffer), "rar", t.TempDir())
+	assert.Error(t, err)
+}
+
+// TestWriteBundleMissingDirectory checks that error is reported when
+// directory with exported files does not exist
+func TestWriteBundleMissingDirectory(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	err := main.WriteBundle(new(bytes.Buffer), "zip", missing)
+	assert.Error(t, err)
+}
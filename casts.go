@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains construction of SELECT statements with columns
+// cast or transformed by SQL expressions configured in [casts] section. It
+// allows to export columns with types that are not handled well on client
+// side (jsonb, bytea etc.) in their textual form.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/casts.html
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// messages
+const (
+	unknownCastColumn = "Cast configured for unknown column %s in table %s"
+)
+
+// readColumnNames method reads names of all columns of given table in the
+// order in which they are defined. No records are read.
+func (storage DBStorage) readColumnNames(ctx context.Context, tableName TableName) ([]string, error) {
+	sqlStatement := selectNothingFromTable(tableName)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		// error during reading columns is more important than error during close
+		_ = rows.Close()
+		return nil, err
+	}
+
+	return columns, rows.Close()
+}
+
+// castColumns function constructs list of selected columns where configured
+// columns are replaced by SQL expressions. Expressions keep the original
+// column names.
+func castColumns(tableName TableName, columns []string,
+	casts map[string]string) (string, error) {
+	known := make(map[string]bool, len(columns))
+	selected := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		known[column] = true
+
+		expression, found := casts[column]
+		if !found {
+			selected = append(selected, quoteIdentifier(column))
+			continue
+		}
+		selected = append(selected, expression+" AS "+quoteIdentifier(column))
+	}
+
+	// report the first unknown column in stable order
+	castedColumns := make([]string, 0, len(casts))
+	for column := range casts {
+		castedColumns = append(castedColumns, column)
+	}
+	sort.Strings(castedColumns)
+
+	for _, column := range castedColumns {
+		if !known[column] {
+			return "", fmt.Errorf(unknownCastColumn, column, tableName)
+		}
+	}
+
+	return strings.Join(selected, ", "), nil
+}
+
+// selectTableContent method constructs query to read all records from given
+// table (or their random sample). Columns with configured casts are
+// transformed by SQL expressions.
+func (storage DBStorage) selectTableContent(ctx context.Context, tableName TableName) (string, error) {
+	casts, _ := lookupTableConfig(storage.casts, tableName)
+	if len(casts) == 0 {
+		return selectAllFromTable(TableName(storage.sampledTable(tableName))), nil
+	}
+
+	columns, err := storage.readColumnNames(ctx, tableName)
+	if err != nil {
+		return "", err
+	}
+
+	selected, err := castColumns(tableName, columns, casts)
+	if err != nil {
+		return "", err
+	}
+
+	// it is not possible to use parameter for table name or a key
+	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G201
+	return fmt.Sprintf("SELECT %s FROM %s", selected, storage.sampledTable(tableName)), nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/casts_test.html
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// mustCreateSQLiteStorage helper function creates SQLite database with one
+// table and constructs storage connected to it
+func mustCreateSQLiteStorage(t *testing.T) *main.DBStorage {
+	connection, err := sql.Open("sqlite3", prepareSQLiteDatabase(t))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, connection.Close())
+	})
+
+	config := main.StorageConfiguration{Driver: "sqlite3"}
+	return main.NewFromConnection(connection, main.DBDriverSQLite3, &config)
+}
+
+// TestCastColumns checks the function castColumns
+func TestCastColumns(t *testing.T) {
+	columns := []string{"id", "report"}
+
+	selected, err := main.CastColumns("report", columns, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `"id", "report"`, selected)
+
+	selected, err = main.CastColumns("report", columns,
+		map[string]string{"report": "report::text"})
+	assert.NoError(t, err)
+	assert.Equal(t, `"id", report::text AS "report"`, selected)
+
+	_, err = main.CastColumns("report", columns,
+		map[string]string{"reprot": "reprot::text"})
+	assert.EqualError(t, err, "Cast configured for unknown column reprot in table report")
+}
+
+// TestReadTableWithCasts checks that casted columns are read as transformed
+// by SQL expressions
+func TestReadTableWithCasts(t *testing.T) {
+	storage := mustCreateSQLiteStorage(t)
+	main.SetCasts(storage, main.CastsConfiguration{
+		"report": {"report": "upper(report)"},
+	})
+
+	rows, err := storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "FIRST", rows[0]["report"])
+	assert.Equal(t, "SECOND", rows[1]["report"])
+	assert.Equal(t, int64(1), rows[0]["id"])
+
+	// column names are kept
+	columnTypes, err := storage.RetrieveColumnTypes(context.Background(), "report")
+	assert.NoError(t, err)
+	assert.Len(t, columnTypes, 2)
+	assert.Equal(t, "id", columnTypes[0].Name())
+	assert.Equal(t, "report", columnTypes[1].Name())
+}
+
+// TestReadTableWithoutCasts checks that tables without configured casts are
+// read as they are
+func TestReadTableWithoutCasts(t *testing.T) {
+	storage := mustCreateSQLiteStorage(t)
+	main.SetCasts(storage, main.CastsConfiguration{
+		"other_table": {"report": "upper(report)"},
+	})
+
+	rows, err := storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "first", rows[0]["report"])
+}
+
+// TestReadTableWithCastOfUnknownColumn checks that cast of column that does
+// not exist is reported
+func TestReadTableWithCastOfUnknownColumn(t *testing.T) {
+	storage := mustCreateSQLiteStorage(t)
+	main.SetCasts(storage, main.CastsConfiguration{
+		"report": {"unknown": "upper(unknown)"},
+	})
+
+	_, err := storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.Error(t, err)
+
+	_, err = storage.RetrieveColumnTypes(context.Background(), "report")
+	assert.Error(t, err)
+}
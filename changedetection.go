@@ -0,0 +1,262 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains detection of tables whose content has not been
+// changed since the previous export. Hash of content of each table exported
+// into S3 is stored into manifest object. When the hash computed by next run
+// is the same, the table is not uploaded again and the manifest refers to the
+// object stored by previous run.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/changedetection.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// name of object with hashes of all exported tables
+const manifestObject = "_manifest.json"
+
+// content type of manifest object
+const manifestContentType = "application/json"
+
+// S3 error code returned when object does not exist
+const noSuchKeyErrorCode = "NoSuchKey"
+
+// messages
+const (
+	tableNotChanged       = "Table content has not been changed, upload skipped"
+	readManifestFailed    = "Unable to read manifest of previous export"
+	storeManifestFailed   = "Store manifest into S3 failed"
+	contentHashMsg        = "Content hash"
+	objectNameMsg         = "Object name"
+	previousObjectMissing = "Object stored by previous export not found"
+)
+
+// ManifestEntry describes one table exported into S3
+type ManifestEntry struct {
+	Object string      `json:"object,omitempty"`
+	SHA256 string      `json:"sha256,omitempty"`
+	Rows   *int        `json:"rows,omitempty"`
+	Audit  *TableAudit `json:"audit,omitempty"`
+}
+
+// Manifest contains descriptions of all tables exported into S3 by one run.
+// Description of the run itself is filled in only for manifest stored at the
+// end of the run (see manifest.go).
+type Manifest struct {
+	RunID           string                      `json:"run_id,omitempty"`
+	Version         string                      `json:"exporter_version,omitempty"`
+	Started         *time.Time                  `json:"started,omitempty"`
+	Finished        *time.Time                  `json:"finished,omitempty"`
+	DurationSeconds float64                     `json:"duration_seconds,omitempty"`
+	Database        *ManifestDatabase           `json:"database,omitempty"`
+	Rows            int                         `json:"rows,omitempty"`
+	Tables          map[TableName]ManifestEntry `json:"tables"`
+	Objects         []Artifact                  `json:"objects,omitempty"`
+	ExitStatus      *RunExitStatus              `json:"exit_status,omitempty"`
+}
+
+// ChangeDetection contains manifest of previous export and manifest that is
+// being constructed by current export
+type ChangeDetection struct {
+	previous Manifest
+	current  Manifest
+}
+
+// NewManifest function constructs new empty manifest
+func NewManifest() Manifest {
+	return Manifest{
+		Tables: make(map[TableName]ManifestEntry),
+	}
+}
+
+// NewChangeDetection function constructs change detection that compares
+// tables with given manifest of previous export
+func NewChangeDetection(previous Manifest) *ChangeDetection {
+	if previous.Tables == nil {
+		previous = NewManifest()
+	}
+
+	return &ChangeDetection{
+		previous: previous,
+		current:  NewManifest(),
+	}
+}
+
+// contentHash function computes SHA-256 hash of given data
+func contentHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// Unchanged method checks if table with given hash has been stored into the
+// same object by previous export
+func (changes *ChangeDetection) Unchanged(tableName TableName, object, hash string) bool {
+	entry, found := changes.previous.Tables[tableName]
+	return found && entry.Object == object && entry.SHA256 == hash
+}
+
+// Record method records table stored into given object into current manifest
+func (changes *ChangeDetection) Record(tableName TableName, object, hash string) {
+	changes.current.Tables[tableName] = ManifestEntry{
+		Object: object,
+		SHA256: hash,
+	}
+}
+
+// RecordAudit method records audit of table that has been recorded into
+// current manifest already
+func (changes *ChangeDetection) RecordAudit(tableName TableName, tableAudit TableAudit) {
+	entry, found := changes.current.Tables[tableName]
+	if !found {
+		return
+	}
+
+	entry.Audit = &tableAudit
+	changes.current.Tables[tableName] = entry
+}
+
+// Current method returns manifest constructed by current export
+func (changes *ChangeDetection) Current() Manifest {
+	return changes.current
+}
+
+// readManifest function reads manifest from given reader
+func readManifest(reader io.Reader) (Manifest, error) {
+	manifest := NewManifest()
+
+	err := json.NewDecoder(reader).Decode(&manifest)
+	if err != nil {
+		return NewManifest(), err
+	}
+
+	// manifest without tables
+	if manifest.Tables == nil {
+		manifest.Tables = make(map[TableName]ManifestEntry)
+	}
+
+	return manifest, nil
+}
+
+// readManifestFromS3 function reads manifest stored by previous export. Empty
+// manifest is returned when the manifest object does not exist.
+func readManifestFromS3(ctx context.Context, session *S3Session,
+	bucketName, objectName string) (Manifest, error) {
+	object, err := session.client.GetObject(ctx, bucketName, objectName,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return NewManifest(), err
+	}
+
+	defer func() {
+		_ = object.Close()
+	}()
+
+	manifest, err := readManifest(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == noSuchKeyErrorCode {
+			return NewManifest(), nil
+		}
+		return NewManifest(), err
+	}
+
+	return manifest, nil
+}
+
+// storeManifestIntoS3 function stores manifest into given bucket under
+// selected object name. Manifest is never compressed, so it can be read by
+// next export regardless of selected codec. It is encrypted when encryption
+// is given (and extension of encrypted objects is added to its name).
+func storeManifestIntoS3(ctx context.Context, session *S3Session,
+	bucketName, objectName string, manifest Manifest, encryption *Encryption) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data, err = compressData(noCompression, encryption, data)
+	if err != nil {
+		return err
+	}
+	objectName += encryption.Extension()
+
+	return session.retryOperation(ctx, s3PutOperation, s3ArtifactLocation(bucketName, objectName),
+		func(ctx context.Context) error {
+			_, err := session.client.PutObject(ctx, bucketName, objectName,
+				bytes.NewReader(data), int64(len(data)),
+				minio.PutObjectOptions{ContentType: manifestContentType})
+			return err
+		})
+}
+
+// s3ObjectExists function checks if object with given name exists in given
+// bucket
+func s3ObjectExists(ctx context.Context, session *S3Session,
+	bucketName, objectName string) (bool, error) {
+	err := session.withTimeout(ctx, s3StatOperation, s3ArtifactLocation(bucketName, objectName),
+		func(ctx context.Context) error {
+			_, err := session.client.StatObject(ctx, bucketName, objectName,
+				minio.StatObjectOptions{})
+			return err
+		})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == noSuchKeyErrorCode {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// unchangedTable method checks if table with given content hash has been
+// stored by previous export and if the stored object still exists
+func (storage DBStorage) unchangedTable(ctx context.Context,
+	session *S3Session, bucketName string, tableName TableName,
+	objectName, hash string) (bool, error) {
+	if !storage.changes.Unchanged(tableName, objectName, hash) {
+		return false, nil
+	}
+
+	exists, err := s3ObjectExists(ctx, session, bucketName, objectName)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		storage.logger.Warn().Str(objectNameMsg, objectName).
+			Msg(previousObjectMissing)
+		return false, nil
+	}
+
+	storage.logger.Info().Str(contentHashMsg, hash).
+		Msg(tableNotChanged)
+	return true, nil
+}
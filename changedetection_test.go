@@ -0,0 +1,421 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/changedetection_test.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// SHA-256 hash of string "hello"
+const helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// fakeS3 is minimal S3 server that stores objects in memory
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+
+	// parts of multipart uploads in progress indexed by upload ID
+	uploads map[string]map[int][]byte
+
+	// sizes of all uploaded parts of multipart uploads
+	partSizes []int
+
+	// number of following uploads that fail with server error
+	failedPuts int
+}
+
+// ServeHTTP method handles PUT, GET, HEAD and DELETE requests for objects, listing
+// of objects in bucket and multipart uploads
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	uploadID := r.URL.Query().Get("uploadId")
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if s.failedPuts > 0 {
+			s.failedPuts--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// payload can be sent in aws-chunked encoding
+		if r.Header.Get("X-Amz-Decoded-Content-Length") != "" {
+			data = decodeAWSChunked(data)
+		}
+		// part of multipart upload
+		if uploadID != "" {
+			partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+			s.uploads[uploadID][partNumber] = data
+			s.partSizes = append(s.partSizes, len(data))
+			w.Header().Set("ETag", "\"part"+strconv.Itoa(partNumber)+"\"")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !s.conditionHolds(r) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = io.WriteString(w, "<Error><Code>PreconditionFailed</Code>"+
+				"<Message>precondition failed</Message></Error>")
+			return
+		}
+		s.objects[r.URL.Path] = data
+		w.Header().Set("ETag", objectETag(data))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		s.multipartUpload(w, r, uploadID)
+	case http.MethodDelete:
+		// multipart upload is aborted or object is removed
+		if uploadID != "" {
+			delete(s.uploads, uploadID)
+		} else {
+			delete(s.objects, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		// bucket location is queried by clients without configured region
+		if _, found := r.URL.Query()["location"]; found {
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w,
+				"<LocationConstraint>us-east-1</LocationConstraint>")
+			return
+		}
+		// objects in bucket are listed by ListObjectsV2 call
+		if r.URL.Query().Get("list-type") == "2" {
+			s.listObjects(w, r)
+			return
+		}
+		data, found := s.objects[r.URL.Path]
+		if !found {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w,
+					"<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			}
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", objectETag(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// conditionHolds method checks If-None-Match and If-Match conditions of
+// object write
+func (s *fakeS3) conditionHolds(r *http.Request) bool {
+	data, found := s.objects[r.URL.Path]
+
+	if r.Header.Get("If-None-Match") == "*" && found {
+		return false
+	}
+	if etag := r.Header.Get("If-Match"); etag != "" {
+		return found && etag == objectETag(data)
+	}
+	return true
+}
+
+// objectETag helper function returns ETag of object with given content
+func objectETag(data []byte) string {
+	hash := md5.Sum(data) // #nosec G401
+	return "\"" + hex.EncodeToString(hash[:]) + "\""
+}
+
+// multipartUpload method starts new multipart upload or completes upload
+// with given ID by joining all its parts into one object
+func (s *fakeS3) multipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	w.Header().Set("Content-Type", "application/xml")
+
+	if uploadID == "" {
+		uploadID = strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[uploadID] = make(map[int][]byte)
+		_, _ = io.WriteString(w, "<InitiateMultipartUploadResult><UploadId>"+
+			uploadID+"</UploadId></InitiateMultipartUploadResult>")
+		return
+	}
+
+	parts := s.uploads[uploadID]
+	numbers := make([]int, 0, len(parts))
+	for number := range parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	var data []byte
+	for _, number := range numbers {
+		data = append(data, parts[number]...)
+	}
+	s.objects[r.URL.Path] = data
+	delete(s.uploads, uploadID)
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	_, _ = io.WriteString(w, "<CompleteMultipartUploadResult><Bucket>"+bucket+
+		"</Bucket><Key>"+key+"</Key><ETag>\"etag\"</ETag>"+
+		"</CompleteMultipartUploadResult>")
+}
+
+// listObjects method returns all objects from bucket with given prefix in
+// ListBucketResult form
+func (s *fakeS3) listObjects(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.Trim(r.URL.Path, "/")
+	prefix := "/" + bucket + "/" + r.URL.Query().Get("prefix")
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result strings.Builder
+	result.WriteString("<ListBucketResult><Name>" + bucket + "</Name>")
+	result.WriteString("<KeyCount>" + strconv.Itoa(len(keys)) + "</KeyCount>")
+	result.WriteString("<IsTruncated>false</IsTruncated>")
+	for _, key := range keys {
+		result.WriteString("<Contents><Key>" +
+			strings.TrimPrefix(key, "/"+bucket+"/") + "</Key>")
+		result.WriteString("<Size>" + strconv.Itoa(len(s.objects[key])) + "</Size>")
+		result.WriteString("<LastModified>2024-01-01T00:00:00.000Z</LastModified>")
+		result.WriteString("<ETag>\"etag\"</ETag></Contents>")
+	}
+	result.WriteString("</ListBucketResult>")
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, result.String())
+}
+
+// decodeAWSChunked helper function decodes payload sent in aws-chunked
+// encoding: each chunk is prefixed by its size in hex, optionally followed by
+// signature or trailing checksum
+func decodeAWSChunked(data []byte) []byte {
+	var decoded []byte
+
+	for len(data) > 0 {
+		end := bytes.Index(data, []byte("\r\n"))
+		if end < 0 {
+			break
+		}
+
+		header := string(data[:end])
+		if i := strings.IndexByte(header, ';'); i >= 0 {
+			header = header[:i]
+		}
+
+		size, err := strconv.ParseInt(header, 16, 64)
+		if err != nil || size == 0 {
+			break
+		}
+
+		data = data[end+2:]
+		decoded = append(decoded, data[:size]...)
+		data = bytes.TrimPrefix(data[size:], []byte("\r\n"))
+	}
+
+	return decoded
+}
+
+// startFakeS3Server helper function starts fake S3 server and returns its
+// address in host:port form
+func startFakeS3Server(t *testing.T) (*fakeS3, string) {
+	storage := &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+
+	return storage, strings.TrimPrefix(server.URL, "http://")
+}
+
+// startFakeS3 helper function starts fake S3 server and constructs session
+// connected to it
+func startFakeS3(t *testing.T) (*fakeS3, *main.S3Session) {
+	return startFakeS3WithConfiguration(t, main.S3Configuration{})
+}
+
+// startFakeS3WithConfiguration helper function starts fake S3 server and
+// constructs session connected to it that uses given settings of S3
+// operations
+func startFakeS3WithConfiguration(t *testing.T,
+	configuration main.S3Configuration) (*fakeS3, *main.S3Session) {
+	storage, address := startFakeS3Server(t)
+
+	minioClient, err := minio.New(address, &minio.Options{Region: "us-east-1"})
+	assert.NoError(t, err)
+
+	return storage, main.NewS3Session(minioClient, configuration)
+}
+
+// TestContentHash checks the function contentHash
+func TestContentHash(t *testing.T) {
+	assert.Equal(t, helloHash, main.ContentHash([]byte("hello")))
+	assert.NotEqual(t, helloHash, main.ContentHash([]byte("hello!")))
+}
+
+// TestChangeDetection checks the methods Unchanged and Record
+func TestChangeDetection(t *testing.T) {
+	previous := main.NewManifest()
+	previous.Tables["report"] = main.ManifestEntry{
+		Object: "prefix/report.csv",
+		SHA256: helloHash,
+	}
+
+	changes := main.NewChangeDetection(previous)
+
+	assert.True(t, changes.Unchanged("report", "prefix/report.csv", helloHash))
+
+	// content has been changed
+	assert.False(t, changes.Unchanged("report", "prefix/report.csv", "other"))
+
+	// object name has been changed (different format or compression)
+	assert.False(t, changes.Unchanged("report", "prefix/report.csv.gz", helloHash))
+
+	// table has not been exported by previous run
+	assert.False(t, changes.Unchanged("rule_hit", "prefix/rule_hit.csv", helloHash))
+
+	// current manifest is empty until tables are recorded
+	assert.Empty(t, changes.Current().Tables)
+
+	changes.Record("report", "prefix/report.csv", helloHash)
+	assert.Equal(t, previous, changes.Current())
+}
+
+// TestChangeDetectionWithoutPreviousManifest checks that all tables are
+// detected as changed when previous manifest is empty
+func TestChangeDetectionWithoutPreviousManifest(t *testing.T) {
+	changes := main.NewChangeDetection(main.Manifest{})
+
+	assert.False(t, changes.Unchanged("report", "report.csv", helloHash))
+}
+
+// TestReadManifest checks the function readManifest
+func TestReadManifest(t *testing.T) {
+	manifest, err := main.ReadManifest(strings.NewReader(
+		`{"tables": {"report": {"object": "report.csv", "sha256": "abcd"}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, main.ManifestEntry{Object: "report.csv", SHA256: "abcd"},
+		manifest.Tables["report"])
+
+	// manifest without tables
+	manifest, err = main.ReadManifest(strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.NotNil(t, manifest.Tables)
+	assert.Empty(t, manifest.Tables)
+
+	// improper manifest
+	_, err = main.ReadManifest(strings.NewReader(`[`))
+	assert.Error(t, err)
+}
+
+// TestReadMissingManifestFromS3 checks that empty manifest is returned when
+// previous export has not stored any manifest
+func TestReadMissingManifestFromS3(t *testing.T) {
+	_, session := startFakeS3(t)
+
+	manifest, err := main.ReadManifestFromS3(context.Background(), session,
+		"bucket", "_manifest.json")
+	assert.NoError(t, err)
+	assert.Empty(t, manifest.Tables)
+}
+
+// TestStoreAndReadManifestFromS3 checks that stored manifest can be read by
+// next export
+func TestStoreAndReadManifestFromS3(t *testing.T) {
+	storage, session := startFakeS3(t)
+	ctx := context.Background()
+
+	manifest := main.NewManifest()
+	manifest.Tables["report"] = main.ManifestEntry{
+		Object: "prefix/report.csv",
+		SHA256: helloHash,
+	}
+
+	err := main.StoreManifestIntoS3(ctx, session, "bucket",
+		"prefix/_manifest.json", manifest, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, storage.objects, "/bucket/prefix/_manifest.json")
+
+	read, err := main.ReadManifestFromS3(ctx, session, "bucket",
+		"prefix/_manifest.json")
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, read)
+}
+
+// TestS3ObjectExists checks the function s3ObjectExists
+func TestS3ObjectExists(t *testing.T) {
+	storage, session := startFakeS3(t)
+	ctx := context.Background()
+
+	storage.objects["/bucket/report.csv"] = []byte("hello")
+
+	exists, err := main.S3ObjectExists(ctx, session, "bucket", "report.csv")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = main.S3ObjectExists(ctx, session, "bucket", "rule_hit.csv")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// TestChangeDetectionRecordAudit checks that audit of table is stored into
+// manifest entry
+func TestChangeDetectionRecordAudit(t *testing.T) {
+	changes := main.NewChangeDetection(main.NewManifest())
+
+	// table has not been recorded yet
+	changes.RecordAudit("report", main.TableAudit{Rows: 1})
+	assert.Empty(t, changes.Current().Tables)
+
+	changes.Record("report", "report.csv", helloHash)
+	changes.RecordAudit("report", main.TableAudit{Rows: 1})
+	assert.Equal(t, &main.TableAudit{Rows: 1}, changes.Current().Tables["report"].Audit)
+}
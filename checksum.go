@@ -0,0 +1,87 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains SHA-256 checksum sidecars. When checksums are
+// enabled, every exported file is accompanied by file with the same name and
+// .sha256 extension and every uploaded S3 object by object with .sha256
+// suffix. Sidecars use format of sha256sum tool, so integrity of transferred
+// data can be verified by `sha256sum -c`.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/checksum.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// checksumExtension is extension of sidecar files and objects with checksum
+const checksumExtension = ".sha256"
+
+// checksumContentType is content type of sidecar objects with checksum
+const checksumContentType = "text/plain"
+
+// checksumSidecar function returns content of sidecar with given checksum of
+// file or object with given name, format of sha256sum tool is used
+func checksumSidecar(sum []byte, name string) []byte {
+	return []byte(hex.EncodeToString(sum) + "  " + name + "\n")
+}
+
+// writeChecksumFile function writes sidecar file with given checksum of file
+// with given name. Sidecar is written next to the file.
+func writeChecksumFile(fileName string, sum []byte) error {
+	// sidecar needs to be readable the same way as exported file
+	// #nosec G306
+	return os.WriteFile(fileName+checksumExtension,
+		checksumSidecar(sum, filepath.Base(fileName)), 0o644)
+}
+
+// storeChecksumObject function stores sidecar object with given checksum of
+// object with given name. Sidecar is not compressed and it is not recorded as
+// artifact of the export.
+func storeChecksumObject(ctx context.Context, session *S3Session,
+	bucketName, objectName string, sum []byte) error {
+	data := checksumSidecar(sum, path.Base(objectName))
+	sidecarName := objectName + checksumExtension
+
+	options := minio.PutObjectOptions{
+		ContentType: checksumContentType,
+	}
+	return session.retryOperation(ctx, s3PutOperation, s3ArtifactLocation(bucketName, sidecarName),
+		func(ctx context.Context) error {
+			_, err := session.client.PutObject(ctx, bucketName, sidecarName,
+				bytes.NewReader(data), int64(len(data)), options)
+			return err
+		})
+}
+
+// dataChecksum function computes SHA-256 checksum of given data
+func dataChecksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/checksum_test.html
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// expectedSidecar function returns expected content of sidecar with checksum
+// of given data
+func expectedSidecar(data []byte, name string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + "  " + name + "\n"
+}
+
+// TestPutObjectChecksum checks that checksum of stored (compressed) object
+// is stored into sidecar object
+func TestPutObjectChecksum(t *testing.T) {
+	s3, session := startFakeS3(t)
+	main.SetSessionArtifacts(session, nil, true)
+	ctx := context.Background()
+
+	err := main.PutObject(ctx, session, "bucket", "prefix/report.csv",
+		"text/csv", []byte("id\n1\n"), "gzip", nil)
+	assert.NoError(t, err)
+
+	data, found := s3.objects["/bucket/prefix/report.csv.gz"]
+	assert.True(t, found)
+
+	sidecar, found := s3.objects["/bucket/prefix/report.csv.gz.sha256"]
+	assert.True(t, found)
+	assert.Equal(t, expectedSidecar(data, "report.csv.gz"), string(sidecar))
+}
+
+// TestPutObjectStreamChecksum checks that checksum of streamed object is
+// stored into sidecar object
+func TestPutObjectStreamChecksum(t *testing.T) {
+	s3, session := startFakeS3(t)
+	main.SetSessionArtifacts(session, nil, true)
+	ctx := context.Background()
+
+	err := main.PutObjectStream(ctx, session, "bucket", "table.csv",
+		"text/csv", "none", nil, func(output io.Writer) error {
+			_, err := io.WriteString(output, strings.Repeat("row\n", 100))
+			return err
+		})
+	assert.NoError(t, err)
+
+	sidecar, found := s3.objects["/bucket/table.csv.sha256"]
+	assert.True(t, found)
+	assert.Equal(t, expectedSidecar([]byte(strings.Repeat("row\n", 100)), "table.csv"),
+		string(sidecar))
+}
+
+// TestPutObjectNoChecksum checks that sidecar objects are not stored by
+// default
+func TestPutObjectNoChecksum(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	err := main.PutObject(context.Background(), session, "bucket",
+		"report.csv", "text/csv", []byte("id\n"), "none", nil)
+	assert.NoError(t, err)
+
+	assert.Len(t, s3.objects, 1)
+	_, found := s3.objects["/bucket/report.csv.sha256"]
+	assert.False(t, found)
+}
+
+// TestPerformDataExportSQLiteChecksums checks that every exported file is
+// accompanied by sidecar file with its checksum
+func TestPerformDataExportSQLiteChecksums(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		"INSERT INTO report VALUES (1), (2)")
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			Checksums: true,
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+
+	exported := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".sha256") {
+			continue
+		}
+		exported++
+
+		data, err := os.ReadFile(filepath.Join(directory, entry.Name()))
+		assert.NoError(t, err)
+		sidecar, err := os.ReadFile(filepath.Join(directory, entry.Name()+".sha256"))
+		assert.NoError(t, err)
+		assert.Equal(t, expectedSidecar(data, entry.Name()), string(sidecar))
+	}
+
+	assert.Positive(t, exported)
+	assert.Len(t, entries, 2*exported)
+	assert.FileExists(t, filepath.Join(directory, "report.csv.sha256"))
+}
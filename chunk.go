@@ -0,0 +1,276 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/chunk.html
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Limits for chunk size computed by tuner
+const (
+	minChunkSize = 100
+	maxChunkSize = 1000000
+)
+
+// messages
+const (
+	readingTableInChunks = "Reading table in chunks"
+	chunkSizeMsg         = "chunk size"
+)
+
+// approximate overhead (in bytes) of one column value stored in M map
+const columnOverhead = 32
+
+// ChunkSizeTuner adapts the number of rows read from database in one chunk
+// according to observed average row width, so memory used by one chunk stays
+// near the configured target instead of using one-size-fits-all constant.
+type ChunkSizeTuner struct {
+	targetBytes  int64
+	chunkSize    int
+	observedRows int64
+	observedSize int64
+}
+
+// NewChunkSizeTuner function constructs new tuner. The initial chunk size is
+// used until first rows are observed. When targetBytes is not positive, the
+// chunk size is never changed.
+func NewChunkSizeTuner(initialChunkSize int, targetBytes int64) *ChunkSizeTuner {
+	return &ChunkSizeTuner{
+		targetBytes: targetBytes,
+		chunkSize:   clampChunkSize(int64(initialChunkSize)),
+	}
+}
+
+// ChunkSize method returns the number of rows to be read in next chunk
+func (tuner *ChunkSizeTuner) ChunkSize() int {
+	return tuner.chunkSize
+}
+
+// AverageRowWidth method returns average width of all rows observed so far
+func (tuner *ChunkSizeTuner) AverageRowWidth() int64 {
+	if tuner.observedRows == 0 {
+		return 0
+	}
+	return tuner.observedSize / tuner.observedRows
+}
+
+// Observe method records the width of one row read from database
+func (tuner *ChunkSizeTuner) Observe(row M) {
+	tuner.ObserveWidth(1, rowWidth(row))
+}
+
+// ObserveWidth method records that given number of rows with given total
+// width has been read from database
+func (tuner *ChunkSizeTuner) ObserveWidth(rows int, width int64) {
+	if rows <= 0 {
+		return
+	}
+
+	tuner.observedRows += int64(rows)
+	tuner.observedSize += width
+}
+
+// Adjust method computes the size of next chunk from rows observed so far.
+// It needs to be called once per chunk. The chunk size is allowed to grow at
+// most twice per chunk to avoid big jumps caused by few unusually narrow rows.
+func (tuner *ChunkSizeTuner) Adjust() int {
+	// auto-tuning is disabled or there is nothing to compute from
+	average := tuner.AverageRowWidth()
+	if tuner.targetBytes <= 0 || average <= 0 {
+		return tuner.chunkSize
+	}
+
+	chunkSize := tuner.targetBytes / average
+	if limit := 2 * int64(tuner.chunkSize); chunkSize > limit {
+		chunkSize = limit
+	}
+
+	tuner.chunkSize = clampChunkSize(chunkSize)
+	return tuner.chunkSize
+}
+
+// clampChunkSize function makes sure the chunk size is in allowed range
+func clampChunkSize(chunkSize int64) int {
+	if chunkSize < minChunkSize {
+		return minChunkSize
+	}
+	if chunkSize > maxChunkSize {
+		return maxChunkSize
+	}
+	return int(chunkSize)
+}
+
+// rowWidth function computes approximate memory width of one row read from
+// database
+func rowWidth(row M) int64 {
+	var width int64
+
+	for column, value := range row {
+		width += int64(len(column)) + columnOverhead
+
+		switch v := value.(type) {
+		case string:
+			width += int64(len(v))
+		case []byte:
+			width += int64(len(v))
+		case bool:
+			width++
+		case ByteaValue:
+			width += int64(len(v))
+		case JSONValue, NumericValue, ArrayValue:
+			width += int64(len(fmt.Sprint(v)))
+		default:
+			// numbers, timestamps etc.
+			width += 8
+		}
+	}
+
+	return width
+}
+
+// keyAfter function checks if key a follows key b. Integer keys (that can be
+// read as numbers or as text) are compared numerically, other keys by their
+// textual form.
+func keyAfter(a, b interface{}) bool {
+	textA, textB := fmt.Sprint(a), fmt.Sprint(b)
+
+	intA, errA := strconv.ParseInt(textA, 10, 64)
+	intB, errB := strconv.ParseInt(textB, 10, 64)
+	if errA == nil && errB == nil {
+		return intA > intB
+	}
+
+	return textA > textB
+}
+
+// readTableInChunks method reads selected table in chunks ordered by given
+// key column. Each chunk is selected by key greater than the last key of
+// previous chunk (keyset pagination), so no result set is kept open for long
+// and chunk that failed because of transient error is read again. Rows are
+// passed one by one to given function.
+func (storage DBStorage) readTableInChunks(ctx context.Context, tableName TableName,
+	keyColumn string, limit int, process func(M) error) error {
+	key := quoteIdentifier(keyColumn)
+
+	selectContent, err := storage.selectTableContent(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	tuner := NewChunkSizeTuner(storage.config.ChunkSize, storage.config.ChunkTargetBytes)
+	storage.logger.Info().
+		Int(chunkSizeMsg, tuner.ChunkSize()).
+		Msg(readingTableInChunks)
+
+	// table uploaded with checkpoints is continued after the last key
+	// exported by interrupted run
+	upload := storage.upload
+	var lastKey interface{}
+	if startKey := upload.StartKey(); startKey != "" {
+		lastKey = startKey
+	}
+	remaining := limit
+
+	for {
+		chunkSize := tuner.ChunkSize()
+		if limit > 0 && remaining < chunkSize {
+			chunkSize = remaining
+		}
+
+		sqlStatement := selectContent
+		storage.applySelectiveExport(&sqlStatement, tableName)
+
+		var args []interface{}
+		if lastKey != nil {
+			sqlStatement += storage.whereOrAnd(tableName) + key + " > $1"
+			args = append(args, lastKey)
+		}
+		sqlStatement += fmt.Sprintf(" ORDER BY %s LIMIT %d", key, chunkSize)
+
+		// rows rejected by failed read of chunk are read again
+		rejectedBefore := storage.quarantine.Count(tableName)
+
+		// key of rejected row is tracked as read from database, the
+		// quarantined row contains masked value of key column
+		var rows []M
+		var rejectedKey interface{}
+		err := storage.withRetry(func() error {
+			storage.quarantine.Truncate(tableName, rejectedBefore)
+			rejectedKey = nil
+
+			var err error
+			rows, err = storage.queryRows(ctx, tableName, sqlStatement,
+				func(raw M) {
+					rejectedKey = raw[keyColumn]
+				}, args...)
+			return err
+		})
+		if err != nil {
+			// chunk has been retried already
+			return finalError{err}
+		}
+
+		// rows that can't be scanned are part of chunk too
+		_, rejects := storage.quarantine.Rejects(tableName)
+		rejected := rejects[rejectedBefore:]
+		read := len(rows) + len(rejected)
+
+		if len(rows) > 0 {
+			lastKey = rows[len(rows)-1][keyColumn]
+		}
+		if rejectedKey != nil && (lastKey == nil || keyAfter(rejectedKey, lastKey)) {
+			lastKey = rejectedKey
+		}
+
+		for _, row := range rows {
+			tuner.Observe(row)
+
+			err = process(row)
+			if err != nil {
+				return err
+			}
+		}
+
+		// rows of the whole chunk can be stored with checkpoint
+		err = upload.ChunkRead(lastKey)
+		if err != nil {
+			return err
+		}
+
+		if limit > 0 {
+			remaining -= read
+			if remaining <= 0 {
+				return nil
+			}
+		}
+
+		// the last chunk is not full
+		if read < chunkSize {
+			return nil
+		}
+
+		tuner.Adjust()
+	}
+}
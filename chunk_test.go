@@ -0,0 +1,249 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/chunk_test.html
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestChunkSizeTunerDisabled checks that chunk size is not changed when
+// auto-tuning is disabled
+func TestChunkSizeTunerDisabled(t *testing.T) {
+	tuner := main.NewChunkSizeTuner(5000, 0)
+	tuner.ObserveWidth(1000, 1000*1000)
+
+	assert.Equal(t, 5000, tuner.Adjust())
+	assert.Equal(t, 5000, tuner.ChunkSize())
+	assert.Equal(t, int64(1000), tuner.AverageRowWidth())
+}
+
+// TestChunkSizeTunerWideRows checks that chunk size is decreased for wide rows
+func TestChunkSizeTunerWideRows(t *testing.T) {
+	// 10 MB target
+	tuner := main.NewChunkSizeTuner(10000, 10*1000*1000)
+
+	// rows with 50 kB in average
+	tuner.ObserveWidth(100, 100*50000)
+	assert.Equal(t, 200, tuner.Adjust())
+	assert.Equal(t, 200, tuner.ChunkSize())
+}
+
+// TestChunkSizeTunerNarrowRows checks that chunk size grows gradually for
+// narrow rows
+func TestChunkSizeTunerNarrowRows(t *testing.T) {
+	// 10 MB target
+	tuner := main.NewChunkSizeTuner(1000, 10*1000*1000)
+
+	// rows with 100 bytes in average
+	tuner.ObserveWidth(1000, 1000*100)
+	assert.Equal(t, 2000, tuner.Adjust())
+
+	tuner.ObserveWidth(2000, 2000*100)
+	assert.Equal(t, 4000, tuner.Adjust())
+
+	// many chunks later the target is reached
+	for i := 0; i < 10; i++ {
+		tuner.ObserveWidth(tuner.ChunkSize(), int64(tuner.ChunkSize())*100)
+		tuner.Adjust()
+	}
+	assert.Equal(t, 100000, tuner.ChunkSize())
+}
+
+// TestChunkSizeTunerLimits checks that chunk size stays in allowed range
+func TestChunkSizeTunerLimits(t *testing.T) {
+	tuner := main.NewChunkSizeTuner(1, 1000)
+	assert.Equal(t, 100, tuner.ChunkSize())
+
+	// extremely wide rows
+	tuner.ObserveWidth(1, 1000*1000*1000)
+	assert.Equal(t, 100, tuner.Adjust())
+
+	tuner = main.NewChunkSizeTuner(10000000, 1000)
+	assert.Equal(t, 1000000, tuner.ChunkSize())
+}
+
+// TestChunkSizeTunerObserveRow checks that row width is computed from row
+// content
+func TestChunkSizeTunerObserveRow(t *testing.T) {
+	tuner := main.NewChunkSizeTuner(1000, 1000*1000)
+
+	narrow := main.M{"id": int64(1)}
+	wide := main.M{"id": int64(1), "report": strings.Repeat("x", 10000)}
+
+	tuner.Observe(narrow)
+	tuner.Observe(narrow)
+	narrowWidth := tuner.AverageRowWidth()
+	assert.Greater(t, narrowWidth, int64(0))
+
+	tuner = main.NewChunkSizeTuner(1000, 1000*1000)
+	tuner.Observe(wide)
+	assert.Greater(t, tuner.AverageRowWidth(), narrowWidth+10000-1)
+
+	// no rows observed
+	tuner.ObserveWidth(0, 1000)
+	assert.Greater(t, tuner.AverageRowWidth(), int64(10000))
+}
+
+// chunkedConfig returns storage configuration with given chunk size
+func chunkedConfig(chunkSize int) *main.StorageConfiguration {
+	config := testConfig
+	config.ChunkSize = chunkSize
+	return &config
+}
+
+// sequence helper function returns integers from first to last
+func sequence(first, last int) []int {
+	ids := make([]int, 0, last-first+1)
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// TestReadTableInChunks checks that table is read in chunks selected by the
+// last key of previous chunk
+func TestReadTableInChunks(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name ORDER BY "id" LIMIT 100$`).
+		WillReturnRows(rangeRows(mock, sequence(1, 100)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 100$`).
+		WithArgs(100).WillReturnRows(rangeRows(mock, 101, 102))
+	mock.ExpectClose()
+
+	// the smallest chunk size is used
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(1))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, 102, strings.Count(output, "\n"))
+	assert.True(t, strings.HasSuffix(output, "100,row\n101,row\n102,row\n"))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInChunksWithLimit checks that the last chunk is reduced to
+// the number of remaining rows
+func TestReadTableInChunksWithLimit(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name ORDER BY "id" LIMIT 100$`).
+		WillReturnRows(rangeRows(mock, sequence(1, 100)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 20$`).
+		WithArgs(100).WillReturnRows(rangeRows(mock, sequence(101, 120)...))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+
+	output, err := writeTableContent(t, storage, 120)
+	assert.NoError(t, err)
+	assert.Equal(t, 120, strings.Count(output, "\n"))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInChunksNoSuitableKey checks that table without suitable
+// primary key is read by one query
+func TestReadTableInChunksNoSuitableKey(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("name", "text")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+	mock.ExpectQuery(`SELECT \* FROM table_name$`).WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInChunksRejectedMaskedKey checks that the next chunk starts
+// after the key of rejected row as read from database when the key column
+// is masked
+func TestReadTableInChunksRejectedMaskedKey(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	// the last row of the first chunk can't be scanned, so it is
+	// quarantined
+	chunkRows := func(ids ...int) *sqlmock.Rows {
+		rows := mock.NewRowsWithColumnDefinition(
+			sqlmock.NewColumn("id").OfType("INT4", int64(0)),
+			sqlmock.NewColumn("text").OfType("VARCHAR", ""),
+			sqlmock.NewColumn("count").OfType("INT4", int64(0)))
+		for _, id := range ids {
+			var count driver.Value = id
+			if id == 100 {
+				count = "bad"
+			}
+			rows.AddRow(id, "row", count)
+		}
+		return rows
+	}
+	mock.ExpectQuery(`SELECT \* FROM table_name ORDER BY "id" LIMIT 100$`).
+		WillReturnRows(chunkRows(sequence(1, 100)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 100$`).
+		WithArgs(int64(100)).WillReturnRows(chunkRows(101, 102))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+	quarantine := main.NewQuarantine()
+	main.SetQuarantine(storage, quarantine)
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"table_name": {"id": "hash"},
+	})
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, 101, strings.Count(output, "\n"))
+
+	// only masked key is quarantined
+	_, rejects := quarantine.Rejects("table_name")
+	assert.Len(t, rejects, 1)
+	assert.NotEqual(t, int64(100), rejects[0].Row["id"])
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
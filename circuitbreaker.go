@@ -0,0 +1,263 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains retry budget and circuit breaker for database
+// errors. Reads that failed because of transient database errors (timeouts,
+// connection resets etc.) are retried while the retry budget of the whole
+// run is not exhausted. After given number of consecutive database errors
+// the circuit breaker opens and the run is aborted immediately with clear
+// diagnosis instead of trying all remaining tables.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/circuitbreaker.html
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// delay between retries of failed database reads, it is multiplied by
+// number of the attempt
+const dbRetryDelay = 2 * time.Second
+
+// messages
+const (
+	circuitBreakerOpen   = "circuit breaker open after %d consecutive database errors, database seems to be unavailable: %v"
+	retryingDatabaseRead = "Transient database error, retrying"
+	retryBudgetExhausted = "Retry budget exhausted"
+	databaseUnavailable  = "Database seems to be unavailable, aborting export"
+	retryAttemptMsg      = "attempt"
+	remainingRetriesMsg  = "remaining retries"
+)
+
+// CircuitOpenError is returned when circuit breaker opens. It contains the
+// last database error.
+type CircuitOpenError struct {
+	Consecutive int
+	Err         error
+}
+
+// Error method returns diagnosis in human readable form
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf(circuitBreakerOpen, e.Consecutive, e.Err)
+}
+
+// Unwrap method returns the last database error
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Err
+}
+
+// PostgreSQL error classes of transient errors: connection exception,
+// transaction rollback, insufficient resources and operator intervention
+// (including statement timeout and administrator shutdown)
+var transientErrorClasses = map[pq.ErrorClass]bool{
+	"08": true,
+	"40": true,
+	"53": true,
+	"57": true,
+}
+
+// finalError marks error of database read that must not be retried, for
+// example because part of the data read has already been exported
+type finalError struct {
+	err error
+}
+
+// Error method returns description of the original error
+func (e finalError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap method returns the original error
+func (e finalError) Unwrap() error {
+	return e.err
+}
+
+// isTransientDBError function checks if given error is caused by database
+// or network problem that may disappear when the operation is retried
+func isTransientDBError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var final finalError
+	if errors.As(err, &final) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientErrorClasses[pqErr.Code.Class()]
+	}
+
+	return false
+}
+
+// CircuitBreaker tracks database errors of one export run. It limits total
+// number of retries (retry budget) and opens after given number of
+// consecutive errors.
+//
+// All methods can be called on nil pointer - in this case nothing is retried
+// and breaker never opens. Methods are safe to be called from several
+// goroutines.
+type CircuitBreaker struct {
+	mutex       sync.Mutex
+	threshold   int
+	budget      int
+	delay       time.Duration
+	consecutive int
+}
+
+// NewCircuitBreaker function constructs circuit breaker that opens after
+// threshold consecutive errors and allows budget retries in total. Nil is
+// returned when neither retries nor breaker are enabled.
+func NewCircuitBreaker(threshold, budget int, delay time.Duration) *CircuitBreaker {
+	if threshold <= 0 && budget <= 0 {
+		return nil
+	}
+
+	return &CircuitBreaker{
+		threshold: threshold,
+		budget:    budget,
+		delay:     delay,
+	}
+}
+
+// Success method records successful operation, so the counter of
+// consecutive errors is reset
+func (breaker *CircuitBreaker) Success() {
+	if breaker == nil {
+		return
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.consecutive = 0
+}
+
+// Failure method records failed operation. Error with diagnosis is returned
+// when the breaker opens.
+func (breaker *CircuitBreaker) Failure(err error) error {
+	if breaker == nil {
+		return nil
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.consecutive++
+	if breaker.threshold > 0 && breaker.consecutive >= breaker.threshold {
+		return &CircuitOpenError{
+			Consecutive: breaker.consecutive,
+			Err:         err,
+		}
+	}
+	return nil
+}
+
+// Retry method consumes one retry from the budget. False is returned when
+// the budget is exhausted.
+func (breaker *CircuitBreaker) Retry() bool {
+	if breaker == nil {
+		return false
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.budget <= 0 {
+		return false
+	}
+	breaker.budget--
+	return true
+}
+
+// Remaining method returns number of retries that can still be performed
+func (breaker *CircuitBreaker) Remaining() int {
+	if breaker == nil {
+		return 0
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	return breaker.budget
+}
+
+// withRetry method performs given database read. Read that failed because of
+// transient error is retried while the retry budget is not exhausted and the
+// circuit breaker is not open.
+func (storage DBStorage) withRetry(read func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := read()
+		if err == nil {
+			storage.breaker.Success()
+			return nil
+		}
+
+		// other errors are not solved by retrying
+		if !isTransientDBError(err) {
+			return err
+		}
+
+		openErr := storage.breaker.Failure(err)
+		if openErr != nil {
+			storage.logger.Error().Err(openErr).Msg(databaseUnavailable)
+			return openErr
+		}
+
+		if !storage.breaker.Retry() {
+			if storage.breaker != nil {
+				storage.logger.Error().Err(err).Msg(retryBudgetExhausted)
+			}
+			return err
+		}
+
+		storage.logger.Warn().Err(err).
+			Int(retryAttemptMsg, attempt).
+			Int(remainingRetriesMsg, storage.breaker.Remaining()).
+			Msg(retryingDatabaseRead)
+		time.Sleep(time.Duration(attempt) * storage.breaker.delay)
+	}
+}
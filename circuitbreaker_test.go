@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/circuitbreaker_test.html
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// errors returned by mocked database
+var (
+	statementTimeout = &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}
+	undefinedTable   = &pq.Error{Code: "42P01", Message: "relation does not exist"}
+)
+
+// TestIsTransientDBError checks the function isTransientDBError
+func TestIsTransientDBError(t *testing.T) {
+	assert.True(t, main.IsTransientDBError(statementTimeout))
+	assert.True(t, main.IsTransientDBError(&pq.Error{Code: "08006"}))
+	assert.True(t, main.IsTransientDBError(driver.ErrBadConn))
+	assert.True(t, main.IsTransientDBError(fmt.Errorf("read: %w", io.ErrUnexpectedEOF)))
+
+	assert.False(t, main.IsTransientDBError(nil))
+	assert.False(t, main.IsTransientDBError(undefinedTable))
+	assert.False(t, main.IsTransientDBError(errors.New("other error")))
+}
+
+// TestCircuitBreaker checks that breaker opens after given number of
+// consecutive errors and that retries are limited by budget
+func TestCircuitBreaker(t *testing.T) {
+	breaker := main.NewCircuitBreaker(3, 2, 0)
+
+	assert.NoError(t, breaker.Failure(statementTimeout))
+	assert.NoError(t, breaker.Failure(statementTimeout))
+
+	// successful operation resets the counter
+	breaker.Success()
+	assert.NoError(t, breaker.Failure(statementTimeout))
+	assert.NoError(t, breaker.Failure(statementTimeout))
+
+	err := breaker.Failure(statementTimeout)
+	var openErr *main.CircuitOpenError
+	assert.ErrorAs(t, err, &openErr)
+	assert.Equal(t, 3, openErr.Consecutive)
+	assert.ErrorIs(t, err, statementTimeout)
+
+	// retry budget
+	assert.True(t, breaker.Retry())
+	assert.True(t, breaker.Retry())
+	assert.False(t, breaker.Retry())
+	assert.Equal(t, 0, breaker.Remaining())
+}
+
+// TestDisabledCircuitBreaker checks that nil breaker never opens and does
+// not allow any retry
+func TestDisabledCircuitBreaker(t *testing.T) {
+	breaker := main.NewCircuitBreaker(0, 0, 0)
+	assert.Nil(t, breaker)
+
+	breaker.Success()
+	assert.NoError(t, breaker.Failure(statementTimeout))
+	assert.False(t, breaker.Retry())
+	assert.Equal(t, 0, breaker.Remaining())
+}
+
+// TestWriteTableContentRetry checks that read failed because of transient
+// error is retried
+func TestWriteTableContentRetry(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(readTableQuery).WillReturnError(statementTimeout)
+	mock.ExpectQuery(readTableQuery).WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetCircuitBreaker(storage, main.NewCircuitBreaker(3, 1, 0))
+
+	content, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n", content)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentRetryBudgetExhausted checks that error is returned
+// when no retry is left
+func TestWriteTableContentRetryBudgetExhausted(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(readTableQuery).WillReturnError(statementTimeout)
+	mock.ExpectQuery(readTableQuery).WillReturnError(statementTimeout)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetCircuitBreaker(storage, main.NewCircuitBreaker(0, 1, 0))
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	assert.ErrorIs(t, err, statementTimeout)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentCircuitOpen checks that export is aborted after given
+// number of consecutive errors even when retry budget is not exhausted
+func TestWriteTableContentCircuitOpen(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(readTableQuery).WillReturnError(statementTimeout)
+	mock.ExpectQuery(readTableQuery).WillReturnError(statementTimeout)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetCircuitBreaker(storage, main.NewCircuitBreaker(2, 10, 0))
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	var openErr *main.CircuitOpenError
+	assert.ErrorAs(t, err, &openErr)
+	assert.Contains(t, err.Error(), "circuit breaker open after 2 consecutive database errors")
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentPermanentError checks that errors not caused by
+// database availability are not retried
+func TestWriteTableContentPermanentError(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(readTableQuery).WillReturnError(undefinedTable)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetCircuitBreaker(storage, main.NewCircuitBreaker(2, 10, 0))
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	assert.ErrorIs(t, err, undefinedTable)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentNoRetryAfterRowsWritten checks that table is not read
+// again when part of its rows have already been written
+func TestWriteTableContentNoRetryAfterRowsWritten(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	rows := rangeRows(mock, 1, 2).RowError(1, statementTimeout)
+	mock.ExpectQuery(readTableQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetCircuitBreaker(storage, main.NewCircuitBreaker(3, 10, 0))
+
+	content, err := writeTableContent(t, storage, NoLimits)
+	assert.ErrorIs(t, err, statementTimeout)
+	assert.Equal(t, "1,row\n", content)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,232 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains profiling of exported columns. Columns that are
+// NULL in all exported rows or that contain the same value in all exported
+// rows are flagged in _column_flags.csv report, so dead columns can be found
+// and dropped from database.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/columnflags.html
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// columnFlagsFile is name of file or object with flagged columns
+const columnFlagsFile = "_column_flags.csv"
+
+// flags of columns
+const (
+	allNullFlag  = "all-null"
+	constantFlag = "constant"
+)
+
+// messages
+const (
+	storeColumnFlagsFailed = "Store column flags failed"
+)
+
+// ColumnFlag represents column flagged by profiling of exported rows
+type ColumnFlag struct {
+	Table  TableName
+	Column string
+	Rows   int
+	Flag   string
+	Value  string
+}
+
+// ColumnProfile contains flagged columns of all exported tables. All methods
+// can be called on nil pointer - in this case they do nothing. Methods are
+// safe to be called from several goroutines.
+type ColumnProfile struct {
+	mutex sync.Mutex
+	flags map[TableName][]ColumnFlag
+}
+
+// NewColumnProfile function constructs profile of exported columns, nil is
+// returned when columns are not profiled
+func NewColumnProfile(enabled bool) *ColumnProfile {
+	if !enabled {
+		return nil
+	}
+	return &ColumnProfile{flags: make(map[TableName][]ColumnFlag)}
+}
+
+// columnStatistics contains statistics of one column computed from exported
+// rows. Value of the first row is compared with values of all other rows.
+type columnStatistics struct {
+	nulls     int
+	first     string
+	firstNull bool
+	distinct  bool
+}
+
+// tableProfiler computes statistics of columns of one table while its rows
+// are being exported, so the rows don't need to be kept in memory. Nil
+// profiler is used for tables that are not profiled.
+type tableProfiler struct {
+	colNames []string
+	columns  []columnStatistics
+	rows     int
+}
+
+// newTableProfiler method constructs profiler of columns of given table
+func (profile *ColumnProfile) newTableProfiler(colNames []string) *tableProfiler {
+	if profile == nil {
+		return nil
+	}
+	return &tableProfiler{
+		colNames: colNames,
+		columns:  make([]columnStatistics, len(colNames)),
+	}
+}
+
+// Add method adds exported row into statistics of columns
+func (profiler *tableProfiler) Add(row M) {
+	if profiler == nil {
+		return
+	}
+
+	for i, colName := range profiler.colNames {
+		value := row[colName]
+		statistics := &profiler.columns[i]
+
+		if value == nil {
+			statistics.nulls++
+		}
+
+		if profiler.rows == 0 {
+			statistics.first = csvValue(value)
+			statistics.firstNull = value == nil
+			continue
+		}
+
+		// NULL differs from any value, including empty string
+		if (value == nil) != statistics.firstNull ||
+			csvValue(value) != statistics.first {
+			statistics.distinct = true
+		}
+	}
+
+	profiler.rows++
+}
+
+// Reset method forgets all rows added so far, it is used when table is read
+// again
+func (profiler *tableProfiler) Reset() {
+	if profiler == nil {
+		return
+	}
+
+	profiler.rows = 0
+	profiler.columns = make([]columnStatistics, len(profiler.colNames))
+}
+
+// Flags method returns flagged columns, empty table does not have flagged
+// columns
+func (profiler *tableProfiler) Flags(tableName TableName) []ColumnFlag {
+	if profiler == nil || profiler.rows == 0 {
+		return nil
+	}
+
+	var flags []ColumnFlag
+	for i, colName := range profiler.colNames {
+		statistics := profiler.columns[i]
+
+		switch {
+		case statistics.nulls == profiler.rows:
+			flags = append(flags, ColumnFlag{
+				Table: tableName, Column: colName, Rows: profiler.rows,
+				Flag: allNullFlag,
+			})
+		case !statistics.distinct:
+			flags = append(flags, ColumnFlag{
+				Table: tableName, Column: colName, Rows: profiler.rows,
+				Flag: constantFlag, Value: statistics.first,
+			})
+		}
+	}
+	return flags
+}
+
+// Record method records flagged columns of given table computed by profiler
+func (profile *ColumnProfile) Record(tableName TableName, profiler *tableProfiler) {
+	if profile == nil {
+		return
+	}
+
+	profile.mutex.Lock()
+	defer profile.mutex.Unlock()
+
+	profile.flags[tableName] = profiler.Flags(tableName)
+}
+
+// Flags method returns flagged columns of all tables ordered by table name,
+// columns are in the order they are defined in table
+func (profile *ColumnProfile) Flags() []ColumnFlag {
+	if profile == nil {
+		return nil
+	}
+
+	profile.mutex.Lock()
+	defer profile.mutex.Unlock()
+
+	tableNames := make([]TableName, 0, len(profile.flags))
+	for tableName := range profile.flags {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Slice(tableNames, func(i, j int) bool {
+		return tableNames[i] < tableNames[j]
+	})
+
+	var flags []ColumnFlag
+	for _, tableName := range tableNames {
+		flags = append(flags, profile.flags[tableName]...)
+	}
+	return flags
+}
+
+// columnFlagsIntoCSV function writes flagged columns into CSV
+func columnFlagsIntoCSV(flags []ColumnFlag) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	writer, release := newCSVWriter(buffer)
+	defer release()
+
+	err := writer.Write([]string{"Table", "Column", "Rows", "Flag", "Value"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, flag := range flags {
+		err := writer.Write([]string{string(flag.Table), flag.Column,
+			strconv.Itoa(flag.Rows), flag.Flag, flag.Value})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
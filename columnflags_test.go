@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/columnflags_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestColumnProfileFlags checks that all-NULL and constant columns are
+// flagged
+func TestColumnProfileFlags(t *testing.T) {
+	profile := main.NewColumnProfile(true)
+
+	main.ProfileTable(profile, "rule_hit", []string{"id", "note", "state", "mixed", "empty"}, []main.M{
+		{"id": int64(1), "note": nil, "state": "ok", "mixed": nil, "empty": ""},
+		{"id": int64(2), "note": nil, "state": "ok", "mixed": "", "empty": ""},
+	})
+	main.ProfileTable(profile, "report", []string{"id"}, []main.M{
+		{"id": int64(1)},
+	})
+	// columns of empty table are not flagged
+	main.ProfileTable(profile, "advisor_ratings", []string{"id"}, nil)
+
+	assert.Equal(t, []main.ColumnFlag{
+		{Table: "report", Column: "id", Rows: 1, Flag: "constant", Value: "1"},
+		{Table: "rule_hit", Column: "note", Rows: 2, Flag: "all-null"},
+		{Table: "rule_hit", Column: "state", Rows: 2, Flag: "constant", Value: "ok"},
+		{Table: "rule_hit", Column: "empty", Rows: 2, Flag: "constant", Value: ""},
+	}, profile.Flags())
+}
+
+// TestColumnProfileNil checks that nil profile can be used
+func TestColumnProfileNil(t *testing.T) {
+	profile := main.NewColumnProfile(false)
+	assert.Nil(t, profile)
+
+	main.ProfileTable(profile, "report", []string{"id"}, []main.M{{"id": nil}})
+	assert.Nil(t, profile.Flags())
+}
+
+// TestColumnFlagsIntoCSV checks format of column flags report
+func TestColumnFlagsIntoCSV(t *testing.T) {
+	data, err := main.ColumnFlagsIntoCSV([]main.ColumnFlag{
+		{Table: "report", Column: "note", Rows: 3, Flag: "all-null"},
+		{Table: "report", Column: "state", Rows: 3, Flag: "constant", Value: "a,b"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Table,Column,Rows,Flag,Value\n"+
+		"report,note,3,all-null,\n"+
+		"report,state,3,constant,\"a,b\"\n", string(data))
+}
+
+// TestPerformDataExportSQLiteColumnFlags checks that column flags report is
+// written into output directory
+func TestPerformDataExportSQLiteColumnFlags(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER PRIMARY KEY,
+		note VARCHAR, state VARCHAR)`,
+		`INSERT INTO report VALUES
+		(1, NULL, 'ok'), (2, NULL, 'ok'), (3, NULL, 'ok')`)
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			ColumnFlags: true,
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	data, err := os.ReadFile(filepath.Join(directory, "_column_flags.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Table,Column,Rows,Flag,Value\n"+
+		"report,note,3,all-null,\n"+
+		"report,state,3,constant,ok\n", string(data))
+}
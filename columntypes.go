@@ -0,0 +1,408 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains types used to scan and render values of
+// PostgreSQL columns that can not be represented by plain strings and
+// numbers without loss of information: JSON documents, arbitrary precision
+// numbers, byte arrays, arrays and date/time values.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/columntypes.html
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layouts used to render date and time values
+const (
+	dateLayout   = "2006-01-02"
+	timeLayout   = "15:04:05.999999"
+	timeTZLayout = "15:04:05.999999Z07:00"
+)
+
+// messages
+const (
+	malformedArrayLiteral = "malformed array literal"
+	unsupportedByteaValue = "value of type %T can not be stored into BYTEA column"
+)
+
+// JSONValue represents content of JSON or JSONB column. Text formats contain
+// the document as is, JSON based formats embed it as nested JSON value.
+type JSONValue string
+
+// String method returns the JSON document
+func (v JSONValue) String() string {
+	return string(v)
+}
+
+// MarshalJSON method embeds the JSON document into JSON output. Document that
+// is not valid (it can happen for NULL values) is marshalled as string.
+func (v JSONValue) MarshalJSON() ([]byte, error) {
+	var compacted bytes.Buffer
+
+	// documents stored in JSON columns can span several lines
+	if err := json.Compact(&compacted, []byte(v)); err != nil {
+		return json.Marshal(string(v))
+	}
+	return compacted.Bytes(), nil
+}
+
+// NumericValue represents content of NUMERIC column. The value is kept in its
+// textual form, so no precision is lost by conversion into floating point.
+type NumericValue string
+
+// String method returns the number in textual form
+func (v NumericValue) String() string {
+	return string(v)
+}
+
+// MarshalJSON method writes the number as JSON number. Special values (NaN,
+// Infinity) that can not be expressed as JSON numbers are written as strings.
+func (v NumericValue) MarshalJSON() ([]byte, error) {
+	if isNumberLiteral(string(v)) {
+		return []byte(v), nil
+	}
+	return json.Marshal(string(v))
+}
+
+// isNumberLiteral function checks if given text is valid JSON number
+func isNumberLiteral(text string) bool {
+	if text == "" || !(text[0] == '-' || (text[0] >= '0' && text[0] <= '9')) {
+		return false
+	}
+	return json.Valid([]byte(text))
+}
+
+// ByteaValue represents content of BYTEA column. Text formats contain the
+// value in PostgreSQL hex format (\x followed by hex digits), binary formats
+// store the bytes directly. NULL is represented by nil slice.
+type ByteaValue []byte
+
+// String method returns the value in PostgreSQL hex format
+func (v ByteaValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return `\x` + hex.EncodeToString(v)
+}
+
+// MarshalJSON method writes the value as string in PostgreSQL hex format
+func (v ByteaValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+// ArrayValue represents content of array column. Text formats contain the
+// array literal as returned by database, JSON based formats contain JSON
+// array with elements typed according to the type of array elements.
+type ArrayValue struct {
+	Literal     string
+	ElementType string
+}
+
+// String method returns the array literal
+func (v ArrayValue) String() string {
+	return v.Literal
+}
+
+// Value method implements driver.Valuer interface, the array is stored into
+// other databases as its literal
+func (v ArrayValue) Value() (driver.Value, error) {
+	return v.Literal, nil
+}
+
+// MarshalJSON method writes the array as JSON array. Literal that can not be
+// parsed is written as string.
+func (v ArrayValue) MarshalJSON() ([]byte, error) {
+	if v.Literal == "" {
+		return json.Marshal(v.Literal)
+	}
+
+	elements, err := parseArrayLiteral(v.Literal, v.ElementType)
+	if err != nil {
+		return json.Marshal(v.Literal)
+	}
+	return json.Marshal(elements)
+}
+
+// parseArrayLiteral function parses PostgreSQL array literal, for example
+// {1,2,NULL} or {{"a b",c},{d,e}}, into (possibly nested) slice of values
+func parseArrayLiteral(literal, elementType string) ([]interface{}, error) {
+	// arrays with non-default bounds are prefixed by dimensions: [0:1]={1,2}
+	if strings.HasPrefix(literal, "[") {
+		index := strings.Index(literal, "=")
+		if index < 0 {
+			return nil, errors.New(malformedArrayLiteral)
+		}
+		literal = literal[index+1:]
+	}
+
+	parser := arrayParser{literal: literal, elementType: elementType}
+	elements, err := parser.parseArray()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.position != len(parser.literal) {
+		return nil, errors.New(malformedArrayLiteral)
+	}
+	return elements, nil
+}
+
+// arrayParser is a simple recursive descent parser of array literals
+type arrayParser struct {
+	literal     string
+	elementType string
+	position    int
+}
+
+// next method returns next character of the literal, zero is returned at
+// the end of literal
+func (p *arrayParser) next() byte {
+	if p.position >= len(p.literal) {
+		return 0
+	}
+	return p.literal[p.position]
+}
+
+// parseArray method parses one array enclosed in curly braces
+func (p *arrayParser) parseArray() ([]interface{}, error) {
+	if p.next() != '{' {
+		return nil, errors.New(malformedArrayLiteral)
+	}
+	p.position++
+
+	elements := []interface{}{}
+
+	// empty array
+	if p.next() == '}' {
+		p.position++
+		return elements, nil
+	}
+
+	for {
+		element, err := p.parseElement()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+
+		switch p.next() {
+		case ',':
+			p.position++
+		case '}':
+			p.position++
+			return elements, nil
+		default:
+			return nil, errors.New(malformedArrayLiteral)
+		}
+	}
+}
+
+// parseElement method parses one element of array: nested array, quoted
+// string or unquoted value
+func (p *arrayParser) parseElement() (interface{}, error) {
+	switch p.next() {
+	case '{':
+		return p.parseArray()
+	case '"':
+		return p.parseQuoted()
+	default:
+		start := p.position
+		for p.next() != ',' && p.next() != '}' {
+			if p.next() == 0 {
+				return nil, errors.New(malformedArrayLiteral)
+			}
+			p.position++
+		}
+		return arrayElementValue(p.literal[start:p.position], p.elementType), nil
+	}
+}
+
+// parseQuoted method parses quoted element, backslash escapes the next
+// character
+func (p *arrayParser) parseQuoted() (interface{}, error) {
+	var element strings.Builder
+
+	// opening quote
+	p.position++
+
+	for {
+		c := p.next()
+		switch c {
+		case 0:
+			return nil, errors.New(malformedArrayLiteral)
+		case '"':
+			p.position++
+			return element.String(), nil
+		case '\\':
+			p.position++
+			if p.next() == 0 {
+				return nil, errors.New(malformedArrayLiteral)
+			}
+			element.WriteByte(p.next())
+		default:
+			element.WriteByte(c)
+		}
+		p.position++
+	}
+}
+
+// arrayElementValue function converts unquoted element of array into value
+// of type that corresponds to type of array elements
+func arrayElementValue(element, elementType string) interface{} {
+	if strings.EqualFold(element, "NULL") {
+		return nil
+	}
+
+	switch elementType {
+	case "BOOL":
+		return element == "t"
+	case "INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "NUMERIC":
+		return NumericValue(element)
+	case "JSON", "JSONB":
+		return JSONValue(element)
+	default:
+		return element
+	}
+}
+
+// isArrayType function checks if database type name denotes an array. Names
+// of array types start with underscore followed by name of element type.
+func isArrayType(databaseType string) bool {
+	return strings.HasPrefix(databaseType, "_")
+}
+
+// columnScanner is implemented by scan arguments that convert the scanned
+// value into value stored into exported row, NULL is converted into nil
+type columnScanner interface {
+	sql.Scanner
+	rowValue() interface{}
+}
+
+// jsonScanner scans content of JSON and JSONB columns
+type jsonScanner struct {
+	sql.NullString
+}
+
+// rowValue method returns the scanned JSON document
+func (s *jsonScanner) rowValue() interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return JSONValue(s.String)
+}
+
+// numericScanner scans content of NUMERIC columns
+type numericScanner struct {
+	sql.NullString
+}
+
+// rowValue method returns the scanned number
+func (s *numericScanner) rowValue() interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return NumericValue(s.String)
+}
+
+// byteaScanner scans content of BYTEA columns
+type byteaScanner struct {
+	data []byte
+}
+
+// Scan method implements sql.Scanner interface. The scanned bytes are copied,
+// because the driver can reuse its buffer.
+func (s *byteaScanner) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		s.data = nil
+	case []byte:
+		s.data = append([]byte{}, v...)
+	case string:
+		s.data = []byte(v)
+	default:
+		return fmt.Errorf(unsupportedByteaValue, src)
+	}
+	return nil
+}
+
+// rowValue method returns the scanned bytes
+func (s *byteaScanner) rowValue() interface{} {
+	if s.data == nil {
+		return nil
+	}
+	return ByteaValue(s.data)
+}
+
+// arrayScanner scans content of array columns
+type arrayScanner struct {
+	sql.NullString
+	elementType string
+}
+
+// rowValue method returns the scanned array
+func (s *arrayScanner) rowValue() interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return ArrayValue{Literal: s.String, ElementType: s.elementType}
+}
+
+// temporalScanner scans content of DATE, TIME and TIMETZ columns. Drivers
+// return these values as time.Time with zero parts that would appear in the
+// output, so the value is formatted by layout of the given type.
+type temporalScanner struct {
+	value  string
+	valid  bool
+	layout string
+}
+
+// Scan method implements sql.Scanner interface
+func (s *temporalScanner) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		s.value = v.Format(s.layout)
+		s.valid = true
+		return nil
+	default:
+		var text sql.NullString
+		err := text.Scan(src)
+		s.value = text.String
+		s.valid = text.Valid
+		return err
+	}
+}
+
+// rowValue method returns the formatted value
+func (s *temporalScanner) rowValue() interface{} {
+	if !s.valid {
+		return nil
+	}
+	return s.value
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/columntypes_test.html
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestJSONValue checks rendering of JSON documents
+func TestJSONValue(t *testing.T) {
+	value := main.JSONValue("{\n  \"a\": [1, 2]\n}")
+	assert.Equal(t, "{\n  \"a\": [1, 2]\n}", value.String())
+
+	marshalled, err := json.Marshal(value)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":[1,2]}`, string(marshalled))
+
+	// invalid document is marshalled as string
+	marshalled, err = json.Marshal(main.JSONValue(""))
+	assert.NoError(t, err)
+	assert.Equal(t, `""`, string(marshalled))
+}
+
+// TestNumericValue checks rendering of numbers with arbitrary precision
+func TestNumericValue(t *testing.T) {
+	marshalled, err := json.Marshal(main.NumericValue("12345678901234567890.123456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234567890.123456789", string(marshalled))
+
+	marshalled, err = json.Marshal(main.NumericValue("NaN"))
+	assert.NoError(t, err)
+	assert.Equal(t, `"NaN"`, string(marshalled))
+}
+
+// TestByteaValue checks rendering of byte arrays
+func TestByteaValue(t *testing.T) {
+	assert.Equal(t, `\x00ff10`, main.ByteaValue{0x00, 0xff, 0x10}.String())
+	assert.Equal(t, `\x`, main.ByteaValue{}.String())
+	assert.Equal(t, "", main.ByteaValue(nil).String())
+
+	marshalled, err := json.Marshal(main.ByteaValue{0xca, 0xfe})
+	assert.NoError(t, err)
+	assert.Equal(t, `"\\xcafe"`, string(marshalled))
+}
+
+// TestArrayValue checks rendering of arrays
+func TestArrayValue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    main.ArrayValue
+		expected string
+	}{
+		{"integers", main.ArrayValue{Literal: "{1,2,NULL}", ElementType: "INT4"}, `[1,2,null]`},
+		{"strings", main.ArrayValue{Literal: `{foo,"bar baz","a\"b",NULL}`, ElementType: "TEXT"},
+			`["foo","bar baz","a\"b",null]`},
+		{"booleans", main.ArrayValue{Literal: "{t,f}", ElementType: "BOOL"}, `[true,false]`},
+		{"nested", main.ArrayValue{Literal: "{{1,2},{3,4}}", ElementType: "INT8"}, `[[1,2],[3,4]]`},
+		{"empty", main.ArrayValue{Literal: "{}", ElementType: "TEXT"}, `[]`},
+		{"bounds", main.ArrayValue{Literal: "[0:1]={1,2}", ElementType: "INT4"}, `[1,2]`},
+		{"malformed", main.ArrayValue{Literal: "{1,2", ElementType: "INT4"}, `"{1,2"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.value.Literal, tc.value.String())
+
+			marshalled, err := json.Marshal(tc.value)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(marshalled))
+		})
+	}
+}
+
+// TestReadTableRichTypes checks that values of columns with types that can
+// not be represented by plain strings are read without loss of information
+func TestReadTableRichTypes(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	rows := mock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("INT8", int64(0)),
+		sqlmock.NewColumn("report").OfType("JSONB", []byte{}),
+		sqlmock.NewColumn("amount").OfType("NUMERIC", []byte{}),
+		sqlmock.NewColumn("data").OfType("BYTEA", []byte{}),
+		sqlmock.NewColumn("tags").OfType("_TEXT", []byte{}),
+		sqlmock.NewColumn("score").OfType("FLOAT8", float64(0)),
+		sqlmock.NewColumn("day").OfType("DATE", time.Time{}),
+	)
+	rows.AddRow(int64(9007199254740993), []byte(`{"rules": []}`), []byte("0.10000000000000000001"),
+		[]byte{1, 2}, []byte(`{a,"b c"}`), 0.25, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC))
+	rows.AddRow(int64(1), nil, nil, nil, nil, nil, nil)
+
+	mock.ExpectQuery(readTableQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	values, err := storage.ReadTable(context.Background(), "table_name", NoLimits)
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+
+	assert.Equal(t, main.M{
+		"id":     int64(9007199254740993),
+		"report": main.JSONValue(`{"rules": []}`),
+		"amount": main.NumericValue("0.10000000000000000001"),
+		"data":   main.ByteaValue{1, 2},
+		"tags":   main.ArrayValue{Literal: `{a,"b c"}`, ElementType: "TEXT"},
+		"score":  0.25,
+		"day":    "2024-02-29",
+	}, values[0])
+
+	// NULL is stored as nil so it is distinguished from empty value
+	assert.Equal(t, main.M{
+		"id":     int64(1),
+		"report": nil,
+		"amount": nil,
+		"data":   nil,
+		"tags":   nil,
+		"score":  nil,
+		"day":    nil,
+	}, values[1])
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
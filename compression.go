@@ -0,0 +1,267 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains compression codecs that can be applied to all
+// exported objects and files - table data, metadata, disabled rules and
+// operation log. Codec is selected in configuration file. Compressed data
+// is encrypted when public keys are configured.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/compression.html
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Supported compression codecs
+const (
+	noCompression   = "none"
+	gzipCompression = "gzip"
+	zstdCompression = "zstd"
+	lz4Compression  = "lz4"
+)
+
+// File extensions added to names of compressed files and objects
+const (
+	gzipFileExtension = ".gz"
+	zstdFileExtension = ".zst"
+	lz4FileExtension  = ".lz4"
+)
+
+// messages
+const (
+	unknownCompression = "Unknown compression codec: %s"
+)
+
+// checkCompression function checks if given compression codec is supported.
+// Empty codec means no compression.
+func checkCompression(compression string) error {
+	switch compression {
+	case "", noCompression, gzipCompression, zstdCompression, lz4Compression:
+		return nil
+	default:
+		return fmt.Errorf(unknownCompression, compression)
+	}
+}
+
+// compressionExtension function returns extension that is added to names of
+// files and objects compressed by given codec
+func compressionExtension(compression string) string {
+	switch compression {
+	case gzipCompression:
+		return gzipFileExtension
+	case zstdCompression:
+		return zstdFileExtension
+	case lz4Compression:
+		return lz4FileExtension
+	default:
+		return ""
+	}
+}
+
+// artifactExtension function returns extension that is added to names of
+// files and objects compressed by given codec and encrypted by given
+// encryption
+func artifactExtension(compression string, encryption *Encryption) string {
+	return compressionExtension(compression) + encryption.Extension()
+}
+
+// contentEncoding function returns value of Content-Encoding attribute for
+// objects compressed by given codec. Encrypted objects can't be decoded by
+// clients, so they have no content encoding.
+func contentEncoding(compression string, encryption *Encryption) string {
+	if encryption != nil {
+		return ""
+	}
+
+	switch compression {
+	case gzipCompression, zstdCompression, lz4Compression:
+		return compression
+	default:
+		return ""
+	}
+}
+
+// nopWriteCloser is used when no compression is selected, closing it does
+// not close the underlying writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close method does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newEncodingWriter function constructs writer that compresses all data
+// written into it by given codec and encrypts them when encryption is
+// given. The writer needs to be closed to write all data into the
+// underlying writer, but the underlying writer is not closed.
+func newEncodingWriter(compression string, encryption *Encryption,
+	writer io.Writer) (io.WriteCloser, error) {
+	if encryption == nil {
+		return newCompressor(compression, writer)
+	}
+
+	encryptor, err := encryption.newEncryptor(writer)
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := newCompressor(compression, encryptor)
+	if err != nil {
+		_ = encryptor.Close()
+		return nil, err
+	}
+	return encryptedWriter{compressor, encryptor}, nil
+}
+
+// newCompressor function constructs writer that compresses all data written
+// into it by given codec. The compressor needs to be closed to write all
+// data into the underlying writer, but the underlying writer is not closed.
+func newCompressor(compression string, writer io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "", noCompression:
+		return nopWriteCloser{writer}, nil
+	case gzipCompression:
+		return gzip.NewWriter(writer), nil
+	case zstdCompression:
+		return zstd.NewWriter(writer)
+	case lz4Compression:
+		return lz4.NewWriter(writer), nil
+	default:
+		return nil, fmt.Errorf(unknownCompression, compression)
+	}
+}
+
+// compressData function compresses data by given codec and encrypts them
+// when encryption is given
+func compressData(compression string, encryption *Encryption, data []byte) ([]byte, error) {
+	// nothing to do
+	if artifactExtension(compression, encryption) == "" {
+		return data, checkCompression(compression)
+	}
+
+	buffer := new(bytes.Buffer)
+
+	compressor, err := newEncodingWriter(compression, encryption, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = compressor.Write(data)
+	if err != nil {
+		// error during write is more important than error during close
+		_ = compressor.Close()
+		return nil, err
+	}
+
+	err = compressor.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// objectCompression function returns codec used to compress object or file
+// with given name, according to its extension
+func objectCompression(name string) string {
+	for _, compression := range []string{gzipCompression, zstdCompression, lz4Compression} {
+		if strings.HasSuffix(name, compressionExtension(compression)) {
+			return compression
+		}
+	}
+	return noCompression
+}
+
+// newDecompressor function constructs reader that decompresses data read
+// from given reader by selected codec. The decompressor needs to be closed,
+// but the underlying reader is not closed.
+func newDecompressor(compression string, reader io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "", noCompression:
+		return io.NopCloser(reader), nil
+	case gzipCompression:
+		return gzip.NewReader(reader)
+	case zstdCompression:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case lz4Compression:
+		return io.NopCloser(lz4.NewReader(reader)), nil
+	default:
+		return nil, fmt.Errorf(unknownCompression, compression)
+	}
+}
+
+// compressedFile represents file with data compressed by selected codec
+type compressedFile struct {
+	compressor io.WriteCloser
+	file       *os.File
+}
+
+// createCompressedFile function creates new file with data compressed by
+// given codec (and encrypted when encryption is given). Extensions used by
+// codec and encryption are added to file name.
+func createCompressedFile(fileName, compression string,
+	encryption *Encryption) (io.WriteCloser, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	fout, err := os.Create(fileName + artifactExtension(compression, encryption)) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := newEncodingWriter(compression, encryption, fout)
+	if err != nil {
+		_ = fout.Close()
+		return nil, err
+	}
+
+	return compressedFile{compressor, fout}, nil
+}
+
+// Write method writes data into file through compressor
+func (f compressedFile) Write(data []byte) (int, error) {
+	return f.compressor.Write(data)
+}
+
+// Close method flushes all compressed data and closes the file
+func (f compressedFile) Close() error {
+	err := f.compressor.Close()
+	if err != nil {
+		// error during compression is more important than error during close
+		_ = f.file.Close()
+		return err
+	}
+
+	return f.file.Close()
+}
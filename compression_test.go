@@ -0,0 +1,166 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/compression_test.html
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// testData is content compressed by all tests
+var testData = []byte("org_id,cluster_id\n1,first\n2,second\n")
+
+// decompress is helper function that decompresses data compressed by given
+// codec
+func decompress(t *testing.T, compression string, data []byte) []byte {
+	var reader io.Reader
+	var err error
+
+	switch compression {
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case "zstd":
+		reader, err = zstd.NewReader(bytes.NewReader(data))
+	case "lz4":
+		reader = lz4.NewReader(bytes.NewReader(data))
+	default:
+		reader = bytes.NewReader(data)
+	}
+	assert.NoError(t, err)
+
+	decompressed, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return decompressed
+}
+
+// TestCheckCompression checks which compression codecs are supported
+func TestCheckCompression(t *testing.T) {
+	for _, compression := range []string{"", "none", "gzip", "zstd", "lz4"} {
+		assert.NoError(t, main.CheckCompression(compression), compression)
+	}
+
+	assert.EqualError(t, main.CheckCompression("brotli"),
+		"Unknown compression codec: brotli")
+}
+
+// TestCompressionExtension checks extensions used by compression codecs
+func TestCompressionExtension(t *testing.T) {
+	assert.Equal(t, "", main.CompressionExtension(""))
+	assert.Equal(t, "", main.CompressionExtension("none"))
+	assert.Equal(t, ".gz", main.CompressionExtension("gzip"))
+	assert.Equal(t, ".zst", main.CompressionExtension("zstd"))
+	assert.Equal(t, ".lz4", main.CompressionExtension("lz4"))
+}
+
+// TestCompressData checks that compressed data can be decompressed
+func TestCompressData(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "zstd", "lz4"} {
+		t.Run(compression, func(t *testing.T) {
+			compressed, err := main.CompressData(compression, nil, testData)
+			assert.NoError(t, err)
+			assert.Equal(t, testData, decompress(t, compression, compressed))
+		})
+	}
+}
+
+// TestCompressDataNoCompression checks that data are not changed when no
+// compression is selected
+func TestCompressDataNoCompression(t *testing.T) {
+	compressed, err := main.CompressData("", nil, testData)
+	assert.NoError(t, err)
+	assert.Equal(t, testData, compressed)
+}
+
+// TestCompressDataUnknownCodec checks that unknown codec is refused
+func TestCompressDataUnknownCodec(t *testing.T) {
+	_, err := main.CompressData("brotli", nil, testData)
+	assert.Error(t, err)
+}
+
+// TestObjectCompression checks that codec is selected by extension
+func TestObjectCompression(t *testing.T) {
+	assert.Equal(t, "none", main.ObjectCompression("prefix/table.csv"))
+	assert.Equal(t, "gzip", main.ObjectCompression("prefix/table.csv.gz"))
+	assert.Equal(t, "zstd", main.ObjectCompression("prefix/table.csv.zst"))
+	assert.Equal(t, "lz4", main.ObjectCompression("prefix/table.csv.lz4"))
+}
+
+// TestNewDecompressor checks that compressed data can be decompressed
+func TestNewDecompressor(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "zstd", "lz4"} {
+		t.Run(compression, func(t *testing.T) {
+			compressed, err := main.CompressData(compression, nil, testData)
+			assert.NoError(t, err)
+
+			decompressor, err := main.NewDecompressor(compression, bytes.NewReader(compressed))
+			assert.NoError(t, err)
+
+			decompressed, err := io.ReadAll(decompressor)
+			assert.NoError(t, err)
+			assert.NoError(t, decompressor.Close())
+			assert.Equal(t, testData, decompressed)
+		})
+	}
+
+	_, err := main.NewDecompressor("brotli", bytes.NewReader(testData))
+	assert.Error(t, err)
+}
+
+// TestCreateCompressedFile checks that file with codec extension is created
+// and that its content can be decompressed
+func TestCreateCompressedFile(t *testing.T) {
+	for _, compression := range []string{"none", "gzip", "zstd", "lz4"} {
+		t.Run(compression, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "table.csv")
+
+			fout, err := main.CreateCompressedFile(fileName, compression, nil)
+			assert.NoError(t, err)
+
+			_, err = fout.Write(testData)
+			assert.NoError(t, err)
+			assert.NoError(t, fout.Close())
+
+			content, err := os.ReadFile(fileName + main.CompressionExtension(compression))
+			assert.NoError(t, err)
+			assert.Equal(t, testData, decompress(t, compression, content))
+		})
+	}
+}
+
+// TestCreateCompressedFileUnknownCodec checks that unknown codec is refused
+func TestCreateCompressedFileUnknownCodec(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "table.csv")
+
+	_, err := main.CreateCompressedFile(fileName, "brotli", nil)
+	assert.Error(t, err)
+}
@@ -44,6 +44,18 @@ package main
 // pg_port = 5432
 // pg_db_name = "aggregator"
 // pg_params = "sslmode=disable"
+// parallel_readers = 1
+// retry_budget = 5
+// circuit_breaker_threshold = 3
+// query_timeout = "0s"
+// chunk_size = 0
+// chunk_target_bytes = 0
+// replica_lag_threshold = "0s"
+// replica_lag_action = "abort"
+// schemas = ["public"]
+// pg_copy = false
+// explain_queries = false
+// explain_min_rows = 0
 //
 // [s3]
 // type = "minio"
@@ -53,11 +65,99 @@ package main
 // secret_access_key = "foobar"
 // use_ssl = false
 // bucket = "test"
+// stat_timeout = "0s"
+// put_timeout = "0s"
+// bucket_exists_timeout = "0s"
+// create_bucket = false
+// region = ""
+// presign_expiry = "0s"
+// lock_ttl = "0s"
+// tls_min_version = ""
+// tls_cipher_suites = []
+// part_size = 0
+// upload_concurrency = 0
+// retries = 3
+// retry_backoff = "1s"
+// retry_max_backoff = "30s"
+// retry_jitter = "500ms"
+//
+// [sftp]
+// host = ""
+// port = 22
+// username = ""
+// password = ""
+// private_key_file = ""
+// known_hosts_file = ""
+// insecure_ignore_host_key = false
+// directory = ""
+//
+// [kafka]
+// brokers = ["localhost:9092"]
+// topic = "exports.{table}"
 //
 // [logging]
 // debug = true
 // log_level = ""
 //
+// [metrics]
+// textfile_path = ""
+// statsd_address = ""
+// statsd_namespace = ""
+//
+// [export]
+// skip_artifacts = []
+// duckdb_binary = ""
+// compression = "none"
+// encryption_key_file = ""
+// skip_unchanged = false
+// audited_tables = []
+// audit_checksum = "none"
+// audit_checkpoint_rows = 0
+// directories = []
+// content_addressed = false
+// quarantine = false
+// invalid_utf8 = "replace"
+// tables = []
+// exclude_tables = []
+// identity_columns = "override"
+// csv_schema_sidecars = false
+// csv_null_value = ""
+// order_rows = false
+// digest_state_file = ""
+// watermark_state_file = ""
+// watermark_state_object = ""
+//
+// [schedule]
+// start_jitter = "0s"
+// max_active_connections = 0
+// max_replication_lag = "0s"
+// load_check_interval = "1m"
+// max_start_delay = "0s"
+//
+// [hooks]
+// command = []
+// webhook_url = ""
+// retries = 3
+// retry_delay = "1s"
+// timeout = "30s"
+// tls_min_version = ""
+// tls_cipher_suites = []
+//
+// [split]
+// public_tables = []
+// public_bucket = ""
+// public_prefix = "public"
+// restricted_bucket = ""
+// restricted_prefix = "restricted"
+//
+// [google_sheets]
+// spreadsheet_id = ""
+// service_account_file = ""
+// endpoint_url = ""
+// timeout = "30s"
+// tls_min_version = ""
+// tls_cipher_suites = []
+//
 // Environment variables that can be used to override configuration file settings:
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__DB_DRIVER
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_USERNAME
@@ -66,6 +166,18 @@ package main
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_PORT
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_DB_NAME
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_PARAMS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PARALLEL_READERS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__RETRY_BUDGET
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__CIRCUIT_BREAKER_THRESHOLD
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__QUERY_TIMEOUT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__CHUNK_SIZE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__CHUNK_TARGET_BYTES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__REPLICA_LAG_THRESHOLD
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__REPLICA_LAG_ACTION
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__SCHEMAS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_COPY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__EXPLAIN_QUERIES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__EXPLAIN_MIN_ROWS
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__TYPE
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__ENDPOINT_URL
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__ENDPOINT_PORT
@@ -74,15 +186,85 @@ package main
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__USE_SSL
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__BUCKET
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__PREFIX
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__STAT_TIMEOUT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__PUT_TIMEOUT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__BUCKET_EXISTS_TIMEOUT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__CREATE_BUCKET
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__REGION
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__PRESIGN_EXPIRY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__LOCK_TTL
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__TLS_MIN_VERSION
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__TLS_CIPHER_SUITES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__PART_SIZE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__UPLOAD_CONCURRENCY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__RETRIES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__RETRY_BACKOFF
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__RETRY_MAX_BACKOFF
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__S3__RETRY_JITTER
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__HOST
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__PORT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__USERNAME
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__PASSWORD
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__PRIVATE_KEY_FILE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__KNOWN_HOSTS_FILE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__INSECURE_IGNORE_HOST_KEY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SFTP__DIRECTORY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__KAFKA__BROKERS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__KAFKA__TOPIC
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__LOGGING__DEBUG
 // INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__LOGGING__LOG_DEVEL
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__METRICS__TEXTFILE_PATH
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__METRICS__STATSD_ADDRESS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__METRICS__STATSD_NAMESPACE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__SKIP_ARTIFACTS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__DUCKDB_BINARY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__COMPRESSION
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__ENCRYPTION_KEY_FILE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__SKIP_UNCHANGED
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__AUDITED_TABLES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__AUDIT_CHECKSUM
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__AUDIT_CHECKPOINT_ROWS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__DIRECTORIES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__CONTENT_ADDRESSED
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__QUARANTINE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__INVALID_UTF8
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__TABLES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__EXCLUDE_TABLES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__IDENTITY_COLUMNS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__CSV_SCHEMA_SIDECARS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__CSV_NULL_VALUE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__ORDER_ROWS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__DIGEST_STATE_FILE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__WATERMARK_STATE_FILE
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__EXPORT__WATERMARK_STATE_OBJECT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SCHEDULE__START_JITTER
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SCHEDULE__MAX_ACTIVE_CONNECTIONS
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SCHEDULE__MAX_REPLICATION_LAG
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SCHEDULE__LOAD_CHECK_INTERVAL
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SCHEDULE__MAX_START_DELAY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__COMMAND
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__WEBHOOK_URL
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__RETRIES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__RETRY_DELAY
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__TIMEOUT
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__TLS_MIN_VERSION
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__HOOKS__TLS_CIPHER_SUITES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SPLIT__PUBLIC_TABLES
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SPLIT__PUBLIC_BUCKET
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SPLIT__PUBLIC_PREFIX
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SPLIT__RESTRICTED_BUCKET
+// INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__SPLIT__RESTRICTED_PREFIX
 
 import (
 	"bytes"
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	clowder "github.com/redhatinsights/app-common-go/pkg/api/v1"
@@ -101,10 +283,30 @@ const (
 
 // ConfigStruct is a structure holding the whole service configuration
 type ConfigStruct struct {
-	Storage StorageConfiguration `mapstructure:"storage" toml:"storage"`
-	S3      S3Configuration      `mapstructure:"s3"      toml:"s3"`
-	Logging LoggingConfiguration `mapstructure:"logging" toml:"logging"`
-	Sentry  SentryConfiguration  `mapstructure:"sentry"  toml:"sentry"`
+	Storage     StorageConfiguration     `mapstructure:"storage" toml:"storage"`
+	S3          S3Configuration          `mapstructure:"s3"      toml:"s3"`
+	SFTP        SFTPConfiguration        `mapstructure:"sftp"    toml:"sftp"`
+	Kafka       KafkaConfiguration       `mapstructure:"kafka"   toml:"kafka"`
+	Logging     LoggingConfiguration     `mapstructure:"logging" toml:"logging"`
+	Sentry      SentryConfiguration      `mapstructure:"sentry"  toml:"sentry"`
+	Metrics     MetricsConfiguration     `mapstructure:"metrics" toml:"metrics"`
+	Export      ExportConfiguration      `mapstructure:"export"   toml:"export"`
+	Casts       CastsConfiguration       `mapstructure:"casts"    toml:"casts"`
+	Schedule    ScheduleConfiguration    `mapstructure:"schedule" toml:"schedule"`
+	Queries     QueriesConfiguration     `mapstructure:"queries"     toml:"queries"`
+	Incremental IncrementalConfiguration `mapstructure:"incremental" toml:"incremental"`
+	Hooks       HooksConfiguration       `mapstructure:"hooks"       toml:"hooks"`
+	Split       SplitConfiguration       `mapstructure:"split"       toml:"split"`
+	Ordering    OrderingConfiguration    `mapstructure:"ordering"    toml:"ordering"`
+	Limits      LimitsConfiguration      `mapstructure:"limits"      toml:"limits"`
+	Masking     MaskingConfiguration     `mapstructure:"masking"     toml:"masking"`
+	ObjectNames ObjectNamesConfiguration `mapstructure:"object_names" toml:"object_names"`
+	// OutputProfiles contains profiles CSV objects written into outputs
+	// are formatted by
+	OutputProfiles OutputProfilesConfiguration `mapstructure:"output_profiles" toml:"output_profiles"`
+	// GoogleSheets contains configuration of spreadsheet small reports
+	// are written into
+	GoogleSheets GoogleSheetsConfiguration `mapstructure:"google_sheets" toml:"google_sheets"`
 }
 
 // LoggingConfiguration represents configuration for logging in general
@@ -142,6 +344,44 @@ type StorageConfiguration struct {
 	EnableOrgIDFiltering   bool     `mapstructure:"enable_org_id_filtering"   toml:"enable_org_id_filtering"`
 	OrganizationIDsCSVFile string   `mapstructure:"organization_ids_csv_file" toml:"organization_ids_csv_file"`
 	OrganizationsToExport  []string `mapstructure:"organizations_to_export" toml:"organizations_to_export"`
+	ParallelReaders        int      `mapstructure:"parallel_readers" toml:"parallel_readers"`
+	// RetryBudget is total number of retries of database reads that
+	// failed because of transient errors during one run
+	RetryBudget int `mapstructure:"retry_budget" toml:"retry_budget"`
+	// CircuitBreakerThreshold is number of consecutive database errors
+	// after which the run is aborted, zero disables the breaker
+	CircuitBreakerThreshold int `mapstructure:"circuit_breaker_threshold" toml:"circuit_breaker_threshold"`
+	// QueryTimeout is maximal time one database query (including reading
+	// of all its rows) can take, zero disables the timeout
+	QueryTimeout time.Duration `mapstructure:"query_timeout" toml:"query_timeout"`
+	// ChunkSize is number of rows read by one query when tables are read
+	// in chunks ordered by primary key, zero disables chunked reading.
+	// Parallel readers take precedence, so chunks are used with more
+	// parallel readers only for exports with limit of rows.
+	ChunkSize int `mapstructure:"chunk_size" toml:"chunk_size"`
+	// ChunkTargetBytes is approximate memory size of one chunk, chunk
+	// size is tuned by observed width of rows when it is set
+	ChunkTargetBytes int64 `mapstructure:"chunk_target_bytes" toml:"chunk_target_bytes"`
+	// ReplicaLagThreshold is maximal replication lag of standby database
+	// the data can be exported from, zero disables the check
+	ReplicaLagThreshold time.Duration `mapstructure:"replica_lag_threshold" toml:"replica_lag_threshold"`
+	// ReplicaLagAction selects what happens when replication lag exceeds
+	// the threshold: the export is aborted (abort) or warning is logged
+	// (warn)
+	ReplicaLagAction string `mapstructure:"replica_lag_action" toml:"replica_lag_action"`
+	// Schemas contains list of PostgreSQL schemas tables are exported
+	// from, tables from all schemas except system ones are exported when
+	// the list is empty
+	Schemas []string `mapstructure:"schemas" toml:"schemas"`
+	// PGCopy enables export of tables into CSV by PostgreSQL COPY TO
+	// command instead of reading and formatting rows one by one
+	PGCopy bool `mapstructure:"pg_copy" toml:"pg_copy"`
+	// ExplainQueries enables recording of plans of queries reading
+	// tables into log and operation log
+	ExplainQueries bool `mapstructure:"explain_queries" toml:"explain_queries"`
+	// ExplainMinRows is minimal estimated number of rows of table for its
+	// query plan to be recorded, all plans are recorded when it is zero
+	ExplainMinRows int `mapstructure:"explain_min_rows" toml:"explain_min_rows"`
 }
 
 // S3Configuration represents configuration of S3/Minio data storage
@@ -154,6 +394,95 @@ type S3Configuration struct {
 	UseSSL          bool   `mapstructure:"use_ssl"           toml:"use_ssl"`
 	Bucket          string `mapstructure:"bucket"            toml:"bucket"`
 	Prefix          string `mapstructure:"prefix"            toml:"prefix"`
+
+	// StatTimeout is maximal time of one request reading object metadata,
+	// zero means no timeout
+	StatTimeout time.Duration `mapstructure:"stat_timeout" toml:"stat_timeout"`
+
+	// PutTimeout is maximal time of one object upload (including streaming
+	// of table content into the object), zero means no timeout
+	PutTimeout time.Duration `mapstructure:"put_timeout" toml:"put_timeout"`
+
+	// BucketExistsTimeout is maximal time of one check of bucket existence,
+	// zero means no timeout
+	BucketExistsTimeout time.Duration `mapstructure:"bucket_exists_timeout" toml:"bucket_exists_timeout"`
+
+	// CreateBucket enables creation of configured bucket when it does not
+	// exist, so ephemeral environments do not need to prepare it
+	CreateBucket bool `mapstructure:"create_bucket" toml:"create_bucket"`
+
+	// Region is region the bucket is created in and requests are signed
+	// for, it is detected from the bucket when it is not set
+	Region string `mapstructure:"region" toml:"region"`
+
+	// PresignExpiry enables generation of presigned GET URLs of exported
+	// objects that are valid for this time, zero disables presigned URLs
+	PresignExpiry time.Duration `mapstructure:"presign_expiry" toml:"presign_expiry"`
+
+	// LockTTL enables lock object that guards configured prefix against
+	// concurrent exports, lock that has not been released expires after
+	// this time. Zero disables locking.
+	LockTTL time.Duration `mapstructure:"lock_ttl" toml:"lock_ttl"`
+
+	// SignatureVersion selects how requests are signed, "v4" (the default)
+	// or "v2" for legacy S3-compatible endpoints
+	SignatureVersion string `mapstructure:"signature_version" toml:"signature_version"`
+
+	// BucketLookup selects how bucket is addressed, "auto" (the default),
+	// "dns" (virtual-host style) or "path" (path style)
+	BucketLookup string `mapstructure:"bucket_lookup" toml:"bucket_lookup"`
+
+	// TLSMinVersion is minimal TLS version of connection to S3 ("1.0",
+	// "1.1", "1.2" or "1.3"), TLS 1.2 is used when it is not set
+	TLSMinVersion string `mapstructure:"tls_min_version" toml:"tls_min_version"`
+
+	// TLSCipherSuites contains names of cipher suites allowed for
+	// connection to S3, default cipher suites are used when it is empty
+	TLSCipherSuites []string `mapstructure:"tls_cipher_suites" toml:"tls_cipher_suites"`
+
+	// PartSize is size of one part of multipart upload in bytes (5 MiB at
+	// least), 16 MiB is used when it is not set
+	PartSize int64 `mapstructure:"part_size" toml:"part_size"`
+
+	// UploadConcurrency is number of parts of one object uploaded
+	// concurrently, parts are uploaded one by one when it is not set
+	UploadConcurrency int `mapstructure:"upload_concurrency" toml:"upload_concurrency"`
+
+	// Retries is number of retries of upload that failed because of
+	// transient error, zero disables retries
+	Retries int `mapstructure:"retries" toml:"retries"`
+
+	// RetryBackoff is delay before the first retry, it is doubled with
+	// every other retry
+	RetryBackoff time.Duration `mapstructure:"retry_backoff" toml:"retry_backoff"`
+
+	// RetryMaxBackoff limits delay between retries, zero means no limit
+	RetryMaxBackoff time.Duration `mapstructure:"retry_max_backoff" toml:"retry_max_backoff"`
+
+	// RetryJitter is maximal random delay added to every backoff
+	RetryJitter time.Duration `mapstructure:"retry_jitter" toml:"retry_jitter"`
+}
+
+// SFTPConfiguration represents configuration of SFTP server exported files
+// are uploaded to. Password or private key can be used for authentication.
+// Host key of the server is checked against known_hosts file.
+type SFTPConfiguration struct {
+	Host                  string `mapstructure:"host"                     toml:"host"`
+	Port                  int    `mapstructure:"port"                     toml:"port"`
+	Username              string `mapstructure:"username"                 toml:"username"`
+	Password              string `mapstructure:"password"                 toml:"password"`
+	PrivateKeyFile        string `mapstructure:"private_key_file"         toml:"private_key_file"`
+	KnownHostsFile        string `mapstructure:"known_hosts_file"         toml:"known_hosts_file"`
+	InsecureIgnoreHostKey bool   `mapstructure:"insecure_ignore_host_key" toml:"insecure_ignore_host_key"`
+	Directory             string `mapstructure:"directory"                toml:"directory"`
+}
+
+// KafkaConfiguration represents configuration of Kafka brokers exported rows
+// are published to. Topic can contain {table} placeholder that is replaced by
+// the name of exported table.
+type KafkaConfiguration struct {
+	Brokers []string `mapstructure:"brokers" toml:"brokers"`
+	Topic   string   `mapstructure:"topic"   toml:"topic"`
 }
 
 // SentryConfiguration represents the configuration of Sentry logger
@@ -162,6 +491,352 @@ type SentryConfiguration struct {
 	SentryEnvironment string `mapstructure:"environment" toml:"environment"`
 }
 
+// MetricsConfiguration represents configuration of metrics written at the
+// end of the run
+type MetricsConfiguration struct {
+	// TextfilePath is path to file in Prometheus text format that is read
+	// by node_exporter textfile collector. Metrics are not written when
+	// the path is empty.
+	TextfilePath string `mapstructure:"textfile_path" toml:"textfile_path"`
+
+	// StatsdAddress is host:port of StatsD daemon counters and timers are
+	// sent to over UDP during the run. Metrics are not sent when the
+	// address is empty.
+	StatsdAddress string `mapstructure:"statsd_address" toml:"statsd_address"`
+
+	// StatsdNamespace is prefix of names of metrics sent to StatsD
+	StatsdNamespace string `mapstructure:"statsd_namespace" toml:"statsd_namespace"`
+}
+
+// HooksConfiguration represents configuration of post-processing hooks
+// invoked for every produced artifact
+type HooksConfiguration struct {
+	// Command is external command (with its arguments) executed for every
+	// artifact, location and checksum of the artifact are appended to
+	// the arguments
+	Command []string `mapstructure:"command" toml:"command"`
+	// WebhookURL is URL description of every artifact is posted to as
+	// JSON
+	WebhookURL string `mapstructure:"webhook_url" toml:"webhook_url"`
+	// Retries is number of retries of failed invocation
+	Retries int `mapstructure:"retries" toml:"retries"`
+	// RetryDelay is time to wait before failed invocation is retried
+	RetryDelay time.Duration `mapstructure:"retry_delay" toml:"retry_delay"`
+	// Timeout is maximal time one invocation can take
+	Timeout time.Duration `mapstructure:"timeout" toml:"timeout"`
+	// TLSMinVersion is minimal TLS version of connection to webhook
+	TLSMinVersion string `mapstructure:"tls_min_version" toml:"tls_min_version"`
+	// TLSCipherSuites contains names of cipher suites allowed for
+	// connection to webhook
+	TLSCipherSuites []string `mapstructure:"tls_cipher_suites" toml:"tls_cipher_suites"`
+}
+
+// GoogleSheetsConfiguration represents configuration of Google Sheets
+// spreadsheet metadata, summary and disabled rules reports are written into
+type GoogleSheetsConfiguration struct {
+	// SpreadsheetID is ID of spreadsheet reports are written into, reports
+	// are not written into Google Sheets when it is empty
+	SpreadsheetID string `mapstructure:"spreadsheet_id" toml:"spreadsheet_id"`
+	// ServiceAccountFile is JSON key file of service account used to
+	// authenticate to Google Sheets API
+	ServiceAccountFile string `mapstructure:"service_account_file" toml:"service_account_file"`
+	// EndpointURL is URL of Google Sheets API, public API is used when
+	// it is not set
+	EndpointURL string `mapstructure:"endpoint_url" toml:"endpoint_url"`
+	// Timeout is maximal time update of all sheets can take
+	Timeout time.Duration `mapstructure:"timeout" toml:"timeout"`
+	// TLSMinVersion is minimal TLS version of connection to Google Sheets
+	// API and token endpoint
+	TLSMinVersion string `mapstructure:"tls_min_version" toml:"tls_min_version"`
+	// TLSCipherSuites contains names of cipher suites allowed for
+	// connection to Google Sheets API and token endpoint
+	TLSCipherSuites []string `mapstructure:"tls_cipher_suites" toml:"tls_cipher_suites"`
+}
+
+// SplitConfiguration represents configuration of export split into public
+// and restricted artifact sets
+type SplitConfiguration struct {
+	// PublicTables contains names or patterns of tables that belong to
+	// public artifact set, all other tables are restricted. Export is not
+	// split when the list is empty.
+	PublicTables []string `mapstructure:"public_tables" toml:"public_tables"`
+	// PublicBucket is bucket public artifact set is stored into, bucket
+	// from [s3] section is used when not set
+	PublicBucket string `mapstructure:"public_bucket" toml:"public_bucket"`
+	// PublicPrefix is prefix (or subdirectory) public artifact set is
+	// stored under
+	PublicPrefix string `mapstructure:"public_prefix" toml:"public_prefix"`
+	// RestrictedBucket is bucket restricted artifact set is stored into,
+	// bucket from [s3] section is used when not set
+	RestrictedBucket string `mapstructure:"restricted_bucket" toml:"restricted_bucket"`
+	// RestrictedPrefix is prefix (or subdirectory) restricted artifact set
+	// is stored under
+	RestrictedPrefix string `mapstructure:"restricted_prefix" toml:"restricted_prefix"`
+	// PublicMasks contains SQL expressions that mask columns of public
+	// tables, they are configured per table and column the same way as
+	// casts
+	PublicMasks CastsConfiguration `mapstructure:"public_masks" toml:"public_masks"`
+}
+
+// ExportConfiguration represents configuration of exported data and
+// artifacts
+type ExportConfiguration struct {
+	// SkipArtifacts contains list of artifacts that won't be exported even
+	// when requested on command line. Possible values are:
+	// "tables-list"
+	// "metadata"
+	// "disabled-rules"
+	// "log"
+	// "config"
+	// "queries"
+	// "sequences"
+	// "constraints"
+	// "rule-hits"
+	// "org-summary"
+	// "recommendations-trend"
+	SkipArtifacts []string `mapstructure:"skip_artifacts" toml:"skip_artifacts"`
+
+	// DuckDBBinary is path to DuckDB command line tool used by duckdb
+	// output. The tool is searched in PATH when not set.
+	DuckDBBinary string `mapstructure:"duckdb_binary" toml:"duckdb_binary"`
+
+	// Compression is codec used to compress all exported objects and
+	// files: none, gzip, zstd or lz4
+	Compression string `mapstructure:"compression" toml:"compression"`
+
+	// EncryptionKeyFile is path to file with OpenPGP public keys all
+	// exported objects and files are encrypted for, artifacts are not
+	// encrypted when it is not set
+	EncryptionKeyFile string `mapstructure:"encryption_key_file" toml:"encryption_key_file"`
+
+	// SkipUnchanged enables detection of tables that have not been changed
+	// since previous export into S3. Such tables are not uploaded again.
+	SkipUnchanged bool `mapstructure:"skip_unchanged" toml:"skip_unchanged"`
+
+	// AuditedTables contains list of sensitive tables. Number of rows and
+	// range of primary key values exported from these tables are recorded
+	// into operation log and into manifest.
+	AuditedTables []string `mapstructure:"audited_tables" toml:"audited_tables"`
+
+	// AuditChecksum is algorithm used to compute hash chain of rows
+	// exported from audited tables: none, sha256 or sha512
+	AuditChecksum string `mapstructure:"audit_checksum" toml:"audit_checksum"`
+
+	// AuditCheckpointRows is number of rows after which hash chain of
+	// audited table is recorded into manifest, zero records the final hash
+	// only
+	AuditCheckpointRows int `mapstructure:"audit_checkpoint_rows" toml:"audit_checkpoint_rows"`
+
+	// Directories contains list of directories (volumes) files with exported
+	// tables are distributed into in round-robin fashion. Other files are
+	// written into the first directory.
+	Directories []string `mapstructure:"directories" toml:"directories"`
+
+	// ContentAddressed enables content-addressed layout of objects exported
+	// into S3: data objects are named by hash of their content and index
+	// object maps tables to them.
+	ContentAddressed bool `mapstructure:"content_addressed" toml:"content_addressed"`
+
+	// Quarantine enables quarantine of rows that can't be scanned or
+	// converted into output format. Such rows are written into separate
+	// file with rejects instead of failing the export of whole table.
+	Quarantine bool `mapstructure:"quarantine" toml:"quarantine"`
+
+	// InvalidUTF8 selects what happens with text values that are not
+	// valid UTF-8: invalid sequences are replaced (replace), the row is
+	// rejected (reject) or the export fails (fail)
+	InvalidUTF8 string `mapstructure:"invalid_utf8" toml:"invalid_utf8"`
+
+	// Tables contains list of tables to be exported, all tables are
+	// exported when it is empty. List selected on command line is used
+	// instead when it is set.
+	Tables []string `mapstructure:"tables" toml:"tables"`
+
+	// ExcludeTables contains list of tables that won't be exported
+	ExcludeTables []string `mapstructure:"exclude_tables" toml:"exclude_tables"`
+
+	// IdentityColumns selects how identity columns are written into SQL
+	// dump: values are inserted with OVERRIDING SYSTEM VALUE (override) or
+	// the columns are left out of INSERT statements (skip)
+	IdentityColumns string `mapstructure:"identity_columns" toml:"identity_columns"`
+
+	// CSVSchemaSidecars enables export of schema sidecar file (for example
+	// report.csv.schema.json) with column names, types and NULL values for
+	// every table exported into CSV
+	CSVSchemaSidecars bool `mapstructure:"csv_schema_sidecars" toml:"csv_schema_sidecars"`
+
+	// CSVNullValue is string written into CSV instead of NULL (for example
+	// \N). Values equal to it are quoted, so with the default empty string
+	// NULL is written as empty field and empty string as ""
+	CSVNullValue string `mapstructure:"csv_null_value" toml:"csv_null_value"`
+
+	// TypeInferenceRows is number of rows sampled to infer types of
+	// columns reported by database without type, 100 rows are sampled
+	// when it is not set
+	TypeInferenceRows int `mapstructure:"type_inference_rows" toml:"type_inference_rows"`
+
+	// OrderRows enables ordering of rows of all tables by primary key, so
+	// consecutive exports can be compared. Columns configured in ordering
+	// section are used instead of primary key.
+	OrderRows bool `mapstructure:"order_rows" toml:"order_rows"`
+
+	// DigestStateFile is local file with state of the previous run the
+	// digest compares number of rows and columns of tables with
+	DigestStateFile string `mapstructure:"digest_state_file" toml:"digest_state_file"`
+
+	// WatermarkStateFile is local file with watermarks of incrementally
+	// exported tables
+	WatermarkStateFile string `mapstructure:"watermark_state_file" toml:"watermark_state_file"`
+
+	// WatermarkStateObject is S3 object (in configured bucket) with
+	// watermarks of incrementally exported tables
+	WatermarkStateObject string `mapstructure:"watermark_state_object" toml:"watermark_state_object"`
+
+	// ColumnFlags enables profiling of exported columns, columns that are
+	// NULL or constant in all exported rows are listed in _column_flags.csv
+	ColumnFlags bool `mapstructure:"column_flags" toml:"column_flags"`
+
+	// Checksums enables SHA-256 checksum sidecar (.sha256 file or object)
+	// for every exported file or object
+	Checksums bool `mapstructure:"checksums" toml:"checksums"`
+
+	// VerifyRowCounts enables comparison of number of rows exported from
+	// each table with number of its records read in the same snapshot
+	VerifyRowCounts bool `mapstructure:"verify_row_counts" toml:"verify_row_counts"`
+
+	// DisabledRulesDetails enables export of detailed report of rules
+	// disabled by more users (organizations, clusters and justifications)
+	// together with list of disabled rules
+	DisabledRulesDetails bool `mapstructure:"disabled_rules_details" toml:"disabled_rules_details"`
+
+	// RunTimeout is maximal time of the whole export, summary, manifest
+	// and operation log are still stored when it is exceeded. Zero means no
+	// timeout.
+	RunTimeout time.Duration `mapstructure:"run_timeout" toml:"run_timeout"`
+
+	// TrendWindow is time covered by trend of recommendations, 30 days
+	// are covered when it is not set
+	TrendWindow time.Duration `mapstructure:"trend_window" toml:"trend_window"`
+
+	// TrendPeriod is period rule hits and disabled rules are counted by
+	// in trend of recommendations: "day" (default) or "week"
+	TrendPeriod string `mapstructure:"trend_period" toml:"trend_period"`
+
+	// PseudonymSaltFile is local file with salt pseudonyms of masked
+	// values are computed with, so they are the same across runs. Random
+	// salt is used by each run when it is not set.
+	PseudonymSaltFile string `mapstructure:"pseudonym_salt_file" toml:"pseudonym_salt_file"`
+
+	// ProgressFile is local file status of running export is periodically
+	// written into
+	ProgressFile string `mapstructure:"progress_file" toml:"progress_file"`
+
+	// ProgressObject is S3 object (in configured bucket) status of running
+	// export is periodically written into
+	ProgressObject string `mapstructure:"progress_object" toml:"progress_object"`
+
+	// ProgressInterval is delay between writes of status of running
+	// export, zero means the default interval (10 seconds)
+	ProgressInterval time.Duration `mapstructure:"progress_interval" toml:"progress_interval"`
+
+	// CheckpointTables enables upload of tables read in chunks with
+	// checkpoints, so export resumed by -resume flag continues
+	// half-finished table after the last exported key
+	CheckpointTables bool `mapstructure:"checkpoint_tables" toml:"checkpoint_tables"`
+}
+
+// ScheduleConfiguration represents configuration of start of scheduled
+// export
+type ScheduleConfiguration struct {
+	// StartJitter is maximal random delay before the export starts
+	StartJitter time.Duration `mapstructure:"start_jitter" toml:"start_jitter"`
+
+	// MaxActiveConnections is number of active database connections
+	// above which the export is deferred, zero disables the check
+	MaxActiveConnections int `mapstructure:"max_active_connections" toml:"max_active_connections"`
+
+	// MaxReplicationLag is replication lag of database replica above which
+	// the export is deferred, zero disables the check
+	MaxReplicationLag time.Duration `mapstructure:"max_replication_lag" toml:"max_replication_lag"`
+
+	// LoadCheckInterval is delay between checks of database load
+	LoadCheckInterval time.Duration `mapstructure:"load_check_interval" toml:"load_check_interval"`
+
+	// MaxStartDelay is maximal time the export can be deferred, the
+	// export fails when the database is still busy; zero means no limit
+	MaxStartDelay time.Duration `mapstructure:"max_start_delay" toml:"max_start_delay"`
+}
+
+// CastsConfiguration contains SQL expressions used to cast or transform
+// selected columns before they are read from database. Expressions are
+// stored by table name and column name, for example:
+//
+// [casts.report]
+// report = "report::text"
+type CastsConfiguration map[string]map[string]string
+
+// QueriesConfiguration contains named custom SQL queries. Result of each
+// query is exported into its own file or object, for example:
+//
+// [queries]
+// rules_by_org = "SELECT org_id, count(*) AS hits FROM rule_hit GROUP BY org_id"
+type QueriesConfiguration map[string]string
+
+// IncrementalConfiguration contains timestamp or serial columns of tables
+// that are exported incrementally. Only rows with value of the column higher
+// than watermark recorded by previous run are exported, for example:
+//
+// [incremental]
+// report = "reported_at"
+// rule_hit = "id"
+type IncrementalConfiguration map[string]string
+
+// OrderingConfiguration contains columns rows of tables are ordered by, so
+// consecutive exports can be compared. More columns are separated by comma,
+// for example:
+//
+// [ordering]
+// report = "org_id, cluster"
+// rule_hit = "id"
+type OrderingConfiguration map[string]string
+
+// LimitsConfiguration contains maximal number of rows exported from selected
+// tables, so quick samples can be exported without dumping whole tables.
+// Limit selected by -limit flag is applied too, the lower limit is used, for
+// example:
+//
+// [limits]
+// report = 1000
+// rule_hit = 100
+type LimitsConfiguration map[string]int
+
+// MaskingConfiguration contains masking methods of columns that need to be
+// anonymized before they are exported. Methods are stored by table name and
+// column name, for example:
+//
+// [masking.report]
+// org_id = "pseudonym"
+// cluster = "fake"
+// account = "drop"
+// gathered_at = "constant:2000-01-01"
+type MaskingConfiguration map[string]map[string]string
+
+// ObjectNamesConfiguration contains names (including extension) of files or
+// objects selected tables are exported into instead of names derived from
+// table names, for example:
+//
+// [object_names]
+// rule_disable = "rules_disabled_report.csv"
+type ObjectNamesConfiguration map[string]string
+
+// OutputProfilesConfiguration contains names of profiles (formatting of
+// numbers and timestamps, delimiter) CSV objects written into selected
+// outputs are converted by, for example:
+//
+// [output_profiles]
+// file = "excel-eu"
+type OutputProfilesConfiguration map[string]string
+
 // LoadConfiguration function loads configuration from defaultConfigFile, file
 // set in configFileEnvVariableName or from environment variables
 func LoadConfiguration(configFileEnvVariableName, defaultConfigFile string) (ConfigStruct, error) {
@@ -229,13 +904,19 @@ func LoadConfiguration(configFileEnvVariableName, defaultConfigFile string) (Con
 		return config, err
 	}
 
+	// resolve ${ENV_VAR} references in string options
+	if err := interpolateEnvVariables(&config); err != nil {
+		return config, err
+	}
+
 	// updated configuration by introducing Clowder-related things
 	if err := updateConfigFromClowder(&config); err != nil {
 		fmt.Println("Error loading clowder configuration")
 		return config, err
 	}
 
-	return config, err
+	// check the final values, ie. after all overrides
+	return config, validateConfiguration(&config)
 }
 
 // GetStorageConfiguration function returns storage configuration
@@ -264,6 +945,208 @@ func GetS3Configuration(config *ConfigStruct) S3Configuration {
 	return config.S3
 }
 
+// GetSFTPConfiguration function returns SFTP configuration
+func GetSFTPConfiguration(config *ConfigStruct) SFTPConfiguration {
+	return config.SFTP
+}
+
+// GetKafkaConfiguration function returns Kafka configuration
+func GetKafkaConfiguration(config *ConfigStruct) KafkaConfiguration {
+	return config.Kafka
+}
+
+// GetMetricsConfiguration function returns metrics configuration
+func GetMetricsConfiguration(config *ConfigStruct) MetricsConfiguration {
+	return config.Metrics
+}
+
+// GetExportConfiguration function returns export configuration
+func GetExportConfiguration(config *ConfigStruct) ExportConfiguration {
+	return config.Export
+}
+
+// GetScheduleConfiguration function returns configuration of start of
+// scheduled export
+func GetScheduleConfiguration(config *ConfigStruct) ScheduleConfiguration {
+	return config.Schedule
+}
+
+// GetCastsConfiguration function returns casts of columns
+func GetCastsConfiguration(config *ConfigStruct) CastsConfiguration {
+	return config.Casts
+}
+
+// GetQueriesConfiguration function returns named custom queries
+func GetQueriesConfiguration(config *ConfigStruct) QueriesConfiguration {
+	return config.Queries
+}
+
+// GetGoogleSheetsConfiguration function returns configuration of Google
+// Sheets spreadsheet reports are written into
+func GetGoogleSheetsConfiguration(config *ConfigStruct) GoogleSheetsConfiguration {
+	return config.GoogleSheets
+}
+
+// GetHooksConfiguration function returns configuration of post-processing
+// hooks
+func GetHooksConfiguration(config *ConfigStruct) HooksConfiguration {
+	return config.Hooks
+}
+
+// GetSplitConfiguration function returns configuration of export split into
+// public and restricted artifact sets
+func GetSplitConfiguration(config *ConfigStruct) SplitConfiguration {
+	return config.Split
+}
+
+// GetIncrementalConfiguration function returns columns of incrementally
+// exported tables
+func GetIncrementalConfiguration(config *ConfigStruct) IncrementalConfiguration {
+	return config.Incremental
+}
+
+// GetLimitsConfiguration function returns maximal numbers of rows exported
+// from selected tables
+func GetLimitsConfiguration(config *ConfigStruct) LimitsConfiguration {
+	return config.Limits
+}
+
+// GetOrderingConfiguration function returns columns rows of tables are
+// ordered by
+func GetOrderingConfiguration(config *ConfigStruct) OrderingConfiguration {
+	return config.Ordering
+}
+
+// GetMaskingConfiguration function returns masking methods of anonymized
+// columns
+func GetMaskingConfiguration(config *ConfigStruct) MaskingConfiguration {
+	return config.Masking
+}
+
+// GetObjectNamesConfiguration function returns names of files or objects
+// tables are exported into
+func GetObjectNamesConfiguration(config *ConfigStruct) ObjectNamesConfiguration {
+	return config.ObjectNames
+}
+
+// GetOutputProfilesConfiguration function returns profiles selected for
+// outputs
+func GetOutputProfilesConfiguration(config *ConfigStruct) OutputProfilesConfiguration {
+	return config.OutputProfiles
+}
+
+// envVariableReference is regular expression matching ${ENV_VAR} references
+// and escaped $${ sequences
+var envVariableReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// escapedEnvVariableReference is written as literal ${
+const escapedEnvVariableReference = "$${"
+
+// interpolateString function replaces all ${ENV_VAR} references in given
+// string by values of environment variables, $${ is replaced by literal ${.
+// Names of undefined variables are returned too.
+func interpolateString(value string) (string, []string) {
+	var undefined []string
+
+	result := envVariableReference.ReplaceAllStringFunc(value, func(reference string) string {
+		if reference == escapedEnvVariableReference {
+			return reference[1:]
+		}
+		name := envVariableReference.FindStringSubmatch(reference)[1]
+		envValue, found := os.LookupEnv(name)
+		if !found {
+			undefined = append(undefined, name)
+		}
+		return envValue
+	})
+
+	return result, undefined
+}
+
+// interpolateValue function replaces ${ENV_VAR} references in given string
+// value or recursively in all strings contained in given struct, map, slice
+// or pointer. Struct fields are named by their mapstructure tags and map
+// entries by their keys, so problems are reported with full option name.
+// Options with SQL are skipped.
+func interpolateValue(checker *configurationChecker, option string, value reflect.Value) {
+	if sqlOptions[option] {
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		result, undefined := interpolateString(value.String())
+		for _, name := range undefined {
+			checker.report(option, fmt.Sprintf(undefinedEnvVariable, name))
+		}
+		value.SetString(result)
+	case reflect.Ptr:
+		if !value.IsNil() {
+			interpolateValue(checker, option, value.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			interpolateValue(checker, option, value.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				// unexported field
+				continue
+			}
+			interpolateValue(checker, joinOptionName(option, field.Tag.Get("mapstructure")),
+				value.Field(i))
+		}
+	case reflect.Map:
+		// map entries are not addressable, so each value is interpolated
+		// in its copy that is stored back. Keys are sorted to report
+		// problems in stable order.
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			element := reflect.New(value.Type().Elem()).Elem()
+			element.Set(value.MapIndex(key))
+			interpolateValue(checker, joinOptionName(option, fmt.Sprint(key.Interface())),
+				element)
+			value.SetMapIndex(key, element)
+		}
+	}
+}
+
+// joinOptionName function constructs name of nested option
+func joinOptionName(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// sqlOptions contains configuration sections and options with SQL (casts,
+// custom queries and masks of public artifact set), SQL is used as it is
+// written, without interpolation
+var sqlOptions = map[string]bool{
+	"casts":              true,
+	"queries":            true,
+	"split.public_masks": true,
+}
+
+// interpolateEnvVariables function replaces ${ENV_VAR} references in all
+// string options of the configuration (including lists, maps and nested
+// sections) by values of environment variables, so one configuration
+// template can be used in more environments. Options with SQL are not
+// interpolated. Literal ${ can be written as $${. References to undefined
+// variables are reported as configuration problems.
+func interpolateEnvVariables(config *ConfigStruct) error {
+	var checker configurationChecker
+
+	interpolateValue(&checker, "", reflect.ValueOf(config).Elem())
+
+	return checker.err()
+}
+
 // updateConfigFromClowder function updates the current config with the values
 // defined in clowder
 func updateConfigFromClowder(c *ConfigStruct) error {
@@ -77,10 +77,34 @@ func TestLoadConfigurationFromEnvVariable(t *testing.T) {
 // TestLoadConfigurationNonEnvVarUnknownConfigFile tests loading an unexisting
 // config file when no environment variable is provided
 func TestLoadConfigurationNonEnvVarUnknownConfigFile(t *testing.T) {
+	os.Clearenv()
+
+	// configuration is read from environment variables only
+	mustSetEnv(t, "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__DB_DRIVER", "sqlite3")
+	mustSetEnv(t, "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__SQLITE_DATASOURCE", ":memory:")
+
 	_, err := main.LoadConfiguration("", "foobar")
 	assert.Nil(t, err)
 }
 
+// TestLoadConfigurationInvalidValues tests that all invalid values are
+// reported when configuration is loaded
+func TestLoadConfigurationInvalidValues(t *testing.T) {
+	os.Clearenv()
+
+	mustSetEnv(t, "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__DB_DRIVER", "postgres")
+	mustSetEnv(t, "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER__STORAGE__PG_PORT", "123456")
+
+	_, err := main.LoadConfiguration("", "foobar")
+	assert.Error(t, err)
+
+	configurationError, ok := err.(*main.ConfigurationError)
+	assert.True(t, ok, "ConfigurationError is expected")
+	assert.Len(t, configurationError.Problems, 4)
+	assert.Contains(t, err.Error(), "storage.pg_port: must be in range 1-65535, found 123456")
+	assert.Contains(t, err.Error(), "storage.pg_host: must not be empty")
+}
+
 // TestLoadConfigurationBadConfigFile tests loading an unexisting config file when no environment variable is provided
 func TestLoadConfigurationBadConfigFile(t *testing.T) {
 	_, err := main.LoadConfiguration("", "tests/config3")
@@ -225,6 +249,20 @@ func TestLoadSentryConfiguration(t *testing.T) {
 	assert.Equal(t, "test_env", sentryCfg.SentryEnvironment)
 }
 
+// TestLoadCastsConfiguration tests loading the casts configuration sub-tree
+func TestLoadCastsConfiguration(t *testing.T) {
+	envVar := "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE"
+	mustSetEnv(t, envVar, "tests/config2")
+	config, err := main.LoadConfiguration(envVar, "")
+	assert.Nil(t, err, "Failed loading configuration file from env var!")
+
+	castsCfg := main.GetCastsConfiguration(&config)
+
+	assert.Equal(t, main.CastsConfiguration{
+		"report": {"report": "report::text"},
+	}, castsCfg)
+}
+
 // TestLoadS3Configuration tests loading the S3 configuration sub-tree
 func TestLoadS3Configuration(t *testing.T) {
 	envVar := "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE"
@@ -239,6 +277,152 @@ func TestLoadS3Configuration(t *testing.T) {
 	assert.Equal(t, "test_path", S3Cfg.Prefix)
 }
 
+// TestLoadMetricsConfiguration tests loading the metrics configuration
+// sub-tree
+func TestLoadMetricsConfiguration(t *testing.T) {
+	envVar := "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE"
+	mustSetEnv(t, envVar, "tests/config2")
+	config, err := main.LoadConfiguration(envVar, "")
+	assert.Nil(t, err, "Failed loading configuration file from env var!")
+
+	metricsCfg := main.GetMetricsConfiguration(&config)
+
+	assert.Equal(t, "/tmp/exporter.prom", metricsCfg.TextfilePath)
+}
+
+// TestLoadConfigurationEnvInterpolation tests that ${ENV_VAR} references in
+// configuration file are resolved
+func TestLoadConfigurationEnvInterpolation(t *testing.T) {
+	os.Clearenv()
+
+	envVar := "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE"
+	mustSetEnv(t, envVar, "tests/config4")
+	mustSetEnv(t, "EXPORTER_TEST_ENVIRONMENT", "stage")
+	mustSetEnv(t, "EXPORTER_TEST_DATASOURCE", ":memory:")
+
+	config, err := main.LoadConfiguration(envVar, "")
+	assert.NoError(t, err)
+
+	s3Cfg := main.GetS3Configuration(&config)
+	assert.Equal(t, "exports-stage", s3Cfg.Bucket)
+	assert.Equal(t, "stage/stage", s3Cfg.Prefix)
+	assert.Equal(t, ":memory:", main.GetStorageConfiguration(&config).SQLiteDataSource)
+}
+
+// TestLoadConfigurationEnvInterpolationUndefinedVariable tests that
+// references to undefined environment variables are reported
+func TestLoadConfigurationEnvInterpolationUndefinedVariable(t *testing.T) {
+	os.Clearenv()
+
+	envVar := "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE"
+	mustSetEnv(t, envVar, "tests/config4")
+	mustSetEnv(t, "EXPORTER_TEST_DATASOURCE", ":memory:")
+
+	_, err := main.LoadConfiguration(envVar, "")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.bucket: environment variable EXPORTER_TEST_ENVIRONMENT is not defined; "+
+		"s3.prefix: environment variable EXPORTER_TEST_ENVIRONMENT is not defined; "+
+		"s3.prefix: environment variable EXPORTER_TEST_ENVIRONMENT is not defined")
+}
+
+// TestInterpolateString tests the function interpolateString
+func TestInterpolateString(t *testing.T) {
+	os.Clearenv()
+	mustSetEnv(t, "EXPORTER_TEST_ENVIRONMENT", "prod")
+
+	result, undefined := main.InterpolateString("exports-${EXPORTER_TEST_ENVIRONMENT}")
+	assert.Equal(t, "exports-prod", result)
+	assert.Empty(t, undefined)
+
+	// only ${ENV_VAR} form is interpolated, so $ can be used in passwords
+	result, undefined = main.InterpolateString("pa$$word$EXPORTER_TEST_ENVIRONMENT")
+	assert.Equal(t, "pa$$word$EXPORTER_TEST_ENVIRONMENT", result)
+	assert.Empty(t, undefined)
+
+	result, undefined = main.InterpolateString("${UNDEFINED_VARIABLE}")
+	assert.Equal(t, "", result)
+	assert.Equal(t, []string{"UNDEFINED_VARIABLE"}, undefined)
+
+	// $${ is written as literal ${
+	result, undefined = main.InterpolateString("$${EXPORTER_TEST_ENVIRONMENT}-${EXPORTER_TEST_ENVIRONMENT}")
+	assert.Equal(t, "${EXPORTER_TEST_ENVIRONMENT}-prod", result)
+	assert.Empty(t, undefined)
+
+	result, undefined = main.InterpolateString("$${UNDEFINED_VARIABLE} $${")
+	assert.Equal(t, "${UNDEFINED_VARIABLE} ${", result)
+	assert.Empty(t, undefined)
+}
+
+// TestInterpolateEnvVariablesNestedOptions tests that ${ENV_VAR} references
+// are resolved in maps and nested options of the whole configuration
+func TestInterpolateEnvVariablesNestedOptions(t *testing.T) {
+	os.Clearenv()
+	mustSetEnv(t, "EXPORTER_TEST_ENVIRONMENT", "prod")
+
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{
+			Directories: []string{"/exports/${EXPORTER_TEST_ENVIRONMENT}"},
+		},
+		ObjectNames: main.ObjectNamesConfiguration{
+			"report": "${EXPORTER_TEST_ENVIRONMENT}_reports",
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {"cluster": "constant:${EXPORTER_TEST_ENVIRONMENT}"},
+		},
+	}
+
+	assert.NoError(t, main.InterpolateEnvVariables(&configuration))
+	assert.Equal(t, []string{"/exports/prod"}, configuration.Export.Directories)
+	assert.Equal(t, "prod_reports", configuration.ObjectNames["report"])
+	assert.Equal(t, "constant:prod", configuration.Masking["report"]["cluster"])
+}
+
+// TestInterpolateEnvVariablesSQLSections tests that SQL in casts, custom
+// queries and public masks is not interpolated
+func TestInterpolateEnvVariablesSQLSections(t *testing.T) {
+	os.Clearenv()
+
+	configuration := main.ConfigStruct{
+		Casts: main.CastsConfiguration{
+			"report": {"report": "'${UNDEFINED_VARIABLE}' || report"},
+		},
+		Queries: main.QueriesConfiguration{
+			"templates": "SELECT '${name}', '$${name}' AS template",
+		},
+		Split: main.SplitConfiguration{
+			PublicMasks: main.CastsConfiguration{
+				"report": {"cluster": "'${name}'"},
+			},
+		},
+	}
+
+	assert.NoError(t, main.InterpolateEnvVariables(&configuration))
+	assert.Equal(t, "'${UNDEFINED_VARIABLE}' || report", configuration.Casts["report"]["report"])
+	assert.Equal(t, "SELECT '${name}', '$${name}' AS template", configuration.Queries["templates"])
+	assert.Equal(t, "'${name}'", configuration.Split.PublicMasks["report"]["cluster"])
+}
+
+// TestInterpolateEnvVariablesNestedUndefinedVariable tests that references
+// to undefined variables in maps are reported with full option name
+func TestInterpolateEnvVariablesNestedUndefinedVariable(t *testing.T) {
+	os.Clearenv()
+
+	configuration := main.ConfigStruct{
+		ObjectNames: main.ObjectNamesConfiguration{
+			"rule_hit": "${UNDEFINED_VARIABLE}",
+			"report":   "${UNDEFINED_VARIABLE}",
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {"cluster": "constant:${UNDEFINED_VARIABLE}"},
+		},
+	}
+
+	assert.EqualError(t, main.InterpolateEnvVariables(&configuration), "invalid configuration: "+
+		"masking.report.cluster: environment variable UNDEFINED_VARIABLE is not defined; "+
+		"object_names.report: environment variable UNDEFINED_VARIABLE is not defined; "+
+		"object_names.rule_hit: environment variable UNDEFINED_VARIABLE is not defined")
+}
+
 // TestLoadConfigurationFromEnvVariableClowderEnabled tests loading the config.
 // file for testing from an environment variable. Clowder config is enabled in
 // this case.
@@ -0,0 +1,603 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions to validate values read from
+// configuration file and from environment variables. All problems are
+// collected and reported at once, so the user does not need to fix them one
+// by one.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/configcheck.html
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Range of valid TCP ports
+const (
+	minPort = 1
+	maxPort = 65535
+)
+
+// Descriptions of configuration problems
+const (
+	mustNotBeEmpty            = "must not be empty"
+	mustNotBeNegative         = "must not be negative, found %d"
+	mustBePositive            = "must be positive, found %d"
+	durationMustNotBeNegative = "must not be negative, found %v"
+	portOutOfRange            = "must be in range 1-65535, found %d"
+	unsupportedDriver         = "unsupported driver %q, use postgres or sqlite3"
+	undefinedEnvVariable      = "environment variable %s is not defined"
+	notDirectory              = "%s is not existing directory"
+	checkpointsNeedChunks     = "tables are read in chunks only when storage.chunk_size is set"
+	outputNotEncrypted        = "%s output can't be encrypted"
+	encryptedManifest         = "manifest of encrypted export can't be read by next run"
+)
+
+// ConfigurationProblem describes one invalid configuration option
+type ConfigurationProblem struct {
+	Option  string
+	Problem string
+}
+
+// ConfigurationError is returned when configuration contains invalid values.
+// It contains all problems found during validation.
+type ConfigurationError struct {
+	Problems []ConfigurationProblem
+}
+
+// Error method returns all problems in one human readable message
+func (e *ConfigurationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Option+": "+problem.Problem)
+	}
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// configurationChecker collects configuration problems
+type configurationChecker struct {
+	problems []ConfigurationProblem
+}
+
+// report method records one configuration problem
+func (c *configurationChecker) report(option, problem string) {
+	c.problems = append(c.problems, ConfigurationProblem{option, problem})
+}
+
+// nonEmpty method checks that given option is set
+func (c *configurationChecker) nonEmpty(option, value string) {
+	if strings.TrimSpace(value) == "" {
+		c.report(option, mustNotBeEmpty)
+	}
+}
+
+// directory method checks that given option refers to existing directory
+func (c *configurationChecker) directory(option, value string) {
+	if strings.TrimSpace(value) == "" {
+		c.report(option, mustNotBeEmpty)
+		return
+	}
+	info, err := os.Stat(value)
+	if err != nil || !info.IsDir() {
+		c.report(option, fmt.Sprintf(notDirectory, value))
+	}
+}
+
+// port method checks that given option contains valid TCP port
+func (c *configurationChecker) port(option string, value int) {
+	if value < minPort || value > maxPort {
+		c.report(option, fmt.Sprintf(portOutOfRange, value))
+	}
+}
+
+// err method returns ConfigurationError when any problem has been found
+func (c *configurationChecker) err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return &ConfigurationError{Problems: c.problems}
+}
+
+// validateConfiguration function checks values that does not depend on
+// operation selected on command line
+func validateConfiguration(config *ConfigStruct) error {
+	var checker configurationChecker
+
+	storage := config.Storage
+	switch storage.Driver {
+	case "postgres":
+		checker.nonEmpty("storage.pg_host", storage.PGHost)
+		checker.port("storage.pg_port", storage.PGPort)
+		checker.nonEmpty("storage.pg_db_name", storage.PGDBName)
+		checker.nonEmpty("storage.pg_username", storage.PGUsername)
+	case "sqlite3":
+		checker.nonEmpty("storage.sqlite_datasource", storage.SQLiteDataSource)
+	default:
+		checker.report("storage.db_driver", fmt.Sprintf(unsupportedDriver, storage.Driver))
+	}
+
+	for i, schema := range storage.Schemas {
+		checker.nonEmpty(fmt.Sprintf("storage.schemas[%d]", i), schema)
+	}
+
+	if storage.ParallelReaders < 0 {
+		checker.report("storage.parallel_readers",
+			fmt.Sprintf(mustNotBeNegative, storage.ParallelReaders))
+	}
+
+	if storage.RetryBudget < 0 {
+		checker.report("storage.retry_budget",
+			fmt.Sprintf(mustNotBeNegative, storage.RetryBudget))
+	}
+
+	if storage.CircuitBreakerThreshold < 0 {
+		checker.report("storage.circuit_breaker_threshold",
+			fmt.Sprintf(mustNotBeNegative, storage.CircuitBreakerThreshold))
+	}
+
+	if storage.QueryTimeout < 0 {
+		checker.report("storage.query_timeout",
+			fmt.Sprintf(durationMustNotBeNegative, storage.QueryTimeout))
+	}
+
+	if storage.ChunkSize < 0 {
+		checker.report("storage.chunk_size",
+			fmt.Sprintf(mustNotBeNegative, storage.ChunkSize))
+	}
+
+	if config.Export.CheckpointTables && storage.ChunkSize == 0 {
+		checker.report("export.checkpoint_tables", checkpointsNeedChunks)
+	}
+
+	if storage.ChunkTargetBytes < 0 {
+		checker.report("storage.chunk_target_bytes",
+			fmt.Sprintf(mustNotBeNegative, storage.ChunkTargetBytes))
+	}
+
+	if storage.ReplicaLagThreshold < 0 {
+		checker.report("storage.replica_lag_threshold",
+			fmt.Sprintf(durationMustNotBeNegative, storage.ReplicaLagThreshold))
+	}
+
+	if err := checkReplicaLagAction(storage.ReplicaLagAction); err != nil {
+		checker.report("storage.replica_lag_action", err.Error())
+	}
+
+	if storage.ExplainMinRows < 0 {
+		checker.report("storage.explain_min_rows",
+			fmt.Sprintf(mustNotBeNegative, storage.ExplainMinRows))
+	}
+
+	schedule := config.Schedule
+
+	if schedule.StartJitter < 0 {
+		checker.report("schedule.start_jitter",
+			fmt.Sprintf(durationMustNotBeNegative, schedule.StartJitter))
+	}
+
+	if schedule.MaxReplicationLag < 0 {
+		checker.report("schedule.max_replication_lag",
+			fmt.Sprintf(durationMustNotBeNegative, schedule.MaxReplicationLag))
+	}
+
+	if schedule.LoadCheckInterval < 0 {
+		checker.report("schedule.load_check_interval",
+			fmt.Sprintf(durationMustNotBeNegative, schedule.LoadCheckInterval))
+	}
+
+	if schedule.MaxStartDelay < 0 {
+		checker.report("schedule.max_start_delay",
+			fmt.Sprintf(durationMustNotBeNegative, schedule.MaxStartDelay))
+	}
+
+	if schedule.MaxActiveConnections < 0 {
+		checker.report("schedule.max_active_connections",
+			fmt.Sprintf(mustNotBeNegative, schedule.MaxActiveConnections))
+	}
+
+	// port is checked only when S3 endpoint is configured
+	if config.S3.EndpointURL != "" {
+		checker.port("s3.endpoint_port", int(config.S3.EndpointPort))
+	}
+
+	s3Timeouts := []struct {
+		option  string
+		timeout time.Duration
+	}{
+		{"s3.stat_timeout", config.S3.StatTimeout},
+		{"s3.put_timeout", config.S3.PutTimeout},
+		{"s3.bucket_exists_timeout", config.S3.BucketExistsTimeout},
+		{"s3.lock_ttl", config.S3.LockTTL},
+		{"s3.retry_backoff", config.S3.RetryBackoff},
+		{"s3.retry_max_backoff", config.S3.RetryMaxBackoff},
+		{"s3.retry_jitter", config.S3.RetryJitter},
+	}
+	for _, s3Timeout := range s3Timeouts {
+		if s3Timeout.timeout < 0 {
+			checker.report(s3Timeout.option,
+				fmt.Sprintf(durationMustNotBeNegative, s3Timeout.timeout))
+		}
+	}
+
+	if isPrefixTemplate(config.S3.Prefix) {
+		// values of placeholders are not known until the run starts
+		data := newPrefixTemplateData(time.Now(), config.Storage.PGDBName, sampleRunID)
+		if _, err := expandPrefix(config.S3.Prefix, data); err != nil {
+			checker.report("s3.prefix", err.Error())
+		}
+	}
+
+	if err := checkS3SignatureVersion(config.S3.SignatureVersion); err != nil {
+		checker.report("s3.signature_version", err.Error())
+	}
+
+	if _, err := s3BucketLookup(config.S3.BucketLookup); err != nil {
+		checker.report("s3.bucket_lookup", err.Error())
+	}
+
+	checker.checkTLS("s3", s3TLS(config.S3))
+
+	if err := checkPartSize(config.S3.PartSize); err != nil {
+		checker.report("s3.part_size", err.Error())
+	}
+
+	if config.S3.UploadConcurrency < 0 {
+		checker.report("s3.upload_concurrency",
+			fmt.Sprintf(mustNotBeNegative, config.S3.UploadConcurrency))
+	}
+
+	if err := checkPresignExpiry(config.S3.PresignExpiry); err != nil {
+		checker.report("s3.presign_expiry", err.Error())
+	}
+
+	if config.S3.Retries < 0 {
+		checker.report("s3.retries", fmt.Sprintf(mustNotBeNegative, config.S3.Retries))
+	}
+
+	if err := checkCompression(config.Export.Compression); err != nil {
+		checker.report("export.compression", err.Error())
+	}
+
+	if config.Export.EncryptionKeyFile != "" {
+		if _, err := readEncryptionKeys(config.Export.EncryptionKeyFile); err != nil {
+			checker.report("export.encryption_key_file", err.Error())
+		}
+		if config.Export.SkipUnchanged {
+			checker.report("export.skip_unchanged", encryptedManifest)
+		}
+	}
+
+	if err := checkInvalidUTF8Handling(config.Export.InvalidUTF8); err != nil {
+		checker.report("export.invalid_utf8", err.Error())
+	}
+
+	if err := checkAuditChecksum(config.Export.AuditChecksum); err != nil {
+		checker.report("export.audit_checksum", err.Error())
+	}
+
+	if config.Export.AuditCheckpointRows < 0 {
+		checker.report("export.audit_checkpoint_rows",
+			fmt.Sprintf(mustNotBeNegative, config.Export.AuditCheckpointRows))
+	}
+
+	if err := checkIdentityColumnsHandling(config.Export.IdentityColumns); err != nil {
+		checker.report("export.identity_columns", err.Error())
+	}
+
+	if err := checkCSVNullValue(config.Export.CSVNullValue); err != nil {
+		checker.report("export.csv_null_value", err.Error())
+	}
+
+	if config.Export.TypeInferenceRows < 0 {
+		checker.report("export.type_inference_rows",
+			fmt.Sprintf(mustNotBeNegative, config.Export.TypeInferenceRows))
+	}
+
+	if config.Export.RunTimeout < 0 {
+		checker.report("export.run_timeout",
+			fmt.Sprintf(durationMustNotBeNegative, config.Export.RunTimeout))
+	}
+
+	if config.Export.TrendWindow < 0 {
+		checker.report("export.trend_window",
+			fmt.Sprintf(durationMustNotBeNegative, config.Export.TrendWindow))
+	}
+
+	if err := checkTrendPeriod(config.Export.TrendPeriod); err != nil {
+		checker.report("export.trend_period", err.Error())
+	}
+
+	for i, pattern := range config.Export.Tables {
+		if _, err := newTableMatcher(pattern); err != nil {
+			checker.report(fmt.Sprintf("export.tables[%d]", i), err.Error())
+		}
+	}
+
+	for i, pattern := range config.Export.ExcludeTables {
+		if _, err := newTableMatcher(pattern); err != nil {
+			checker.report(fmt.Sprintf("export.exclude_tables[%d]", i), err.Error())
+		}
+	}
+
+	checker.checkCasts("casts", config.Casts)
+
+	// custom queries are checked in stable order
+	for _, name := range queryNames(config.Queries) {
+		if err := checkQueryName(name); err != nil {
+			checker.report("queries."+name, err.Error())
+		}
+		if strings.TrimSpace(config.Queries[name]) == "" {
+			checker.report("queries."+name, mustNotBeEmpty)
+		} else if err := checkReadOnlyQuery(config.Queries[name]); err != nil {
+			checker.report("queries."+name, err.Error())
+		}
+	}
+
+	checker.checkIncremental(config)
+	checker.checkProgress(config)
+	checker.checkOrdering(config.Ordering)
+	checker.checkLimits(config.Limits)
+	checker.checkMasking(config.Masking)
+	checker.checkObjectNames(config.ObjectNames)
+	checker.checkOutputProfiles(config.OutputProfiles)
+	checker.checkHooks(config.Hooks)
+	checker.checkSplit(config)
+	checker.checkGoogleSheets(config.GoogleSheets)
+
+	return checker.err()
+}
+
+// checkCasts method checks SQL expressions configured per table and column
+// in given section
+func (c *configurationChecker) checkCasts(section string, casts CastsConfiguration) {
+	// casts are checked in stable order
+	tableNames := make([]string, 0, len(casts))
+	for tableName := range casts {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		columns := make([]string, 0, len(casts[tableName]))
+		for column := range casts[tableName] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			option := section + "." + tableName + "." + column
+			if strings.TrimSpace(casts[tableName][column]) == "" {
+				c.report(option, mustNotBeEmpty)
+			} else if err := checkSQLExpression(casts[tableName][column]); err != nil {
+				c.report(option, err.Error())
+			}
+		}
+	}
+}
+
+// checkSplit method checks classification of tables into public and
+// restricted artifact sets and locations of both sets
+func (c *configurationChecker) checkSplit(config *ConfigStruct) {
+	split := config.Split
+	if !splitConfigured(split) {
+		return
+	}
+
+	for i, pattern := range split.PublicTables {
+		if _, err := newTableMatcher(pattern); err != nil {
+			c.report(fmt.Sprintf("split.public_tables[%d]", i), err.Error())
+		}
+	}
+
+	c.checkCasts("split.public_masks", split.PublicMasks)
+
+	if err := checkArtifactSets(artifactSets(config), s3Output); err != nil {
+		c.report("split.public_prefix", err.Error())
+	}
+}
+
+// checkIncremental method checks columns of incrementally exported tables
+// and options selecting where watermarks are stored
+func (c *configurationChecker) checkIncremental(config *ConfigStruct) {
+	if len(config.Incremental) == 0 {
+		return
+	}
+
+	// columns are checked in stable order
+	tableNames := make([]string, 0, len(config.Incremental))
+	for tableName := range config.Incremental {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		c.nonEmpty("incremental."+tableName, config.Incremental[tableName])
+	}
+
+	stateFile := config.Export.WatermarkStateFile
+	stateObject := config.Export.WatermarkStateObject
+	switch {
+	case stateFile == "" && stateObject == "":
+		c.report("export.watermark_state_file", watermarkStateNotSet)
+	case stateFile != "" && stateObject != "":
+		c.report("export.watermark_state_object", watermarkStateConflict)
+	case stateObject != "":
+		c.nonEmpty("s3.endpoint_url", config.S3.EndpointURL)
+		c.nonEmpty("s3.bucket", config.S3.Bucket)
+	}
+}
+
+// checkProgress method checks where status of running export is written
+func (c *configurationChecker) checkProgress(config *ConfigStruct) {
+	exportConfiguration := config.Export
+
+	if exportConfiguration.ProgressInterval < 0 {
+		c.report("export.progress_interval",
+			fmt.Sprintf(durationMustNotBeNegative, exportConfiguration.ProgressInterval))
+	}
+
+	switch {
+	case exportConfiguration.ProgressFile != "" && exportConfiguration.ProgressObject != "":
+		c.report("export.progress_object", progressStateConflict)
+	case exportConfiguration.ProgressObject != "":
+		c.nonEmpty("s3.endpoint_url", config.S3.EndpointURL)
+		c.nonEmpty("s3.bucket", config.S3.Bucket)
+	}
+}
+
+// checkOrdering method checks columns rows of tables are ordered by
+func (c *configurationChecker) checkOrdering(ordering OrderingConfiguration) {
+	// columns are checked in stable order
+	tableNames := make([]string, 0, len(ordering))
+	for tableName := range ordering {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		if len(splitOrderColumns(ordering[tableName])) == 0 {
+			c.report("ordering."+tableName, mustNotBeEmpty)
+		}
+	}
+}
+
+// checkLimits method checks maximal numbers of rows exported from tables
+func (c *configurationChecker) checkLimits(limits LimitsConfiguration) {
+	// limits are checked in stable order
+	tableNames := make([]string, 0, len(limits))
+	for tableName := range limits {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		if limits[tableName] <= 0 {
+			c.report("limits."+tableName, fmt.Sprintf(mustBePositive, limits[tableName]))
+		}
+	}
+}
+
+// checkMasking method checks masking methods configured per table and
+// column
+func (c *configurationChecker) checkMasking(masking MaskingConfiguration) {
+	// masking methods are checked in stable order
+	tableNames := make([]string, 0, len(masking))
+	for tableName := range masking {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		columns := make([]string, 0, len(masking[tableName]))
+		for column := range masking[tableName] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			if _, err := parseColumnMask(masking[tableName][column]); err != nil {
+				c.report("masking."+tableName+"."+column, err.Error())
+			}
+		}
+	}
+}
+
+// checkHooks method checks configuration of post-processing hooks
+func (c *configurationChecker) checkHooks(hooks HooksConfiguration) {
+	if len(hooks.Command) > 0 {
+		c.nonEmpty("hooks.command[0]", hooks.Command[0])
+	}
+
+	if hooks.Retries < 0 {
+		c.report("hooks.retries", fmt.Sprintf(mustNotBeNegative, hooks.Retries))
+	}
+
+	if hooks.RetryDelay < 0 {
+		c.report("hooks.retry_delay",
+			fmt.Sprintf(durationMustNotBeNegative, hooks.RetryDelay))
+	}
+
+	if hooks.Timeout < 0 {
+		c.report("hooks.timeout",
+			fmt.Sprintf(durationMustNotBeNegative, hooks.Timeout))
+	}
+
+	c.checkTLS("hooks", hooksTLS(hooks))
+}
+
+// validateOutputConfiguration function checks configuration options needed
+// by selected output
+func validateOutputConfiguration(config *ConfigStruct, output string) error {
+	var checker configurationChecker
+
+	// options of all selected outputs are checked
+	for _, output := range parseOutputs(output) {
+		checker.checkOutput(config, output)
+	}
+
+	return checker.err()
+}
+
+// checkOutput method checks configuration options needed by one output
+func (c *configurationChecker) checkOutput(config *ConfigStruct, output string) {
+	switch output {
+	case fileOutput:
+		for i, directory := range config.Export.Directories {
+			c.directory(fmt.Sprintf("export.directories[%d]", i), directory)
+		}
+	case s3Output:
+		c.nonEmpty("s3.endpoint_url", config.S3.EndpointURL)
+		c.nonEmpty("s3.bucket", config.S3.Bucket)
+	case sftpOutput:
+		sftpConfig := config.SFTP
+		c.nonEmpty("sftp.host", sftpConfig.Host)
+		c.nonEmpty("sftp.username", sftpConfig.Username)
+		// default port is used when not set
+		if sftpConfig.Port != 0 {
+			c.port("sftp.port", sftpConfig.Port)
+		}
+		if sftpConfig.Password == "" && sftpConfig.PrivateKeyFile == "" {
+			c.report("sftp.password", sftpAuthenticationNotSet)
+		}
+		if sftpConfig.KnownHostsFile == "" && !sftpConfig.InsecureIgnoreHostKey {
+			c.report("sftp.known_hosts_file", sftpHostKeyCheckNotSet)
+		}
+	case kafkaOutput:
+		if len(config.Kafka.Brokers) == 0 {
+			c.report("kafka.brokers", kafkaBrokersNotSet)
+		}
+		c.nonEmpty("kafka.topic", config.Kafka.Topic)
+	case duckDBOutput:
+		if err := checkDuckDBBinary(duckDBBinary(config)); err != nil {
+			c.report("export.duckdb_binary", err.Error())
+		}
+	}
+
+	// tables are not written as files or objects into these outputs
+	if config.Export.EncryptionKeyFile != "" && (output == kafkaOutput || output == duckDBOutput) {
+		c.report("export.encryption_key_file", fmt.Sprintf(outputNotEncrypted, output))
+	}
+}
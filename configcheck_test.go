@@ -0,0 +1,1068 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/configcheck_test.html
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestValidateConfigurationPostgres checks validation of valid PostgreSQL
+// configuration
+func TestValidateConfigurationPostgres(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:     "postgres",
+			PGUsername: "user",
+			PGHost:     "localhost",
+			PGPort:     5432,
+			PGDBName:   "aggregator",
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  "localhost",
+			EndpointPort: 9000,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+}
+
+// TestValidateConfigurationAllProblems checks that all problems are reported
+// at once
+func TestValidateConfigurationAllProblems(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "mysql",
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  "localhost",
+			EndpointPort: 70000,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		`storage.db_driver: unsupported driver "mysql", use postgres or sqlite3; `+
+		"s3.endpoint_port: must be in range 1-65535, found 70000")
+
+	configurationError, ok := err.(*main.ConfigurationError)
+	assert.True(t, ok)
+	assert.Equal(t, "storage.db_driver", configurationError.Problems[0].Option)
+}
+
+// TestValidateConfigurationSQLite checks validation of SQLite configuration
+func TestValidateConfigurationSQLite(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver: "sqlite3",
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: storage.sqlite_datasource: must not be empty")
+}
+
+// TestValidateOutputConfiguration checks validation of options needed by
+// selected output
+func TestValidateOutputConfiguration(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "file"))
+
+	err := main.ValidateOutputConfiguration(&configuration, "S3")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.endpoint_url: must not be empty; s3.bucket: must not be empty")
+
+	configuration.S3.EndpointURL = "localhost"
+	configuration.S3.Bucket = "bucket"
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "S3"))
+}
+
+// TestValidateFileOutputConfiguration checks validation of directories the
+// exported files are striped across
+func TestValidateFileOutputConfiguration(t *testing.T) {
+	directory := t.TempDir()
+
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{
+			Directories: []string{directory, "", directory + "/missing"},
+		},
+	}
+
+	err := main.ValidateOutputConfiguration(&configuration, "file")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.directories[1]: must not be empty; "+
+		"export.directories[2]: "+directory+"/missing is not existing directory")
+
+	configuration.Export.Directories = []string{directory}
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "file"))
+}
+
+// TestValidateSFTPOutputConfiguration checks validation of options needed by
+// SFTP output
+func TestValidateSFTPOutputConfiguration(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	err := main.ValidateOutputConfiguration(&configuration, "sftp")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"sftp.host: must not be empty; "+
+		"sftp.username: must not be empty; "+
+		"sftp.password: password or private key file needs to be set; "+
+		"sftp.known_hosts_file: known hosts file needs to be set or host key check disabled")
+
+	configuration.SFTP = main.SFTPConfiguration{
+		Host:           "localhost",
+		Port:           100000,
+		Username:       "exporter",
+		PrivateKeyFile: "id_ed25519",
+		KnownHostsFile: "known_hosts",
+	}
+	err = main.ValidateOutputConfiguration(&configuration, "sftp")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"sftp.port: must be in range 1-65535, found 100000")
+
+	// default port is used
+	configuration.SFTP.Port = 0
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "sftp"))
+}
+
+// TestValidateKafkaOutputConfiguration checks options needed by Kafka
+// output
+func TestValidateKafkaOutputConfiguration(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	err := main.ValidateOutputConfiguration(&configuration, "kafka")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"kafka.brokers: at least one Kafka broker needs to be set; "+
+		"kafka.topic: must not be empty")
+
+	configuration.Kafka = main.KafkaConfiguration{
+		Brokers: []string{"localhost:9092"},
+		Topic:   "exports.{table}",
+	}
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "kafka"))
+}
+
+// TestValidateConfigurationCompression checks validation of compression
+// codec
+func TestValidateConfigurationCompression(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			Compression: "zstd",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Export.Compression = "brotli"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.compression: Unknown compression codec: brotli")
+}
+
+// TestValidateConfigurationParallelReaders checks validation of number of
+// parallel readers
+func TestValidateConfigurationParallelReaders(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			ParallelReaders:  -1,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.parallel_readers: must not be negative, found -1")
+}
+
+// TestValidateConfigurationChunkSize checks validation of chunk size and
+// target size of one chunk
+func TestValidateConfigurationChunkSize(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			ChunkSize:        -1,
+			ChunkTargetBytes: -2,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.chunk_size: must not be negative, found -1; "+
+		"storage.chunk_target_bytes: must not be negative, found -2")
+}
+
+// TestValidateConfigurationExplainMinRows checks validation of threshold of
+// recorded query plans
+func TestValidateConfigurationExplainMinRows(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			ExplainQueries:   true,
+			ExplainMinRows:   -10,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.explain_min_rows: must not be negative, found -10")
+}
+
+// TestValidateConfigurationTrend checks validation of window and period of
+// trend of recommendations
+func TestValidateConfigurationTrend(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			TrendWindow: -time.Hour,
+			TrendPeriod: "month",
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.trend_window: must not be negative, found -1h0m0s; "+
+		"export.trend_period: unknown trend period \"month\", day or week can be used")
+}
+
+// TestValidateConfigurationSchedule checks validation of options of
+// scheduled export
+func TestValidateConfigurationSchedule(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Schedule: main.ScheduleConfiguration{
+			StartJitter:          -time.Second,
+			MaxActiveConnections: -1,
+			LoadCheckInterval:    -time.Minute,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"schedule.start_jitter: must not be negative, found -1s; "+
+		"schedule.load_check_interval: must not be negative, found -1m0s; "+
+		"schedule.max_active_connections: must not be negative, found -1")
+}
+
+// TestValidateConfigurationReplicaLag checks validation of replication lag
+// threshold and action
+func TestValidateConfigurationReplicaLag(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:              "sqlite3",
+			SQLiteDataSource:    ":memory:",
+			ReplicaLagThreshold: -time.Minute,
+			ReplicaLagAction:    "ignore",
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.replica_lag_threshold: must not be negative, found -1m0s; "+
+		"storage.replica_lag_action: Unknown action on replication lag: ignore")
+}
+
+// TestValidateConfigurationTablePatterns checks validation of patterns
+// selecting exported tables
+func TestValidateConfigurationTablePatterns(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			Tables:        []string{"report", "/rule_(/"},
+			ExcludeTables: []string{"rule_[a-"},
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.ErrorContains(t, err, "export.tables[1]: wrong table pattern /rule_(/")
+	assert.ErrorContains(t, err, "export.exclude_tables[0]: wrong table pattern rule_[a-")
+}
+
+// TestValidateConfigurationRetries checks validation of retry budget and
+// circuit breaker threshold
+func TestValidateConfigurationRetries(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:                  "sqlite3",
+			SQLiteDataSource:        ":memory:",
+			RetryBudget:             -1,
+			CircuitBreakerThreshold: -2,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.retry_budget: must not be negative, found -1; "+
+		"storage.circuit_breaker_threshold: must not be negative, found -2")
+}
+
+// TestValidateConfigurationQueryTimeout checks validation of query timeout
+func TestValidateConfigurationQueryTimeout(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			QueryTimeout:     -time.Second,
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"storage.query_timeout: must not be negative, found -1s")
+}
+
+// TestValidateConfigurationInvalidUTF8 checks validation of handling of
+// invalid UTF-8
+func TestValidateConfigurationInvalidUTF8(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			InvalidUTF8: "ignore",
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.invalid_utf8: Unknown handling of invalid UTF-8: ignore")
+}
+
+// TestValidateConfigurationCasts checks validation of casts of columns
+func TestValidateConfigurationCasts(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Casts: main.CastsConfiguration{
+			"report": {"report": "report::text"},
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Casts["rule_hit"] = map[string]string{
+		"template_data": " ",
+		"error_key":     "",
+		"rule_fqdn":     "rule_fqdn; DROP TABLE report",
+		"org_id":        "setval('report_seq', 1)",
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"casts.rule_hit.error_key: must not be empty; "+
+		"casts.rule_hit.org_id: function setval is not allowed; "+
+		"casts.rule_hit.rule_fqdn: expression can't contain statement separator; "+
+		"casts.rule_hit.template_data: must not be empty")
+}
+
+// TestValidateConfigurationQueries checks validation of custom queries
+func TestValidateConfigurationQueries(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Queries: main.QueriesConfiguration{
+			"rules_by_org": "SELECT org_id, count(*) FROM rule_hit GROUP BY org_id",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Queries["empty"] = " "
+	configuration.Queries["../hits"] = "SELECT 1"
+	configuration.Queries["purge"] = "DELETE FROM rule_hit"
+	configuration.Queries["two"] = "SELECT 1; DROP TABLE report"
+	configuration.Queries["with"] = "WITH gone AS (DELETE FROM report RETURNING *) SELECT * FROM gone"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"queries.../hits: query name can contain only letters, digits, underscores and dashes; "+
+		"queries.empty: must not be empty; "+
+		"queries.purge: only SELECT statement can be used, found DELETE; "+
+		"queries.two: only one statement can be used; "+
+		"queries.with: keyword DELETE is not allowed")
+}
+
+// TestValidateConfigurationIdentityColumns checks validation of handling of
+// identity columns
+func TestValidateConfigurationIdentityColumns(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+	}
+
+	for _, handling := range []string{"", "override", "skip"} {
+		configuration.Export.IdentityColumns = handling
+		assert.NoError(t, main.ValidateConfiguration(&configuration))
+	}
+
+	configuration.Export.IdentityColumns = "drop"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.identity_columns: Unknown handling of identity columns: drop")
+}
+
+// TestValidateConfigurationCSVNullValue checks validation of value written
+// into CSV instead of NULL
+func TestValidateConfigurationCSVNullValue(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+	}
+
+	for _, nullValue := range []string{"", `\N`, "NULL"} {
+		configuration.Export.CSVNullValue = nullValue
+		assert.NoError(t, main.ValidateConfiguration(&configuration))
+	}
+
+	configuration.Export.CSVNullValue = "a,b"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		`export.csv_null_value: NULL value "a,b" must not contain delimiter, quote or line break`)
+}
+
+// TestValidateConfigurationOrdering checks validation of columns rows of
+// tables are ordered by
+func TestValidateConfigurationOrdering(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Ordering: main.OrderingConfiguration{
+			"report":   "org_id, cluster",
+			"rule_hit": "id",
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Ordering["rule_hit"] = " , "
+	configuration.Ordering["cluster"] = ""
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"ordering.cluster: must not be empty; ordering.rule_hit: must not be empty")
+}
+
+// TestValidateConfigurationS3Signature checks validation of signature
+// version and bucket lookup type of S3 requests
+func TestValidateConfigurationS3Signature(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			SignatureVersion: "v2",
+			BucketLookup:     "path",
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.SignatureVersion = "s3v4"
+	configuration.S3.BucketLookup = "host"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		`s3.signature_version: unknown signature version "s3v4", v2 or v4 can be used; `+
+		`s3.bucket_lookup: unknown bucket lookup "host", auto, dns or path can be used`)
+}
+
+// TestValidateConfigurationS3Timeouts checks validation of timeouts of S3
+// operations
+func TestValidateConfigurationS3Timeouts(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			StatTimeout:         time.Second,
+			PutTimeout:          time.Minute,
+			BucketExistsTimeout: 0,
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.StatTimeout = -time.Second
+	configuration.S3.BucketExistsTimeout = -time.Minute
+	configuration.S3.LockTTL = -time.Hour
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.stat_timeout: must not be negative, found -1s; "+
+		"s3.bucket_exists_timeout: must not be negative, found -1m0s; "+
+		"s3.lock_ttl: must not be negative, found -1h0m0s")
+}
+
+// TestValidateConfigurationLimits checks validation of maximal numbers of
+// rows exported from tables
+func TestValidateConfigurationLimits(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Limits: main.LimitsConfiguration{
+			"report":   1000,
+			"rule_hit": 1,
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Limits["rule_hit"] = 0
+	configuration.Limits["cluster"] = -1
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"limits.cluster: must be positive, found -1; limits.rule_hit: must be positive, found 0")
+}
+
+// TestValidateConfigurationMasking checks validation of masking methods of
+// anonymized columns
+func TestValidateConfigurationMasking(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {
+				"org_id":  "hash",
+				"cluster": "constant:anonymized",
+			},
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Masking["report"]["account"] = "shuffle"
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		`masking.report.account: unknown masking method "shuffle", drop, hash, pseudonym, fake or constant:<value> can be used`)
+}
+
+// TestValidateConfigurationProgress checks validation of options selecting
+// where status of running export is written
+func TestValidateConfigurationProgress(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			ProgressFile:     "status.json",
+			ProgressInterval: time.Minute,
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Export.ProgressObject = "status.json"
+	configuration.Export.ProgressInterval = -time.Second
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.progress_interval: must not be negative, found -1s; "+
+		"export.progress_object: progress_file and progress_object can't be used together")
+
+	configuration.Export.ProgressFile = ""
+	configuration.Export.ProgressInterval = 0
+	err = main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.endpoint_url: must not be empty; s3.bucket: must not be empty")
+}
+
+// TestValidateConfigurationSchemas checks validation of selected schemas
+func TestValidateConfigurationSchemas(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			Schemas:          []string{"public", "archive"},
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Storage.Schemas = []string{"public", " "}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: storage.schemas[1]: must not be empty")
+}
+
+// TestValidateConfigurationAuditChecksum checks validation of hash chain of
+// audited tables
+func TestValidateConfigurationAuditChecksum(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			AuditChecksum:       "sha512",
+			AuditCheckpointRows: 1000,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Export.AuditChecksum = "crc32"
+	configuration.Export.AuditCheckpointRows = -1
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.audit_checksum: unknown checksum algorithm crc32; "+
+		"export.audit_checkpoint_rows: must not be negative, found -1")
+}
+
+// TestValidateConfigurationIncremental checks validation of incrementally
+// exported tables
+func TestValidateConfigurationIncremental(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Incremental: main.IncrementalConfiguration{
+			"report": "reported_at",
+		},
+	}
+
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.watermark_state_file: state file or object needs to be set for incremental export")
+
+	configuration.Export.WatermarkStateFile = "watermarks.json"
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Export.WatermarkStateObject = "watermarks.json"
+	configuration.Incremental["rule_hit"] = ""
+	err = main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"incremental.rule_hit: must not be empty; "+
+		"export.watermark_state_object: watermark_state_file and watermark_state_object can't be used together")
+
+	configuration.Export.WatermarkStateFile = ""
+	delete(configuration.Incremental, "rule_hit")
+	err = main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.endpoint_url: must not be empty; s3.bucket: must not be empty")
+}
+
+// TestValidateConfigurationHooks checks validation of post-processing hooks
+func TestValidateConfigurationHooks(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Hooks: main.HooksConfiguration{
+			Command:    []string{"clamscan", "--no-summary"},
+			Retries:    3,
+			RetryDelay: time.Second,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Hooks = main.HooksConfiguration{
+		Command:    []string{" "},
+		Retries:    -1,
+		RetryDelay: -time.Second,
+		Timeout:    -time.Second,
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"hooks.command[0]: must not be empty; "+
+		"hooks.retries: must not be negative, found -1; "+
+		"hooks.retry_delay: must not be negative, found -1s; "+
+		"hooks.timeout: must not be negative, found -1s")
+}
+
+// TestValidateConfigurationSplit checks validation of export split into
+// public and restricted artifact sets
+func TestValidateConfigurationSplit(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Split: main.SplitConfiguration{
+			PublicTables:     []string{"report", "rule_*"},
+			PublicPrefix:     "public",
+			RestrictedPrefix: "restricted",
+			PublicMasks: main.CastsConfiguration{
+				"report": {"org_id": "0"},
+			},
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	// both sets in one bucket can't share prefix
+	configuration.Split = main.SplitConfiguration{
+		PublicTables: []string{"/[/"},
+		PublicMasks: main.CastsConfiguration{
+			"report": {"org_id": " "},
+		},
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"split.public_tables[0]: wrong table pattern /[/: "+
+		"error parsing regexp: missing closing ]: `[`; "+
+		"split.public_masks.report.org_id: must not be empty; "+
+		"split.public_prefix: public and restricted artifact sets are stored into the same location /")
+
+	// sets can share prefix when they are stored into different buckets
+	configuration.Split = main.SplitConfiguration{
+		PublicTables:     []string{"report"},
+		PublicBucket:     "public",
+		RestrictedBucket: "restricted",
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+}
+
+// TestValidateConfigurationObjectNames checks validation of names of objects
+// tables are exported into
+func TestValidateConfigurationObjectNames(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		ObjectNames: main.ObjectNamesConfiguration{
+			"rule_disable": "rules_disabled_report.csv",
+			"report":       "reports.v2.json",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.ObjectNames = main.ObjectNamesConfiguration{
+		"a_table": "report.csv",
+		"b_table": "report.csv",
+		"c_table": "../report.csv",
+		"d_table": "",
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"object_names.b_table: object name report.csv is used by table a_table too; "+
+		"object_names.c_table: object name can contain only letters, digits, underscores, dashes and dots and it can't start with dot; "+
+		"object_names.d_table: object name can contain only letters, digits, underscores, dashes and dots and it can't start with dot")
+}
+
+// TestValidateConfigurationGoogleSheets checks validation of Google Sheets
+// configuration
+func TestValidateConfigurationGoogleSheets(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		GoogleSheets: main.GoogleSheetsConfiguration{
+			SpreadsheetID:      "spreadsheet",
+			ServiceAccountFile: "account.json",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.GoogleSheets.ServiceAccountFile = ""
+	configuration.GoogleSheets.Timeout = -time.Second
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"google_sheets.service_account_file: service account file needs to be set for spreadsheet; "+
+		"google_sheets.timeout: must not be negative, found -1s")
+}
+
+// TestValidateConfigurationOutputProfiles checks validation of profiles
+// selected for outputs
+func TestValidateConfigurationOutputProfiles(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		OutputProfiles: main.OutputProfilesConfiguration{
+			"file": "excel-eu",
+			"S3":   "default",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.OutputProfiles = main.OutputProfilesConfiguration{
+		"file":  "excel-de",
+		"email": "excel-us",
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"output_profiles.email: output email is not known; "+
+		"output_profiles.file: unknown output profile excel-de, supported profiles: default, excel-eu, excel-us")
+}
+
+// TestValidateConfigurationRunTimeout checks validation of timeout of the
+// whole run
+func TestValidateConfigurationRunTimeout(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			RunTimeout: time.Hour,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Export.RunTimeout = -time.Minute
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.run_timeout: must not be negative, found -1m0s")
+}
+
+// TestValidateConfigurationMultipartUpload checks validation of part size
+// and concurrency of multipart uploads
+func TestValidateConfigurationMultipartUpload(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			PartSize:          64 * 1024 * 1024,
+			UploadConcurrency: 4,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.PartSize = 1024
+	configuration.S3.UploadConcurrency = -1
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.part_size: must be between 5242880 and 5368709120 bytes, found 1024; "+
+		"s3.upload_concurrency: must not be negative, found -1")
+}
+
+// TestValidateConfigurationS3Retries checks validation of retries of S3
+// uploads
+func TestValidateConfigurationS3Retries(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			Retries:         3,
+			RetryBackoff:    time.Second,
+			RetryMaxBackoff: 30 * time.Second,
+			RetryJitter:     500 * time.Millisecond,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.Retries = -1
+	configuration.S3.RetryJitter = -time.Second
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.retry_jitter: must not be negative, found -1s; "+
+		"s3.retries: must not be negative, found -1")
+}
+
+// TestValidateConfigurationTLS checks validation of TLS settings of
+// destinations
+func TestValidateConfigurationTLS(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			TLSMinVersion:   "1.2",
+			TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.TLSCipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "NULL"}
+	configuration.Hooks.TLSMinVersion = "1.4"
+	configuration.GoogleSheets = main.GoogleSheetsConfiguration{
+		SpreadsheetID:      "spreadsheet",
+		ServiceAccountFile: "account.json",
+		TLSMinVersion:      "TLS13",
+	}
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		`s3.tls_cipher_suites[1]: unknown or insecure cipher suite "NULL"; `+
+		`hooks.tls_min_version: unknown TLS version "1.4", 1.0, 1.1, 1.2 or 1.3 can be used; `+
+		`google_sheets.tls_min_version: unknown TLS version "TLS13", 1.0, 1.1, 1.2 or 1.3 can be used`)
+}
+
+// TestValidateConfigurationTableCheckpoints checks that checkpoints of
+// tables require reading tables in chunks
+func TestValidateConfigurationTableCheckpoints(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+			ChunkSize:        10000,
+		},
+		Export: main.ExportConfiguration{
+			CheckpointTables: true,
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.Storage.ChunkSize = 0
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.checkpoint_tables: tables are read in chunks only when storage.chunk_size is set")
+}
+
+// TestValidateConfigurationEncryption checks validation of encryption key
+// file and outputs that can't be encrypted
+func TestValidateConfigurationEncryption(t *testing.T) {
+	_, fileName := mustCreateEncryptionKey(t)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		Export: main.ExportConfiguration{
+			Compression:       "gzip",
+			EncryptionKeyFile: fileName,
+		},
+		Kafka: main.KafkaConfiguration{
+			Brokers: []string{"localhost:9092"},
+			Topic:   "exports",
+		},
+	}
+
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "file"))
+
+	err := main.ValidateOutputConfiguration(&configuration, "kafka")
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.encryption_key_file: kafka output can't be encrypted")
+
+	// manifest of previous encrypted run can't be read
+	configuration.Export.SkipUnchanged = true
+	err = main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"export.skip_unchanged: manifest of encrypted export can't be read by next run")
+	configuration.Export.SkipUnchanged = false
+
+	configuration.Export.EncryptionKeyFile = filepath.Join(t.TempDir(), "missing.asc")
+	err = main.ValidateConfiguration(&configuration)
+	assert.ErrorContains(t, err, "export.encryption_key_file: open ")
+}
+
+// TestValidateConfigurationPrefixTemplate checks that placeholders in S3
+// prefix are checked
+func TestValidateConfigurationPrefixTemplate(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			Prefix: "exports/{{.Date}}T{{.Time}}/{{.RunID}}",
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.Prefix = "exports/{{.Date"
+	err := main.ValidateConfiguration(&configuration)
+	assert.ErrorContains(t, err, "s3.prefix: wrong prefix template: ")
+
+	configuration.S3.Prefix = "exports/{{.Cluster}}"
+	err = main.ValidateConfiguration(&configuration)
+	assert.ErrorContains(t, err, "s3.prefix: wrong prefix template: ")
+}
+
+// TestValidateConfigurationPresignExpiry checks that validity of presigned
+// URLs is checked
+func TestValidateConfigurationPresignExpiry(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: ":memory:",
+		},
+		S3: main.S3Configuration{
+			PresignExpiry: 24 * time.Hour,
+		},
+	}
+	assert.NoError(t, main.ValidateConfiguration(&configuration))
+
+	configuration.S3.PresignExpiry = 30 * 24 * time.Hour
+	err := main.ValidateConfiguration(&configuration)
+	assert.EqualError(t, err, "invalid configuration: "+
+		"s3.presign_expiry: must not be longer than 168h0m0s, found 720h0m0s")
+}
+
+// TestValidateDuckDBOutputConfiguration checks that DuckDB command line tool
+// needed by DuckDB output is found
+func TestValidateDuckDBOutputConfiguration(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{
+			DuckDBBinary: "this-duckdb-does-not-exist",
+		},
+	}
+
+	err := main.ValidateOutputConfiguration(&configuration, "duckdb")
+	assert.ErrorContains(t, err, "invalid configuration: "+
+		"export.duckdb_binary: DuckDB command line tool this-duckdb-does-not-exist "+
+		"needed by duckdb output not found, install it or set path to it: ")
+
+	// any program that can be run passes the check
+	executable, err := os.Executable()
+	assert.NoError(t, err)
+	configuration.Export.DuckDBBinary = executable
+	assert.NoError(t, main.ValidateOutputConfiguration(&configuration, "duckdb"))
+}
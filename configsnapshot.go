@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains snapshot of configuration used by the export.
+// Effective configuration (after environment variables and Clowder settings
+// are applied) is stored as `_config.toml` next to exported data, so it is
+// possible to find out later how the given export has been produced. All
+// secrets are redacted.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/configsnapshot.html
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/BurntSushi/toml"
+)
+
+// name of file or object with configuration snapshot
+const configSnapshotFile = "_config.toml"
+
+// content type of configuration snapshot
+const tomlContentType = "application/toml"
+
+// value written instead of secrets
+const redactedValue = "<redacted>"
+
+// redact function hides non-empty secret value
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// RedactConfiguration function returns copy of configuration with all
+// secrets (passwords, keys, Sentry DSN, webhook URL that usually contains
+// token) redacted
+func RedactConfiguration(configuration ConfigStruct) ConfigStruct {
+	configuration.Storage.PGPassword = redact(configuration.Storage.PGPassword)
+	configuration.S3.AccessKeyID = redact(configuration.S3.AccessKeyID)
+	configuration.S3.SecretAccessKey = redact(configuration.S3.SecretAccessKey)
+	configuration.SFTP.Password = redact(configuration.SFTP.Password)
+	configuration.Sentry.SentryDSN = redact(configuration.Sentry.SentryDSN)
+	configuration.Hooks.WebhookURL = redact(configuration.Hooks.WebhookURL)
+	return configuration
+}
+
+// ConfigurationToTOML function writes redacted configuration in TOML format
+func ConfigurationToTOML(writer io.Writer, configuration *ConfigStruct) error {
+	return toml.NewEncoder(writer).Encode(RedactConfiguration(*configuration))
+}
+
+// artifactSinks function constructs sinks for artifacts stored after the
+// export finished. Files exported for outputs other than sinks and S3 are
+// written into output directory. Artifacts are compressed by given codec and
+// encrypted by configured public keys.
+func artifactSinks(configuration *ConfigStruct, cliFlags CliFlags, compression string) ([]Sink, error) {
+	outputs := parseOutputs(cliFlags.Output)
+	if !exportedIntoSinks(cliFlags.Output) && exportOutput(cliFlags) != s3Output {
+		outputs = []string{fileOutput}
+	}
+
+	encryption, err := artifactEncryption(configuration, cliFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSinks(configuration, outputs, SinkOptions{
+		Directory:   cliFlags.OutputDirectory,
+		Compression: compression,
+		Encryption:  encryption,
+		Checksums:   GetExportConfiguration(configuration).Checksums,
+	})
+}
+
+// storeConfigSnapshot function stores redacted configuration into the
+// output the data have been exported into
+func storeConfigSnapshot(configuration *ConfigStruct, cliFlags CliFlags) (int, error) {
+	buffer := new(bytes.Buffer)
+	err := ConfigurationToTOML(buffer, configuration)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	sinks, err := artifactSinks(configuration, cliFlags,
+		artifactCompression(configuration))
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	return storeObjectIntoSinks(sinks, configSnapshotFile,
+		ObjectMeta{ContentType: tomlContentType}, buffer.Bytes())
+}
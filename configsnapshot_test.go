@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/configsnapshot_test.html
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// snapshotConfig returns configuration with secrets used by tests
+func snapshotConfig() main.ConfigStruct {
+	var config main.ConfigStruct
+	config.Storage.Driver = "postgres"
+	config.Storage.PGUsername = "user"
+	config.Storage.PGPassword = "password"
+	config.Storage.QueryTimeout = 30 * time.Second
+	config.S3.Bucket = "bucket"
+	config.S3.AccessKeyID = "key"
+	config.S3.SecretAccessKey = "secret"
+	config.Sentry.SentryDSN = "https://key@sentry.example.com/1"
+	config.Export.Tables = []string{"report", "rule_*"}
+	return config
+}
+
+// TestRedactConfiguration checks the function RedactConfiguration
+func TestRedactConfiguration(t *testing.T) {
+	config := snapshotConfig()
+
+	redacted := main.RedactConfiguration(config)
+
+	assert.Equal(t, "<redacted>", redacted.Storage.PGPassword)
+	assert.Equal(t, "<redacted>", redacted.S3.AccessKeyID)
+	assert.Equal(t, "<redacted>", redacted.S3.SecretAccessKey)
+	assert.Equal(t, "<redacted>", redacted.Sentry.SentryDSN)
+
+	// secrets that are not set are not reported as redacted
+	assert.Equal(t, "", redacted.SFTP.Password)
+
+	// other values are kept
+	assert.Equal(t, "user", redacted.Storage.PGUsername)
+	assert.Equal(t, "bucket", redacted.S3.Bucket)
+
+	// original configuration is not changed
+	assert.Equal(t, "password", config.Storage.PGPassword)
+}
+
+// secretOption is regular expression matching names of options that contain
+// secrets
+var secretOption = regexp.MustCompile(`password|secret|key_id|dsn|token|webhook_url`)
+
+// setSecretOptions helper function walks configuration structure and calls
+// given function for every string option with secret
+func setSecretOptions(value reflect.Value, path string, visit func(string, reflect.Value)) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := path + value.Type().Field(i).Tag.Get("toml")
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			setSecretOptions(field, name+".", visit)
+		case field.Kind() == reflect.String && secretOption.MatchString(name):
+			visit(name, field)
+		}
+	}
+}
+
+// TestRedactConfigurationAllSecrets checks that all options with secrets
+// are redacted
+func TestRedactConfigurationAllSecrets(t *testing.T) {
+	var config main.ConfigStruct
+	var secrets []string
+	setSecretOptions(reflect.ValueOf(&config).Elem(), "",
+		func(name string, field reflect.Value) {
+			secrets = append(secrets, name)
+			field.SetString("value-of-" + name)
+		})
+
+	assert.ElementsMatch(t, []string{
+		"storage.pg_password",
+		"s3.access_key_id",
+		"s3.secret_access_key",
+		"sftp.password",
+		"sentry.dsn",
+		"hooks.webhook_url",
+	}, secrets)
+
+	redacted := main.RedactConfiguration(config)
+	setSecretOptions(reflect.ValueOf(&redacted).Elem(), "",
+		func(name string, field reflect.Value) {
+			assert.Equal(t, "<redacted>", field.String(), name)
+		})
+}
+
+// TestConfigurationToTOML checks that configuration snapshot can be read
+// back as configuration
+func TestConfigurationToTOML(t *testing.T) {
+	config := snapshotConfig()
+
+	buffer := new(bytes.Buffer)
+	err := main.ConfigurationToTOML(buffer, &config)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, buffer.String(), "password\"")
+	assert.NotContains(t, buffer.String(), "secret\"")
+
+	var snapshot main.ConfigStruct
+	_, err = toml.Decode(buffer.String(), &snapshot)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "postgres", snapshot.Storage.Driver)
+	assert.Equal(t, "<redacted>", snapshot.Storage.PGPassword)
+	assert.Equal(t, 30*time.Second, snapshot.Storage.QueryTimeout)
+	assert.Equal(t, []string{"report", "rule_*"}, snapshot.Export.Tables)
+}
+
+// TestStoreConfigSnapshotIntoFile checks that configuration snapshot is
+// written into output directory
+func TestStoreConfigSnapshotIntoFile(t *testing.T) {
+	config := snapshotConfig()
+	directory := t.TempDir()
+
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	status, err := main.StoreConfigSnapshot(&config, cliFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_config.toml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "pg_username = \"user\"")
+	assert.Contains(t, string(content), "pg_password = \"<redacted>\"")
+}
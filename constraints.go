@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of primary keys, unique constraints,
+// foreign keys and indexes of exported tables. They are exported together
+// with metadata into `_constraints.csv` (one row per constraint or index with
+// its SQL definition), so the exports can be used for capacity and integrity
+// analysis. Constraints are read from PostgreSQL only.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/constraints.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// name of file or object with constraints and indexes
+const constraintsFile = "_constraints.csv"
+
+// SQL statement used to read constraints and indexes of given table
+const selectTableConstraints = `
+        SELECT kind, name, definition
+          FROM (SELECT CASE contype
+                            WHEN 'p' THEN 'primary key'
+                            WHEN 'u' THEN 'unique'
+                            ELSE 'foreign key'
+                       END AS kind,
+                       conname AS name,
+                       pg_get_constraintdef(oid) AS definition
+                  FROM pg_catalog.pg_constraint
+                 WHERE conrelid = $1::regclass
+                   AND contype IN ('p', 'u', 'f')
+                 UNION ALL
+                SELECT 'index', c.relname, pg_get_indexdef(i.indexrelid)
+                  FROM pg_catalog.pg_index i
+                  JOIN pg_catalog.pg_class c ON c.oid = i.indexrelid
+                 WHERE i.indrelid = $1::regclass) AS constraints
+         ORDER BY kind, name`
+
+// messages
+const (
+	exportingConstraints  = "Exporting constraints and indexes"
+	readConstraintsFailed = "Read constraints and indexes failed"
+)
+
+// ConstraintInfo contains one constraint or index of table: its kind
+// (primary key, unique, foreign key or index), name and SQL definition
+type ConstraintInfo struct {
+	Table      TableName
+	Kind       string
+	Name       string
+	Definition string
+}
+
+// ReadTableConstraints method reads primary key, unique constraints, foreign
+// keys and indexes of given table. Empty list is returned for databases
+// other than PostgreSQL.
+func (storage DBStorage) ReadTableConstraints(ctx context.Context, tableName TableName) ([]ConstraintInfo, error) {
+	constraints := make([]ConstraintInfo, 0)
+
+	if storage.dbDriverType != DBDriverPostgres {
+		return constraints, nil
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectTableConstraints, string(tableName))
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectTableConstraints).Msg(sqlStatementExecutionError)
+		return constraints, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		constraint := ConstraintInfo{Table: tableName}
+
+		err := rows.Scan(&constraint.Kind, &constraint.Name, &constraint.Definition)
+		if err != nil {
+			return constraints, err
+		}
+
+		constraints = append(constraints, constraint)
+	}
+
+	return constraints, rows.Err()
+}
+
+// ConstraintsToCSV function exports list of constraints and indexes into
+// CSV
+func ConstraintsToCSV(buffer io.Writer, constraints []ConstraintInfo) error {
+	if buffer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	writer, release := newCSVWriter(buffer)
+	defer release()
+
+	err := writer.Write([]string{"Table name", "Kind", "Name", "Definition"})
+	if err != nil {
+		return err
+	}
+
+	for _, constraint := range constraints {
+		err := writer.Write([]string{string(constraint.Table), constraint.Kind,
+			constraint.Name, constraint.Definition})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	// check for any error during export to CSV
+	return writer.Error()
+}
+
+// constraintsIntoCSV method reads constraints and indexes of all given
+// tables and serializes them into CSV
+func (storage DBStorage) constraintsIntoCSV(ctx context.Context, tableNames []TableName) ([]byte, error) {
+	var constraints []ConstraintInfo
+
+	for _, tableName := range tableNames {
+		tableConstraints, err := storage.ReadTableConstraints(ctx, tableName)
+		if err != nil {
+			storage.logger.Err(err).Str(tableNameMsg, string(tableName)).Msg(readConstraintsFailed)
+			return nil, err
+		}
+		constraints = append(constraints, tableConstraints...)
+	}
+
+	buffer := new(bytes.Buffer)
+	err := ConstraintsToCSV(buffer, constraints)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
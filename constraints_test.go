@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/constraints_test.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// expected query to read constraints and indexes
+const readConstraintsQuery = `SELECT kind, name, definition\s+FROM \(SELECT CASE contype`
+
+// TestReadTableConstraints checks the method ReadTableConstraints
+func TestReadTableConstraints(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"kind", "name", "definition"})
+	rows.AddRow("foreign key", "report_org_fk", "FOREIGN KEY (org_id) REFERENCES org(id)")
+	rows.AddRow("index", "report_pkey", "CREATE UNIQUE INDEX report_pkey ON public.report USING btree (id)")
+	rows.AddRow("primary key", "report_pkey", "PRIMARY KEY (id)")
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readConstraintsQuery).WithArgs("report").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	constraints, err := storage.ReadTableConstraints(context.Background(), "report")
+	assert.NoError(t, err)
+	assert.Equal(t, []main.ConstraintInfo{
+		{Table: "report", Kind: "foreign key", Name: "report_org_fk",
+			Definition: "FOREIGN KEY (org_id) REFERENCES org(id)"},
+		{Table: "report", Kind: "index", Name: "report_pkey",
+			Definition: "CREATE UNIQUE INDEX report_pkey ON public.report USING btree (id)"},
+		{Table: "report", Kind: "primary key", Name: "report_pkey",
+			Definition: "PRIMARY KEY (id)"},
+	}, constraints)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableConstraintsError checks the method ReadTableConstraints when
+// the query fails
+func TestReadTableConstraintsError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readConstraintsQuery).WithArgs("report").
+		WillReturnError(errors.New("permission denied"))
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	_, err := storage.ReadTableConstraints(context.Background(), "report")
+	assert.EqualError(t, err, "permission denied")
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableConstraintsSQLite checks that no constraints are read from
+// SQLite
+func TestReadTableConstraintsSQLite(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
+
+	// call the tested method
+	constraints, err := storage.ReadTableConstraints(context.Background(), "report")
+	assert.NoError(t, err)
+	assert.Empty(t, constraints)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestConstraintsToCSV checks the function ConstraintsToCSV
+func TestConstraintsToCSV(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.ConstraintsToCSV(buffer, []main.ConstraintInfo{
+		{Table: "report", Kind: "primary key", Name: "report_pkey",
+			Definition: "PRIMARY KEY (id)"},
+		{Table: "rule_hit", Kind: "unique", Name: "rule_hit_key",
+			Definition: "UNIQUE (org_id, rule_fqdn)"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Table name,Kind,Name,Definition\n"+
+		"report,primary key,report_pkey,PRIMARY KEY (id)\n"+
+		"rule_hit,unique,rule_hit_key,\"UNIQUE (org_id, rule_fqdn)\"\n",
+		buffer.String())
+}
+
+// TestConstraintsToCSVNilBuffer checks the function ConstraintsToCSV with
+// nil buffer
+func TestConstraintsToCSVNilBuffer(t *testing.T) {
+	err := main.ConstraintsToCSV(nil, nil)
+	assert.Error(t, err)
+}
+
+// TestPerformDataExportConstraints checks that constraints are exported with
+// metadata
+func TestPerformDataExportConstraints(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report"),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_constraints.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Table name,Kind,Name,Definition\n", string(content))
+}
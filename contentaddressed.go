@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains content-addressed layout of objects exported
+// into S3. Data object of each table is named by SHA-256 hash of its content
+// and index object maps table names to these objects. Object with the same
+// content is uploaded only once, so exports that share the prefix are
+// deduplicated and content of any object can be verified by its name.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/contentaddressed.html
+
+import (
+	"context"
+)
+
+// name of index object that maps tables to content-addressed objects
+const indexObject = "_index.json"
+
+// directory (under prefix) with content-addressed data objects
+const contentObjectsDirectory = "objects"
+
+// messages
+const (
+	contentAlreadyStored     = "Object with the same content is already stored, upload skipped"
+	storeIndexFailed         = "Store index into S3 failed"
+	contentAddressedOnlyS3   = "Content-addressed layout is supported for S3 output only"
+	skipUnchangedNotNeeded   = "Unchanged tables are not detected in content-addressed layout"
+	contentAddressedArchives = "Content-addressed layout is not used for formats storing all tables into one object"
+)
+
+// ContentIndex maps exported tables to content-addressed objects. It has the
+// same structure as manifest, so it can be read by the same tools.
+type ContentIndex struct {
+	index Manifest
+}
+
+// NewContentIndex function constructs new empty index
+func NewContentIndex() *ContentIndex {
+	return &ContentIndex{
+		index: NewManifest(),
+	}
+}
+
+// Record method records table stored into given content-addressed object
+func (index *ContentIndex) Record(tableName TableName, object, hash string,
+	tableAudit *TableAudit) {
+	index.index.Tables[tableName] = ManifestEntry{
+		Object: object,
+		SHA256: hash,
+		Audit:  tableAudit,
+	}
+}
+
+// Index method returns all recorded tables
+func (index *ContentIndex) Index() Manifest {
+	return index.index
+}
+
+// contentObjectName function constructs name of object with given content
+// hash
+func contentObjectName(hash, format string) string {
+	return contentObjectsDirectory + "/" + hash + fileExtension(format)
+}
+
+// contentAddressedObject method constructs name of object the table content
+// is stored into and records it into index. False is returned when the
+// object with the same content has already been stored, so it does not need
+// to be uploaded again.
+func (storage DBStorage) contentAddressedObject(ctx context.Context,
+	session *S3Session, bucketName, prefix string, tableName TableName,
+	format string, data []byte) (string, bool, error) {
+	hash := contentHash(data)
+	objectName := setObjectPrefix(prefix, contentObjectName(hash, format))
+	storedObject := objectName + artifactExtension(storage.compression, storage.encryption)
+
+	exists, err := s3ObjectExists(ctx, session, bucketName, storedObject)
+	if err != nil {
+		return "", false, err
+	}
+
+	// audit of sensitive table is part of index too
+	var tableAudit *TableAudit
+	if audit, found := storage.audit.Table(tableName); found {
+		tableAudit = &audit
+	}
+	storage.contentIndex.Record(tableName, storedObject, hash, tableAudit)
+
+	if exists {
+		storage.logger.Info().Str(contentHashMsg, hash).
+			Msg(contentAlreadyStored)
+		return objectName, false, nil
+	}
+
+	return objectName, true, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/contentaddressed_test.html
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// SHA-256 hash of report table exported into CSV
+const reportHash = "5f383b94d4c901768da89ed50a4a43d1b24a29c80cfce45bca25b5ae9b0a45c3"
+
+// TestContentObjectName checks construction of content-addressed object
+// names
+func TestContentObjectName(t *testing.T) {
+	assert.Equal(t, "objects/"+helloHash+".csv",
+		main.ContentObjectName(helloHash, "csv"))
+	assert.Equal(t, "objects/"+helloHash+".json",
+		main.ContentObjectName(helloHash, "json"))
+}
+
+// TestContentIndex checks recording of tables into index
+func TestContentIndex(t *testing.T) {
+	index := main.NewContentIndex()
+	assert.Empty(t, index.Index().Tables)
+
+	index.Record("report", "objects/"+helloHash+".csv", helloHash, nil)
+	assert.Equal(t, main.ManifestEntry{
+		Object: "objects/" + helloHash + ".csv",
+		SHA256: helloHash,
+	}, index.Index().Tables["report"])
+}
+
+// TestPerformDataExportContentAddressed checks that tables are stored into
+// objects named by their content and that object with the same content is
+// not uploaded again
+func TestPerformDataExportContentAddressed(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+		Export: main.ExportConfiguration{
+			ContentAddressed: true,
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "S3",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	object := "/bucket/prefix/objects/" + reportHash + ".csv"
+	assert.Equal(t, "id,report\n1,first\n2,second\n", string(s3.objects[object]))
+	assert.NotContains(t, s3.objects, "/bucket/prefix/report.csv")
+
+	index, err := main.ReadManifest(bytes.NewReader(s3.objects["/bucket/prefix/_index.json"]))
+	assert.NoError(t, err)
+	assert.Equal(t, main.ManifestEntry{
+		Object: "prefix/objects/" + reportHash + ".csv",
+		SHA256: reportHash,
+	}, index.Tables["report"])
+
+	// object with the same content is not uploaded again
+	s3.objects[object] = []byte("stored before")
+
+	code, err = main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	assert.Equal(t, "stored before", string(s3.objects[object]))
+}
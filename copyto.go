@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains fast path of export of tables into CSV.
+// PostgreSQL formats the content of table itself by COPY TO STDOUT command,
+// so rows don't need to be scanned and converted one by one. The fast path
+// is used only for tables that don't need to be processed row by row
+// (audited and incrementally exported tables). One connection is opened for
+// COPY TO commands of all tables exported by the run.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/copyto.html
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// messages
+const (
+	copyingTableContent = "Copying table content by COPY TO"
+	copyTableFailed     = "Copy table content failed"
+	copiedRowsMsg       = "copied rows"
+)
+
+// copyToCSV is template of COPY command that writes result of given query
+// as CSV with header
+const copyToCSV = "COPY (%s) TO STDOUT WITH CSV HEADER"
+
+// copyNullOption is template of COPY option that selects string written
+// instead of NULL
+const copyNullOption = " NULL '%s'"
+
+// setReadOnlySession makes all transactions of COPY connection read-only
+const setReadOnlySession = "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY"
+
+// copyConnection is connection used by COPY TO commands of all tables
+// exported from one storage. COPY TO is not supported by database/sql
+// driver, so own connection is opened when the first table is copied and it
+// is reused by following tables. Tables are copied one by one.
+type copyConnection struct {
+	mutex      sync.Mutex
+	connection *pgconn.PgConn
+}
+
+// acquire method returns connection used by COPY TO command, the connection
+// is opened when it is not open yet (or when it has been closed because of
+// failure of previous command). All transactions of the connection are
+// read-only, because copied queries can contain casts provided by users.
+// Connection needs to be released when the command finishes.
+func (c *copyConnection) acquire(ctx context.Context, dataSource string) (*pgconn.PgConn, error) {
+	c.mutex.Lock()
+
+	if c.connection != nil && !c.connection.IsClosed() {
+		return c.connection, nil
+	}
+
+	connection, err := pgconn.Connect(ctx, dataSource)
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	_, err = connection.Exec(ctx, setReadOnlySession).ReadAll()
+	if err != nil {
+		_ = connection.Close(context.Background())
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.connection = connection
+	return connection, nil
+}
+
+// release method releases connection acquired for COPY TO command
+func (c *copyConnection) release() {
+	c.mutex.Unlock()
+}
+
+// Close method closes the connection when it has been opened
+func (c *copyConnection) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.connection == nil {
+		return nil
+	}
+	err := c.connection.Close(context.Background())
+	c.connection = nil
+	return err
+}
+
+// copyToApplicable method checks whether given table can be exported by COPY
+// TO command in selected format
+func (storage DBStorage) copyToApplicable(tableName TableName, format string) bool {
+	if storage.config == nil || !storage.config.PGCopy {
+		return false
+	}
+
+	if storage.dbDriverType != DBDriverPostgres || format != csvFormat {
+		return false
+	}
+
+	// audited, incrementally exported, masked and profiled tables need to
+	// see every row, verified tables are read in one snapshot with number
+	// of their records. Rows are quarantined and checked for invalid UTF-8
+	// by the exporter only.
+	masks, _ := lookupTableConfig(storage.masking, tableName)
+	return !storage.audit.Audited(tableName) &&
+		storage.watermarks.Column(tableName) == "" &&
+		len(masks) == 0 &&
+		storage.profile == nil &&
+		!storage.verifyRowCounts &&
+		storage.quarantine == nil &&
+		storage.invalidUTF8 == ""
+}
+
+// copyStatement method constructs COPY command that writes result of given
+// query as CSV, NULL is written as configured
+func (storage DBStorage) copyStatement(sqlStatement string) string {
+	statement := fmt.Sprintf(copyToCSV, sqlStatement)
+	if storage.csvNullValue != "" {
+		statement += fmt.Sprintf(copyNullOption,
+			strings.ReplaceAll(storage.csvNullValue, "'", "''"))
+	}
+	return statement
+}
+
+// copyTableContent method writes content of given table including header
+// into given writer as CSV formatted by PostgreSQL. The table is read by the
+// same query as when it is read row by row, COPY TO commands of all tables
+// share one connection.
+func (storage DBStorage) copyTableContent(ctx context.Context, output io.Writer,
+	tableName TableName, colNames []string, limit int) error {
+	limit = storage.tableLimit(tableName, limit)
+
+	sqlStatement, err := storage.tableContentQuery(ctx, tableName, limit)
+	if err != nil {
+		return err
+	}
+
+	_, _, dataSource, err := initAndGetDriver(storage.config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	started := time.Now()
+	defer func() {
+		storage.summary.AddDuration(stageDataRead, time.Since(started))
+	}()
+
+	connection, err := storage.copyConnection.acquire(ctx, dataSource)
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(copyTableFailed)
+		return err
+	}
+	defer storage.copyConnection.release()
+
+	storage.logger.Info().Msg(copyingTableContent)
+	storage.progress.StartTable(tableName)
+
+	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G201
+	commandTag, err := connection.CopyTo(ctx, output, storage.copyStatement(sqlStatement))
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(copyTableFailed)
+		return err
+	}
+
+	exportedRows := int(commandTag.RowsAffected())
+	storage.logger.Debug().Int(copiedRowsMsg, exportedRows).Msg(copyingTableContent)
+
+	duration := time.Since(started)
+	storage.recordTableMetrics(tableName, exportedRows, 0, duration)
+	storage.summary.AddExportedRows(exportedRows)
+	storage.summary.RecordTable(tableName, colNames, exportedRows)
+	storage.summary.RecordTableThroughput(tableName, exportedRows, duration)
+	storage.progress.AddRows(exportedRows)
+	storage.progress.FinishTable()
+	return nil
+}
@@ -23,6 +23,7 @@ package main
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/csv.html
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -42,7 +43,8 @@ func DisabledRulesToCSV(buffer io.Writer, disabledRulesInfo []DisabledRuleInfo)
 		return err
 	}
 
-	writer := csv.NewWriter(buffer)
+	writer, release := newCSVWriter(buffer)
+	defer release()
 
 	var data = [][]string{{"Rule", "Count"}}
 
@@ -71,14 +73,43 @@ func DisabledRulesToCSV(buffer io.Writer, disabledRulesInfo []DisabledRuleInfo)
 	return nil
 }
 
+// TableNamesToCSV function exports list of table names into CSV file.
+func TableNamesToCSV(buffer io.Writer, tableNames []TableName) error {
+	if buffer == nil {
+		err := errors.New(bufferIsNil)
+		return err
+	}
+
+	writer, release := newCSVWriter(buffer)
+	defer release()
+
+	err := writer.Write([]string{"Table name"})
+	if err != nil {
+		return err
+	}
+
+	for _, tableName := range tableNames {
+		err := writer.Write([]string{string(tableName)})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	// check for any error during export to CSV
+	return writer.Error()
+}
+
 // TableMetadataToCSV function exports list of table names into CSV file.
-func TableMetadataToCSV(buffer io.Writer, tableNames []TableName, storage DBStorage) error {
+func TableMetadataToCSV(ctx context.Context, buffer io.Writer, tableNames []TableName, storage DBStorage) error {
 	if buffer == nil {
 		err := errors.New(bufferIsNil)
 		return err
 	}
 
-	writer := csv.NewWriter(buffer)
+	writer, release := newCSVWriter(buffer)
+	defer release()
 
 	err := writer.Write([]string{"Table name", "Records"})
 	if err != nil {
@@ -87,11 +118,12 @@ func TableMetadataToCSV(buffer io.Writer, tableNames []TableName, storage DBStor
 	}
 
 	for _, tableName := range tableNames {
-		cnt, err := storage.ReadRecordsCount(tableName)
+		cnt, err := storage.ReadRecordsCount(ctx, tableName)
 		if err != nil {
 			log.Error().Err(err).Msg(readListOfRecordsFailed)
 			return err
 		}
+		storage.summary.RecordTableRecords(tableName, cnt)
 
 		columns := []string{string(tableName), strconv.Itoa(cnt)}
 
@@ -24,6 +24,7 @@ package main_test
 
 import (
 	"bytes"
+	"context"
 	"testing"
 
 	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
@@ -98,7 +99,7 @@ func TestTableMetadataToCSVNilBuffer(t *testing.T) {
 	// empty list
 	tableNames := []main.TableName{}
 
-	err := main.TableMetadataToCSV(nil, tableNames, *storage)
+	err := main.TableMetadataToCSV(context.Background(), nil, tableNames, *storage)
 	assert.Error(t, err, "Buffer is nil")
 }
 
@@ -114,7 +115,7 @@ func TestTableMetadataToCSVEmptyListOfRules(t *testing.T) {
 	// empty list
 	tableNames := []main.TableName{}
 
-	err := main.TableMetadataToCSV(buffer, tableNames, *storage)
+	err := main.TableMetadataToCSV(context.Background(), buffer, tableNames, *storage)
 	assert.NoError(t, err, "Error not expected")
 
 	content := buffer.String()
@@ -138,6 +139,6 @@ func TestTableMetadataToCSV(t *testing.T) {
 		main.TableName("third"),
 	}
 
-	err := main.TableMetadataToCSV(buffer, tableNames, *storage)
+	err := main.TableMetadataToCSV(context.Background(), buffer, tableNames, *storage)
 	assert.Error(t, err, "Storage error is not expected")
 }
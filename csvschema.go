@@ -0,0 +1,229 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of schema sidecar files. When enabled,
+// every table exported into CSV is accompanied by small JSON file (for
+// example report.csv.schema.json) with column names, their types and values
+// written into CSV instead of NULL, so pandas or Spark readers can load the
+// data with correct types without guessing.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/csvschema.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// schemaSidecarSuffix is appended to name of CSV file to construct name of
+// its schema sidecar file
+const schemaSidecarSuffix = ".schema.json"
+
+// messages
+const (
+	storeSchemaSidecarFailed = "Store schema sidecar file failed"
+)
+
+// Types of CSV columns written into schema sidecar files
+const (
+	csvBooleanType   = "boolean"
+	csvIntegerType   = "integer"
+	csvNumberType    = "number"
+	csvDecimalType   = "decimal"
+	csvTimestampType = "timestamp"
+	csvDateType      = "date"
+	csvJSONType      = "json"
+	csvBinaryType    = "binary"
+	csvArrayType     = "array"
+	csvStringType    = "string"
+)
+
+// CSVColumnSchema represents one column of table exported into CSV. Type of
+// column reported by database without type is inferred from sampled rows.
+type CSVColumnSchema struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+	Type         string `json:"type"`
+	NullValue    string `json:"null_value"`
+	Inferred     bool   `json:"inferred,omitempty"`
+	InferredType string `json:"inferred_type,omitempty"`
+	SampledRows  int    `json:"sampled_rows,omitempty"`
+}
+
+// CSVSchema represents content of schema sidecar file
+type CSVSchema struct {
+	Table     string            `json:"table"`
+	Header    bool              `json:"header"`
+	Delimiter string            `json:"delimiter"`
+	Columns   []CSVColumnSchema `json:"columns"`
+}
+
+// csvTypeForColumn function returns type of CSV column for given database
+// column type. Types need to follow the types used to scan values from
+// database (see fillInScanArgs), other types are exported as strings.
+func csvTypeForColumn(databaseType string) string {
+	switch databaseType {
+	case "BOOL":
+		return csvBooleanType
+	case "INT2", "INT4", "INT8":
+		return csvIntegerType
+	case "FLOAT4", "FLOAT8":
+		return csvNumberType
+	case "NUMERIC":
+		return csvDecimalType
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return csvTimestampType
+	case "DATE":
+		return csvDateType
+	case "JSON", "JSONB":
+		return csvJSONType
+	case "BYTEA":
+		return csvBinaryType
+	default:
+		if isArrayType(databaseType) {
+			return csvArrayType
+		}
+		return csvStringType
+	}
+}
+
+// schemaSidecarFileName function constructs name of schema sidecar file for
+// given table
+func schemaSidecarFileName(tableName TableName) string {
+	return string(tableName) + CSVFileExtension + schemaSidecarSuffix
+}
+
+// NewCSVSchema function constructs schema of table exported into CSV, NULL
+// is written into all columns as given null value
+func NewCSVSchema(tableName TableName, columns []Column, nullValue string) CSVSchema {
+	schema := CSVSchema{
+		Table:     string(tableName),
+		Header:    true,
+		Delimiter: ",",
+		Columns:   make([]CSVColumnSchema, 0, len(columns)),
+	}
+
+	for _, column := range columns {
+		csvType := csvTypeForColumn(column.DatabaseType)
+		schema.Columns = append(schema.Columns, CSVColumnSchema{
+			Name:         column.Name,
+			DatabaseType: column.DatabaseType,
+			Type:         csvType,
+			NullValue:    nullValue,
+		})
+	}
+
+	return schema
+}
+
+// applyInferredTypes function records types of columns inferred from sampled
+// rows into schema, columns are expected in the same order as in schema
+func applyInferredTypes(schema CSVSchema, columns []InferredColumn) CSVSchema {
+	for i, column := range columns {
+		if column.DatabaseType != "" {
+			continue
+		}
+
+		schema.Columns[i].Inferred = true
+		schema.Columns[i].InferredType = column.InferredType
+		schema.Columns[i].SampledRows = column.SampledRows
+		if column.InferredType != "" {
+			schema.Columns[i].Type = csvTypeForColumn(column.InferredType)
+		}
+	}
+	return schema
+}
+
+// CSVSchemaToJSON function writes schema of table exported into CSV as
+// indented JSON
+func CSVSchemaToJSON(writer io.Writer, schema CSVSchema) error {
+	if writer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema)
+}
+
+// schemaSidecarIntoJSON method reads columns of given table and returns
+// content of its schema sidecar file
+func (storage DBStorage) schemaSidecarIntoJSON(ctx context.Context,
+	tableName TableName) ([]byte, error) {
+	columnTypes, err := storage.RetrieveColumnTypes(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// columns without type are not exported as strings silently
+	columns := getColumns(storage.dbDriverType, columnTypes)
+	inferred, err := storage.inferColumnTypes(ctx, tableName, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := applyInferredTypes(NewCSVSchema(tableName, columns, storage.csvNullValue),
+		inferred)
+
+	buffer := new(bytes.Buffer)
+	err = CSVSchemaToJSON(buffer, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// storeSchemaSidecarIntoSinks method stores schema sidecar file of given
+// table into all sinks
+func (storage DBStorage) storeSchemaSidecarIntoSinks(ctx context.Context,
+	sinks []Sink, tableName TableName) (int, error) {
+	data, err := storage.schemaSidecarIntoJSON(ctx, tableName)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	return storeObjectIntoSinks(sinks, schemaSidecarFileName(tableName),
+		ObjectMeta{ContentType: jsonContentType}, data)
+}
+
+// storeSchemaSidecarIntoS3 method stores schema sidecar file of given table
+// into S3 object under selected prefix
+func (storage DBStorage) storeSchemaSidecarIntoS3(ctx context.Context,
+	session *S3Session, bucketName, prefix string,
+	tableName TableName) (int, error) {
+	data, err := storage.schemaSidecarIntoJSON(ctx, tableName)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	err = putObject(ctx, session, bucketName,
+		setObjectPrefix(prefix, schemaSidecarFileName(tableName)),
+		jsonContentType, data, storage.compression, storage.encryption)
+	if err != nil {
+		return ExitStatusS3Error, err
+	}
+
+	return ExitStatusOK, nil
+}
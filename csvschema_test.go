@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/csvschema_test.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestNewCSVSchema checks the function NewCSVSchema
+func TestNewCSVSchema(t *testing.T) {
+	schema := main.NewCSVSchema("report", []main.Column{
+		{Name: "org_id", DatabaseType: "INT4"},
+		{Name: "enabled", DatabaseType: "BOOL"},
+		{Name: "reported_at", DatabaseType: "TIMESTAMP"},
+		{Name: "report", DatabaseType: "VARCHAR"},
+	}, `\N`)
+
+	assert.Equal(t, main.CSVSchema{
+		Table:     "report",
+		Header:    true,
+		Delimiter: ",",
+		Columns: []main.CSVColumnSchema{
+			{Name: "org_id", DatabaseType: "INT4", Type: "integer", NullValue: `\N`},
+			{Name: "enabled", DatabaseType: "BOOL", Type: "boolean", NullValue: `\N`},
+			{Name: "reported_at", DatabaseType: "TIMESTAMP", Type: "timestamp", NullValue: `\N`},
+			{Name: "report", DatabaseType: "VARCHAR", Type: "string", NullValue: `\N`},
+		},
+	}, schema)
+}
+
+// TestCSVSchemaToJSON checks the function CSVSchemaToJSON
+func TestCSVSchemaToJSON(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.CSVSchemaToJSON(buffer, main.NewCSVSchema("report", []main.Column{
+		{Name: "org_id", DatabaseType: "INT4"},
+	}, ""))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"table": "report",
+		"header": true,
+		"delimiter": ",",
+		"columns": [
+			{"name": "org_id", "database_type": "INT4", "type": "integer", "null_value": ""}
+		]
+	}`, buffer.String())
+}
+
+// TestCSVSchemaToJSONNilBuffer checks the function CSVSchemaToJSON with nil
+// buffer
+func TestCSVSchemaToJSONNilBuffer(t *testing.T) {
+	err := main.CSVSchemaToJSON(nil, main.CSVSchema{})
+	assert.Error(t, err)
+}
+
+// performDataExportWithSidecars function exports SQLite database with one
+// table into files in selected format
+func performDataExportWithSidecars(t *testing.T, enabled bool, format string) string {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report"),
+		},
+		Export: main.ExportConfiguration{
+			CSVSchemaSidecars: enabled,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          format,
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	return directory
+}
+
+// TestPerformDataExportSchemaSidecars checks that schema sidecar files are
+// exported with CSV files when enabled
+func TestPerformDataExportSchemaSidecars(t *testing.T) {
+	directory := performDataExportWithSidecars(t, true, "csv")
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv.schema.json"))
+	assert.NoError(t, err)
+
+	var schema main.CSVSchema
+	assert.NoError(t, json.Unmarshal(content, &schema))
+	assert.Equal(t, "report", schema.Table)
+	assert.Len(t, schema.Columns, 1)
+	assert.Equal(t, "id", schema.Columns[0].Name)
+}
+
+// TestPerformDataExportSchemaSidecarsDisabled checks that schema sidecar
+// files are not exported by default
+func TestPerformDataExportSchemaSidecarsDisabled(t *testing.T) {
+	directory := performDataExportWithSidecars(t, false, "csv")
+
+	assert.NoFileExists(t, filepath.Join(directory, "report.csv.schema.json"))
+}
+
+// TestPerformDataExportSchemaSidecarsOtherFormat checks that schema sidecar
+// files are exported with CSV files only
+func TestPerformDataExportSchemaSidecarsOtherFormat(t *testing.T) {
+	directory := performDataExportWithSidecars(t, true, "json")
+
+	assert.FileExists(t, filepath.Join(directory, "report.json"))
+	assert.NoFileExists(t, filepath.Join(directory, "report.csv.schema.json"))
+}
@@ -0,0 +1,359 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains end-of-run digest. Digest combines summary of
+// the run, number of rows exported from each table compared with previous
+// run, schema drift (columns added into or removed from tables) and warnings
+// logged during the run. It is stored as text and HTML files next to the
+// exported data. State of the run (tables, their columns and number of rows)
+// is stored into local file, so the next run can compare its results.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/digest.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Names of files with digest
+const (
+	digestTextFile = "_digest.txt"
+	digestHTMLFile = "_digest.html"
+)
+
+// Content types of files with digest
+const (
+	textContentType = "text/plain"
+	htmlContentType = "text/html"
+)
+
+// messages
+const (
+	columnAddedMsg   = "column %s added"
+	columnRemovedMsg = "column %s removed"
+	tableAddedMsg    = "table added"
+	tableMissingMsg  = "table not exported"
+)
+
+// templates used to render digest
+const (
+	digestTextTemplate = `Export digest
+=============
+Finished: {{.Finished.Format "2006-01-02 15:04:05 MST"}}
+Duration: {{.Duration}}
+Exported tables: {{.ExportedTables}}, exported rows: {{.ExportedRows}}, rejected rows: {{.RejectedRows}}
+{{if .PreviousRun}}Previous run: {{.PreviousRun.Format "2006-01-02 15:04:05 MST"}}
+{{end}}
+Tables:
+{{range .Tables}}  {{.Name}}: {{.Rows}} rows ({{.Delta}})
+{{else}}  none
+{{end}}
+Schema drift:
+{{range .Drift}}  {{.Table}}: {{.Change}}
+{{else}}  none
+{{end}}
+Warnings:
+{{range .Warnings}}  {{.Message}}{{if gt .Count 1}} ({{.Count}} times){{end}}
+{{else}}  none
+{{end}}`
+
+	digestHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Export digest</title>
+</head>
+<body>
+<h1>Export digest</h1>
+<p>Finished: {{.Finished.Format "2006-01-02 15:04:05 MST"}}<br>
+Duration: {{.Duration}}<br>
+Exported tables: {{.ExportedTables}}, exported rows: {{.ExportedRows}}, rejected rows: {{.RejectedRows}}{{if .PreviousRun}}<br>
+Previous run: {{.PreviousRun.Format "2006-01-02 15:04:05 MST"}}{{end}}</p>
+<h2>Tables</h2>
+<table>
+<tr><th>Table</th><th>Rows</th><th>Change</th></tr>
+{{range .Tables}}<tr><td>{{.Name}}</td><td>{{.Rows}}</td><td>{{.Delta}}</td></tr>
+{{end}}</table>
+<h2>Schema drift</h2>
+<ul>
+{{range .Drift}}<li>{{.Table}}: {{.Change}}</li>
+{{else}}<li>none</li>
+{{end}}</ul>
+<h2>Warnings</h2>
+<ul>
+{{range .Warnings}}<li>{{.Message}}{{if gt .Count 1}} ({{.Count}} times){{end}}</li>
+{{else}}<li>none</li>
+{{end}}</ul>
+</body>
+</html>
+`
+)
+
+// DigestState contains results of one run the next run is compared with
+type DigestState struct {
+	Finished time.Time      `json:"finished"`
+	Tables   []TableSummary `json:"tables"`
+}
+
+// DigestTable contains number of rows exported from one table and its
+// change since previous run
+type DigestTable struct {
+	Name  TableName
+	Rows  int
+	Delta string
+}
+
+// SchemaDrift describes one change of table schema since previous run
+type SchemaDrift struct {
+	Table  TableName
+	Change string
+}
+
+// Digest contains all information reported in end-of-run digest
+type Digest struct {
+	Finished       time.Time
+	PreviousRun    *time.Time
+	Duration       time.Duration
+	ExportedTables int
+	ExportedRows   int
+	RejectedRows   int
+	Tables         []DigestTable
+	Drift          []SchemaDrift
+	Warnings       []Warning
+}
+
+// rowsDelta function describes change of number of rows since previous run
+func rowsDelta(rows, previous int) string {
+	switch {
+	case rows > previous:
+		return "+" + strconv.Itoa(rows-previous)
+	case rows < previous:
+		return "-" + strconv.Itoa(previous-rows)
+	default:
+		return "unchanged"
+	}
+}
+
+// columnsDrift function returns columns added into and removed from table
+// since previous run
+func columnsDrift(tableName TableName, columns, previous []string) []SchemaDrift {
+	var drift []SchemaDrift
+
+	previousColumns := make(map[string]bool, len(previous))
+	for _, column := range previous {
+		previousColumns[column] = true
+	}
+	currentColumns := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		currentColumns[column] = true
+	}
+
+	for _, column := range columns {
+		if !previousColumns[column] {
+			drift = append(drift, SchemaDrift{tableName, fmt.Sprintf(columnAddedMsg, column)})
+		}
+	}
+	for _, column := range previous {
+		if !currentColumns[column] {
+			drift = append(drift, SchemaDrift{tableName, fmt.Sprintf(columnRemovedMsg, column)})
+		}
+	}
+
+	return drift
+}
+
+// NewDigest function constructs digest of finished run. Number of rows and
+// columns of tables are compared with previous run when its state is known.
+func NewDigest(summary *Summary, previous *DigestState) Digest {
+	digest := Digest{
+		Finished:       summary.Finished(),
+		Duration:       summary.TotalDuration().Round(time.Millisecond),
+		ExportedTables: summary.ExportedTables(),
+		ExportedRows:   summary.ExportedRows(),
+		RejectedRows:   summary.RejectedRows(),
+		Warnings:       summary.Warnings(),
+	}
+
+	previousTables := make(map[TableName]TableSummary)
+	if previous != nil {
+		digest.PreviousRun = &previous.Finished
+		for _, table := range previous.Tables {
+			previousTables[table.Name] = table
+		}
+	}
+
+	tables := summary.Tables()
+	exported := make(map[TableName]bool, len(tables))
+
+	for _, table := range tables {
+		exported[table.Name] = true
+
+		digestTable := DigestTable{Name: table.Name, Rows: table.Rows}
+		previousTable, found := previousTables[table.Name]
+		switch {
+		case previous == nil:
+			digestTable.Delta = "no previous run"
+		case !found:
+			digestTable.Delta = "new"
+			digest.Drift = append(digest.Drift, SchemaDrift{table.Name, tableAddedMsg})
+		default:
+			digestTable.Delta = rowsDelta(table.Rows, previousTable.Rows)
+			digest.Drift = append(digest.Drift,
+				columnsDrift(table.Name, table.Columns, previousTable.Columns)...)
+		}
+		digest.Tables = append(digest.Tables, digestTable)
+	}
+
+	// tables exported by previous run only are reported as drift too
+	if previous != nil {
+		var missing []TableName
+		for tableName := range previousTables {
+			if !exported[tableName] {
+				missing = append(missing, tableName)
+			}
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+		for _, tableName := range missing {
+			digest.Drift = append(digest.Drift, SchemaDrift{tableName, tableMissingMsg})
+		}
+	}
+
+	return digest
+}
+
+// DigestToText function writes digest as plain text
+func DigestToText(writer io.Writer, digest Digest) error {
+	if writer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	tmpl := template.Must(template.New("digest").Parse(digestTextTemplate))
+	return tmpl.Execute(writer, digest)
+}
+
+// DigestToHTML function writes digest as HTML page
+func DigestToHTML(writer io.Writer, digest Digest) error {
+	if writer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	tmpl := htmltemplate.Must(htmltemplate.New("digest").Parse(digestHTMLTemplate))
+	return tmpl.Execute(writer, digest)
+}
+
+// readDigestState function reads state of previous run from given file. Nil
+// is returned when the file does not exist yet.
+func readDigestState(fileName string) (*DigestState, error) {
+	data, err := os.ReadFile(fileName) // #nosec G304
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state DigestState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// writeDigestState function writes state of finished run into given file
+func writeDigestState(fileName string, summary *Summary) error {
+	data, err := json.MarshalIndent(DigestState{
+		Finished: summary.Finished(),
+		Tables:   summary.Tables(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, data, 0o600)
+}
+
+// storeDigest function stores digest of finished run into the output the
+// data have been exported into. State of the run is written into configured
+// file, so the next run is compared with this one.
+func storeDigest(configuration *ConfigStruct, cliFlags CliFlags, summary *Summary) (int, error) {
+	stateFile := GetExportConfiguration(configuration).DigestStateFile
+
+	var previous *DigestState
+	if stateFile != "" {
+		var err error
+		previous, err = readDigestState(stateFile)
+		if err != nil {
+			return ExitStatusIOError, err
+		}
+	}
+
+	digest := NewDigest(summary, previous)
+
+	text := new(bytes.Buffer)
+	err := DigestToText(text, digest)
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	html := new(bytes.Buffer)
+	err = DigestToHTML(html, digest)
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	sinks, err := artifactSinks(configuration, cliFlags,
+		artifactCompression(configuration))
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	exitStatus, err := storeObjectIntoSinks(sinks, digestTextFile,
+		ObjectMeta{ContentType: textContentType}, text.Bytes())
+	if err != nil {
+		return exitStatus, err
+	}
+
+	exitStatus, err = storeObjectIntoSinks(sinks, digestHTMLFile,
+		ObjectMeta{ContentType: htmlContentType}, html.Bytes())
+	if err != nil {
+		return exitStatus, err
+	}
+
+	if stateFile != "" {
+		err = writeDigestState(stateFile, summary)
+		if err != nil {
+			return ExitStatusIOError, err
+		}
+	}
+
+	return ExitStatusOK, nil
+}
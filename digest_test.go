@@ -0,0 +1,184 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/digest_test.html
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// digestSummary function constructs summary of finished run with two
+// exported tables
+func digestSummary() *main.Summary {
+	summary := main.NewSummary()
+	summary.RecordTable("report", []string{"id", "report", "reported_at"}, 12)
+	summary.RecordTable("rule_hit", []string{"org_id"}, 5)
+	summary.AddExportedTable()
+	summary.AddExportedTable()
+	summary.AddExportedRows(17)
+	summary.AddWarning("No table selected for export by")
+	summary.Finish()
+	return summary
+}
+
+// TestNewDigestWithoutPreviousRun checks the function NewDigest when state
+// of previous run is not known
+func TestNewDigestWithoutPreviousRun(t *testing.T) {
+	digest := main.NewDigest(digestSummary(), nil)
+
+	assert.Nil(t, digest.PreviousRun)
+	assert.Equal(t, 2, digest.ExportedTables)
+	assert.Equal(t, 17, digest.ExportedRows)
+	assert.Equal(t, []main.DigestTable{
+		{Name: "report", Rows: 12, Delta: "no previous run"},
+		{Name: "rule_hit", Rows: 5, Delta: "no previous run"},
+	}, digest.Tables)
+	assert.Empty(t, digest.Drift)
+	assert.Equal(t, []main.Warning{
+		{Message: "No table selected for export by", Count: 1},
+	}, digest.Warnings)
+}
+
+// TestNewDigestWithPreviousRun checks that number of rows and columns are
+// compared with previous run
+func TestNewDigestWithPreviousRun(t *testing.T) {
+	previous := main.DigestState{
+		Finished: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tables: []main.TableSummary{
+			{Name: "report", Columns: []string{"id", "report", "updated_at"}, Rows: 10},
+			{Name: "rule_disable", Columns: []string{"org_id"}, Rows: 1},
+		},
+	}
+
+	digest := main.NewDigest(digestSummary(), &previous)
+
+	assert.Equal(t, previous.Finished, *digest.PreviousRun)
+	assert.Equal(t, []main.DigestTable{
+		{Name: "report", Rows: 12, Delta: "+2"},
+		{Name: "rule_hit", Rows: 5, Delta: "new"},
+	}, digest.Tables)
+	assert.Equal(t, []main.SchemaDrift{
+		{Table: "report", Change: "column reported_at added"},
+		{Table: "report", Change: "column updated_at removed"},
+		{Table: "rule_hit", Change: "table added"},
+		{Table: "rule_disable", Change: "table not exported"},
+	}, digest.Drift)
+}
+
+// TestDigestToText checks the function DigestToText
+func TestDigestToText(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DigestToText(buffer, main.Digest{
+		Finished:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration:       2 * time.Second,
+		ExportedTables: 1,
+		ExportedRows:   12,
+		Tables:         []main.DigestTable{{Name: "report", Rows: 12, Delta: "-3"}},
+		Warnings:       []main.Warning{{Message: "Replica lag", Count: 2}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `Export digest
+=============
+Finished: 2024-01-02 03:04:05 UTC
+Duration: 2s
+Exported tables: 1, exported rows: 12, rejected rows: 0
+
+Tables:
+  report: 12 rows (-3)
+
+Schema drift:
+  none
+
+Warnings:
+  Replica lag (2 times)
+`, buffer.String())
+}
+
+// TestDigestToHTML checks that values are escaped in HTML digest
+func TestDigestToHTML(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DigestToHTML(buffer, main.Digest{
+		Warnings: []main.Warning{{Message: "<script>", Count: 1}},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "<li>&lt;script&gt;</li>")
+	assert.NotContains(t, buffer.String(), "<script>")
+}
+
+// TestDigestNilBuffer checks the functions DigestToText and DigestToHTML
+// with nil buffer
+func TestDigestNilBuffer(t *testing.T) {
+	assert.Error(t, main.DigestToText(nil, main.Digest{}))
+	assert.Error(t, main.DigestToHTML(nil, main.Digest{}))
+}
+
+// TestStoreDigest checks that digest is written into output directory and
+// the next run is compared with state of previous one
+func TestStoreDigest(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "digest.json")
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{
+			DigestStateFile: stateFile,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	// the first run has nothing to compare with
+	status, err := main.StoreDigest(&configuration, cliFlags, digestSummary())
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_digest.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "report: 12 rows (no previous run)")
+	assert.FileExists(t, filepath.Join(directory, "_digest.html"))
+	assert.FileExists(t, stateFile)
+
+	// the second run is compared with the first one
+	summary := main.NewSummary()
+	summary.RecordTable("report", []string{"id", "report", "reported_at"}, 15)
+	summary.Finish()
+
+	status, err = main.StoreDigest(&configuration, cliFlags, summary)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	content, err = os.ReadFile(filepath.Join(directory, "_digest.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "report: 15 rows (+3)")
+	assert.Contains(t, string(content), "rule_hit: table not exported")
+}
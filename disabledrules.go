@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains detailed report of rules disabled by more users.
+// List of disabled rules contains only rules and numbers of users who
+// disabled them. Detailed report contains one row per rule, error key and
+// organization with number of users and clusters the rule has been disabled
+// for, the latest justification entered by user and the latest feedback
+// entered for cluster.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/disabledrules.html
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// disabledRulesDetails is name of file or object with detailed report of
+// disabled rules
+const disabledRulesDetails = "_disabled_rules_details.csv"
+
+// messages
+const (
+	readDisabledRulesDetailsFailed  = "Read details of disabled rules failed"
+	storeDisabledRulesDetailsFailed = "Store details of disabled rules failed"
+	exportingDisabledRulesDetails   = "Exporting details of rules disabled by more users"
+)
+
+// Keys of objects in detailed report written as JSON or NDJSON, they are
+// used as CSV header too
+const (
+	errorKeyKey      = "error_key"
+	orgIDKey         = "org_id"
+	usersKey         = "users"
+	clustersKey      = "clusters"
+	justificationKey = "justification"
+	feedbackKey      = "feedback"
+)
+
+// selectDisabledRulesDetails reads rules disabled by more users grouped by
+// rule, error key and organization. Clusters are assigned to organizations
+// by their reports. Filter of organizations is appended to WHERE clause.
+const selectDisabledRulesDetails = `
+           SELECT d.rule_id, d.error_key, d.org_id,
+                  count(DISTINCT d.user_id) AS users,
+                  (SELECT count(DISTINCT t.cluster_id)
+                     FROM cluster_rule_toggle t
+                     JOIN report r ON r.cluster = t.cluster_id
+                    WHERE t.rule_id = d.rule_id
+                      AND t.error_key = d.error_key
+                      AND t.disabled = 1
+                      AND r.org_id = d.org_id) AS clusters,
+                  coalesce((SELECT j.justification
+                              FROM rule_disable j
+                             WHERE j.rule_id = d.rule_id
+                               AND j.error_key = d.error_key
+                               AND j.org_id = d.org_id
+                             ORDER BY coalesce(j.updated_at, j.created_at) DESC
+                             LIMIT 1), '') AS justification,
+                  coalesce((SELECT f.message
+                              FROM cluster_user_rule_disable_feedback f
+                              JOIN report r ON r.cluster = f.cluster_id
+                             WHERE f.rule_id = d.rule_id
+                               AND f.error_key = d.error_key
+                               AND r.org_id = d.org_id
+                             ORDER BY coalesce(f.updated_at, f.added_at) DESC
+                             LIMIT 1), '') AS feedback
+             FROM rule_disable d
+            WHERE d.rule_id IN (SELECT rule_id
+                                  FROM rule_disable
+                                 GROUP BY rule_id
+                                HAVING count(rule_id)>1)%s
+            GROUP BY d.rule_id, d.error_key, d.org_id
+            ORDER BY users DESC, d.rule_id, d.error_key, d.org_id;
+`
+
+// DisabledRuleDetails contains information about rule disabled by users in
+// one organization
+type DisabledRuleDetails struct {
+	Rule          string
+	ErrorKey      string
+	OrgID         int
+	Users         int
+	Clusters      int
+	Justification string
+	Feedback      string
+}
+
+// ReadDisabledRulesDetails method reads rules disabled by more than one user
+// together with organizations they have been disabled in
+func (storage DBStorage) ReadDisabledRulesDetails(ctx context.Context) ([]DisabledRuleDetails, error) {
+	details := make([]DisabledRuleDetails, 0)
+
+	// only selected organizations are reported
+	filter := ""
+	if storage.orgIDFilterApplied("rule_disable") {
+		filter = fmt.Sprintf(" AND d.org_id IN ('%v')",
+			strings.Join(storage.config.OrganizationsToExport, "','"))
+	}
+	sqlStatement := fmt.Sprintf(selectDisabledRulesDetails, filter)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return details, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var detail DisabledRuleDetails
+
+		err := rows.Scan(&detail.Rule, &detail.ErrorKey, &detail.OrgID,
+			&detail.Users, &detail.Clusters, &detail.Justification, &detail.Feedback)
+		if err != nil {
+			return details, err
+		}
+		details = append(details, detail)
+	}
+
+	return details, rows.Err()
+}
+
+// writeDisabledRulesDetails function exports detailed report of disabled
+// rules in report format for selected output format
+func writeDisabledRulesDetails(buffer io.Writer, details []DisabledRuleDetails, format string) error {
+	rows := make([]M, 0, len(details))
+	for _, detail := range details {
+		rows = append(rows, M{
+			ruleKey:          detail.Rule,
+			errorKeyKey:      detail.ErrorKey,
+			orgIDKey:         detail.OrgID,
+			usersKey:         detail.Users,
+			clustersKey:      detail.Clusters,
+			justificationKey: detail.Justification,
+			feedbackKey:      detail.Feedback,
+		})
+	}
+
+	return writeReport(buffer, reportFormat(format),
+		[]string{ruleKey, errorKeyKey, orgIDKey, usersKey, clustersKey,
+			justificationKey, feedbackKey}, rows)
+}
+
+// disabledRulesDetailsReport method reads detailed report of disabled rules
+// and converts it into report format. Exit status is returned together with
+// error.
+func (storage DBStorage) disabledRulesDetailsReport(ctx context.Context, format string) ([]byte, int, error) {
+	details, err := storage.ReadDisabledRulesDetails(ctx)
+	if err != nil {
+		storage.logger.Err(err).Msg(readDisabledRulesDetailsFailed)
+		return nil, ExitStatusStorageError, err
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	err = writeDisabledRulesDetails(buffer, details, format)
+	if err != nil {
+		return nil, ExitStatusIOError, err
+	}
+
+	// buffer is returned into pool
+	return append([]byte(nil), buffer.Bytes()...), ExitStatusOK, nil
+}
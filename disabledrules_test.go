@@ -0,0 +1,159 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/disabledrules_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareDisabledRulesDatabase function creates SQLite database with rules
+// disabled by users in two organizations
+func prepareDisabledRulesDatabase(t *testing.T) string {
+	statements := []string{
+		`CREATE TABLE report (org_id INTEGER, cluster VARCHAR)`,
+		`CREATE TABLE rule_disable (org_id INTEGER, user_id VARCHAR, rule_id VARCHAR,
+		     error_key VARCHAR, justification VARCHAR, created_at TIMESTAMP, updated_at TIMESTAMP)`,
+		`CREATE TABLE cluster_rule_toggle (cluster_id VARCHAR, rule_id VARCHAR, user_id VARCHAR,
+		     disabled SMALLINT, disabled_at TIMESTAMP, enabled_at TIMESTAMP, updated_at TIMESTAMP,
+		     error_key VARCHAR)`,
+		`CREATE TABLE cluster_user_rule_disable_feedback (cluster_id VARCHAR, user_id VARCHAR,
+		     rule_id VARCHAR, message VARCHAR, added_at TIMESTAMP, updated_at TIMESTAMP,
+		     error_key VARCHAR)`,
+		`INSERT INTO report VALUES (1, 'c1'), (1, 'c2'), (2, 'c3')`,
+		`INSERT INTO rule_disable VALUES
+		     (1, 'u1', 'rule1', 'KEY1', 'not relevant', '2024-01-01', NULL),
+		     (1, 'u2', 'rule1', 'KEY1', 'false positive', '2024-01-02', '2024-01-03'),
+		     (2, 'u3', 'rule1', 'KEY1', 'known issue', '2024-01-01', NULL),
+		     (2, 'u3', 'rule2', 'KEY2', 'disabled once', '2024-01-01', NULL)`,
+		`INSERT INTO cluster_rule_toggle VALUES
+		     ('c1', 'rule1', 'u1', 1, '2024-01-01', NULL, '2024-01-01', 'KEY1'),
+		     ('c2', 'rule1', 'u2', 1, '2024-01-01', NULL, '2024-01-01', 'KEY1'),
+		     ('c3', 'rule1', 'u3', 0, '2024-01-01', '2024-01-02', '2024-01-02', 'KEY1')`,
+		`INSERT INTO cluster_user_rule_disable_feedback VALUES
+		     ('c1', 'u1', 'rule1', 'noisy on c1', '2024-01-01', '2024-01-01', 'KEY1'),
+		     ('c2', 'u2', 'rule1', 'noisy on c2', '2024-01-02', '2024-01-02', 'KEY1')`,
+	}
+
+	return newSQLiteTestDatabase(t, statements...)
+}
+
+// TestReadDisabledRulesDetails checks the method ReadDisabledRulesDetails
+func TestReadDisabledRulesDetails(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareDisabledRulesDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	details, err := storage.ReadDisabledRulesDetails(context.Background())
+	assert.NoError(t, err)
+
+	// rule disabled by one user only is not reported
+	assert.Equal(t, []main.DisabledRuleDetails{
+		{
+			Rule: "rule1", ErrorKey: "KEY1", OrgID: 1, Users: 2, Clusters: 2,
+			Justification: "false positive", Feedback: "noisy on c2",
+		},
+		{
+			Rule: "rule1", ErrorKey: "KEY1", OrgID: 2, Users: 1, Clusters: 0,
+			Justification: "known issue", Feedback: "",
+		},
+	}, details)
+}
+
+// TestReadDisabledRulesDetailsOrgFilter checks that only selected
+// organizations are reported
+func TestReadDisabledRulesDetailsOrgFilter(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:                "sqlite3",
+		SQLiteDataSource:      prepareDisabledRulesDatabase(t),
+		EnableOrgIDFiltering:  true,
+		OrganizationsToExport: []string{"2"},
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	details, err := storage.ReadDisabledRulesDetails(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, details, 1)
+	assert.Equal(t, 2, details[0].OrgID)
+}
+
+// TestPerformDataExportDisabledRulesDetails checks that detailed report is
+// exported together with list of disabled rules
+func TestPerformDataExportDisabledRulesDetails(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDisabledRulesDatabase(t),
+		},
+		Export: main.ExportConfiguration{
+			DisabledRulesDetails: true,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:              "file",
+		OutputDirectory:     directory,
+		ExportDisabledRules: true,
+		SkipArtifacts:       "sequences,constraints",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// list of disabled rules is not changed
+	content, err := os.ReadFile(filepath.Join(directory, "_disabled_rules.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Rule,Count\nrule1,3\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(directory, "_disabled_rules_details.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "rule,error_key,org_id,users,clusters,justification,feedback\n"+
+		"rule1,KEY1,1,2,2,false positive,noisy on c2\n"+
+		"rule1,KEY1,2,1,0,known issue,\"\"\n", string(content))
+
+	// detailed report follows format of other reports
+	cliFlags.Format = "ndjson"
+	cliFlags.OutputDirectory = t.TempDir()
+	_, err = main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(cliFlags.OutputDirectory, "_disabled_rules_details.ndjson"))
+}
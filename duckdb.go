@@ -0,0 +1,240 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of all tables into one DuckDB database
+// file. DuckDB engine is not linked into the exporter (Go driver would embed
+// the whole engine into the binary and it needs newer Go toolchain than the
+// one the exporter is built by), so the DuckDB command line tool is used
+// instead: tables are exported into temporary CSV files first and then
+// imported by the tool. The tool is installed into the container image and
+// its presence is checked when the configuration is validated, before any
+// table is read.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/duckdb.html
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// name of DuckDB database file with all exported tables
+const duckDBFile = "export.duckdb"
+
+// DuckDB command line tool used when no other tool is configured
+const defaultDuckDBBinary = "duckdb"
+
+// messages
+const (
+	duckDBToolNotFound        = "DuckDB command line tool %s needed by duckdb output not found, install it or set path to it: %v"
+	duckDBImportFailed        = "Import into DuckDB failed"
+	duckDBMetadataUnsupported = "Metadata, disabled rules and custom queries are not exported into DuckDB"
+)
+
+// duckDBImportScript function constructs SQL script that imports given CSV
+// files into DuckDB tables. Existing tables are replaced, so the export can
+// be repeated into the same database file.
+func duckDBImportScript(tableFiles map[TableName]string, tableNames []TableName) string {
+	var script strings.Builder
+
+	script.WriteString("BEGIN TRANSACTION;\n")
+	for _, tableName := range tableNames {
+		fileName, found := tableFiles[tableName]
+		if !found {
+			continue
+		}
+		script.WriteString(createSchemaStatement(tableName))
+		fmt.Fprintf(&script,
+			"CREATE OR REPLACE TABLE %s AS SELECT * FROM read_csv_auto(%s, header = true);\n",
+			quoteTableName(tableName), sqlLiteral(fileName))
+	}
+	script.WriteString("COMMIT;\n")
+
+	return script.String()
+}
+
+// duckDBBinary function returns DuckDB command line tool selected by
+// configuration, default tool searched in PATH is used when not set
+func duckDBBinary(configuration *ConfigStruct) string {
+	binary := GetExportConfiguration(configuration).DuckDBBinary
+	if binary == "" {
+		return defaultDuckDBBinary
+	}
+	return binary
+}
+
+// checkDuckDBBinary function checks that given DuckDB command line tool can
+// be run, so missing tool is reported before any table is read
+func checkDuckDBBinary(binary string) error {
+	_, err := exec.LookPath(binary)
+	if err != nil {
+		return fmt.Errorf(duckDBToolNotFound, binary, err)
+	}
+	return nil
+}
+
+// runDuckDB function runs DuckDB command line tool with given script on
+// selected database file
+func runDuckDB(binary, databaseFile, script string) error {
+	// disable "G204 (CWE-78): Subprocess launched with variable"
+	cmd := exec.Command(binary, databaseFile) // #nosec G204
+	cmd.Stdin = strings.NewReader(script)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%v: %s", err, message)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// performDataExportToDuckDB function exports all tables into one DuckDB
+// database file
+func performDataExportToDuckDB(ctx context.Context, configuration *ConfigStruct,
+	storage *DBStorage, exportMetadata bool,
+	exportDisabledRules bool,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, summary *Summary) (int, error) {
+	operationLogger.Info().Msg("Exporting to DuckDB")
+
+	if exportMetadata || exportDisabledRules || len(storage.queries) > 0 {
+		storage.logger.Warn().Msg(duckDBMetadataUnsupported)
+		operationLogger.Warn().Msg(duckDBMetadataUnsupported)
+	}
+
+	// the tool is checked when configuration is validated too, this check
+	// covers exports started without the validation
+	binary := duckDBBinary(configuration)
+	err := checkDuckDBBinary(binary)
+	if err != nil {
+		storage.logger.Err(err).Msg(duckDBImportFailed)
+		operationLogger.Err(err).Msg(duckDBImportFailed)
+		return ExitStatusConfigurationError, err
+	}
+
+	operationLogger.Info().Msg(readingListOfTables)
+
+	stopMeasuring := summary.MeasureStage(stageDiscovery)
+	tableNames, err := storage.ReadListOfTables(ctx)
+	stopMeasuring()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// only selected tables are exported
+	tableNames, err = storage.selectTables(tableNames)
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	storage.logger.Info().Int("count", len(tableNames)).Msg(listOfTablesMsg)
+
+	// log into terminal
+	printTables(&storage.logger, tableNames)
+
+	// tables are exported into CSV files first
+	directory, err := os.MkdirTemp("", "duckdb-export")
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+	defer func() {
+		if err := os.RemoveAll(directory); err != nil {
+			storage.logger.Err(err).Msg("Remove temporary directory")
+		}
+	}()
+
+	operationLogger.Info().Msg(exportingTables)
+
+	tableFiles := make(map[TableName]string, len(tableNames))
+
+	for _, tableName := range tableNames {
+		// ignore table if specified by user
+		if _, found := ignoredTables[string(tableName)]; found {
+			operationLogger.Info().
+				Str(tableNameMsg, string(tableName)).
+				Msg(tableIsIgnored)
+			continue
+		}
+		operationLogger.Info().
+			Str(tableNameMsg, string(tableName)).
+			Msg(exportingTable)
+
+		// table names don't need to be valid file names
+		fileName := filepath.Join(directory, fmt.Sprintf("table%d%s", len(tableFiles), CSVFileExtension))
+		// all messages logged during table export contain its name
+		tableStorage := storage.WithLogger(storage.logger.With().
+			Str(tableNameMsg, string(tableName)).Logger())
+		tableStorage.explainTable(ctx, operationLogger, tableName, limit)
+		err = tableStorage.storeTableIntoNamedFile(ctx, fileName, tableName, limit,
+			csvFormat, noCompression, nil)
+		if err != nil {
+			const msg = "Store table into file failed"
+			tableStorage.logger.Err(err).Msg(msg)
+			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+				Msg(msg)
+			return ExitStatusStorageError, err
+		}
+		tableFiles[tableName] = fileName
+		logTableAudit(&storage.logger, operationLogger, storage.audit, tableName)
+		logRejects(&storage.logger, operationLogger, storage.quarantine, tableName)
+		summary.AddExportedTable()
+	}
+
+	// import all tables into DuckDB at once
+	stopMeasuring = summary.MeasureStage(stageUpload)
+	err = runDuckDB(binary, duckDBFile, duckDBImportScript(tableFiles, tableNames))
+	stopMeasuring()
+	if err != nil {
+		storage.logger.Err(err).Msg(duckDBImportFailed)
+		operationLogger.Err(err).Msg(duckDBImportFailed)
+		return ExitStatusIOError, err
+	}
+
+	operationLogger.Info().Msg(closingConnectionToStorage)
+
+	// we have finished, let's close the connection to database
+	err = storage.Close()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// default exit value + no error
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/duckdb_test.html
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestDuckDBImportScript checks construction of script that imports CSV
+// files into DuckDB
+func TestDuckDBImportScript(t *testing.T) {
+	tableFiles := map[main.TableName]string{
+		"report":      "/tmp/table0.csv",
+		"rule_hit":    "/tmp/table1.csv",
+		"odd\"name's": "/tmp/it's.csv",
+	}
+
+	// table without file is skipped
+
+	script := main.DuckDBImportScript(tableFiles,
+		[]main.TableName{"report", "not_exported", "rule_hit", "odd\"name's"})
+
+	expected := "BEGIN TRANSACTION;\n" +
+		`CREATE OR REPLACE TABLE "report" AS SELECT * FROM read_csv_auto('/tmp/table0.csv', header = true);` + "\n" +
+		`CREATE OR REPLACE TABLE "rule_hit" AS SELECT * FROM read_csv_auto('/tmp/table1.csv', header = true);` + "\n" +
+		`CREATE OR REPLACE TABLE "odd""name's" AS SELECT * FROM read_csv_auto('/tmp/it''s.csv', header = true);` + "\n" +
+		"COMMIT;\n"
+	assert.Equal(t, expected, script)
+}
+
+// TestDuckDBImportScriptNoTables checks script constructed when no table is
+// exported
+func TestDuckDBImportScriptNoTables(t *testing.T) {
+	script := main.DuckDBImportScript(nil, nil)
+	assert.Equal(t, "BEGIN TRANSACTION;\nCOMMIT;\n", script)
+}
+
+// TestRunDuckDBMissingBinary checks that missing DuckDB tool is reported
+func TestRunDuckDBMissingBinary(t *testing.T) {
+	databaseFile := filepath.Join(t.TempDir(), "export.duckdb")
+	err := main.RunDuckDB("this-duckdb-does-not-exist", databaseFile, "")
+	assert.Error(t, err)
+}
+
+// TestRunDuckDBFailure checks that error output of the tool is part of
+// returned error
+func TestRunDuckDBFailure(t *testing.T) {
+	// shell is used instead of DuckDB, database file is the script to run
+	const shell = "/bin/sh"
+	if _, err := os.Stat(shell); err != nil {
+		t.Skip("shell is not available")
+	}
+
+	script := filepath.Join(t.TempDir(), "script.sh")
+	err := main.RunDuckDB(shell, script, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "script.sh")
+}
+
+// TestDuckDBImportScriptSchemaQualifiedTable checks that schema-qualified
+// table is imported into its schema
+func TestDuckDBImportScriptSchemaQualifiedTable(t *testing.T) {
+	script := main.DuckDBImportScript(
+		map[main.TableName]string{"archive.report": "/tmp/table0.csv"},
+		[]main.TableName{"archive.report"})
+
+	expected := "BEGIN TRANSACTION;\n" +
+		`CREATE SCHEMA IF NOT EXISTS "archive";` + "\n" +
+		`CREATE OR REPLACE TABLE "archive"."report" AS SELECT * FROM read_csv_auto('/tmp/table0.csv', header = true);` + "\n" +
+		"COMMIT;\n"
+	assert.Equal(t, expected, script)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains validation of text values read from database.
+// Old rows can contain byte sequences that are not valid UTF-8 and some
+// loaders refuse files with such values, so invalid sequences are replaced,
+// the row is rejected or the export fails, according to configuration.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/encoding.html
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported handling of values that are not valid UTF-8
+const (
+	replaceInvalidUTF8 = "replace"
+	rejectInvalidUTF8  = "reject"
+	failOnInvalidUTF8  = "fail"
+)
+
+// invalid byte sequences are replaced by Unicode replacement character
+const replacementCharacter = "\uFFFD"
+
+// messages
+const (
+	unknownInvalidUTF8Handling = "Unknown handling of invalid UTF-8: %s"
+	invalidUTF8Value           = "Value of column %s is not valid UTF-8"
+)
+
+// checkInvalidUTF8Handling function checks if given handling of invalid
+// UTF-8 is supported. Empty value means that invalid sequences are replaced.
+func checkInvalidUTF8Handling(handling string) error {
+	switch handling {
+	case "", replaceInvalidUTF8, rejectInvalidUTF8, failOnInvalidUTF8:
+		return nil
+	default:
+		return fmt.Errorf(unknownInvalidUTF8Handling, handling)
+	}
+}
+
+// validateUTF8 function checks that all text values in given row are valid
+// UTF-8. Invalid byte sequences are replaced in the row itself. Error
+// returned for rejected row is RowConversionError, so the row can be
+// quarantined.
+func validateUTF8(colNames []string, row M, handling string) error {
+	for _, colName := range colNames {
+		value, ok := row[colName].(string)
+		if !ok || utf8.ValidString(value) {
+			continue
+		}
+
+		switch handling {
+		case rejectInvalidUTF8:
+			return RowConversionError{fmt.Errorf(invalidUTF8Value, colName)}
+		case failOnInvalidUTF8:
+			return fmt.Errorf(invalidUTF8Value, colName)
+		default:
+			row[colName] = strings.ToValidUTF8(value, replacementCharacter)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/encoding_test.html
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// text with byte sequence that is not valid UTF-8
+const invalidText = "caf\xe9"
+
+// TestCheckInvalidUTF8Handling checks the function checkInvalidUTF8Handling
+func TestCheckInvalidUTF8Handling(t *testing.T) {
+	for _, handling := range []string{"", "replace", "reject", "fail"} {
+		assert.NoError(t, main.CheckInvalidUTF8Handling(handling))
+	}
+
+	assert.EqualError(t, main.CheckInvalidUTF8Handling("ignore"),
+		"Unknown handling of invalid UTF-8: ignore")
+}
+
+// TestValidateUTF8Replace checks that invalid sequences are replaced
+func TestValidateUTF8Replace(t *testing.T) {
+	for _, handling := range []string{"", "replace"} {
+		row := main.M{"id": int64(1), "report": invalidText}
+
+		err := main.ValidateUTF8([]string{"id", "report"}, row, handling)
+		assert.NoError(t, err)
+		assert.Equal(t, main.M{"id": int64(1), "report": "caf�"}, row)
+	}
+}
+
+// TestValidateUTF8Reject checks that row with invalid sequence is rejected
+// and it can be quarantined
+func TestValidateUTF8Reject(t *testing.T) {
+	row := main.M{"report": invalidText}
+
+	err := main.ValidateUTF8([]string{"report"}, row, "reject")
+	assert.EqualError(t, err, "Value of column report is not valid UTF-8")
+
+	var conversionErr main.RowConversionError
+	assert.True(t, errors.As(err, &conversionErr))
+	assert.Equal(t, invalidText, row["report"])
+}
+
+// TestValidateUTF8Fail checks that invalid sequence causes error that is not
+// quarantined
+func TestValidateUTF8Fail(t *testing.T) {
+	err := main.ValidateUTF8([]string{"report"}, main.M{"report": invalidText}, "fail")
+	assert.EqualError(t, err, "Value of column report is not valid UTF-8")
+
+	var conversionErr main.RowConversionError
+	assert.False(t, errors.As(err, &conversionErr))
+
+	// valid values are accepted
+	assert.NoError(t, main.ValidateUTF8([]string{"report"}, main.M{"report": "café"}, "fail"))
+}
+
+// prepareDatabaseWithInvalidUTF8 helper function creates SQLite database
+// file with one table that contains text that is not valid UTF-8
+func prepareDatabaseWithInvalidUTF8(t *testing.T) string {
+	dataSource := filepath.Join(t.TempDir(), "aggregator.db")
+
+	connection, err := sql.Open("sqlite3", dataSource)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	_, err = connection.Exec(`CREATE TABLE report (id INTEGER, report TEXT)`)
+	assert.NoError(t, err)
+
+	_, err = connection.Exec(`INSERT INTO report VALUES (1, 'first'), (2, ?)`, invalidText)
+	assert.NoError(t, err)
+
+	return dataSource
+}
+
+// TestPerformDataExportInvalidUTF8 checks all handlings of invalid UTF-8
+// during export into file
+func TestPerformDataExportInvalidUTF8(t *testing.T) {
+	type testCase struct {
+		handling     string
+		expectedCode int
+		content      string
+		rejects      bool
+	}
+
+	for _, tc := range []testCase{
+		{"replace", main.ExitStatusOK, "id,report\n1,first\n2,caf�\n", false},
+		{"reject", main.ExitStatusOK, "id,report\n1,first\n", true},
+		{"fail", main.ExitStatusStorageError, "", false},
+	} {
+		t.Run(tc.handling, func(t *testing.T) {
+			configuration := main.ConfigStruct{
+				Storage: main.StorageConfiguration{
+					Driver:           "sqlite3",
+					SQLiteDataSource: prepareDatabaseWithInvalidUTF8(t),
+				},
+				Export: main.ExportConfiguration{
+					InvalidUTF8: tc.handling,
+				},
+			}
+
+			directory := t.TempDir()
+			cliFlags := main.CliFlags{
+				Output:          "file",
+				OutputDirectory: directory,
+			}
+
+			code, _ := main.PerformDataExport(context.Background(), &configuration,
+				cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+			assert.Equal(t, tc.expectedCode, code)
+
+			if tc.content != "" {
+				content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+				assert.NoError(t, err)
+				assert.Equal(t, tc.content, string(content))
+			}
+
+			_, err := os.Stat(filepath.Join(directory, "report_rejects.csv"))
+			assert.Equal(t, tc.rejects, err == nil)
+		})
+	}
+}
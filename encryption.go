@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains client-side encryption of exported artifacts.
+// When public key file is configured, all exported objects and files are
+// compressed by selected codec and then encrypted by OpenPGP (GPG) for all
+// keys found in the file, so artifacts are ciphertext at rest even for
+// administrators of the bucket. Public keys are passed explicitly to
+// storage and sinks writing artifacts, state objects written by the
+// exporter itself are not encrypted and they can be read by next run.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/encryption.html
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// encryptedFileExtension is added to names of encrypted files and objects
+const encryptedFileExtension = ".gpg"
+
+// armoredKeyPrefix starts public keys stored in ASCII armored form
+const armoredKeyPrefix = "-----BEGIN"
+
+// messages
+const (
+	noEncryptionKey = "no public key found in encryption key file"
+)
+
+// Encryption contains public keys exported artifacts are encrypted for.
+// Nil encryption means that artifacts are not encrypted.
+type Encryption struct {
+	recipients openpgp.EntityList
+}
+
+// encryptionConfig contains settings of OpenPGP encryption, data is
+// compressed by selected codec before it is encrypted
+var encryptionConfig = &packet.Config{
+	DefaultCipher:          packet.CipherAES256,
+	DefaultCompressionAlgo: packet.CompressionNone,
+}
+
+// readEncryptionKeys function reads public keys from given file, the keys
+// can be stored in binary or in ASCII armored form
+func readEncryptionKeys(fileName string) (openpgp.EntityList, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	prefix, _ := reader.Peek(len(armoredKeyPrefix))
+
+	var keys openpgp.EntityList
+	if bytes.Equal(prefix, []byte(armoredKeyPrefix)) {
+		keys, err = openpgp.ReadArmoredKeyRing(reader)
+	} else {
+		keys, err = openpgp.ReadKeyRing(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New(noEncryptionKey)
+	}
+
+	// key without encryption subkey is refused now, not when the first
+	// artifact is written
+	writer, err := openpgp.Encrypt(io.Discard, keys, nil, nil, encryptionConfig)
+	if err != nil {
+		return nil, err
+	}
+	return keys, writer.Close()
+}
+
+// configuredEncryption function reads public keys exported artifacts are
+// encrypted for from file selected by configuration. Nil encryption is
+// returned when no file is configured.
+func configuredEncryption(configuration *ConfigStruct) (*Encryption, error) {
+	fileName := GetExportConfiguration(configuration).EncryptionKeyFile
+	if fileName == "" {
+		return nil, nil
+	}
+
+	keys, err := readEncryptionKeys(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryption{recipients: keys}, nil
+}
+
+// artifactEncryption function returns encryption of artifacts exported by
+// selected operation. Files put into bundle are not encrypted, because the
+// bundle is encrypted as a whole.
+func artifactEncryption(configuration *ConfigStruct, cliFlags CliFlags) (*Encryption, error) {
+	if cliFlags.Bundle != "" {
+		return nil, nil
+	}
+	return configuredEncryption(configuration)
+}
+
+// artifactCompression function returns codec used for all exported
+// artifacts
+func artifactCompression(configuration *ConfigStruct) string {
+	return GetExportConfiguration(configuration).Compression
+}
+
+// Extension method returns extension added to names of encrypted files and
+// objects, it follows extension of compression codec
+func (encryption *Encryption) Extension() string {
+	if encryption == nil {
+		return ""
+	}
+	return encryptedFileExtension
+}
+
+// encryptedWriter encrypts all data written into it. Data is compressed
+// before it is encrypted, so closing the writer closes compressor first.
+type encryptedWriter struct {
+	compressor io.WriteCloser
+	encryptor  io.WriteCloser
+}
+
+// Write method writes data into compressor
+func (w encryptedWriter) Write(data []byte) (int, error) {
+	return w.compressor.Write(data)
+}
+
+// Close method flushes compressed data and finishes the encrypted message,
+// the underlying writer is not closed
+func (w encryptedWriter) Close() error {
+	err := w.compressor.Close()
+	if err != nil {
+		// error during compression is more important than error during
+		// encryption
+		_ = w.encryptor.Close()
+		return err
+	}
+
+	return w.encryptor.Close()
+}
+
+// newEncryptor method constructs writer that encrypts all data written into
+// it for the public keys. The encryptor needs to be closed to write all
+// data into the underlying writer, but the underlying writer is not closed.
+func (encryption *Encryption) newEncryptor(writer io.Writer) (io.WriteCloser, error) {
+	hints := &openpgp.FileHints{IsBinary: true}
+	return openpgp.Encrypt(writer, encryption.recipients, nil, hints, encryptionConfig)
+}
@@ -0,0 +1,251 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/encryption_test.html
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// mustCreateEncryptionKey helper function generates new OpenPGP key and
+// writes its public part in ASCII armored form into file
+func mustCreateEncryptionKey(t *testing.T) (*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity("exporter", "test", "exporter@example.com", nil)
+	assert.NoError(t, err)
+
+	buffer := new(bytes.Buffer)
+	writer, err := armor.Encode(buffer, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(writer))
+	assert.NoError(t, writer.Close())
+
+	fileName := filepath.Join(t.TempDir(), "export.asc")
+	assert.NoError(t, os.WriteFile(fileName, buffer.Bytes(), 0o600))
+
+	return entity, fileName
+}
+
+// mustReadEncryption helper function reads public keys from given file
+func mustReadEncryption(t *testing.T, fileName string) *main.Encryption {
+	encryption, err := main.ArtifactEncryption(&main.ConfigStruct{
+		Export: main.ExportConfiguration{EncryptionKeyFile: fileName},
+	}, main.CliFlags{})
+	assert.NoError(t, err)
+	assert.NotNil(t, encryption)
+	return encryption
+}
+
+// decrypt helper function decrypts given data by private key
+func decrypt(t *testing.T, entity *openpgp.Entity, data []byte) []byte {
+	message, err := openpgp.ReadMessage(bytes.NewReader(data),
+		openpgp.EntityList{entity}, nil, nil)
+	assert.NoError(t, err)
+
+	plaintext, err := io.ReadAll(message.UnverifiedBody)
+	assert.NoError(t, err)
+	return plaintext
+}
+
+// TestArtifactCompression checks that codec of artifacts does not depend on
+// encryption
+func TestArtifactCompression(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{Compression: "gzip"},
+	}
+	assert.Equal(t, "gzip", main.ArtifactCompression(&configuration))
+
+	configuration.Export.EncryptionKeyFile = "export.asc"
+	assert.Equal(t, "gzip", main.ArtifactCompression(&configuration))
+}
+
+// TestArtifactEncryption checks the function ArtifactEncryption
+func TestArtifactEncryption(t *testing.T) {
+	_, fileName := mustCreateEncryptionKey(t)
+
+	// encryption is not configured
+	encryption, err := main.ArtifactEncryption(&main.ConfigStruct{}, main.CliFlags{})
+	assert.NoError(t, err)
+	assert.Nil(t, encryption)
+
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{EncryptionKeyFile: fileName},
+	}
+	encryption, err = main.ArtifactEncryption(&configuration, main.CliFlags{})
+	assert.NoError(t, err)
+	assert.NotNil(t, encryption)
+
+	// bundled files are not encrypted, the bundle is
+	encryption, err = main.ArtifactEncryption(&configuration, main.CliFlags{Bundle: "zip"})
+	assert.NoError(t, err)
+	assert.Nil(t, encryption)
+}
+
+// TestArtifactEncryptionWrongKey checks that file without public key is
+// refused
+func TestArtifactEncryptionWrongKey(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "export.asc")
+	assert.NoError(t, os.WriteFile(fileName, []byte("not a key"), 0o600))
+
+	for _, fileName := range []string{fileName, filepath.Join(t.TempDir(), "missing.asc")} {
+		_, err := main.ArtifactEncryption(&main.ConfigStruct{
+			Export: main.ExportConfiguration{EncryptionKeyFile: fileName},
+		}, main.CliFlags{})
+		assert.Error(t, err)
+	}
+}
+
+// TestEncryptedCodec checks extensions and content encoding of encrypted
+// artifacts
+func TestEncryptedCodec(t *testing.T) {
+	_, fileName := mustCreateEncryptionKey(t)
+	encryption := mustReadEncryption(t, fileName)
+
+	assert.Equal(t, ".gz.gpg", main.ArtifactExtension("gzip", encryption))
+	assert.Equal(t, ".gpg", main.ArtifactExtension("none", encryption))
+	assert.Equal(t, ".gz", main.ArtifactExtension("gzip", nil))
+	assert.Equal(t, "", main.ContentEncoding("gzip", encryption))
+	assert.Equal(t, "gzip", main.ContentEncoding("gzip", nil))
+}
+
+// TestCompressDataEncrypted checks that compressed data is encrypted by
+// given key
+func TestCompressDataEncrypted(t *testing.T) {
+	entity, fileName := mustCreateEncryptionKey(t)
+
+	data, err := main.CompressData("gzip", mustReadEncryption(t, fileName),
+		[]byte("org_id,hits\n1,10\n"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "org_id")
+
+	decompressor, err := main.NewDecompressor("gzip",
+		bytes.NewReader(decrypt(t, entity, data)))
+	assert.NoError(t, err)
+	plaintext, err := io.ReadAll(decompressor)
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,hits\n1,10\n", string(plaintext))
+}
+
+// TestStoreBundleIntoFileEncrypted checks that bundle is encrypted as a
+// whole
+func TestStoreBundleIntoFileEncrypted(t *testing.T) {
+	entity, keyFile := mustCreateEncryptionKey(t)
+	fileName := filepath.Join(t.TempDir(), "export.zip")
+
+	err := main.StoreBundleIntoFile(fileName, "zip", prepareBundleDirectory(t),
+		mustReadEncryption(t, keyFile))
+	assert.NoError(t, err)
+
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	content, err := os.ReadFile(fileName + ".gpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "PK", string(decrypt(t, entity, content)[:2]))
+}
+
+// TestStoreManifestIntoS3Encrypted checks that manifest of unchanged tables
+// is encrypted
+func TestStoreManifestIntoS3Encrypted(t *testing.T) {
+	entity, keyFile := mustCreateEncryptionKey(t)
+	storage, session := startFakeS3(t)
+
+	manifest := main.NewManifest()
+	manifest.Tables["report"] = main.ManifestEntry{Object: "prefix/report.csv.gpg"}
+
+	err := main.StoreManifestIntoS3(context.Background(), session, "bucket",
+		"prefix/_manifest.json", manifest, mustReadEncryption(t, keyFile))
+	assert.NoError(t, err)
+	assert.NotContains(t, storage.objects, "/bucket/prefix/_manifest.json")
+
+	read, err := main.ReadManifest(bytes.NewReader(
+		decrypt(t, entity, storage.objects["/bucket/prefix/_manifest.json.gpg"])))
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, read)
+}
+
+// TestPerformDataExportEncrypted checks that all artifacts exported into
+// files are encrypted
+func TestPerformDataExportEncrypted(t *testing.T) {
+	entity, fileName := mustCreateEncryptionKey(t)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithRuleHits(t),
+		},
+		Export: main.ExportConfiguration{
+			EncryptionKeyFile: fileName,
+		},
+		Queries: main.QueriesConfiguration{
+			"hits_by_org": "SELECT org_id, count(*) AS hits FROM rule_hit GROUP BY org_id ORDER BY org_id",
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// no artifact is stored in plain text
+	_, err = os.Stat(filepath.Join(directory, "rule_hit.csv"))
+	assert.True(t, os.IsNotExist(err))
+
+	content, err := os.ReadFile(filepath.Join(directory, "rule_hit.csv.gpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,rule_fqdn\n1,a\n1,b\n2,a\n", string(decrypt(t, entity, content)))
+
+	content, err = os.ReadFile(filepath.Join(directory, "_query_hits_by_org.csv.gpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,hits\n1,2\n2,1\n", string(decrypt(t, entity, content)))
+
+	// manifest of the run is encrypted too
+	summary := main.NewSummary()
+	summary.Finish()
+	code, err = main.StoreRunManifest(&configuration, cliFlags, "0123456789abcdef",
+		summary, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err = os.ReadFile(filepath.Join(directory, "_manifest.json.gpg"))
+	assert.NoError(t, err)
+	manifest, err := main.ReadManifest(bytes.NewReader(decrypt(t, entity, content)))
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", manifest.RunID)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains errors returned by the exporter that callers
+// can branch on. Errors are compared by errors.Is (and errors.As for errors
+// carrying more information) instead of matching their messages, so the
+// messages can change without breaking callers.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/errors.html
+
+import (
+	"errors"
+	"fmt"
+)
+
+// sentinel errors
+var (
+	// ErrNilConfiguration is returned when connection is opened without
+	// configuration
+	ErrNilConfiguration = errors.New(configurationIsNil)
+
+	// ErrNilMinioClient is returned when S3 operation is called without
+	// Minio client
+	ErrNilMinioClient = errors.New(minioClientIsNil)
+
+	// ErrBucketNotSet is returned when S3 operation is called without
+	// bucket name
+	ErrBucketNotSet = errors.New(bucketNameIsNotSet)
+
+	// ErrObjectNotSet is returned when S3 operation is called without
+	// object name
+	ErrObjectNotSet = errors.New(objectNameIsNotSet)
+
+	// ErrUnsupportedDriver is returned when database driver is not
+	// supported, UnsupportedDriverError matches it too
+	ErrUnsupportedDriver = errors.New("database driver is not supported")
+
+	// ErrPrefixLocked is returned when prefix in S3 is locked by another
+	// export, PrefixLockedError matches it too
+	ErrPrefixLocked = errors.New("prefix is locked by another export")
+
+	// ErrQueryRejected is returned when custom query is not read-only,
+	// RejectedQueryError matches it too
+	ErrQueryRejected = errors.New("query is rejected")
+)
+
+// UnsupportedDriverError is returned when driver selected in configuration
+// is not supported. It is matched by ErrUnsupportedDriver.
+type UnsupportedDriverError struct {
+	Driver string
+}
+
+// Error method returns name of the driver in human readable form
+func (e *UnsupportedDriverError) Error() string {
+	return fmt.Sprintf("driver %v is not supported", e.Driver)
+}
+
+// Is method makes the error match ErrUnsupportedDriver
+func (e *UnsupportedDriverError) Is(target error) bool {
+	return target == ErrUnsupportedDriver
+}
+
+// PrefixLockedError is returned when lock of prefix in S3 can't be acquired,
+// because it is held by another export. It is matched by ErrPrefixLocked.
+type PrefixLockedError struct {
+	Prefix string
+	Holder string
+	// Until is expiration of the lock, it is empty when the lock has been
+	// acquired by another export at the same time
+	Until string
+}
+
+// Error method returns holder of the lock in human readable form
+func (e *PrefixLockedError) Error() string {
+	if e.Until == "" {
+		return fmt.Sprintf(lockTakenOver, e.Prefix, e.Holder)
+	}
+	return fmt.Sprintf(prefixIsLocked, e.Prefix, e.Holder, e.Until)
+}
+
+// Is method makes the error match ErrPrefixLocked
+func (e *PrefixLockedError) Is(target error) bool {
+	return target == ErrPrefixLocked
+}
+
+// RejectedQueryError is returned when custom query is not exactly one
+// read-only SELECT statement. It is matched by ErrQueryRejected.
+type RejectedQueryError struct {
+	Reason string
+}
+
+// Error method returns reason of rejection in human readable form
+func (e *RejectedQueryError) Error() string {
+	return "query is rejected: " + e.Reason
+}
+
+// Is method makes the error match ErrQueryRejected
+func (e *RejectedQueryError) Is(target error) bool {
+	return target == ErrQueryRejected
+}
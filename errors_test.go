@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/errors_test.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestUnsupportedDriverError checks that unsupported driver can be
+// recognized by errors.Is and errors.As
+func TestUnsupportedDriverError(t *testing.T) {
+	_, err := main.NewStorage(&main.StorageConfiguration{
+		Driver: "mysql",
+	})
+	assert.ErrorIs(t, err, main.ErrUnsupportedDriver)
+
+	var driverError *main.UnsupportedDriverError
+	assert.True(t, errors.As(err, &driverError))
+	assert.Equal(t, "mysql", driverError.Driver)
+
+	// wrapped error is recognized too
+	assert.ErrorIs(t, fmt.Errorf("export failed: %w", err), main.ErrUnsupportedDriver)
+	assert.NotErrorIs(t, err, main.ErrNilConfiguration)
+}
+
+// TestNilConfigurationError checks that missing configuration can be
+// recognized by errors.Is
+func TestNilConfigurationError(t *testing.T) {
+	_, err := main.OpenS3Session(nil)
+	assert.ErrorIs(t, err, main.ErrNilConfiguration)
+
+	_, _, err = main.NewSFTPConnection(nil)
+	assert.ErrorIs(t, err, main.ErrNilConfiguration)
+
+	_, err = main.NewKafkaProducer(nil)
+	assert.ErrorIs(t, err, main.ErrNilConfiguration)
+}
+
+// TestS3ArgumentErrors checks that wrong arguments of S3 operations can be
+// recognized by errors.Is
+func TestS3ArgumentErrors(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := main.S3BucketExists(ctx, nil, "bucket")
+	assert.ErrorIs(t, err, main.ErrNilMinioClient)
+
+	_, err = main.S3BucketExists(ctx, mustConstructS3Session(t), "")
+	assert.ErrorIs(t, err, main.ErrBucketNotSet)
+
+	err = main.StoreTableNames(ctx, mustConstructS3Session(t), "bucket", "",
+		[]main.TableName{}, "", nil)
+	assert.ErrorIs(t, err, main.ErrObjectNotSet)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains machine-readable description of exit statuses.
+// Mapping of exit statuses to their names and descriptions can be printed
+// as JSON by -exit-statuses flag and exit status of the run together with
+// its reason is recorded in manifest of the run and in status document, so
+// wrapper automation can react to each class of failures.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/exitstatus.html
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ExitStatusInfo describes one exit status in machine-readable form
+type ExitStatusInfo struct {
+	Code        int    `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// exitStatuses contains description of all exit statuses ordered by their
+// codes
+var exitStatuses = []ExitStatusInfo{
+	{ExitStatusOK, "ok", "export finished with success"},
+	{ExitStatusLoggingError, "logging_error", "logging could not be initialized"},
+	{ExitStatusStorageError, "storage_error", "database could not be read"},
+	{ExitStatusS3Error, "s3_error", "S3 connection or upload failed"},
+	{ExitStatusConfigurationError, "configuration_error", "configuration or command line flags are wrong"},
+	{ExitStatusIOError, "io_error", "file could not be read or written"},
+	{ExitStatusSFTPError, "sftp_error", "SFTP connection or upload failed"},
+	{ExitStatusKafkaError, "kafka_error", "Kafka connection or publishing failed"},
+	{ExitStatusHookError, "hook_error", "post-processing command failed for some artifacts"},
+	{ExitStatusRowCountMismatch, "verification_failed", "number of exported rows differs from number of records"},
+	{ExitStatusSheetsError, "sheets_error", "Google Sheets could not be updated"},
+	{ExitStatusPartialSuccess, "partial_success", "some tables have not been exported"},
+	{ExitStatusTimeout, "timeout", "export exceeded run timeout"},
+	{ExitStatusLockContention, "lock_contention", "prefix is locked by another export"},
+	{ExitStatusNotificationError, "notification_failed", "webhook notification failed for some artifacts"},
+}
+
+// RunExitStatus contains exit status of the run together with its reason
+type RunExitStatus struct {
+	Code   int    `json:"code"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// exitStatusName function returns name of given exit status, code itself is
+// returned for unknown exit status
+func exitStatusName(code int) string {
+	if code >= 0 && code < len(exitStatuses) {
+		return exitStatuses[code].Name
+	}
+	return strconv.Itoa(code)
+}
+
+// newRunExitStatus function constructs exit status of the run, reason is
+// taken from error the run failed with
+func newRunExitStatus(code int, err error) *RunExitStatus {
+	status := &RunExitStatus{
+		Code: code,
+		Name: exitStatusName(code),
+	}
+	if err != nil {
+		status.Reason = err.Error()
+	}
+	return status
+}
+
+// writeExitStatuses function writes description of all exit statuses into
+// given writer as JSON
+func writeExitStatuses(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exitStatuses)
+}
+
+// showExitStatuses function displays description of all exit statuses as
+// JSON
+func showExitStatuses() error {
+	return writeExitStatuses(os.Stdout)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/exitstatus_test.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestWriteExitStatuses checks that all exit statuses are described by
+// unique names
+func TestWriteExitStatuses(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.WriteExitStatuses(buffer))
+
+	var statuses []main.ExitStatusInfo
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &statuses))
+	assert.Len(t, statuses, main.ExitStatusNotificationError+1)
+
+	names := make(map[string]bool)
+	for code, status := range statuses {
+		assert.Equal(t, code, status.Code)
+		assert.NotEmpty(t, status.Name)
+		assert.NotEmpty(t, status.Description)
+		assert.False(t, names[status.Name], status.Name)
+		names[status.Name] = true
+	}
+}
+
+// TestExitStatusName checks the function ExitStatusName
+func TestExitStatusName(t *testing.T) {
+	assert.Equal(t, "ok", main.ExitStatusName(main.ExitStatusOK))
+	assert.Equal(t, "verification_failed", main.ExitStatusName(main.ExitStatusRowCountMismatch))
+	assert.Equal(t, "timeout", main.ExitStatusName(main.ExitStatusTimeout))
+	assert.Equal(t, "lock_contention", main.ExitStatusName(main.ExitStatusLockContention))
+	assert.Equal(t, "notification_failed", main.ExitStatusName(main.ExitStatusNotificationError))
+
+	// unknown exit status
+	assert.Equal(t, "42", main.ExitStatusName(42))
+}
+
+// TestSummaryExitStatus checks that exit status is recorded in summary
+// together with its reason
+func TestSummaryExitStatus(t *testing.T) {
+	var nilSummary *main.Summary
+	nilSummary.SetExitStatus(main.ExitStatusOK, nil)
+	assert.Nil(t, nilSummary.ExitStatus())
+
+	summary := main.NewSummary()
+	assert.Nil(t, summary.ExitStatus())
+
+	summary.SetExitStatus(main.ExitStatusLockContention, main.ErrPrefixLocked)
+	assert.Equal(t, &main.RunExitStatus{
+		Code:   13,
+		Name:   "lock_contention",
+		Reason: "prefix is locked by another export",
+	}, summary.ExitStatus())
+}
@@ -21,6 +21,12 @@ limitations under the License.
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/export_test.html
 package main
 
+import (
+	"context"
+
+	"github.com/minio/minio-go/v7"
+)
+
 // Export for testing
 //
 // This source file contains name aliases of all package-private functions
@@ -42,12 +48,415 @@ var (
 	PerformDataExport         = performDataExport
 	ConstructIgnoredTablesMap = constructIgnoredTablesMap
 	SetObjectPrefix           = setObjectPrefix
+	DataExportSelected        = dataExportSelected
+	ConstructSkippedArtifacts = constructSkippedArtifacts
+	OperationName             = operationName
 
 	// exported functions from the s3.go source file
 	S3BucketExists  = s3BucketExists
 	StoreTableNames = storeTableNames
+	PutObjectStream = putObjectStream
+	PutObject       = putObject
 
 	// exported functions from the file.go source file
 	StoreTableNamesIntoFile    = storeTableNamesIntoFile
 	StoreDisabledRulesIntoFile = storeDisabledRulesIntoFile
+
+	// exported constants from the summary.go source file
+	StageDiscovery  = stageDiscovery
+	StageDataRead   = stageDataRead
+	StageConversion = stageConversion
+	StageUpload     = stageUpload
+
+	// exported functions from the summary.go source file
+	PrintSummary = printSummary
+
+	// exported functions from the recommendations.go source file
+	RecommendTuning = recommendTuning
+
+	// exported functions from the presign.go source file
+	PresignArtifacts   = presignArtifacts
+	CheckPresignExpiry = checkPresignExpiry
+	SplitS3Location    = splitS3Location
+
+	// exported functions from the prefixtemplate.go source file
+	ExpandPrefix           = expandPrefix
+	NewPrefixTemplateData  = newPrefixTemplateData
+	ExpandConfiguredPrefix = expandConfiguredPrefix
+
+	// exported functions from the permissions.go source file
+	CheckDatabasePermissions = checkDatabasePermissions
+	CheckBucketPermissions   = checkBucketPermissions
+	PrintPermissionChecks    = printPermissionChecks
+	CheckPermissions         = checkPermissions
+
+	// exported functions from the metrics.go source file
+	WriteMetrics     = writeMetrics
+	WriteMetricsFile = writeMetricsFile
+
+	// exported functions from the avro.go source file
+	AvroName = avroName
+
+	// exported functions from the sqldump.go source file
+	SQLLiteral      = sqlLiteral
+	SQLType         = sqlType
+	QuoteIdentifier = quoteIdentifier
+
+	// exported functions from the configcheck.go source file
+	ValidateConfiguration       = validateConfiguration
+	ValidateOutputConfiguration = validateOutputConfiguration
+
+	// exported functions from the config.go source file
+	InterpolateString       = interpolateString
+	InterpolateEnvVariables = interpolateEnvVariables
+
+	// exported functions from the xlsx.go source file
+	ColumnLetters = columnLetters
+
+	// exported functions from the duckdb.go source file
+	DuckDBImportScript = duckDBImportScript
+	RunDuckDB          = runDuckDB
+
+	// exported functions from the compression.go source file
+	CheckCompression     = checkCompression
+	CompressionExtension = compressionExtension
+	ArtifactExtension    = artifactExtension
+	CompressData         = compressData
+	CreateCompressedFile = createCompressedFile
+	ObjectCompression    = objectCompression
+	ContentEncoding      = contentEncoding
+	NewDecompressor      = newDecompressor
+
+	// exported functions from the encryption.go source file
+	ArtifactEncryption  = artifactEncryption
+	ArtifactCompression = artifactCompression
+
+	// exported functions from the metricsregistry.go source file
+	NewMetrics = newMetrics
+
+	// exported functions from the rangeread.go source file
+	SplitIntegerRange = splitIntegerRange
+
+	// exported functions from the bundle.go source file
+	CheckBundle         = checkBundle
+	WriteBundle         = writeBundle
+	StoreBundleIntoFile = storeBundleIntoFile
+
+	// exported functions from the changedetection.go source file
+	ContentHash         = contentHash
+	ReadManifest        = readManifest
+	ReadManifestFromS3  = readManifestFromS3
+	StoreManifestIntoS3 = storeManifestIntoS3
+	S3ObjectExists      = s3ObjectExists
+
+	// exported functions from the casts.go source file
+	CastColumns = castColumns
+
+	// exported functions from the audit.go source file
+	KeyRangeOfRows     = keyRangeOfRows
+	CheckAuditChecksum = checkAuditChecksum
+
+	// exported functions from the sftp.go source file
+	StoreExportIntoSFTP = storeExportIntoSFTP
+
+	// exported functions from the logging.go source file
+	NewRunID     = newRunID
+	NewRunLogger = newRunLogger
+
+	// exported functions from the kafka.go source file
+	KafkaTopic              = kafkaTopic
+	NewKafkaTableWriter     = newKafkaTableWriter
+	PublishRejectsIntoKafka = publishRejectsIntoKafka
+	PublishTablesIntoKafka  = publishTablesIntoKafka
+
+	// exported functions from the circuitbreaker.go source file
+	IsTransientDBError = isTransientDBError
+
+	// exported functions from the multioutput.go source file
+	ParseOutputs = parseOutputs
+	CheckOutputs = checkOutputs
+
+	// exported functions from the resume.go source file
+	CheckResume         = checkResume
+	ListExportedObjects = listExportedObjects
+
+	// exported functions from the multioutput.go and sink.go source files
+	ExportedIntoSinks = exportedIntoSinks
+
+	// exported functions from the contentaddressed.go source file
+	ContentObjectName = contentObjectName
+
+	// exported functions from the quarantine.go source file
+	RejectsFileName = rejectsFileName
+
+	// exported functions from the encoding.go source file
+	CheckInvalidUTF8Handling = checkInvalidUTF8Handling
+	ValidateUTF8             = validateUTF8
+
+	// exported functions from the schedule.go source file
+	StartJitter     = startJitter
+	WaitForStart    = waitForStart
+	ErrDatabaseBusy = errDatabaseBusy
+
+	// exported functions from the replicalag.go source file
+	CheckReplicaLag       = checkReplicaLag
+	CheckReplicaLagAction = checkReplicaLagAction
+	ErrStaleReplica       = errStaleReplica
+
+	// exported functions from the trend.go source file
+	StoreDisabledRulesTrendIntoS3 = storeDisabledRulesTrendIntoS3
+
+	// exported functions from the tablefilter.go source file
+	ParseTableList          = parseTableList
+	ReadTableList           = readTableList
+	SelectedTablesFromFlags = selectedTablesFromFlags
+
+	// exported functions from the schemas.go source file
+	CheckTableNameCollisions = checkTableNameCollisions
+	LookupTableConfig        = lookupTableConfig[string]
+	QuoteTableName           = quoteTableName
+
+	// exported functions from the configsnapshot.go source file
+	StoreConfigSnapshot = storeConfigSnapshot
+
+	// exported functions from the sqlite.go source file
+	SQLiteColumnType = sqliteColumnType
+
+	// exported functions from the digest.go source file
+	StoreDigest = storeDigest
+
+	// exported functions from the hooks.go source file
+	RunArtifactHooks = runArtifactHooks
+
+	// exported functions from the typeinference.go source file
+	ValueType  = valueType
+	MergeTypes = mergeTypes
+
+	// exported functions from the outputprofile.go source file
+	OutputProfileForOutput = outputProfile
+
+	// exported functions from the sheets.go source file
+	StoreReportsIntoSheets = storeReportsIntoSheets
+
+	// exported functions from the orgsummary.go source file
+	TimestampValue = timestampValue
+
+	// exported functions from the queryplan.go source file
+	PlanEstimate = planEstimate
+
+	// exported functions from the recommendationstrend.go source file
+	TrendWindowStart = trendWindowStart
+	CheckTrendPeriod = checkTrendPeriod
+
+	// exported functions from the tlsconfig.go source file
+	ConfigureTLS = configureTLS
+	HTTPClient   = httpClient
+
+	// exported functions from the runtimeout.go source file
+	WithRunTimeout        = withRunTimeout
+	RunTimedOut           = runTimedOut
+	StorePartialArtifacts = storePartialArtifacts
+
+	// exported functions from the manifest.go source file
+	StoreRunManifest = storeRunManifest
+	ManifestLocation = manifestLocation
+
+	// exported functions from the format.go source file
+	NewCSVTableWriter = newCSVTableWriter
+
+	// exported functions from the s3upload.go source file
+	SetUploadOptions = (*S3Session).setUploadOptions
+
+	// exported functions from the s3retry.go source file
+	IsTransientS3Error = isTransientS3Error
+	RetryS3Operation   = (*S3Session).retryOperation
+
+	// exported functions from the sqlsandbox.go source file
+	CheckReadOnlyQuery = checkReadOnlyQuery
+	CheckSQLExpression = checkSQLExpression
+
+	// exported functions from the s3lock.go source file
+	AcquireExportLock = acquireExportLock
+	RenewExportLock   = renewExportLock
+	ReleaseExportLock = releaseExportLock
+	ExportedIntoS3    = exportedIntoS3
+	LockExportPrefix  = lockExportPrefix
+
+	// exported functions from the exitstatus.go source file
+	ExitStatusName    = exitStatusName
+	WriteExitStatuses = writeExitStatuses
+
+	// exported functions from the sampling.go source file
+	CheckSample = checkSample
+
+	// exported functions from the watermark.go source file
+	WatermarkLess = watermarkLess
+
+	// exported functions from the masking.go source file
+	HashValue        = hashValue
+	PseudonymValue   = pseudonymValue
+	FakeValue        = fakeValue
+	LoadPseudonymKey = loadPseudonymKey
+	TypedConstant    = typedConstant
+	ConstantValue    = constantValue
+
+	// exported functions from the bufferpool.go source file
+	GetBuffer    = getBuffer
+	PutBuffer    = putBuffer
+	NewCSVWriter = newCSVWriter
+
+	// exported functions from the columnflags.go source file
+	ColumnFlagsIntoCSV = columnFlagsIntoCSV
 )
+
+// SetCasts function sets casts of columns used by given storage
+func SetCasts(storage *DBStorage, casts CastsConfiguration) {
+	storage.casts = casts
+}
+
+// SetAudit function sets audit of tables used by given storage
+func SetAudit(storage *DBStorage, audit *ExportAudit) {
+	storage.audit = audit
+}
+
+// SetCircuitBreaker function sets circuit breaker used by storage
+func SetCircuitBreaker(storage *DBStorage, breaker *CircuitBreaker) {
+	storage.breaker = breaker
+}
+
+// SetQuarantine function sets quarantine of rows used by storage
+func SetQuarantine(storage *DBStorage, quarantine *Quarantine) {
+	storage.quarantine = quarantine
+}
+
+// SetKeepGoing function makes given storage continue with remaining tables
+// when export of table fails, failures are recorded into summary
+func SetKeepGoing(storage *DBStorage, summary *Summary) {
+	storage.keepGoing = true
+	storage.summary = summary
+}
+
+// SetInvalidUTF8 function sets handling of invalid UTF-8 used by given
+// storage
+func SetInvalidUTF8(storage *DBStorage, handling string) {
+	storage.invalidUTF8 = handling
+}
+
+// Detached function returns copy of storage used by export phase running
+// concurrently with export of tables
+func Detached(storage *DBStorage) *DBStorage {
+	return storage.detached()
+}
+
+// CopyToApplicable function checks whether given table would be exported by
+// COPY TO command
+func CopyToApplicable(storage *DBStorage, tableName TableName, format string) bool {
+	return storage.copyToApplicable(tableName, format)
+}
+
+// SetCSVNullValue function sets value written into CSV instead of NULL by
+// given storage
+func SetCSVNullValue(storage *DBStorage, nullValue string) {
+	storage.csvNullValue = nullValue
+}
+
+// CopyStatement function constructs COPY command used to export result of
+// given query
+func CopyStatement(storage *DBStorage, sqlStatement string) string {
+	return storage.copyStatement(sqlStatement)
+}
+
+// AcquireCopyConnection function opens (or reuses) connection used by COPY
+// TO commands of given storage and releases it
+func AcquireCopyConnection(storage *DBStorage, dataSource string) error {
+	_, err := storage.copyConnection.acquire(context.Background(), dataSource)
+	if err != nil {
+		return err
+	}
+	storage.copyConnection.release()
+	return nil
+}
+
+// SetOrdering function sets ordering of rows used by given storage
+func SetOrdering(storage *DBStorage, orderRows bool, ordering OrderingConfiguration) {
+	storage.orderRows = orderRows
+	storage.ordering = ordering
+}
+
+// SetLimits function sets maximal numbers of rows exported from tables by
+// given storage
+func SetLimits(storage *DBStorage, limits LimitsConfiguration) {
+	storage.limits = limits
+}
+
+// SetSample function sets fraction of rows randomly selected from exported
+// tables
+func SetSample(storage *DBStorage, sample float64) {
+	storage.sample = sample
+}
+
+// SetMasking function sets masking methods of anonymized columns used by
+// given storage
+func SetMasking(storage *DBStorage, masking MaskingConfiguration) {
+	storage.masking = masking
+}
+
+// SetColumnProfile function sets profile of exported columns used by given
+// storage
+func SetColumnProfile(storage *DBStorage, profile *ColumnProfile) {
+	storage.profile = profile
+}
+
+// ProfileTable function profiles given rows of table and records flagged
+// columns into profile
+func ProfileTable(profile *ColumnProfile, tableName TableName, colNames []string, rows []M) {
+	profiler := profile.newTableProfiler(colNames)
+	for _, row := range rows {
+		profiler.Add(row)
+	}
+	profile.Record(tableName, profiler)
+}
+
+// SetRowCountVerification function sets summary mismatched numbers of rows
+// are recorded into and enables verification of number of exported rows
+func SetRowCountVerification(storage *DBStorage, summary *Summary) {
+	storage.summary = summary
+	storage.verifyRowCounts = true
+}
+
+// SetTableCheckpoints function enables upload of tables with checkpoints
+func SetTableCheckpoints(storage *DBStorage, checkpoints, resume bool) {
+	storage.checkpointTables = checkpoints
+	storage.resumeTables = resume
+}
+
+// CheckColumnMask function checks masking method configured for column
+func CheckColumnMask(spec string) error {
+	_, err := parseColumnMask(spec)
+	return err
+}
+
+// ReleaseHeldExportLock function stops renewals of lock acquired by
+// lockExportPrefix and releases it
+func ReleaseHeldExportLock(lock *heldExportLock) {
+	lock.release()
+}
+
+// NewS3Session function constructs session that uses given client and
+// settings of S3 operations
+func NewS3Session(client *minio.Client, configuration S3Configuration) *S3Session {
+	return &S3Session{client: client, configuration: configuration}
+}
+
+// SetSessionArtifacts function sets log objects stored by given session are
+// recorded into and enables checksum sidecars of the objects
+func SetSessionArtifacts(session *S3Session, artifacts *ArtifactLog, checksums bool) {
+	session.artifacts = artifacts
+	session.checksums = checksums
+}
+
+// SetSessionMetrics function sets metrics backend S3 requests of given
+// session are reported into
+func SetSessionMetrics(session *S3Session, metrics Metrics) {
+	session.metrics = metrics
+}
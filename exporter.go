@@ -24,10 +24,15 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -35,7 +40,7 @@ import (
 
 // Messages
 const (
-	versionMessage         = "Insights Results Aggregator Exporter version 1.0"
+	versionMessage         = "Insights Results Aggregator Exporter version " + exporterVersion
 	authorsMessage         = "Pavel Tisnovsky, Red Hat Inc."
 	operationFailedMessage = "Operation failed"
 	listOfTablesMsg        = "List of tables"
@@ -67,6 +72,42 @@ const (
 	// ExitStatusIOError is returned in case of any I/O error (export data
 	// into file failed etc.)
 	ExitStatusIOError
+
+	// ExitStatusSFTPError is returned in case of any error related with
+	// SFTP connection or upload
+	ExitStatusSFTPError
+
+	// ExitStatusKafkaError is returned in case of any error related with
+	// Kafka connection or publishing messages
+	ExitStatusKafkaError
+
+	// ExitStatusHookError is returned when any post-processing hook failed
+	// for any produced artifact
+	ExitStatusHookError
+
+	// ExitStatusRowCountMismatch is returned when number of rows exported
+	// from any table differs from number of its records
+	ExitStatusRowCountMismatch
+
+	// ExitStatusSheetsError is returned in case of any error related with
+	// Google Sheets authentication or update of sheets
+	ExitStatusSheetsError
+
+	// ExitStatusPartialSuccess is returned when export continued after
+	// failure and some tables have not been exported
+	ExitStatusPartialSuccess
+
+	// ExitStatusTimeout is returned when export has been interrupted because
+	// it took longer than configured run timeout
+	ExitStatusTimeout
+
+	// ExitStatusLockContention is returned when prefix in S3 is locked by
+	// another export
+	ExitStatusLockContention
+
+	// ExitStatusNotificationError is returned when webhook notification
+	// failed for any produced artifact (and all commands succeeded)
+	ExitStatusNotificationError
 )
 
 const (
@@ -82,6 +123,40 @@ const (
 	logFile       = "_logs.txt"
 )
 
+// name of file or object with all exported tables (xlsx and sqlite formats
+// only), extension depends on format
+const archiveFile = "export"
+
+// names of artifacts that can be skipped during export
+const (
+	tablesListArtifact    = "tables-list"
+	metadataArtifact      = "metadata"
+	disabledRulesArtifact = "disabled-rules"
+	logArtifact           = "log"
+	configArtifact        = "config"
+	queriesArtifact       = "queries"
+	sequencesArtifact     = "sequences"
+	constraintsArtifact   = "constraints"
+	ruleHitsArtifact      = "rule-hits"
+	orgSummaryArtifact    = "org-summary"
+	trendArtifact         = "recommendations-trend"
+)
+
+// artifactNames contains names of all artifacts that can be skipped
+var artifactNames = []string{
+	tablesListArtifact,
+	metadataArtifact,
+	disabledRulesArtifact,
+	logArtifact,
+	configArtifact,
+	queriesArtifact,
+	sequencesArtifact,
+	constraintsArtifact,
+	ruleHitsArtifact,
+	orgSummaryArtifact,
+	trendArtifact,
+}
+
 // messages
 const (
 	readDisabledRulesInfoFailed      = "Read disabled rules info failed"
@@ -93,14 +168,29 @@ const (
 	exportingTable                   = "Exporting table"
 	exportingMetadata                = "Exporting metadata"
 	unknownOutputType                = "Unknown output type: %s"
+	unknownArtifact                  = "Unknown artifact to skip: %s"
+	artifactIsSkipped                = "Artifact is skipped"
+	artifactMsg                      = "Artifact"
+	createArchiveFailed              = "Unable to create archive for exported tables"
+	createExportDirectoryFailed      = "Unable to create temporary directory for exported files"
+	storeExportedFilesFailed         = "Store exported files failed"
 )
 
 // flags
 const (
-	s3Output   = "S3"
-	fileOutput = "file"
+	s3Output     = "S3"
+	fileOutput   = "file"
+	duckDBOutput = "duckdb"
+	sftpOutput   = "sftp"
+	kafkaOutput  = "kafka"
 )
 
+// supportedOutputs contains names of all outputs the data can be exported
+// into
+var supportedOutputs = []string{
+	fileOutput, s3Output, duckDBOutput, sftpOutput, kafkaOutput,
+}
+
 // showVersion function displays version information.
 func showVersion() {
 	fmt.Println(versionMessage)
@@ -163,30 +253,364 @@ func constructIgnoredTablesMap(input string) IgnoredTables {
 	return m
 }
 
-// performDataExport function exports all data into selected output
-func performDataExport(configuration *ConfigStruct, cliFlags CliFlags, operationLogger *zerolog.Logger) (int, error) {
+// constructSkippedArtifacts function constructs set of artifacts that won't
+// be exported. Artifacts can be specified on command line (comma-separated
+// list) and in configuration file, both lists are merged.
+func constructSkippedArtifacts(input string, configured []string) (SkippedArtifacts, error) {
+	artifacts := make([]string, 0, len(configured)+len(artifactNames))
+	if input != "" {
+		artifacts = append(artifacts, strings.Split(input, ",")...)
+	}
+	artifacts = append(artifacts, configured...)
+
+	m := make(SkippedArtifacts, len(artifacts))
+
+	for _, artifact := range artifacts {
+		artifact = strings.TrimSpace(artifact)
+		if !isKnownArtifact(artifact) {
+			return nil, fmt.Errorf(unknownArtifact, artifact)
+		}
+		m[artifact] = struct{}{}
+	}
+
+	return m, nil
+}
+
+// isKnownArtifact function checks if given artifact name can be skipped
+func isKnownArtifact(artifact string) bool {
+	for _, name := range artifactNames {
+		if name == artifact {
+			return true
+		}
+	}
+	return false
+}
+
+// performDataExport function exports all data into selected output. Export
+// can be split into public and restricted artifact sets. Exported artifacts
+// are recorded into given log (when it is not nil) and counters and timers
+// are reported into given metrics backend.
+func performDataExport(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	logger, operationLogger *zerolog.Logger, summary *Summary, artifacts *ArtifactLog,
+	metrics Metrics) (status int, err error) {
+	// status of running export can be watched by external dashboards
+	progress := NewProgress()
+	reporter := startProgressReporter(configuration, progress, logger)
+	defer func() {
+		reporter.Stop(status, err)
+	}()
+
+	// rules disabled by more users are always reported when only reports
+	// are exported (but not into public artifact set of split export)
+	if cliFlags.ReportsOnly {
+		cliFlags.ExportMetadata = false
+		cliFlags.ExportDisabledRules = true
+	}
+
+	if splitConfigured(GetSplitConfiguration(configuration)) {
+		status, err = performSplitDataExport(ctx, configuration, cliFlags, logger,
+			operationLogger, summary, progress, artifacts, metrics)
+	} else {
+		status, err = performDataExportOfSet(ctx, configuration, cliFlags, logger,
+			operationLogger, summary, progress, artifacts, metrics)
+	}
+	if status != ExitStatusOK || err != nil {
+		return status, err
+	}
+
+	// export might have continued after failures
+	return checkFailedTables(operationLogger, summary)
+}
+
+// performDataExportOfSet function exports all data (or data of artifact set
+// selected by flags) into selected output
+func performDataExportOfSet(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	logger, operationLogger *zerolog.Logger, summary *Summary, progress *Progress,
+	artifacts *ArtifactLog, metrics Metrics) (int, error) {
 	operationLogger.Info().Msg("Retrieving connection to storage")
 
-	// prepare the storage
+	// prepare the storage, schemas selected on command line override
+	// configuration
 	storageConfiguration := GetStorageConfiguration(configuration)
+	if schemas := parseTableList(cliFlags.Schema); len(schemas) > 0 {
+		storageConfiguration.Schemas = schemas
+	}
 	storage, err := NewStorage(&storageConfiguration)
 	if err != nil {
-		log.Err(err).Msg(operationFailedMessage)
+		logger.Err(err).Msg(operationFailedMessage)
 		operationLogger.Err(err).Msg("Unable to retrieve connection to storage")
 		return ExitStatusStorageError, err
 	}
 
+	// connection is closed on all error paths too, outputs close it
+	// explicitly after successful export (closing twice is harmless)
+	defer func() {
+		_ = storage.Close()
+	}()
+
+	// all messages logged by storage belong to this run
+	storage = storage.WithLogger(*logger)
+
+	// time spent in individual stages is measured by storage too
+	storage.summary = summary
+
+	// progress of the export is tracked while tables are exported
+	storage.progress = progress
+
+	// counters and timers are reported into backend shared with S3 layer
+	storage.metrics = metrics
+
+	// exported artifacts are recorded for post-processing hooks and their
+	// integrity can be verified by checksum sidecars
+	storage.artifacts = artifacts
+	storage.checksums = GetExportConfiguration(configuration).Checksums
+
+	// all exported objects and files are compressed by the same codec and
+	// encrypted for the same public keys
+	storage.compression = artifactCompression(configuration)
+	storage.encryption, err = artifactEncryption(configuration, cliFlags)
+	if err != nil {
+		logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg("Unable to read encryption keys")
+		return ExitStatusConfigurationError, err
+	}
+
+	// files can be exported into other than current directory
+	storage.directory = cliFlags.OutputDirectory
+
+	// files with tables can be striped across more directories, bundled
+	// files are always exported into one temporary directory
+	if !exportedIntoDirectory(cliFlags) {
+		storage.directories = GetExportConfiguration(configuration).Directories
+	}
+
+	// selected columns are cast by SQL expressions
+	storage.casts = GetCastsConfiguration(configuration)
+
+	// results of named custom queries are exported with tables
+	storage.queries = GetQueriesConfiguration(configuration)
+
+	// identity columns are written into SQL dump as configured
+	storage.identityColumns = GetExportConfiguration(configuration).IdentityColumns
+
+	// tables exported into CSV can be accompanied by schema sidecar files
+	storage.csvSchemaSidecars = GetExportConfiguration(configuration).CSVSchemaSidecars
+
+	// NULL is distinguished from empty string in CSV by configured value
+	storage.csvNullValue = GetExportConfiguration(configuration).CSVNullValue
+	storage.inferenceRows = GetExportConfiguration(configuration).TypeInferenceRows
+
+	// rows can be ordered, so consecutive exports can be compared
+	storage.orderRows = GetExportConfiguration(configuration).OrderRows
+	storage.ordering = GetOrderingConfiguration(configuration)
+
+	// quick samples of selected tables can be exported
+	storage.limits = GetLimitsConfiguration(configuration)
+
+	// tables can be exported into objects with legacy names
+	storage.objectNames = GetObjectNamesConfiguration(configuration)
+
+	// sensitive columns are anonymized before they are exported,
+	// pseudonyms are consistent in all tables
+	storage.masking = GetMaskingConfiguration(configuration)
+	storage.pseudonymKey, err = loadPseudonymKey(
+		GetExportConfiguration(configuration).PseudonymSaltFile)
+	if err != nil {
+		operationLogger.Err(err).Msg("Unable to read pseudonym salt")
+		return ExitStatusConfigurationError, err
+	}
+
+	// random sample of rows can be exported instead of whole tables
+	storage.sample = cliFlags.Sample
+
+	// reads failed because of transient database errors are retried
+	storage.breaker = NewCircuitBreaker(storageConfiguration.CircuitBreakerThreshold,
+		storageConfiguration.RetryBudget, dbRetryDelay)
+
+	// rows exported from sensitive tables are audited
+	storage.audit = NewExportAudit(GetExportConfiguration(configuration).AuditedTables)
+	storage.audit.EnableChecksums(GetExportConfiguration(configuration).AuditChecksum,
+		GetExportConfiguration(configuration).AuditCheckpointRows)
+
+	// number of rows exported from tables is verified
+	storage.verifyRowCounts = GetExportConfiguration(configuration).VerifyRowCounts
+
+	// failed tables can be skipped instead of aborting the export
+	storage.keepGoing = cliFlags.KeepGoing
+
+	// disabled rules can be reported per organization too
+	storage.disabledRulesDetails = GetExportConfiguration(configuration).DisabledRulesDetails
+
+	// clusters hitting rules are reported per organization
+	storage.ruleHits = cliFlags.ExportRuleHits
+	storage.orgSummary = cliFlags.ExportOrgSummary
+
+	// hits and disables of rules can be reported over time
+	storage.trendReport = cliFlags.ExportTrend
+	storage.trendWindow = GetExportConfiguration(configuration).TrendWindow
+	storage.trendPeriod = GetExportConfiguration(configuration).TrendPeriod
+
+	// reports can be exported more often than tables
+	storage.reportsOnly = cliFlags.ReportsOnly
+
+	// columns NULL or constant in all exported rows are flagged
+	storage.profile = NewColumnProfile(GetExportConfiguration(configuration).ColumnFlags)
+
+	// rows that can't be exported are quarantined instead of failing,
+	// rejected rows with invalid UTF-8 are quarantined too
+	storage.invalidUTF8 = GetExportConfiguration(configuration).InvalidUTF8
+	if GetExportConfiguration(configuration).Quarantine ||
+		storage.invalidUTF8 == rejectInvalidUTF8 {
+		storage.quarantine = NewQuarantine()
+	}
+
+	// tables selected on command line (or in file given on command line)
+	// override configuration, excluded tables are merged
+	includedTables, err := selectedTablesFromFlags(cliFlags, os.Stdin)
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong tables selected")
+		return ExitStatusConfigurationError, err
+	}
+	if len(includedTables) == 0 {
+		includedTables = GetExportConfiguration(configuration).Tables
+	}
+	storage.tableFilter, err = NewTableFilter(includedTables,
+		append(parseTableList(cliFlags.ExcludeTables),
+			GetExportConfiguration(configuration).ExcludeTables...))
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong tables selected")
+		return ExitStatusConfigurationError, err
+	}
+
+	// only tables of selected artifact set are exported from split export
+	storage.tableFilter, err = artifactSetTableFilter(storage.tableFilter,
+		cliFlags.ArtifactSet, GetSplitConfiguration(configuration))
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong tables selected")
+		return ExitStatusConfigurationError, err
+	}
+
 	ignoredTablesMap := constructIgnoredTablesMap(cliFlags.IgnoredTables)
 
-	switch cliFlags.Output {
+	skipped, err := constructSkippedArtifacts(cliFlags.SkipArtifacts,
+		GetExportConfiguration(configuration).SkipArtifacts)
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong artifact to skip selected")
+		return ExitStatusConfigurationError, err
+	}
+
+	// CSV is the default output format
+	format := cliFlags.Format
+	if format == "" {
+		format = csvFormat
+	}
+
+	err = checkFormat(format)
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong output format selected")
+		return ExitStatusConfigurationError, err
+	}
+
+	err = checkOutputs(cliFlags.Output)
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong output type selected")
+		return ExitStatusConfigurationError, err
+	}
+
+	err = checkResume(cliFlags, GetS3Configuration(configuration).Prefix)
+	if err != nil {
+		operationLogger.Err(err).Msg("Export can't be resumed")
+		return ExitStatusConfigurationError, err
+	}
+
+	if GetExportConfiguration(configuration).ContentAddressed &&
+		exportOutput(cliFlags) != s3Output {
+		storage.logger.Warn().Msg(contentAddressedOnlyS3)
+		operationLogger.Warn().Msg(contentAddressedOnlyS3)
+	}
+
+	// scheduled export is deferred while the database is busy
+	err = waitForStart(ctx, storage, GetScheduleConfiguration(configuration), operationLogger)
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// data exported from lagging replica would be outdated
+	err = checkReplicaLag(ctx, storage, operationLogger)
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// only rows newer than watermarks of previous run are exported from
+	// incrementally exported tables
+	var status int
+	storage.watermarks, status, err = loadWatermarks(ctx, configuration)
+	if err != nil {
+		storage.logger.Err(err).Msg(readWatermarksFailed)
+		operationLogger.Err(err).Msg(readWatermarksFailed)
+		return status, err
+	}
+
+	status, err = dispatchDataExport(ctx, configuration, cliFlags, storage,
+		operationLogger, ignoredTablesMap, format, skipped, summary)
+	if status != ExitStatusOK || err != nil {
+		return status, err
+	}
+
+	// tables might have been exported only partially
+	if mismatches := summary.RowCountMismatches(); len(mismatches) > 0 {
+		err = fmt.Errorf(rowCountMismatches, len(mismatches))
+		operationLogger.Err(err).Msg(rowCountMismatch)
+		return ExitStatusRowCountMismatch, err
+	}
+
+	// watermarks are moved only when all tables have been exported
+	if len(summary.FailedTables()) > 0 {
+		storage.logger.Warn().Msg(watermarksNotStored)
+		operationLogger.Warn().Msg(watermarksNotStored)
+		return ExitStatusOK, nil
+	}
+	return storeWatermarks(ctx, configuration, storage.watermarks, operationLogger)
+}
+
+// dispatchDataExport function exports all data into output selected on
+// command line
+func dispatchDataExport(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	storage *DBStorage, operationLogger *zerolog.Logger, ignoredTablesMap IgnoredTables,
+	format string, skipped SkippedArtifacts, summary *Summary) (int, error) {
+	// each table is read only once when more outputs are selected, other
+	// registered sinks are used the same way
+	if exportedIntoSinks(cliFlags.Output) {
+		return performDataExportToOutputs(ctx, configuration, storage,
+			parseOutputs(cliFlags.Output),
+			cliFlags.ExportMetadata, cliFlags.ExportDisabledRules,
+			operationLogger, cliFlags.Limit, ignoredTablesMap, format,
+			skipped, summary)
+	}
+
+	switch exportOutput(cliFlags) {
 	case s3Output:
-		return performDataExportToS3(configuration, storage,
+		return performDataExportToS3(ctx, configuration, storage,
 			cliFlags.ExportMetadata, cliFlags.ExportDisabledRules,
-			operationLogger, cliFlags.Limit, ignoredTablesMap)
+			operationLogger, cliFlags.Limit, ignoredTablesMap, format,
+			skipped, cliFlags.Resume, cliFlags.DisabledRulesTrend, summary)
 	case fileOutput:
-		return performDataExportToFiles(configuration, storage,
+		return performDataExportToFiles(ctx, configuration, storage,
+			cliFlags.ExportMetadata, cliFlags.ExportDisabledRules,
+			operationLogger, cliFlags.Limit, ignoredTablesMap, format,
+			skipped, summary)
+	case duckDBOutput:
+		return performDataExportToDuckDB(ctx, configuration, storage,
+			cliFlags.ExportMetadata, cliFlags.ExportDisabledRules,
+			operationLogger, cliFlags.Limit, ignoredTablesMap, summary)
+	case kafkaOutput:
+		return performDataExportToKafka(ctx, configuration, storage,
 			cliFlags.ExportMetadata, cliFlags.ExportDisabledRules,
-			operationLogger, cliFlags.Limit, ignoredTablesMap)
+			operationLogger, cliFlags.Limit, ignoredTablesMap, summary)
 	default:
 		err := fmt.Errorf(unknownOutputType, cliFlags.Output)
 		operationLogger.Err(err).Msg("Wrong output type selected")
@@ -194,189 +618,427 @@ func performDataExport(configuration *ConfigStruct, cliFlags CliFlags, operation
 	}
 }
 
+// exportOutput function returns output the data are exported into. Bundle is
+// always constructed from exported files and files are uploaded into SFTP
+// server after export.
+func exportOutput(cliFlags CliFlags) string {
+	if exportedIntoDirectory(cliFlags) {
+		return fileOutput
+	}
+	return cliFlags.Output
+}
+
+// exportedIntoDirectory function returns true when all files need to be
+// exported into temporary directory first and stored into selected output
+// after export
+func exportedIntoDirectory(cliFlags CliFlags) bool {
+	return cliFlags.Bundle != "" || cliFlags.Output == sftpOutput
+}
+
+// storeExportedFiles function stores files exported into temporary directory
+// into selected output - as bundle or file by file into SFTP server
+func storeExportedFiles(configuration *ConfigStruct, cliFlags CliFlags) (int, error) {
+	if cliFlags.Output == sftpOutput {
+		err := storeExportIntoSFTP(configuration, cliFlags.Bundle, cliFlags.OutputDirectory)
+		if err != nil {
+			return ExitStatusSFTPError, err
+		}
+		return ExitStatusOK, nil
+	}
+
+	return storeBundle(configuration, cliFlags)
+}
+
 // performDataExportToS3 exports all tables and metadata info configured S3
 // bucket
-func performDataExportToS3(configuration *ConfigStruct,
+func performDataExportToS3(ctx context.Context, configuration *ConfigStruct,
 	storage *DBStorage, exportMetadata bool,
 	exportDisabledRules bool,
 	operationLogger *zerolog.Logger, limit int,
-	ignoredTables IgnoredTables) (int, error) {
+	ignoredTables IgnoredTables, format string,
+	skipped SkippedArtifacts, resume bool, trendRuns int,
+	summary *Summary) (int, error) {
 	operationLogger.Info().Msg("Exporting to S3")
 
 	operationLogger.Info().Msg(readingListOfTables)
 
-	minioClient, context, err := NewS3Connection(configuration)
+	session, err := storage.openS3Session(configuration)
 	if err != nil {
 		return ExitStatusS3Error, err
 	}
 
-	tableNames, err := storage.ReadListOfTables()
+	stopMeasuring := summary.MeasureStage(stageDiscovery)
+	tableNames, err := storage.ReadListOfTables(ctx)
+	stopMeasuring()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// only selected tables are exported
+	tableNames, err = storage.selectTables(tableNames)
 	if err != nil {
-		log.Err(err).Msg(operationFailedMessage)
+		storage.logger.Err(err).Msg(operationFailedMessage)
 		operationLogger.Err(err).Msg(operationFailedMessage)
 		return ExitStatusStorageError, err
 	}
 
-	log.Info().Int("tables count", len(tableNames)).Msg(listOfTablesMsg)
+	storage.logger.Info().Int("tables count", len(tableNames)).Msg(listOfTablesMsg)
 
 	// log into terminal
-	printTables(tableNames)
+	printTables(&storage.logger, tableNames)
+
+	bucket, bucketPrefix := session.Bucket(), session.Prefix()
+	storage.logger.Info().Str("bucket name", bucket).Msg("S3 bucket to write to")
+
+	// metadata and reports are exported while the tables are being
+	// exported, both phases use their own database connections. Reports
+	// are exported using storage with its own state, so the phases can't
+	// change each other's state.
+	reportsStorage := storage.detached()
+	var reportsStatus int
+	var reportsErr error
+	reportsDone := make(chan struct{})
+	go func() {
+		defer close(reportsDone)
+		reportsStatus, reportsErr = storeReportsIntoS3(ctx, reportsStorage,
+			session, bucket, bucketPrefix, tableNames, exportMetadata,
+			exportDisabledRules, operationLogger, skipped, trendRuns, format, summary)
+	}()
+
+	tablesStatus, tablesErr := storeTablesIntoS3(ctx, configuration, storage,
+		session, bucket, bucketPrefix, tableNames, operationLogger, limit,
+		ignoredTables, format, resume, summary)
+
+	// connection to storage can be closed only when both phases finished
+	<-reportsDone
+	if tablesErr != nil {
+		return tablesStatus, tablesErr
+	}
+	if reportsErr != nil {
+		return reportsStatus, reportsErr
+	}
+
+	operationLogger.Info().Msg(closingConnectionToStorage)
 
-	s3config := GetS3Configuration(configuration)
-	bucket, bucketPrefix := s3config.Bucket, s3config.Prefix
-	log.Info().Str("bucket name", bucket).Msg("S3 bucket to write to")
+	// we have finished, let's close the connection to database
+	err = storage.Close()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// default exit value + no error
+	return ExitStatusOK, nil
+}
+
+// storeReportsIntoS3 function exports metadata about tables, list of
+// disabled rules and results of custom queries into S3
+func storeReportsIntoS3(ctx context.Context, storage *DBStorage,
+	session *S3Session, bucket, bucketPrefix string,
+	tableNames []TableName, exportMetadata, exportDisabledRules bool,
+	operationLogger *zerolog.Logger, skipped SkippedArtifacts, trendRuns int,
+	format string, summary *Summary) (int, error) {
 	listOfTablesObject := setObjectPrefix(bucketPrefix, listOfTables)
-	metadataTableObject := setObjectPrefix(bucketPrefix, metadataTable)
+	metadataTableObject := setObjectPrefix(bucketPrefix, reportName(metadataTable, format))
 
 	if exportMetadata {
 		operationLogger.Info().Msg(exportingMetadata)
+		stopMeasuring := summary.MeasureStage(stageMetadata)
 
 		// export list of all tables into S3
-		err = storeTableNames(context, minioClient,
-			bucket, listOfTablesObject, tableNames)
-		if err != nil {
-			const msg = "Store table list to S3 failed"
-			log.Err(err).Msg(msg)
-			operationLogger.Err(err).Msg(msg)
-			return ExitStatusStorageError, err
+		if skipped.Contains(tablesListArtifact) {
+			logSkippedArtifact(operationLogger, tablesListArtifact)
+		} else {
+			err := storeTableNames(ctx, session,
+				bucket, listOfTablesObject, tableNames, storage.compression, storage.encryption)
+			if err != nil {
+				stopMeasuring()
+				const msg = "Store table list to S3 failed"
+				storage.logger.Err(err).Msg(msg)
+				operationLogger.Err(err).Msg(msg)
+				return ExitStatusStorageError, err
+			}
 		}
 
 		// export tables metadata into S3
-		err = storage.StoreTableMetadataIntoS3(context, minioClient,
-			bucket, metadataTableObject, tableNames)
-		if err != nil {
-			const msg = "Store tables metadata to S3 failed"
-			log.Err(err).Msg(msg)
-			return ExitStatusStorageError, err
+		if skipped.Contains(metadataArtifact) {
+			logSkippedArtifact(operationLogger, metadataArtifact)
+		} else {
+			err := storage.storeTableMetadataIntoS3(ctx, session,
+				bucket, metadataTableObject, tableNames, format)
+			if err != nil {
+				stopMeasuring()
+				const msg = "Store tables metadata to S3 failed"
+				storage.logger.Err(err).Msg(msg)
+				return ExitStatusStorageError, err
+			}
+		}
+
+		// export sequences and their current values into S3
+		if skipped.Contains(sequencesArtifact) {
+			logSkippedArtifact(operationLogger, sequencesArtifact)
+		} else {
+			operationLogger.Info().Msg(exportingSequences)
+			data, err := storage.sequencesIntoCSV(ctx)
+			if err != nil {
+				stopMeasuring()
+				operationLogger.Err(err).Msg(readSequencesFailed)
+				return ExitStatusStorageError, err
+			}
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, sequencesFile), csvContentType,
+				data, storage.compression, storage.encryption)
+			if err != nil {
+				stopMeasuring()
+				storage.logger.Err(err).Str(objectMsg, sequencesFile).Msg(storeObjectFailed)
+				operationLogger.Err(err).Str(objectMsg, sequencesFile).Msg(storeObjectFailed)
+				return ExitStatusS3Error, err
+			}
+		}
+
+		// export constraints and indexes of all tables into S3
+		if skipped.Contains(constraintsArtifact) {
+			logSkippedArtifact(operationLogger, constraintsArtifact)
+		} else {
+			operationLogger.Info().Msg(exportingConstraints)
+			data, err := storage.constraintsIntoCSV(ctx, tableNames)
+			if err != nil {
+				stopMeasuring()
+				operationLogger.Err(err).Msg(readConstraintsFailed)
+				return ExitStatusStorageError, err
+			}
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, constraintsFile), csvContentType,
+				data, storage.compression, storage.encryption)
+			if err != nil {
+				stopMeasuring()
+				storage.logger.Err(err).Str(objectMsg, constraintsFile).Msg(storeObjectFailed)
+				operationLogger.Err(err).Str(objectMsg, constraintsFile).Msg(storeObjectFailed)
+				return ExitStatusS3Error, err
+			}
 		}
+		stopMeasuring()
 	}
 
-	if exportDisabledRules {
+	if exportDisabledRules && skipped.Contains(disabledRulesArtifact) {
+		logSkippedArtifact(operationLogger, disabledRulesArtifact)
+	} else if exportDisabledRules {
 		operationLogger.Info().Msg(exportingDisabledRules)
+		stopMeasuring := summary.MeasureStage(stageReports)
 
 		// export rules disabled by more users into CSV file
-		disabledRulesInfo, err := storage.ReadDisabledRules()
+		disabledRulesInfo, err := storage.ReadDisabledRules(ctx)
 		if err != nil {
-			log.Err(err).Msg(readDisabledRulesInfoFailed)
+			stopMeasuring()
+			storage.logger.Err(err).Msg(readDisabledRulesInfoFailed)
 			operationLogger.Err(err).Msg(readDisabledRulesInfoFailed)
 			return ExitStatusStorageError, err
 		}
+		summary.RecordDisabledRules(disabledRulesInfo)
 
 		// export list of disabled rules
-		err = storeDisabledRulesIntoS3(context, minioClient, bucket,
-			disabledRules, disabledRulesInfo)
+		err = storeDisabledRulesIntoS3(ctx, session, bucket,
+			setObjectPrefix(bucketPrefix, reportName(disabledRules, format)),
+			disabledRulesInfo, format, storage.compression, storage.encryption)
 		if err != nil {
-			log.Err(err).Msg(storeDisabledRulesIntoFileFailed)
+			stopMeasuring()
+			storage.logger.Err(err).Msg(storeDisabledRulesIntoFileFailed)
 			operationLogger.Err(err).Msg(storeDisabledRulesIntoFileFailed)
 			return ExitStatusIOError, err
 		}
+
+		// rules disabled by more users per organization
+		if storage.disabledRulesDetails {
+			operationLogger.Info().Msg(exportingDisabledRulesDetails)
+			data, exitStatus, err := storage.disabledRulesDetailsReport(ctx, format)
+			if err == nil {
+				exitStatus = ExitStatusS3Error
+				err = putObject(ctx, session, bucket,
+					setObjectPrefix(bucketPrefix, reportName(disabledRulesDetails, format)),
+					reportContentType(format), data, storage.compression, storage.encryption)
+			}
+			if err != nil {
+				stopMeasuring()
+				operationLogger.Err(err).Msg(storeDisabledRulesDetailsFailed)
+				return exitStatus, err
+			}
+		}
+
+		// counts of disabled rules over the last runs
+		if trendRuns > 0 {
+			operationLogger.Info().Msg(readingDisabledRulesTrend)
+			err = storeDisabledRulesTrendIntoS3(ctx, session, bucket,
+				bucketPrefix, disabledRulesInfo, trendRuns, storage.compression, storage.encryption)
+			if err != nil {
+				stopMeasuring()
+				storage.logger.Err(err).Msg(storeTrendFailed)
+				operationLogger.Err(err).Msg(storeTrendFailed)
+				return ExitStatusS3Error, err
+			}
+		}
+		stopMeasuring()
 	}
 
-	operationLogger.Info().Msg(exportingTables)
+	if storage.ruleHits && skipped.Contains(ruleHitsArtifact) {
+		logSkippedArtifact(operationLogger, ruleHitsArtifact)
+	} else if storage.ruleHits {
+		operationLogger.Info().Msg(exportingRuleHits)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.ruleHitsReport(ctx, format)
+		if err == nil {
+			exitStatus = ExitStatusS3Error
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, reportName(ruleHitsFile, format)),
+				reportContentType(format), data, storage.compression, storage.encryption)
+		}
+		stopMeasuring()
+		if err != nil {
+			operationLogger.Err(err).Msg(storeRuleHitsFailed)
+			return exitStatus, err
+		}
+	}
 
-	// read content of all tables and perform export
-	for _, tableName := range tableNames {
-		// ignore table if specified by user
-		if _, found := ignoredTables[string(tableName)]; found {
-			operationLogger.Info().
-				Str(tableNameMsg, string(tableName)).
-				Msg(tableIsIgnored)
-			continue
+	if storage.orgSummary && skipped.Contains(orgSummaryArtifact) {
+		logSkippedArtifact(operationLogger, orgSummaryArtifact)
+	} else if storage.orgSummary {
+		operationLogger.Info().Msg(exportingOrgSummary)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.orgSummaryReport(ctx, format)
+		if err == nil {
+			exitStatus = ExitStatusS3Error
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, reportName(orgSummaryFile, format)),
+				reportContentType(format), data, storage.compression, storage.encryption)
 		}
-		operationLogger.Info().
-			Str(tableNameMsg, string(tableName)).
-			Msg(exportingTable)
-		err = storage.StoreTable(context, minioClient, bucket, bucketPrefix, tableName, limit)
+		stopMeasuring()
 		if err != nil {
-			const msg = "Store table into S3 failed"
-			log.Err(err).Str(tableNameMsg, string(tableName)).
-				Msg(msg)
-			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
-				Msg(msg)
-			return ExitStatusStorageError, err
+			operationLogger.Err(err).Msg(storeOrgSummaryFailed)
+			return exitStatus, err
 		}
 	}
 
-	operationLogger.Info().Msg(closingConnectionToStorage)
+	if storage.trendReport && skipped.Contains(trendArtifact) {
+		logSkippedArtifact(operationLogger, trendArtifact)
+	} else if storage.trendReport {
+		operationLogger.Info().Msg(exportingRecommendationsTrend)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.recommendationsTrendReport(ctx, format)
+		if err == nil {
+			exitStatus = ExitStatusS3Error
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, reportName(recommendationsTrendFile, format)),
+				reportContentType(format), data, storage.compression, storage.encryption)
+		}
+		stopMeasuring()
+		if err != nil {
+			operationLogger.Err(err).Msg(storeRecommendationsTrendFailed)
+			return exitStatus, err
+		}
+	}
 
-	// we have finished, let's close the connection to database
-	err = storage.Close()
-	if err != nil {
-		log.Err(err).Msg(operationFailedMessage)
-		operationLogger.Err(err).Msg(operationFailedMessage)
-		return ExitStatusStorageError, err
+	if len(storage.queries) > 0 && skipped.Contains(queriesArtifact) {
+		logSkippedArtifact(operationLogger, queriesArtifact)
+	} else if len(storage.queries) > 0 {
+		stopMeasuring := summary.MeasureStage(stageReports)
+		exitStatus, err := storage.exportCustomQueries(ctx, operationLogger,
+			func(objectName string, data []byte) (int, error) {
+				err := putObject(ctx, session, bucket,
+					setObjectPrefix(bucketPrefix, objectName), csvContentType,
+					data, storage.compression, storage.encryption)
+				if err != nil {
+					storage.logger.Err(err).Str(objectMsg, objectName).Msg(storeObjectFailed)
+					operationLogger.Err(err).Str(objectMsg, objectName).Msg(storeObjectFailed)
+					return ExitStatusS3Error, err
+				}
+				return ExitStatusOK, nil
+			})
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
 	}
 
-	// default exit value + no error
 	return ExitStatusOK, nil
 }
 
-// performDataExportToFiles exports all tables and metadata info files
-func performDataExportToFiles(_ *ConfigStruct,
-	storage *DBStorage, exportMetadata bool,
-	exportDisabledRules bool,
-	operationLogger *zerolog.Logger, limit int,
-	ignoredTables IgnoredTables) (int, error) {
-	operationLogger.Info().Msg("Exporting to file")
-
-	operationLogger.Info().Msg(readingListOfTables)
+// storeTablesIntoS3 function exports content of all selected tables into S3
+// objects or into one archive stored into S3
+func storeTablesIntoS3(ctx context.Context, configuration *ConfigStruct,
+	storage *DBStorage, session *S3Session, bucket, bucketPrefix string,
+	tableNames []TableName, operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, format string, resume bool,
+	summary *Summary) (int, error) {
+	operationLogger.Info().Msg(exportingTables)
 
-	tableNames, err := storage.ReadListOfTables()
+	// some formats store all tables into one archive
+	archive, err := newTableArchive(format)
 	if err != nil {
-		log.Err(err).Msg(operationFailedMessage)
-		operationLogger.Err(err).Msg(operationFailedMessage)
-		return ExitStatusStorageError, err
+		storage.logger.Err(err).Msg(createArchiveFailed)
+		operationLogger.Err(err).Msg(createArchiveFailed)
+		return ExitStatusIOError, err
 	}
+	defer closeArchive(archive)
 
-	log.Info().Int("count", len(tableNames)).Msg(listOfTablesMsg)
-
-	// log into terminal
-	printTables(tableNames)
-
-	if exportMetadata {
-		operationLogger.Info().Msg(exportingMetadata)
-
-		// export list of all tables into CSV file
-		err = storeTableNamesIntoFile(listOfTables, tableNames)
-		if err != nil {
-			const msg = "Store table list to file failed"
-			log.Err(err).Msg(msg)
-			operationLogger.Err(err).Msg(msg)
-			return ExitStatusStorageError, err
-		}
+	// table that failed might have been partially written into archive
+	if storage.keepGoing && archive != nil {
+		storage.logger.Warn().Msg(keepGoingWithArchive)
+		operationLogger.Warn().Msg(keepGoingWithArchive)
+		storage.keepGoing = false
+	}
 
-		// export tables metadata into CSV file
-		err = storage.StoreTableMetadataIntoFile(metadataTable, tableNames)
-		if err != nil {
-			const msg = "Store tables metadata to file failed"
-			log.Err(err).Msg(msg)
-			operationLogger.Err(err).Msg(msg)
-			return ExitStatusStorageError, err
+	// tables stored into separate objects can be named by their content
+	exportConfiguration := GetExportConfiguration(configuration)
+	if exportConfiguration.ContentAddressed && archive != nil {
+		storage.logger.Warn().Msg(contentAddressedArchives)
+		operationLogger.Warn().Msg(contentAddressedArchives)
+	} else if exportConfiguration.ContentAddressed {
+		storage.contentIndex = NewContentIndex()
+		if exportConfiguration.SkipUnchanged {
+			storage.logger.Warn().Msg(skipUnchangedNotNeeded)
+			operationLogger.Warn().Msg(skipUnchangedNotNeeded)
 		}
 	}
 
-	if exportDisabledRules {
-		operationLogger.Info().Msg(exportingDisabledRules)
-
-		// export rules disabled by more users into CSV file
-		disabledRulesInfo, err := storage.ReadDisabledRules()
+	// unchanged tables are detected for tables stored into separate objects
+	manifestObjectName := setObjectPrefix(bucketPrefix, manifestObject)
+	if archive == nil && storage.contentIndex == nil && exportConfiguration.SkipUnchanged {
+		previous, err := readManifestFromS3(ctx, session, bucket,
+			manifestObjectName)
 		if err != nil {
-			log.Err(err).Msg(readDisabledRulesInfoFailed)
-			operationLogger.Err(err).Msg(readDisabledRulesInfoFailed)
-			return ExitStatusStorageError, err
+			// all tables will be uploaded
+			storage.logger.Warn().Err(err).Msg(readManifestFailed)
+			operationLogger.Warn().Err(err).Msg(readManifestFailed)
 		}
+		storage.changes = NewChangeDetection(previous)
+	}
 
-		// export list of disabled rules
-		err = storeDisabledRulesIntoFile(disabledRules, disabledRulesInfo)
+	// tables read in chunks can be resumed in the middle of table
+	storage.checkpointTables = exportConfiguration.CheckpointTables && archive == nil
+	storage.resumeTables = resume
+
+	// tables stored by interrupted run are not exported again
+	var exported ExportedObjects
+	if resume && archive != nil {
+		storage.logger.Warn().Msg(resumeWithArchive)
+		operationLogger.Warn().Msg(resumeWithArchive)
+	} else if resume {
+		exported, err = listExportedObjects(ctx, session, bucket,
+			bucketPrefix)
 		if err != nil {
-			log.Err(err).Msg(storeDisabledRulesIntoFileFailed)
-			operationLogger.Err(err).Msg(storeDisabledRulesIntoFileFailed)
-			return ExitStatusIOError, err
+			storage.logger.Err(err).Msg(listExportedFailed)
+			operationLogger.Err(err).Msg(listExportedFailed)
+			return ExitStatusS3Error, err
 		}
+		storage.logger.Info().Int(exportedObjectsMsg, len(exported)).
+			Msg(exportedObjectsListed)
 	}
 
-	operationLogger.Info().Msg(exportingTables)
-
 	// read content of all tables and perform export
 	for _, tableName := range tableNames {
 		// ignore table if specified by user
@@ -386,49 +1048,186 @@ func performDataExportToFiles(_ *ConfigStruct,
 				Msg(tableIsIgnored)
 			continue
 		}
+		storedObject := setObjectPrefix(bucketPrefix, storage.tableObjectName(tableName, format)) +
+			artifactExtension(storage.compression, storage.encryption)
+		if size, found := exported.Exported(storedObject); found {
+			operationLogger.Info().
+				Str(tableNameMsg, string(tableName)).
+				Int64(exportedObjectSizeMsg, size).
+				Msg(tableAlreadyExported)
+			continue
+		}
 		operationLogger.Info().
 			Str(tableNameMsg, string(tableName)).
 			Msg(exportingTable)
-		err = storage.StoreTableIntoFile(tableName, limit)
+		// all messages logged during table export contain its name
+		tableStorage := storage.WithLogger(storage.logger.With().
+			Str(tableNameMsg, string(tableName)).Logger())
+		tableStorage.explainTable(ctx, operationLogger, tableName, limit)
+		if archive != nil {
+			err = tableStorage.StoreTableIntoArchive(ctx, archive, tableName, limit)
+		} else {
+			err = tableStorage.StoreTable(ctx, session, bucket, bucketPrefix, tableName, limit, format)
+		}
 		if err != nil {
-			const msg = "Store table into file failed"
-			log.Err(err).Str(tableNameMsg, string(tableName)).
-				Msg(msg)
+			const msg = "Store table into S3 failed"
+			tableStorage.logger.Err(err).Msg(msg)
 			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
 				Msg(msg)
+			if storage.continueAfterFailure(operationLogger, tableName,
+				ExitStatusStorageError, err) {
+				continue
+			}
 			return ExitStatusStorageError, err
 		}
+		if storage.csvSchemaSidecars && format == csvFormat && archive == nil {
+			exitStatus, err := tableStorage.storeSchemaSidecarIntoS3(ctx,
+				session, bucket, bucketPrefix, tableName)
+			if err != nil {
+				storage.logger.Err(err).Msg(storeSchemaSidecarFailed)
+				operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+					Msg(storeSchemaSidecarFailed)
+				if storage.continueAfterFailure(operationLogger, tableName,
+					exitStatus, err) {
+					continue
+				}
+				return exitStatus, err
+			}
+		}
+		err = storeRejectsIntoS3(ctx, session, bucket, bucketPrefix,
+			storage.quarantine, tableName, storage.compression, storage.encryption)
+		if err != nil {
+			storage.logger.Err(err).Msg(storeRejectsFailed)
+			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+				Msg(storeRejectsFailed)
+			if storage.continueAfterFailure(operationLogger, tableName,
+				ExitStatusS3Error, err) {
+				continue
+			}
+			return ExitStatusS3Error, err
+		}
+		logTableAudit(&storage.logger, operationLogger, storage.audit, tableName)
+		logRejects(&storage.logger, operationLogger, storage.quarantine, tableName)
+		summary.AddExportedTable()
 	}
 
-	operationLogger.Info().Msg(closingConnectionToStorage)
+	// columns are flagged when all tables have been exported
+	if storage.profile != nil {
+		data, err := columnFlagsIntoCSV(storage.profile.Flags())
+		if err == nil {
+			err = putObject(ctx, session, bucket,
+				setObjectPrefix(bucketPrefix, columnFlagsFile), csvContentType,
+				data, storage.compression, storage.encryption)
+		}
+		if err != nil {
+			storage.logger.Err(err).Msg(storeColumnFlagsFailed)
+			operationLogger.Err(err).Msg(storeColumnFlagsFailed)
+			return ExitStatusS3Error, err
+		}
+	}
 
-	// we have finished, let's close the connection to database
-	err = storage.Close()
-	if err != nil {
-		log.Err(err).Msg(operationFailedMessage)
-		operationLogger.Err(err).Msg(operationFailedMessage)
-		return ExitStatusStorageError, err
+	if archive != nil {
+		stopMeasuring := summary.MeasureStage(stageUpload)
+		err = storeArchiveIntoS3(ctx, session, bucket,
+			setObjectPrefix(bucketPrefix, archiveFile+fileExtension(format)),
+			contentType(format), archive, storage.compression, storage.encryption)
+		stopMeasuring()
+		if err != nil {
+			const msg = "Store archive into S3 failed"
+			storage.logger.Err(err).Msg(msg)
+			operationLogger.Err(err).Msg(msg)
+			return ExitStatusS3Error, err
+		}
+	}
+
+	// index is stored only when all tables have been exported
+	if storage.contentIndex != nil {
+		err = storeManifestIntoS3(ctx, session, bucket,
+			setObjectPrefix(bucketPrefix, indexObject), storage.contentIndex.Index(),
+			storage.encryption)
+		if err != nil {
+			storage.logger.Err(err).Msg(storeIndexFailed)
+			operationLogger.Err(err).Msg(storeIndexFailed)
+			return ExitStatusS3Error, err
+		}
+	}
+
+	// manifest is stored only when all tables have been exported
+	if storage.changes != nil {
+		err = storeManifestIntoS3(ctx, session, bucket,
+			manifestObjectName, storage.changes.Current(), storage.encryption)
+		if err != nil {
+			storage.logger.Err(err).Msg(storeManifestFailed)
+			operationLogger.Err(err).Msg(storeManifestFailed)
+			return ExitStatusS3Error, err
+		}
 	}
 
-	// default exit value + no error
 	return ExitStatusOK, nil
 }
 
-func printTables(tableNames []TableName) {
+// performDataExportToFiles exports all tables and metadata info files
+func performDataExportToFiles(ctx context.Context, configuration *ConfigStruct,
+	storage *DBStorage, exportMetadata bool,
+	exportDisabledRules bool,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, format string,
+	skipped SkippedArtifacts, summary *Summary) (int, error) {
+	operationLogger.Info().Msg("Exporting to file")
+
+	sink, err := NewSink(configuration, fileOutput, SinkOptions{
+		Directory:   storage.directory,
+		Directories: storage.directories,
+		Compression: storage.compression,
+		Encryption:  storage.encryption,
+		Artifacts:   storage.artifacts,
+		Checksums:   storage.checksums,
+		Metrics:     storage.metrics,
+	})
+	if err != nil {
+		storage.logger.Err(err).Msg(createSinksFailed)
+		operationLogger.Err(err).Msg(createSinksFailed)
+		return ExitStatusConfigurationError, err
+	}
+
+	return performDataExportToSinks(ctx, storage, []Sink{sink}, exportMetadata,
+		exportDisabledRules, operationLogger, limit, ignoredTables, format,
+		skipped, summary)
+}
+
+// closeArchive function closes archive with exported tables, if any
+func closeArchive(archive TableArchive) {
+	if archive == nil {
+		return
+	}
+
+	err := archive.Close()
+	if err != nil {
+		log.Err(err).Msg("Close archive with exported tables")
+	}
+}
+
+// logSkippedArtifact function writes information about skipped artifact into
+// operation log
+func logSkippedArtifact(operationLogger *zerolog.Logger, artifact string) {
+	operationLogger.Info().Str(artifactMsg, artifact).Msg(artifactIsSkipped)
+}
+
+func printTables(logger *zerolog.Logger, tableNames []TableName) {
 	for i, tableName := range tableNames {
-		log.Info().Int("#", i+1).Str("table", string(tableName)).Msg("Table in database")
+		logger.Info().Int("#", i+1).Str("table", string(tableName)).Msg("Table in database")
 	}
 }
 
 // checkS3Connection checks if connection to S3 is possible
 func checkS3Connection(configuration *ConfigStruct) (int, error) {
 	log.Info().Msg("Checking connection to S3")
-	minioClient, context, err := NewS3Connection(configuration)
+	session, err := OpenS3Session(configuration)
 	if err != nil {
 		return ExitStatusS3Error, err
 	}
 
-	exists, err := s3BucketExists(context, minioClient, GetS3Configuration(configuration).Bucket)
+	exists, err := s3BucketExists(session.Context(), session, session.Bucket())
 	if err != nil {
 		return ExitStatusS3Error, err
 	}
@@ -445,23 +1244,34 @@ func checkS3Connection(configuration *ConfigStruct) (int, error) {
 
 func storeOpertionLogIntoS3(configuration *ConfigStruct,
 	buffer bytes.Buffer) error {
-	minioClient, context, err := NewS3Connection(configuration)
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return err
+	}
+
+	encryption, err := configuredEncryption(configuration)
 	if err != nil {
 		return err
 	}
 
-	s3config := GetS3Configuration(configuration)
-	bucketName, bucketPrefix := s3config.Bucket, s3config.Prefix
-	logFileObject := setObjectPrefix(bucketPrefix, logFile)
-	return storeBufferToS3(context, minioClient, bucketName, logFileObject, buffer)
+	session.checksums = GetExportConfiguration(configuration).Checksums
+	return storeBufferToS3(session.Context(), session, session.Bucket(),
+		session.ObjectName(logFile), buffer, artifactCompression(configuration), encryption)
 }
 
 // doSelectedOperation function perform operation selected on command line.
 // When no operation is specified, the Notification writer service is started
 // instead.
-func doSelectedOperation(configuration *ConfigStruct, cliFlags CliFlags,
-	operationLogger *zerolog.Logger) (int, error) {
+func doSelectedOperation(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	logger, operationLogger *zerolog.Logger, summary *Summary, artifacts *ArtifactLog,
+	metrics Metrics) (int, error) {
 	switch {
+	case cliFlags.ShowVersion && cliFlags.Format == jsonFormat:
+		err := showVersionInfo()
+		if err != nil {
+			return ExitStatusIOError, err
+		}
+		return ExitStatusOK, nil
 	case cliFlags.ShowVersion:
 		showVersion()
 		return ExitStatusOK, nil
@@ -471,28 +1281,87 @@ func doSelectedOperation(configuration *ConfigStruct, cliFlags CliFlags,
 	case cliFlags.ShowConfiguration:
 		showConfiguration(configuration)
 		return ExitStatusOK, nil
+	case cliFlags.ShowExitStatuses:
+		err := showExitStatuses()
+		if err != nil {
+			return ExitStatusIOError, err
+		}
+		return ExitStatusOK, nil
 	case cliFlags.CheckS3Connection:
 		return checkS3Connection(configuration)
+	case cliFlags.CheckPermissions:
+		return checkPermissions(ctx, configuration)
 	default:
 		// default operation - data export
-		return performDataExport(configuration, cliFlags, operationLogger)
+		return performDataExport(ctx, configuration, cliFlags, logger, operationLogger,
+			summary, artifacts, metrics)
 	}
 	// this can not happen: return ExitStatusOK, nil
 }
 
+// dataExportSelected function returns true when the data export is the
+// operation selected by command line flags
+func dataExportSelected(cliFlags CliFlags) bool {
+	return !cliFlags.ShowVersion && !cliFlags.ShowAuthors &&
+		!cliFlags.ShowConfiguration && !cliFlags.ShowExitStatuses &&
+		!cliFlags.CheckS3Connection && !cliFlags.CheckPermissions
+}
+
+// operationName function returns name of operation selected by command line
+// flags. The name is added into all log messages produced by one run.
+func operationName(cliFlags CliFlags) string {
+	switch {
+	case cliFlags.ShowVersion:
+		return "version"
+	case cliFlags.ShowAuthors:
+		return "authors"
+	case cliFlags.ShowConfiguration:
+		return "show-configuration"
+	case cliFlags.ShowExitStatuses:
+		return "exit-statuses"
+	case cliFlags.CheckS3Connection:
+		return "check-s3-connection"
+	case cliFlags.CheckPermissions:
+		return "check-permissions"
+	default:
+		return "export"
+	}
+}
+
 func parseFlags() (cliFlags CliFlags) {
 	// define and parse all command line options
 	flag.BoolVar(&cliFlags.ShowVersion, "version", false, "show version")
 	flag.BoolVar(&cliFlags.ShowAuthors, "authors", false, "show authors")
 	flag.BoolVar(&cliFlags.ShowConfiguration, "show-configuration", false, "show configuration")
+	flag.BoolVar(&cliFlags.ShowExitStatuses, "exit-statuses", false, "show exit statuses and their meaning as JSON")
 	flag.BoolVar(&cliFlags.PrintSummaryTable, "summary", false, "print summary table after export")
-	flag.StringVar(&cliFlags.Output, "output", "S3", "output to: file, S3")
+	flag.StringVar(&cliFlags.Output, "output", "S3", "output to: file, S3, duckdb, sftp, kafka (comma-separated list of file and S3 is allowed)")
+	flag.StringVar(&cliFlags.Format, "format", csvFormat, "format of exported tables: csv, json, ndjson, avro, sqldump, xlsx, sqlite (json selects output of -version too)")
 	flag.BoolVar(&cliFlags.ExportMetadata, "metadata", false, "export metadata")
 	flag.BoolVar(&cliFlags.ExportDisabledRules, "disabled-by-more-users", false, "export rules disabled by more users")
+	flag.IntVar(&cliFlags.DisabledRulesTrend, "disabled-rules-trend", 0, "export trend of rules disabled by more users over given number of runs (S3 only)")
+	flag.BoolVar(&cliFlags.ExportRuleHits, "rule-hits", false, "export numbers of clusters hitting each rule per organization")
+	flag.BoolVar(&cliFlags.ExportOrgSummary, "org-summary", false, "export numbers of clusters and reports per organization")
+	flag.BoolVar(&cliFlags.ExportTrend, "recommendations-trend", false, "export numbers of rule hits and disabled rules per day or week")
+	flag.BoolVar(&cliFlags.ReportsOnly, "reports-only", false, "export only reports (disabled rules and other selected reports, custom queries), no tables and no metadata")
 	flag.BoolVar(&cliFlags.CheckS3Connection, "check-s3-connection", false, "check S3 connection and exit")
+	flag.BoolVar(&cliFlags.CheckPermissions, "check-permissions", false, "check database and S3 permissions and exit")
 	flag.BoolVar(&cliFlags.ExportLog, "export-log", false, "export log")
+	flag.BoolVar(&cliFlags.ExportConfig, "export-config", false, "export redacted configuration snapshot")
+	flag.BoolVar(&cliFlags.ExportDigest, "digest", false, "export digest of the run (text and HTML)")
+	flag.BoolVar(&cliFlags.ExportManifest, "manifest", false, "export manifest of the run (JSON)")
 	flag.IntVar(&cliFlags.Limit, "limit", -1, "limit number of exported records")
+	flag.Float64Var(&cliFlags.Sample, "sample", 0, "export random fraction of rows from each table, for example 0.01 for 1%")
 	flag.StringVar(&cliFlags.IgnoredTables, "ignore-tables", "", "comma-separated list of tables that will be ignored")
+	flag.StringVar(&cliFlags.Tables, "tables", "", "comma-separated list of tables or patterns that will be exported (overrides configuration), - reads the list from standard input")
+	flag.StringVar(&cliFlags.TablesFile, "tables-file", "", "file with list of tables or patterns that will be exported, one per line (overrides configuration)")
+	flag.StringVar(&cliFlags.ExcludeTables, "exclude-tables", "", "comma-separated list of tables or patterns that won't be exported")
+	flag.StringVar(&cliFlags.Bundle, "bundle", "", "bundle the whole export into one archive: tar.gz, zip")
+	flag.StringVar(&cliFlags.SkipArtifacts, "skip-artifacts", "", "comma-separated list of artifacts that won't be exported: tables-list, metadata, disabled-rules, log, config, queries, sequences, constraints, rule-hits, org-summary, recommendations-trend")
+	flag.BoolVar(&cliFlags.Resume, "resume", false, "skip tables already exported into S3 by interrupted run")
+	flag.BoolVar(&cliFlags.KeepGoing, "keep-going", false, "continue with remaining tables when export of table fails and exit with partial success status")
+	flag.StringVar(&cliFlags.Prefix, "prefix", "", "prefix of objects stored into S3 (overrides configuration)")
+	flag.StringVar(&cliFlags.Schema, "schema", "", "comma-separated list of PostgreSQL schemas tables are exported from (overrides configuration)")
 
 	// parse all command line flags
 	flag.Parse()
@@ -508,30 +1377,52 @@ func (w DummyWriter) Write(_ []byte) (n int, err error) {
 	return 0, nil
 }
 
-// createOperationLog function constructs operation log instance
-func createOperationLog(cliFlags CliFlags, buffer *bytes.Buffer) (zerolog.Logger, error) {
+// createOperationLog function constructs operation log instance. Returned
+// closer needs to be called to write all data into log file.
+func createOperationLog(cliFlags CliFlags, buffer *bytes.Buffer,
+	compression string, encryption *Encryption) (zerolog.Logger, func(), error) {
 	dummyLogger := zerolog.New(DummyWriter{}).With().Logger()
+	dummyCloser := func() {}
+
+	// operation log is stored into all outputs at the end of export
+	if cliFlags.ExportLog && exportedIntoSinks(cliFlags.Output) {
+		memoryLogger := zerolog.New(buffer).With().Logger()
+		memoryLogger.Info().Msg("Memory logger initialized")
+		return memoryLogger, dummyCloser, nil
+	}
 
 	if cliFlags.ExportLog {
-		switch cliFlags.Output {
+		switch exportOutput(cliFlags) {
 		case s3Output:
 			memoryLogger := zerolog.New(buffer).With().Logger()
 			memoryLogger.Info().Msg("Memory logger initialized")
-			return memoryLogger, nil
-		case fileOutput:
-			logFile, err := os.Create(logFile)
+			return memoryLogger, dummyCloser, nil
+		case fileOutput, duckDBOutput, kafkaOutput:
+			logFile, err := createCompressedFile(filepath.Join(cliFlags.OutputDirectory, logFile),
+				compression, encryption)
 			if err != nil {
-				return dummyLogger, err
+				return dummyLogger, dummyCloser, err
 			}
 			fileLogger := zerolog.New(logFile).With().Logger()
 			fileLogger.Info().Msg("File logger initialized")
-			return fileLogger, nil
+
+			// log can be closed before bundle is constructed
+			closed := false
+			return fileLogger, func() {
+				if closed {
+					return
+				}
+				closed = true
+				if err := logFile.Close(); err != nil {
+					log.Err(err).Msg("Close operation log")
+				}
+			}, nil
 		default:
-			return dummyLogger, fmt.Errorf(unknownOutputType, cliFlags.Output)
+			return dummyLogger, dummyCloser, fmt.Errorf(unknownOutputType, cliFlags.Output)
 		}
 	}
 
-	return dummyLogger, nil
+	return dummyLogger, dummyCloser, nil
 }
 
 func setObjectPrefix(prefix, object string) string {
@@ -551,6 +1442,84 @@ func mainWithStatusCode() int {
 		log.Err(err).Msg("Load configuration")
 	}
 
+	// data export can't be performed with invalid configuration
+	if dataExportSelected(cliFlags) {
+		var configurationError *ConfigurationError
+		if errors.As(err, &configurationError) {
+			return ExitStatusConfigurationError
+		}
+
+		// some options are needed for selected output only
+		err := validateOutputConfiguration(&config, cliFlags.Output)
+		if err != nil {
+			log.Err(err).Msg("Check configuration")
+			return ExitStatusConfigurationError
+		}
+	}
+
+	// operation log can be disabled in configuration file as well
+	skipped, err := constructSkippedArtifacts(cliFlags.SkipArtifacts,
+		GetExportConfiguration(&config).SkipArtifacts)
+	if err != nil {
+		log.Err(err).Msg("Wrong artifact to skip selected")
+		return ExitStatusConfigurationError
+	}
+	if skipped.Contains(logArtifact) {
+		cliFlags.ExportLog = false
+	}
+	if skipped.Contains(configArtifact) {
+		cliFlags.ExportConfig = false
+	}
+
+	err = checkBundle(cliFlags.Bundle, cliFlags.Output)
+	if err != nil {
+		log.Err(err).Msg("Wrong bundle selected")
+		return ExitStatusConfigurationError
+	}
+
+	err = checkOutputs(cliFlags.Output)
+	if err != nil {
+		log.Err(err).Msg("Wrong output selected")
+		return ExitStatusConfigurationError
+	}
+
+	err = checkSample(cliFlags.Sample)
+	if err != nil {
+		log.Err(err).Msg("Wrong sample selected")
+		return ExitStatusConfigurationError
+	}
+
+	// prefix selected on command line identifies the run to be resumed
+	if cliFlags.Prefix != "" {
+		config.S3.Prefix = cliFlags.Prefix
+	}
+
+	err = checkResume(cliFlags, GetS3Configuration(&config).Prefix)
+	if err != nil {
+		log.Err(err).Msg("Export can't be resumed")
+		return ExitStatusConfigurationError
+	}
+
+	// bundled files and files uploaded into SFTP server are exported into
+	// temporary directory first
+	if exportedIntoDirectory(cliFlags) && dataExportSelected(cliFlags) {
+		directory, err := os.MkdirTemp("", "export")
+		if err != nil {
+			log.Err(err).Msg(createExportDirectoryFailed)
+			return ExitStatusIOError
+		}
+		defer func() {
+			if err := os.RemoveAll(directory); err != nil {
+				log.Err(err).Msg("Remove export directory")
+			}
+		}()
+		cliFlags.OutputDirectory = directory
+	} else if directories := GetExportConfiguration(&config).Directories; len(directories) > 0 {
+		// files other than tables are written into the first of directories
+		// the tables are striped across
+		cliFlags.OutputDirectory = directories[0]
+	}
+
 	loggingCloser, err := InitLogging(&config)
 	if err != nil {
 		log.Err(err).Msg("Init logging")
@@ -559,29 +1528,236 @@ func mainWithStatusCode() int {
 
 	defer loggingCloser()
 
+	// operation log is encrypted as other artifacts, keys are checked
+	// before anything is exported
+	encryption, err := artifactEncryption(&config, cliFlags)
+	if err != nil {
+		log.Err(err).Msg("Read encryption keys")
+		return ExitStatusConfigurationError
+	}
+
+	// all messages logged by this run can be correlated by its ID
+	runID := newRunID()
+	logger := newRunLogger(log.Logger, runID, operationName(cliFlags))
+
+	// placeholders in prefix are expanded once, so all objects of the run
+	// are stored under the same prefix
+	if isPrefixTemplate(config.S3.Prefix) {
+		err = expandConfiguredPrefix(&config, runID)
+		if err != nil {
+			logger.Err(err).Msg("Expand prefix")
+			return ExitStatusConfigurationError
+		}
+		logger.Info().Str(prefixMsg, config.S3.Prefix).Msg(expandedPrefixMsg)
+	}
+
 	var buffer bytes.Buffer
-	operationLogger, err := createOperationLog(cliFlags, &buffer)
+	operationLogger, operationLogCloser, err := createOperationLog(cliFlags, &buffer,
+		artifactCompression(&config), encryption)
 	if err != nil {
-		log.Err(err).Msg("Create operation log")
+		logger.Err(err).Msg("Create operation log")
 		return ExitStatusIOError
 	}
 
+	defer operationLogCloser()
+
+	operationLogger = operationLogger.With().Str(runIDMsg, runID).Logger()
+
+	// export can be canceled by signal, running database queries are
+	// canceled too
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	// whole export is limited by run timeout
+	ctx, cancelRun := withRunTimeout(ctx, GetExportConfiguration(&config).RunTimeout)
+	defer cancelRun()
+
+	// counters and timers are reported by storage and S3 layers into
+	// backends selected by configuration
+	metricsConfiguration := GetMetricsConfiguration(&config)
+	metrics, registry, metricsCloser, err := newMetrics(metricsConfiguration)
+	if err != nil {
+		logger.Err(err).Msg("Initialize metrics")
+		return ExitStatusConfigurationError
+	}
+	defer metricsCloser()
+
 	// perform selected operation
-	exitStatus, err := doSelectedOperation(&config, cliFlags, &operationLogger)
+	summary := NewSummary()
+
+	// warnings logged during the run are reported in digest
+	logger = logger.Hook(summary.WarningHook())
+
+	// artifacts produced by the export are recorded for post-processing
+	// hooks, files exported into temporary directory are not final
+	// artifacts
+	var artifacts *ArtifactLog
+	hooksEnabled := false
+	hooksConfiguration := GetHooksConfiguration(&config)
+	if hooksConfigured(hooksConfiguration) && dataExportSelected(cliFlags) {
+		if exportedIntoDirectory(cliFlags) {
+			logger.Warn().Msg(hooksNotInvoked)
+		} else {
+			hooksEnabled = true
+		}
+	}
+
+	// artifacts are listed in manifest of the run too and they can be
+	// shared by presigned URLs
+	if hooksEnabled || (cliFlags.ExportManifest && dataExportSelected(cliFlags)) ||
+		presignEnabled(&config, cliFlags) {
+		artifacts = NewArtifactLog()
+	}
+
+	// destination prefix in S3 is guarded against concurrent exports
+	ctx, exportLock, exitStatus, err := lockExportPrefix(ctx, &config, cliFlags, runID,
+		&logger)
+	if err != nil {
+		return exitStatus
+	}
+	defer exportLock.release()
+
+	exitStatus, err = doSelectedOperation(ctx, &config, cliFlags, &logger,
+		&operationLogger, summary, artifacts, metrics)
+
+	// export that lost lock of destination prefix is canceled and it fails
+	if lostErr := exportLock.Lost(); lostErr != nil {
+		exitStatus, err = ExitStatusLockContention, lostErr
+	}
+
+	// export interrupted by run timeout is reported by its own exit status
+	timedOut := err != nil && dataExportSelected(cliFlags) && runTimedOut(ctx)
+	if timedOut {
+		exitStatus = ExitStatusTimeout
+	}
+
+	// export continued after failed tables, artifacts of all other tables
+	// are processed as usual
+	partialSuccess := !timedOut && exitStatus == ExitStatusPartialSuccess && err != nil
+	var partialErr error
+	if partialSuccess {
+		logger.Warn().Err(err).Msg(partialExport)
+		partialErr, err = err, nil
+	}
+
+	// presigned URLs are generated only for successful export, hooks
+	// receive them together with artifacts
+	if err == nil && presignEnabled(&config, cliFlags) {
+		exitStatus, err = presignArtifacts(&config, artifacts, summary, &logger)
+	}
+
+	// hooks are invoked only when all artifacts have been produced
+	if err == nil && hooksEnabled {
+		exitStatus, err = runArtifactHooks(ctx, hooksConfiguration,
+			artifacts.Artifacts(), summary, &logger)
+	}
+	if err == nil && partialSuccess {
+		exitStatus = ExitStatusPartialSuccess
+		summary.SetExitStatus(exitStatus, partialErr)
+	} else {
+		summary.SetExitStatus(exitStatus, err)
+	}
+	summary.Finish()
+
+	if dataExportSelected(cliFlags) {
+		// throughput of tables is analyzed even when the export failed
+		summary.SetRecommendations(recommendTuning(summary, &config))
+		logRecommendations(&logger, summary.Recommendations())
+	}
+
+	if cliFlags.PrintSummaryTable {
+		// summary is useful even when the export failed
+		if err := printSummary(os.Stdout, summary); err != nil {
+			logger.Err(err).Msg("Print summary table")
+		}
+	}
+
+	if metricsConfiguration.TextfilePath != "" && dataExportSelected(cliFlags) {
+		// metrics are written even when the export failed
+		err := writeMetricsFile(metricsConfiguration.TextfilePath, summary, exitStatus,
+			registry)
+		if err != nil {
+			logger.Err(err).Msg("Write metrics into textfile")
+		}
+	}
+
 	if err != nil {
-		log.Err(err).Msg("Do selected operation")
+		logger.Err(err).Msg("Do selected operation")
+		if timedOut {
+			storePartialArtifacts(&config, cliFlags, runID, summary,
+				artifacts.Artifacts(), &buffer, operationLogCloser, &logger)
+		}
 		return exitStatus
 	}
 
-	if cliFlags.ExportLog && cliFlags.Output == s3Output {
+	if cliFlags.ExportConfig && dataExportSelected(cliFlags) {
+		// snapshot needs to be written before files are stored
+		exitStatus, err := storeConfigSnapshot(&config, cliFlags)
+		if err != nil {
+			logger.Err(err).Msg("Storing configuration snapshot failed")
+			return exitStatus
+		}
+	}
+
+	if cliFlags.ExportDigest && dataExportSelected(cliFlags) {
+		// digest needs to be written before files are stored
+		exitStatus, err := storeDigest(&config, cliFlags, summary)
+		if err != nil {
+			logger.Err(err).Msg("Storing digest failed")
+			return exitStatus
+		}
+	}
+
+	if cliFlags.ExportManifest && dataExportSelected(cliFlags) {
+		// manifest needs to be written before files are stored
+		exitStatus, err := storeRunManifest(&config, cliFlags, runID, summary,
+			artifacts.Artifacts())
+		if err != nil {
+			logger.Err(err).Msg(storeRunManifestFailed)
+			return exitStatus
+		}
+	}
+
+	if sheetsEnabled(GetGoogleSheetsConfiguration(&config)) && dataExportSelected(cliFlags) {
+		logger.Info().Msg(updatingSheets)
+		exitStatus, err := storeReportsIntoSheets(ctx, &config, summary)
+		if err != nil {
+			logger.Err(err).Msg(updateSheetsFailed)
+			return exitStatus
+		}
+	}
+
+	if cliFlags.OutputDirectory != "" {
+		// operation log needs to be complete before files are stored
+		operationLogCloser()
+		exitStatus, err := storeExportedFiles(&config, cliFlags)
+		if err != nil {
+			logger.Err(err).Msg(storeExportedFilesFailed)
+			return exitStatus
+		}
+	}
+
+	if cliFlags.ExportLog && exportedIntoSinks(cliFlags.Output) {
+		exitStatus, err := storeOperationLogIntoSinks(&config, cliFlags, &buffer)
+		if err != nil {
+			logger.Err(err).Msg("Storing log into outputs failed")
+			return exitStatus
+		}
+	}
+
+	if cliFlags.ExportLog && exportOutput(cliFlags) == s3Output {
 		err := storeOpertionLogIntoS3(&config, buffer)
 		if err != nil {
-			log.Err(err).Msg("Storing log into S3 failed")
+			logger.Err(err).Msg("Storing log into S3 failed")
 			return ExitStatusS3Error
 		}
 	}
 
-	log.Debug().Msg("Finished")
+	logger.Debug().Msg("Finished")
+	if partialSuccess {
+		return ExitStatusPartialSuccess
+	}
 	return ExitStatusOK
 }
 
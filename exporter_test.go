@@ -26,7 +26,12 @@ package main_test
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/exporter_test.html
 
 import (
+	"context"
+	"encoding/json"
+	"net"
 	"os"
+	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/rs/zerolog"
@@ -114,7 +119,7 @@ func TestDoSelectedOperationShowVersion(t *testing.T) {
 
 	// try to call the tested function and capture its output
 	output, err := capture.StandardOutput(func() {
-		code, err := main.DoSelectedOperation(&configuration, cliFlags, &log.Logger)
+		code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 		assert.Equal(t, code, main.ExitStatusOK)
 		assert.Nil(t, err)
 	})
@@ -138,7 +143,7 @@ func TestDoSelectedOperationShowAuthors(t *testing.T) {
 
 	// try to call the tested function and capture its output
 	output, err := capture.StandardOutput(func() {
-		code, err := main.DoSelectedOperation(&configuration, cliFlags, &log.Logger)
+		code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 		assert.Equal(t, code, main.ExitStatusOK)
 		assert.Nil(t, err)
 	})
@@ -164,7 +169,7 @@ func TestDoSelectedOperationShowConfiguration(t *testing.T) {
 	// try to call the tested function and capture its output
 	output, err := capture.ErrorOutput(func() {
 		log.Logger = log.Output(zerolog.New(os.Stderr))
-		code, err := main.DoSelectedOperation(&configuration, cliFlags, &log.Logger)
+		code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 		assert.Equal(t, code, main.ExitStatusOK)
 		assert.Nil(t, err)
 	})
@@ -189,7 +194,7 @@ func TestDoSelectedOperationCheckS3Connection(t *testing.T) {
 		CheckS3Connection: true,
 	}
 
-	code, err := main.DoSelectedOperation(&configuration, cliFlags, &log.Logger)
+	code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusS3Error)
 	assert.Error(t, err)
 }
@@ -204,7 +209,7 @@ func TestPrintTables(t *testing.T) {
 
 	output, err := capture.ErrorOutput(func() {
 		log.Logger = log.Output(zerolog.New(os.Stderr))
-		main.PrintTables(tables)
+		main.PrintTables(&log.Logger, tables)
 	})
 
 	// check the captured text
@@ -237,7 +242,7 @@ func TestPerformDataExportViaDoSelectedOperation(t *testing.T) {
 	}
 
 	// the call should fail
-	code, err := main.DoSelectedOperation(&configuration, cliFlags, &log.Logger)
+	code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusStorageError)
 	assert.Error(t, err)
 }
@@ -269,7 +274,7 @@ func TestPerformDataExportNoStorage(t *testing.T) {
 	}
 
 	// the call should fail
-	code, err := main.PerformDataExport(&configuration, cliFlags, &log.Logger)
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusStorageError)
 	assert.Error(t, err)
 }
@@ -279,7 +284,7 @@ func TestPerformDataExportConfigError(t *testing.T) {
 	// fill in configuration structure w/o specifying S3 connection
 	// but DB connection is specified
 	configuration := main.ConfigStruct{
-		main.StorageConfiguration{
+		Storage: main.StorageConfiguration{
 			Driver:        "postgres",
 			PGUsername:    "user",
 			PGPassword:    "password",
@@ -289,9 +294,6 @@ func TestPerformDataExportConfigError(t *testing.T) {
 			PGParams:      "",
 			LogSQLQueries: true,
 		},
-		main.S3Configuration{},
-		main.LoggingConfiguration{},
-		main.SentryConfiguration{},
 	}
 
 	// default operation is export data
@@ -303,7 +305,7 @@ func TestPerformDataExportConfigError(t *testing.T) {
 	}
 
 	// the call should fail, but now because of improper configuration
-	code, err := main.PerformDataExport(&configuration, cliFlags, &log.Logger)
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusConfigurationError)
 	assert.Error(t, err)
 }
@@ -313,7 +315,7 @@ func TestPerformDataExportToS3(t *testing.T) {
 	// fill in configuration structure w/o specifying S3 connection
 	// but DB connection is specified
 	configuration := main.ConfigStruct{
-		main.StorageConfiguration{
+		Storage: main.StorageConfiguration{
 			Driver:        "postgres",
 			PGUsername:    "user",
 			PGPassword:    "password",
@@ -323,9 +325,6 @@ func TestPerformDataExportToS3(t *testing.T) {
 			PGParams:      "",
 			LogSQLQueries: true,
 		},
-		main.S3Configuration{},
-		main.LoggingConfiguration{},
-		main.SentryConfiguration{},
 	}
 
 	// default operation is export data
@@ -338,7 +337,7 @@ func TestPerformDataExportToS3(t *testing.T) {
 	}
 
 	// the call should fail due to inaccessible S3/Minio
-	code, err := main.PerformDataExport(&configuration, cliFlags, &log.Logger)
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusS3Error)
 	assert.Error(t, err)
 }
@@ -348,7 +347,7 @@ func TestPerformDataExportToFile(t *testing.T) {
 	// fill in configuration structure w/o specifying S3 connection
 	// but DB connection is specified
 	configuration := main.ConfigStruct{
-		main.StorageConfiguration{
+		Storage: main.StorageConfiguration{
 			Driver:        "postgres",
 			PGUsername:    "user",
 			PGPassword:    "password",
@@ -358,9 +357,6 @@ func TestPerformDataExportToFile(t *testing.T) {
 			PGParams:      "",
 			LogSQLQueries: true,
 		},
-		main.S3Configuration{},
-		main.LoggingConfiguration{},
-		main.SentryConfiguration{},
 	}
 
 	// default operation is export data
@@ -373,11 +369,36 @@ func TestPerformDataExportToFile(t *testing.T) {
 	}
 
 	// the call should fail due to inaccessible storage (DB)
-	code, err := main.PerformDataExport(&configuration, cliFlags, &log.Logger)
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
 	assert.Equal(t, code, main.ExitStatusStorageError)
 	assert.Error(t, err)
 }
 
+// TestPerformDataExportWrongFormat checks the function performDataExport
+// when unknown output format is selected
+func TestPerformDataExportWrongFormat(t *testing.T) {
+	// fill in configuration structure w/o specifying S3 connection
+	// but DB connection is specified
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:   "postgres",
+			PGHost:   "nowhere",
+			PGPort:   1234,
+			PGDBName: "test",
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "file",
+		Format: "xml",
+	}
+
+	// the call should fail because of improper configuration
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Equal(t, code, main.ExitStatusConfigurationError)
+	assert.EqualError(t, err, "Unknown output format: xml")
+}
+
 // TestConstructIgnoreTableMapEmptyInput checks the function
 // constructIgnoredTablesMap for empty input.
 func TestConstructIgnoreTableMapEmptyInput(t *testing.T) {
@@ -402,7 +423,184 @@ func TestConstructIgnoreTableMapTwoTables(t *testing.T) {
 	assert.Contains(t, m, "table2")
 }
 
+// TestConstructSkippedArtifactsEmptyInput checks the function
+// constructSkippedArtifacts for empty input.
+func TestConstructSkippedArtifactsEmptyInput(t *testing.T) {
+	m, err := main.ConstructSkippedArtifacts("", nil)
+	assert.NoError(t, err)
+	assert.Len(t, m, 0, "Empty set should be returned")
+	assert.False(t, m.Contains("log"))
+}
+
+// TestConstructSkippedArtifactsMerge checks that artifacts from command line
+// and from configuration file are merged.
+func TestConstructSkippedArtifactsMerge(t *testing.T) {
+	m, err := main.ConstructSkippedArtifacts("tables-list,log", []string{"disabled-rules", "log"})
+	assert.NoError(t, err)
+	assert.Len(t, m, 3)
+	assert.True(t, m.Contains("tables-list"))
+	assert.True(t, m.Contains("disabled-rules"))
+	assert.True(t, m.Contains("log"))
+	assert.False(t, m.Contains("metadata"))
+}
+
+// TestConstructSkippedArtifactsUnknownArtifact checks the function
+// constructSkippedArtifacts for unknown artifact name.
+func TestConstructSkippedArtifactsUnknownArtifact(t *testing.T) {
+	_, err := main.ConstructSkippedArtifacts("metadata,foo", nil)
+	assert.EqualError(t, err, "Unknown artifact to skip: foo")
+}
+
+// TestPerformDataExportWrongArtifact checks the function performDataExport
+// when unknown artifact to skip is selected
+func TestPerformDataExportWrongArtifact(t *testing.T) {
+	// fill in configuration structure w/o specifying S3 connection
+	// but DB connection is specified
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:   "postgres",
+			PGHost:   "nowhere",
+			PGPort:   1234,
+			PGDBName: "test",
+		},
+		Export: main.ExportConfiguration{
+			SkipArtifacts: []string{"whatever"},
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "file",
+	}
+
+	// the call should fail because of improper configuration
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Equal(t, code, main.ExitStatusConfigurationError)
+	assert.EqualError(t, err, "Unknown artifact to skip: whatever")
+}
+
 func TestSetObjectPrefix(t *testing.T) {
 	assert.Equal(t, "test/bucket", main.SetObjectPrefix("test", "bucket"))
 	assert.Equal(t, "bucket", main.SetObjectPrefix("", "bucket"))
 }
+
+// TestOperationName checks the function operationName
+func TestOperationName(t *testing.T) {
+	assert.Equal(t, "export", main.OperationName(main.CliFlags{}))
+	assert.Equal(t, "version", main.OperationName(main.CliFlags{ShowVersion: true}))
+	assert.Equal(t, "check-permissions",
+		main.OperationName(main.CliFlags{CheckPermissions: true}))
+}
+
+// TestDoSelectedOperationShowVersionJSON checks that version information is
+// printed in JSON format when selected
+func TestDoSelectedOperationShowVersionJSON(t *testing.T) {
+	// stub for structures needed to call the tested function
+	configuration := main.ConfigStruct{}
+	cliFlags := main.CliFlags{
+		ShowVersion: true,
+		Format:      "json",
+	}
+
+	// try to call the tested function and capture its output
+	output, err := capture.StandardOutput(func() {
+		code, err := main.DoSelectedOperation(context.Background(), &configuration, cliFlags, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+		assert.Equal(t, code, main.ExitStatusOK)
+		assert.Nil(t, err)
+	})
+
+	// check the captured text
+	checkCapture(t, err)
+
+	var versionInfo main.VersionInfo
+	assert.NoError(t, json.Unmarshal([]byte(output), &versionInfo))
+	assert.Equal(t, main.BuildVersion, versionInfo.Version)
+	assert.Equal(t, runtime.Version(), versionInfo.GoVersion)
+	assert.Contains(t, versionInfo.Drivers, "postgres")
+	assert.Contains(t, versionInfo.Drivers, "sqlite3")
+	assert.Contains(t, versionInfo.Formats, "csv")
+	assert.Contains(t, versionInfo.Outputs, "S3")
+	assert.Contains(t, versionInfo.Compressions, "gzip")
+}
+
+// TestPerformDataExportToS3WithMetadata checks that metadata and tables
+// exported concurrently are all stored into S3
+func TestPerformDataExportToS3WithMetadata(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output:         "S3",
+		ExportMetadata: true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, "id,report\n1,first\n2,second\n", string(s3.objects["/bucket/prefix/report.csv"]))
+	assert.Contains(t, s3.objects, "/bucket/prefix/_tables.csv")
+	assert.Contains(t, s3.objects, "/bucket/prefix/_metadata.csv")
+}
+
+// TestPerformDataExportReportsOnly checks that only reports are exported when
+// tables are not requested
+func TestPerformDataExportReportsOnly(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDisabledRulesDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output:         "S3",
+		ExportMetadata: true,
+		ReportsOnly:    true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// rules disabled by more users are always reported
+	assert.Equal(t, "Rule,Count\nrule1,3\n",
+		string(s3.objects["/bucket/prefix/_disabled_rules.csv"]))
+
+	// no table and no metadata
+	assert.NotContains(t, s3.objects, "/bucket/prefix/report.csv")
+	assert.NotContains(t, s3.objects, "/bucket/prefix/rule_disable.csv")
+	assert.NotContains(t, s3.objects, "/bucket/prefix/_tables.csv")
+	assert.NotContains(t, s3.objects, "/bucket/prefix/_metadata.csv")
+}
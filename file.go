@@ -23,8 +23,8 @@ package main
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/file.html
 
 import (
-	"encoding/csv"
 	"os"
+	"path/filepath"
 
 	"github.com/rs/zerolog/log"
 )
@@ -37,17 +37,17 @@ const (
 
 // storeTableNamesIntoFile function stores names of all tables into the
 // specified file
-func storeTableNamesIntoFile(fileName string, tableNames []TableName) error {
+func storeTableNamesIntoFile(fileName string, tableNames []TableName,
+	compression string, encryption *Encryption) error {
 	// open new CSV file to be filled in
-
-	// disable "G304 (CWE-22): Potential file inclusion via variable"
-	fout, err := os.Create(fileName) // #nosec G304
+	fout, err := createCompressedFile(fileName, compression, encryption)
 	if err != nil {
 		return err
 	}
 
 	// initialize CSV writer
-	writer := csv.NewWriter(fout)
+	writer, release := newCSVWriter(fout)
+	defer release()
 	var data = [][]string{{"Table name"}}
 
 	// header
@@ -83,11 +83,10 @@ func storeTableNamesIntoFile(fileName string, tableNames []TableName) error {
 
 // storeDisabledRulesIntoFile function stores info about disabled rules into
 // specified file
-func storeDisabledRulesIntoFile(fileName string, disabledRulesInfo []DisabledRuleInfo) error {
+func storeDisabledRulesIntoFile(fileName string, disabledRulesInfo []DisabledRuleInfo,
+	compression string, encryption *Encryption) error {
 	// open new CSV file to be filled in
-
-	// disable "G304 (CWE-22): Potential file inclusion via variable"
-	fout, err := os.Create(fileName) // #nosec G304
+	fout, err := createCompressedFile(fileName, compression, encryption)
 	if err != nil {
 		return err
 	}
@@ -107,3 +106,37 @@ func storeDisabledRulesIntoFile(fileName string, disabledRulesInfo []DisabledRul
 
 	return nil
 }
+
+// writeFileAtomically function writes data into given file. Data are written
+// into temporary file first and then the file is renamed, so readers never
+// see partially written file.
+func writeFileAtomically(fileName string, data []byte, perm os.FileMode) error {
+	// temporary file needs to be in the same directory to make rename atomic
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+
+	if err != nil {
+		// don't leave temporary files behind
+		if removeErr := os.Remove(tmpName); removeErr != nil {
+			log.Error().Err(removeErr).Msg("Unable to remove temporary file")
+		}
+		return err
+	}
+
+	return nil
+}
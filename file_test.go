@@ -82,7 +82,7 @@ func TestStoreTableNamesIntoFileNoWritableFile(t *testing.T) {
 	const filename = ""
 	tableNames := []main.TableName{}
 
-	err := main.StoreTableNamesIntoFile(filename, tableNames)
+	err := main.StoreTableNamesIntoFile(filename, tableNames, "none", nil)
 	assert.Error(t, err, "Error should be thrown for empty file name")
 }
 
@@ -98,7 +98,7 @@ func TestStoreTableNamesIntoFileEmptyListOfTables(t *testing.T) {
 	// just to be sure
 	assert.NoFileExists(t, filename, "File must not exist")
 
-	err := main.StoreTableNamesIntoFile(filename, tableNames)
+	err := main.StoreTableNamesIntoFile(filename, tableNames, "none", nil)
 	assert.NoError(t, err, "Error should not be thrown for regular file name")
 
 	// file with exported data must be created
@@ -127,7 +127,7 @@ func TestStoreTableNamesIntoFile(t *testing.T) {
 	// just to be sure
 	assert.NoFileExists(t, filename, "File must not exist")
 
-	err := main.StoreTableNamesIntoFile(filename, tableNames)
+	err := main.StoreTableNamesIntoFile(filename, tableNames, "none", nil)
 	assert.NoError(t, err, "Error should not be thrown for regular file name")
 
 	// file with exported data must be created
@@ -147,7 +147,7 @@ func TestStoreDisableRulesIntoFile(t *testing.T) {
 	const filename = ""
 	disabledRules := []main.DisabledRuleInfo{}
 
-	err := main.StoreDisabledRulesIntoFile(filename, disabledRules)
+	err := main.StoreDisabledRulesIntoFile(filename, disabledRules, "none", nil)
 	assert.Error(t, err, "Error should be thrown for empty file name")
 }
 
@@ -163,7 +163,7 @@ func TestStoreDisabledRulesIntoFileEmptyListOfTables(t *testing.T) {
 	// just to be sure
 	assert.NoFileExists(t, filename, "File must not exist")
 
-	err := main.StoreDisabledRulesIntoFile(filename, disabledRules)
+	err := main.StoreDisabledRulesIntoFile(filename, disabledRules, "none", nil)
 	assert.NoError(t, err, "Error should not be thrown for regular file name")
 
 	// file with exported data must be created
@@ -193,7 +193,7 @@ func TestStoreDisabledRulesIntoFile(t *testing.T) {
 	// just to be sure
 	assert.NoFileExists(t, filename, "File must not exist")
 
-	err := main.StoreDisabledRulesIntoFile(filename, disabledRules)
+	err := main.StoreDisabledRulesIntoFile(filename, disabledRules, "none", nil)
 	assert.NoError(t, err, "Error should not be thrown for regular file name")
 
 	// file with exported data must be created
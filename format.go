@@ -0,0 +1,470 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/format.html
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Supported output formats
+const (
+	csvFormat    = "csv"
+	jsonFormat   = "json"
+	ndjsonFormat = "ndjson"
+	avroFormat   = "avro"
+	sqlFormat    = "sqldump"
+	xlsxFormat   = "xlsx"
+	sqliteFormat = "sqlite"
+)
+
+// supportedFormats contains names of all supported output formats
+var supportedFormats = []string{
+	csvFormat, jsonFormat, ndjsonFormat, avroFormat, sqlFormat, xlsxFormat,
+	sqliteFormat,
+}
+
+// JSONFileExtension is common extension used for files with JSON data
+const JSONFileExtension = ".json"
+
+// NDJSONFileExtension is common extension used for files with JSON Lines
+const NDJSONFileExtension = ".ndjson"
+
+// AvroFileExtension is common extension used for Avro container files
+const AvroFileExtension = ".avro"
+
+// SQLFileExtension is common extension used for files with SQL statements
+const SQLFileExtension = ".sql"
+
+// XLSXFileExtension is common extension used for Excel workbooks
+const XLSXFileExtension = ".xlsx"
+
+// SQLiteFileExtension is common extension used for SQLite database files
+const SQLiteFileExtension = ".sqlite"
+
+// Content types of objects stored into S3
+const (
+	csvContentType    = "text/csv"
+	jsonContentType   = "application/json"
+	ndjsonContentType = "application/x-ndjson"
+	avroContentType   = "application/avro"
+	sqlContentType    = "application/sql"
+	xlsxContentType   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	sqliteContentType = "application/vnd.sqlite3"
+)
+
+const unknownFormat = "Unknown output format: %s"
+
+const wrongCSVNullValue = "NULL value %q must not contain delimiter, quote or line break"
+
+// Column represents name and database type of one table column. Some output
+// formats need to know column types to construct the schema.
+type Column struct {
+	Name         string
+	DatabaseType string
+
+	// Identity is kind of identity column (ALWAYS or BY DEFAULT), it is
+	// empty for other columns
+	Identity string
+
+	// Omitted is set for columns that are not inserted back by SQL dump
+	// (generated columns and skipped identity columns)
+	Omitted bool
+}
+
+// TableWriter is an interface for all writers that are able to serialize
+// content of one table into selected output format.
+type TableWriter interface {
+	// WriteHeader method writes column names (if the format needs them)
+	WriteHeader(colNames []string) error
+
+	// WriteRow method writes one table row, columns are written in the
+	// order specified by colNames
+	WriteRow(colNames []string, row M) error
+
+	// Flush method finishes the output and reports any error that
+	// occurred during writing
+	Flush() error
+}
+
+// TableArchive is an interface for output formats that store all exported
+// tables into one file, for example workbook or database file.
+type TableArchive interface {
+	// AddTable method adds new table into archive and returns writer for
+	// its content
+	AddTable(tableName TableName, columns []Column) (TableWriter, error)
+
+	// Write method writes the whole archive into given writer
+	Write(writer io.Writer) error
+
+	// Close method releases all resources used by archive
+	Close() error
+}
+
+// newTableArchive function constructs archive for output formats that store
+// all tables into one file. Nil is returned for formats that store each
+// table separately.
+func newTableArchive(format string) (TableArchive, error) {
+	switch format {
+	case xlsxFormat:
+		return NewWorkbook(), nil
+	case sqliteFormat:
+		return NewSQLiteArchive()
+	default:
+		return nil, nil
+	}
+}
+
+// NewTableWriter function constructs table writer for selected output format.
+// Table name and columns are used by formats with schema.
+func NewTableWriter(format string, writer io.Writer, tableName TableName,
+	columns []Column) (TableWriter, error) {
+	if writer == nil {
+		return nil, errors.New(bufferIsNil)
+	}
+
+	switch format {
+	case csvFormat:
+		return newCSVTableWriter(writer, ""), nil
+	case jsonFormat:
+		return &jsonTableWriter{writer: writer}, nil
+	case ndjsonFormat:
+		return &ndjsonTableWriter{writer: writer}, nil
+	case avroFormat:
+		return newAvroTableWriter(writer, tableName, columns)
+	case sqlFormat:
+		return newSQLDumpTableWriter(writer, tableName, columns), nil
+	case xlsxFormat:
+		// all tables are stored as sheets in one workbook, see Workbook
+		return nil, errors.New(workbookFormatOnly)
+	case sqliteFormat:
+		// all tables are stored in one database, see SQLiteArchive
+		return nil, errors.New(sqliteArchiveOnly)
+	default:
+		return nil, fmt.Errorf(unknownFormat, format)
+	}
+}
+
+// newTableWriter method constructs table writer for selected output format,
+// NULL is written into CSV as configured
+func (storage DBStorage) newTableWriter(format string, writer io.Writer,
+	tableName TableName, columns []Column) (TableWriter, error) {
+	tableWriter, err := NewTableWriter(format, writer, tableName, columns)
+	if csvWriter, ok := tableWriter.(*csvTableWriter); ok {
+		csvWriter.nullValue = storage.csvNullValue
+	}
+	return tableWriter, err
+}
+
+// checkFormat function checks if given output format is supported
+func checkFormat(format string) error {
+	for _, supported := range supportedFormats {
+		if format == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf(unknownFormat, format)
+}
+
+// fileExtension function returns extension used for files or objects with
+// data in selected format
+func fileExtension(format string) string {
+	switch format {
+	case jsonFormat:
+		return JSONFileExtension
+	case ndjsonFormat:
+		return NDJSONFileExtension
+	case avroFormat:
+		return AvroFileExtension
+	case sqlFormat:
+		return SQLFileExtension
+	case xlsxFormat:
+		return XLSXFileExtension
+	case sqliteFormat:
+		return SQLiteFileExtension
+	default:
+		return CSVFileExtension
+	}
+}
+
+// contentType function returns content type of S3 objects with data in
+// selected format
+func contentType(format string) string {
+	switch format {
+	case jsonFormat:
+		return jsonContentType
+	case ndjsonFormat:
+		return ndjsonContentType
+	case avroFormat:
+		return avroContentType
+	case sqlFormat:
+		return sqlContentType
+	case xlsxFormat:
+		return xlsxContentType
+	case sqliteFormat:
+		return sqliteContentType
+	default:
+		return csvContentType
+	}
+}
+
+// csvTableWriter writes table content as comma-separated values. NULL is
+// written as configured null value (unquoted) and other values equal to the
+// null value are quoted, so consumers can tell them apart the same way as
+// for CSV written by PostgreSQL COPY.
+type csvTableWriter struct {
+	writer    *bufio.Writer
+	nullValue string
+}
+
+// newCSVTableWriter function constructs CSV table writer that writes NULL as
+// given null value
+func newCSVTableWriter(writer io.Writer, nullValue string) *csvTableWriter {
+	return &csvTableWriter{
+		writer:    getBufferedWriter(writer),
+		nullValue: nullValue,
+	}
+}
+
+// WriteHeader method writes column names as the first CSV record
+func (w *csvTableWriter) WriteHeader(colNames []string) error {
+	for i, colName := range colNames {
+		w.writeField(colName, i)
+	}
+	_, err := w.writer.WriteString("\n")
+	return err
+}
+
+// WriteRow method writes one row as CSV record, all values are converted into
+// strings
+func (w *csvTableWriter) WriteRow(colNames []string, row M) error {
+	for i, colName := range colNames {
+		value := row[colName]
+		if value == nil {
+			w.writeSeparator(i)
+			_, _ = w.writer.WriteString(w.nullValue)
+			continue
+		}
+		w.writeField(csvValue(value), i)
+	}
+	_, err := w.writer.WriteString("\n")
+	return err
+}
+
+// writeSeparator method writes separator before all but the first field
+func (w *csvTableWriter) writeSeparator(index int) {
+	if index > 0 {
+		_ = w.writer.WriteByte(',')
+	}
+}
+
+// writeField method writes one field of CSV record, the field is quoted when
+// it needs to be or when it is equal to null value. Errors are reported by
+// the last write of record.
+func (w *csvTableWriter) writeField(field string, index int) {
+	w.writeSeparator(index)
+
+	if field != w.nullValue && !csvFieldNeedsQuotes(field) {
+		_, _ = w.writer.WriteString(field)
+		return
+	}
+
+	_ = w.writer.WriteByte('"')
+	_, _ = w.writer.WriteString(strings.ReplaceAll(field, `"`, `""`))
+	_ = w.writer.WriteByte('"')
+}
+
+// csvFieldNeedsQuotes function checks if field needs to be quoted, the rules
+// follow the rules used by encoding/csv package
+func csvFieldNeedsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+
+	if field == `\.` || strings.ContainsAny(field, ",\"\r\n") {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// checkCSVNullValue function checks if given value can be written into CSV
+// instead of NULL without quoting
+func checkCSVNullValue(nullValue string) error {
+	if strings.ContainsAny(nullValue, ",\"\r\n") {
+		return fmt.Errorf(wrongCSVNullValue, nullValue)
+	}
+	return nil
+}
+
+// csvValue function converts one value into string written into CSV, NULL
+// is converted into empty string
+func csvValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// csvValues function converts values of one row into strings written into
+// CSV, values are ordered as specified by colNames
+func csvValues(colNames []string, row M) []string {
+	columns := make([]string, 0, len(colNames))
+	for _, colName := range colNames {
+		columns = append(columns, csvValue(row[colName]))
+	}
+	return columns
+}
+
+// Flush method flushes all buffered CSV records
+func (w *csvTableWriter) Flush() error {
+	if w.writer == nil {
+		return nil
+	}
+
+	err := w.writer.Flush()
+
+	// buffered writer is reused by next table
+	putBufferedWriter(w.writer)
+	w.writer = nil
+	return err
+}
+
+// FlushRows method flushes buffered CSV records without finishing the
+// output, so more rows can be written
+func (w *csvTableWriter) FlushRows() error {
+	if w.writer == nil {
+		return nil
+	}
+	return w.writer.Flush()
+}
+
+// jsonTableWriter writes table content as JSON array of row objects. Types of
+// values read from database are preserved and the order of keys in objects
+// follows the order of columns in table.
+type jsonTableWriter struct {
+	writer io.Writer
+	rows   int
+}
+
+// WriteHeader method starts the JSON array, column names are part of row
+// objects
+func (w *jsonTableWriter) WriteHeader(_ []string) error {
+	_, err := io.WriteString(w.writer, "[")
+	return err
+}
+
+// WriteRow method writes one row as JSON object
+func (w *jsonTableWriter) WriteRow(colNames []string, row M) error {
+	separator := "\n"
+	if w.rows > 0 {
+		separator = ",\n"
+	}
+
+	object, err := marshalRow(colNames, row)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w.writer, separator)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(object)
+	if err != nil {
+		return err
+	}
+
+	w.rows++
+	return nil
+}
+
+// Flush method closes the JSON array
+func (w *jsonTableWriter) Flush() error {
+	_, err := io.WriteString(w.writer, "\n]\n")
+	return err
+}
+
+// ndjsonTableWriter writes table content as JSON Lines - one JSON object per
+// row and line. Rows are written immediately, so the whole document does not
+// need to be built in memory.
+type ndjsonTableWriter struct {
+	writer io.Writer
+}
+
+// WriteHeader method does nothing, column names are part of row objects
+func (w *ndjsonTableWriter) WriteHeader(_ []string) error {
+	return nil
+}
+
+// WriteRow method writes one row as JSON object on separate line
+func (w *ndjsonTableWriter) WriteRow(colNames []string, row M) error {
+	object, err := marshalRow(colNames, row)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(append(object, '\n'))
+	return err
+}
+
+// Flush method does nothing, all rows have been written already
+func (w *ndjsonTableWriter) Flush() error {
+	return nil
+}
+
+// marshalRow function serializes one table row into JSON object with keys
+// ordered by column order (standard marshaller would sort the keys)
+func marshalRow(colNames []string, row M) ([]byte, error) {
+	object := []byte{'{'}
+
+	for i, colName := range colNames {
+		if i > 0 {
+			object = append(object, ',')
+		}
+
+		key, err := json.Marshal(colName)
+		if err != nil {
+			return nil, RowConversionError{err}
+		}
+
+		value, err := json.Marshal(row[colName])
+		if err != nil {
+			return nil, RowConversionError{err}
+		}
+
+		object = append(object, key...)
+		object = append(object, ':')
+		object = append(object, value...)
+	}
+
+	return append(object, '}'), nil
+}
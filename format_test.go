@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/format_test.html
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// columns and rows used by tests for table writers
+var (
+	testColumns      = []string{"id", "name", "valid"}
+	testTableColumns = []main.Column{
+		{Name: "id", DatabaseType: "INT4"},
+		{Name: "name", DatabaseType: "VARCHAR"},
+		{Name: "valid", DatabaseType: "BOOL"},
+	}
+	testRows = []main.M{
+		{"id": int64(1), "name": "foo", "valid": true},
+		{"id": int64(2), "name": "bar \"baz\"", "valid": false},
+	}
+)
+
+// writeTestTable helper function writes test table using table writer for
+// selected format and returns the output
+func writeTestTable(t *testing.T, format string, rows []main.M) string {
+	buffer := new(bytes.Buffer)
+
+	writer, err := main.NewTableWriter(format, buffer, "test_table", testTableColumns)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	for _, row := range rows {
+		assert.NoError(t, writer.WriteRow(testColumns, row))
+	}
+	assert.NoError(t, writer.Flush())
+
+	return buffer.String()
+}
+
+// TestNewTableWriterUnknownFormat checks that unknown format is refused
+func TestNewTableWriterUnknownFormat(t *testing.T) {
+	_, err := main.NewTableWriter("xml", new(bytes.Buffer), "test_table", testTableColumns)
+	assert.EqualError(t, err, "Unknown output format: xml")
+}
+
+// TestNewTableWriterNilBuffer checks that nil buffer is refused
+func TestNewTableWriterNilBuffer(t *testing.T) {
+	_, err := main.NewTableWriter("csv", nil, "test_table", testTableColumns)
+	assert.EqualError(t, err, "Buffer is nil")
+}
+
+// TestCSVTableWriter checks writing table into CSV
+func TestCSVTableWriter(t *testing.T) {
+	output := writeTestTable(t, "csv", testRows)
+
+	expected := "id,name,valid\n1,foo,true\n2,\"bar \"\"baz\"\"\",false\n"
+	assert.Equal(t, expected, output)
+}
+
+// TestCSVTableWriterNullValue checks that NULL is distinguished from empty
+// string in CSV
+func TestCSVTableWriterNullValue(t *testing.T) {
+	rows := []main.M{
+		{"id": int64(1), "name": nil, "valid": nil},
+		{"id": int64(2), "name": "", "valid": true},
+	}
+
+	// NULL is written as empty field and empty string is quoted by default
+	output := writeTestTable(t, "csv", rows)
+	assert.Equal(t, "id,name,valid\n1,,\n2,\"\",true\n", output)
+
+	// values equal to NULL value are quoted
+	buffer := new(bytes.Buffer)
+	writer := main.NewCSVTableWriter(buffer, `\N`)
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	assert.NoError(t, writer.WriteRow(testColumns, rows[0]))
+	assert.NoError(t, writer.WriteRow(testColumns, rows[1]))
+	assert.NoError(t, writer.WriteRow(testColumns, main.M{"id": int64(3), "name": `\N`, "valid": false}))
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, "id,name,valid\n1,\\N,\\N\n2,,true\n3,\"\\N\",false\n", buffer.String())
+}
+
+// TestCSVTableWriterQuoting checks that fields are quoted when needed
+func TestCSVTableWriterQuoting(t *testing.T) {
+	output := writeTestTable(t, "csv", []main.M{
+		{"id": int64(1), "name": "a,b", "valid": " x"},
+		{"id": int64(2), "name": "line\nbreak", "valid": `\.`},
+	})
+
+	expected := "id,name,valid\n1,\"a,b\",\" x\"\n2,\"line\nbreak\",\"\\.\"\n"
+	assert.Equal(t, expected, output)
+}
+
+// TestJSONTableWriterNullValue checks that NULL is written into JSON as null
+func TestJSONTableWriterNullValue(t *testing.T) {
+	output := writeTestTable(t, "ndjson", []main.M{
+		{"id": int64(1), "name": nil, "valid": nil},
+		{"id": int64(2), "name": "", "valid": true},
+	})
+
+	expected := `{"id":1,"name":null,"valid":null}` + "\n" +
+		`{"id":2,"name":"","valid":true}` + "\n"
+	assert.Equal(t, expected, output)
+}
+
+// TestJSONTableWriter checks writing table into JSON
+func TestJSONTableWriter(t *testing.T) {
+	output := writeTestTable(t, "json", testRows)
+
+	expected := `[
+{"id":1,"name":"foo","valid":true},
+{"id":2,"name":"bar \"baz\"","valid":false}
+]
+`
+	assert.Equal(t, expected, output)
+
+	// output must be valid JSON with preserved types
+	var rows []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(output), &rows))
+	assert.Len(t, rows, 2)
+	assert.Equal(t, 1.0, rows[0]["id"])
+	assert.Equal(t, true, rows[0]["valid"])
+}
+
+// TestJSONTableWriterEmptyTable checks writing empty table into JSON
+func TestJSONTableWriterEmptyTable(t *testing.T) {
+	output := writeTestTable(t, "json", nil)
+
+	var rows []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(output), &rows))
+	assert.Len(t, rows, 0)
+}
+
+// TestNDJSONTableWriter checks writing table into JSON Lines
+func TestNDJSONTableWriter(t *testing.T) {
+	output := writeTestTable(t, "ndjson", testRows)
+
+	expected := `{"id":1,"name":"foo","valid":true}
+{"id":2,"name":"bar \"baz\"","valid":false}
+`
+	assert.Equal(t, expected, output)
+}
+
+// TestNDJSONTableWriterEmptyTable checks writing empty table into JSON Lines
+func TestNDJSONTableWriterEmptyTable(t *testing.T) {
+	output := writeTestTable(t, "ndjson", nil)
+	assert.Empty(t, output)
+}
@@ -0,0 +1,399 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains post-processing hooks. External command and/or
+// webhook can be configured in [hooks] section. The hooks are invoked for
+// every artifact (file or S3 object) produced by the export together with
+// its SHA-256 checksum, for example to trigger virus scanning or downstream
+// ingestion. Failed invocations are retried and results of all invocations
+// are reported in summary.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/hooks.html
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Environment variables with description of artifact passed to hook command
+const (
+	artifactLocationEnvVariable = "EXPORTER_ARTIFACT_LOCATION"
+	artifactSHA256EnvVariable   = "EXPORTER_ARTIFACT_SHA256"
+	artifactSizeEnvVariable     = "EXPORTER_ARTIFACT_SIZE"
+)
+
+// Default settings of hook invocations
+const (
+	defaultHookTimeout    = 30 * time.Second
+	defaultHookRetryDelay = time.Second
+)
+
+// messages
+const (
+	invokingHooks      = "Invoking post-processing hooks"
+	hookSucceeded      = "Post-processing hook succeeded"
+	hookFailed         = "Post-processing hook failed"
+	hookAttemptFailed  = "Post-processing hook attempt failed, retrying"
+	hooksNotInvoked    = "Post-processing hooks are not invoked for bundled and SFTP exports"
+	hooksFailed        = "%d post-processing hooks failed"
+	webhookStatusError = "webhook returned status %d"
+	artifactsMsg       = "artifacts"
+	hookMsg            = "hook"
+	hookAttemptMsg     = "attempt"
+	unknownHook        = "unknown hook %s"
+)
+
+// kinds of hooks
+const (
+	commandHook = "command"
+	webhookHook = "webhook"
+)
+
+// Artifact describes one file or S3 object produced by the export
+type Artifact struct {
+	Location string `json:"location"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+
+	// PresignedURL allows to download S3 object without access to the
+	// bucket, it is set only when presigned URLs are enabled
+	PresignedURL string `json:"presigned_url,omitempty"`
+}
+
+// ArtifactLog records all artifacts produced by the export.
+//
+// All methods can be called on nil pointer - in this case artifacts are not
+// recorded. Methods are safe to be called from several goroutines.
+type ArtifactLog struct {
+	mutex     sync.Mutex
+	artifacts []Artifact
+}
+
+// NewArtifactLog function constructs empty log of artifacts
+func NewArtifactLog() *ArtifactLog {
+	return &ArtifactLog{}
+}
+
+// Record method records one produced artifact
+func (log *ArtifactLog) Record(artifact Artifact) {
+	if log == nil {
+		return
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.artifacts = append(log.artifacts, artifact)
+}
+
+// Artifacts method returns all recorded artifacts sorted by their location
+func (log *ArtifactLog) Artifacts() []Artifact {
+	if log == nil {
+		return nil
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	artifacts := make([]Artifact, len(log.artifacts))
+	copy(artifacts, log.artifacts)
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Location < artifacts[j].Location
+	})
+	return artifacts
+}
+
+// SetPresignedURL method records presigned URL of artifact stored in given
+// location
+func (log *ArtifactLog) SetPresignedURL(location, url string) {
+	if log == nil {
+		return
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	for i := range log.artifacts {
+		if log.artifacts[i].Location == location {
+			log.artifacts[i].PresignedURL = url
+		}
+	}
+}
+
+// artifactDigest computes checksum and size of artifact while it is being
+// written
+type artifactDigest struct {
+	hash hash.Hash
+	size int64
+}
+
+// newArtifactDigest function constructs digest of artifact. Nil is returned
+// when artifacts are not recorded, so no checksum is computed.
+func newArtifactDigest(log *ArtifactLog) *artifactDigest {
+	if log == nil {
+		return nil
+	}
+	return &artifactDigest{hash: sha256.New()}
+}
+
+// Write method adds data into digest
+func (digest *artifactDigest) Write(data []byte) (int, error) {
+	digest.size += int64(len(data))
+	return digest.hash.Write(data)
+}
+
+// Writer method returns writer that writes into given writer and into digest
+func (digest *artifactDigest) Writer(writer io.Writer) io.Writer {
+	if digest == nil {
+		return writer
+	}
+	return io.MultiWriter(writer, digest)
+}
+
+// Record method records artifact with given location into log
+func (digest *artifactDigest) Record(log *ArtifactLog, location string) {
+	if digest == nil {
+		return
+	}
+
+	log.Record(Artifact{
+		Location: location,
+		SHA256:   hex.EncodeToString(digest.hash.Sum(nil)),
+		Size:     digest.size,
+	})
+}
+
+// recordArtifactData function records artifact with given content into
+// given log, nothing is recorded when the log is nil
+func recordArtifactData(log *ArtifactLog, location string, data []byte) {
+	digest := newArtifactDigest(log)
+	if digest == nil {
+		return
+	}
+
+	_, _ = digest.Write(data)
+	digest.Record(log, location)
+}
+
+// fileDigest function computes checksum and size of file with given name.
+// The file is read again, so the checksum of (compressed) data stored on
+// disk is computed.
+func fileDigest(fileName string) (*artifactDigest, error) {
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	digest := &artifactDigest{hash: sha256.New()}
+	_, err = io.Copy(digest, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+// s3ArtifactLocation function returns location of S3 object reported to
+// hooks
+func s3ArtifactLocation(bucketName, objectName string) string {
+	return "s3://" + bucketName + "/" + objectName
+}
+
+// hooksConfigured function checks whether any post-processing hook is
+// configured
+func hooksConfigured(config HooksConfiguration) bool {
+	return len(config.Command) > 0 || config.WebhookURL != ""
+}
+
+// hookTimeout function returns time one invocation of hook can take
+func hookTimeout(config HooksConfiguration) time.Duration {
+	if config.Timeout <= 0 {
+		return defaultHookTimeout
+	}
+	return config.Timeout
+}
+
+// invokeCommandHook function executes configured command for given
+// artifact. Location and checksum of the artifact are appended to command
+// arguments and passed in environment variables too.
+func invokeCommandHook(ctx context.Context, config HooksConfiguration, artifact Artifact) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout(config))
+	defer cancel()
+
+	args := append(append([]string{}, config.Command[1:]...),
+		artifact.Location, artifact.SHA256)
+
+	// command is selected by administrator in configuration file
+	// #nosec G204
+	command := exec.CommandContext(ctx, config.Command[0], args...)
+	command.Env = append(os.Environ(),
+		artifactLocationEnvVariable+"="+artifact.Location,
+		artifactSHA256EnvVariable+"="+artifact.SHA256,
+		artifactSizeEnvVariable+"="+strconv.FormatInt(artifact.Size, 10))
+
+	output, err := command.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(output))
+	}
+	return err
+}
+
+// invokeWebhook function posts description of given artifact as JSON into
+// configured webhook. Any status other than 2xx is reported as error.
+func invokeWebhook(ctx context.Context, config HooksConfiguration, artifact Artifact) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout(config))
+	defer cancel()
+
+	body, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client, err := httpClient(hooksTLS(config))
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf(webhookStatusError, response.StatusCode)
+	}
+	return nil
+}
+
+// invokeHook function invokes selected hook for given artifact
+func invokeHook(ctx context.Context, config HooksConfiguration, hook string,
+	artifact Artifact) error {
+	switch hook {
+	case commandHook:
+		return invokeCommandHook(ctx, config, artifact)
+	case webhookHook:
+		return invokeWebhook(ctx, config, artifact)
+	default:
+		return fmt.Errorf(unknownHook, hook)
+	}
+}
+
+// invokeHookWithRetries function invokes selected hook for given artifact.
+// Failed invocation is retried configured number of times.
+func invokeHookWithRetries(ctx context.Context, config HooksConfiguration, hook string,
+	artifact Artifact, logger *zerolog.Logger) error {
+	delay := config.RetryDelay
+	if delay <= 0 {
+		delay = defaultHookRetryDelay
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = invokeHook(ctx, config, hook, artifact)
+		if err == nil || attempt >= config.Retries {
+			return err
+		}
+
+		logger.Warn().Err(err).Str(artifactMsg, artifact.Location).
+			Int(hookAttemptMsg, attempt+1).Msg(hookAttemptFailed)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runArtifactHooks function invokes all configured hooks for every given
+// artifact. All artifacts are processed even when some invocations failed,
+// number of succeeded and failed invocations is recorded in summary.
+func runArtifactHooks(ctx context.Context, config HooksConfiguration,
+	artifacts []Artifact, summary *Summary, logger *zerolog.Logger) (int, error) {
+	var hooks []string
+	if len(config.Command) > 0 {
+		hooks = append(hooks, commandHook)
+	}
+	if config.WebhookURL != "" {
+		hooks = append(hooks, webhookHook)
+	}
+
+	logger.Info().Int(artifactsMsg, len(artifacts)).Msg(invokingHooks)
+
+	failed, failedCommands := 0, 0
+	for _, artifact := range artifacts {
+		for _, hook := range hooks {
+			err := invokeHookWithRetries(ctx, config, hook, artifact, logger)
+			summary.AddHookInvocation(err == nil)
+			if err != nil {
+				failed++
+				if hook == commandHook {
+					failedCommands++
+				}
+				logger.Err(err).Str(artifactMsg, artifact.Location).
+					Str(hookMsg, hook).Msg(hookFailed)
+				continue
+			}
+			logger.Debug().Str(artifactMsg, artifact.Location).
+				Str(hookMsg, hook).Msg(hookSucceeded)
+		}
+	}
+
+	// failed notifications are distinguished from failed post-processing
+	switch {
+	case failedCommands > 0:
+		return ExitStatusHookError, fmt.Errorf(hooksFailed, failed)
+	case failed > 0:
+		return ExitStatusNotificationError, fmt.Errorf(hooksFailed, failed)
+	default:
+		return ExitStatusOK, nil
+	}
+}
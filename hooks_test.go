@@ -0,0 +1,250 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/hooks_test.html
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestArtifactLogNil checks that nothing is recorded by nil log
+func TestArtifactLogNil(t *testing.T) {
+	var artifacts *main.ArtifactLog
+
+	artifacts.Record(main.Artifact{Location: "report.csv"})
+	assert.Empty(t, artifacts.Artifacts())
+}
+
+// TestArtifactLog checks that artifacts are returned sorted by location
+func TestArtifactLog(t *testing.T) {
+	artifacts := main.NewArtifactLog()
+
+	artifacts.Record(main.Artifact{Location: "s3://bucket/rule_hit.csv"})
+	artifacts.Record(main.Artifact{Location: "s3://bucket/report.csv"})
+
+	assert.Equal(t, []main.Artifact{
+		{Location: "s3://bucket/report.csv"},
+		{Location: "s3://bucket/rule_hit.csv"},
+	}, artifacts.Artifacts())
+}
+
+// TestArtifactLogSetPresignedURL checks that presigned URL is recorded for
+// artifact stored in given location only
+func TestArtifactLogSetPresignedURL(t *testing.T) {
+	artifacts := main.NewArtifactLog()
+
+	artifacts.Record(main.Artifact{Location: "s3://bucket/report.csv"})
+	artifacts.Record(main.Artifact{Location: "s3://bucket/rule_hit.csv"})
+	artifacts.SetPresignedURL("s3://bucket/report.csv", "https://example.com/report.csv")
+
+	assert.Equal(t, []main.Artifact{
+		{Location: "s3://bucket/report.csv", PresignedURL: "https://example.com/report.csv"},
+		{Location: "s3://bucket/rule_hit.csv"},
+	}, artifacts.Artifacts())
+
+	// nothing is recorded by nil log
+	var nilLog *main.ArtifactLog
+	nilLog.SetPresignedURL("s3://bucket/report.csv", "https://example.com/report.csv")
+}
+
+// writeHookScript helper function writes shell script used as hook command
+// into temporary directory
+func writeHookScript(t *testing.T, script string) string {
+	fileName := filepath.Join(t.TempDir(), "hook.sh")
+	assert.NoError(t, os.WriteFile(fileName, []byte("#!/bin/sh\n"+script), 0o700))
+	return fileName
+}
+
+// TestRunArtifactHooksCommand checks that command is executed with location
+// and checksum of every artifact
+func TestRunArtifactHooksCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "invocations.txt")
+	script := writeHookScript(t, `echo "$1 $2 $EXPORTER_ARTIFACT_SIZE" >> `+output+"\n")
+
+	summary := main.NewSummary()
+	code, err := main.RunArtifactHooks(context.Background(), main.HooksConfiguration{
+		Command: []string{script},
+	}, []main.Artifact{
+		{Location: "report.csv", SHA256: "abc", Size: 10},
+		{Location: "rule_hit.csv", SHA256: "def", Size: 20},
+	}, summary, &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "report.csv abc 10\nrule_hit.csv def 20\n", string(content))
+
+	succeeded, failed := summary.HookInvocations()
+	assert.Equal(t, 2, succeeded)
+	assert.Equal(t, 0, failed)
+}
+
+// TestRunArtifactHooksRetries checks that failed invocation is retried
+func TestRunArtifactHooksRetries(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "attempts")
+	// the first attempt fails
+	script := writeHookScript(t, `echo >> `+counter+`
+test "$(wc -l < `+counter+`)" -ge 2
+`)
+
+	summary := main.NewSummary()
+	code, err := main.RunArtifactHooks(context.Background(), main.HooksConfiguration{
+		Command:    []string{script},
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	}, []main.Artifact{{Location: "report.csv"}}, summary, &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	succeeded, failed := summary.HookInvocations()
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 0, failed)
+}
+
+// TestRunArtifactHooksWebhook checks that description of every artifact is
+// posted into webhook and that failed invocations are reported
+func TestRunArtifactHooksWebhook(t *testing.T) {
+	var posted []main.Artifact
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var artifact main.Artifact
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&artifact))
+		posted = append(posted, artifact)
+
+		if strings.HasPrefix(artifact.Location, "s3://infected/") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	artifacts := []main.Artifact{
+		{Location: "s3://bucket/report.csv", SHA256: "abc", Size: 10},
+		{Location: "s3://infected/report.csv", SHA256: "def", Size: 20},
+	}
+
+	summary := main.NewSummary()
+	code, err := main.RunArtifactHooks(context.Background(), main.HooksConfiguration{
+		WebhookURL: server.URL,
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	}, artifacts, summary, &log.Logger)
+	assert.EqualError(t, err, "1 post-processing hooks failed")
+	assert.Equal(t, main.ExitStatusNotificationError, code)
+
+	// failed invocation has been retried
+	assert.Equal(t, []main.Artifact{artifacts[0], artifacts[1], artifacts[1]}, posted)
+
+	succeeded, failed := summary.HookInvocations()
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 1, failed)
+}
+
+// TestPerformDataExportRecordsArtifacts checks that files written by the
+// export are recorded with checksums of their content
+func TestPerformDataExportRecordsArtifacts(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	artifacts := main.NewArtifactLog()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), artifacts, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	checksum := sha256.Sum256(content)
+
+	assert.Contains(t, artifacts.Artifacts(), main.Artifact{
+		Location: filepath.Join(directory, "report.csv"),
+		SHA256:   hex.EncodeToString(checksum[:]),
+		Size:     int64(len(content)),
+	})
+}
+
+// TestPerformDataExportToS3RecordsArtifacts checks that objects uploaded
+// into S3 are recorded with checksums of their content
+func TestPerformDataExportToS3RecordsArtifacts(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+	}
+
+	artifacts := main.NewArtifactLog()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "S3"}, &log.Logger, &log.Logger, main.NewSummary(), artifacts, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content := s3.objects["/bucket/prefix/report.csv"]
+	checksum := sha256.Sum256(content)
+
+	assert.Equal(t, []main.Artifact{{
+		Location: "s3://bucket/prefix/report.csv",
+		SHA256:   hex.EncodeToString(checksum[:]),
+		Size:     int64(len(content)),
+	}}, artifacts.Artifacts())
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains handling of identity and generated columns in
+// SQL dump. Values of generated columns can't be inserted back into
+// PostgreSQL, so such columns are left out of INSERT statements. Identity
+// columns are either inserted with OVERRIDING SYSTEM VALUE (override) or
+// they are left out too, so new values are generated (skip). Columns are
+// detected in PostgreSQL only.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/identity.html
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQL statement used to read identity and generated columns of given table
+const selectIdentityColumns = `
+        SELECT attname, attidentity, attgenerated
+          FROM pg_catalog.pg_attribute
+         WHERE attrelid = $1::regclass
+           AND attnum > 0
+           AND NOT attisdropped
+           AND (attidentity <> '' OR attgenerated <> '')`
+
+// Supported handling of identity columns in SQL dump
+const (
+	overrideIdentityColumns = "override"
+	skipIdentityColumns     = "skip"
+)
+
+// Kinds of identity columns as used in CREATE TABLE statement
+const (
+	identityAlways    = "ALWAYS"
+	identityByDefault = "BY DEFAULT"
+)
+
+// messages
+const (
+	unknownIdentityColumnsHandling = "Unknown handling of identity columns: %s"
+	readIdentityColumnsFailed      = "Read identity columns failed"
+)
+
+// checkIdentityColumnsHandling function checks if given handling of identity
+// columns is supported. Empty value means that the values are overridden.
+func checkIdentityColumnsHandling(handling string) error {
+	switch handling {
+	case "", overrideIdentityColumns, skipIdentityColumns:
+		return nil
+	default:
+		return fmt.Errorf(unknownIdentityColumnsHandling, handling)
+	}
+}
+
+// identityKind function converts identity flag stored in pg_attribute into
+// kind of identity column
+func identityKind(flag string) string {
+	switch flag {
+	case "a":
+		return identityAlways
+	case "d":
+		return identityByDefault
+	default:
+		return ""
+	}
+}
+
+// ReadIdentityColumns method reads identity and generated columns of given
+// table. Identity columns are returned with their kind, generated columns
+// in separate set.
+func (storage DBStorage) ReadIdentityColumns(ctx context.Context, tableName TableName) (
+	map[string]string, map[string]bool, error) {
+	identity := make(map[string]string)
+	generated := make(map[string]bool)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectIdentityColumns, string(tableName))
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectIdentityColumns).Msg(sqlStatementExecutionError)
+		return nil, nil, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var column, identityFlag, generatedFlag sql.NullString
+
+		err := rows.Scan(&column, &identityFlag, &generatedFlag)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if kind := identityKind(identityFlag.String); kind != "" {
+			identity[column.String] = kind
+		}
+		if generatedFlag.String != "" {
+			generated[column.String] = true
+		}
+	}
+
+	return identity, generated, rows.Err()
+}
+
+// tableColumns method returns names and types of all columns of given
+// table. Types of masked columns follow the masked values. Identity and
+// generated columns are marked for SQL dump of PostgreSQL tables, so they
+// can be inserted back.
+func (storage DBStorage) tableColumns(ctx context.Context, tableName TableName,
+	columnTypes []*sql.ColumnType, format string) ([]Column, error) {
+	columns := getColumns(storage.dbDriverType, columnTypes)
+
+	masks, err := storage.tableMasks(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		if mask, found := masks[columns[i].Name]; found {
+			columns[i].DatabaseType = digestedColumnType(mask, columns[i].DatabaseType)
+		}
+	}
+
+	if format != sqlFormat || storage.dbDriverType != DBDriverPostgres {
+		return columns, nil
+	}
+
+	identity, generated, err := storage.ReadIdentityColumns(ctx, tableName)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(tableNameMsg, string(tableName)).Msg(readIdentityColumnsFailed)
+		return nil, err
+	}
+
+	for i := range columns {
+		name := columns[i].Name
+		columns[i].Identity = identity[name]
+		columns[i].Omitted = generated[name] ||
+			(identity[name] != "" && storage.identityColumns == skipIdentityColumns)
+	}
+
+	return columns, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/identity_test.html
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// expected query to read identity and generated columns
+const readIdentityColumnsQuery = `SELECT attname, attidentity, attgenerated\s+FROM pg_catalog.pg_attribute`
+
+// TestReadIdentityColumns checks the method ReadIdentityColumns
+func TestReadIdentityColumns(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"attname", "attidentity", "attgenerated"})
+	rows.AddRow("id", "a", "")
+	rows.AddRow("seq", "d", "")
+	rows.AddRow("total", "", "s")
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readIdentityColumnsQuery).WithArgs("report").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	identity, generated, err := storage.ReadIdentityColumns(context.Background(), "report")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "ALWAYS", "seq": "BY DEFAULT"}, identity)
+	assert.Equal(t, map[string]bool{"total": true}, generated)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadIdentityColumnsError checks the method ReadIdentityColumns when
+// the query fails
+func TestReadIdentityColumnsError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readIdentityColumnsQuery).WithArgs("report").
+		WillReturnError(errors.New("relation does not exist"))
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	_, _, err := storage.ReadIdentityColumns(context.Background(), "report")
+	assert.EqualError(t, err, "relation does not exist")
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,373 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains publishing of exported rows into Kafka topics.
+// Each row is serialized into JSON object (the same as in ndjson format) and
+// published as one message. Topic name can contain the name of exported
+// table, so each table can be published into its own topic. Rejected rows
+// of each table are published into topic of `<table>_rejects` table.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/kafka.html
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// placeholder in topic name that is replaced by name of exported table
+const kafkaTablePlaceholder = "{table}"
+
+// maximum number of messages sent to Kafka in one batch
+const kafkaBatchSize = 100
+
+// messages
+const (
+	kafkaBrokersNotSet        = "at least one Kafka broker needs to be set"
+	kafkaMetadataUnsupported  = "Metadata, disabled rules and custom queries are not published into Kafka"
+	kafkaProducerFailed       = "Unable to create Kafka producer"
+	publishingTableIntoKafka  = "Publishing table into Kafka"
+	publishTableIntoKafkaFail = "Publish table into Kafka failed"
+	publishRejectsFailed      = "Publish rejected rows into Kafka failed"
+	kafkaTopicMsg             = "topic"
+)
+
+// kafkaTopic function constructs name of topic rows of given table are
+// published to
+func kafkaTopic(template string, tableName TableName) string {
+	return strings.ReplaceAll(template, kafkaTablePlaceholder, string(tableName))
+}
+
+// kafkaTableWriter publishes each table row as JSON object into Kafka topic.
+// Messages are sent in batches, so not all rows are published before Flush
+// is called.
+type kafkaTableWriter struct {
+	producer   sarama.SyncProducer
+	topic      string
+	keyColumns []string
+	messages   []*sarama.ProducerMessage
+}
+
+// newKafkaTableWriter function constructs writer that publishes rows of
+// one table into selected topic. Messages are keyed by values of given key
+// columns, so all changes of one row land in the same partition. Messages
+// without key columns have no key and are spread across partitions.
+func newKafkaTableWriter(producer sarama.SyncProducer, topic string,
+	keyColumns []string) *kafkaTableWriter {
+	return &kafkaTableWriter{
+		producer:   producer,
+		topic:      topic,
+		keyColumns: keyColumns,
+		messages:   make([]*sarama.ProducerMessage, 0, kafkaBatchSize),
+	}
+}
+
+// WriteHeader method does nothing, column names are part of row objects
+func (w *kafkaTableWriter) WriteHeader(_ []string) error {
+	return nil
+}
+
+// WriteRow method prepares one row as JSON message. The batch of messages is
+// sent when it is full.
+func (w *kafkaTableWriter) WriteRow(colNames []string, row M) error {
+	object, err := marshalRow(colNames, row)
+	if err != nil {
+		return err
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic: w.topic,
+		Value: sarama.ByteEncoder(object),
+	}
+	if len(w.keyColumns) > 0 {
+		key, err := marshalRow(w.keyColumns, row)
+		if err != nil {
+			return err
+		}
+		message.Key = sarama.ByteEncoder(key)
+	}
+	w.messages = append(w.messages, message)
+
+	if len(w.messages) >= kafkaBatchSize {
+		return w.send()
+	}
+	return nil
+}
+
+// Flush method sends all messages that have not been sent yet
+func (w *kafkaTableWriter) Flush() error {
+	return w.send()
+}
+
+// send method sends all prepared messages in one batch
+func (w *kafkaTableWriter) send() error {
+	if len(w.messages) == 0 {
+		return nil
+	}
+
+	err := w.producer.SendMessages(w.messages)
+	w.messages = w.messages[:0]
+	return err
+}
+
+// publishRejectsIntoKafka function publishes rejected rows of given table as
+// JSON objects into topic constructed for `<table>_rejects` table. Values
+// are published as they have been read from database, the error is stored
+// in the last key.
+func publishRejectsIntoKafka(producer sarama.SyncProducer, topicTemplate string,
+	quarantine *Quarantine, tableName TableName) error {
+	colNames, rejects := quarantine.Rejects(tableName)
+	if len(rejects) == 0 {
+		return nil
+	}
+
+	rejectsTable := TableName(string(tableName) + rejectsSuffix)
+	writer := newKafkaTableWriter(producer,
+		kafkaTopic(topicTemplate, rejectsTable), nil)
+
+	rejectNames := append(append([]string{}, colNames...), rejectErrorColumn)
+	for _, reject := range rejects {
+		row := make(M, len(rejectNames))
+		for _, colName := range colNames {
+			row[colName] = reject.Row[colName]
+		}
+		row[rejectErrorColumn] = reject.Error
+
+		err := writer.WriteRow(rejectNames, row)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// NewKafkaProducer function constructs producer connected to configured
+// Kafka brokers. Producer needs to be closed by caller.
+func NewKafkaProducer(configuration *ConfigStruct) (sarama.SyncProducer, error) {
+	// check if configuration structure has been provided
+	if configuration == nil {
+		err := ErrNilConfiguration
+		log.Error().Err(err).Msg(configurationError)
+		return nil, err
+	}
+
+	config := GetKafkaConfiguration(configuration)
+	if len(config.Brokers) == 0 {
+		return nil, errors.New(kafkaBrokersNotSet)
+	}
+
+	saramaConfig := sarama.NewConfig()
+	// needed by synchronous producer
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+
+	log.Info().Strs("Kafka brokers", config.Brokers).Msg("Preparing connection")
+	return sarama.NewSyncProducer(config.Brokers, saramaConfig)
+}
+
+// StoreTableIntoKafka method publishes all rows of given table into topic
+// constructed from topic template
+func (storage DBStorage) StoreTableIntoKafka(ctx context.Context, producer sarama.SyncProducer,
+	topicTemplate string, tableName TableName, limit int) error {
+	columnTypes, err := storage.RetrieveColumnTypes(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	colNames := getColumnNames(columnTypes)
+
+	topic := kafkaTopic(topicTemplate, tableName)
+	storage.logger.Info().Str(kafkaTopicMsg, topic).Msg(publishingTableIntoKafka)
+
+	keyColumns, err := storage.kafkaKeyColumns(ctx, tableName, colNames)
+	if err != nil {
+		return err
+	}
+
+	writer := newKafkaTableWriter(producer, topic, keyColumns)
+
+	err = storage.WriteTableContent(ctx, writer, tableName, colNames, limit)
+	if err != nil {
+		return err
+	}
+
+	// measure time spent by sending the last batch
+	defer storage.summary.MeasureStage(stageUpload)()
+
+	return writer.Flush()
+}
+
+// kafkaKeyColumns method returns primary key columns of given table
+// messages are keyed by. No columns are returned for tables without primary
+// key and for tables with key columns not exported (dropped by masking).
+func (storage DBStorage) kafkaKeyColumns(ctx context.Context, tableName TableName,
+	colNames []string) ([]string, error) {
+	keyColumns, err := storage.readPrimaryKeyColumns(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]bool, len(colNames))
+	for _, colName := range colNames {
+		exported[colName] = true
+	}
+	for _, keyColumn := range keyColumns {
+		if !exported[keyColumn] {
+			return nil, nil
+		}
+	}
+
+	return keyColumns, nil
+}
+
+// performDataExportToKafka function publishes rows of all tables into Kafka
+// topics
+func performDataExportToKafka(ctx context.Context, configuration *ConfigStruct,
+	storage *DBStorage, exportMetadata bool,
+	exportDisabledRules bool,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, summary *Summary) (int, error) {
+	operationLogger.Info().Msg("Exporting to Kafka")
+
+	if exportMetadata || exportDisabledRules || len(storage.queries) > 0 {
+		storage.logger.Warn().Msg(kafkaMetadataUnsupported)
+		operationLogger.Warn().Msg(kafkaMetadataUnsupported)
+	}
+
+	producer, err := NewKafkaProducer(configuration)
+	if err != nil {
+		storage.logger.Err(err).Msg(kafkaProducerFailed)
+		operationLogger.Err(err).Msg(kafkaProducerFailed)
+		return ExitStatusKafkaError, err
+	}
+	defer func() {
+		if err := producer.Close(); err != nil {
+			storage.logger.Err(err).Msg("Close Kafka producer")
+		}
+	}()
+
+	operationLogger.Info().Msg(readingListOfTables)
+
+	stopMeasuring := summary.MeasureStage(stageDiscovery)
+	tableNames, err := storage.ReadListOfTables(ctx)
+	stopMeasuring()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// only selected tables are exported
+	tableNames, err = storage.selectTables(tableNames)
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	storage.logger.Info().Int("count", len(tableNames)).Msg(listOfTablesMsg)
+
+	// log into terminal
+	printTables(&storage.logger, tableNames)
+
+	operationLogger.Info().Msg(exportingTables)
+
+	status, err := publishTablesIntoKafka(ctx, storage, producer,
+		GetKafkaConfiguration(configuration).Topic, tableNames,
+		operationLogger, limit, ignoredTables, summary)
+	if err != nil {
+		return status, err
+	}
+
+	operationLogger.Info().Msg(closingConnectionToStorage)
+
+	// we have finished, let's close the connection to database
+	err = storage.Close()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// default exit value + no error
+	return ExitStatusOK, nil
+}
+
+// publishTablesIntoKafka function publishes rows of given tables into Kafka
+// topics. Rejected rows of each table are published after the table. When
+// export continues after failure, tables that can't be published are
+// recorded and remaining tables are published.
+func publishTablesIntoKafka(ctx context.Context, storage *DBStorage,
+	producer sarama.SyncProducer, topicTemplate string, tableNames []TableName,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, summary *Summary) (int, error) {
+	for _, tableName := range tableNames {
+		// ignore table if specified by user
+		if _, found := ignoredTables[string(tableName)]; found {
+			operationLogger.Info().
+				Str(tableNameMsg, string(tableName)).
+				Msg(tableIsIgnored)
+			continue
+		}
+		operationLogger.Info().
+			Str(tableNameMsg, string(tableName)).
+			Msg(exportingTable)
+
+		// all messages logged during table export contain its name
+		tableStorage := storage.WithLogger(storage.logger.With().
+			Str(tableNameMsg, string(tableName)).Logger())
+		tableStorage.explainTable(ctx, operationLogger, tableName, limit)
+		err := tableStorage.StoreTableIntoKafka(ctx, producer, topicTemplate,
+			tableName, limit)
+		if err != nil {
+			tableStorage.logger.Err(err).Msg(publishTableIntoKafkaFail)
+			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+				Msg(publishTableIntoKafkaFail)
+			if storage.continueAfterFailure(operationLogger, tableName,
+				ExitStatusKafkaError, err) {
+				continue
+			}
+			return ExitStatusKafkaError, err
+		}
+		err = publishRejectsIntoKafka(producer, topicTemplate,
+			storage.quarantine, tableName)
+		if err != nil {
+			storage.logger.Err(err).Msg(publishRejectsFailed)
+			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+				Msg(publishRejectsFailed)
+			if storage.continueAfterFailure(operationLogger, tableName,
+				ExitStatusKafkaError, err) {
+				continue
+			}
+			return ExitStatusKafkaError, err
+		}
+		logTableAudit(&storage.logger, operationLogger, storage.audit, tableName)
+		logRejects(&storage.logger, operationLogger, storage.quarantine, tableName)
+		summary.AddExportedTable()
+	}
+
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,341 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/kafka_test.html
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// expectMessage helper function prepares expectation for message with given
+// value
+func expectMessage(producer *mocks.SyncProducer, expected string) {
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		if string(value) != expected {
+			return errors.New("unexpected message: " + string(value))
+		}
+		return nil
+	})
+}
+
+// expectKeyedMessage helper function prepares expectation for message with
+// given key and value, empty key means message without key
+func expectKeyedMessage(producer *mocks.SyncProducer, expectedKey, expected string) {
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+		func(message *sarama.ProducerMessage) error {
+			key := ""
+			if message.Key != nil {
+				encoded, err := message.Key.Encode()
+				if err != nil {
+					return err
+				}
+				key = string(encoded)
+			}
+			value, err := message.Value.Encode()
+			if err != nil {
+				return err
+			}
+			if key != expectedKey || string(value) != expected {
+				return errors.New("unexpected message: " + key + " " + string(value))
+			}
+			return nil
+		})
+}
+
+// TestKafkaTopic checks the function kafkaTopic
+func TestKafkaTopic(t *testing.T) {
+	assert.Equal(t, "exports.report", main.KafkaTopic("exports.{table}", "report"))
+	assert.Equal(t, "exports", main.KafkaTopic("exports", "report"))
+}
+
+// TestStoreTableIntoKafka checks that each table row is published as one
+// JSON message
+func TestStoreTableIntoKafka(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	expectMessage(producer, `{"id":1,"report":"first"}`)
+	expectMessage(producer, `{"id":2,"report":"second"}`)
+
+	storage := mustCreateSQLiteStorage(t)
+
+	err := storage.StoreTableIntoKafka(context.Background(), producer, "exports.{table}", "report", NoLimits)
+	assert.NoError(t, err)
+}
+
+// TestStoreTableIntoKafkaKeys checks that messages are keyed by primary key
+// of published rows, so they are spread across partitions
+func TestStoreTableIntoKafkaKeys(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	expectKeyedMessage(producer, `{"org_id":1,"cluster":"a"}`,
+		`{"cluster":"a","org_id":1,"report":"first"}`)
+	expectKeyedMessage(producer, `{"org_id":2,"cluster":"b"}`,
+		`{"cluster":"b","org_id":2,"report":"second"}`)
+
+	connection, err := sql.Open("sqlite3", newSQLiteTestDatabase(t,
+		`CREATE TABLE report (cluster TEXT, org_id INTEGER, report TEXT, PRIMARY KEY (org_id, cluster))`,
+		`INSERT INTO report VALUES ('a', 1, 'first'), ('b', 2, 'second')`))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3,
+		&main.StorageConfiguration{Driver: "sqlite3"})
+
+	err = storage.StoreTableIntoKafka(context.Background(), producer, "exports.{table}", "report", NoLimits)
+	assert.NoError(t, err)
+}
+
+// TestStoreTableIntoKafkaDroppedKey checks that messages have no key when
+// key column is dropped by masking, tables without primary key are
+// published without keys too
+func TestStoreTableIntoKafkaDroppedKey(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	expectKeyedMessage(producer, "", `{"report":"first"}`)
+	expectKeyedMessage(producer, "", `{"id":1,"report":"first"}`)
+
+	connection, err := sql.Open("sqlite3", newSQLiteTestDatabase(t,
+		`CREATE TABLE report (org_id INTEGER PRIMARY KEY, report TEXT)`,
+		`INSERT INTO report VALUES (1, 'first')`,
+		`CREATE TABLE rule_hit (id INTEGER, report TEXT)`,
+		`INSERT INTO rule_hit VALUES (1, 'first')`))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3,
+		&main.StorageConfiguration{Driver: "sqlite3"})
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"org_id": "drop"},
+	})
+
+	err = storage.StoreTableIntoKafka(context.Background(), producer, "exports.{table}", "report", NoLimits)
+	assert.NoError(t, err)
+	err = storage.StoreTableIntoKafka(context.Background(), producer, "exports.{table}", "rule_hit", NoLimits)
+	assert.NoError(t, err)
+}
+
+// TestKafkaTableWriterBatches checks that messages are sent in batches and
+// the last batch is sent by Flush
+func TestKafkaTableWriterBatches(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	writer := main.NewKafkaTableWriter(producer, "exports.report", nil)
+	assert.NoError(t, writer.WriteHeader([]string{"id"}))
+
+	// the first batch is sent when it is full
+	for i := 0; i < 100; i++ {
+		producer.ExpectSendMessageAndSucceed()
+		assert.NoError(t, writer.WriteRow([]string{"id"}, main.M{"id": i}))
+	}
+
+	// the rest is sent by Flush
+	producer.ExpectSendMessageAndSucceed()
+	assert.NoError(t, writer.WriteRow([]string{"id"}, main.M{"id": 100}))
+	assert.NoError(t, writer.Flush())
+
+	// nothing to send
+	assert.NoError(t, writer.Flush())
+}
+
+// TestKafkaTableWriterSendError checks that error during publishing is
+// reported
+func TestKafkaTableWriterSendError(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		_ = producer.Close()
+	}()
+
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	writer := main.NewKafkaTableWriter(producer, "exports.report", nil)
+	assert.NoError(t, writer.WriteRow([]string{"id"}, main.M{"id": 1}))
+	assert.Error(t, writer.Flush())
+}
+
+// TestNewKafkaProducerImproperConfiguration checks that producer is not
+// constructed without configured brokers
+func TestNewKafkaProducerImproperConfiguration(t *testing.T) {
+	_, err := main.NewKafkaProducer(nil)
+	assert.Error(t, err)
+
+	_, err = main.NewKafkaProducer(&main.ConfigStruct{})
+	assert.EqualError(t, err, "at least one Kafka broker needs to be set")
+}
+
+// TestPublishRejectsIntoKafka checks that rejected rows are published into
+// topic of rejects table together with the error
+func TestPublishRejectsIntoKafka(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	quarantine := main.NewQuarantine()
+	colNames := []string{"id", "report"}
+	quarantine.Reject("report", colNames, main.M{"id": 1, "report": "first"},
+		errors.New("invalid UTF-8"))
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+		func(message *sarama.ProducerMessage) error {
+			value, err := message.Value.Encode()
+			if err != nil {
+				return err
+			}
+			if message.Topic != "exports.report_rejects" ||
+				string(value) != `{"id":1,"report":"first","error":"invalid UTF-8"}` {
+				return errors.New("unexpected message: " + string(value))
+			}
+			return nil
+		})
+
+	assert.NoError(t, main.PublishRejectsIntoKafka(producer, "exports.{table}",
+		quarantine, "report"))
+
+	// nothing is published for tables without rejected rows
+	assert.NoError(t, main.PublishRejectsIntoKafka(producer, "exports.{table}",
+		quarantine, "rule_hit"))
+	assert.NoError(t, main.PublishRejectsIntoKafka(producer, "exports.{table}",
+		nil, "report"))
+}
+
+// TestPublishTablesIntoKafkaRejects checks that rows rejected during export
+// are published into Kafka
+func TestPublishTablesIntoKafkaRejects(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	connection, err := sql.Open("sqlite3", prepareDatabaseWithInvalidUTF8(t))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3,
+		&main.StorageConfiguration{Driver: "sqlite3"})
+	quarantine := main.NewQuarantine()
+	main.SetQuarantine(storage, quarantine)
+
+	// the second row is not valid UTF-8
+	main.SetInvalidUTF8(storage, "reject")
+
+	expectMessage(producer, `{"id":1,"report":"first"}`)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+		func(message *sarama.ProducerMessage) error {
+			if message.Topic != "exports.report_rejects" {
+				return errors.New("unexpected topic: " + message.Topic)
+			}
+			return nil
+		})
+
+	summary := main.NewSummary()
+	code, err := main.PublishTablesIntoKafka(context.Background(), storage,
+		producer, "exports.{table}", []main.TableName{"report"}, &log.Logger,
+		NoLimits, main.IgnoredTables{}, summary)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	assert.Equal(t, 1, summary.ExportedTables())
+	assert.Equal(t, 1, quarantine.Count("report"))
+}
+
+// TestPublishTablesIntoKafkaAbortsOnFailure checks that the first table
+// that can't be published aborts the export by default
+func TestPublishTablesIntoKafkaAbortsOnFailure(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	// both rows of the first table are sent in one batch, nothing is
+	// expected from the second table
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	storage := mustCreateSQLiteStorage(t)
+
+	summary := main.NewSummary()
+	code, err := main.PublishTablesIntoKafka(context.Background(), storage,
+		producer, "exports.{table}", []main.TableName{"report", "report"},
+		&log.Logger, NoLimits, main.IgnoredTables{}, summary)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusKafkaError, code)
+	assert.Equal(t, 0, summary.ExportedTables())
+}
+
+// TestPublishTablesIntoKafkaKeepGoing checks that remaining tables are
+// published when export continues after failure
+func TestPublishTablesIntoKafkaKeepGoing(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer func() {
+		assert.NoError(t, producer.Close())
+	}()
+
+	// publishing of the first table (both rows sent in one batch) fails,
+	// the second one is published
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	expectMessage(producer, `{"id":1,"report":"first"}`)
+	expectMessage(producer, `{"id":2,"report":"second"}`)
+
+	storage := mustCreateSQLiteStorage(t)
+	summary := main.NewSummary()
+	main.SetKeepGoing(storage, summary)
+
+	code, err := main.PublishTablesIntoKafka(context.Background(), storage,
+		producer, "exports.{table}", []main.TableName{"report", "report"},
+		&log.Logger, NoLimits, main.IgnoredTables{}, summary)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	assert.Equal(t, 1, summary.ExportedTables())
+
+	failures := summary.FailedTables()
+	assert.Len(t, failures, 1)
+	assert.Equal(t, main.TableName("report"), failures[0].Table)
+	assert.Equal(t, main.ExitStatusKafkaError, failures[0].Status)
+}
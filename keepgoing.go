@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains continue-on-error policy. By default the first
+// table that can't be exported aborts the whole run. When -keep-going flag
+// is used, the failure is recorded, remaining tables are exported and the
+// run finishes with partial success exit status, so one broken table does
+// not block export of all other tables.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/keepgoing.html
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// messages
+const (
+	tableExportFailed    = "Export of table failed, continuing with remaining tables"
+	tableNotExported     = "Table has not been exported"
+	tablesFailed         = "export of %d table(s) failed"
+	partialExport        = "Export finished with partial success"
+	keepGoingWithArchive = "Export can't continue after failure when tables are stored into one archive"
+	watermarksNotStored  = "Watermarks are not stored, because export of some tables failed"
+	exitStatusMsg        = "exit status"
+)
+
+// FailedTable represents table that has not been exported because of error,
+// it is recorded when export continues with remaining tables
+type FailedTable struct {
+	Table  TableName
+	Status int
+	Error  string
+}
+
+// continueAfterFailure method records failure of table export when the
+// export continues with remaining tables. False is returned when the whole
+// export needs to be aborted.
+func (storage DBStorage) continueAfterFailure(operationLogger *zerolog.Logger,
+	tableName TableName, status int, err error) bool {
+	if !storage.keepGoing {
+		return false
+	}
+
+	storage.summary.AddFailedTable(FailedTable{
+		Table:  tableName,
+		Status: status,
+		Error:  err.Error(),
+	})
+	operationLogger.Warn().Err(err).
+		Str(tableNameMsg, string(tableName)).
+		Int(exitStatusMsg, status).
+		Msg(tableExportFailed)
+	return true
+}
+
+// checkFailedTables function reports all tables that have not been
+// exported. Partial success exit status is returned when there are any.
+func checkFailedTables(operationLogger *zerolog.Logger, summary *Summary) (int, error) {
+	failures := summary.FailedTables()
+	if len(failures) == 0 {
+		return ExitStatusOK, nil
+	}
+
+	for _, failure := range failures {
+		operationLogger.Error().
+			Str(tableNameMsg, string(failure.Table)).
+			Int(exitStatusMsg, failure.Status).
+			Str("error", failure.Error).
+			Msg(tableNotExported)
+	}
+
+	err := fmt.Errorf(tablesFailed, len(failures))
+	operationLogger.Err(err).Msg(partialExport)
+	return ExitStatusPartialSuccess, err
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/keepgoing_test.html
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// failingTableConfiguration function returns configuration with two tables,
+// export of table report fails because its incremental column does not
+// exist
+func failingTableConfiguration(t *testing.T) main.ConfigStruct {
+	return main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report", "rule_hit"),
+		},
+		Export: main.ExportConfiguration{
+			WatermarkStateFile: filepath.Join(t.TempDir(), "watermarks.json"),
+		},
+		Incremental: main.IncrementalConfiguration{
+			"report": "updated_at",
+		},
+	}
+}
+
+// TestPerformDataExportAbortsOnFailure checks that the first failed table
+// aborts the export by default
+func TestPerformDataExportAbortsOnFailure(t *testing.T) {
+	configuration := failingTableConfiguration(t)
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: t.TempDir(),
+	}
+
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusStorageError, code)
+
+	assert.NoFileExists(t, filepath.Join(cliFlags.OutputDirectory, "rule_hit.csv"))
+	assert.Empty(t, summary.FailedTables())
+}
+
+// TestPerformDataExportKeepGoing checks that remaining tables are exported
+// when export continues after failure
+func TestPerformDataExportKeepGoing(t *testing.T) {
+	configuration := failingTableConfiguration(t)
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: t.TempDir(),
+		KeepGoing:       true,
+	}
+
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.EqualError(t, err, "export of 1 table(s) failed")
+	assert.Equal(t, main.ExitStatusPartialSuccess, code)
+
+	assert.FileExists(t, filepath.Join(cliFlags.OutputDirectory, "rule_hit.csv"))
+	assert.Equal(t, 1, summary.ExportedTables())
+	assert.Equal(t, []main.FailedTable{{
+		Table:  "report",
+		Status: main.ExitStatusStorageError,
+		Error:  "column updated_at configured for incremental export not found in table report",
+	}}, summary.FailedTables())
+
+	// watermarks are not moved when some tables have not been exported
+	assert.NoFileExists(t, configuration.Export.WatermarkStateFile)
+
+	// failed tables are part of summary table
+	var output bytes.Buffer
+	assert.NoError(t, main.PrintSummary(&output, summary))
+	assert.Contains(t, output.String(), "Export of table report failed: "+
+		"column updated_at configured for incremental export not found in table report\n")
+}
+
+// TestPerformDataExportToS3KeepGoing checks that remaining tables are
+// exported into S3 when export continues after failure
+func TestPerformDataExportToS3KeepGoing(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := failingTableConfiguration(t)
+	configuration.S3 = main.S3Configuration{
+		EndpointURL:  host,
+		EndpointPort: uint(endpointPort),
+		Bucket:       "bucket",
+	}
+
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "S3", KeepGoing: true}, &log.Logger, &log.Logger, summary, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusPartialSuccess, code)
+
+	assert.Contains(t, s3.objects, "/bucket/rule_hit.csv")
+	assert.NotContains(t, s3.objects, "/bucket/report.csv")
+	assert.Len(t, summary.FailedTables(), 1)
+}
@@ -23,18 +23,35 @@ package main
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/logging.html
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	zlogsentry "github.com/archdx/zerolog-sentry"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-// InitLogging add more writers to zerolog log object. This way the logging can be sent to
-// many targets. For the moment just STDOUT and Sentry are configured.
-func InitLogging(config *ConfigStruct) (func(), error) {
+// messages and log fields
+const (
+	runIDMsg     = "run ID"
+	operationMsg = "operation"
+)
+
+// globalLoggerMutex guards replacement of global logger, because several
+// exports can be initialized concurrently when exporter is used as library
+var globalLoggerMutex sync.Mutex
+
+// NewLogger function constructs logger that writes into all configured
+// targets. For the moment just STDOUT and Sentry are configured. Global
+// logger is not changed. Returned function needs to be called to close all
+// writers.
+func NewLogger(config *ConfigStruct) (zerolog.Logger, func(), error) {
 	var (
 		writers       []io.Writer
 		writeClosers  []io.WriteCloser
@@ -58,26 +75,62 @@ func InitLogging(config *ConfigStruct) (func(), error) {
 		sentryWriter, err := setupSentryLogging(sentryConf)
 		if err != nil {
 			err = fmt.Errorf("Error initializing Sentry logging: %s", err.Error())
-			return func() {}, err
+			return zerolog.Nop(), func() {}, err
 		}
 		writers = append(writers, sentryWriter)
 		writeClosers = append(writeClosers, sentryWriter)
 	}
 
 	logsWriter := zerolog.MultiLevelWriter(writers...)
-	log.Logger = zerolog.New(logsWriter).With().Timestamp().Logger()
+	logger := zerolog.New(logsWriter).With().Timestamp().Logger()
 
-	return func() {
-		log.Info().Msg("Closing logging writers")
+	return logger, func() {
+		logger.Info().Msg("Closing logging writers")
 		for _, w := range writeClosers {
 			err := w.Close()
 			if err != nil {
-				log.Error().Err(err).Msg("unable to close writer")
+				logger.Error().Err(err).Msg("unable to close writer")
 			}
 		}
 	}, nil
 }
 
+// InitLogging add more writers to zerolog log object. This way the logging can be sent to
+// many targets. For the moment just STDOUT and Sentry are configured.
+func InitLogging(config *ConfigStruct) (func(), error) {
+	logger, closer, err := NewLogger(config)
+	if err != nil {
+		return closer, err
+	}
+
+	globalLoggerMutex.Lock()
+	defer globalLoggerMutex.Unlock()
+	log.Logger = logger
+
+	return closer, nil
+}
+
+// newRunID function generates random identifier of one exporter run. It is
+// added into all log messages produced by the run.
+func newRunID() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		// should not happen, but the run still needs some identifier
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+// newRunLogger function constructs logger used by one exporter run. All
+// messages contain run ID and the name of selected operation.
+func newRunLogger(logger zerolog.Logger, runID, operation string) zerolog.Logger {
+	return logger.With().
+		Str(runIDMsg, runID).
+		Str(operationMsg, operation).
+		Logger()
+}
+
 func setupSentryLogging(conf SentryConfiguration) (io.WriteCloser, error) {
 	sentryWriter, err := zlogsentry.New(conf.SentryDSN, zlogsentry.WithEnvironment(conf.SentryEnvironment))
 	if err != nil {
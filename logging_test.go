@@ -23,9 +23,12 @@ package main_test
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/logging_test.html
 
 import (
+	"bytes"
 	"testing"
 
 	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -50,3 +53,45 @@ func TestInitLoggingWithSentryDSN(t *testing.T) {
 	defer closer()
 	assert.NoError(t, err, "unexpected error initializing logging")
 }
+
+// TestNewLoggerDoesNotChangeGlobalLogger checks that logger constructed by
+// the function NewLogger is not set as global logger
+func TestNewLoggerDoesNotChangeGlobalLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	original := log.Logger
+	log.Logger = zerolog.New(&buffer)
+	defer func() {
+		log.Logger = original
+	}()
+
+	config, err := main.LoadConfiguration("", "tests/config1")
+	assert.NoError(t, err, "unexpected error loading configuration")
+
+	logger, closer, err := main.NewLogger(&config)
+	assert.NoError(t, err, "unexpected error constructing logger")
+	closer()
+
+	// global logger is still the same
+	log.Info().Msg("global logger")
+	assert.Contains(t, buffer.String(), "global logger")
+
+	// closer logs by constructed logger only
+	assert.NotContains(t, buffer.String(), "Closing logging writers")
+	assert.NotEqual(t, zerolog.Disabled, logger.GetLevel())
+}
+
+// TestNewRunLogger checks that all messages logged by run logger contain run
+// ID and operation
+func TestNewRunLogger(t *testing.T) {
+	var buffer bytes.Buffer
+
+	runID := main.NewRunID()
+	assert.Len(t, runID, 16)
+	assert.NotEqual(t, runID, main.NewRunID())
+
+	logger := main.NewRunLogger(zerolog.New(&buffer), runID, "export")
+	logger.Info().Msg("message")
+
+	assert.Contains(t, buffer.String(), `"run ID":"`+runID+`"`)
+	assert.Contains(t, buffer.String(), `"operation":"export"`)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains manifest of the whole run. Manifest is stored as
+// _manifest.json next to exported data and it describes the run (its ID,
+// exporter version, start and duration), database the data have been read
+// from, number of rows exported from each table and all exported objects
+// with their sizes and checksums. Consumers can check that all data have
+// been delivered without listing the bucket or the directory.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/manifest.html
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// exporterVersion is version of exporter recorded in manifest
+const exporterVersion = "1.0"
+
+// messages
+const (
+	storeRunManifestFailed = "Storing manifest of the run failed"
+)
+
+// ManifestDatabase identifies database the data have been exported from, no
+// credentials are part of the identity
+type ManifestDatabase struct {
+	Driver string `json:"driver"`
+	Host   string `json:"host,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// manifestDatabase function returns identity of database selected in
+// configuration
+func manifestDatabase(configuration StorageConfiguration) *ManifestDatabase {
+	if configuration.Driver == "sqlite3" {
+		return &ManifestDatabase{
+			Driver: configuration.Driver,
+			Name:   configuration.SQLiteDataSource,
+		}
+	}
+
+	return &ManifestDatabase{
+		Driver: configuration.Driver,
+		Host:   configuration.PGHost,
+		Port:   configuration.PGPort,
+		Name:   configuration.PGDBName,
+	}
+}
+
+// manifestLocation function returns location of artifact as it is listed in
+// manifest. Files are listed relatively to output directory, so the manifest
+// stays valid when the directory is moved or bundled.
+func manifestLocation(directory, location string) string {
+	if directory == "" || strings.HasPrefix(location, "s3://") {
+		return location
+	}
+
+	relative, err := filepath.Rel(directory, location)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return location
+	}
+	return filepath.ToSlash(relative)
+}
+
+// NewRunManifest function constructs manifest of the finished run from its
+// summary and from artifacts produced by the run
+func NewRunManifest(configuration *ConfigStruct, cliFlags CliFlags, runID string,
+	summary *Summary, artifacts []Artifact) Manifest {
+	manifest := NewManifest()
+
+	started := summary.Started()
+	finished := summary.Finished()
+
+	manifest.RunID = runID
+	manifest.Version = exporterVersion
+	manifest.Started = &started
+	manifest.Finished = &finished
+	manifest.DurationSeconds = summary.TotalDuration().Seconds()
+	manifest.Database = manifestDatabase(GetStorageConfiguration(configuration))
+	manifest.Rows = summary.ExportedRows()
+	manifest.ExitStatus = summary.ExitStatus()
+
+	for _, table := range summary.Tables() {
+		rows := table.Rows
+		manifest.Tables[table.Name] = ManifestEntry{Rows: &rows}
+	}
+
+	manifest.Objects = make([]Artifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		artifact.Location = manifestLocation(cliFlags.OutputDirectory, artifact.Location)
+		manifest.Objects = append(manifest.Objects, artifact)
+	}
+
+	return manifest
+}
+
+// mergeChangeDetection function adds objects and hashes of tables recorded
+// for change detection into manifest of the run, so the next run can still
+// skip unchanged tables
+func mergeChangeDetection(manifest, changes Manifest) {
+	for tableName, change := range changes.Tables {
+		entry := manifest.Tables[tableName]
+		entry.Object = change.Object
+		entry.SHA256 = change.SHA256
+		entry.Audit = change.Audit
+		manifest.Tables[tableName] = entry
+	}
+}
+
+// storeRunManifest function stores manifest of the run into the output the
+// data have been exported into. Manifest is never compressed, but it is
+// encrypted as other artifacts. When unchanged
+// tables are detected in S3, manifest stored by the export is extended, so
+// change detection keeps working.
+func storeRunManifest(configuration *ConfigStruct, cliFlags CliFlags, runID string,
+	summary *Summary, artifacts []Artifact) (int, error) {
+	manifest := NewRunManifest(configuration, cliFlags, runID, summary, artifacts)
+
+	if exportOutput(cliFlags) == s3Output && GetExportConfiguration(configuration).SkipUnchanged {
+		session, err := OpenS3Session(configuration)
+		if err != nil {
+			return ExitStatusS3Error, err
+		}
+
+		changes, err := readManifestFromS3(session.Context(), session,
+			session.Bucket(), session.ObjectName(manifestObject))
+		if err != nil {
+			return ExitStatusS3Error, err
+		}
+		mergeChangeDetection(manifest, changes)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	sinks, err := artifactSinks(configuration, cliFlags, noCompression)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	return storeObjectIntoSinks(sinks, manifestObject,
+		ObjectMeta{ContentType: manifestContentType}, data)
+}
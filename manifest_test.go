@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/manifest_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestManifestLocation checks that files are listed relatively to output
+// directory
+func TestManifestLocation(t *testing.T) {
+	assert.Equal(t, "report.csv", main.ManifestLocation("/tmp/out", "/tmp/out/report.csv"))
+	assert.Equal(t, "a/report.csv", main.ManifestLocation("/tmp/out", "/tmp/out/a/report.csv"))
+	assert.Equal(t, "/tmp/other.csv", main.ManifestLocation("/tmp/out", "/tmp/other.csv"))
+	assert.Equal(t, "s3://bucket/report.csv", main.ManifestLocation("/tmp/out", "s3://bucket/report.csv"))
+	assert.Equal(t, "report.csv", main.ManifestLocation("", "report.csv"))
+}
+
+// TestStoreRunManifest checks that manifest describes the run, exported
+// tables and all exported files
+func TestStoreRunManifest(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		"INSERT INTO report VALUES (1), (2), (3)")
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			Compression: "gzip",
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+
+	artifacts := main.NewArtifactLog()
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, artifacts, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	summary.SetExitStatus(code, err)
+	summary.Finish()
+
+	code, err = main.StoreRunManifest(&configuration, cliFlags, "0123456789abcdef",
+		summary, artifacts.Artifacts())
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// manifest is not compressed regardless of configuration
+	fin, err := os.Open(filepath.Join(directory, "_manifest.json"))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, fin.Close())
+	}()
+
+	manifest, err := main.ReadManifest(fin)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "0123456789abcdef", manifest.RunID)
+	assert.Equal(t, "1.0", manifest.Version)
+	assert.NotNil(t, manifest.Started)
+	assert.NotNil(t, manifest.Finished)
+	assert.Equal(t, &main.ManifestDatabase{Driver: "sqlite3", Name: dataSource}, manifest.Database)
+	assert.Equal(t, 3, manifest.Rows)
+	assert.Equal(t, &main.RunExitStatus{Code: 0, Name: "ok"}, manifest.ExitStatus)
+
+	assert.Contains(t, manifest.Tables, main.TableName("report"))
+	assert.Equal(t, 3, *manifest.Tables["report"].Rows)
+
+	var report *main.Artifact
+	for i := range manifest.Objects {
+		if manifest.Objects[i].Location == "report.csv.gz" {
+			report = &manifest.Objects[i]
+		}
+	}
+	if assert.NotNil(t, report) {
+		info, err := os.Stat(filepath.Join(directory, "report.csv.gz"))
+		assert.NoError(t, err)
+		assert.Equal(t, info.Size(), report.Size)
+		assert.Len(t, report.SHA256, 64)
+	}
+}
@@ -0,0 +1,425 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains masking (anonymization) of exported columns.
+// Masking is configured per table and column in [masking] section. Columns
+// can be dropped from export, their values can be replaced by hash, by keyed
+// hash (pseudonym), by constant or by fake value of the same type. Values are
+// masked on client side before rows are written into output, so masking does
+// not depend on database.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/masking.html
+
+import (
+	"bytes"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// masking methods
+const (
+	maskDrop      = "drop"
+	maskHash      = "hash"
+	maskPseudonym = "pseudonym"
+	maskFake      = "fake"
+	maskConstant  = "constant"
+)
+
+// messages
+const (
+	unknownMaskMethod = "unknown masking method %q, drop, hash, pseudonym, fake or constant:<value> can be used"
+	unknownMaskColumn = "Masking configured for unknown column %s in table %s"
+	emptySaltFile     = "pseudonym salt file %s is empty"
+	wrongMaskConstant = "constant %q of column %s in table %s can't be stored in column of type %s"
+)
+
+// pseudonymKeySize is size of random key pseudonyms are computed with when
+// no salt file is configured
+const pseudonymKeySize = 32
+
+// uuidValue is regular expression matching textual form of UUID, pseudonyms
+// of UUIDs are UUIDs too
+var uuidValue = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// random key used by all exports performed by this process, so pseudonyms
+// are the same in all tables and artifact sets of one run
+var (
+	runPseudonymKeyOnce sync.Once
+	runPseudonymKey     []byte
+	runPseudonymKeyErr  error
+)
+
+// fakeCharacters contains characters fake strings are composed of
+const fakeCharacters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// columnMask represents masking method configured for one column, value is
+// used by constant masking only and key by pseudonymization only
+type columnMask struct {
+	method string
+	value  string
+	key    []byte
+}
+
+// parseColumnMask function parses masking method configured for column.
+// Constant is specified as "constant:<value>".
+func parseColumnMask(spec string) (columnMask, error) {
+	method, value, hasValue := strings.Cut(spec, ":")
+
+	switch {
+	case method == maskConstant && hasValue:
+		return columnMask{method: maskConstant, value: value}, nil
+	case !hasValue && (method == maskDrop || method == maskHash ||
+		method == maskPseudonym || method == maskFake):
+		return columnMask{method: method}, nil
+	}
+
+	return columnMask{}, fmt.Errorf(unknownMaskMethod, spec)
+}
+
+// tableMasks method parses masking methods configured for columns of given
+// table. Nil is returned when no column of the table is masked.
+func (storage DBStorage) tableMasks(tableName TableName) (map[string]columnMask, error) {
+	configured, _ := lookupTableConfig(storage.masking, tableName)
+	if len(configured) == 0 {
+		return nil, nil
+	}
+
+	masks := make(map[string]columnMask, len(configured))
+	for column, spec := range configured {
+		mask, err := parseColumnMask(spec)
+		if err != nil {
+			return nil, err
+		}
+		mask.key = storage.pseudonymKey
+		masks[column] = mask
+	}
+
+	return masks, nil
+}
+
+// dropMaskedColumns method removes columns that are dropped by masking from
+// column types of given table. Masking configured for column that does not
+// exist in the table is reported as error.
+func (storage DBStorage) dropMaskedColumns(tableName TableName,
+	columnTypes []*sql.ColumnType) ([]*sql.ColumnType, error) {
+	masks, err := storage.tableMasks(tableName)
+	if err != nil || masks == nil {
+		return columnTypes, err
+	}
+
+	known := make(map[string]bool, len(columnTypes))
+	kept := make([]*sql.ColumnType, 0, len(columnTypes))
+
+	for _, columnType := range columnTypes {
+		known[columnType.Name()] = true
+		mask := masks[columnType.Name()]
+		if mask.method == maskConstant {
+			// constant is checked once, not for every masked row
+			databaseType := columnType.DatabaseTypeName()
+			if _, err := typedConstant(mask.value, databaseType); err != nil {
+				return nil, fmt.Errorf(wrongMaskConstant, mask.value,
+					columnType.Name(), tableName, databaseType)
+			}
+		}
+		if mask.method != maskDrop {
+			kept = append(kept, columnType)
+		}
+	}
+
+	// report the first unknown column in stable order
+	maskedColumns := make([]string, 0, len(masks))
+	for column := range masks {
+		maskedColumns = append(maskedColumns, column)
+	}
+	sort.Strings(maskedColumns)
+
+	for _, column := range maskedColumns {
+		if !known[column] {
+			return nil, fmt.Errorf(unknownMaskColumn, column, tableName)
+		}
+	}
+
+	return kept, nil
+}
+
+// maskRow function returns copy of given row with masked values. The row
+// itself is not changed, so the original values can still be used (for
+// watermarks etc.). NULL values are not masked.
+func maskRow(masks map[string]columnMask, row M) M {
+	masked := make(M, len(row))
+	for column, value := range row {
+		mask, found := masks[column]
+		if !found || value == nil {
+			masked[column] = value
+			continue
+		}
+
+		switch mask.method {
+		case maskDrop:
+			// column is not exported at all
+		case maskHash:
+			masked[column] = hashValue(value)
+		case maskPseudonym:
+			masked[column] = pseudonymValue(mask.key, value)
+		case maskFake:
+			masked[column] = fakeValue(value)
+		case maskConstant:
+			masked[column] = constantValue(mask.value, value)
+		}
+	}
+	return masked
+}
+
+// maskRejectedRow function masks row that is quarantined, so values of
+// masked columns are never written into rejects in plain text. Columns
+// dropped by masking are removed from returned column names.
+func maskRejectedRow(masks map[string]columnMask, colNames []string, row M) ([]string, M) {
+	if masks == nil {
+		return colNames, row
+	}
+
+	kept := make([]string, 0, len(colNames))
+	for _, colName := range colNames {
+		if masks[colName].method != maskDrop {
+			kept = append(kept, colName)
+		}
+	}
+	return kept, maskRow(masks, row)
+}
+
+// typedConstant function converts constant used for masking into value of
+// type scanned from column of given database type. Constants of other types
+// are used as strings.
+func typedConstant(constant, databaseType string) (interface{}, error) {
+	switch databaseType {
+	case "BOOL":
+		return strconv.ParseBool(constant)
+	case "INT2", "INT4":
+		value, err := strconv.ParseInt(constant, 10, 32)
+		return int32(value), err
+	case "INT8":
+		return strconv.ParseInt(constant, 10, 64)
+	case "FLOAT4", "FLOAT8":
+		return strconv.ParseFloat(constant, 64)
+	default:
+		return constant, nil
+	}
+}
+
+// constantValue function returns constant that replaces given value. The
+// constant keeps type of the value, so it can be written into typed formats
+// (Avro, Parquet) as well.
+func constantValue(constant string, value interface{}) interface{} {
+	var (
+		typed interface{}
+		err   error
+	)
+
+	switch value.(type) {
+	case bool:
+		typed, err = typedConstant(constant, "BOOL")
+	case int32:
+		typed, err = typedConstant(constant, "INT4")
+	case int64:
+		typed, err = typedConstant(constant, "INT8")
+	case float64:
+		typed, err = typedConstant(constant, "FLOAT8")
+	default:
+		return constant
+	}
+
+	// constants are checked against column types before the export
+	if err != nil {
+		return constant
+	}
+	return typed
+}
+
+// hashValue function replaces value by its SHA-256 hash. The same values
+// are always replaced by the same hash, so masked columns can still be
+// joined. The hash is not keyed, so values from small domains (organization
+// IDs etc.) can be recovered by hashing of all possible values, pseudonymValue
+// should be used for them.
+func hashValue(value interface{}) interface{} {
+	digest := sha256.Sum256([]byte(canonicalValue(value)))
+	return digestValue(digest[:], value)
+}
+
+// pseudonymValue function replaces value by its keyed hash (HMAC-SHA256).
+// The same values are replaced by the same pseudonym as long as the same key
+// is used, but pseudonyms can't be reversed by hashing of guessed values.
+func pseudonymValue(key []byte, value interface{}) interface{} {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(canonicalValue(value)))
+	return digestValue(mac.Sum(nil), value)
+}
+
+// canonicalValue function returns textual form of value the digest is
+// computed from. The form does not depend on type the value was scanned
+// into, so for example the same organization ID stored in INT4 and INT8
+// columns (or in text column) has the same digest.
+func canonicalValue(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+
+	return csvValue(value)
+}
+
+// digestValue function converts digest of value into value written instead
+// of it. Integers of all types are replaced by the same non-negative 63 bit
+// integer derived from the digest (integers stored as text by its decimal
+// form) and UUIDs by UUIDs, so they keep their type. Other values are
+// replaced by hex encoded digest.
+func digestValue(digest []byte, value interface{}) interface{} {
+	number := int64(binary.BigEndian.Uint64(digest[:8]) & math.MaxInt64)
+
+	switch v := value.(type) {
+	case int64, int32, int:
+		return number
+	case string:
+		if uuidValue.MatchString(v) {
+			encoded := hex.EncodeToString(digest[:16])
+			return strings.Join([]string{encoded[:8], encoded[8:12],
+				encoded[12:16], encoded[16:20], encoded[20:32]}, "-")
+		}
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil &&
+			strconv.FormatInt(parsed, 10) == v {
+			return strconv.FormatInt(number, 10)
+		}
+	}
+
+	return hex.EncodeToString(digest)
+}
+
+// digestedColumnType function returns database type of column whose values
+// are masked by given mask. Hashes and pseudonyms of integers don't fit into
+// INT2 and INT4 columns, so such columns are written as INT8 ones.
+func digestedColumnType(mask columnMask, databaseType string) string {
+	if (mask.method == maskHash || mask.method == maskPseudonym) &&
+		(databaseType == "INT2" || databaseType == "INT4") {
+		return "INT8"
+	}
+	return databaseType
+}
+
+// loadPseudonymKey function returns key pseudonyms are computed with. Salt
+// read from given file is used as key, so pseudonyms are the same across
+// runs. Otherwise random key generated for this run is used.
+func loadPseudonymKey(saltFile string) ([]byte, error) {
+	if saltFile == "" {
+		runPseudonymKeyOnce.Do(func() {
+			runPseudonymKey = make([]byte, pseudonymKeySize)
+			_, runPseudonymKeyErr = cryptorand.Read(runPseudonymKey)
+		})
+		return runPseudonymKey, runPseudonymKeyErr
+	}
+
+	salt, err := os.ReadFile(saltFile)
+	if err != nil {
+		return nil, err
+	}
+
+	salt = bytes.TrimSpace(salt)
+	if len(salt) == 0 {
+		return nil, fmt.Errorf(emptySaltFile, saltFile)
+	}
+
+	return salt, nil
+}
+
+// fakeValue function replaces value by random value of the same type.
+// Numbers keep their number of digits and strings their length.
+func fakeValue(value interface{}) interface{} {
+	// fake values do not need to be cryptographically secure
+	// #nosec G404
+	switch v := value.(type) {
+	case int64:
+		return rand.Int63n(fakeUpperBound(int64(math.Abs(float64(v)))))
+	case int32:
+		return int32(rand.Int63n(fakeUpperBound(int64(math.Abs(float64(v))))) % math.MaxInt32)
+	case float64:
+		return rand.Float64() * math.Abs(v)
+	case bool:
+		return rand.Intn(2) == 1
+	case time.Time:
+		return time.Unix(rand.Int63n(time.Now().Unix()), 0).UTC()
+	case []byte:
+		fake := make([]byte, len(v))
+		for i := range fake {
+			fake[i] = byte(rand.Intn(256))
+		}
+		return fake
+	case string:
+		return fakeString(utf8.RuneCountInString(v))
+	}
+
+	return fakeString(utf8.RuneCountInString(csvValue(value)))
+}
+
+// fakeUpperBound function returns the lowest power of ten greater than given
+// non-negative number
+func fakeUpperBound(number int64) int64 {
+	bound := int64(10)
+	for bound <= number && bound < math.MaxInt64/10 {
+		bound *= 10
+	}
+	return bound
+}
+
+// fakeString function returns random string of given length
+func fakeString(length int) string {
+	var builder strings.Builder
+	builder.Grow(length)
+
+	for i := 0; i < length; i++ {
+		// #nosec G404
+		builder.WriteByte(fakeCharacters[rand.Intn(len(fakeCharacters))])
+	}
+	return builder.String()
+}
@@ -0,0 +1,430 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/masking_test.html
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestCheckColumnMask checks parsing of masking methods
+func TestCheckColumnMask(t *testing.T) {
+	for _, spec := range []string{"drop", "hash", "pseudonym", "fake", "constant:", "constant:a:b"} {
+		assert.NoError(t, main.CheckColumnMask(spec))
+	}
+
+	for _, spec := range []string{"", "constant", "hash:x", "DROP", "shuffle"} {
+		assert.Error(t, main.CheckColumnMask(spec), spec)
+	}
+}
+
+// TestHashValue checks that hashed values are stable and integers keep
+// their type
+func TestHashValue(t *testing.T) {
+	assert.Equal(t, main.HashValue("cluster"), main.HashValue("cluster"))
+	assert.NotEqual(t, main.HashValue("cluster1"), main.HashValue("cluster2"))
+	assert.Len(t, main.HashValue("cluster"), 64)
+
+	assert.IsType(t, int64(0), main.HashValue(int64(42)))
+	assert.IsType(t, int64(0), main.HashValue(int32(42)))
+	assert.Equal(t, main.HashValue(int64(42)), main.HashValue(int64(42)))
+	assert.GreaterOrEqual(t, main.HashValue(int64(42)).(int64), int64(0))
+}
+
+// TestHashValueCanonical checks that hash depends on value only, not on type
+// it was scanned into
+func TestHashValueCanonical(t *testing.T) {
+	hash := main.HashValue(int64(42))
+	assert.Equal(t, hash, main.HashValue(int32(42)))
+	assert.Equal(t, hash, main.HashValue(42))
+
+	// integers stored as text are replaced by the same integer
+	assert.Equal(t, fmt.Sprint(hash), main.HashValue("42"))
+	assert.Len(t, main.HashValue("042"), 64)
+
+	assert.Equal(t, main.HashValue("cluster"), main.HashValue([]byte("cluster")))
+
+	gatheredAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, main.HashValue(gatheredAt),
+		main.HashValue(gatheredAt.In(time.FixedZone("CET", 3600))))
+
+	// all 63 bits of the digest are used for 32 bit integers as well
+	key := []byte("key")
+	large := false
+	for i := int32(0); i < 100; i++ {
+		pseudonym := main.PseudonymValue(key, i)
+		assert.Equal(t, main.PseudonymValue(key, int64(i)), pseudonym)
+		if pseudonym.(int64) > math.MaxInt32 {
+			large = true
+		}
+	}
+	assert.True(t, large)
+}
+
+// TestPseudonymValue checks that pseudonyms depend on key and keep type of
+// integers and UUIDs
+func TestPseudonymValue(t *testing.T) {
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+
+	assert.Equal(t, main.PseudonymValue(key1, "cluster"), main.PseudonymValue(key1, "cluster"))
+	assert.NotEqual(t, main.PseudonymValue(key1, "cluster"), main.PseudonymValue(key2, "cluster"))
+	assert.NotEqual(t, main.HashValue("cluster"), main.PseudonymValue(key1, "cluster"))
+
+	assert.IsType(t, int64(0), main.PseudonymValue(key1, int64(1000)))
+	assert.Equal(t, main.PseudonymValue(key1, int64(1000)), main.PseudonymValue(key1, int64(1000)))
+
+	pseudonym := main.PseudonymValue(key1, "5d5892d3-1f74-4ccf-91af-548dfc9767aa")
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$", pseudonym)
+	assert.NotEqual(t, "5d5892d3-1f74-4ccf-91af-548dfc9767aa", pseudonym)
+}
+
+// TestLoadPseudonymKey checks that salt is read from file and random key is
+// shared by the whole run otherwise
+func TestLoadPseudonymKey(t *testing.T) {
+	first, err := main.LoadPseudonymKey("")
+	assert.NoError(t, err)
+	assert.Len(t, first, 32)
+
+	second, err := main.LoadPseudonymKey("")
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	saltFile := filepath.Join(t.TempDir(), "salt")
+	assert.NoError(t, os.WriteFile(saltFile, []byte("secret salt\n"), 0o600))
+
+	salt, err := main.LoadPseudonymKey(saltFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret salt"), salt)
+
+	assert.NoError(t, os.WriteFile(saltFile, []byte(" \n"), 0o600))
+	_, err = main.LoadPseudonymKey(saltFile)
+	assert.EqualError(t, err, "pseudonym salt file "+saltFile+" is empty")
+
+	_, err = main.LoadPseudonymKey(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+// TestPerformDataExportSQLitePseudonyms checks that the same values are
+// replaced by the same pseudonyms in all tables and in all runs with the
+// same salt
+func TestPerformDataExportSQLitePseudonyms(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (cluster VARCHAR)",
+		"CREATE TABLE rule_hit (cluster_id VARCHAR)",
+		"INSERT INTO report VALUES ('cluster-a')",
+		"INSERT INTO rule_hit VALUES ('cluster-a')")
+
+	saltFile := filepath.Join(t.TempDir(), "salt")
+	assert.NoError(t, os.WriteFile(saltFile, []byte("salt"), 0o600))
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			PseudonymSaltFile: saltFile,
+		},
+		Masking: main.MaskingConfiguration{
+			"report":   {"cluster": "pseudonym"},
+			"rule_hit": {"cluster_id": "pseudonym"},
+		},
+	}
+
+	// export returns content of both tables
+	export := func() (string, string) {
+		directory := t.TempDir()
+		cliFlags := main.CliFlags{
+			Output:          "file",
+			Format:          "csv",
+			OutputDirectory: directory,
+			Limit:           NoLimits,
+		}
+
+		code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+			&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+
+		report, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+		assert.NoError(t, err)
+		ruleHit, err := os.ReadFile(filepath.Join(directory, "rule_hit.csv"))
+		assert.NoError(t, err)
+
+		return strings.TrimPrefix(string(report), "cluster\n"),
+			strings.TrimPrefix(string(ruleHit), "cluster_id\n")
+	}
+
+	report, ruleHit := export()
+	assert.NotContains(t, report, "cluster-a")
+	assert.Equal(t, report, ruleHit)
+
+	// the same salt gives the same pseudonyms
+	nextReport, _ := export()
+	assert.Equal(t, report, nextReport)
+}
+
+// TestFakeValue checks that fake values keep type and size of original
+// values
+func TestFakeValue(t *testing.T) {
+	fakeInt := main.FakeValue(int64(12345))
+	assert.IsType(t, int64(0), fakeInt)
+	assert.GreaterOrEqual(t, fakeInt.(int64), int64(0))
+	assert.Less(t, fakeInt.(int64), int64(100000))
+
+	fakeInt32 := main.FakeValue(int32(-7))
+	assert.IsType(t, int32(0), fakeInt32)
+	assert.Less(t, fakeInt32.(int32), int32(10))
+
+	assert.Len(t, main.FakeValue("my-cluster"), len("my-cluster"))
+	assert.Len(t, main.FakeValue("příliš"), 6)
+	assert.Len(t, main.FakeValue([]byte{1, 2, 3}), 3)
+	assert.IsType(t, true, main.FakeValue(false))
+	assert.IsType(t, time.Time{}, main.FakeValue(time.Now()))
+	assert.IsType(t, float64(0), main.FakeValue(1.5))
+}
+
+// TestPerformDataExportSQLiteMasking checks that masked columns are
+// anonymized in exported file
+func TestPerformDataExportSQLiteMasking(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER PRIMARY KEY,
+		org_id INTEGER, cluster VARCHAR, account VARCHAR, note VARCHAR, rule VARCHAR)`,
+		`INSERT INTO report VALUES
+		(1, 1000, 'cluster-a', 'acc1', 'secret', 'rule1'),
+		(2, 1000, 'cluster-b', 'acc2', NULL, 'rule2')`)
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {
+				"org_id":  "hash",
+				"cluster": "fake",
+				"account": "drop",
+				"note":    "constant:redacted",
+			},
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	fin, err := os.Open(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, fin.Close())
+	}()
+
+	records, err := csv.NewReader(fin).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+
+	// dropped column is not exported at all
+	assert.Equal(t, []string{"id", "org_id", "cluster", "note", "rule"}, records[0])
+
+	// hashed values are the same for the same original values
+	assert.NotEqual(t, "1000", records[1][1])
+	assert.Equal(t, records[1][1], records[2][1])
+
+	// fake values keep length of original values
+	assert.NotEqual(t, "cluster-a", records[1][2])
+	assert.Len(t, records[1][2], len("cluster-a"))
+
+	// NULL is not masked
+	assert.Equal(t, "redacted", records[1][3])
+	assert.Equal(t, "", records[2][3])
+
+	// columns without masking are exported as they are
+	assert.Equal(t, []string{"1", "2"}, []string{records[1][0], records[2][0]})
+	assert.Equal(t, []string{"rule1", "rule2"}, []string{records[1][4], records[2][4]})
+}
+
+// TestRetrieveColumnTypesUnknownMaskedColumn checks that masking configured
+// for column that does not exist is reported
+func TestRetrieveColumnTypesUnknownMaskedColumn(t *testing.T) {
+	connection, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+
+	_, err = connection.Exec("CREATE TABLE report (id INTEGER PRIMARY KEY)")
+	assert.NoError(t, err)
+
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"org_id": "drop"},
+	})
+
+	_, err = storage.RetrieveColumnTypes(context.Background(), "report")
+	assert.EqualError(t, err, "Masking configured for unknown column org_id in table report")
+
+	assert.NoError(t, storage.Close())
+}
+
+// TestConstantValue checks that constants used for masking keep type of
+// masked values
+func TestConstantValue(t *testing.T) {
+	assert.Equal(t, int64(0), main.ConstantValue("0", int64(42)))
+	assert.Equal(t, int32(-1), main.ConstantValue("-1", int32(42)))
+	assert.Equal(t, 1.5, main.ConstantValue("1.5", 42.0))
+	assert.Equal(t, false, main.ConstantValue("false", true))
+	assert.Equal(t, "***", main.ConstantValue("***", "cluster"))
+
+	_, err := main.TypedConstant("***", "INT8")
+	assert.Error(t, err)
+	_, err = main.TypedConstant("4294967296", "INT4")
+	assert.Error(t, err)
+	_, err = main.TypedConstant("***", "VARCHAR")
+	assert.NoError(t, err)
+}
+
+// TestRetrieveColumnTypesWrongMaskConstant checks that constant that can't
+// be stored in masked column is reported before the table is exported
+func TestRetrieveColumnTypesWrongMaskConstant(t *testing.T) {
+	connection, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+
+	_, err = connection.Exec("CREATE TABLE report (id INTEGER PRIMARY KEY, org_id INT8)")
+	assert.NoError(t, err)
+
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"org_id": "constant:***"},
+	})
+
+	_, err = storage.RetrieveColumnTypes(context.Background(), "report")
+	assert.EqualError(t, err,
+		`constant "***" of column org_id in table report can't be stored in column of type INT8`)
+
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"org_id": "constant:0"},
+	})
+	_, err = storage.RetrieveColumnTypes(context.Background(), "report")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.Close())
+}
+
+// TestPerformDataExportSQLiteMaskingAvroConstant checks that integer column
+// masked by constant can be exported into Avro without rejected rows
+func TestPerformDataExportSQLiteMaskingAvroConstant(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER PRIMARY KEY, org_id INT8)`,
+		`INSERT INTO report VALUES (1, 1000), (2, 2000)`)
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			Quarantine: true,
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {"org_id": "constant:0"},
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "avro",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+	summary := main.NewSummary()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, 2, summary.ExportedRows())
+	assert.Equal(t, 0, summary.RejectedRows())
+	assert.NoFileExists(t, filepath.Join(directory, "report_rejects.csv"))
+}
+
+// TestPerformDataExportSQLiteMaskingAvroPseudonym checks that INT4 column
+// masked by pseudonym is exported into Avro as long, so pseudonyms are not
+// truncated nor rejected
+func TestPerformDataExportSQLiteMaskingAvroPseudonym(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER PRIMARY KEY, org_id INT4)`,
+		`INSERT INTO report VALUES (1, 1000), (2, 2000)`)
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			Quarantine: true,
+		},
+		Masking: main.MaskingConfiguration{
+			"report": {"org_id": "pseudonym"},
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "avro",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+	summary := main.NewSummary()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, 2, summary.ExportedRows())
+	assert.Equal(t, 0, summary.RejectedRows())
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.avro"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `{"name":"org_id","type":["null","long"],"default":null}`)
+}
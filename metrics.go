@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions to write metrics about data export
+// into file in Prometheus text format. Such file can be read by textfile
+// collector that is part of node_exporter.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/metrics.html
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+)
+
+// prefix used for all metric names
+const metricsPrefix = "insights_results_aggregator_exporter_"
+
+// metric is representation of one metric without labels
+type metric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// writeMetricHeader function writes HELP and TYPE lines for given metric
+func writeMetricHeader(writer io.Writer, name, help string) error {
+	_, err := fmt.Fprintf(writer, "# HELP %s%s %s\n# TYPE %s%s gauge\n",
+		metricsPrefix, name, help, metricsPrefix, name)
+	return err
+}
+
+// writeMetrics function writes metrics about one export run in Prometheus
+// text format into given writer, counters and timers reported by storage
+// and S3 layers follow when registry is given
+func writeMetrics(writer io.Writer, summary *Summary, exitStatus int,
+	registry *PrometheusMetrics) error {
+	// metrics without labels
+	metrics := []metric{
+		{"last_run_timestamp_seconds", "Time when the last export finished.",
+			float64(summary.Finished().Unix())},
+		{"last_run_duration_seconds", "Duration of the last export.",
+			summary.TotalDuration().Seconds()},
+		{"last_run_exit_status", "Exit status of the last export.",
+			float64(exitStatus)},
+		{"last_run_exported_tables", "Number of tables exported by the last export.",
+			float64(summary.ExportedTables())},
+		{"last_run_exported_rows", "Number of rows exported by the last export.",
+			float64(summary.ExportedRows())},
+		{"last_run_rejected_rows", "Number of rows rejected by the last export.",
+			float64(summary.RejectedRows())},
+	}
+
+	for _, m := range metrics {
+		err := writeMetricHeader(writer, m.name, m.help)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "%s%s %g\n", metricsPrefix, m.name, m.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// durations of individual stages are labeled by stage name
+	const stageMetric = "last_run_stage_duration_seconds"
+	err := writeMetricHeader(writer, stageMetric, "Time spent in individual stages of the last export.")
+	if err != nil {
+		return err
+	}
+
+	for _, stage := range stages {
+		_, err := fmt.Fprintf(writer, "%s%s{stage=%q} %g\n",
+			metricsPrefix, stageMetric, stage, summary.Duration(stage).Seconds())
+		if err != nil {
+			return err
+		}
+	}
+
+	if registry == nil {
+		return nil
+	}
+	return registry.Write(writer)
+}
+
+// writeMetricsFile function writes metrics into given file. Metrics are
+// written into temporary file first and then the file is renamed, so the
+// collector never reads partially written file.
+func writeMetricsFile(filename string, summary *Summary, exitStatus int,
+	registry *PrometheusMetrics) error {
+	var buffer bytes.Buffer
+
+	err := writeMetrics(&buffer, summary, exitStatus, registry)
+	if err != nil {
+		return err
+	}
+
+	// textfile collector needs to be able to read the file
+	err = writeFileAtomically(filename, buffer.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str(filenameAttribute, filename).Msg("Metrics written")
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/metrics_test.html
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareSummary is helper function to construct summary with some data
+func prepareSummary() *main.Summary {
+	summary := main.NewSummary()
+	summary.AddDuration(main.StageDataRead, 1500*time.Millisecond)
+	summary.AddExportedRows(42)
+	summary.AddRejectedRows(3)
+	summary.AddExportedTable()
+	summary.Finish()
+	return summary
+}
+
+// TestWriteMetrics checks the function writeMetrics
+func TestWriteMetrics(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.WriteMetrics(buffer, prepareSummary(), main.ExitStatusStorageError, nil)
+	assert.NoError(t, err)
+
+	output := buffer.String()
+	for _, expected := range []string{
+		"# TYPE insights_results_aggregator_exporter_last_run_duration_seconds gauge",
+		"insights_results_aggregator_exporter_last_run_exit_status 2\n",
+		"insights_results_aggregator_exporter_last_run_exported_tables 1\n",
+		"insights_results_aggregator_exporter_last_run_exported_rows 42\n",
+		"insights_results_aggregator_exporter_last_run_rejected_rows 3\n",
+		`insights_results_aggregator_exporter_last_run_stage_duration_seconds{stage="data read"} 1.5`,
+		`insights_results_aggregator_exporter_last_run_stage_duration_seconds{stage="upload"} 0`,
+		"insights_results_aggregator_exporter_last_run_timestamp_seconds ",
+	} {
+		assert.Contains(t, output, expected)
+	}
+}
+
+// TestWriteMetricsFile checks that metrics file is written and no temporary
+// file is left behind
+func TestWriteMetricsFile(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "exporter.prom")
+
+	err := main.WriteMetricsFile(filename, prepareSummary(), main.ExitStatusOK, nil)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "insights_results_aggregator_exporter_last_run_exit_status 0\n")
+
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// TestWriteMetricsFileWrongDirectory checks that error is returned when the
+// metrics file can not be written
+func TestWriteMetricsFileWrongDirectory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "nonexisting", "exporter.prom")
+
+	err := main.WriteMetricsFile(filename, prepareSummary(), main.ExitStatusOK, nil)
+	assert.Error(t, err)
+}
+
+// TestDataExportSelected checks the function dataExportSelected
+func TestDataExportSelected(t *testing.T) {
+	assert.True(t, main.DataExportSelected(main.CliFlags{}))
+	assert.False(t, main.DataExportSelected(main.CliFlags{ShowVersion: true}))
+	assert.False(t, main.DataExportSelected(main.CliFlags{CheckPermissions: true}))
+}
@@ -0,0 +1,424 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains metrics registry shared by storage and S3
+// layers. Counters and timers are reported through Metrics interface that
+// is implemented by Prometheus backend (metrics are written into textfile
+// at the end of the run) and by StatsD backend (metrics are sent over UDP
+// as soon as they are reported), so every layer reports the same
+// instrumentation regardless of selected backend and output. All backends
+// are safe to be used from several goroutines.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/metricsregistry.html
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// names of metrics reported by storage and S3 layers
+const (
+	metricRowsExported    = "rows_exported_total"
+	metricRowsRejected    = "rows_rejected_total"
+	metricTableExport     = "table_export_seconds"
+	metricS3Requests      = "s3_requests_total"
+	metricS3Request       = "s3_request_seconds"
+	metricS3Retries       = "s3_retries_total"
+	metricS3UploadedBytes = "s3_uploaded_bytes_total"
+)
+
+// labels of reported values
+const (
+	metricLabelTable     = "table"
+	metricLabelOperation = "operation"
+	metricLabelResult    = "result"
+	metricResultSuccess  = "success"
+	metricResultError    = "error"
+)
+
+// StatsD protocol
+const (
+	statsdCounterType      = "c"
+	statsdTimerType        = "ms"
+	statsdNameSeparator    = "."
+	defaultStatsdNamespace = "insights_results_aggregator_exporter"
+)
+
+// metricHelps contains descriptions of metrics written in Prometheus
+// format
+var metricHelps = map[string]string{
+	metricRowsExported:    "Number of rows exported from table.",
+	metricRowsRejected:    "Number of rows rejected during export of table.",
+	metricTableExport:     "Time spent by reading and writing table.",
+	metricS3Requests:      "Number of S3 requests by operation and result.",
+	metricS3Request:       "Time spent by S3 requests.",
+	metricS3Retries:       "Number of retried S3 requests.",
+	metricS3UploadedBytes: "Number of bytes uploaded into S3.",
+}
+
+// statsdUnsafeCharacters matches characters that can't be used in names of
+// StatsD metrics
+var statsdUnsafeCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// MetricLabels contains labels of one reported value
+type MetricLabels map[string]string
+
+// Metrics is interface of metrics backends. Counters are increased by
+// given value, timers record one observed duration. Implementations must
+// be safe to be called from several goroutines.
+type Metrics interface {
+	AddCounter(name string, labels MetricLabels, value float64)
+	ObserveDuration(name string, labels MetricLabels, duration time.Duration)
+}
+
+// nopMetrics is used when no metrics backend is configured
+type nopMetrics struct{}
+
+// AddCounter method does nothing
+func (nopMetrics) AddCounter(string, MetricLabels, float64) {}
+
+// ObserveDuration method does nothing
+func (nopMetrics) ObserveDuration(string, MetricLabels, time.Duration) {}
+
+// multiMetrics reports all values into several backends
+type multiMetrics []Metrics
+
+// AddCounter method increases counter in all backends
+func (m multiMetrics) AddCounter(name string, labels MetricLabels, value float64) {
+	for _, metrics := range m {
+		metrics.AddCounter(name, labels, value)
+	}
+}
+
+// ObserveDuration method records duration in all backends
+func (m multiMetrics) ObserveDuration(name string, labels MetricLabels, duration time.Duration) {
+	for _, metrics := range m {
+		metrics.ObserveDuration(name, labels, duration)
+	}
+}
+
+// metricSeries is one counter or timer with given labels
+type metricSeries struct {
+	sum   float64
+	count int
+}
+
+// PrometheusMetrics collects counters and timers in memory, they are
+// written in Prometheus text format together with metrics about the run
+type PrometheusMetrics struct {
+	mutex    sync.Mutex
+	counters map[string]map[string]*metricSeries
+	timers   map[string]map[string]*metricSeries
+}
+
+// NewPrometheusMetrics function constructs empty Prometheus registry
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		counters: map[string]map[string]*metricSeries{},
+		timers:   map[string]map[string]*metricSeries{},
+	}
+}
+
+// sortedLabelNames function returns names of given labels in stable order
+func sortedLabelNames(labels MetricLabels) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prometheusLabels function formats given labels in Prometheus text format,
+// empty string is returned for no labels
+func prometheusLabels(labels MetricLabels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	formatted := make([]string, 0, len(labels))
+	for _, name := range sortedLabelNames(labels) {
+		formatted = append(formatted, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return "{" + strings.Join(formatted, ",") + "}"
+}
+
+// observe function adds given value into series with given labels
+func observe(series map[string]map[string]*metricSeries, name string,
+	labels MetricLabels, value float64) {
+	if series[name] == nil {
+		series[name] = map[string]*metricSeries{}
+	}
+
+	// series are identified by formatted labels
+	key := prometheusLabels(labels)
+	s, found := series[name][key]
+	if !found {
+		s = &metricSeries{}
+		series[name][key] = s
+	}
+
+	s.sum += value
+	s.count++
+}
+
+// AddCounter method increases counter with given name and labels
+func (m *PrometheusMetrics) AddCounter(name string, labels MetricLabels, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	observe(m.counters, name, labels, value)
+}
+
+// ObserveDuration method records duration into timer with given name and
+// labels
+func (m *PrometheusMetrics) ObserveDuration(name string, labels MetricLabels, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	observe(m.timers, name, labels, duration.Seconds())
+}
+
+// sortedSeries function returns series of one metric in stable order
+func sortedSeries(series map[string]*metricSeries) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedMetricNames function returns names of metrics in stable order
+func sortedMetricNames(series map[string]map[string]*metricSeries) []string {
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeMetricType function writes HELP and TYPE lines for given metric
+func writeMetricType(writer io.Writer, name, metricType string) error {
+	help := metricHelps[name]
+	if help == "" {
+		help = name
+	}
+	_, err := fmt.Fprintf(writer, "# HELP %s%s %s\n# TYPE %s%s %s\n",
+		metricsPrefix, name, help, metricsPrefix, name, metricType)
+	return err
+}
+
+// Write method writes all counters and timers in Prometheus text format
+// into given writer. Counters are written as counters, timers as summaries
+// without quantiles.
+func (m *PrometheusMetrics) Write(writer io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range sortedMetricNames(m.counters) {
+		err := writeMetricType(writer, name, "counter")
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedSeries(m.counters[name]) {
+			_, err := fmt.Fprintf(writer, "%s%s%s %g\n",
+				metricsPrefix, name, key, m.counters[name][key].sum)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range sortedMetricNames(m.timers) {
+		err := writeMetricType(writer, name, "summary")
+		if err != nil {
+			return err
+		}
+		for _, key := range sortedSeries(m.timers[name]) {
+			series := m.timers[name][key]
+			_, err := fmt.Fprintf(writer, "%s%s_sum%s %g\n%s%s_count%s %d\n",
+				metricsPrefix, name, key, series.sum,
+				metricsPrefix, name, key, series.count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// statsdMetrics sends counters and timers to StatsD daemon over UDP. Every
+// value is sent in its own datagram, so values are not lost when the export
+// fails; values that can't be sent are dropped.
+type statsdMetrics struct {
+	connection net.Conn
+	namespace  string
+}
+
+// newStatsdMetrics function constructs StatsD backend sending values to
+// given address
+func newStatsdMetrics(address, namespace string) (*statsdMetrics, error) {
+	connection, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		namespace = defaultStatsdNamespace
+	}
+	return &statsdMetrics{
+		connection: connection,
+		namespace:  namespace,
+	}, nil
+}
+
+// statsdName method returns name of StatsD metric, values of labels are
+// appended to the name in stable order of label names
+func (m *statsdMetrics) statsdName(name string, labels MetricLabels) string {
+	parts := []string{m.namespace, name}
+	for _, label := range sortedLabelNames(labels) {
+		parts = append(parts, statsdUnsafeCharacters.ReplaceAllString(labels[label], "_"))
+	}
+	return strings.Join(parts, statsdNameSeparator)
+}
+
+// send method sends one value to StatsD daemon
+func (m *statsdMetrics) send(name string, labels MetricLabels, value, metricType string) {
+	// UDP write fails only when the datagram can't be sent at all
+	_, _ = fmt.Fprintf(m.connection, "%s:%s|%s", m.statsdName(name, labels), value, metricType)
+}
+
+// AddCounter method sends counter increment
+func (m *statsdMetrics) AddCounter(name string, labels MetricLabels, value float64) {
+	m.send(name, labels, fmt.Sprintf("%g", value), statsdCounterType)
+}
+
+// ObserveDuration method sends timer value in milliseconds
+func (m *statsdMetrics) ObserveDuration(name string, labels MetricLabels, duration time.Duration) {
+	m.send(name, labels, fmt.Sprintf("%g", float64(duration)/float64(time.Millisecond)), statsdTimerType)
+}
+
+// Close method closes connection to StatsD daemon
+func (m *statsdMetrics) Close() error {
+	return m.connection.Close()
+}
+
+// newMetrics function constructs metrics backends selected by
+// configuration. Prometheus registry is returned too (nil when textfile is
+// not configured), so it can be written into the textfile. Returned closer
+// needs to be called at the end of the run.
+func newMetrics(configuration MetricsConfiguration) (Metrics, *PrometheusMetrics, func(), error) {
+	var backends multiMetrics
+	closer := func() {}
+
+	var registry *PrometheusMetrics
+	if configuration.TextfilePath != "" {
+		registry = NewPrometheusMetrics()
+		backends = append(backends, registry)
+	}
+
+	if configuration.StatsdAddress != "" {
+		statsd, err := newStatsdMetrics(configuration.StatsdAddress,
+			configuration.StatsdNamespace)
+		if err != nil {
+			return nil, nil, closer, err
+		}
+		backends = append(backends, statsd)
+		closer = func() {
+			_ = statsd.Close()
+		}
+	}
+
+	switch len(backends) {
+	case 0:
+		return nopMetrics{}, registry, closer, nil
+	case 1:
+		return backends[0], registry, closer, nil
+	default:
+		return backends, registry, closer, nil
+	}
+}
+
+// instrumentation method returns metrics backend of the storage, backend
+// doing nothing is returned when none has been set
+func (storage DBStorage) instrumentation() Metrics {
+	if storage.metrics == nil {
+		return nopMetrics{}
+	}
+	return storage.metrics
+}
+
+// recordTableMetrics method reports number of exported and rejected rows
+// and time spent by export of given table
+func (storage DBStorage) recordTableMetrics(tableName TableName, exportedRows,
+	rejectedRows int, duration time.Duration) {
+	metrics := storage.instrumentation()
+	labels := MetricLabels{metricLabelTable: string(tableName)}
+	metrics.AddCounter(metricRowsExported, labels, float64(exportedRows))
+	if rejectedRows > 0 {
+		metrics.AddCounter(metricRowsRejected, labels, float64(rejectedRows))
+	}
+	metrics.ObserveDuration(metricTableExport, labels, duration)
+}
+
+// instrumentation method returns metrics backend of the session, backend
+// doing nothing is returned when none has been set
+func (session *S3Session) instrumentation() Metrics {
+	if session.metrics == nil {
+		return nopMetrics{}
+	}
+	return session.metrics
+}
+
+// recordRequest method reports one S3 request, its result and duration
+func (session *S3Session) recordRequest(operation string, err error,
+	duration time.Duration) {
+	metrics := session.instrumentation()
+	result := metricResultSuccess
+	if err != nil {
+		result = metricResultError
+	}
+	metrics.AddCounter(metricS3Requests, MetricLabels{
+		metricLabelOperation: operation,
+		metricLabelResult:    result,
+	}, 1)
+	metrics.ObserveDuration(metricS3Request, MetricLabels{metricLabelOperation: operation}, duration)
+}
+
+// countingWriter counts bytes written into the underlying writer
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+// Write method writes data into the underlying writer and counts them
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/metricsregistry_test.html
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestPrometheusMetrics checks that counters and timers reported from
+// several goroutines are written in Prometheus text format
+func TestPrometheusMetrics(t *testing.T) {
+	registry := main.NewPrometheusMetrics()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry.AddCounter("rows_exported_total", main.MetricLabels{"table": "report"}, 5)
+			registry.ObserveDuration("table_export_seconds",
+				main.MetricLabels{"table": "report"}, 500*time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	registry.AddCounter("rows_exported_total", main.MetricLabels{"table": "rule_hit"}, 1)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, registry.Write(buffer))
+	assert.Equal(t, ""+
+		"# HELP insights_results_aggregator_exporter_rows_exported_total Number of rows exported from table.\n"+
+		"# TYPE insights_results_aggregator_exporter_rows_exported_total counter\n"+
+		`insights_results_aggregator_exporter_rows_exported_total{table="report"} 50`+"\n"+
+		`insights_results_aggregator_exporter_rows_exported_total{table="rule_hit"} 1`+"\n"+
+		"# HELP insights_results_aggregator_exporter_table_export_seconds Time spent by reading and writing table.\n"+
+		"# TYPE insights_results_aggregator_exporter_table_export_seconds summary\n"+
+		`insights_results_aggregator_exporter_table_export_seconds_sum{table="report"} 5`+"\n"+
+		`insights_results_aggregator_exporter_table_export_seconds_count{table="report"} 10`+"\n",
+		buffer.String())
+}
+
+// TestWriteMetricsWithRegistry checks that counters and timers follow
+// metrics about the run
+func TestWriteMetricsWithRegistry(t *testing.T) {
+	registry := main.NewPrometheusMetrics()
+	registry.AddCounter("s3_retries_total", main.MetricLabels{"operation": "put"}, 1)
+
+	buffer := new(bytes.Buffer)
+	err := main.WriteMetrics(buffer, prepareSummary(), main.ExitStatusOK, registry)
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "insights_results_aggregator_exporter_last_run_exit_status 0\n")
+	assert.Contains(t, buffer.String(),
+		`insights_results_aggregator_exporter_s3_retries_total{operation="put"} 1`+"\n")
+}
+
+// TestStatsdMetrics checks that counters and timers are sent to StatsD
+// daemon
+func TestStatsdMetrics(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, listener.Close())
+	}()
+
+	metrics, registry, closer, err := main.NewMetrics(main.MetricsConfiguration{
+		StatsdAddress:   listener.LocalAddr().String(),
+		StatsdNamespace: "exporter",
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, registry)
+	defer closer()
+
+	metrics.AddCounter("rows_exported_total", main.MetricLabels{"table": "public.report"}, 42)
+	metrics.ObserveDuration("s3_request_seconds", main.MetricLabels{"operation": "put"},
+		1500*time.Microsecond)
+
+	buffer := make([]byte, 1024)
+	for _, expected := range []string{
+		"exporter.rows_exported_total.public_report:42|c",
+		"exporter.s3_request_seconds.put:1.5|ms",
+	} {
+		assert.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := listener.ReadFrom(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(buffer[:n]))
+	}
+}
+
+// TestNewMetrics checks backends selected by configuration
+func TestNewMetrics(t *testing.T) {
+	metrics, registry, closer, err := main.NewMetrics(main.MetricsConfiguration{})
+	assert.NoError(t, err)
+	assert.Nil(t, registry)
+	// metrics are dropped
+	metrics.AddCounter("rows_exported_total", nil, 1)
+	closer()
+
+	metrics, registry, closer, err = main.NewMetrics(main.MetricsConfiguration{
+		TextfilePath: "exporter.prom",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, registry, metrics)
+	closer()
+
+	_, _, closer, err = main.NewMetrics(main.MetricsConfiguration{
+		StatsdAddress: "localhost:notaport",
+	})
+	assert.Error(t, err)
+	closer()
+}
+
+// TestRetryS3OperationMetrics checks that S3 requests and retries are
+// reported into metrics backend of the session
+func TestRetryS3OperationMetrics(t *testing.T) {
+	registry := main.NewPrometheusMetrics()
+	session := main.NewS3Session(nil, main.S3Configuration{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	})
+	main.SetSessionMetrics(session, registry)
+
+	attempts := 0
+	err := main.RetryS3Operation(session, context.Background(), "put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			if attempts < 2 {
+				return minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable}
+			}
+			return nil
+		})
+	assert.NoError(t, err)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, registry.Write(buffer))
+	for _, expected := range []string{
+		`insights_results_aggregator_exporter_s3_requests_total{operation="put",result="error"} 1`,
+		`insights_results_aggregator_exporter_s3_requests_total{operation="put",result="success"} 1`,
+		`insights_results_aggregator_exporter_s3_retries_total{operation="put"} 1`,
+		`insights_results_aggregator_exporter_s3_request_seconds_count{operation="put"} 2`,
+	} {
+		assert.Contains(t, buffer.String(), expected)
+	}
+}
+
+// TestPerformDataExportMetrics checks that rows exported from tables are
+// reported by storage into given metrics backend
+func TestPerformDataExportMetrics(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithRuleHits(t),
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: t.TempDir(),
+	}
+
+	registry := main.NewPrometheusMetrics()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, registry)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, registry.Write(buffer))
+	assert.Contains(t, buffer.String(),
+		`insights_results_aggregator_exporter_rows_exported_total{table="rule_hit"} 3`+"\n")
+	assert.Contains(t, buffer.String(),
+		`insights_results_aggregator_exporter_table_export_seconds_count{table="rule_hit"} 1`+"\n")
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export into more outputs (files and S3) in one
+// run. Each table is read from database and serialized only once and the
+// same content is stored into all selected outputs, so all outputs are
+// consistent with each other and the database is not loaded more times.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/multioutput.html
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// messages
+const (
+	outputNotCombinable  = "Output %s can not be combined with other outputs"
+	outputSelectedTwice  = "Output %s is selected more than once"
+	skipUnchangedIgnored = "Unchanged tables are not detected when more outputs are selected"
+)
+
+// parseOutputs function splits comma-separated list of outputs
+func parseOutputs(output string) []string {
+	var outputs []string
+
+	for _, o := range strings.Split(output, ",") {
+		o = strings.TrimSpace(o)
+		if o != "" {
+			outputs = append(outputs, o)
+		}
+	}
+
+	return outputs
+}
+
+// exportedIntoSinks function returns true when more outputs are selected
+// on command line or when the selected output is sink registered by
+// RegisterSink (file and S3 outputs have their own export)
+func exportedIntoSinks(output string) bool {
+	outputs := parseOutputs(output)
+	if len(outputs) != 1 {
+		return len(outputs) > 1
+	}
+
+	output = outputs[0]
+	return output != fileOutput && output != s3Output && isSinkRegistered(output)
+}
+
+// checkOutputs function checks if selected outputs can be combined. Only
+// outputs registered as sinks can be used together.
+func checkOutputs(output string) error {
+	outputs := parseOutputs(output)
+	if len(outputs) <= 1 {
+		return nil
+	}
+
+	selected := make(map[string]bool, len(outputs))
+	for _, o := range outputs {
+		if !isSinkRegistered(o) {
+			return fmt.Errorf(outputNotCombinable, o)
+		}
+		if selected[o] {
+			return fmt.Errorf(outputSelectedTwice, o)
+		}
+		selected[o] = true
+	}
+
+	return nil
+}
+
+// storeOperationLogIntoSinks function stores operation log into all
+// selected outputs
+func storeOperationLogIntoSinks(configuration *ConfigStruct,
+	cliFlags CliFlags, buffer *bytes.Buffer) (int, error) {
+	encryption, err := artifactEncryption(configuration, cliFlags)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	sinks, err := newSinks(configuration, parseOutputs(cliFlags.Output),
+		SinkOptions{
+			Directory:   cliFlags.OutputDirectory,
+			Compression: artifactCompression(configuration),
+			Encryption:  encryption,
+			Checksums:   GetExportConfiguration(configuration).Checksums,
+		})
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	return storeObjectIntoSinks(sinks, logFile,
+		ObjectMeta{ContentType: "text/plain"}, buffer.Bytes())
+}
+
+// performDataExportToOutputs function exports all tables and metadata info
+// files into all selected outputs at once
+func performDataExportToOutputs(ctx context.Context, configuration *ConfigStruct,
+	storage *DBStorage, outputs []string, exportMetadata bool,
+	exportDisabledRules bool,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, format string,
+	skipped SkippedArtifacts, summary *Summary) (int, error) {
+	operationLogger.Info().Strs("outputs", outputs).Msg("Exporting to outputs")
+
+	sinks, err := newSinks(configuration, outputs, SinkOptions{
+		Directory:   storage.directory,
+		Directories: storage.directories,
+		Compression: storage.compression,
+		Encryption:  storage.encryption,
+		Artifacts:   storage.artifacts,
+		Checksums:   storage.checksums,
+		Metrics:     storage.metrics,
+	})
+	if err != nil {
+		storage.logger.Err(err).Msg(createSinksFailed)
+		operationLogger.Err(err).Msg(createSinksFailed)
+		return ExitStatusConfigurationError, err
+	}
+
+	if GetExportConfiguration(configuration).SkipUnchanged {
+		storage.logger.Warn().Msg(skipUnchangedIgnored)
+		operationLogger.Warn().Msg(skipUnchangedIgnored)
+	}
+
+	return performDataExportToSinks(ctx, storage, sinks, exportMetadata,
+		exportDisabledRules, operationLogger, limit, ignoredTables, format,
+		skipped, summary)
+}
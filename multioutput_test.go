@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/multioutput_test.html
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareSQLiteDatabase helper function creates SQLite database file with
+// one table and returns its name
+func prepareSQLiteDatabase(t *testing.T) string {
+	return newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER, report TEXT)`,
+		`INSERT INTO report VALUES (1, 'first'), (2, 'second')`)
+}
+
+// TestParseOutputs checks the function parseOutputs
+func TestParseOutputs(t *testing.T) {
+	assert.Equal(t, []string{"file"}, main.ParseOutputs("file"))
+	assert.Equal(t, []string{"file", "S3"}, main.ParseOutputs("file, S3,"))
+	assert.Empty(t, main.ParseOutputs(""))
+}
+
+// TestCheckOutputs checks the function checkOutputs
+func TestCheckOutputs(t *testing.T) {
+	assert.NoError(t, main.CheckOutputs("duckdb"))
+	assert.NoError(t, main.CheckOutputs("file,S3"))
+
+	assert.EqualError(t, main.CheckOutputs("file,duckdb"),
+		"Output duckdb can not be combined with other outputs")
+	assert.EqualError(t, main.CheckOutputs("S3,S3"),
+		"Output S3 is selected more than once")
+}
+
+// TestPerformDataExportToFileAndS3 checks that the same content is exported
+// into file and S3 outputs in one run
+func TestPerformDataExportToFileAndS3(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file,S3",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	for _, name := range []string{"report.csv", "_tables.csv", "_metadata.csv"} {
+		content, err := os.ReadFile(filepath.Join(directory, name))
+		assert.NoError(t, err)
+		assert.Equal(t, string(content), string(s3.objects["/bucket/prefix/"+name]))
+	}
+
+	assert.Equal(t, "id,report\n1,first\n2,second\n",
+		string(s3.objects["/bucket/prefix/report.csv"]))
+}
+
+// TestPerformDataExportWrongOutputs checks that outputs that can't be
+// combined are refused
+func TestPerformDataExportWrongOutputs(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "file,kafka",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusConfigurationError, code)
+}
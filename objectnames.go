@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains names of files and objects tables are exported
+// into. Table is exported into object named by the table with extension of
+// selected format by default. Name (including extension) can be configured
+// per table, so consumers expecting legacy names keep working while tables
+// are renamed in database.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/objectnames.html
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// messages
+const (
+	wrongTableObjectName = "object name can contain only letters, digits, underscores, dashes and dots and it can't start with dot"
+	duplicateObjectName  = "object name %s is used by table %s too"
+)
+
+// objectNamePattern matches names configured for exported tables, the names
+// can't contain path separators and they can't refer to parent directory
+var objectNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// checkObjectName function checks if given name can be used as name of file
+// or object table is exported into
+func checkObjectName(name string) error {
+	if !objectNamePattern.MatchString(name) {
+		return errors.New(wrongTableObjectName)
+	}
+	return nil
+}
+
+// tableObjectName method returns name of file or object (without prefix and
+// compression extension) given table is exported into in selected format
+func (storage DBStorage) tableObjectName(tableName TableName, format string) string {
+	if name, found := lookupTableConfig(storage.objectNames, tableName); found {
+		return name
+	}
+	return string(tableName) + fileExtension(format)
+}
+
+// checkObjectNames method checks names of objects configured for tables. Each
+// name can be used by one table only.
+func (c *configurationChecker) checkObjectNames(objectNames ObjectNamesConfiguration) {
+	// names are checked in stable order
+	tableNames := make([]string, 0, len(objectNames))
+	for tableName := range objectNames {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	used := make(map[string]string, len(objectNames))
+	for _, tableName := range tableNames {
+		name := objectNames[tableName]
+		if err := checkObjectName(name); err != nil {
+			c.report("object_names."+tableName, err.Error())
+			continue
+		}
+
+		if other, found := used[name]; found {
+			c.report("object_names."+tableName, fmt.Sprintf(duplicateObjectName, name, other))
+			continue
+		}
+		used[name] = tableName
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/objectnames_test.html
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestPerformDataExportSQLiteObjectNames checks that table with configured
+// object name is exported into file with that name, other tables keep names
+// derived from table name
+func TestPerformDataExportSQLiteObjectNames(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE rule_disable (rule VARCHAR)",
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		"INSERT INTO rule_disable VALUES ('rule1')")
+
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		ObjectNames: main.ObjectNamesConfiguration{
+			"rule_disable": "rules_disabled_report.csv",
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "json",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// configured name is used as it is, including extension
+	content, err := os.ReadFile(filepath.Join(directory, "rules_disabled_report.csv"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "rule1")
+	assert.NoFileExists(t, filepath.Join(directory, "rule_disable.json"))
+
+	assert.FileExists(t, filepath.Join(directory, "report.json"))
+}
+
+// TestPerformDataExportToS3ObjectNames checks that table with configured
+// object name is uploaded under selected prefix into object with that name
+func TestPerformDataExportToS3ObjectNames(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "prefix",
+		},
+		Export: main.ExportConfiguration{
+			Compression: "gzip",
+		},
+		ObjectNames: main.ObjectNamesConfiguration{
+			"report": "legacy_report.csv",
+		},
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "S3"}, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// compression extension is appended to configured name
+	assert.Contains(t, s3.objects, "/bucket/prefix/legacy_report.csv.gz")
+	assert.NotContains(t, s3.objects, "/bucket/prefix/report.csv.gz")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions used to export rows of tables in
+// deterministic order, so consecutive exports can be compared. Rows are
+// ordered by primary key discovered from database catalog or by columns
+// configured for given table.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/ordering.html
+
+import (
+	"context"
+	"strings"
+)
+
+// query to read names of all primary key columns of given PostgreSQL table
+// in order they are declared in the key
+const selectPrimaryKeyColumns = `
+SELECT a.attname
+  FROM pg_index i
+ CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, position)
+  JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+ WHERE i.indrelid = $1::regclass AND i.indisprimary
+ ORDER BY k.position`
+
+// query to read names of all primary key columns of given SQLite table in
+// order they are declared in the key
+const selectSQLitePrimaryKeyColumns = `
+SELECT name
+  FROM pragma_table_info(?)
+ WHERE pk > 0
+ ORDER BY pk`
+
+// messages
+const (
+	orderingRows       = "Ordering rows of table"
+	orderColumnsMsg    = "order columns"
+	noKeyToOrderRowsBy = "Table does not have primary key, rows are not ordered"
+)
+
+// orderColumns method returns columns rows of given table are ordered by.
+// Columns configured for the table are used when set, otherwise primary key
+// columns are used when ordering of all tables is enabled. Nil is returned
+// when rows are not ordered.
+func (storage DBStorage) orderColumns(ctx context.Context, tableName TableName) ([]string, error) {
+	if configured, found := lookupTableConfig(storage.ordering, tableName); found {
+		return splitOrderColumns(configured), nil
+	}
+
+	if !storage.orderRows {
+		return nil, nil
+	}
+
+	columns, err := storage.readPrimaryKeyColumns(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		storage.logger.Warn().Msg(noKeyToOrderRowsBy)
+	}
+
+	return columns, nil
+}
+
+// splitOrderColumns function splits comma separated list of columns
+func splitOrderColumns(configured string) []string {
+	var columns []string
+	for _, column := range strings.Split(configured, ",") {
+		column = strings.TrimSpace(column)
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// readPrimaryKeyColumns method reads names of all primary key columns of
+// given table, composite keys are supported
+func (storage DBStorage) readPrimaryKeyColumns(ctx context.Context, tableName TableName) ([]string, error) {
+	query := selectPrimaryKeyColumns
+	if storage.dbDriverType == DBDriverSQLite3 {
+		query = selectSQLitePrimaryKeyColumns
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, query, string(tableName))
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, query).Msg(sqlStatementExecutionError)
+		return nil, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	var columns []string
+
+	for rows.Next() {
+		var column string
+
+		err := rows.Scan(&column)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// applyOrdering method appends ORDER BY clause to query that reads content
+// of given table when its rows need to be ordered
+func (storage DBStorage) applyOrdering(ctx context.Context, sqlStatement *string,
+	tableName TableName) error {
+	columns, err := storage.orderColumns(ctx, tableName)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	storage.logger.Info().
+		Strs(orderColumnsMsg, columns).
+		Msg(orderingRows)
+
+	quoted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, quoteIdentifier(column))
+	}
+
+	*sqlStatement += " ORDER BY " + strings.Join(quoted, ", ")
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/ordering_test.html
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// mustCreateOrderedTables helper function creates SQLite database with
+// tables with and without primary key and constructs storage connected to it.
+// Rows are inserted out of key order.
+func mustCreateOrderedTables(t *testing.T) *main.DBStorage {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE rule_hit (
+			org_id INTEGER,
+			cluster VARCHAR,
+			hits INTEGER,
+			PRIMARY KEY (org_id, cluster))`,
+		`INSERT INTO rule_hit VALUES
+			(2, 'b', 10), (1, 'b', 20), (2, 'a', 30), (1, 'a', 40)`,
+		`CREATE TABLE report (id INTEGER, report TEXT)`,
+		`INSERT INTO report VALUES (2, 'x'), (3, 'z'), (1, 'y')`)
+
+	connection, err := sql.Open("sqlite3", dataSource)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, connection.Close())
+	})
+
+	config := main.StorageConfiguration{Driver: "sqlite3"}
+	return main.NewFromConnection(connection, main.DBDriverSQLite3, &config)
+}
+
+// columnValues helper function returns values of given column of all rows
+func columnValues(rows []main.M, column string) []interface{} {
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row[column])
+	}
+	return values
+}
+
+// TestReadTableOrderedByPrimaryKey checks that rows are ordered by all
+// columns of composite primary key
+func TestReadTableOrderedByPrimaryKey(t *testing.T) {
+	storage := mustCreateOrderedTables(t)
+	main.SetOrdering(storage, true, nil)
+
+	rows, err := storage.ReadTable(context.Background(), "rule_hit", NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(40), int64(20), int64(30), int64(10)},
+		columnValues(rows, "hits"))
+
+	// limit is applied to ordered rows
+	rows, err = storage.ReadTable(context.Background(), "rule_hit", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(40)}, columnValues(rows, "hits"))
+}
+
+// TestReadTableOrderedByConfiguredColumns checks that configured columns are
+// used instead of primary key
+func TestReadTableOrderedByConfiguredColumns(t *testing.T) {
+	storage := mustCreateOrderedTables(t)
+	main.SetOrdering(storage, false, main.OrderingConfiguration{
+		"rule_hit": "hits",
+		"report":   "report, id",
+	})
+
+	rows, err := storage.ReadTable(context.Background(), "rule_hit", NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(10), int64(20), int64(30), int64(40)},
+		columnValues(rows, "hits"))
+
+	rows, err = storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(2), int64(1), int64(3)}, columnValues(rows, "id"))
+}
+
+// TestReadTableWithoutPrimaryKey checks that rows of table without primary
+// key are read in unspecified order
+func TestReadTableWithoutPrimaryKey(t *testing.T) {
+	storage := mustCreateOrderedTables(t)
+	main.SetOrdering(storage, true, nil)
+
+	rows, err := storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+}
+
+// TestReadTableOrderedPostgres checks query constructed for PostgreSQL table
+// ordered by primary key read from catalog
+func TestReadTableOrderedPostgres(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery("SELECT a.attname").
+		WithArgs("report").
+		WillReturnRows(sqlmock.NewRows([]string{"attname"}).AddRow("org_id").AddRow("cluster"))
+	mock.ExpectQuery(`SELECT \* FROM report ORDER BY "org_id", "cluster" LIMIT 10`).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "cluster"}))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetOrdering(storage, true, nil)
+
+	_, err := storage.ReadTable(context.Background(), "report", 10)
+	assert.NoError(t, err)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains summary of organizations. The summary contains
+// one row per organization with number of its clusters, number of reports
+// and timestamp of the latest report, so freshness of data of each
+// organization can be checked without processing the whole report table.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/orgsummary.html
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// orgSummaryFile is name of file or object with summary of organizations
+const orgSummaryFile = "_org_summary.csv"
+
+// messages
+const (
+	readOrgSummaryFailed  = "Read summary of organizations failed"
+	storeOrgSummaryFailed = "Store summary of organizations failed"
+	exportingOrgSummary   = "Exporting summary of organizations"
+)
+
+// Keys of objects in summary of organizations written as JSON or NDJSON,
+// they are used as CSV header too
+const (
+	reportsKey    = "reports"
+	lastReportKey = "last_report"
+)
+
+// selectOrgSummary counts clusters and reports of each organization. Filter
+// of organizations is inserted as WHERE clause.
+const selectOrgSummary = `
+           SELECT org_id, count(DISTINCT cluster) AS clusters, count(*) AS reports,
+                  max(reported_at) AS last_report
+             FROM report%s
+            GROUP BY org_id
+            ORDER BY org_id;
+`
+
+// OrgSummary contains numbers of clusters and reports of one organization
+// together with timestamp of its latest report
+type OrgSummary struct {
+	OrgID      int
+	Clusters   int
+	Reports    int
+	LastReport string
+}
+
+// timestampValue function converts timestamp read from database into
+// string. Drivers return aggregated timestamps as time or as text.
+func timestampValue(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	default:
+		return csvValue(v)
+	}
+}
+
+// ReadOrgSummary method reads numbers of clusters and reports of each
+// organization
+func (storage DBStorage) ReadOrgSummary(ctx context.Context) ([]OrgSummary, error) {
+	summaries := make([]OrgSummary, 0)
+
+	// only selected organizations are reported
+	filter := ""
+	if storage.orgIDFilterApplied("report") {
+		filter = fmt.Sprintf(" WHERE org_id IN ('%v')",
+			strings.Join(storage.config.OrganizationsToExport, "','"))
+	}
+	sqlStatement := fmt.Sprintf(selectOrgSummary, filter)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return summaries, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			summary    OrgSummary
+			lastReport interface{}
+		)
+
+		err := rows.Scan(&summary.OrgID, &summary.Clusters, &summary.Reports, &lastReport)
+		if err != nil {
+			return summaries, err
+		}
+		summary.LastReport = timestampValue(lastReport)
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+// writeOrgSummary function exports summary of organizations in report
+// format for selected output format
+func writeOrgSummary(buffer io.Writer, summaries []OrgSummary, format string) error {
+	rows := make([]M, 0, len(summaries))
+	for _, summary := range summaries {
+		rows = append(rows, M{
+			orgIDKey:      summary.OrgID,
+			clustersKey:   summary.Clusters,
+			reportsKey:    summary.Reports,
+			lastReportKey: summary.LastReport,
+		})
+	}
+
+	return writeReport(buffer, reportFormat(format),
+		[]string{orgIDKey, clustersKey, reportsKey, lastReportKey}, rows)
+}
+
+// orgSummaryReport method reads summary of organizations and converts it
+// into report format. Exit status is returned together with error.
+func (storage DBStorage) orgSummaryReport(ctx context.Context, format string) ([]byte, int, error) {
+	summaries, err := storage.ReadOrgSummary(ctx)
+	if err != nil {
+		storage.logger.Err(err).Msg(readOrgSummaryFailed)
+		return nil, ExitStatusStorageError, err
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	err = writeOrgSummary(buffer, summaries, format)
+	if err != nil {
+		return nil, ExitStatusIOError, err
+	}
+
+	// buffer is returned into pool
+	return append([]byte(nil), buffer.Bytes()...), ExitStatusOK, nil
+}
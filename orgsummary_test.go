@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/orgsummary_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareOrgSummaryDatabase function creates SQLite database with reports
+// of clusters in two organizations
+func prepareOrgSummaryDatabase(t *testing.T) string {
+	statements := []string{
+		`CREATE TABLE report (org_id INTEGER, cluster VARCHAR, report VARCHAR,
+		     reported_at TIMESTAMP)`,
+		`INSERT INTO report VALUES
+		     (1, 'c1', '{}', '2024-01-01 10:00:00'),
+		     (1, 'c2', '{}', '2024-01-03 08:30:00'),
+		     (2, 'c3', '{}', '2024-01-02 12:00:00'),
+		     (2, 'c3', '{}', '2023-12-31 12:00:00')`,
+	}
+
+	return newSQLiteTestDatabase(t, statements...)
+}
+
+// TestReadOrgSummary checks the method ReadOrgSummary
+func TestReadOrgSummary(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareOrgSummaryDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	summaries, err := storage.ReadOrgSummary(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.OrgSummary{
+		{OrgID: 1, Clusters: 2, Reports: 2, LastReport: "2024-01-03 08:30:00"},
+		{OrgID: 2, Clusters: 1, Reports: 2, LastReport: "2024-01-02 12:00:00"},
+	}, summaries)
+}
+
+// TestReadOrgSummaryOrgFilter checks that only selected organizations are
+// summarized
+func TestReadOrgSummaryOrgFilter(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:                "sqlite3",
+		SQLiteDataSource:      prepareOrgSummaryDatabase(t),
+		EnableOrgIDFiltering:  true,
+		OrganizationsToExport: []string{"1"},
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	summaries, err := storage.ReadOrgSummary(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, 1, summaries[0].OrgID)
+}
+
+// TestTimestampValue checks the function TimestampValue
+func TestTimestampValue(t *testing.T) {
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "2024-01-02T02:04:05Z", main.TimestampValue(timestamp))
+	assert.Equal(t, "2024-01-02 03:04:05", main.TimestampValue([]byte("2024-01-02 03:04:05")))
+	assert.Equal(t, "", main.TimestampValue(nil))
+}
+
+// TestPerformDataExportOrgSummary checks that summary of organizations is
+// exported when requested
+func TestPerformDataExportOrgSummary(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareOrgSummaryDatabase(t),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:           "file",
+		OutputDirectory:  directory,
+		ExportOrgSummary: true,
+		SkipArtifacts:    "sequences,constraints",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_org_summary.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,clusters,reports,last_report\n"+
+		"1,2,2,2024-01-03 08:30:00\n"+
+		"2,1,2,2024-01-02 12:00:00\n", string(content))
+}
@@ -0,0 +1,252 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains locale-aware output profiles. CSV files are
+// exported with comma delimiter, decimal point and ISO timestamps by
+// default, which spreadsheet applications configured for other locales
+// don't read correctly. Profile selected for output (file or S3) converts
+// all CSV objects written into the output: delimiter, decimal separator and
+// format of timestamps are changed and byte order mark is added, so the
+// files can be opened directly.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/outputprofile.html
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultProfile is name of profile that keeps CSV objects unchanged
+const defaultProfile = "default"
+
+// messages
+const (
+	unknownOutputProfile = "unknown output profile %s, supported profiles: %s"
+	profileUnknownOutput = "output %s is not known"
+)
+
+// byteOrderMark is written at the beginning of CSV objects, so spreadsheet
+// applications detect UTF-8 encoding
+const byteOrderMark = "\ufeff"
+
+// OutputProfile contains formatting of values in CSV objects written into
+// one output
+type OutputProfile struct {
+	// Delimiter separates fields of one record
+	Delimiter rune
+
+	// DecimalSeparator replaces decimal point of numbers
+	DecimalSeparator string
+
+	// TimestampLayout is used to format timestamps
+	TimestampLayout string
+
+	// ByteOrderMark is written at the beginning of each CSV object
+	ByteOrderMark bool
+}
+
+// outputProfiles contains all supported profiles except the default one
+var outputProfiles = map[string]OutputProfile{
+	"excel-eu": {
+		Delimiter:        ';',
+		DecimalSeparator: ",",
+		TimestampLayout:  "02.01.2006 15:04:05",
+		ByteOrderMark:    true,
+	},
+	"excel-us": {
+		Delimiter:        ',',
+		DecimalSeparator: ".",
+		TimestampLayout:  "01/02/2006 15:04:05",
+		ByteOrderMark:    true,
+	},
+}
+
+// decimalNumber matches values converted by decimal separator, integers and
+// numbers in exponential notation are kept as they are
+var decimalNumber = regexp.MustCompile(`^-?[0-9]+\.[0-9]+$`)
+
+// profileTimestampLayouts contains layouts of timestamps written into CSV,
+// the first one is used by timestamps read from database
+var profileTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+}
+
+// outputProfileNames function returns names of all supported profiles
+func outputProfileNames() []string {
+	names := []string{defaultProfile}
+	for name := range outputProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// outputProfile function returns profile selected for given output, nil is
+// returned when CSV objects are written without changes
+func outputProfile(configuration *ConfigStruct, output string) *OutputProfile {
+	profile, found := outputProfiles[GetOutputProfilesConfiguration(configuration)[output]]
+	if !found {
+		return nil
+	}
+	return &profile
+}
+
+// checkOutputProfiles method checks profiles selected for outputs
+func (c *configurationChecker) checkOutputProfiles(profiles OutputProfilesConfiguration) {
+	// outputs are checked in stable order
+	outputs := make([]string, 0, len(profiles))
+	for output := range profiles {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+
+	for _, output := range outputs {
+		if !isSinkRegistered(output) {
+			c.report("output_profiles."+output, fmt.Sprintf(profileUnknownOutput, output))
+			continue
+		}
+
+		name := profiles[output]
+		if _, found := outputProfiles[name]; !found && name != defaultProfile {
+			c.report("output_profiles."+output, fmt.Sprintf(unknownOutputProfile,
+				name, strings.Join(outputProfileNames(), ", ")))
+		}
+	}
+}
+
+// csvDelimiter function detects delimiter of CSV data from its header, so
+// data converted by any profile can be read back
+func csvDelimiter(data []byte) rune {
+	header := data
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		header = data[:end]
+	}
+
+	if bytes.IndexByte(header, ';') >= 0 && bytes.IndexByte(header, ',') < 0 {
+		return ';'
+	}
+	return ','
+}
+
+// Value method returns value of one field formatted by the profile
+func (profile *OutputProfile) Value(value string) string {
+	if decimalNumber.MatchString(value) {
+		return strings.Replace(value, ".", profile.DecimalSeparator, 1)
+	}
+
+	// only values that look like timestamps are parsed
+	if len(value) >= len("2006-01-02 15:04:05") && value[4] == '-' && value[7] == '-' {
+		for _, layout := range profileTimestampLayouts {
+			if timestamp, err := time.Parse(layout, value); err == nil {
+				return timestamp.Format(profile.TimestampLayout)
+			}
+		}
+	}
+	return value
+}
+
+// Convert method reads CSV records from input and writes them into output
+// formatted by the profile
+func (profile *OutputProfile) Convert(input io.Reader, output io.Writer) error {
+	if profile.ByteOrderMark {
+		_, err := io.WriteString(output, byteOrderMark)
+		if err != nil {
+			return err
+		}
+	}
+
+	reader := csv.NewReader(input)
+	// records of reports don't need to have the same number of fields
+	reader.FieldsPerRecord = -1
+
+	writer, release := newCSVWriter(output)
+	defer release()
+	writer.Comma = profile.Delimiter
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		for i := range record {
+			record[i] = profile.Value(record[i])
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ConvertData method converts CSV data in memory
+func (profile *OutputProfile) ConvertData(data []byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := profile.Convert(bytes.NewReader(data), buffer)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ConvertStream method converts CSV data written by given function into
+// output while they are being written
+func (profile *OutputProfile) ConvertStream(output io.Writer, write func(io.Writer) error) error {
+	reader, writer := io.Pipe()
+	writeErr := make(chan error, 1)
+
+	go func() {
+		err := write(writer)
+		_ = writer.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	err := profile.Convert(reader, output)
+
+	// stop writing when conversion failed before all data were written
+	if err != nil {
+		_ = reader.CloseWithError(errSinkFailed)
+	} else {
+		_ = reader.Close()
+	}
+
+	// error during writing is the cause of failed conversion too
+	if err := <-writeErr; err != nil && !errors.Is(err, errSinkFailed) {
+		return err
+	}
+	return err
+}
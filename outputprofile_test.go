@@ -0,0 +1,202 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/outputprofile_test.html
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// excelEU is profile used by tests
+var excelEU = main.OutputProfile{
+	Delimiter:        ';',
+	DecimalSeparator: ",",
+	TimestampLayout:  "02.01.2006 15:04:05",
+	ByteOrderMark:    true,
+}
+
+// TestOutputProfileValue checks the method OutputProfile.Value
+func TestOutputProfileValue(t *testing.T) {
+	profile := excelEU
+
+	assert.Equal(t, "3,14", profile.Value("3.14"))
+	assert.Equal(t, "-0,5", profile.Value("-0.5"))
+	assert.Equal(t, "42", profile.Value("42"))
+	assert.Equal(t, "1e+06", profile.Value("1e+06"))
+	assert.Equal(t, "1.2.3", profile.Value("1.2.3"))
+	assert.Equal(t, "", profile.Value(""))
+	assert.Equal(t, "text", profile.Value("text"))
+
+	assert.Equal(t, "31.12.2023 23:59:58",
+		profile.Value("2023-12-31 23:59:58.123 +0000 UTC"))
+	assert.Equal(t, "01.02.2024 10:00:00", profile.Value("2024-02-01T10:00:00Z"))
+	assert.Equal(t, "2024-02-01 is a date", profile.Value("2024-02-01 is a date"))
+}
+
+// TestOutputProfileConvert checks the method OutputProfile.Convert
+func TestOutputProfileConvert(t *testing.T) {
+	profile := excelEU
+
+	var output bytes.Buffer
+	err := profile.Convert(strings.NewReader(
+		"id,ratio,note\n1,0.25,\"a;b\"\n2,1.5,\"x,y\"\n"), &output)
+	assert.NoError(t, err)
+	assert.Equal(t, "\ufeffid;ratio;note\n1;0,25;\"a;b\"\n2;1,5;x,y\n", output.String())
+
+	// the same conversion of data in memory
+	converted, err := profile.ConvertData([]byte("ratio\n0.75\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\ufeffratio\n0,75\n", string(converted))
+
+	// malformed CSV is reported
+	_, err = profile.ConvertData([]byte("a,\"b\n"))
+	assert.Error(t, err)
+}
+
+// TestOutputProfileForOutput checks that profiles are selected per output
+func TestOutputProfileForOutput(t *testing.T) {
+	configuration := main.ConfigStruct{
+		OutputProfiles: main.OutputProfilesConfiguration{
+			"file": "excel-eu",
+			"S3":   "default",
+		},
+	}
+
+	profile := main.OutputProfileForOutput(&configuration, "file")
+	assert.NotNil(t, profile)
+	assert.Equal(t, excelEU, *profile)
+
+	assert.Nil(t, main.OutputProfileForOutput(&configuration, "S3"))
+	assert.Nil(t, main.OutputProfileForOutput(&configuration, "duckdb"))
+}
+
+// TestDisabledRulesFromCSVProfile checks that disabled rules converted by
+// output profile are read back
+func TestDisabledRulesFromCSVProfile(t *testing.T) {
+	profile := excelEU
+	converted, err := profile.ConvertData([]byte("Rule,Count\nrule1,2\nrule2,3\n"))
+	assert.NoError(t, err)
+
+	disabledRules, err := main.DisabledRulesFromCSV(bytes.NewReader(converted))
+	assert.NoError(t, err)
+	assert.Equal(t, []main.DisabledRuleInfo{
+		{Rule: "rule1", Count: 2},
+		{Rule: "rule2", Count: 3},
+	}, disabledRules)
+}
+
+// TestPerformDataExportOutputProfile checks that CSV files are converted by
+// profile selected for file output and S3 objects are kept unchanged
+func TestPerformDataExportOutputProfile(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER, score REAL, note TEXT)`,
+		`INSERT INTO report VALUES (1, 0.5, 'a;b'), (2, 12.25, 'c')`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+		},
+		OutputProfiles: main.OutputProfilesConfiguration{
+			"file": "excel-eu",
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file,S3",
+		OutputDirectory: directory,
+		SkipArtifacts:   "sequences,constraints",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\ufeffid;score;note\n1;0,5;\"a;b\"\n2;12,25;c\n", string(content))
+
+	assert.Equal(t, "id,score,note\n1,0.5,a;b\n2,12.25,c\n",
+		string(s3.objects["/bucket/report.csv"]))
+}
+
+// TestPerformDataExportToS3OutputProfile checks that CSV objects are
+// converted by profile selected for S3
+func TestPerformDataExportToS3OutputProfile(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+		},
+		OutputProfiles: main.OutputProfilesConfiguration{
+			"S3": "excel-eu",
+		},
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "S3", ExportMetadata: true}, &log.Logger, &log.Logger,
+		main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, "\ufeffid;report\n1;first\n2;second\n",
+		string(s3.objects["/bucket/report.csv"]))
+	assert.True(t, strings.HasPrefix(string(s3.objects["/bucket/_tables.csv"]), "\ufeff"))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/permissions.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+)
+
+// name of object that is written and deleted during permission check
+const permissionCheckObject = "_permission_check"
+
+// messages
+const (
+	permissionCheckFailed = "Permission check failed"
+	bucketDoesNotExist    = "Bucket does not exist"
+)
+
+// PermissionCheck represents result of one pre-flight permission check
+type PermissionCheck struct {
+	Description string
+	Err         error
+}
+
+// Passed method returns true if the check passed
+func (check PermissionCheck) Passed() bool {
+	return check.Err == nil
+}
+
+// checkDatabasePermissions function checks if all tables can be read from
+// database. Error is returned only when the list of tables can not be read.
+func checkDatabasePermissions(ctx context.Context, storage *DBStorage) ([]PermissionCheck, error) {
+	tableNames, err := storage.ReadListOfTables(ctx)
+	if err != nil {
+		return []PermissionCheck{{"list tables", err}}, err
+	}
+
+	checks := make([]PermissionCheck, 0, len(tableNames)+1)
+	checks = append(checks, PermissionCheck{"list tables", nil})
+
+	for _, tableName := range tableNames {
+		err := storage.CheckSelectPermission(ctx, tableName)
+		checks = append(checks, PermissionCheck{
+			Description: "SELECT on table " + string(tableName),
+			Err:         err,
+		})
+	}
+
+	return checks, nil
+}
+
+// checkBucketPermissions function checks if objects can be written into and
+// deleted from given bucket. Small probe object is used for this purpose.
+func checkBucketPermissions(ctx context.Context, session *S3Session,
+	bucketName, prefix string) []PermissionCheck {
+	exists, err := s3BucketExists(ctx, session, bucketName)
+	if err == nil && !exists {
+		err = errors.New(bucketDoesNotExist)
+	}
+
+	checks := []PermissionCheck{{"access bucket " + bucketName, err}}
+	if err != nil {
+		// it does not make sense to continue
+		return checks
+	}
+
+	objectName := setObjectPrefix(prefix, permissionCheckObject)
+
+	// try to write probe object
+	probe := []byte("permission check")
+	err = session.withTimeout(ctx, s3PutOperation, s3ArtifactLocation(bucketName, objectName),
+		func(ctx context.Context) error {
+			_, err := session.client.PutObject(ctx, bucketName, objectName,
+				bytes.NewReader(probe), int64(len(probe)),
+				minio.PutObjectOptions{ContentType: "text/plain"})
+			return err
+		})
+	checks = append(checks, PermissionCheck{"write into bucket " + bucketName, err})
+	if err != nil {
+		// nothing to delete
+		return checks
+	}
+
+	// try to delete probe object
+	err = session.client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+	checks = append(checks, PermissionCheck{"delete from bucket " + bucketName, err})
+
+	return checks
+}
+
+// printPermissionChecks function prints checklist with results of all
+// permission checks
+func printPermissionChecks(writer io.Writer, checks []PermissionCheck) {
+	for _, check := range checks {
+		if check.Passed() {
+			fmt.Fprintf(writer, "[OK]     %s\n", check.Description)
+		} else {
+			fmt.Fprintf(writer, "[FAILED] %s: %v\n", check.Description, check.Err)
+		}
+	}
+}
+
+// countFailedChecks function returns number of checks that did not pass
+func countFailedChecks(checks []PermissionCheck) int {
+	failed := 0
+	for _, check := range checks {
+		if !check.Passed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// checkPermissions function verifies that the exporter is able to read all
+// tables from database and write objects into configured bucket. Checklist
+// with results is printed to standard output.
+func checkPermissions(ctx context.Context, configuration *ConfigStruct) (int, error) {
+	log.Info().Msg("Checking permissions")
+
+	storageConfiguration := GetStorageConfiguration(configuration)
+	storage, err := NewStorage(&storageConfiguration)
+	if err != nil {
+		return ExitStatusStorageError, err
+	}
+
+	databaseChecks, err := checkDatabasePermissions(ctx, storage)
+	printPermissionChecks(os.Stdout, databaseChecks)
+
+	closeErr := storage.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Err(err).Msg(permissionCheckFailed)
+		return ExitStatusStorageError, err
+	}
+
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return ExitStatusS3Error, err
+	}
+
+	bucketChecks := checkBucketPermissions(session.Context(), session,
+		session.Bucket(), session.Prefix())
+	printPermissionChecks(os.Stdout, bucketChecks)
+
+	// database related problems are reported first
+	if failed := countFailedChecks(databaseChecks); failed > 0 {
+		err := fmt.Errorf("%d database permission check(s) failed", failed)
+		log.Err(err).Msg(permissionCheckFailed)
+		return ExitStatusStorageError, err
+	}
+
+	if failed := countFailedChecks(bucketChecks); failed > 0 {
+		err := fmt.Errorf("%d S3 permission check(s) failed", failed)
+		log.Err(err).Msg(permissionCheckFailed)
+		return ExitStatusS3Error, err
+	}
+
+	log.Info().Msg("All permission checks passed")
+	return ExitStatusOK, nil
+}
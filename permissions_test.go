@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/permissions_test.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestCheckDatabasePermissions checks the function checkDatabasePermissions
+func TestCheckDatabasePermissions(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "first")
+	rows.AddRow("public", "second")
+
+	// expected queries performed by tested function
+	mock.ExpectQuery(readListOfTablesQueryPostgres).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT \\* FROM first LIMIT 0").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM second LIMIT 0").
+		WillReturnError(errors.New("permission denied for table second"))
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested function
+	checks, err := main.CheckDatabasePermissions(context.Background(), storage)
+	assert.NoError(t, err)
+
+	assert.Len(t, checks, 3)
+	assert.True(t, checks[0].Passed())
+	assert.True(t, checks[1].Passed())
+	assert.Equal(t, "SELECT on table first", checks[1].Description)
+	assert.False(t, checks[2].Passed())
+	assert.Equal(t, "SELECT on table second", checks[2].Description)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckDatabasePermissionsListOfTablesError checks the function
+// checkDatabasePermissions when list of tables can not be read
+func TestCheckDatabasePermissionsListOfTablesError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readListOfTablesQueryPostgres).
+		WillReturnError(errors.New("permission denied"))
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested function
+	checks, err := main.CheckDatabasePermissions(context.Background(), storage)
+	assert.Error(t, err)
+	assert.Len(t, checks, 1)
+	assert.False(t, checks[0].Passed())
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckBucketPermissionsNoClient checks the function
+// checkBucketPermissions when Minio client is not provided
+func TestCheckBucketPermissionsNoClient(t *testing.T) {
+	checks := main.CheckBucketPermissions(context.Background(), nil, "bucket", "")
+	assert.Len(t, checks, 1)
+	assert.False(t, checks[0].Passed())
+}
+
+// TestCheckBucketPermissionsNotAccessibleClient checks the function
+// checkBucketPermissions when S3 is not accessible
+func TestCheckBucketPermissionsNotAccessibleClient(t *testing.T) {
+	checks := main.CheckBucketPermissions(context.Background(),
+		mustConstructS3Session(t), "bucket", "prefix")
+	assert.Len(t, checks, 1)
+	assert.False(t, checks[0].Passed())
+	assert.Contains(t, checks[0].Err.Error(), "connection refused")
+}
+
+// TestPrintPermissionChecks checks the function printPermissionChecks
+func TestPrintPermissionChecks(t *testing.T) {
+	checks := []main.PermissionCheck{
+		{Description: "SELECT on table first"},
+		{Description: "SELECT on table second", Err: errors.New("permission denied")},
+	}
+
+	buffer := new(bytes.Buffer)
+	main.PrintPermissionChecks(buffer, checks)
+
+	expected := "[OK]     SELECT on table first\n" +
+		"[FAILED] SELECT on table second: permission denied\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+// TestCheckPermissionsNoStorage checks the function checkPermissions when
+// storage is not configured
+func TestCheckPermissionsNoStorage(t *testing.T) {
+	configuration := main.ConfigStruct{}
+
+	code, err := main.CheckPermissions(context.Background(), &configuration)
+	assert.Equal(t, main.ExitStatusStorageError, code)
+	assert.Error(t, err)
+}
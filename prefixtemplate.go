@@ -0,0 +1,139 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains templating of S3 prefix. Prefix can contain Go
+// template placeholders like `{{.Date}}`, `{{.Time}}`, `{{.DBName}}` or
+// `{{.RunID}}` that are expanded once when the run starts, so successive
+// runs store their objects under different prefixes instead of overwriting
+// objects stored by previous runs, for example:
+//
+// prefix = "exports/{{.Date}}T{{.Time}}"
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/prefixtemplate.html
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Formats of date and time placeholders
+const (
+	prefixDateFormat = "2006-01-02"
+	prefixTimeFormat = "15:04"
+)
+
+// templateDelimiter starts placeholder in prefix template
+const templateDelimiter = "{{"
+
+// sampleRunID is used instead of identifier of the run when prefix template
+// is checked before the run starts
+const sampleRunID = "0123456789abcdef"
+
+// messages
+const (
+	wrongPrefixTemplate   = "wrong prefix template: %w"
+	emptyExpandedPrefix   = "prefix template is expanded into empty prefix"
+	prefixTemplateResumed = "prefix with placeholders can't be resumed, select prefix of interrupted run by -prefix flag"
+	expandedPrefixMsg     = "Prefix expanded"
+	prefixMsg             = "prefix"
+)
+
+// PrefixTemplateData contains values of all placeholders that can be used
+// in prefix template
+type PrefixTemplateData struct {
+	// Date is date the run has been started in UTC, e.g. 2024-05-01
+	Date string
+
+	// Time is time the run has been started in UTC, e.g. 02:00
+	Time string
+
+	// Timestamp is time the run has been started in UTC, it can be
+	// formatted by template, e.g. {{.Timestamp.Format "20060102"}}
+	Timestamp time.Time
+
+	// DBName is name of database the data are exported from
+	DBName string
+
+	// RunID is identifier of the run
+	RunID string
+}
+
+// newPrefixTemplateData function constructs values of placeholders for run
+// started at given time
+func newPrefixTemplateData(started time.Time, dbName, runID string) PrefixTemplateData {
+	started = started.UTC()
+	return PrefixTemplateData{
+		Date:      started.Format(prefixDateFormat),
+		Time:      started.Format(prefixTimeFormat),
+		Timestamp: started,
+		DBName:    dbName,
+		RunID:     runID,
+	}
+}
+
+// isPrefixTemplate function checks if given prefix contains any placeholder
+func isPrefixTemplate(prefix string) bool {
+	return strings.Contains(prefix, templateDelimiter)
+}
+
+// expandPrefix function expands all placeholders in given prefix. Prefix
+// without placeholders is returned unchanged. Trailing slashes are removed,
+// because names of objects are joined with prefix by slash.
+func expandPrefix(prefix string, data PrefixTemplateData) (string, error) {
+	if !isPrefixTemplate(prefix) {
+		return prefix, nil
+	}
+
+	tmpl, err := template.New("prefix").Option("missingkey=error").Parse(prefix)
+	if err != nil {
+		return "", fmt.Errorf(wrongPrefixTemplate, err)
+	}
+
+	var builder strings.Builder
+	err = tmpl.Execute(&builder, data)
+	if err != nil {
+		return "", fmt.Errorf(wrongPrefixTemplate, err)
+	}
+
+	expanded := strings.TrimRight(builder.String(), "/")
+	if expanded == "" {
+		return "", errors.New(emptyExpandedPrefix)
+	}
+	return expanded, nil
+}
+
+// expandConfiguredPrefix function expands placeholders in S3 prefix stored
+// in given configuration by values of run that is just being started
+func expandConfiguredPrefix(configuration *ConfigStruct, runID string) error {
+	data := newPrefixTemplateData(time.Now(),
+		GetStorageConfiguration(configuration).PGDBName, runID)
+
+	prefix, err := expandPrefix(configuration.S3.Prefix, data)
+	if err != nil {
+		return err
+	}
+	configuration.S3.Prefix = prefix
+	return nil
+}
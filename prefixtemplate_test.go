@@ -0,0 +1,78 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/prefixtemplate_test.html
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestExpandPrefix checks that placeholders in prefix are expanded
+func TestExpandPrefix(t *testing.T) {
+	started := time.Date(2024, 5, 1, 4, 0, 30, 0, time.FixedZone("CEST", 2*60*60))
+	data := main.NewPrefixTemplateData(started, "aggregator", "0a1b2c")
+
+	for prefix, expected := range map[string]string{
+		"":                                   "",
+		"exports":                            "exports",
+		"exports/{{.Date}}T{{.Time}}/":       "exports/2024-05-01T02:00",
+		"{{.DBName}}/{{.RunID}}":             "aggregator/0a1b2c",
+		`{{.Timestamp.Format "20060102"}}`:   "20240501",
+		"exports/{{.Timestamp.Hour}}-run///": "exports/2-run",
+	} {
+		expanded, err := main.ExpandPrefix(prefix, data)
+		assert.NoError(t, err, prefix)
+		assert.Equal(t, expected, expanded, prefix)
+	}
+}
+
+// TestExpandPrefixErrors checks that wrong prefix templates are refused
+func TestExpandPrefixErrors(t *testing.T) {
+	data := main.NewPrefixTemplateData(time.Now(), "aggregator", "0a1b2c")
+
+	_, err := main.ExpandPrefix("exports/{{.Date", data)
+	assert.ErrorContains(t, err, "wrong prefix template: ")
+
+	_, err = main.ExpandPrefix("exports/{{.Cluster}}", data)
+	assert.ErrorContains(t, err, "wrong prefix template: ")
+
+	_, err = main.ExpandPrefix("{{.DBName}}/", main.PrefixTemplateData{})
+	assert.EqualError(t, err, "prefix template is expanded into empty prefix")
+}
+
+// TestExpandConfiguredPrefix checks that prefix is expanded in
+// configuration by values of the run
+func TestExpandConfiguredPrefix(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{PGDBName: "aggregator"},
+		S3:      main.S3Configuration{Prefix: "exports/{{.DBName}}/{{.Date}}/{{.RunID}}"},
+	}
+
+	assert.NoError(t, main.ExpandConfiguredPrefix(&configuration, "0a1b2c"))
+	assert.Regexp(t, `^exports/aggregator/\d{4}-\d{2}-\d{2}/0a1b2c$`,
+		configuration.S3.Prefix)
+}
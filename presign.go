@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains generation of presigned GET URLs of objects
+// exported into S3. URLs are generated after successful export, they are
+// listed in manifest of the run and in summary table, so exports can be
+// shared with teams that have no direct access to the bucket. Each URL is
+// valid for configured time.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/presign.html
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxPresignExpiry is the longest validity of presigned URL accepted by S3
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// s3LocationPrefix starts locations of artifacts stored in S3
+const s3LocationPrefix = "s3://"
+
+// messages
+const (
+	generatingPresignedURLs = "Generating presigned URLs of exported objects"
+	presignedURLsGenerated  = "Presigned URLs generated"
+	presignExpiryTooLong    = "must not be longer than %v, found %v"
+	presignFailed           = "unable to presign %s: %w"
+	expiresMsg              = "expires"
+)
+
+// PresignedURL represents presigned GET URL of one exported object
+type PresignedURL struct {
+	Location string
+	URL      string
+	Expires  time.Time
+}
+
+// checkPresignExpiry function checks validity of presigned URLs selected by
+// configuration, zero disables presigned URLs
+func checkPresignExpiry(expiry time.Duration) error {
+	if expiry < 0 {
+		return fmt.Errorf(durationMustNotBeNegative, expiry)
+	}
+	if expiry > maxPresignExpiry {
+		return fmt.Errorf(presignExpiryTooLong, maxPresignExpiry, expiry)
+	}
+	return nil
+}
+
+// presignEnabled function checks whether presigned URLs of exported objects
+// need to be generated
+func presignEnabled(configuration *ConfigStruct, cliFlags CliFlags) bool {
+	return GetS3Configuration(configuration).PresignExpiry > 0 &&
+		dataExportSelected(cliFlags)
+}
+
+// splitS3Location function splits location of S3 artifact into bucket and
+// object name, false is returned for artifacts not stored in S3
+func splitS3Location(location string) (string, string, bool) {
+	if !strings.HasPrefix(location, s3LocationPrefix) {
+		return "", "", false
+	}
+
+	bucketName, objectName, found := strings.Cut(
+		strings.TrimPrefix(location, s3LocationPrefix), "/")
+	if !found || bucketName == "" || objectName == "" {
+		return "", "", false
+	}
+	return bucketName, objectName, true
+}
+
+// presignArtifacts function generates presigned GET URLs of all artifacts
+// stored in S3. URLs are recorded into the artifact log (and so into
+// manifest of the run and into input of post-processing hooks) and into
+// summary. Artifacts written into files are skipped.
+func presignArtifacts(configuration *ConfigStruct, artifacts *ArtifactLog,
+	summary *Summary, logger *zerolog.Logger) (int, error) {
+	expiry := GetS3Configuration(configuration).PresignExpiry
+
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return ExitStatusS3Error, err
+	}
+
+	logger.Info().Msg(generatingPresignedURLs)
+
+	expires := time.Now().Add(expiry)
+	generated := 0
+
+	for _, artifact := range artifacts.Artifacts() {
+		bucketName, objectName, stored := splitS3Location(artifact.Location)
+		if !stored {
+			continue
+		}
+
+		url, err := session.Client().PresignedGetObject(session.Context(),
+			bucketName, objectName, expiry, nil)
+		if err != nil {
+			return ExitStatusS3Error, fmt.Errorf(presignFailed, artifact.Location, err)
+		}
+
+		artifacts.SetPresignedURL(artifact.Location, url.String())
+		summary.AddPresignedURL(PresignedURL{
+			Location: artifact.Location,
+			URL:      url.String(),
+			Expires:  expires,
+		})
+		generated++
+	}
+
+	logger.Info().Int(artifactsMsg, generated).
+		Time(expiresMsg, expires).Msg(presignedURLsGenerated)
+	return ExitStatusOK, nil
+}
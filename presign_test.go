@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/presign_test.html
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestCheckPresignExpiry checks the function checkPresignExpiry
+func TestCheckPresignExpiry(t *testing.T) {
+	assert.NoError(t, main.CheckPresignExpiry(0))
+	assert.NoError(t, main.CheckPresignExpiry(time.Hour))
+	assert.NoError(t, main.CheckPresignExpiry(7*24*time.Hour))
+
+	assert.EqualError(t, main.CheckPresignExpiry(-time.Second),
+		"must not be negative, found -1s")
+	assert.EqualError(t, main.CheckPresignExpiry(8*24*time.Hour),
+		"must not be longer than 168h0m0s, found 192h0m0s")
+}
+
+// TestSplitS3Location checks the function splitS3Location
+func TestSplitS3Location(t *testing.T) {
+	bucketName, objectName, stored := main.SplitS3Location("s3://bucket/prefix/report.csv")
+	assert.True(t, stored)
+	assert.Equal(t, "bucket", bucketName)
+	assert.Equal(t, "prefix/report.csv", objectName)
+
+	for _, location := range []string{
+		"/tmp/export/report.csv",
+		"s3://bucket",
+		"s3:///report.csv",
+	} {
+		_, _, stored := main.SplitS3Location(location)
+		assert.False(t, stored, location)
+	}
+}
+
+// TestPresignArtifacts checks that presigned URLs are generated for objects
+// stored in S3 and that the objects can be downloaded by them
+func TestPresignArtifacts(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+	s3.objects["/bucket/prefix/report.csv"] = []byte("org_id\n1\n")
+
+	configuration := main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:     address,
+			AccessKeyID:     "foobar",
+			SecretAccessKey: "foobar",
+			Bucket:          "bucket",
+			Prefix:          "prefix",
+			Region:          "us-east-1",
+			PresignExpiry:   time.Hour,
+		},
+	}
+
+	artifacts := main.NewArtifactLog()
+	artifacts.Record(main.Artifact{Location: "s3://bucket/prefix/report.csv"})
+	artifacts.Record(main.Artifact{Location: "/tmp/export/rule_hit.csv"})
+
+	summary := main.NewSummary()
+	status, err := main.PresignArtifacts(&configuration, artifacts, summary, &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, status)
+
+	urls := summary.PresignedURLs()
+	assert.Len(t, urls, 1)
+	assert.Equal(t, "s3://bucket/prefix/report.csv", urls[0].Location)
+	assert.Contains(t, urls[0].URL, "/bucket/prefix/report.csv?")
+	assert.Contains(t, urls[0].URL, "X-Amz-Expires=3600")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), urls[0].Expires, time.Minute)
+
+	// URLs are recorded for artifacts stored in S3 only
+	recorded := artifacts.Artifacts()
+	assert.Empty(t, recorded[0].PresignedURL)
+	assert.Equal(t, urls[0].URL, recorded[1].PresignedURL)
+
+	// object can be downloaded without credentials
+	response, err := http.Get(urls[0].URL)
+	assert.NoError(t, err)
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	data, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id\n1\n", string(data))
+}
+
+// TestPrintSummaryPresignedURLs checks that presigned URLs are printed only
+// when they have been generated
+func TestPrintSummaryPresignedURLs(t *testing.T) {
+	summary := main.NewSummary()
+	summary.Finish()
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.NotContains(t, buffer.String(), "Presigned URLs")
+
+	summary.AddPresignedURL(main.PresignedURL{
+		Location: "s3://bucket/report.csv",
+		URL:      "http://localhost/bucket/report.csv?X-Amz-Expires=3600",
+		Expires:  time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC),
+	})
+
+	buffer.Reset()
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.Contains(t, buffer.String(), "Presigned URLs (valid until 2024-05-01T03:00:00Z):\n"+
+		"  s3://bucket/report.csv: http://localhost/bucket/report.csv?X-Amz-Expires=3600\n")
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains progress of running export. Status of the
+// export (table being exported, percent complete, rows exported so far) is
+// periodically written as small JSON document into local file or S3 object,
+// so external dashboards can show live progress of the export.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/progress.html
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// states of the export written into status document
+const (
+	progressRunning  = "running"
+	progressFinished = "finished"
+	progressFailed   = "failed"
+)
+
+// defaultProgressInterval is delay between writes of status document when
+// no interval is configured
+const defaultProgressInterval = 10 * time.Second
+
+// messages
+const (
+	storeProgressFailed   = "Unable to store status of the export"
+	progressStateConflict = "progress_file and progress_object can't be used together"
+)
+
+// Progress represents progress of running export. All methods can be called
+// on nil pointer - in this case they do nothing. Methods are safe to be
+// called from several goroutines.
+type Progress struct {
+	mutex            sync.Mutex
+	started          time.Time
+	totalTables      int
+	finishedTables   int
+	currentTable     TableName
+	currentTableRows int
+	rows             int
+}
+
+// ProgressStatus is status of the export written into status document
+type ProgressStatus struct {
+	State            string    `json:"state"`
+	Started          time.Time `json:"started"`
+	Updated          time.Time `json:"updated"`
+	CurrentTable     TableName `json:"current_table,omitempty"`
+	CurrentTableRows int       `json:"current_table_rows"`
+	TablesTotal      int       `json:"tables_total"`
+	TablesFinished   int       `json:"tables_finished"`
+	PercentComplete  float64   `json:"percent_complete"`
+	Rows             int       `json:"rows"`
+	ExitStatus       *int      `json:"exit_status,omitempty"`
+	ExitStatusName   string    `json:"exit_status_name,omitempty"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// NewProgress function constructs progress of export that starts now
+func NewProgress() *Progress {
+	return &Progress{started: time.Now()}
+}
+
+// AddTables method adds number of tables selected to be exported. Split
+// export selects tables of each artifact set separately.
+func (progress *Progress) AddTables(tables int) {
+	if progress == nil {
+		return
+	}
+
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.totalTables += tables
+}
+
+// StartTable method records that export of given table has started
+func (progress *Progress) StartTable(tableName TableName) {
+	if progress == nil {
+		return
+	}
+
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.currentTable = tableName
+	progress.currentTableRows = 0
+}
+
+// AddRows method adds number of rows exported from current table
+func (progress *Progress) AddRows(rows int) {
+	if progress == nil {
+		return
+	}
+
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.currentTableRows += rows
+	progress.rows += rows
+}
+
+// FinishTable method records that export of current table has finished
+func (progress *Progress) FinishTable() {
+	if progress == nil {
+		return
+	}
+
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.finishedTables++
+}
+
+// Status method returns status of the export in given state
+func (progress *Progress) Status(state string) ProgressStatus {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	status := ProgressStatus{
+		State:            state,
+		Started:          progress.started.UTC(),
+		Updated:          time.Now().UTC(),
+		CurrentTable:     progress.currentTable,
+		CurrentTableRows: progress.currentTableRows,
+		TablesTotal:      progress.totalTables,
+		TablesFinished:   progress.finishedTables,
+		Rows:             progress.rows,
+	}
+
+	switch {
+	case state == progressFinished:
+		status.PercentComplete = 100
+	case progress.totalTables > 0:
+		status.PercentComplete = float64(progress.finishedTables) * 100 /
+			float64(progress.totalTables)
+	}
+
+	return status
+}
+
+// progressReporter periodically writes status of running export into file
+// or S3 object
+type progressReporter struct {
+	progress *Progress
+	store    func([]byte) error
+	logger   *zerolog.Logger
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startProgressReporter function starts periodic writes of status of the
+// export into file or S3 object selected by configuration. Nil is returned
+// when status of the export is not written.
+func startProgressReporter(configuration *ConfigStruct, progress *Progress,
+	logger *zerolog.Logger) *progressReporter {
+	exportConfiguration := GetExportConfiguration(configuration)
+
+	var store func([]byte) error
+	switch {
+	case exportConfiguration.ProgressFile != "":
+		store = func(data []byte) error {
+			// dashboards need to be able to read the file
+			return writeFileAtomically(exportConfiguration.ProgressFile, data, 0o644)
+		}
+	case exportConfiguration.ProgressObject != "":
+		store = func(data []byte) error {
+			session, err := OpenS3Session(configuration)
+			if err != nil {
+				return err
+			}
+			// status is never compressed, so it can be read by
+			// dashboards directly. It is not an artifact of the
+			// export and it is written even when the export is
+			// interrupted, so context of the session is used.
+			return putObject(session.Context(), session,
+				session.Bucket(), exportConfiguration.ProgressObject,
+				jsonContentType, data, noCompression, nil)
+		}
+	default:
+		return nil
+	}
+
+	interval := exportConfiguration.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	reporter := &progressReporter{
+		progress: progress,
+		store:    store,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	reporter.write(progress.Status(progressRunning))
+
+	go func() {
+		defer close(reporter.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reporter.write(progress.Status(progressRunning))
+			case <-reporter.stop:
+				return
+			}
+		}
+	}()
+
+	return reporter
+}
+
+// Stop method stops periodic writes and writes the final status of the
+// export with its exit status
+func (reporter *progressReporter) Stop(exitStatus int, err error) {
+	if reporter == nil {
+		return
+	}
+
+	close(reporter.stop)
+	<-reporter.done
+
+	state := progressFinished
+	if exitStatus != ExitStatusOK || err != nil {
+		state = progressFailed
+	}
+
+	status := reporter.progress.Status(state)
+	status.ExitStatus = &exitStatus
+	status.ExitStatusName = exitStatusName(exitStatus)
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	reporter.write(status)
+}
+
+// write method writes given status, failed write is logged only, because
+// status of the export is not part of exported data
+func (reporter *progressReporter) write(status ProgressStatus) {
+	data, err := json.Marshal(status)
+	if err == nil {
+		err = reporter.store(data)
+	}
+
+	if err != nil {
+		reporter.logger.Warn().Err(err).Msg(storeProgressFailed)
+	}
+}
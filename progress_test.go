@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/progress_test.html
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestProgressStatus checks that status reflects exported tables and rows
+func TestProgressStatus(t *testing.T) {
+	progress := main.NewProgress()
+
+	status := progress.Status("running")
+	assert.Equal(t, "running", status.State)
+	assert.Equal(t, float64(0), status.PercentComplete)
+
+	progress.AddTables(4)
+	progress.StartTable("report")
+	progress.AddRows(10)
+	progress.FinishTable()
+	progress.StartTable("rule_hit")
+	progress.AddRows(5)
+
+	status = progress.Status("running")
+	assert.Equal(t, main.TableName("rule_hit"), status.CurrentTable)
+	assert.Equal(t, 5, status.CurrentTableRows)
+	assert.Equal(t, 15, status.Rows)
+	assert.Equal(t, 4, status.TablesTotal)
+	assert.Equal(t, 1, status.TablesFinished)
+	assert.Equal(t, float64(25), status.PercentComplete)
+	assert.Nil(t, status.ExitStatus)
+
+	// finished export is always complete
+	assert.Equal(t, float64(100), progress.Status("finished").PercentComplete)
+}
+
+// TestProgressNil checks that nil progress can be used
+func TestProgressNil(t *testing.T) {
+	var progress *main.Progress
+
+	progress.AddTables(1)
+	progress.StartTable("report")
+	progress.AddRows(1)
+	progress.FinishTable()
+}
+
+// readProgressFile helper function reads status of the export from file
+func readProgressFile(t *testing.T, fileName string) map[string]interface{} {
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+
+	var status map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &status))
+	return status
+}
+
+// TestPerformDataExportProgressFile checks that status of finished and
+// failed export is written into configured file
+func TestPerformDataExportProgressFile(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE rule_hit (id INTEGER PRIMARY KEY)",
+		"INSERT INTO report VALUES (1), (2), (3)")
+
+	progressFile := filepath.Join(t.TempDir(), "status.json")
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			ProgressFile: progressFile,
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: t.TempDir(),
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	status := readProgressFile(t, progressFile)
+	assert.Equal(t, "finished", status["state"])
+	assert.Equal(t, float64(100), status["percent_complete"])
+	assert.Equal(t, float64(2), status["tables_total"])
+	assert.Equal(t, float64(2), status["tables_finished"])
+	assert.Equal(t, float64(3), status["rows"])
+	assert.Equal(t, float64(main.ExitStatusOK), status["exit_status"])
+	assert.Equal(t, "ok", status["exit_status_name"])
+	assert.NotContains(t, status, "error")
+
+	// failed export is reported with its exit status and error
+	configuration.Incremental = main.IncrementalConfiguration{"report": "updated_at"}
+	configuration.Export.WatermarkStateFile = filepath.Join(t.TempDir(), "watermarks.json")
+
+	code, err = main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+
+	status = readProgressFile(t, progressFile)
+	assert.Equal(t, "failed", status["state"])
+	assert.Equal(t, float64(code), status["exit_status"])
+	assert.Equal(t, err.Error(), status["error"])
+}
@@ -0,0 +1,320 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains quarantine of rows that can't be scanned from
+// database or converted into selected output format. Such rows are not
+// exported, they are written into separate `<table>_rejects.csv` file (or
+// object) together with the error instead, so one bad row does not fail the
+// export of whole table.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/quarantine.html
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// suffix of name of file with rejected rows
+const rejectsSuffix = "_rejects"
+
+// name of column with the reason why the row has been rejected
+const rejectErrorColumn = "error"
+
+// messages
+const (
+	rowRejected        = "Row has been rejected"
+	rejectedRowsMsg    = "rejected rows"
+	tableHasRejects    = "Some rows of table have been rejected"
+	storeRejectsFailed = "Store rejected rows failed"
+)
+
+// RowConversionError is returned by table writers when one row can't be
+// converted into selected output format. Nothing from the row is written, so
+// the row can be quarantined and the export can continue.
+type RowConversionError struct {
+	Err error
+}
+
+// Error method returns description of the conversion error
+func (e RowConversionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap method returns the original error
+func (e RowConversionError) Unwrap() error {
+	return e.Err
+}
+
+// RejectedRow represents one row that has not been exported
+type RejectedRow struct {
+	Row   M
+	Error string
+}
+
+// tableRejects contains all rejected rows of one table
+type tableRejects struct {
+	colNames []string
+	rows     []RejectedRow
+}
+
+// Quarantine contains rows rejected during export, indexed by table name.
+//
+// All methods can be called on nil pointer - in this case quarantine is
+// disabled. Methods are safe to be called from several goroutines.
+type Quarantine struct {
+	mutex  sync.Mutex
+	tables map[TableName]*tableRejects
+}
+
+// NewQuarantine function constructs new empty quarantine
+func NewQuarantine() *Quarantine {
+	return &Quarantine{
+		tables: make(map[TableName]*tableRejects),
+	}
+}
+
+// Enabled method checks if rows can be quarantined
+func (quarantine *Quarantine) Enabled() bool {
+	return quarantine != nil
+}
+
+// Reject method puts given row of table into quarantine
+func (quarantine *Quarantine) Reject(tableName TableName, colNames []string,
+	row M, err error) {
+	if quarantine == nil {
+		return
+	}
+
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	rejects, found := quarantine.tables[tableName]
+	if !found {
+		rejects = &tableRejects{colNames: colNames}
+		quarantine.tables[tableName] = rejects
+	}
+	rejects.rows = append(rejects.rows, RejectedRow{
+		Row:   row,
+		Error: err.Error(),
+	})
+}
+
+// Reset method removes all rejected rows of given table, it is used when the
+// table is read again
+func (quarantine *Quarantine) Reset(tableName TableName) {
+	if quarantine == nil {
+		return
+	}
+
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	delete(quarantine.tables, tableName)
+}
+
+// Truncate method keeps only given number of first rejected rows of table,
+// it is used when part of the table is read again
+func (quarantine *Quarantine) Truncate(tableName TableName, count int) {
+	if quarantine == nil {
+		return
+	}
+
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	rejects, found := quarantine.tables[tableName]
+	if found && count < len(rejects.rows) {
+		rejects.rows = rejects.rows[:count]
+	}
+}
+
+// Count method returns number of rejected rows of given table
+func (quarantine *Quarantine) Count(tableName TableName) int {
+	if quarantine == nil {
+		return 0
+	}
+
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	rejects, found := quarantine.tables[tableName]
+	if !found {
+		return 0
+	}
+	return len(rejects.rows)
+}
+
+// Rejects method returns column names and all rejected rows of given table
+func (quarantine *Quarantine) Rejects(tableName TableName) ([]string, []RejectedRow) {
+	if quarantine == nil {
+		return nil, nil
+	}
+
+	quarantine.mutex.Lock()
+	defer quarantine.mutex.Unlock()
+
+	rejects, found := quarantine.tables[tableName]
+	if !found {
+		return nil, nil
+	}
+	return rejects.colNames, rejects.rows
+}
+
+// rejectsFileName function constructs name of file with rejected rows of
+// given table
+func rejectsFileName(tableName TableName) string {
+	return string(tableName) + rejectsSuffix + CSVFileExtension
+}
+
+// RejectsToCSV function writes rejected rows into CSV. Values are written as
+// they have been read from database and the last column contains the error.
+func RejectsToCSV(output io.Writer, colNames []string, rejects []RejectedRow) error {
+	writer, release := newCSVWriter(output)
+	defer release()
+
+	header := append(append([]string{}, colNames...), rejectErrorColumn)
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, reject := range rejects {
+		record := make([]string, 0, len(header))
+		for _, colName := range colNames {
+			value, found := reject.Row[colName]
+			if !found || value == nil {
+				record = append(record, "")
+				continue
+			}
+			record = append(record, fmt.Sprintf("%v", value))
+		}
+		record = append(record, reject.Error)
+
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// rejectsIntoCSV method serializes rejected rows of given table. Nil is
+// returned when no row has been rejected.
+func (quarantine *Quarantine) rejectsIntoCSV(tableName TableName) ([]byte, error) {
+	colNames, rejects := quarantine.Rejects(tableName)
+	if len(rejects) == 0 {
+		return nil, nil
+	}
+
+	buffer := new(bytes.Buffer)
+	err := RejectsToCSV(buffer, colNames, rejects)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// scanRawRow function reads values of current row without any conversion.
+// It is used to quarantine row that can't be scanned into typed values.
+func scanRawRow(rows *sql.Rows, colNames []string) M {
+	values := make([]interface{}, len(colNames))
+	scanArgs := make([]interface{}, len(colNames))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	row := M{}
+
+	// scanning into empty interfaces does not convert values, so it
+	// should not fail for the same reason
+	if err := rows.Scan(scanArgs...); err != nil {
+		return row
+	}
+
+	for i, colName := range colNames {
+		if b, ok := values[i].([]byte); ok {
+			row[colName] = string(b)
+			continue
+		}
+		row[colName] = values[i]
+	}
+	return row
+}
+
+// logRejects function writes number of rejected rows of given table into log
+// and into operation log
+func logRejects(logger, operationLogger *zerolog.Logger, quarantine *Quarantine,
+	tableName TableName) {
+	count := quarantine.Count(tableName)
+	if count == 0 {
+		return
+	}
+
+	for _, l := range []*zerolog.Logger{logger, operationLogger} {
+		l.Warn().
+			Str(tableNameMsg, string(tableName)).
+			Int(rejectedRowsMsg, count).
+			Msg(tableHasRejects)
+	}
+}
+
+// storeRejectsIntoSinks function stores rejected rows of given table into
+// all sinks
+func storeRejectsIntoSinks(sinks []Sink, quarantine *Quarantine,
+	tableName TableName) (int, error) {
+	data, err := quarantine.rejectsIntoCSV(tableName)
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+	if data == nil {
+		return ExitStatusOK, nil
+	}
+
+	return storeObjectIntoSinks(sinks, rejectsFileName(tableName),
+		ObjectMeta{ContentType: csvContentType}, data)
+}
+
+// storeRejectsIntoS3 function stores rejected rows of given table into S3
+// object under selected prefix
+func storeRejectsIntoS3(ctx context.Context, session *S3Session,
+	bucketName, prefix string, quarantine *Quarantine, tableName TableName,
+	compression string, encryption *Encryption) error {
+	data, err := quarantine.rejectsIntoCSV(tableName)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+
+	return putObject(ctx, session, bucketName,
+		setObjectPrefix(prefix, rejectsFileName(tableName)), csvContentType,
+		data, compression, encryption)
+}
@@ -0,0 +1,328 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/quarantine_test.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareDatabaseWithBadRows helper function creates SQLite database file
+// with one table that contains row that can't be scanned (text in integer
+// column) and row that can't be stored into XLSX cell
+func prepareDatabaseWithBadRows(t *testing.T) string {
+	return newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INT4, report TEXT)`,
+		`INSERT INTO report VALUES (1, 'first'), ('bad', 'second'), (3, '`+
+			strings.Repeat("x", 40000)+`')`)
+}
+
+// quarantineConfiguration helper function prepares configuration with
+// enabled quarantine of rows
+func quarantineConfiguration(dataSource string) main.ConfigStruct {
+	return main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			Quarantine: true,
+		},
+	}
+}
+
+// TestQuarantineNil checks that disabled quarantine can be used
+func TestQuarantineNil(t *testing.T) {
+	var quarantine *main.Quarantine
+
+	quarantine.Reject("report", []string{"id"}, main.M{"id": 1}, errors.New("error"))
+	quarantine.Reset("report")
+	quarantine.Truncate("report", 0)
+
+	assert.False(t, quarantine.Enabled())
+	assert.Equal(t, 0, quarantine.Count("report"))
+
+	colNames, rejects := quarantine.Rejects("report")
+	assert.Nil(t, colNames)
+	assert.Nil(t, rejects)
+}
+
+// TestQuarantineRejectAndReset checks that rejected rows are recorded per
+// table and can be removed when table is read again
+func TestQuarantineRejectAndReset(t *testing.T) {
+	quarantine := main.NewQuarantine()
+	assert.True(t, quarantine.Enabled())
+
+	colNames := []string{"id", "report"}
+	quarantine.Reject("report", colNames, main.M{"id": 1}, errors.New("first"))
+	quarantine.Reject("report", colNames, main.M{"id": 2}, errors.New("second"))
+	quarantine.Reject("rule_hit", []string{"id"}, main.M{"id": 3}, errors.New("third"))
+
+	assert.Equal(t, 2, quarantine.Count("report"))
+	assert.Equal(t, 1, quarantine.Count("rule_hit"))
+
+	rejectedColumns, rejects := quarantine.Rejects("report")
+	assert.Equal(t, colNames, rejectedColumns)
+	assert.Equal(t, []main.RejectedRow{
+		{Row: main.M{"id": 1}, Error: "first"},
+		{Row: main.M{"id": 2}, Error: "second"},
+	}, rejects)
+
+	quarantine.Reset("report")
+	assert.Equal(t, 0, quarantine.Count("report"))
+	assert.Equal(t, 1, quarantine.Count("rule_hit"))
+}
+
+// TestQuarantineTruncate checks that rejected rows of table read again are
+// removed
+func TestQuarantineTruncate(t *testing.T) {
+	quarantine := main.NewQuarantine()
+
+	colNames := []string{"id"}
+	quarantine.Reject("report", colNames, main.M{"id": 1}, errors.New("first"))
+	quarantine.Reject("report", colNames, main.M{"id": 2}, errors.New("second"))
+
+	quarantine.Truncate("report", 5)
+	assert.Equal(t, 2, quarantine.Count("report"))
+
+	quarantine.Truncate("report", 1)
+	assert.Equal(t, 1, quarantine.Count("report"))
+
+	_, rejects := quarantine.Rejects("report")
+	assert.Equal(t, "first", rejects[0].Error)
+}
+
+// TestRejectsToCSV checks the function RejectsToCSV
+func TestRejectsToCSV(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.RejectsToCSV(buffer, []string{"id", "report"}, []main.RejectedRow{
+		{Row: main.M{"id": "bad", "report": "second"}, Error: "conversion failed"},
+		{Row: main.M{"id": 3, "report": nil}, Error: "too long"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "id,report,error\n"+
+		"bad,second,conversion failed\n"+
+		"3,,too long\n", buffer.String())
+}
+
+// TestRejectsFileName checks the function rejectsFileName
+func TestRejectsFileName(t *testing.T) {
+	assert.Equal(t, "report_rejects.csv", main.RejectsFileName("report"))
+}
+
+// TestRowConversionError checks that original error is accessible
+func TestRowConversionError(t *testing.T) {
+	original := errors.New("original")
+	err := main.RowConversionError{Err: original}
+
+	assert.EqualError(t, err, "original")
+	assert.ErrorIs(t, err, original)
+}
+
+// TestReadTableQuarantine checks that row that can't be scanned is
+// quarantined and other rows are read
+func TestReadTableQuarantine(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+	storage, err := main.NewStorage(&configuration.Storage)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	// without quarantine the table can't be read
+	_, err = storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.Error(t, err)
+
+	quarantine := main.NewQuarantine()
+	main.SetQuarantine(storage, quarantine)
+
+	rows, err := storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+
+	_, rejects := quarantine.Rejects("report")
+	assert.Len(t, rejects, 1)
+	assert.Equal(t, main.M{"id": "bad", "report": "second"}, rejects[0].Row)
+}
+
+// TestPerformDataExportQuarantine checks that rejected rows are exported
+// into separate file and counted in summary
+func TestPerformDataExportQuarantine(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+	summary := main.NewSummary()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(content), "\n"))
+
+	rejects, err := os.ReadFile(filepath.Join(directory, "report_rejects.csv"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(rejects), "id,report,error\nbad,second,"))
+
+	assert.Equal(t, 2, summary.ExportedRows())
+	assert.Equal(t, 1, summary.RejectedRows())
+}
+
+// TestPerformDataExportQuarantineConversion checks that row that can't be
+// converted into output format is quarantined
+func TestPerformDataExportQuarantineConversion(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		Format:          "xlsx",
+	}
+	summary := main.NewSummary()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	rejects, err := os.ReadFile(filepath.Join(directory, "report_rejects.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(rejects), "\n"))
+	assert.Contains(t, string(rejects), "cell can contain at most 32767")
+
+	assert.Equal(t, 1, summary.ExportedRows())
+	assert.Equal(t, 2, summary.RejectedRows())
+}
+
+// TestReadTableQuarantineMasked checks that rows that can't be scanned are
+// quarantined with masked values only
+func TestReadTableQuarantineMasked(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+	storage, err := main.NewStorage(&configuration.Storage)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	quarantine := main.NewQuarantine()
+	main.SetQuarantine(storage, quarantine)
+
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"report": "constant:***"},
+	})
+	_, err = storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+
+	colNames, rejects := quarantine.Rejects("report")
+	assert.Equal(t, []string{"id", "report"}, colNames)
+	assert.Len(t, rejects, 1)
+	assert.Equal(t, main.M{"id": "bad", "report": "***"}, rejects[0].Row)
+
+	// dropped columns are not quarantined at all
+	quarantine.Reset("report")
+	main.SetMasking(storage, main.MaskingConfiguration{
+		"report": {"report": "drop"},
+	})
+	_, err = storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+
+	colNames, rejects = quarantine.Rejects("report")
+	assert.Equal(t, []string{"id"}, colNames)
+	assert.Len(t, rejects, 1)
+	assert.Equal(t, main.M{"id": "bad"}, rejects[0].Row)
+}
+
+// TestPerformDataExportQuarantineMasked checks that row that can't be
+// converted into output format is quarantined with masked values
+func TestPerformDataExportQuarantineMasked(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+	configuration.Masking = main.MaskingConfiguration{
+		"report": {"report": "fake"},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		Format:          "xlsx",
+	}
+	summary := main.NewSummary()
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	rejects, err := os.ReadFile(filepath.Join(directory, "report_rejects.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(rejects), "\n"))
+	assert.Contains(t, string(rejects), "cell can contain at most 32767")
+	assert.NotContains(t, string(rejects), "second")
+	assert.NotContains(t, string(rejects), strings.Repeat("x", 100))
+}
+
+// TestDetachedStorageQuarantine checks that storage used by concurrent
+// export phase does not reject rows into quarantine of exported tables
+func TestDetachedStorageQuarantine(t *testing.T) {
+	configuration := quarantineConfiguration(prepareDatabaseWithBadRows(t))
+	storage, err := main.NewStorage(&configuration.Storage)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	quarantine := main.NewQuarantine()
+	main.SetQuarantine(storage, quarantine)
+
+	_, err = main.Detached(storage).ReadTable(context.Background(), "report", NoLimits)
+	assert.Error(t, err)
+
+	_, rejects := quarantine.Rejects("report")
+	assert.Empty(t, rejects)
+
+	// original storage still quarantines rows
+	_, err = storage.ReadTable(context.Background(), "report", NoLimits)
+	assert.NoError(t, err)
+
+	_, rejects = quarantine.Rejects("report")
+	assert.Len(t, rejects, 1)
+}
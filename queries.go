@@ -0,0 +1,191 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of named custom queries. Queries are
+// defined in `[queries]` section of configuration file and the result of
+// each query is exported as CSV into its own file or object named
+// `_query_<name>.csv`, similarly to the list of disabled rules.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/queries.html
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sort"
+
+	"github.com/rs/zerolog"
+)
+
+// prefix of names of files with results of custom queries
+const queryResultPrefix = "_query_"
+
+// messages
+const (
+	exportingCustomQuery = "Exporting custom query"
+	customQueryFailed    = "Custom query failed"
+	queryNameMsg         = "query"
+	wrongQueryName       = "query name can contain only letters, digits, underscores and dashes"
+)
+
+// queryNamePattern matches names of custom queries that can be used in
+// file and object names
+var queryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// checkQueryName function checks if given name of custom query can be used
+// in name of file or object
+func checkQueryName(name string) error {
+	if !queryNamePattern.MatchString(name) {
+		return errors.New(wrongQueryName)
+	}
+	return nil
+}
+
+// queryResultFileName function constructs name of file (or object) with
+// result of given custom query
+func queryResultFileName(name string) string {
+	return queryResultPrefix + name + CSVFileExtension
+}
+
+// queryNames function returns names of all custom queries in stable order
+func queryNames(queries QueriesConfiguration) []string {
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StoreQueryResultIntoCSV method performs given query and writes all
+// returned rows together with column names into CSV. Query that is not
+// read-only or that can't be explained by database is not performed. The
+// query is performed in read-only transaction.
+func (storage DBStorage) StoreQueryResultIntoCSV(ctx context.Context,
+	buffer *bytes.Buffer, name, sqlStatement string) error {
+	err := storage.validateCustomQuery(ctx, sqlStatement)
+	if err != nil {
+		return err
+	}
+
+	storage.logger.Info().Str(sqlStatementExecuted, sqlStatement).Msg("Performing")
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	return storage.withReadOnlyTransaction(ctx, func(transaction *sql.Tx) error {
+		return storage.writeQueryResult(ctx, transaction, buffer, name, sqlStatement)
+	})
+}
+
+// writeQueryResult method performs given query by given connection or
+// transaction and writes all returned rows together with column names into
+// CSV
+func (storage DBStorage) writeQueryResult(ctx context.Context, querier rowsQuerier,
+	buffer *bytes.Buffer, name, sqlStatement string) error {
+	rows, err := querier.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(unableToRetrieveColumnTypes)
+		return err
+	}
+
+	writer, err := storage.newTableWriter(csvFormat, buffer, TableName(name),
+		getColumns(storage.dbDriverType, columnTypes))
+	if err != nil {
+		return err
+	}
+
+	colNames := getColumnNames(columnTypes)
+	err = writer.WriteHeader(colNames)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		scanArgs := fillInScanArgs(storage.dbDriverType, columnTypes)
+
+		err := rows.Scan(scanArgs...)
+		if err != nil {
+			storage.logger.Error().Err(err).Msg("Unable to scan row")
+			return err
+		}
+
+		err = writer.WriteRow(colNames, fillInMasterData(columnTypes, scanArgs))
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(writeOneRowToOutput)
+			return err
+		}
+	}
+
+	// reading can be interrupted by connection error or timeout
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+// exportCustomQueries method performs all configured custom queries and
+// stores their results by given function. Exit status is returned together
+// with error when any query or store fails.
+func (storage DBStorage) exportCustomQueries(ctx context.Context,
+	operationLogger *zerolog.Logger,
+	store func(objectName string, data []byte) (int, error)) (int, error) {
+	for _, name := range queryNames(storage.queries) {
+		operationLogger.Info().Str(queryNameMsg, name).Msg(exportingCustomQuery)
+
+		buffer := new(bytes.Buffer)
+		err := storage.StoreQueryResultIntoCSV(ctx, buffer, name, storage.queries[name])
+		if err != nil {
+			for _, l := range []*zerolog.Logger{&storage.logger, operationLogger} {
+				l.Err(err).Str(queryNameMsg, name).Msg(customQueryFailed)
+			}
+			if errors.Is(err, ErrQueryRejected) {
+				return ExitStatusConfigurationError, err
+			}
+			return ExitStatusStorageError, err
+		}
+
+		exitStatus, err := store(queryResultFileName(name), buffer.Bytes())
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/queries_test.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestStoreQueryResultIntoCSV checks the method StoreQueryResultIntoCSV
+func TestStoreQueryResultIntoCSV(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"org_id", "hits"})
+	rows.AddRow(1, 10)
+	rows.AddRow(2, 20)
+
+	// expected queries performed by tested method, the query is explained
+	// before it is performed, both in read-only transactions
+	mock.ExpectBegin()
+	mock.ExpectQuery("EXPLAIN SELECT org_id, count\\(\\*\\) AS hits FROM rule_hit GROUP BY org_id").
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow("HashAggregate"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT org_id, count\\(\\*\\) AS hits FROM rule_hit GROUP BY org_id").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	buffer := new(bytes.Buffer)
+	err := storage.StoreQueryResultIntoCSV(context.Background(), buffer, "hits",
+		"SELECT org_id, count(*) AS hits FROM rule_hit GROUP BY org_id")
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,hits\n1,10\n2,20\n", buffer.String())
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestStoreQueryResultIntoCSVQueryError checks the method
+// StoreQueryResultIntoCSV when the query fails
+func TestStoreQueryResultIntoCSVQueryError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// expected queries performed by tested method
+	mock.ExpectBegin()
+	mock.ExpectQuery("EXPLAIN SELECT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow("Result"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT 1").WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	err := storage.StoreQueryResultIntoCSV(context.Background(), new(bytes.Buffer),
+		"one", "SELECT 1")
+	assert.EqualError(t, err, "syntax error")
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// prepareDatabaseWithRuleHits helper function creates SQLite database file
+// with table rule_hit
+func prepareDatabaseWithRuleHits(t *testing.T) string {
+	return newSQLiteTestDatabase(t,
+		`CREATE TABLE rule_hit (org_id INTEGER, rule_fqdn VARCHAR)`,
+		`INSERT INTO rule_hit VALUES (1, 'a'), (1, 'b'), (2, 'a')`)
+}
+
+// TestPerformDataExportCustomQueries checks that results of custom queries
+// are exported into files
+func TestPerformDataExportCustomQueries(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithRuleHits(t),
+		},
+		Queries: main.QueriesConfiguration{
+			"hits_by_org": "SELECT org_id, count(*) AS hits FROM rule_hit GROUP BY org_id ORDER BY org_id",
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_query_hits_by_org.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,hits\n1,2\n2,1\n", string(content))
+}
+
+// TestPerformDataExportCustomQueriesSkipped checks that custom queries are
+// not performed when they are skipped
+func TestPerformDataExportCustomQueriesSkipped(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithRuleHits(t),
+		},
+		Queries: main.QueriesConfiguration{
+			"wrong": "SELECT * FROM nonexistent",
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		SkipArtifacts:   "queries",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// the query would fail if it were performed
+	cliFlags.SkipArtifacts = ""
+	code, err = main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusStorageError, code)
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains recording of query plans. Before content of
+// table is read, query reading the table is explained by database and the
+// plan together with estimated cost, number of rows and size of the table is
+// written into log and operation log. Planner context of slow exports is
+// then captured automatically. Plans are recorded only for tables with
+// estimated number of rows above configured threshold.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/queryplan.html
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// messages
+const (
+	queryPlanRecorded   = "Query plan of table"
+	explainQueryFailed  = "Unable to explain query reading table"
+	queryPlanMsg        = "query plan"
+	estimatedCostMsg    = "estimated cost"
+	estimatedRowsMsg    = "estimated rows"
+	tableSizeMsg        = "table size"
+	selectTableSizeStmt = "SELECT coalesce(pg_total_relation_size(to_regclass($1)), 0)"
+)
+
+// planEstimateRegexp matches estimates in the first line of PostgreSQL plan,
+// for example "Seq Scan on report  (cost=0.00..35.50 rows=2550 width=4)"
+var planEstimateRegexp = regexp.MustCompile(`cost=[0-9.]+\.\.([0-9.]+) rows=([0-9]+)`)
+
+// QueryPlan represents plan of query reading table content
+type QueryPlan struct {
+	// Plan is plan as it is displayed by database, one node per line
+	Plan string
+	// Estimated is set when database estimated cost and number of rows
+	// (SQLite does not)
+	Estimated bool
+	Cost      float64
+	Rows      int64
+	// SizeBytes is size of table including indexes and TOAST data
+	// (PostgreSQL only)
+	SizeBytes int64
+}
+
+// planEstimate function reads estimated total cost and number of rows from
+// the first line of PostgreSQL plan
+func planEstimate(line string) (float64, int64, bool) {
+	match := planEstimateRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return 0, 0, false
+	}
+
+	cost, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	rows, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return cost, rows, true
+}
+
+// ExplainQuery method asks database for plan of given query, the query is
+// not performed. Only one statement can be explained and it is explained in
+// read-only transaction, because the query can contain user provided SQL.
+func (storage DBStorage) ExplainQuery(ctx context.Context, sqlStatement string) (QueryPlan, error) {
+	var plan QueryPlan
+
+	_, err := singleStatementTokens(sqlStatement)
+	if err != nil {
+		return plan, err
+	}
+
+	explain := "EXPLAIN " + sqlStatement
+	if storage.dbDriverType == DBDriverSQLite3 {
+		explain = "EXPLAIN QUERY PLAN " + sqlStatement
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	var lines []string
+	err = storage.withReadOnlyTransaction(ctx, func(transaction *sql.Tx) error {
+		rows, err := transaction.QueryContext(ctx, explain)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err := rows.Close()
+			if err != nil {
+				storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+			}
+		}()
+
+		for rows.Next() {
+			var line string
+			if storage.dbDriverType == DBDriverSQLite3 {
+				// columns are id, parent, notused and detail
+				var id, parent, notUsed int
+				err = rows.Scan(&id, &parent, &notUsed, &line)
+			} else {
+				err = rows.Scan(&line)
+			}
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Plan = strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		plan.Cost, plan.Rows, plan.Estimated = planEstimate(lines[0])
+	}
+	return plan, nil
+}
+
+// readTableSize method reads size of given table, size is known for
+// PostgreSQL tables only
+func (storage DBStorage) readTableSize(ctx context.Context, tableName TableName) (int64, error) {
+	if storage.dbDriverType != DBDriverPostgres {
+		return 0, nil
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	var size int64
+	err := storage.connection.QueryRowContext(ctx, selectTableSizeStmt, string(tableName)).Scan(&size)
+	return size, err
+}
+
+// planRecorded method checks whether plan of table with given estimates is
+// recorded. Plans without estimates are recorded only when there is no
+// threshold.
+func (storage DBStorage) planRecorded(plan QueryPlan) bool {
+	threshold := int64(storage.config.ExplainMinRows)
+	if !plan.Estimated {
+		return threshold <= 0
+	}
+	return plan.Rows >= threshold
+}
+
+// explainTable method records plan of query that reads content of given
+// table into log and operation log. Failure to explain the query does not
+// stop the export, it is logged only. Messages logged by storage logger are
+// expected to contain table name already.
+func (storage DBStorage) explainTable(ctx context.Context, operationLogger *zerolog.Logger,
+	tableName TableName, limit int) {
+	if !storage.config.ExplainQueries {
+		return
+	}
+
+	sqlStatement, err := storage.tableContentQuery(ctx, tableName,
+		storage.tableLimit(tableName, limit))
+	if err == nil {
+		var plan QueryPlan
+		plan, err = storage.ExplainQuery(ctx, sqlStatement)
+		if err == nil && storage.planRecorded(plan) {
+			plan.SizeBytes, err = storage.readTableSize(ctx, tableName)
+			if err == nil {
+				tableLogger := operationLogger.With().
+					Str(tableNameMsg, string(tableName)).Logger()
+				logQueryPlan(&storage.logger, &tableLogger, plan)
+			}
+		}
+	}
+
+	if err != nil {
+		storage.logger.Warn().Err(err).Msg(explainQueryFailed)
+		operationLogger.Warn().Err(err).Str(tableNameMsg, string(tableName)).
+			Msg(explainQueryFailed)
+	}
+}
+
+// logQueryPlan function writes query plan into log and into operation log
+func logQueryPlan(logger, operationLogger *zerolog.Logger, plan QueryPlan) {
+	for _, l := range []*zerolog.Logger{logger, operationLogger} {
+		event := l.Info().Str(queryPlanMsg, plan.Plan)
+		if plan.Estimated {
+			event = event.
+				Float64(estimatedCostMsg, plan.Cost).
+				Int64(estimatedRowsMsg, plan.Rows)
+		}
+		if plan.SizeBytes > 0 {
+			event = event.Int64(tableSizeMsg, plan.SizeBytes)
+		}
+		event.Msg(queryPlanRecorded)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/queryplan_test.html
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestPlanEstimate checks the function PlanEstimate
+func TestPlanEstimate(t *testing.T) {
+	cost, rows, found := main.PlanEstimate("Seq Scan on report  (cost=0.00..35.50 rows=2550 width=4)")
+	assert.True(t, found)
+	assert.Equal(t, 35.5, cost)
+	assert.Equal(t, int64(2550), rows)
+
+	cost, rows, found = main.PlanEstimate(
+		"Limit  (cost=0.15..1024.75 rows=100 width=72)")
+	assert.True(t, found)
+	assert.Equal(t, 1024.75, cost)
+	assert.Equal(t, int64(100), rows)
+
+	_, _, found = main.PlanEstimate("SCAN TABLE report")
+	assert.False(t, found)
+}
+
+// TestExplainQuery checks the method ExplainQuery for SQLite database
+func TestExplainQuery(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareOrgSummaryDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	plan, err := storage.ExplainQuery(context.Background(), "SELECT * FROM report")
+	assert.NoError(t, err)
+	assert.Contains(t, plan.Plan, "SCAN")
+	assert.Contains(t, plan.Plan, "report")
+	// SQLite does not estimate cost of queries
+	assert.False(t, plan.Estimated)
+}
+
+// TestExplainQueryMultipleStatements checks that only one statement can be
+// explained, so no statement can be appended to explained query
+func TestExplainQueryMultipleStatements(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareOrgSummaryDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	_, err = storage.ExplainQuery(context.Background(),
+		"SELECT * FROM report; DELETE FROM report")
+	assert.EqualError(t, err, "only one statement can be used")
+
+	_, err = storage.ExplainQuery(context.Background(),
+		`SELECT E'\''; DELETE FROM report; --'`)
+	assert.EqualError(t, err, "only one statement can be used")
+
+	// nothing has been deleted
+	rows, err := storage.ReadTable(context.Background(), "report", 0)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rows)
+}
+
+// TestPerformDataExportQueryPlan checks that query plans are recorded into
+// operation log when enabled
+func TestPerformDataExportQueryPlan(t *testing.T) {
+	for _, explain := range []bool{true, false} {
+		configuration := main.ConfigStruct{
+			Storage: main.StorageConfiguration{
+				Driver:           "sqlite3",
+				SQLiteDataSource: prepareOrgSummaryDatabase(t),
+				ExplainQueries:   explain,
+			},
+		}
+
+		cliFlags := main.CliFlags{
+			Output:          "file",
+			OutputDirectory: t.TempDir(),
+			SkipArtifacts:   "sequences,constraints",
+		}
+
+		buffer := new(bytes.Buffer)
+		operationLogger := zerolog.New(buffer)
+
+		code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+			&log.Logger, &operationLogger, main.NewSummary(), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+
+		if explain {
+			assert.Contains(t, buffer.String(), `"message":"Query plan of table"`)
+			assert.Contains(t, buffer.String(), `"query plan":"SCAN`)
+		} else {
+			assert.NotContains(t, buffer.String(), "Query plan of table")
+		}
+	}
+}
+
+// TestPerformDataExportQueryPlanThreshold checks that plans without
+// estimates are not recorded when threshold of rows is set
+func TestPerformDataExportQueryPlanThreshold(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareOrgSummaryDatabase(t),
+			ExplainQueries:   true,
+			ExplainMinRows:   1000,
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: t.TempDir(),
+		SkipArtifacts:   "sequences,constraints",
+	}
+
+	buffer := new(bytes.Buffer)
+	operationLogger := zerolog.New(buffer)
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &operationLogger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	assert.NotContains(t, buffer.String(), "Query plan of table")
+}
@@ -0,0 +1,356 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains parallel reading of table content. Tables with
+// single column integer or UUID primary key are split into key ranges that
+// are read concurrently by more database connections. Rows read from all
+// ranges are passed in key order, range by range, while the following ranges
+// are read ahead into bounded buffers.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rangeread.html
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// query to read name and type of primary key columns of given table
+const selectPrimaryKey = `
+SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+  FROM pg_index i
+  JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+ WHERE i.indrelid = $1::regclass AND i.indisprimary`
+
+// types of primary key that can be split into ranges
+const (
+	smallintKeyType = "smallint"
+	integerKeyType  = "integer"
+	bigintKeyType   = "bigint"
+	uuidKeyType     = "uuid"
+)
+
+// rangePrefetchRows is maximal number of rows read ahead from each key range
+// while rows from previous ranges are being exported
+const rangePrefetchRows = 1000
+
+// messages
+const (
+	readingTableInRanges   = "Reading table in parallel key ranges"
+	noKeySuitableForRanges = "Table does not have key suitable for parallel or chunked read"
+	rangesInsteadOfChunks  = "Parallel readers configured, table is read in key ranges instead of chunks"
+	keyRangesMsg           = "Key ranges"
+)
+
+// keyRange represents condition selecting one range of primary key values
+type keyRange struct {
+	condition string
+	args      []interface{}
+}
+
+// rangeRows represents rows read from one key range. Error of the read is
+// set before the channel with rows is closed.
+type rangeRows struct {
+	rows chan M
+	err  error
+}
+
+// streamTableContent method reads the whole content of selected table and
+// passes rows one by one to given function. Table is read by one query and
+// rows are not kept in memory. When parallel readers are configured, table
+// with suitable primary key is read by more concurrent range queries and rows
+// are passed in key order, only limited number of rows is read ahead from
+// each range. When chunk size is configured, table with suitable primary key
+// is read in chunks instead. Parallel readers take precedence over chunks
+// when both are configured, chunks are used for exports with limit only.
+func (storage DBStorage) streamTableContent(ctx context.Context, tableName TableName,
+	limit int, process func(M) error) error {
+	limit = storage.tableLimit(tableName, limit)
+	readers := storage.config.ParallelReaders
+
+	// rows selected by limit would depend on order of ranges
+	parallel := readers > 1 && limit <= 0
+	chunked := storage.config.ChunkSize > 0
+
+	if !parallel && !chunked || storage.dbDriverType != DBDriverPostgres {
+		return storage.scanTable(ctx, tableName, limit, process)
+	}
+
+	keyColumn, keyType, err := storage.readPrimaryKey(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	switch keyType {
+	case smallintKeyType, integerKeyType, bigintKeyType, uuidKeyType:
+	default:
+		storage.logger.Info().Msg(noKeySuitableForRanges)
+		return storage.scanTable(ctx, tableName, limit, process)
+	}
+
+	if !parallel {
+		return storage.readTableInChunks(ctx, tableName, keyColumn, limit, process)
+	}
+
+	if chunked {
+		storage.logger.Info().Msg(rangesInsteadOfChunks)
+	}
+
+	var ranges []keyRange
+
+	switch keyType {
+	case smallintKeyType, integerKeyType, bigintKeyType:
+		ranges, err = storage.integerKeyRanges(ctx, tableName, keyColumn, readers)
+		if err != nil {
+			return err
+		}
+	case uuidKeyType:
+		ranges = uuidKeyRanges(readers)
+	}
+
+	return storage.readTableInRanges(ctx, tableName, keyColumn, ranges, process)
+}
+
+// readPrimaryKey method reads name and type of primary key of given table.
+// Empty strings are returned when the table does not have single column
+// primary key.
+func (storage DBStorage) readPrimaryKey(ctx context.Context, tableName TableName) (string, string, error) {
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectPrimaryKey, string(tableName))
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectPrimaryKey).Msg(sqlStatementExecutionError)
+		return "", "", err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	var columns, types []string
+
+	for rows.Next() {
+		var column, columnType string
+
+		err := rows.Scan(&column, &columnType)
+		if err != nil {
+			return "", "", err
+		}
+
+		columns = append(columns, column)
+		types = append(types, columnType)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return "", "", err
+	}
+
+	// composite keys are not split
+	if len(columns) != 1 {
+		return "", "", nil
+	}
+
+	return columns[0], types[0], nil
+}
+
+// whereOrAnd method returns keyword that joins next condition to query for
+// given table (org ID filter or watermark might have been applied already)
+func (storage DBStorage) whereOrAnd(tableName TableName) string {
+	if storage.orgIDFilterApplied(tableName) || storage.watermarkApplied(tableName) {
+		return " AND "
+	}
+	return " WHERE "
+}
+
+// integerKeyRanges method splits values of integer primary key into given
+// number of ranges with similar size
+func (storage DBStorage) integerKeyRanges(ctx context.Context, tableName TableName,
+	keyColumn string, count int) ([]keyRange, error) {
+	key := quoteIdentifier(keyColumn)
+
+	// it is not possible to use parameter for table name or a key
+	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G201
+	sqlStatement := fmt.Sprintf("SELECT min(%s), max(%s) FROM %s", key, key, string(tableName))
+	storage.applySelectiveExport(&sqlStatement, tableName)
+
+	var minimum, maximum sql.NullInt64
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	err := storage.connection.QueryRowContext(ctx, sqlStatement).Scan(&minimum, &maximum)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return nil, err
+	}
+
+	// table is empty
+	if !minimum.Valid || !maximum.Valid {
+		return nil, nil
+	}
+
+	return splitIntegerRange(minimum.Int64, maximum.Int64, count), nil
+}
+
+// splitIntegerRange function splits closed interval <minimum, maximum> into
+// given number of ranges. Unsigned arithmetic is used, so the whole int64
+// range can be split without overflow.
+func splitIntegerRange(minimum, maximum int64, count int) []keyRange {
+	span := uint64(maximum) - uint64(minimum)
+	step := span/uint64(count) + 1
+
+	var ranges []keyRange
+
+	for i := uint64(0); i < uint64(count); i++ {
+		offset := i * step
+		if offset > span {
+			break
+		}
+
+		lower := int64(uint64(minimum) + offset)
+
+		// the last range ends with maximum value
+		if step > span-offset || i == uint64(count)-1 {
+			ranges = append(ranges, keyRange{
+				condition: "%[1]s >= $1",
+				args:      []interface{}{lower},
+			})
+			break
+		}
+
+		upper := int64(uint64(minimum) + offset + step)
+		ranges = append(ranges, keyRange{
+			condition: "%[1]s >= $1 AND %[1]s < $2",
+			args:      []interface{}{lower, upper},
+		})
+	}
+
+	return ranges
+}
+
+// uuidKeyRanges function splits space of UUID values into given number of
+// ranges with similar size. UUIDs are compared byte by byte, so the ranges
+// are given by the first four bytes.
+func uuidKeyRanges(count int) []keyRange {
+	boundaries := make([]string, 0, count-1)
+	for i := 1; i < count; i++ {
+		prefix := (uint64(i) << 32) / uint64(count)
+		boundaries = append(boundaries, fmt.Sprintf("%08x-0000-0000-0000-000000000000", prefix))
+	}
+
+	ranges := make([]keyRange, 0, count)
+	for i := 0; i < count; i++ {
+		switch {
+		case i == 0:
+			ranges = append(ranges, keyRange{
+				condition: "%[1]s < $1",
+				args:      []interface{}{boundaries[0]},
+			})
+		case i == count-1:
+			ranges = append(ranges, keyRange{
+				condition: "%[1]s >= $1",
+				args:      []interface{}{boundaries[i-1]},
+			})
+		default:
+			ranges = append(ranges, keyRange{
+				condition: "%[1]s >= $1 AND %[1]s < $2",
+				args:      []interface{}{boundaries[i-1], boundaries[i]},
+			})
+		}
+	}
+
+	return ranges
+}
+
+// readTableInRanges method reads all given key ranges concurrently and
+// passes rows to given function in key order. Rows of the range being
+// passed are streamed, at most rangePrefetchRows rows are buffered for each
+// following range, so the table is never kept in memory as a whole.
+func (storage DBStorage) readTableInRanges(ctx context.Context, tableName TableName,
+	keyColumn string, ranges []keyRange, process func(M) error) error {
+	storage.logger.Info().
+		Int(keyRangesMsg, len(ranges)).
+		Msg(readingTableInRanges)
+
+	key := quoteIdentifier(keyColumn)
+
+	selectContent, err := storage.selectTableContent(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	// readers blocked on full buffers are stopped when rows are not passed
+	// anymore
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	results := make([]*rangeRows, len(ranges))
+
+	for i, r := range ranges {
+		sqlStatement := selectContent
+		storage.applySelectiveExport(&sqlStatement, tableName)
+		sqlStatement += storage.whereOrAnd(tableName) +
+			fmt.Sprintf(r.condition, key) + " ORDER BY " + key
+
+		results[i] = &rangeRows{rows: make(chan M, rangePrefetchRows)}
+
+		wg.Add(1)
+		go func(result *rangeRows, sqlStatement string, args []interface{}) {
+			defer wg.Done()
+			defer close(result.rows)
+			result.err = storage.scanRows(ctx, tableName, sqlStatement, func(row M) error {
+				select {
+				case result.rows <- row:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}, nil, args...)
+		}(results[i], sqlStatement, r.args)
+	}
+
+	for _, result := range results {
+		for row := range result.rows {
+			err := process(row)
+			if err != nil {
+				return err
+			}
+		}
+		if result.err != nil {
+			return result.err
+		}
+	}
+
+	return nil
+}
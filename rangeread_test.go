@@ -0,0 +1,351 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rangeread_test.html
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+const (
+	readPrimaryKeyQuery = "SELECT a.attname, format_type"
+	readMinMaxQuery     = `SELECT min\("id"\), max\("id"\) FROM table_name`
+)
+
+// parallelConfig returns storage configuration with given number of
+// parallel readers
+func parallelConfig(readers int) *main.StorageConfiguration {
+	config := testConfig
+	config.ParallelReaders = readers
+	return &config
+}
+
+// rangeRows is helper function to construct rows returned for one key range
+func rangeRows(mock sqlmock.Sqlmock, ids ...int) *sqlmock.Rows {
+	column1 := sqlmock.NewColumn("id").OfType("INT4", int64(0))
+	column2 := sqlmock.NewColumn("text").OfType("VARCHAR", "")
+
+	rows := mock.NewRowsWithColumnDefinition(column1, column2)
+	for _, id := range ids {
+		rows.AddRow(id, "row")
+	}
+	return rows
+}
+
+// writeTableContent is helper function that writes table content as CSV
+func writeTableContent(t *testing.T, storage *main.DBStorage, limit int) (string, error) {
+	buffer := new(bytes.Buffer)
+
+	writer, err := main.NewTableWriter("csv", buffer, "table_name", nil)
+	assert.NoError(t, err)
+
+	err = storage.WriteTableContent(context.Background(), writer, "table_name", []string{"id", "text"}, limit)
+	assert.NoError(t, writer.Flush())
+
+	return buffer.String(), err
+}
+
+// closeRangesConnection helper function closes mocked connection used by
+// readers of key ranges. Ranges can be read by one or more connections, so
+// closing of connections is not checked.
+func closeRangesConnection(connection *sql.DB) {
+	_ = connection.Close()
+}
+
+// TestReadTableInIntegerRanges checks that table with integer primary key is
+// read by more range queries and rows are merged in key order
+func TestReadTableInIntegerRanges(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	// range queries are performed concurrently
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+
+	minMaxRows := sqlmock.NewRows([]string{"min", "max"}).AddRow(1, 10)
+	mock.ExpectQuery(readMinMaxQuery).WillReturnRows(minMaxRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 AND "id" < \$2 ORDER BY "id"`).
+		WithArgs(1, 5).WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 AND "id" < \$2 ORDER BY "id"`).
+		WithArgs(5, 9).WillReturnRows(rangeRows(mock, 5, 8))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 ORDER BY "id"`).
+		WithArgs(9).WillReturnRows(rangeRows(mock, 10))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(3))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n5,row\n8,row\n10,row\n", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInIntegerRangesWithOrgFilter checks that range condition is
+// joined with organization ID filter
+func TestReadTableInIntegerRangesWithOrgFilter(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "bigint")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("report").WillReturnRows(keyRows)
+
+	minMaxRows := sqlmock.NewRows([]string{"min", "max"}).AddRow(7, 7)
+	mock.ExpectQuery(`SELECT min\("id"\), max\("id"\) FROM report WHERE org_id IN \('1','2'\)`).
+		WillReturnRows(minMaxRows)
+
+	mock.ExpectQuery(`SELECT \* FROM report WHERE org_id IN \('1','2'\) AND "id" >= \$1 ORDER BY "id"`).
+		WithArgs(7).WillReturnRows(rangeRows(mock, 7))
+	mock.ExpectClose()
+
+	config := parallelConfig(4)
+	config.EnableOrgIDFiltering = true
+	config.OrganizationsToExport = []string{"1", "2"}
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
+
+	buffer := new(bytes.Buffer)
+	writer, err := main.NewTableWriter("csv", buffer, "report", nil)
+	assert.NoError(t, err)
+
+	err = storage.WriteTableContent(context.Background(), writer, "report", []string{"id", "text"}, NoLimits)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Flush())
+	assert.Equal(t, "7,row\n", buffer.String())
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInIntegerRangesEmptyTable checks that no range query is
+// performed for empty table
+func TestReadTableInIntegerRangesEmptyTable(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+
+	minMaxRows := sqlmock.NewRows([]string{"min", "max"}).AddRow(nil, nil)
+	mock.ExpectQuery(readMinMaxQuery).WillReturnRows(minMaxRows)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(3))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInUUIDRanges checks that table with UUID primary key is split
+// by UUID prefixes
+func TestReadTableInUUIDRanges(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "uuid")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" < \$1 ORDER BY "id"`).
+		WithArgs("80000000-0000-0000-0000-000000000000").WillReturnRows(rangeRows(mock, 1))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 ORDER BY "id"`).
+		WithArgs("80000000-0000-0000-0000-000000000000").WillReturnRows(rangeRows(mock, 2))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(2))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInRangesNoSuitableKey checks that table with composite key is
+// read by one query
+func TestReadTableInRangesNoSuitableKey(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).
+		AddRow("org_id", "integer").
+		AddRow("cluster_id", "character varying(36)")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+	mock.ExpectQuery(`SELECT \* FROM table_name$`).WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(3))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInRangesWithLimit checks that table is read by one query when
+// limit is specified
+func TestReadTableInRangesWithLimit(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name LIMIT 1`).WillReturnRows(rangeRows(mock, 1))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(3))
+
+	output, err := writeTableContent(t, storage, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n", output)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadTableInRangesQueryError checks that error in one range query is
+// reported
+func TestReadTableInRangesQueryError(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "uuid")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" < \$1 ORDER BY "id"`).
+		WillReturnRows(rangeRows(mock, 1))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 ORDER BY "id"`).
+		WillReturnError(errors.New("range query error"))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(2))
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	assert.EqualError(t, err, "range query error")
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestSplitIntegerRange checks splitting of integer keys into ranges
+func TestSplitIntegerRange(t *testing.T) {
+	assert.Len(t, main.SplitIntegerRange(1, 10, 3), 3)
+	assert.Len(t, main.SplitIntegerRange(1, 2, 4), 2)
+	assert.Len(t, main.SplitIntegerRange(5, 5, 4), 1)
+	assert.Len(t, main.SplitIntegerRange(math.MinInt64, math.MaxInt64, 8), 8)
+}
+
+// rowIDs is helper function that returns given number of consecutive IDs
+func rowIDs(first, count int) []int {
+	ids := make([]int, count)
+	for i := range ids {
+		ids[i] = first + i
+	}
+	return ids
+}
+
+// TestReadTableInRangesMoreRowsThanPrefetched checks that rows of ranges
+// bigger than read-ahead buffers are passed in key order
+func TestReadTableInRangesMoreRowsThanPrefetched(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "uuid")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" < \$1 ORDER BY "id"`).
+		WillReturnRows(rangeRows(mock, rowIDs(1, 2500)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 ORDER BY "id"`).
+		WillReturnRows(rangeRows(mock, rowIDs(2501, 2500)...))
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(2))
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	assert.Len(t, lines, 5000)
+	for i, line := range lines {
+		assert.Equal(t, fmt.Sprintf("%d,row", i+1), line)
+	}
+
+	checkAllExpectations(t, mock)
+	closeRangesConnection(connection)
+}
+
+// failingWriter is writer that fails after given number of writes
+type failingWriter struct {
+	writes int
+}
+
+// Write method fails when all allowed writes have been performed
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes == 0 {
+		return 0, errors.New("write error")
+	}
+	w.writes--
+	return len(p), nil
+}
+
+// TestReadTableInRangesWriteError checks that readers of all ranges are
+// stopped when rows can't be written
+func TestReadTableInRangesWriteError(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.MatchExpectationsInOrder(false)
+
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "uuid")
+	mock.ExpectQuery(readPrimaryKeyQuery).WillReturnRows(keyRows)
+
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" < \$1 ORDER BY "id"`).
+		WillReturnRows(rangeRows(mock, rowIDs(1, 2500)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" >= \$1 ORDER BY "id"`).
+		WillReturnRows(rangeRows(mock, rowIDs(2501, 2500)...))
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, parallelConfig(2))
+
+	// write fails in the second range while its reader is blocked on full
+	// buffer (the first write is CREATE TABLE statement)
+	writer, err := main.NewTableWriter("sqldump", &failingWriter{writes: 1 + 2600}, "table_name", nil)
+	assert.NoError(t, err)
+
+	err = storage.WriteTableContent(context.Background(), writer, "table_name",
+		[]string{"id", "text"}, NoLimits)
+	assert.EqualError(t, err, "write error")
+
+	checkAllExpectations(t, mock)
+	closeRangesConnection(connection)
+}
@@ -0,0 +1,289 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains analysis of throughput of exported tables that
+// is performed when the run is finished. Settings that would make next runs
+// faster (number of parallel readers, chunk size, upload concurrency and
+// limits of tables dominating the run) are recommended in summary and in
+// log, so operators can converge on good settings without trial-and-error
+// across nightly runs.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/recommendations.html
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Thresholds used by the analysis of throughput
+const (
+	// tables exported in shorter time are never considered to be slow
+	slowTableDuration = 30 * time.Second
+
+	// time one chunk should be read in, shorter chunks are dominated by
+	// overhead of queries, longer chunks keep too many rows in memory
+	targetChunkDuration = 5 * time.Second
+
+	// chunk size is recommended only when it differs from the configured
+	// one at least by this factor
+	chunkSizeTolerance = 2.0
+
+	// share of the run (in percents) a stage or a table needs to take to
+	// be considered as bottleneck
+	bottleneckShare = 50.0
+
+	// upper bounds of recommended numbers of workers
+	maxRecommendedReaders     = 16
+	maxRecommendedConcurrency = 16
+)
+
+// Names of options that can be recommended
+const (
+	parallelReadersOption   = "parallel_readers"
+	chunkSizeOption         = "chunk_size"
+	uploadConcurrencyOption = "upload_concurrency"
+	limitsOption            = "limits"
+)
+
+// messages
+const (
+	tuningRecommendationMsg = "Tuning recommendation"
+	optionMsg               = "option"
+	currentValueMsg         = "current"
+	suggestedValueMsg       = "suggested"
+)
+
+// Recommendation represents one setting recommended to be changed for next
+// runs together with the reason of the change
+type Recommendation struct {
+	Option    string `json:"option"`
+	Current   int    `json:"current"`
+	Suggested int    `json:"suggested"`
+	Reason    string `json:"reason"`
+}
+
+// String method returns human readable representation of recommendation
+// used in summary table
+func (recommendation Recommendation) String() string {
+	return fmt.Sprintf("%s: %d -> %d (%s)", recommendation.Option,
+		recommendation.Current, recommendation.Suggested, recommendation.Reason)
+}
+
+// roundSuggestion function rounds recommended value to two significant
+// digits, so recommendations do not change with every small fluctuation of
+// throughput
+func roundSuggestion(value float64) int {
+	if value < 1 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(value))-1)
+	if magnitude < 1 {
+		magnitude = 1
+	}
+	return int(math.Round(value/magnitude) * magnitude)
+}
+
+// slowTables function returns throughputs of all tables exported in time
+// that is long enough to be tuned
+func slowTables(throughputs []TableThroughput) []TableThroughput {
+	var slow []TableThroughput
+	for _, throughput := range throughputs {
+		if throughput.Duration >= slowTableDuration && throughput.Rows > 0 {
+			slow = append(slow, throughput)
+		}
+	}
+	return slow
+}
+
+// recommendParallelReaders function recommends number of readers of one
+// table when the slowest table can't be exported in reasonable time
+func recommendParallelReaders(slow []TableThroughput, storageConfiguration StorageConfiguration) []Recommendation {
+	if len(slow) == 0 || storageConfiguration.PGCopy {
+		return nil
+	}
+
+	longest := slow[0]
+	for _, throughput := range slow[1:] {
+		if throughput.Duration > longest.Duration {
+			longest = throughput
+		}
+	}
+
+	current := storageConfiguration.ParallelReaders
+	readers := current
+	if readers < 1 {
+		readers = 1
+	}
+
+	// throughput of table is expected to scale with number of readers
+	suggested := int(math.Ceil(float64(readers) * float64(longest.Duration) /
+		float64(slowTableDuration)))
+	if suggested > maxRecommendedReaders {
+		suggested = maxRecommendedReaders
+	}
+	if suggested <= readers {
+		return nil
+	}
+
+	return []Recommendation{{
+		Option:    parallelReadersOption,
+		Current:   current,
+		Suggested: suggested,
+		Reason: fmt.Sprintf("table %s exported in %v",
+			longest.Table, longest.Duration.Round(time.Second)),
+	}}
+}
+
+// recommendChunkSize function recommends chunk size that makes reading of
+// one chunk of slow tables take approximately the target duration
+func recommendChunkSize(slow []TableThroughput, storageConfiguration StorageConfiguration) []Recommendation {
+	// chunk size is tuned by row width already or chunks are not used
+	if len(slow) == 0 || storageConfiguration.ChunkTargetBytes > 0 || storageConfiguration.PGCopy {
+		return nil
+	}
+
+	// the slowest throughput is used, so no chunk takes longer than target
+	slowest := slow[0]
+	for _, throughput := range slow[1:] {
+		if throughput.RowsPerSecond() < slowest.RowsPerSecond() {
+			slowest = throughput
+		}
+	}
+
+	suggested := clampChunkSize(int64(roundSuggestion(
+		slowest.RowsPerSecond() * targetChunkDuration.Seconds())))
+
+	current := storageConfiguration.ChunkSize
+	if current > 0 {
+		ratio := float64(suggested) / float64(current)
+		if ratio < chunkSizeTolerance && ratio > 1/chunkSizeTolerance {
+			return nil
+		}
+	}
+
+	return []Recommendation{{
+		Option:    chunkSizeOption,
+		Current:   current,
+		Suggested: suggested,
+		Reason: fmt.Sprintf("%.0f rows per second read from table %s",
+			slowest.RowsPerSecond(), slowest.Table),
+	}}
+}
+
+// recommendUploadConcurrency function recommends more concurrent uploads
+// of parts when upload dominates the run
+func recommendUploadConcurrency(summary *Summary, s3Configuration S3Configuration) []Recommendation {
+	upload := summary.Duration(stageUpload)
+	uploadShare := share(upload, summary.TotalDuration())
+	if upload < slowTableDuration || uploadShare < bottleneckShare {
+		return nil
+	}
+
+	current := s3Configuration.UploadConcurrency
+	suggested := 2 * current
+	if suggested < 2 {
+		suggested = 2
+	}
+	if suggested > maxRecommendedConcurrency {
+		suggested = maxRecommendedConcurrency
+	}
+	if suggested <= current {
+		return nil
+	}
+
+	return []Recommendation{{
+		Option:    uploadConcurrencyOption,
+		Current:   current,
+		Suggested: suggested,
+		Reason:    fmt.Sprintf("upload took %.1f%% of the run", uploadShare),
+	}}
+}
+
+// recommendLimits function recommends limits of tables that dominate the
+// run, so quick runs (tests of configuration, samples) are not stalled by
+// them
+func recommendLimits(slow []TableThroughput, total time.Duration, limits LimitsConfiguration) []Recommendation {
+	var recommendations []Recommendation
+
+	for _, throughput := range slow {
+		if _, found := limits[string(throughput.Table)]; found {
+			continue
+		}
+		tableShare := share(throughput.Duration, total)
+		if tableShare < bottleneckShare {
+			continue
+		}
+
+		// number of rows that is exported in time of slow table
+		suggested := roundSuggestion(throughput.RowsPerSecond() * slowTableDuration.Seconds())
+		if suggested >= throughput.Rows {
+			continue
+		}
+
+		recommendations = append(recommendations, Recommendation{
+			Option:    limitsOption + "." + string(throughput.Table),
+			Current:   0,
+			Suggested: suggested,
+			Reason:    fmt.Sprintf("table took %.1f%% of the run", tableShare),
+		})
+	}
+
+	return recommendations
+}
+
+// recommendTuning function analyzes throughput of all tables exported by
+// finished run and returns settings recommended for next runs. Nothing is
+// recommended when the run has not revealed any bottleneck.
+func recommendTuning(summary *Summary, configuration *ConfigStruct) []Recommendation {
+	storageConfiguration := GetStorageConfiguration(configuration)
+	s3Configuration := GetS3Configuration(configuration)
+
+	slow := slowTables(summary.Throughputs())
+
+	var recommendations []Recommendation
+	recommendations = append(recommendations,
+		recommendParallelReaders(slow, storageConfiguration)...)
+	recommendations = append(recommendations,
+		recommendChunkSize(slow, storageConfiguration)...)
+	recommendations = append(recommendations,
+		recommendUploadConcurrency(summary, s3Configuration)...)
+	recommendations = append(recommendations,
+		recommendLimits(slow, summary.TotalDuration(), GetLimitsConfiguration(configuration))...)
+
+	return recommendations
+}
+
+// logRecommendations function logs all tuning recommendations, so they are
+// available even when summary table is not printed
+func logRecommendations(logger *zerolog.Logger, recommendations []Recommendation) {
+	for _, recommendation := range recommendations {
+		logger.Info().
+			Str(optionMsg, recommendation.Option).
+			Int(currentValueMsg, recommendation.Current).
+			Int(suggestedValueMsg, recommendation.Suggested).
+			Str(reasonMsg, recommendation.Reason).
+			Msg(tuningRecommendationMsg)
+	}
+}
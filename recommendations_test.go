@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/recommendations_test.html
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestRecommendTuningFastTables checks that nothing is recommended when all
+// tables are exported quickly
+func TestRecommendTuningFastTables(t *testing.T) {
+	summary := main.NewSummary()
+	summary.RecordTableThroughput("report", 1000, time.Second)
+	summary.RecordTableThroughput("rule_hit", 5000, 2*time.Second)
+	summary.Finish()
+
+	assert.Empty(t, main.RecommendTuning(summary, &main.ConfigStruct{}))
+}
+
+// TestRecommendTuningSlowTable checks recommendations made for slow table
+// exported with default settings
+func TestRecommendTuningSlowTable(t *testing.T) {
+	summary := main.NewSummary()
+	summary.RecordTableThroughput("report", 120000, time.Minute)
+	summary.RecordTableThroughput("rule_hit", 100, time.Second)
+	summary.Finish()
+
+	recommendations := main.RecommendTuning(summary, &main.ConfigStruct{})
+
+	assert.Equal(t, []main.Recommendation{
+		{
+			Option:    "parallel_readers",
+			Current:   0,
+			Suggested: 2,
+			Reason:    "table report exported in 1m0s",
+		},
+		{
+			Option:    "chunk_size",
+			Current:   0,
+			Suggested: 10000,
+			Reason:    "2000 rows per second read from table report",
+		},
+		{
+			Option:    "limits.report",
+			Current:   0,
+			Suggested: 60000,
+			Reason:    recommendations[2].Reason,
+		},
+	}, recommendations)
+}
+
+// TestRecommendTuningConfiguredSettings checks that settings are
+// recommended relatively to configured ones
+func TestRecommendTuningConfiguredSettings(t *testing.T) {
+	summary := main.NewSummary()
+	summary.RecordTableThroughput("report", 74040, time.Minute)
+	summary.Finish()
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			ParallelReaders: 4,
+			ChunkSize:       1000,
+		},
+		Limits: main.LimitsConfiguration{
+			"report": 100000,
+		},
+	}
+
+	recommendations := main.RecommendTuning(summary, &configuration)
+	assert.Len(t, recommendations, 2)
+
+	assert.Equal(t, "parallel_readers", recommendations[0].Option)
+	assert.Equal(t, 4, recommendations[0].Current)
+	assert.Equal(t, 8, recommendations[0].Suggested)
+
+	// suggested chunk size is rounded to two significant digits
+	assert.Equal(t, "chunk_size", recommendations[1].Option)
+	assert.Equal(t, 1000, recommendations[1].Current)
+	assert.Equal(t, 6200, recommendations[1].Suggested)
+}
+
+// TestRecommendTuningChunkSizeWithinTolerance checks that chunk size close
+// to the recommended one is not changed
+func TestRecommendTuningChunkSizeWithinTolerance(t *testing.T) {
+	summary := main.NewSummary()
+	summary.RecordTableThroughput("report", 60000, 30*time.Second)
+	summary.Finish()
+
+	for _, storage := range []main.StorageConfiguration{
+		{ChunkSize: 8000},
+		{ChunkSize: 100, ChunkTargetBytes: 1024 * 1024},
+	} {
+		configuration := main.ConfigStruct{
+			Storage: storage,
+			Limits:  main.LimitsConfiguration{"report": 1000},
+		}
+		assert.Empty(t, main.RecommendTuning(summary, &configuration))
+	}
+}
+
+// TestRecommendTuningUploadConcurrency checks that more concurrent uploads
+// are recommended when upload dominates the run
+func TestRecommendTuningUploadConcurrency(t *testing.T) {
+	summary := main.NewSummary()
+	summary.AddDuration(main.StageUpload, 40*time.Second)
+	summary.Finish()
+
+	recommendations := main.RecommendTuning(summary, &main.ConfigStruct{})
+	assert.Len(t, recommendations, 1)
+	assert.Equal(t, "upload_concurrency", recommendations[0].Option)
+	assert.Equal(t, 2, recommendations[0].Suggested)
+
+	configuration := main.ConfigStruct{
+		S3: main.S3Configuration{UploadConcurrency: 12},
+	}
+	recommendations = main.RecommendTuning(summary, &configuration)
+	assert.Len(t, recommendations, 1)
+	assert.Equal(t, 16, recommendations[0].Suggested)
+
+	configuration.S3.UploadConcurrency = 16
+	assert.Empty(t, main.RecommendTuning(summary, &configuration))
+}
+
+// TestPrintSummaryRecommendations checks that tuning recommendations are
+// printed only when there are any
+func TestPrintSummaryRecommendations(t *testing.T) {
+	summary := main.NewSummary()
+	summary.Finish()
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.NotContains(t, buffer.String(), "Tuning recommendations")
+
+	summary.SetRecommendations([]main.Recommendation{{
+		Option:    "chunk_size",
+		Current:   1000,
+		Suggested: 5000,
+		Reason:    "1000 rows per second read from table report",
+	}})
+
+	buffer.Reset()
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.Contains(t, buffer.String(), "Tuning recommendations:\n"+
+		"  chunk_size: 1000 -> 5000 (1000 rows per second read from table report)\n")
+}
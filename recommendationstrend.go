@@ -0,0 +1,283 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains time series of recommendations. Rule hits and
+// rules disabled by users are counted per day or per week over configured
+// window, so trends of recommendations can be followed without building
+// own pipeline on top of raw table dumps.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/recommendationstrend.html
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recommendationsTrendFile is name of file or object with time series of
+// rule hits and disabled rules
+const recommendationsTrendFile = "_recommendations_trend.csv"
+
+// periods rule hits and disabled rules are counted by
+const (
+	dailyTrend  = "day"
+	weeklyTrend = "week"
+)
+
+// defaultTrendWindow is window of time series when it is not configured
+const defaultTrendWindow = 30 * 24 * time.Hour
+
+// layouts of days and timestamps used in queries and in the report
+const (
+	trendDayLayout       = "2006-01-02"
+	trendTimestampLayout = "2006-01-02 15:04:05"
+)
+
+// messages
+const (
+	readRecommendationsTrendFailed  = "Read trend of recommendations failed"
+	storeRecommendationsTrendFailed = "Store trend of recommendations failed"
+	exportingRecommendationsTrend   = "Exporting trend of recommendations"
+	unknownTrendPeriod              = "unknown trend period %q, day or week can be used"
+)
+
+// Keys of objects in time series written as JSON or NDJSON, they are used
+// as CSV header too
+const (
+	periodKey   = "period"
+	hitsKey     = "hits"
+	disablesKey = "disables"
+)
+
+// selectDailyRuleHits counts rule hits created each day since given time.
+// Expression returning day of timestamp and filter of organizations are
+// inserted into the query.
+const selectDailyRuleHits = `
+           SELECT %s AS day, rule_fqdn, error_key, count(*)
+             FROM rule_hit
+            WHERE created_at >= $1%s
+            GROUP BY 1, rule_fqdn, error_key;
+`
+
+// selectDailyRuleDisables counts rules disabled by users each day since
+// given time. Expression returning day of timestamp and filter of
+// organizations are inserted into the query.
+const selectDailyRuleDisables = `
+           SELECT %s AS day, rule_id, error_key, count(*)
+             FROM rule_disable
+            WHERE created_at >= $1%s
+            GROUP BY 1, rule_id, error_key;
+`
+
+// TrendPoint contains numbers of hits and disables of one rule in one period
+// (day or week starting on Monday)
+type TrendPoint struct {
+	Period   string
+	Rule     string
+	ErrorKey string
+	Hits     int
+	Disables int
+}
+
+// checkTrendPeriod function checks period time series are counted by
+func checkTrendPeriod(period string) error {
+	switch period {
+	case "", dailyTrend, weeklyTrend:
+		return nil
+	default:
+		return fmt.Errorf(unknownTrendPeriod, period)
+	}
+}
+
+// trendPeriodStart function returns beginning of period (day or week
+// starting on Monday) given time belongs to
+func trendPeriodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period != weeklyTrend {
+		return day
+	}
+	// Sunday is the last day of week
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// trendWindowStart function returns beginning of time series with given
+// window ending at given time. The first period is always complete.
+func trendWindowStart(now time.Time, window time.Duration, period string) time.Time {
+	if window <= 0 {
+		window = defaultTrendWindow
+	}
+	return trendPeriodStart(now.UTC().Add(-window), period)
+}
+
+// dayExpression method returns SQL expression converting timestamp column
+// into day in format YYYY-MM-DD
+func (storage DBStorage) dayExpression(column string) string {
+	if storage.dbDriverType == DBDriverSQLite3 {
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d', %s)", column)
+	}
+	return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD')", column)
+}
+
+// readDailyCounts method reads numbers of records created each day since
+// given time by one of queries counting rule hits or disables. Counts are
+// added into given points, days are merged into periods.
+func (storage DBStorage) readDailyCounts(ctx context.Context, query, tableName string,
+	since time.Time, period string, points map[TrendPoint]*TrendPoint,
+	add func(*TrendPoint, int)) error {
+	// only selected organizations are reported
+	filter := ""
+	if storage.orgIDFilterApplied(TableName(tableName)) {
+		filter = fmt.Sprintf(" AND org_id IN ('%v')",
+			strings.Join(storage.config.OrganizationsToExport, "','"))
+	}
+	sqlStatement := fmt.Sprintf(query, storage.dayExpression("created_at"), filter)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement,
+		since.Format(trendTimestampLayout))
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			day   string
+			key   TrendPoint
+			count int
+		)
+
+		err := rows.Scan(&day, &key.Rule, &key.ErrorKey, &count)
+		if err != nil {
+			return err
+		}
+
+		parsed, err := time.Parse(trendDayLayout, day)
+		if err != nil {
+			return err
+		}
+		key.Period = trendPeriodStart(parsed, period).Format(trendDayLayout)
+
+		point, found := points[key]
+		if !found {
+			point = &TrendPoint{Period: key.Period, Rule: key.Rule, ErrorKey: key.ErrorKey}
+			points[key] = point
+		}
+		add(point, count)
+	}
+
+	return rows.Err()
+}
+
+// ReadRecommendationsTrend method reads numbers of rule hits and rules
+// disabled by users in each period since given time. Points are ordered by
+// period, rule and error key.
+func (storage DBStorage) ReadRecommendationsTrend(ctx context.Context, since time.Time,
+	period string) ([]TrendPoint, error) {
+	points := make(map[TrendPoint]*TrendPoint)
+
+	err := storage.readDailyCounts(ctx, selectDailyRuleHits, "rule_hit", since,
+		period, points, func(point *TrendPoint, count int) {
+			point.Hits += count
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.readDailyCounts(ctx, selectDailyRuleDisables, "rule_disable", since,
+		period, points, func(point *TrendPoint, count int) {
+			point.Disables += count
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	trend := make([]TrendPoint, 0, len(points))
+	for _, point := range points {
+		trend = append(trend, *point)
+	}
+	sort.Slice(trend, func(i, j int) bool {
+		if trend[i].Period != trend[j].Period {
+			return trend[i].Period < trend[j].Period
+		}
+		if trend[i].Rule != trend[j].Rule {
+			return trend[i].Rule < trend[j].Rule
+		}
+		return trend[i].ErrorKey < trend[j].ErrorKey
+	})
+
+	return trend, nil
+}
+
+// writeRecommendationsTrend function exports time series of recommendations
+// in report format for selected output format
+func writeRecommendationsTrend(buffer io.Writer, trend []TrendPoint, format string) error {
+	rows := make([]M, 0, len(trend))
+	for _, point := range trend {
+		rows = append(rows, M{
+			periodKey:   point.Period,
+			ruleKey:     point.Rule,
+			errorKeyKey: point.ErrorKey,
+			hitsKey:     point.Hits,
+			disablesKey: point.Disables,
+		})
+	}
+
+	return writeReport(buffer, reportFormat(format),
+		[]string{periodKey, ruleKey, errorKeyKey, hitsKey, disablesKey}, rows)
+}
+
+// recommendationsTrendReport method reads time series of recommendations
+// over configured window and converts it into report format. Exit status is
+// returned together with error.
+func (storage DBStorage) recommendationsTrendReport(ctx context.Context, format string) ([]byte, int, error) {
+	since := trendWindowStart(time.Now(), storage.trendWindow, storage.trendPeriod)
+
+	trend, err := storage.ReadRecommendationsTrend(ctx, since, storage.trendPeriod)
+	if err != nil {
+		storage.logger.Err(err).Msg(readRecommendationsTrendFailed)
+		return nil, ExitStatusStorageError, err
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	err = writeRecommendationsTrend(buffer, trend, format)
+	if err != nil {
+		return nil, ExitStatusIOError, err
+	}
+
+	// buffer is returned into pool
+	return append([]byte(nil), buffer.Bytes()...), ExitStatusOK, nil
+}
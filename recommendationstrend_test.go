@@ -0,0 +1,179 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/recommendationstrend_test.html
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareTrendDatabase function creates SQLite database with rule hits and
+// disabled rules created at given days
+func prepareTrendDatabase(t *testing.T, days []string) string {
+	statements := []string{
+		`CREATE TABLE rule_hit (org_id INTEGER, cluster_id VARCHAR, rule_fqdn VARCHAR,
+		     error_key VARCHAR, template_data VARCHAR, created_at TIMESTAMP)`,
+		`CREATE TABLE rule_disable (org_id INTEGER, user_id VARCHAR, rule_id VARCHAR,
+		     error_key VARCHAR, justification VARCHAR, created_at TIMESTAMP, updated_at TIMESTAMP)`,
+		fmt.Sprintf(`INSERT INTO rule_hit VALUES
+		     (1, 'c1', 'rule1', 'KEY1', '{}', '%[1]s 10:00:00'),
+		     (1, 'c2', 'rule1', 'KEY1', '{}', '%[1]s 11:00:00'),
+		     (2, 'c3', 'rule1', 'KEY1', '{}', '%[2]s 08:00:00'),
+		     (2, 'c3', 'rule2', 'KEY2', '{}', '%[3]s 08:00:00'),
+		     (2, 'c4', 'rule2', 'KEY2', '{}', '%[4]s 08:00:00')`,
+			days[0], days[1], days[2], days[3]),
+		fmt.Sprintf(`INSERT INTO rule_disable VALUES
+		     (1, 'u1', 'rule1', 'KEY1', 'noisy', '%[2]s 12:00:00', NULL),
+		     (2, 'u2', 'rule2', 'KEY2', 'noisy', '%[4]s 12:00:00', NULL)`,
+			days[0], days[1], days[2], days[3]),
+	}
+
+	return newSQLiteTestDatabase(t, statements...)
+}
+
+// TestTrendWindowStart checks the function TrendWindowStart
+func TestTrendWindowStart(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 1, 17, 15, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+		main.TrendWindowStart(now, 7*24*time.Hour, "day"))
+	assert.Equal(t, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+		main.TrendWindowStart(now, 7*24*time.Hour, "week"))
+
+	// 30 days by default
+	assert.Equal(t, time.Date(2023, 12, 18, 0, 0, 0, 0, time.UTC),
+		main.TrendWindowStart(now, 0, ""))
+}
+
+// TestCheckTrendPeriod checks the function CheckTrendPeriod
+func TestCheckTrendPeriod(t *testing.T) {
+	assert.NoError(t, main.CheckTrendPeriod(""))
+	assert.NoError(t, main.CheckTrendPeriod("day"))
+	assert.NoError(t, main.CheckTrendPeriod("week"))
+	assert.EqualError(t, main.CheckTrendPeriod("month"),
+		`unknown trend period "month", day or week can be used`)
+}
+
+// TestReadRecommendationsTrend checks the method ReadRecommendationsTrend
+func TestReadRecommendationsTrend(t *testing.T) {
+	// 2024-01-07 is Sunday
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver: "sqlite3",
+		SQLiteDataSource: prepareTrendDatabase(t,
+			[]string{"2023-12-31", "2024-01-07", "2024-01-08", "2024-01-09"}),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	trend, err := storage.ReadRecommendationsTrend(context.Background(), since, "day")
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TrendPoint{
+		{Period: "2024-01-07", Rule: "rule1", ErrorKey: "KEY1", Hits: 1, Disables: 1},
+		{Period: "2024-01-08", Rule: "rule2", ErrorKey: "KEY2", Hits: 1},
+		{Period: "2024-01-09", Rule: "rule2", ErrorKey: "KEY2", Hits: 1, Disables: 1},
+	}, trend)
+
+	trend, err = storage.ReadRecommendationsTrend(context.Background(), since, "week")
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TrendPoint{
+		{Period: "2024-01-01", Rule: "rule1", ErrorKey: "KEY1", Hits: 1, Disables: 1},
+		{Period: "2024-01-08", Rule: "rule2", ErrorKey: "KEY2", Hits: 2, Disables: 1},
+	}, trend)
+}
+
+// TestReadRecommendationsTrendOrgFilter checks that only selected
+// organizations are counted
+func TestReadRecommendationsTrendOrgFilter(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver: "sqlite3",
+		SQLiteDataSource: prepareTrendDatabase(t,
+			[]string{"2024-01-01", "2024-01-01", "2024-01-01", "2024-01-01"}),
+		EnableOrgIDFiltering:  true,
+		OrganizationsToExport: []string{"1"},
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	trend, err := storage.ReadRecommendationsTrend(context.Background(),
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "day")
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TrendPoint{
+		{Period: "2024-01-01", Rule: "rule1", ErrorKey: "KEY1", Hits: 2, Disables: 1},
+	}, trend)
+}
+
+// TestPerformDataExportRecommendationsTrend checks that trend of
+// recommendations over configured window is exported when requested
+func TestPerformDataExportRecommendationsTrend(t *testing.T) {
+	today := time.Now().UTC()
+	day := func(daysAgo int) string {
+		return today.AddDate(0, 0, -daysAgo).Format("2006-01-02")
+	}
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareTrendDatabase(t, []string{day(10), day(3), day(2), day(1)}),
+		},
+		Export: main.ExportConfiguration{
+			TrendWindow: 5 * 24 * time.Hour,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportTrend:     true,
+		SkipArtifacts:   "sequences,constraints",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// rule hits older than window are not counted
+	content, err := os.ReadFile(filepath.Join(directory, "_recommendations_trend.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "period,rule,error_key,hits,disables\n"+
+		day(3)+",rule1,KEY1,1,1\n"+
+		day(2)+",rule2,KEY2,1,0\n"+
+		day(1)+",rule2,KEY2,1,1\n", string(content))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains check of replication lag performed before the
+// export starts. When the exporter is configured against standby (replica)
+// database, data exported from lagging replica could be hours old without
+// anyone noticing, so the export is aborted (or warning is logged) when the
+// lag exceeds configured threshold.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/replicalag.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// Supported actions performed when replication lag exceeds threshold
+const (
+	abortOnReplicaLag = "abort"
+	warnOnReplicaLag  = "warn"
+)
+
+// messages
+const (
+	unknownReplicaLagAction = "Unknown action on replication lag: %s"
+	replicaLagChecked       = "Replication lag checked"
+	replicaLagThresholdMsg  = "threshold"
+	replicaLagExceeded      = "replication lag %v exceeds threshold %v"
+	staleReplica            = "Database replica is stale, exported data will be outdated"
+)
+
+// errStaleReplica is returned when the export is aborted because of
+// replication lag
+var errStaleReplica = errors.New("database replica is stale")
+
+// checkReplicaLagAction function checks if given action performed on
+// replication lag is supported. Empty value means that the export is aborted.
+func checkReplicaLagAction(action string) error {
+	switch action {
+	case "", abortOnReplicaLag, warnOnReplicaLag:
+		return nil
+	default:
+		return fmt.Errorf(unknownReplicaLagAction, action)
+	}
+}
+
+// checkReplicaLag function checks replication lag of database against
+// threshold from storage configuration. Error is returned when the lag
+// exceeds the threshold and the export needs to be aborted.
+func checkReplicaLag(ctx context.Context, storage *DBStorage,
+	operationLogger *zerolog.Logger) error {
+	threshold := storage.config.ReplicaLagThreshold
+
+	// lag is checked on PostgreSQL only
+	if threshold <= 0 || storage.dbDriverType != DBDriverPostgres {
+		return nil
+	}
+
+	lag, err := storage.ReadReplicationLag(ctx)
+	if err != nil {
+		return err
+	}
+
+	storage.logger.Info().
+		Dur(replicationLagMsg, lag).
+		Dur(replicaLagThresholdMsg, threshold).
+		Msg(replicaLagChecked)
+
+	if lag <= threshold {
+		return nil
+	}
+
+	if storage.config.ReplicaLagAction == warnOnReplicaLag {
+		for _, l := range []*zerolog.Logger{&storage.logger, operationLogger} {
+			l.Warn().
+				Dur(replicationLagMsg, lag).
+				Dur(replicaLagThresholdMsg, threshold).
+				Msg(staleReplica)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: "+replicaLagExceeded, errStaleReplica, lag, threshold)
+}
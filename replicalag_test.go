@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/replicalag_test.html
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// replicaLagConfig returns storage configuration with given replication lag
+// threshold and action
+func replicaLagConfig(threshold time.Duration, action string) *main.StorageConfiguration {
+	config := testConfig
+	config.ReplicaLagThreshold = threshold
+	config.ReplicaLagAction = action
+	return &config
+}
+
+// TestCheckReplicaLagAction checks the function checkReplicaLagAction
+func TestCheckReplicaLagAction(t *testing.T) {
+	for _, action := range []string{"", "abort", "warn"} {
+		assert.NoError(t, main.CheckReplicaLagAction(action))
+	}
+	assert.EqualError(t, main.CheckReplicaLagAction("ignore"),
+		"Unknown action on replication lag: ignore")
+}
+
+// TestCheckReplicaLagDisabled checks that lag is not read when the check is
+// disabled or when database is not PostgreSQL
+func TestCheckReplicaLagDisabled(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, replicaLagConfig(0, ""))
+	assert.NoError(t, main.CheckReplicaLag(context.Background(), storage, &log.Logger))
+
+	storage = main.NewFromConnection(connection, main.DBDriverSQLite3, replicaLagConfig(time.Minute, ""))
+	assert.NoError(t, main.CheckReplicaLag(context.Background(), storage, &log.Logger))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckReplicaLagWithinThreshold checks that export continues when the
+// lag does not exceed the threshold
+func TestCheckReplicaLagWithinThreshold(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(30))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, replicaLagConfig(time.Minute, ""))
+	assert.NoError(t, main.CheckReplicaLag(context.Background(), storage, &log.Logger))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckReplicaLagAbort checks that export is aborted when the lag
+// exceeds the threshold
+func TestCheckReplicaLagAbort(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(7200))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, replicaLagConfig(time.Hour, "abort"))
+	err := main.CheckReplicaLag(context.Background(), storage, &log.Logger)
+	assert.ErrorIs(t, err, main.ErrStaleReplica)
+	assert.EqualError(t, err, "database replica is stale: replication lag 2h0m0s exceeds threshold 1h0m0s")
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckReplicaLagWarn checks that only warning is logged when it is
+// configured
+func TestCheckReplicaLagWarn(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(7200))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, replicaLagConfig(time.Hour, "warn"))
+	assert.NoError(t, main.CheckReplicaLag(context.Background(), storage, &log.Logger))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
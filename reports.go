@@ -0,0 +1,255 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions to write reports about exported data
+// (list of rules disabled by more users and metadata about tables) in format
+// selected by -format command line option.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/reports.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Keys of objects in reports written as JSON or NDJSON
+const (
+	ruleKey      = "rule"
+	countKey     = "count"
+	tableNameKey = "table_name"
+	recordsKey   = "records"
+)
+
+// reportFormat function returns format of reports for selected output
+// format. Reports are written as JSON or NDJSON when data are exported in
+// these formats, CSV is used for all other formats.
+func reportFormat(format string) string {
+	switch format {
+	case jsonFormat, ndjsonFormat:
+		return format
+	default:
+		return csvFormat
+	}
+}
+
+// reportName function returns name of file or object with report for
+// selected output format, the name is derived from CSV file name
+func reportName(name, format string) string {
+	return strings.TrimSuffix(name, CSVFileExtension) + fileExtension(reportFormat(format))
+}
+
+// reportContentType function returns content type of report for selected
+// output format
+func reportContentType(format string) string {
+	return contentType(reportFormat(format))
+}
+
+// writeReport function writes rows of report as JSON or NDJSON
+func writeReport(buffer io.Writer, format string, keys []string, rows []M) error {
+	writer, err := NewTableWriter(format, buffer, "", nil)
+	if err != nil {
+		return err
+	}
+
+	err = writer.WriteHeader(keys)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		err := writer.WriteRow(keys, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// disabledRulesRows function converts list of disabled rules into report
+// rows
+func disabledRulesRows(disabledRulesInfo []DisabledRuleInfo) []M {
+	rows := make([]M, 0, len(disabledRulesInfo))
+	for _, disabledRuleInfo := range disabledRulesInfo {
+		rows = append(rows, M{
+			ruleKey:  disabledRuleInfo.Rule,
+			countKey: disabledRuleInfo.Count,
+		})
+	}
+	return rows
+}
+
+// DisabledRulesToJSON function exports list of disabled rules + number of
+// users who disabled rules as JSON array of objects.
+func DisabledRulesToJSON(buffer io.Writer, disabledRulesInfo []DisabledRuleInfo) error {
+	return writeReport(buffer, jsonFormat, []string{ruleKey, countKey},
+		disabledRulesRows(disabledRulesInfo))
+}
+
+// DisabledRulesToNDJSON function exports list of disabled rules + number of
+// users who disabled rules as JSON Lines, one object per rule.
+func DisabledRulesToNDJSON(buffer io.Writer, disabledRulesInfo []DisabledRuleInfo) error {
+	return writeReport(buffer, ndjsonFormat, []string{ruleKey, countKey},
+		disabledRulesRows(disabledRulesInfo))
+}
+
+// writeDisabledRules function exports list of disabled rules in report
+// format for selected output format
+func writeDisabledRules(buffer io.Writer, disabledRulesInfo []DisabledRuleInfo, format string) error {
+	switch reportFormat(format) {
+	case jsonFormat:
+		return DisabledRulesToJSON(buffer, disabledRulesInfo)
+	case ndjsonFormat:
+		return DisabledRulesToNDJSON(buffer, disabledRulesInfo)
+	default:
+		return DisabledRulesToCSV(buffer, disabledRulesInfo)
+	}
+}
+
+// DisabledRulesFromJSON function reads list of disabled rules in the form
+// written by DisabledRulesToJSON or DisabledRulesToNDJSON
+func DisabledRulesFromJSON(reader io.Reader) ([]DisabledRuleInfo, error) {
+	type disabledRule struct {
+		Rule  *string `json:"rule"`
+		Count *int    `json:"count"`
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON array is decoded as one value, JSON Lines as stream of objects
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var rules []disabledRule
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = decoder.Decode(&rules)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for decoder.More() {
+			var rule disabledRule
+			err := decoder.Decode(&rule)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	disabledRulesInfo := make([]DisabledRuleInfo, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Rule == nil || rule.Count == nil {
+			return nil, errors.New(wrongDisabledRulesObject)
+		}
+		disabledRulesInfo = append(disabledRulesInfo, DisabledRuleInfo{
+			Rule:  *rule.Rule,
+			Count: *rule.Count,
+		})
+	}
+
+	return disabledRulesInfo, nil
+}
+
+// disabledRulesFromReport function reads list of disabled rules from report
+// with given file or object name, format is selected by its extension
+func disabledRulesFromReport(name string, reader io.Reader) ([]DisabledRuleInfo, error) {
+	// compression extension is not part of report name
+	name = strings.TrimSuffix(path.Base(name), compressionExtension(objectCompression(name)))
+
+	switch path.Ext(name) {
+	case JSONFileExtension, NDJSONFileExtension:
+		return DisabledRulesFromJSON(reader)
+	default:
+		return DisabledRulesFromCSV(reader)
+	}
+}
+
+// tableMetadataRows function reads number of records in given tables and
+// returns them as report rows
+func tableMetadataRows(ctx context.Context, tableNames []TableName, storage DBStorage) ([]M, error) {
+	rows := make([]M, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		cnt, err := storage.ReadRecordsCount(ctx, tableName)
+		if err != nil {
+			log.Error().Err(err).Msg(readListOfRecordsFailed)
+			return nil, err
+		}
+		storage.summary.RecordTableRecords(tableName, cnt)
+
+		rows = append(rows, M{
+			tableNameKey: string(tableName),
+			recordsKey:   cnt,
+		})
+	}
+	return rows, nil
+}
+
+// TableMetadataToJSON function exports number of records in given tables as
+// JSON array of objects.
+func TableMetadataToJSON(ctx context.Context, buffer io.Writer, tableNames []TableName, storage DBStorage) error {
+	return tableMetadataToReport(ctx, buffer, tableNames, storage, jsonFormat)
+}
+
+// TableMetadataToNDJSON function exports number of records in given tables
+// as JSON Lines, one object per table.
+func TableMetadataToNDJSON(ctx context.Context, buffer io.Writer, tableNames []TableName, storage DBStorage) error {
+	return tableMetadataToReport(ctx, buffer, tableNames, storage, ndjsonFormat)
+}
+
+// tableMetadataToReport function exports number of records in given tables
+// as JSON or NDJSON
+func tableMetadataToReport(ctx context.Context, buffer io.Writer, tableNames []TableName,
+	storage DBStorage, format string) error {
+	if buffer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	rows, err := tableMetadataRows(ctx, tableNames, storage)
+	if err != nil {
+		return err
+	}
+
+	return writeReport(buffer, format, []string{tableNameKey, recordsKey}, rows)
+}
+
+// writeTableMetadata function exports metadata about given tables in report
+// format for selected output format
+func writeTableMetadata(ctx context.Context, buffer io.Writer, tableNames []TableName,
+	storage DBStorage, format string) error {
+	switch reportFormat(format) {
+	case jsonFormat:
+		return TableMetadataToJSON(ctx, buffer, tableNames, storage)
+	case ndjsonFormat:
+		return TableMetadataToNDJSON(ctx, buffer, tableNames, storage)
+	default:
+		return TableMetadataToCSV(ctx, buffer, tableNames, storage)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/reports_test.html
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// disabledRulesForReports contains list of disabled rules used by tests
+var disabledRulesForReports = []main.DisabledRuleInfo{
+	{Rule: "rule.a|KEY", Count: 2},
+	{Rule: "rule.b|KEY", Count: 5},
+}
+
+// TestDisabledRulesToJSON checks exporting list of disabled rules into JSON
+func TestDisabledRulesToJSON(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DisabledRulesToJSON(buffer, disabledRulesForReports)
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n"+
+		`{"rule":"rule.a|KEY","count":2},`+"\n"+
+		`{"rule":"rule.b|KEY","count":5}`+"\n]\n", buffer.String())
+}
+
+// TestDisabledRulesToJSONEmptyList checks exporting empty list of disabled
+// rules into JSON
+func TestDisabledRulesToJSONEmptyList(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DisabledRulesToJSON(buffer, []main.DisabledRuleInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n]\n", buffer.String())
+}
+
+// TestDisabledRulesToNDJSON checks exporting list of disabled rules into
+// JSON Lines
+func TestDisabledRulesToNDJSON(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DisabledRulesToNDJSON(buffer, disabledRulesForReports)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"rule":"rule.a|KEY","count":2}`+"\n"+
+			`{"rule":"rule.b|KEY","count":5}`+"\n", buffer.String())
+}
+
+// TestDisabledRulesToJSONNilBuffer checks how nil buffer is handled
+func TestDisabledRulesToJSONNilBuffer(t *testing.T) {
+	assert.Error(t, main.DisabledRulesToJSON(nil, disabledRulesForReports))
+	assert.Error(t, main.DisabledRulesToNDJSON(nil, disabledRulesForReports))
+}
+
+// TestDisabledRulesFromJSON checks that lists of disabled rules written as
+// JSON and JSON Lines can be read back
+func TestDisabledRulesFromJSON(t *testing.T) {
+	for _, write := range []func(*bytes.Buffer) error{
+		func(buffer *bytes.Buffer) error {
+			return main.DisabledRulesToJSON(buffer, disabledRulesForReports)
+		},
+		func(buffer *bytes.Buffer) error {
+			return main.DisabledRulesToNDJSON(buffer, disabledRulesForReports)
+		},
+	} {
+		buffer := new(bytes.Buffer)
+		assert.NoError(t, write(buffer))
+
+		disabledRulesInfo, err := main.DisabledRulesFromJSON(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, disabledRulesForReports, disabledRulesInfo)
+	}
+}
+
+// TestDisabledRulesFromJSONWrongContent checks that objects without rule
+// name or count are rejected
+func TestDisabledRulesFromJSONWrongContent(t *testing.T) {
+	_, err := main.DisabledRulesFromJSON(strings.NewReader(`[{"rule":"rule.a"}]`))
+	assert.Error(t, err)
+
+	_, err = main.DisabledRulesFromJSON(strings.NewReader(`{"rule":`))
+	assert.Error(t, err)
+}
+
+// TestTableMetadataToJSON checks exporting number of records in tables into
+// JSON and JSON Lines
+func TestTableMetadataToJSON(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareSQLiteDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	buffer := new(bytes.Buffer)
+	err = main.TableMetadataToJSON(context.Background(), buffer,
+		[]main.TableName{"report"}, *storage)
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n"+`{"table_name":"report","records":2}`+"\n]\n", buffer.String())
+
+	buffer.Reset()
+	err = main.TableMetadataToNDJSON(context.Background(), buffer,
+		[]main.TableName{"report"}, *storage)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"table_name":"report","records":2}`+"\n", buffer.String())
+
+	err = main.TableMetadataToJSON(context.Background(), nil,
+		[]main.TableName{"report"}, *storage)
+	assert.Error(t, err)
+}
+
+// TestStoreDisabledRulesTrendIntoS3JSON checks that lists of disabled rules
+// stored as JSON or JSON Lines by previous runs are part of trend
+func TestStoreDisabledRulesTrendIntoS3JSON(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.DisabledRulesToJSON(buffer,
+		[]main.DisabledRuleInfo{{Rule: "rule.a", Count: 1}}))
+	s3.objects["/bucket/exports/2024-01-01/_disabled_rules.json"] = buffer.Bytes()
+
+	buffer = new(bytes.Buffer)
+	assert.NoError(t, main.DisabledRulesToNDJSON(buffer,
+		[]main.DisabledRuleInfo{{Rule: "rule.a", Count: 2}}))
+	compressed, err := main.CompressData("gzip", nil, buffer.Bytes())
+	assert.NoError(t, err)
+	s3.objects["/bucket/exports/2024-01-02/_disabled_rules.ndjson.gz"] = compressed
+
+	err = main.StoreDisabledRulesTrendIntoS3(context.Background(), session,
+		"bucket", "exports/2024-01-03",
+		[]main.DisabledRuleInfo{{Rule: "rule.a", Count: 3}}, 3, "none", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Rule,exports/2024-01-01,exports/2024-01-02,exports/2024-01-03\n"+
+		"rule.a,1,2,3\n",
+		string(s3.objects["/bucket/exports/2024-01-03/_disabled_rules_trend.csv"]))
+}
+
+// TestPerformDataExportMetadataAsJSON checks that metadata are exported as
+// JSON when data are exported in this format
+func TestPerformDataExportMetadataAsJSON(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "json",
+		ExportMetadata:  true,
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_metadata.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n"+`{"table_name":"report","records":2}`+"\n]\n", string(content))
+
+	assert.NoFileExists(t, filepath.Join(directory, "_metadata.csv"))
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains resume of interrupted export into S3. Objects
+// already stored under selected prefix are listed at the beginning of export
+// and tables stored by the interrupted run are skipped. No local state is
+// needed, so the export can be resumed by new process (or pod).
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/resume.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// messages
+const (
+	resumeUnsupported      = "Resume is supported for S3 output only, selected output: %s"
+	resumeWithArchive      = "Resume is not possible for formats storing all tables into one object"
+	listExportedFailed     = "Unable to list objects stored by previous run"
+	tableAlreadyExported   = "Table has been exported by previous run, skipping"
+	exportedObjectsListed  = "Objects stored by previous run"
+	exportedObjectsMsg     = "objects"
+	exportedObjectSizeMsg  = "size"
+	bucketPrefixIsNotSetUp = "prefix needs to be set to resume export"
+)
+
+// ExportedObjects contains sizes of objects stored under export prefix,
+// indexed by object name
+type ExportedObjects map[string]int64
+
+// Exported method checks if given object has been stored completely. Objects
+// are uploaded at once, so any non-empty object is complete.
+func (objects ExportedObjects) Exported(objectName string) (int64, bool) {
+	size, found := objects[objectName]
+	return size, found && size > 0
+}
+
+// checkResume function checks if export can be resumed into selected output
+func checkResume(cliFlags CliFlags, prefix string) error {
+	if !cliFlags.Resume {
+		return nil
+	}
+
+	if cliFlags.Output != s3Output || cliFlags.Bundle != "" {
+		return fmt.Errorf(resumeUnsupported, cliFlags.Output)
+	}
+
+	// without prefix objects from all previous exports would be matched
+	if prefix == "" {
+		return errors.New(bucketPrefixIsNotSetUp)
+	}
+
+	// placeholders would be expanded into prefix of new run
+	if isPrefixTemplate(prefix) {
+		return errors.New(prefixTemplateResumed)
+	}
+
+	return nil
+}
+
+// listExportedObjects function lists all objects stored under given prefix
+func listExportedObjects(ctx context.Context, session *S3Session,
+	bucketName, prefix string) (ExportedObjects, error) {
+	objects := make(ExportedObjects)
+
+	options := minio.ListObjectsOptions{
+		Prefix:    prefix + "/",
+		Recursive: true,
+	}
+
+	for object := range session.client.ListObjects(ctx, bucketName, options) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects[object.Key] = object.Size
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/resume_test.html
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestExportedObjects checks that only non-empty objects are considered to
+// be exported
+func TestExportedObjects(t *testing.T) {
+	objects := main.ExportedObjects{
+		"run/report.csv": 42,
+		"run/empty.csv":  0,
+	}
+
+	size, found := objects.Exported("run/report.csv")
+	assert.True(t, found)
+	assert.Equal(t, int64(42), size)
+
+	_, found = objects.Exported("run/empty.csv")
+	assert.False(t, found)
+
+	_, found = objects.Exported("run/other.csv")
+	assert.False(t, found)
+}
+
+// TestCheckResume checks which outputs can be resumed
+func TestCheckResume(t *testing.T) {
+	// resume not selected
+	assert.NoError(t, main.CheckResume(main.CliFlags{Output: "file"}, ""))
+
+	assert.NoError(t, main.CheckResume(
+		main.CliFlags{Output: "S3", Resume: true}, "run"))
+
+	assert.EqualError(t, main.CheckResume(
+		main.CliFlags{Output: "file", Resume: true}, "run"),
+		"Resume is supported for S3 output only, selected output: file")
+
+	assert.Error(t, main.CheckResume(
+		main.CliFlags{Output: "S3", Resume: true, Bundle: "zip"}, "run"))
+
+	assert.EqualError(t, main.CheckResume(
+		main.CliFlags{Output: "S3", Resume: true}, ""),
+		"prefix needs to be set to resume export")
+
+	assert.EqualError(t, main.CheckResume(
+		main.CliFlags{Output: "S3", Resume: true}, "exports/{{.Date}}"),
+		"prefix with placeholders can't be resumed, select prefix of interrupted run by -prefix flag")
+}
+
+// TestListExportedObjects checks that objects under given prefix are listed
+// with their sizes
+func TestListExportedObjects(t *testing.T) {
+	s3, session := startFakeS3(t)
+	s3.objects["/bucket/run/report.csv"] = []byte("hello")
+	s3.objects["/bucket/run/nested/rule.csv"] = []byte("hi")
+	s3.objects["/bucket/other/report.csv"] = []byte("other")
+
+	objects, err := main.ListExportedObjects(context.Background(),
+		session, "bucket", "run")
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExportedObjects{
+		"run/report.csv":      5,
+		"run/nested/rule.csv": 2,
+	}, objects)
+}
+
+// TestPerformDataExportResume checks that tables stored by interrupted run
+// are not exported again
+func TestPerformDataExportResume(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	// second table has not been exported by interrupted run
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE report (id INTEGER, report TEXT)`,
+		`INSERT INTO report VALUES (1, 'first'), (2, 'second')`,
+		`CREATE TABLE rule (name TEXT)`,
+		`INSERT INTO rule VALUES ('rule1')`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "run",
+		},
+	}
+
+	// table stored by interrupted run
+	s3.objects["/bucket/run/report.csv"] = []byte("stored before")
+
+	cliFlags := main.CliFlags{
+		Output: "S3",
+		Resume: true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, "stored before", string(s3.objects["/bucket/run/report.csv"]))
+	assert.Equal(t, "name\nrule1\n", string(s3.objects["/bucket/run/rule.csv"]))
+}
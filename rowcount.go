@@ -0,0 +1,150 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains verification of number of exported rows. Number
+// of records in table is read in the same snapshot (read-only transaction)
+// as table content, and it is compared with number of rows really written
+// after the table has been exported. Silent truncation of exported tables
+// (for example when reading is interrupted by early error) is detected this
+// way.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rowcount.html
+
+import (
+	"context"
+	"database/sql"
+)
+
+// messages
+const (
+	rowCountMismatch      = "Number of exported rows differs from number of records in table"
+	rowCountMismatches    = "number of exported rows differs from number of records in %d tables"
+	rowCountNotVerified   = "Sampled table, number of exported rows is not verified"
+	expectedRowsMsg       = "expected rows"
+	exportedRowsMsg       = "exported rows"
+	beginSnapshotFailed   = "Unable to begin read-only transaction"
+	rollbackSnapshotError = "Unable to finish read-only transaction"
+)
+
+// RowCountMismatch represents table with number of exported rows different
+// from number of records read in the same snapshot
+type RowCountMismatch struct {
+	Table    TableName
+	Expected int
+	Exported int
+}
+
+// rowsQuerier is implemented by database connection and by transaction, so
+// rows can be read by both of them
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowCountVerified method checks whether number of rows exported from given
+// table is verified. Number of rows randomly sampled from table is not known
+// in advance.
+func (storage DBStorage) rowCountVerified(tableName TableName) bool {
+	if !storage.verifyRowCounts {
+		return false
+	}
+
+	if storage.sample > 0 && storage.sample < 1 {
+		storage.logger.Info().Str(tableNameMsg, string(tableName)).Msg(rowCountNotVerified)
+		return false
+	}
+
+	return true
+}
+
+// readTableInSnapshot method reads the whole content of selected table by one
+// query and passes rows one by one to given function. Number of records is
+// read in the same read-only transaction, so it is not affected by rows
+// inserted or deleted concurrently. Number of rows that should be exported
+// (taking limit into account) is returned.
+func (storage DBStorage) readTableInSnapshot(ctx context.Context, tableName TableName,
+	limit int, process func(M) error) (int, error) {
+	limit = storage.tableLimit(tableName, limit)
+
+	sqlStatement, err := storage.tableContentQuery(ctx, tableName, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	// PostgreSQL uses one snapshot for all queries in repeatable read
+	// transaction, SQLite does so for any read transaction
+	transaction, err := storage.connection.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(beginSnapshotFailed)
+		return 0, err
+	}
+
+	defer func() {
+		// nothing is changed by the transaction
+		err := transaction.Rollback()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(rollbackSnapshotError)
+		}
+	}()
+
+	expected, err := storage.readRecordsCount(ctx, transaction, tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit > 0 && limit < expected {
+		expected = limit
+	}
+
+	err = storage.scanRowsWith(ctx, transaction, tableName, sqlStatement, process, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return expected, nil
+}
+
+// verifyRowCount method compares number of rows exported from given table
+// with number of records read from database. Rejected rows are counted as
+// exported, because they are written into quarantine. Mismatch is logged and
+// recorded in summary.
+func (storage DBStorage) verifyRowCount(tableName TableName, expected, exported int) {
+	exported += storage.quarantine.Count(tableName)
+	if exported == expected {
+		return
+	}
+
+	storage.logger.Error().
+		Str(tableNameMsg, string(tableName)).
+		Int(expectedRowsMsg, expected).
+		Int(exportedRowsMsg, exported).
+		Msg(rowCountMismatch)
+
+	storage.summary.AddRowCountMismatch(RowCountMismatch{
+		Table:    tableName,
+		Expected: expected,
+		Exported: exported,
+	})
+}
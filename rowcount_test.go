@@ -0,0 +1,151 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rowcount_test.html
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestWriteTableContentRowCountVerified checks that number of records is
+// read in the same transaction as rows and no mismatch is recorded when all
+// rows are exported
+func TestWriteTableContentRowCountVerified(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectBegin()
+	countRows := sqlmock.NewRows([]string{"count"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM table_name`).WillReturnRows(countRows)
+	mock.ExpectQuery(`SELECT \* FROM table_name`).
+		WillReturnRows(rangeRows(mock, 1, 2, 3))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	summary := main.NewSummary()
+	main.SetRowCountVerification(storage, summary)
+
+	output, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,row\n2,row\n3,row\n", output)
+	assert.Empty(t, summary.RowCountMismatches())
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentRowCountLimit checks that limit is taken into account
+// when number of exported rows is verified
+func TestWriteTableContentRowCountLimit(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectBegin()
+	countRows := sqlmock.NewRows([]string{"count"}).AddRow(10)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM table_name`).WillReturnRows(countRows)
+	mock.ExpectQuery(`SELECT \* FROM table_name LIMIT 2`).
+		WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	summary := main.NewSummary()
+	main.SetRowCountVerification(storage, summary)
+
+	_, err := writeTableContent(t, storage, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, summary.RowCountMismatches())
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWriteTableContentRowCountMismatch checks that table exported only
+// partially is recorded in summary
+func TestWriteTableContentRowCountMismatch(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectBegin()
+	countRows := sqlmock.NewRows([]string{"count"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM table_name`).WillReturnRows(countRows)
+	mock.ExpectQuery(`SELECT \* FROM table_name`).
+		WillReturnRows(rangeRows(mock, 1, 2))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	summary := main.NewSummary()
+	main.SetRowCountVerification(storage, summary)
+
+	_, err := writeTableContent(t, storage, NoLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, []main.RowCountMismatch{
+		{Table: "table_name", Expected: 3, Exported: 2},
+	}, summary.RowCountMismatches())
+
+	// mismatch is part of summary table
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.Contains(t, buffer.String(), "Row count mismatch in table table_name: 3 expected, 2 exported\n")
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformDataExportSQLiteRowCounts checks that export with verified
+// numbers of rows succeeds
+func TestPerformDataExportSQLiteRowCounts(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		"INSERT INTO report VALUES (1), (2), (3)")
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			VerifyRowCounts: true,
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: t.TempDir(),
+		Limit:           NoLimits,
+	}
+
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+	assert.Equal(t, 3, summary.ExportedRows())
+	assert.Empty(t, summary.RowCountMismatches())
+}
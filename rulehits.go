@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains report of rule hits per organization. The
+// report contains one row per organization, rule and error key with number
+// of clusters of the organization hitting the rule, so the most common ad-hoc
+// query run against exported rule_hit table is answered by the export
+// itself.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rulehits.html
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ruleHitsFile is name of file or object with rule hits per organization
+const ruleHitsFile = "_rule_hits.csv"
+
+// messages
+const (
+	readRuleHitsFailed  = "Read rule hits per organization failed"
+	storeRuleHitsFailed = "Store rule hits per organization failed"
+	exportingRuleHits   = "Exporting rule hits per organization"
+)
+
+// selectRuleHits counts clusters hitting each rule in each organization.
+// Filter of organizations is inserted as WHERE clause.
+const selectRuleHits = `
+           SELECT org_id, rule_fqdn, error_key, count(DISTINCT cluster_id) AS clusters
+             FROM rule_hit%s
+            GROUP BY org_id, rule_fqdn, error_key
+            ORDER BY org_id, clusters DESC, rule_fqdn, error_key;
+`
+
+// RuleHits contains number of clusters of one organization hitting rule
+type RuleHits struct {
+	OrgID    int
+	Rule     string
+	ErrorKey string
+	Clusters int
+}
+
+// ReadRuleHits method reads numbers of clusters hitting each rule in each
+// organization
+func (storage DBStorage) ReadRuleHits(ctx context.Context) ([]RuleHits, error) {
+	hits := make([]RuleHits, 0)
+
+	// only selected organizations are reported
+	filter := ""
+	if storage.orgIDFilterApplied("rule_hit") {
+		filter = fmt.Sprintf(" WHERE org_id IN ('%v')",
+			strings.Join(storage.config.OrganizationsToExport, "','"))
+	}
+	sqlStatement := fmt.Sprintf(selectRuleHits, filter)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return hits, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var hit RuleHits
+
+		err := rows.Scan(&hit.OrgID, &hit.Rule, &hit.ErrorKey, &hit.Clusters)
+		if err != nil {
+			return hits, err
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
+
+// writeRuleHits function exports rule hits per organization in report
+// format for selected output format
+func writeRuleHits(buffer io.Writer, hits []RuleHits, format string) error {
+	rows := make([]M, 0, len(hits))
+	for _, hit := range hits {
+		rows = append(rows, M{
+			orgIDKey:    hit.OrgID,
+			ruleKey:     hit.Rule,
+			errorKeyKey: hit.ErrorKey,
+			clustersKey: hit.Clusters,
+		})
+	}
+
+	return writeReport(buffer, reportFormat(format),
+		[]string{orgIDKey, ruleKey, errorKeyKey, clustersKey}, rows)
+}
+
+// ruleHitsReport method reads rule hits per organization and converts them
+// into report format. Exit status is returned together with error.
+func (storage DBStorage) ruleHitsReport(ctx context.Context, format string) ([]byte, int, error) {
+	hits, err := storage.ReadRuleHits(ctx)
+	if err != nil {
+		storage.logger.Err(err).Msg(readRuleHitsFailed)
+		return nil, ExitStatusStorageError, err
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	err = writeRuleHits(buffer, hits, format)
+	if err != nil {
+		return nil, ExitStatusIOError, err
+	}
+
+	// buffer is returned into pool
+	return append([]byte(nil), buffer.Bytes()...), ExitStatusOK, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/rulehits_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareRuleHitsDatabase function creates SQLite database with rules hit
+// by clusters in two organizations
+func prepareRuleHitsDatabase(t *testing.T) string {
+	statements := []string{
+		`CREATE TABLE rule_hit (org_id INTEGER, cluster_id VARCHAR, rule_fqdn VARCHAR,
+		     error_key VARCHAR, template_data VARCHAR)`,
+		`INSERT INTO rule_hit VALUES
+		     (1, 'c1', 'rule1', 'KEY1', '{}'),
+		     (1, 'c2', 'rule1', 'KEY1', '{}'),
+		     (1, 'c1', 'rule2', 'KEY2', '{}'),
+		     (1, 'c2', 'rule2', 'KEY3', '{}'),
+		     (2, 'c3', 'rule1', 'KEY1', '{}')`,
+	}
+
+	return newSQLiteTestDatabase(t, statements...)
+}
+
+// TestReadRuleHits checks the method ReadRuleHits
+func TestReadRuleHits(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareRuleHitsDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	hits, err := storage.ReadRuleHits(context.Background())
+	assert.NoError(t, err)
+
+	// rules hit by more clusters come first in each organization
+	assert.Equal(t, []main.RuleHits{
+		{OrgID: 1, Rule: "rule1", ErrorKey: "KEY1", Clusters: 2},
+		{OrgID: 1, Rule: "rule2", ErrorKey: "KEY2", Clusters: 1},
+		{OrgID: 1, Rule: "rule2", ErrorKey: "KEY3", Clusters: 1},
+		{OrgID: 2, Rule: "rule1", ErrorKey: "KEY1", Clusters: 1},
+	}, hits)
+}
+
+// TestReadRuleHitsOrgFilter checks that only selected organizations are
+// reported
+func TestReadRuleHitsOrgFilter(t *testing.T) {
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:                "sqlite3",
+		SQLiteDataSource:      prepareRuleHitsDatabase(t),
+		EnableOrgIDFiltering:  true,
+		OrganizationsToExport: []string{"2"},
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	hits, err := storage.ReadRuleHits(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.RuleHits{
+		{OrgID: 2, Rule: "rule1", ErrorKey: "KEY1", Clusters: 1},
+	}, hits)
+}
+
+// TestPerformDataExportRuleHits checks that report of rule hits is exported
+// when requested and that it can be skipped
+func TestPerformDataExportRuleHits(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareRuleHitsDatabase(t),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportRuleHits:  true,
+		SkipArtifacts:   "sequences,constraints",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_rule_hits.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,rule,error_key,clusters\n"+
+		"1,rule1,KEY1,2\n"+
+		"1,rule2,KEY2,1\n"+
+		"1,rule2,KEY3,1\n"+
+		"2,rule1,KEY1,1\n", string(content))
+
+	// report is not exported when skipped
+	cliFlags.OutputDirectory = t.TempDir()
+	cliFlags.SkipArtifacts = "sequences,constraints,rule-hits"
+	_, err = main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(cliFlags.OutputDirectory, "_rule_hits.csv"))
+}
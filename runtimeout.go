@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains timeout of the whole run. When the export takes
+// longer than configured run timeout, it is interrupted, but summary of the
+// run, manifest of artifacts produced so far and operation log are still
+// stored into the output, so no timed-out run ends without artifacts needed
+// to find out what happened.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/runtimeout.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// summaryFile is name of file or object with summary of timed-out run
+const summaryFile = "_summary.txt"
+
+// messages
+const (
+	runTimeoutExceeded          = "Export exceeded run timeout, storing partial artifacts"
+	storePartialArtifactsFailed = "Storing partial artifacts of timed-out run failed"
+	runTimeoutMsg               = "run timeout"
+	summaryHeader               = "Export has been interrupted after %v by run timeout\n\n"
+)
+
+// withRunTimeout function returns context limited by given run timeout,
+// context is not limited when the timeout is zero
+func withRunTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runTimedOut function checks whether run using given context exceeded its
+// run timeout
+func runTimedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// storeSummary function stores summary of timed-out run into the output the
+// data have been exported into. Summary is never compressed, but it is
+// encrypted as other artifacts.
+func storeSummary(configuration *ConfigStruct, cliFlags CliFlags, summary *Summary) (int, error) {
+	buffer := new(bytes.Buffer)
+	_, err := fmt.Fprintf(buffer, summaryHeader,
+		summary.TotalDuration().Round(time.Millisecond))
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	err = printSummary(buffer, summary)
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	sinks, err := artifactSinks(configuration, cliFlags, noCompression)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	return storeObjectIntoSinks(sinks, summaryFile,
+		ObjectMeta{ContentType: "text/plain"}, buffer.Bytes())
+}
+
+// storePartialArtifacts function stores summary, manifest of artifacts
+// produced so far and operation log of timed-out run. Failures are logged
+// only, so as many artifacts as possible are stored.
+func storePartialArtifacts(configuration *ConfigStruct, cliFlags CliFlags, runID string,
+	summary *Summary, artifacts []Artifact, buffer *bytes.Buffer,
+	operationLogCloser func(), logger *zerolog.Logger) {
+	logger.Warn().
+		Dur(runTimeoutMsg, GetExportConfiguration(configuration).RunTimeout).
+		Msg(runTimeoutExceeded)
+
+	if _, err := storeSummary(configuration, cliFlags, summary); err != nil {
+		logger.Err(err).Msg(storePartialArtifactsFailed)
+	}
+
+	if cliFlags.ExportManifest {
+		_, err := storeRunManifest(configuration, cliFlags, runID, summary, artifacts)
+		if err != nil {
+			logger.Err(err).Msg(storeRunManifestFailed)
+		}
+	}
+
+	// operation log needs to be complete before files are stored
+	operationLogCloser()
+	if exportedIntoDirectory(cliFlags) {
+		if _, err := storeExportedFiles(configuration, cliFlags); err != nil {
+			logger.Err(err).Msg(storeExportedFilesFailed)
+		}
+	}
+
+	if cliFlags.ExportLog && exportedIntoSinks(cliFlags.Output) {
+		if _, err := storeOperationLogIntoSinks(configuration, cliFlags, buffer); err != nil {
+			logger.Err(err).Msg(storePartialArtifactsFailed)
+		}
+	}
+
+	if cliFlags.ExportLog && exportOutput(cliFlags) == s3Output {
+		if err := storeOpertionLogIntoS3(configuration, *buffer); err != nil {
+			logger.Err(err).Msg(storePartialArtifactsFailed)
+		}
+	}
+}
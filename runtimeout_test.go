@@ -0,0 +1,148 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/runtimeout_test.html
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestWithRunTimeout checks the functions WithRunTimeout and RunTimedOut
+func TestWithRunTimeout(t *testing.T) {
+	// no timeout is configured
+	ctx, cancel := main.WithRunTimeout(context.Background(), 0)
+	_, limited := ctx.Deadline()
+	assert.False(t, limited)
+
+	// canceled run has not timed out
+	cancel()
+	assert.False(t, main.RunTimedOut(ctx))
+
+	ctx, cancel = main.WithRunTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, limited = ctx.Deadline()
+	assert.True(t, limited)
+
+	<-ctx.Done()
+	assert.True(t, main.RunTimedOut(ctx))
+}
+
+// TestPerformDataExportRunTimeout checks that export is interrupted when the
+// run timeout is exceeded
+func TestPerformDataExportRunTimeout(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	ctx, cancel := main.WithRunTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	_, err := main.PerformDataExport(ctx, &configuration,
+		main.CliFlags{Output: "file", OutputDirectory: t.TempDir()},
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+	assert.True(t, main.RunTimedOut(ctx))
+}
+
+// TestStorePartialArtifactsIntoFiles checks that summary and manifest of
+// timed-out run are written into output directory
+func TestStorePartialArtifactsIntoFiles(t *testing.T) {
+	directory := t.TempDir()
+	configuration := main.ConfigStruct{
+		Export: main.ExportConfiguration{RunTimeout: time.Minute},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportManifest:  true,
+	}
+
+	summary := main.NewSummary()
+	summary.RecordTable("report", []string{"id"}, 2)
+	summary.Finish()
+
+	closed := false
+	main.StorePartialArtifacts(&configuration, cliFlags, "run-id", summary, nil,
+		new(bytes.Buffer), func() { closed = true }, &log.Logger)
+
+	// operation log is completed
+	assert.True(t, closed)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_summary.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "interrupted")
+	assert.Contains(t, string(content), "Stage")
+
+	content, err = os.ReadFile(filepath.Join(directory, "_manifest.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"run-id"`)
+	assert.Contains(t, string(content), `"report"`)
+}
+
+// TestStorePartialArtifactsIntoS3 checks that summary, manifest and
+// operation log of timed-out run are stored into S3
+func TestStorePartialArtifactsIntoS3(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:  host,
+			EndpointPort: uint(endpointPort),
+			Bucket:       "bucket",
+			Prefix:       "run",
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:         "S3",
+		ExportManifest: true,
+		ExportLog:      true,
+	}
+
+	buffer := bytes.NewBufferString("operation log\n")
+	main.StorePartialArtifacts(&configuration, cliFlags, "run-id", main.NewSummary(), nil,
+		buffer, func() {}, &log.Logger)
+
+	assert.Contains(t, s3.objects, "/bucket/run/_summary.txt")
+	assert.Contains(t, s3.objects, "/bucket/run/_manifest.json")
+	assert.Equal(t, "operation log\n", string(s3.objects["/bucket/run/_logs.txt"]))
+}
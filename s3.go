@@ -25,15 +25,13 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/csv"
+	"crypto/sha256"
 	"errors"
-	"fmt"
 	"io"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 // error messages
@@ -47,69 +45,50 @@ const (
 	bucketNameIsNotSet           = "Bucket name is not set"
 	configurationIsNil           = "Configuration is nil"
 	configurationError           = "Configuration error"
+	bucketCreated                = "Bucket has been created"
+	bucketCreationFailed         = "Bucket can not be created"
 )
 
+// code of error returned when bucket to be created already exists and it is
+// owned by current client
+const bucketAlreadyOwnedByYou = "BucketAlreadyOwnedByYou"
+
 // NewS3Connection function initializes connection to S3/Minio storage.
+// Connection is shared with other operations, see OpenS3Session.
 func NewS3Connection(configuration *ConfigStruct) (*minio.Client, context.Context, error) {
-	// check if configuration structure has been provided
-	if configuration == nil {
-		err := errors.New(configurationIsNil)
-		log.Error().Err(err).Msg(configurationError)
-		return nil, nil, err
-	}
-
-	// retrieve S3/Minio configuration
-	s3Configuration := GetS3Configuration(configuration)
-
-	var endpoint string
-	if s3Configuration.EndpointPort == 0 {
-		endpoint = s3Configuration.EndpointURL
-	} else {
-		endpoint = fmt.Sprintf("%s:%d",
-			s3Configuration.EndpointURL, s3Configuration.EndpointPort)
-	}
-	log.Info().Str("S3 endpoint", endpoint).Msg("Preparing connection")
-
-	ctx := context.Background()
-
-	// initialize Minio client object
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds: credentials.NewStaticV4(
-			s3Configuration.AccessKeyID,
-			s3Configuration.SecretAccessKey, ""),
-		Secure: s3Configuration.UseSSL,
-	})
-
-	// check if client has been constructed properly
+	session, err := OpenS3Session(configuration)
 	if err != nil {
-		log.Error().Err(err).Msg(unableToInitializeConnection)
 		return nil, nil, err
 	}
 
-	log.Info().Msg("Connection established")
-	return minioClient, ctx, nil
+	return session.Client(), session.Context(), nil
 }
 
 // s3BucketExists function checks if bucket with given name exists and can be
 // accessed by current client
-func s3BucketExists(ctx context.Context, minioClient *minio.Client,
+func s3BucketExists(ctx context.Context, session *S3Session,
 	bucketName string) (bool, error) {
 	// check if Minio client has been passed to this function
-	if minioClient == nil {
-		err := errors.New(minioClientIsNil)
+	if session == nil {
+		err := ErrNilMinioClient
 		log.Error().Err(err).Msg(wrongMinioClientReference)
 		return false, err
 	}
 
 	// check if proper bucket name has been passed to this function
 	if bucketName == "" {
-		err := errors.New(bucketNameIsNotSet)
+		err := ErrBucketNotSet
 		log.Error().Err(err).Msg(wrongBucketName)
 		return false, err
 	}
 
 	// check bucket existence
-	found, err := minioClient.BucketExists(ctx, bucketName)
+	var found bool
+	err := session.withTimeout(ctx, s3BucketExistsOperation, bucketName, func(ctx context.Context) error {
+		var err error
+		found, err = session.client.BucketExists(ctx, bucketName)
+		return err
+	})
 	if err != nil {
 		log.Error().Err(err).Str("bucket", bucketName).Msg("Bucket can not be found")
 		return false, err
@@ -119,35 +98,233 @@ func s3BucketExists(ctx context.Context, minioClient *minio.Client,
 	return found, nil
 }
 
+// s3CreateBucket function creates bucket with given name in selected region
+// when it does not exist yet. Bucket created in the meantime by another
+// exporter is accepted too.
+func s3CreateBucket(ctx context.Context, session *S3Session,
+	bucketName, region string) error {
+	exists, err := s3BucketExists(ctx, session, bucketName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	err = session.withTimeout(ctx, s3MakeBucketOperation, bucketName, func(ctx context.Context) error {
+		return session.client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: region})
+	})
+	if err != nil && minio.ToErrorResponse(err).Code != bucketAlreadyOwnedByYou {
+		log.Error().Err(err).Str("bucket", bucketName).Msg(bucketCreationFailed)
+		return err
+	}
+
+	log.Info().Str("bucket", bucketName).Str("region", region).Msg(bucketCreated)
+	return nil
+}
+
+// putObject function compresses given data by selected codec (and encrypts
+// them when encryption is given) and stores them into given bucket under
+// selected object name. Extensions used by codec and encryption are added to
+// object name.
+func putObject(ctx context.Context, session *S3Session,
+	bucketName, objectName, contentType string, data []byte,
+	compression string, encryption *Encryption) error {
+	// CSV objects are formatted by profile selected for S3
+	if profile := session.profile; profile != nil && contentType == csvContentType {
+		converted, err := profile.ConvertData(data)
+		if err != nil {
+			return err
+		}
+		data = converted
+	}
+
+	data, err := compressData(compression, encryption, data)
+	if err != nil {
+		return err
+	}
+
+	options := minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding(compression, encryption),
+	}
+	// large objects are uploaded by multipart upload too
+	session.setUploadOptions(&options)
+	objectName += artifactExtension(compression, encryption)
+	err = session.retryOperation(ctx, s3PutOperation, s3ArtifactLocation(bucketName, objectName),
+		func(ctx context.Context) error {
+			_, err := session.client.PutObject(ctx, bucketName, objectName,
+				bytes.NewReader(data), int64(len(data)), options)
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	session.instrumentation().AddCounter(metricS3UploadedBytes,
+		MetricLabels{metricLabelOperation: s3PutOperation}, float64(len(data)))
+
+	recordArtifactData(session.artifacts, s3ArtifactLocation(bucketName, objectName), data)
+
+	if session.checksums {
+		return storeChecksumObject(ctx, session, bucketName, objectName,
+			dataChecksum(data))
+	}
+	return nil
+}
+
+// putObjectStream function uploads data written by given function into
+// given bucket under selected object name while they are being written.
+// Data are compressed by selected codec (and encrypted when encryption is
+// given) and extensions used by codec and encryption are added to object
+// name. Object of unknown size is uploaded by multipart upload, so
+// only configured number of parts is kept in memory. Error returned by the
+// write function is returned in preference to upload error.
+func putObjectStream(ctx context.Context, session *S3Session,
+	bucketName, objectName, contentType, compression string, encryption *Encryption,
+	write func(io.Writer) error) error {
+	err := checkCompression(compression)
+	if err != nil {
+		return err
+	}
+
+	// CSV objects are formatted by profile selected for S3
+	if profile := session.profile; profile != nil && contentType == csvContentType {
+		unconverted := write
+		write = func(output io.Writer) error {
+			return profile.ConvertStream(output, unconverted)
+		}
+	}
+
+	// checksum of uploaded data is computed only when the object is
+	// recorded as artifact or when checksum sidecar is stored
+	artifacts := session.artifacts
+	checksums := artifacts != nil || session.checksums
+
+	options := minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding(compression, encryption),
+	}
+	session.setUploadOptions(&options)
+	objectName += artifactExtension(compression, encryption)
+
+	// data are written again when upload is retried, so checksum is
+	// computed from data written by the last attempt
+	var digest *artifactDigest
+	err = session.retryOperation(ctx, s3PutOperation, s3ArtifactLocation(bucketName, objectName),
+		func(ctx context.Context) error {
+			if checksums {
+				digest = &artifactDigest{hash: sha256.New()}
+			}
+			return uploadStream(ctx, session, bucketName, objectName,
+				compression, encryption, digest, options, write)
+		})
+	if err != nil {
+		return err
+	}
+
+	digest.Record(artifacts, s3ArtifactLocation(bucketName, objectName))
+
+	if session.checksums {
+		return storeChecksumObject(ctx, session, bucketName, objectName,
+			digest.hash.Sum(nil))
+	}
+	return nil
+}
+
+// uploadStream function uploads data written by given function into given
+// bucket under selected object name, data are compressed by selected codec,
+// encrypted when encryption is given and added into given digest. Error returned by the write function is
+// returned in preference to upload error and it is marked as final, because
+// it is not solved by retrying the upload.
+func uploadStream(ctx context.Context, session *S3Session,
+	bucketName, objectName, compression string, encryption *Encryption,
+	digest *artifactDigest,
+	options minio.PutObjectOptions, write func(io.Writer) error) error {
+	reader, writer := io.Pipe()
+	writeErr := make(chan error, 1)
+
+	// uploaded bytes are counted after compression
+	counter := &countingWriter{writer: writer}
+
+	go func() {
+		err := writeCompressed(digest.Writer(counter), compression, encryption, write)
+		_ = writer.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	_, err := session.client.PutObject(ctx, bucketName, objectName, reader, -1, options)
+
+	// stop writing when upload failed before all data were written
+	if err != nil {
+		_ = reader.CloseWithError(errSinkFailed)
+	} else {
+		_ = reader.Close()
+	}
+
+	// error during writing is the cause of failed upload too
+	if err := <-writeErr; err != nil && !errors.Is(err, errSinkFailed) {
+		return finalError{err}
+	}
+	if err == nil {
+		session.instrumentation().AddCounter(metricS3UploadedBytes,
+			MetricLabels{metricLabelOperation: s3PutOperation}, float64(counter.written))
+	}
+	return err
+}
+
+// writeCompressed function compresses all data written by given function by
+// selected codec into given writer, data are encrypted when encryption is
+// given
+func writeCompressed(writer io.Writer, compression string, encryption *Encryption,
+	write func(io.Writer) error) error {
+	compressor, err := newEncodingWriter(compression, encryption, writer)
+	if err != nil {
+		return err
+	}
+
+	err = write(compressor)
+	if err != nil {
+		// error during write is more important than error during close
+		_ = compressor.Close()
+		return err
+	}
+
+	return compressor.Close()
+}
+
 // storeTableNames function stores all table names passed via tableNames
 // parameter into given bucket under selected object name
-func storeTableNames(ctx context.Context, minioClient *minio.Client,
-	bucketName string, objectName string, tableNames []TableName) error {
+func storeTableNames(ctx context.Context, session *S3Session,
+	bucketName string, objectName string, tableNames []TableName,
+	compression string, encryption *Encryption) error {
 	// check if Minio client has been passed to this function
-	if minioClient == nil {
-		err := errors.New(minioClientIsNil)
+	if session == nil {
+		err := ErrNilMinioClient
 		log.Error().Err(err).Msg(wrongMinioClientReference)
 		return err
 	}
 
 	// check if proper bucket name has been passed to this function
 	if bucketName == "" {
-		err := errors.New(bucketNameIsNotSet)
+		err := ErrBucketNotSet
 		log.Error().Err(err).Msg(wrongBucketName)
 		return err
 	}
 
 	// check if proper object name has been passed to this function
 	if objectName == "" {
-		err := errors.New(objectNameIsNotSet)
+		err := ErrObjectNotSet
 		log.Error().Err(err).Msg(wrongObjectName)
 		return err
 	}
 
 	// conversion to CSV
-	buffer := new(bytes.Buffer)
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 
-	writer := csv.NewWriter(buffer)
+	writer, release := newCSVWriter(buffer)
+	defer release()
 	var data = [][]string{{"Table name"}}
 
 	err := writer.WriteAll(data)
@@ -164,11 +341,9 @@ func storeTableNames(ctx context.Context, minioClient *minio.Client,
 
 	writer.Flush()
 
-	reader := io.Reader(buffer)
-
 	// store CSV data into S3/Minio
-	options := minio.PutObjectOptions{ContentType: "text/csv"}
-	_, err = minioClient.PutObject(ctx, bucketName, objectName, reader, -1, options)
+	err = putObject(ctx, session, bucketName, objectName, "text/csv",
+		buffer.Bytes(), compression, encryption)
 	if err != nil {
 		return err
 	}
@@ -179,42 +354,42 @@ func storeTableNames(ctx context.Context, minioClient *minio.Client,
 
 // storeDisabledRulesIntoS3 function stores info about disabled rules into S3
 // into given bucket under selected object name
-func storeDisabledRulesIntoS3(ctx context.Context, minioClient *minio.Client,
-	bucketName string, objectName string, disabledRulesInfo []DisabledRuleInfo) error {
+func storeDisabledRulesIntoS3(ctx context.Context, session *S3Session,
+	bucketName string, objectName string, disabledRulesInfo []DisabledRuleInfo,
+	format string, compression string, encryption *Encryption) error {
 	// check if Minio client has been passed to this function
-	if minioClient == nil {
-		err := errors.New(minioClientIsNil)
+	if session == nil {
+		err := ErrNilMinioClient
 		log.Error().Err(err).Msg(wrongMinioClientReference)
 		return err
 	}
 
 	// check if proper bucket name has been passed to this function
 	if bucketName == "" {
-		err := errors.New(bucketNameIsNotSet)
+		err := ErrBucketNotSet
 		log.Error().Err(err).Msg(wrongBucketName)
 		return err
 	}
 
 	// check if proper object name has been passed to this function
 	if objectName == "" {
-		err := errors.New(objectNameIsNotSet)
+		err := ErrObjectNotSet
 		log.Error().Err(err).Msg(wrongObjectName)
 		return err
 	}
 
-	// conversion to CSV
-	buffer := new(bytes.Buffer)
-	err := DisabledRulesToCSV(buffer, disabledRulesInfo)
+	// conversion to report format
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	err := writeDisabledRules(buffer, disabledRulesInfo, format)
 	if err != nil {
 		log.Error().Err(err).Msg("Write table name to CSV")
 		return err
 	}
 
-	reader := io.Reader(buffer)
-
-	// store CSV data into S3/Minio
-	options := minio.PutObjectOptions{ContentType: "text/csv"}
-	_, err = minioClient.PutObject(ctx, bucketName, objectName, reader, -1, options)
+	// store report into S3/Minio
+	err = putObject(ctx, session, bucketName, objectName,
+		reportContentType(format), buffer.Bytes(), compression, encryption)
 	if err != nil {
 		return err
 	}
@@ -223,9 +398,25 @@ func storeDisabledRulesIntoS3(ctx context.Context, minioClient *minio.Client,
 	return nil
 }
 
-func storeBufferToS3(ctx context.Context, minioClient *minio.Client,
-	bucketName string, objectName string, buffer bytes.Buffer) error {
-	options := minio.PutObjectOptions{ContentType: "text/plain"}
-	_, err := minioClient.PutObject(ctx, bucketName, objectName, &buffer, -1, options)
-	return err
+// storeArchiveIntoS3 function stores archive with exported tables into S3
+// into given bucket under selected object name
+func storeArchiveIntoS3(ctx context.Context, session *S3Session,
+	bucketName string, objectName string, contentType string,
+	archive TableArchive, compression string, encryption *Encryption) error {
+	buffer := new(bytes.Buffer)
+
+	err := archive.Write(buffer)
+	if err != nil {
+		return err
+	}
+
+	return putObject(ctx, session, bucketName, objectName,
+		contentType, buffer.Bytes(), compression, encryption)
+}
+
+func storeBufferToS3(ctx context.Context, session *S3Session,
+	bucketName string, objectName string, buffer bytes.Buffer,
+	compression string, encryption *Encryption) error {
+	return putObject(ctx, session, bucketName, objectName,
+		"text/plain", buffer.Bytes(), compression, encryption)
 }
@@ -23,7 +23,12 @@ package main_test
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3_test.html
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,13 +38,13 @@ import (
 	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
 )
 
-// mustConstructMinioClient helper function constructs an instance of Minio
-// client or make the test fail
-func mustConstructMinioClient(t *testing.T) *minio.Client {
+// mustConstructS3Session helper function constructs session that uses an
+// instance of Minio client or make the test fail
+func mustConstructS3Session(t *testing.T) *main.S3Session {
 	minioClient, err := minio.New("localhost:1234", &minio.Options{})
 	assert.Nil(t, err)
 
-	return minioClient
+	return main.NewS3Session(minioClient, main.S3Configuration{})
 }
 
 // Test case specification structure for function main.NewS3Connection
@@ -124,7 +129,7 @@ func TestNewS3Connection(t *testing.T) {
 // Test case specification structure for function main.s3BucketExists
 type s3BucketExistsTestSpecification struct {
 	description   string
-	minioClient   *minio.Client
+	session       *main.S3Session
 	bucketName    string
 	shouldFail    bool
 	expectedError string
@@ -138,21 +143,21 @@ func TestS3BucketExists(t *testing.T) {
 	testCases := []s3BucketExistsTestSpecification{
 		{
 			description:   "NoMinioClient",
-			minioClient:   nil,
+			session:       nil,
 			bucketName:    "",
 			shouldFail:    true,
 			expectedError: "Minio Client is nil",
 		},
 		{
 			description:   "EmptyBucketName",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "",
 			shouldFail:    true,
 			expectedError: "Bucket name is not set",
 		},
 		{
 			description:   "NotAccessibleClient",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "bucket",
 			shouldFail:    true,
 			expectedError: "connect: connection refused",
@@ -162,7 +167,7 @@ func TestS3BucketExists(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.description, func(t *testing.T) {
 			_, err := main.S3BucketExists(ctx,
-				testCase.minioClient, testCase.bucketName)
+				testCase.session, testCase.bucketName)
 
 			// check for error
 			if testCase.shouldFail {
@@ -178,7 +183,7 @@ func TestS3BucketExists(t *testing.T) {
 // Test case specification structure for function main.storeTableNames
 type storeTableTestSpecification struct {
 	description   string
-	minioClient   *minio.Client
+	session       *main.S3Session
 	bucketName    string
 	objectName    string
 	tableNames    []main.TableName
@@ -194,7 +199,7 @@ func TestStoreTable(t *testing.T) {
 	testCases := []storeTableTestSpecification{
 		{
 			description:   "NoMinioClient",
-			minioClient:   nil,
+			session:       nil,
 			bucketName:    "",
 			objectName:    "",
 			tableNames:    []main.TableName{},
@@ -203,7 +208,7 @@ func TestStoreTable(t *testing.T) {
 		},
 		{
 			description:   "EmptyBucketName",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "",
 			objectName:    "",
 			tableNames:    []main.TableName{},
@@ -212,7 +217,7 @@ func TestStoreTable(t *testing.T) {
 		},
 		{
 			description:   "EmptyObjectName",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "bucket",
 			objectName:    "",
 			tableNames:    []main.TableName{},
@@ -221,7 +226,7 @@ func TestStoreTable(t *testing.T) {
 		},
 		{
 			description:   "NotAccessibleClient",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "bucket",
 			objectName:    "object",
 			tableNames:    []main.TableName{},
@@ -230,7 +235,7 @@ func TestStoreTable(t *testing.T) {
 		},
 		{
 			description:   "NotAccessibleClient",
-			minioClient:   mustConstructMinioClient(t),
+			session:       mustConstructS3Session(t),
 			bucketName:    "bucket",
 			objectName:    "object",
 			tableNames:    []main.TableName{main.TableName("first"), main.TableName("second")},
@@ -241,9 +246,9 @@ func TestStoreTable(t *testing.T) {
 	// run all specified test cases
 	for _, testCase := range testCases {
 		t.Run(testCase.description, func(t *testing.T) {
-			err := main.StoreTableNames(ctx, testCase.minioClient,
+			err := main.StoreTableNames(ctx, testCase.session,
 				testCase.bucketName, testCase.objectName,
-				testCase.tableNames)
+				testCase.tableNames, "none", nil)
 
 			// check for error
 			if testCase.shouldFail {
@@ -255,3 +260,86 @@ func TestStoreTable(t *testing.T) {
 		})
 	}
 }
+
+// TestPutObjectStream checks that data written by given function are
+// uploaded into S3 object by multipart upload
+func TestPutObjectStream(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	err := main.PutObjectStream(context.Background(), session, "bucket",
+		"prefix/table.csv", "text/csv", "gzip", nil, func(output io.Writer) error {
+			for i := 0; i < 1000; i++ {
+				_, err := io.WriteString(output, "row\n")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	assert.NoError(t, err)
+
+	data, found := s3.objects["/bucket/prefix/table.csv.gz"]
+	assert.True(t, found)
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("row\n", 1000), string(content))
+}
+
+// TestPutObjectStreamWriteError checks that error returned by the write
+// function is returned and no object is stored
+func TestPutObjectStreamWriteError(t *testing.T) {
+	s3, session := startFakeS3(t)
+	writeErr := errors.New("read table failed")
+
+	err := main.PutObjectStream(context.Background(), session, "bucket",
+		"table.csv", "text/csv", "none", nil, func(output io.Writer) error {
+			_, err := io.WriteString(output, "first row\n")
+			assert.NoError(t, err)
+			return writeErr
+		})
+	assert.ErrorIs(t, err, writeErr)
+
+	assert.Empty(t, s3.objects)
+	assert.Empty(t, s3.uploads)
+}
+
+// TestPutObjectStreamUploadError checks that writing is stopped when upload
+// fails
+func TestPutObjectStreamUploadError(t *testing.T) {
+	err := main.PutObjectStream(context.Background(), mustConstructS3Session(t),
+		"bucket", "table.csv", "text/csv", "none", nil, func(output io.Writer) error {
+			// writer is blocked until the data are read by upload
+			for {
+				_, err := io.WriteString(output, "row\n")
+				if err != nil {
+					return err
+				}
+			}
+		})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "connect: connection refused")
+}
+
+// TestStoreTableStreamed checks that table is streamed into S3 object
+func TestStoreTableStreamed(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	storage, err := main.NewStorage(&main.StorageConfiguration{
+		Driver:           "sqlite3",
+		SQLiteDataSource: prepareSQLiteDatabase(t),
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, storage.Close())
+	}()
+
+	err = storage.StoreTable(context.Background(), session, "bucket",
+		"prefix", "report", NoLimits, "csv")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "id,report\n1,first\n2,second\n",
+		string(s3.objects["/bucket/prefix/report.csv"]))
+}
@@ -0,0 +1,486 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of lock object that guards
+// destination prefix in S3 bucket. The lock is acquired before the export
+// writes any object and it is released after the run, so two exporters
+// (for example in different environments) can't write into the same prefix
+// simultaneously. Lock that has not been released (crashed run) expires
+// after configured TTL.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3lock.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// lockObject is name of lock object stored with configured prefix
+const lockObject = "_lock.json"
+
+// lockRenewals is number of renewals of the lock during its TTL, so a
+// renewal that fails is retried before the lock expires
+const lockRenewals = 3
+
+// lockRequestExpiry is validity of presigned request the lock is written by
+const lockRequestExpiry = 15 * time.Minute
+
+// conditions of lock writes, the lock is created only when it does not
+// exist and it is replaced only when it has not been changed since it was
+// read
+const (
+	ifNoneMatchHeader = "If-None-Match"
+	ifMatchHeader     = "If-Match"
+	anyETag           = "*"
+)
+
+// messages
+const (
+	prefixIsLocked      = "prefix %s is locked by %s until %s"
+	lockTakenOver       = "lock of prefix %s has been acquired by %s"
+	lockWriteFailed     = "write of export lock failed: %s"
+	exportLockAcquired  = "Export lock acquired"
+	exportLockReleased  = "Export lock released"
+	exportLockFailed    = "Unable to acquire export lock"
+	releaseLockFailed   = "Unable to release export lock"
+	renewLockFailed     = "Unable to renew export lock"
+	exportLockLost      = "Export lock lost, export is canceled"
+	expiredLockReplaced = "Expired export lock replaced"
+	lockHolderMsg       = "holder"
+	lockExpiresMsg      = "expires at"
+)
+
+// errLockChanged is returned when condition of lock write does not hold,
+// because the lock has been written or removed by another exporter
+var errLockChanged = errors.New("export lock has been changed")
+
+// ExportLock represents content of lock object
+type ExportLock struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Expired method checks if the lock has expired at given time
+func (lock ExportLock) Expired(now time.Time) bool {
+	return !now.Before(lock.ExpiresAt)
+}
+
+// lockHolder function constructs ID of lock holder from host name and ID of
+// the run
+func lockHolder(runID string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname + "/" + runID
+}
+
+// readExportLock function reads lock object from S3 together with its ETag,
+// nil is returned when the object does not exist
+func readExportLock(ctx context.Context, session *S3Session) (*ExportLock, string, error) {
+	object, err := session.Client().GetObject(ctx, session.Bucket(),
+		session.ObjectName(lockObject), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = object.Close()
+	}()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == noSuchKeyErrorCode {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	info, err := object.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var lock ExportLock
+	err = json.Unmarshal(data, &lock)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &lock, info.ETag, nil
+}
+
+// writeExportLock function writes lock object into S3 only when given
+// condition holds and returns ETag of written lock. errLockChanged is
+// returned when the condition does not hold. Lock is never compressed.
+func writeExportLock(ctx context.Context, session *S3Session, lock ExportLock,
+	condition, etag string) (string, error) {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return "", err
+	}
+
+	objectName := session.ObjectName(lockObject)
+	header := http.Header{}
+	header.Set(condition, etag)
+
+	var written string
+	err = session.withTimeout(ctx, s3PutOperation,
+		s3ArtifactLocation(session.Bucket(), objectName),
+		func(ctx context.Context) error {
+			var err error
+			written, err = putConditionally(ctx, session, objectName, data, header)
+			return err
+		})
+	return written, err
+}
+
+// putConditionally function writes object by presigned request carrying
+// given conditional headers, because Minio client can't send
+// If-None-Match: * itself. ETag of written object is returned.
+func putConditionally(ctx context.Context, session *S3Session, objectName string,
+	data []byte, condition http.Header) (string, error) {
+	// headers are not signed by signature v2
+	signedHeader := condition
+	if session.configuration.SignatureVersion == s3SignatureV2 {
+		signedHeader = nil
+	}
+
+	presigned, err := session.Client().PresignHeader(ctx, http.MethodPut,
+		session.Bucket(), objectName, lockRequestExpiry, nil, signedHeader)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		presigned.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	for name, values := range condition {
+		request.Header[name] = values
+	}
+
+	response, err := session.HTTPClient().Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return strings.Trim(response.Header.Get("ETag"), `"`), nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// conflict is reported when another conditional write is in progress
+		return "", errLockChanged
+	default:
+		return "", fmt.Errorf(lockWriteFailed, response.Status)
+	}
+}
+
+// quotedETag function returns ETag in form used by conditional headers
+func quotedETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// lockTakenOverError function constructs error reported when lock has been
+// written by another exporter at the same time
+func lockTakenOverError(ctx context.Context, session *S3Session) error {
+	other := "nobody"
+	current, _, err := readExportLock(ctx, session)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		other = current.Holder
+	}
+	return &PrefixLockedError{Prefix: session.ObjectName(""), Holder: other}
+}
+
+// acquireExportLock function acquires lock of prefix configured for given
+// session for given holder. Lock object is created only when it does not
+// exist. Lock held by another holder that has not expired yet is refused,
+// otherwise it is replaced only when it has not been changed since it was
+// read, so only one of exporters running at the same time acquires the
+// lock. Acquired lock is returned together with its ETag.
+func acquireExportLock(ctx context.Context, session *S3Session, holder string,
+	ttl time.Duration, now time.Time, logger *zerolog.Logger) (ExportLock, string, error) {
+	lock := ExportLock{
+		Holder:     holder,
+		AcquiredAt: now.UTC(),
+		ExpiresAt:  now.Add(ttl).UTC(),
+	}
+
+	etag, err := writeExportLock(ctx, session, lock, ifNoneMatchHeader, anyETag)
+	if errors.Is(err, errLockChanged) {
+		etag, err = replaceExportLock(ctx, session, lock, now, logger)
+	}
+	if errors.Is(err, errLockChanged) {
+		err = lockTakenOverError(ctx, session)
+	}
+	if err != nil {
+		return ExportLock{}, "", err
+	}
+
+	logger.Info().
+		Str(lockHolderMsg, holder).
+		Time(lockExpiresMsg, lock.ExpiresAt).
+		Msg(exportLockAcquired)
+	return lock, etag, nil
+}
+
+// replaceExportLock function replaces existing lock object by given lock
+// when the existing lock has expired or when it is held by the same holder
+func replaceExportLock(ctx context.Context, session *S3Session, lock ExportLock,
+	now time.Time, logger *zerolog.Logger) (string, error) {
+	current, etag, err := readExportLock(ctx, session)
+	if err != nil {
+		return "", err
+	}
+
+	// lock has been released in the meantime
+	if current == nil {
+		return writeExportLock(ctx, session, lock, ifNoneMatchHeader, anyETag)
+	}
+
+	if current.Holder != lock.Holder {
+		if !current.Expired(now) {
+			return "", &PrefixLockedError{
+				Prefix: session.ObjectName(""),
+				Holder: current.Holder,
+				Until:  current.ExpiresAt.Format(time.RFC3339),
+			}
+		}
+		logger.Warn().
+			Str(lockHolderMsg, current.Holder).
+			Time(lockExpiresMsg, current.ExpiresAt).
+			Msg(expiredLockReplaced)
+	}
+
+	return writeExportLock(ctx, session, lock, ifMatchHeader, quotedETag(etag))
+}
+
+// renewExportLock function extends expiration of lock with given ETag and
+// returns ETag of renewed lock. Lock that has been changed by another
+// exporter is not renewed.
+func renewExportLock(ctx context.Context, session *S3Session, lock ExportLock,
+	etag string) (string, error) {
+	etag, err := writeExportLock(ctx, session, lock, ifMatchHeader, quotedETag(etag))
+	if errors.Is(err, errLockChanged) {
+		return "", lockTakenOverError(ctx, session)
+	}
+	return etag, err
+}
+
+// releaseExportLock function releases lock of prefix configured for given
+// session. Lock acquired by another holder (after this one expired) is kept.
+func releaseExportLock(ctx context.Context, session *S3Session, holder string,
+	logger *zerolog.Logger) error {
+	current, _, err := readExportLock(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	if current == nil || current.Holder != holder {
+		return nil
+	}
+
+	err = session.Client().RemoveObject(ctx, session.Bucket(),
+		session.ObjectName(lockObject), minio.RemoveObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str(lockHolderMsg, holder).Msg(exportLockReleased)
+	return nil
+}
+
+// heldExportLock represents lock acquired by the run. The lock is renewed
+// in background until it is released and the export is canceled when the
+// lock is lost. Nil lock (locking is disabled) is never lost.
+type heldExportLock struct {
+	session *S3Session
+	lock    ExportLock
+	etag    string
+	ttl     time.Duration
+	logger  *zerolog.Logger
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	done    chan struct{}
+
+	mutex sync.Mutex
+	err   error
+}
+
+// keep method renews the lock periodically until it is released. Failed
+// renewal is retried by the next one, the lock is lost when it has been
+// changed by another exporter or when it expires before it is renewed.
+func (held *heldExportLock) keep() {
+	defer close(held.done)
+
+	ticker := time.NewTicker(held.ttl / lockRenewals)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-held.stop:
+			return
+		case now := <-ticker.C:
+			err := held.renew(now)
+			if err == nil {
+				continue
+			}
+			held.logger.Err(err).Msg(renewLockFailed)
+			if errors.Is(err, ErrPrefixLocked) || held.lock.Expired(now) {
+				held.lose(err)
+				return
+			}
+		}
+	}
+}
+
+// renew method extends expiration of the lock by its TTL
+func (held *heldExportLock) renew(now time.Time) error {
+	lock := held.lock
+	lock.ExpiresAt = now.Add(held.ttl).UTC()
+
+	etag, err := renewExportLock(context.Background(),
+		held.session, lock, held.etag)
+	if err != nil {
+		return err
+	}
+
+	held.lock, held.etag = lock, etag
+	return nil
+}
+
+// lose method records reason of lost lock and cancels the export
+func (held *heldExportLock) lose(err error) {
+	held.mutex.Lock()
+	held.err = err
+	held.mutex.Unlock()
+
+	held.logger.Error().Err(err).Msg(exportLockLost)
+	held.cancel()
+}
+
+// Lost method returns reason why the lock has been lost, nil is returned
+// while the lock is held
+func (held *heldExportLock) Lost() error {
+	if held == nil {
+		return nil
+	}
+
+	held.mutex.Lock()
+	defer held.mutex.Unlock()
+	return held.err
+}
+
+// release method stops renewals of the lock and releases it. Lock that has
+// been lost is kept, because it is held by another exporter.
+func (held *heldExportLock) release() {
+	if held == nil {
+		return
+	}
+
+	close(held.stop)
+	<-held.done
+	held.cancel()
+
+	if held.Lost() != nil {
+		return
+	}
+
+	// lock is released even when the export has been canceled
+	err := releaseExportLock(context.Background(),
+		held.session, held.lock.Holder, held.logger)
+	if err != nil {
+		held.logger.Err(err).Msg(releaseLockFailed)
+	}
+}
+
+// exportedIntoS3 function checks if any data are written into S3 by export
+// selected on command line
+func exportedIntoS3(cliFlags CliFlags) bool {
+	for _, output := range parseOutputs(cliFlags.Output) {
+		if output == s3Output {
+			return true
+		}
+	}
+	return false
+}
+
+// lockExportPrefix function acquires lock of configured S3 prefix when the
+// export writes into S3 and locking is enabled. The lock is renewed until
+// it is released. Returned context is canceled when the lock is lost.
+func lockExportPrefix(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	runID string, logger *zerolog.Logger) (context.Context, *heldExportLock, int, error) {
+	ttl := GetS3Configuration(configuration).LockTTL
+	if ttl <= 0 || !dataExportSelected(cliFlags) || !exportedIntoS3(cliFlags) {
+		return ctx, nil, ExitStatusOK, nil
+	}
+
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return ctx, nil, ExitStatusS3Error, err
+	}
+
+	lock, etag, err := acquireExportLock(ctx, session,
+		lockHolder(runID), ttl, time.Now(), logger)
+	if err != nil {
+		logger.Err(err).Msg(exportLockFailed)
+		if errors.Is(err, ErrPrefixLocked) {
+			return ctx, nil, ExitStatusLockContention, err
+		}
+		return ctx, nil, ExitStatusS3Error, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	held := &heldExportLock{
+		session: session,
+		lock:    lock,
+		etag:    etag,
+		ttl:     ttl,
+		logger:  logger,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go held.keep()
+
+	return ctx, held, ExitStatusOK, nil
+}
@@ -0,0 +1,259 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3lock_test.html
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// lockObjectPath is path of lock object stored by fake S3
+const lockObjectPath = "/bucket/prefix/_lock.json"
+
+// mustOpenLockSession helper function starts fake S3 server and opens
+// session connected to it
+func mustOpenLockSession(t *testing.T) (*fakeS3, *main.S3Session) {
+	s3, address := startFakeS3Server(t)
+
+	session, err := main.OpenS3Session(lockConfiguration(address, 0))
+	assert.NoError(t, err)
+
+	return s3, session
+}
+
+// lockConfiguration helper function constructs configuration of export
+// into fake S3 with given TTL of lock, lock is written by presigned
+// requests, so credentials are needed
+func lockConfiguration(address string, ttl time.Duration) *main.ConfigStruct {
+	return &main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:     address,
+			AccessKeyID:     "access",
+			SecretAccessKey: "secret",
+			Region:          "us-east-1",
+			Bucket:          "bucket",
+			Prefix:          "prefix",
+			LockTTL:         ttl,
+		},
+	}
+}
+
+// storeLock helper function stores lock object into fake S3 as another
+// exporter would do
+func storeLock(t *testing.T, s3 *fakeS3, lock main.ExportLock) {
+	data, err := json.Marshal(lock)
+	assert.NoError(t, err)
+
+	s3.mutex.Lock()
+	defer s3.mutex.Unlock()
+	s3.objects[lockObjectPath] = data
+}
+
+// storedLock helper function reads lock object stored by fake S3
+func storedLock(t *testing.T, s3 *fakeS3) main.ExportLock {
+	s3.mutex.Lock()
+	defer s3.mutex.Unlock()
+
+	var lock main.ExportLock
+	assert.NoError(t, json.Unmarshal(s3.objects[lockObjectPath], &lock))
+	return lock
+}
+
+// TestExportLockExpired checks the method ExportLock.Expired
+func TestExportLockExpired(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	lock := main.ExportLock{Holder: "host/1", ExpiresAt: now}
+
+	assert.False(t, lock.Expired(now.Add(-time.Second)))
+	assert.True(t, lock.Expired(now))
+	assert.True(t, lock.Expired(now.Add(time.Second)))
+}
+
+// TestAcquireAndReleaseExportLock checks that lock object is written with
+// holder and TTL and removed when released
+func TestAcquireAndReleaseExportLock(t *testing.T) {
+	s3, session := mustOpenLockSession(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	lock, etag, err := main.AcquireExportLock(context.Background(), session, "host/1",
+		time.Hour, now, &log.Logger)
+	assert.NoError(t, err)
+
+	expected := main.ExportLock{
+		Holder:     "host/1",
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	assert.Equal(t, expected, lock)
+	assert.Equal(t, expected, storedLock(t, s3))
+	assert.NotEmpty(t, etag)
+
+	// the same holder can acquire the lock again
+	_, _, err = main.AcquireExportLock(context.Background(), session, "host/1",
+		time.Hour, now.Add(time.Minute), &log.Logger)
+	assert.NoError(t, err)
+
+	err = main.ReleaseExportLock(context.Background(), session, "host/1", &log.Logger)
+	assert.NoError(t, err)
+	assert.NotContains(t, s3.objects, lockObjectPath)
+
+	// releasing lock that is not held is not an error
+	err = main.ReleaseExportLock(context.Background(), session, "host/1", &log.Logger)
+	assert.NoError(t, err)
+}
+
+// TestAcquireExportLockHeldByOther checks that lock held by another holder
+// is refused until it expires
+func TestAcquireExportLockHeldByOther(t *testing.T) {
+	s3, session := mustOpenLockSession(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	_, _, err := main.AcquireExportLock(context.Background(), session, "stage/1",
+		time.Hour, now, &log.Logger)
+	assert.NoError(t, err)
+
+	_, _, err = main.AcquireExportLock(context.Background(), session, "prod/2",
+		time.Hour, now.Add(30*time.Minute), &log.Logger)
+	assert.EqualError(t, err,
+		"prefix prefix/ is locked by stage/1 until 2024-01-02T04:04:05Z")
+	assert.ErrorIs(t, err, main.ErrPrefixLocked)
+	assert.Equal(t, "stage/1", storedLock(t, s3).Holder)
+
+	// lock of another holder is not released
+	err = main.ReleaseExportLock(context.Background(), session, "prod/2", &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "stage/1", storedLock(t, s3).Holder)
+
+	// expired lock is replaced
+	_, _, err = main.AcquireExportLock(context.Background(), session, "prod/2",
+		time.Hour, now.Add(time.Hour), &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod/2", storedLock(t, s3).Holder)
+}
+
+// TestRenewExportLock checks that lock is renewed only when it has not been
+// changed by another exporter
+func TestRenewExportLock(t *testing.T) {
+	s3, session := mustOpenLockSession(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	lock, etag, err := main.AcquireExportLock(context.Background(), session, "stage/1",
+		time.Hour, now, &log.Logger)
+	assert.NoError(t, err)
+
+	lock.ExpiresAt = now.Add(2 * time.Hour)
+	etag, err = main.RenewExportLock(context.Background(), session, lock, etag)
+	assert.NoError(t, err)
+	assert.Equal(t, lock, storedLock(t, s3))
+
+	// expired lock has been replaced by another exporter
+	storeLock(t, s3, main.ExportLock{Holder: "prod/2", ExpiresAt: now.Add(3 * time.Hour)})
+
+	lock.ExpiresAt = now.Add(3 * time.Hour)
+	_, err = main.RenewExportLock(context.Background(), session, lock, etag)
+	assert.EqualError(t, err, "lock of prefix prefix/ has been acquired by prod/2")
+	assert.ErrorIs(t, err, main.ErrPrefixLocked)
+	assert.Equal(t, "prod/2", storedLock(t, s3).Holder)
+}
+
+// TestLockExportPrefixRenewal checks that held lock is renewed until it is
+// released and that the export is canceled when the lock is lost
+func TestLockExportPrefixRenewal(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+	configuration := lockConfiguration(address, 300*time.Millisecond)
+	cliFlags := main.CliFlags{Output: "S3"}
+
+	ctx, lock, exitStatus, err := main.LockExportPrefix(context.Background(),
+		configuration, cliFlags, "1", &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, exitStatus)
+
+	// expiration of the lock is extended
+	acquired := storedLock(t, s3)
+	assert.Eventually(t, func() bool {
+		return storedLock(t, s3).ExpiresAt.After(acquired.ExpiresAt)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, lock.Lost())
+	assert.NoError(t, ctx.Err())
+
+	// lock is taken over by another exporter
+	storeLock(t, s3, main.ExportLock{Holder: "other/2", ExpiresAt: time.Now().Add(time.Hour)})
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("export has not been canceled")
+	}
+	assert.ErrorIs(t, lock.Lost(), main.ErrPrefixLocked)
+
+	// lock held by another exporter is kept
+	main.ReleaseHeldExportLock(lock)
+	assert.Equal(t, "other/2", storedLock(t, s3).Holder)
+}
+
+// TestLockExportPrefixRelease checks that held lock is removed when it is
+// released
+func TestLockExportPrefixRelease(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+	configuration := lockConfiguration(address, time.Hour)
+
+	_, lock, _, err := main.LockExportPrefix(context.Background(), configuration,
+		main.CliFlags{Output: "S3"}, "1", &log.Logger)
+	assert.NoError(t, err)
+
+	main.ReleaseHeldExportLock(lock)
+	assert.NoError(t, lock.Lost())
+	assert.NotContains(t, s3.objects, lockObjectPath)
+}
+
+// TestLockExportPrefixDisabled checks that no lock is acquired when
+// locking is not configured
+func TestLockExportPrefixDisabled(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	_, lock, exitStatus, err := main.LockExportPrefix(context.Background(),
+		lockConfiguration(address, 0), main.CliFlags{Output: "S3"}, "1", &log.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, exitStatus)
+	assert.NoError(t, lock.Lost())
+	assert.Empty(t, s3.objects)
+
+	// releasing of disabled lock does nothing
+	main.ReleaseHeldExportLock(lock)
+}
+
+// TestExportedIntoS3 checks the function exportedIntoS3
+func TestExportedIntoS3(t *testing.T) {
+	assert.True(t, main.ExportedIntoS3(main.CliFlags{Output: "S3"}))
+	assert.True(t, main.ExportedIntoS3(main.CliFlags{Output: "file, S3"}))
+	assert.True(t, main.ExportedIntoS3(main.CliFlags{Output: "S3", Bundle: "zip"}))
+	assert.False(t, main.ExportedIntoS3(main.CliFlags{Output: "file"}))
+	assert.False(t, main.ExportedIntoS3(main.CliFlags{Output: "sftp"}))
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains retries of uploads into S3. Upload that failed
+// because of transient error (server error, throttling, reset connection or
+// timeout) is repeated with exponential backoff and random jitter, so a
+// single failed request does not fail the whole multi-hour export. Table
+// streamed into S3 is read from database again when its upload is retried.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3retry.html
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+)
+
+// messages
+const (
+	retryingS3Operation = "Retrying S3 operation"
+	s3OperationKey      = "operation"
+	s3TargetKey         = "target"
+)
+
+// S3 error codes that are worth retrying
+var transientS3Codes = map[string]bool{
+	"InternalError":      true,
+	"RequestTimeout":     true,
+	"ServiceUnavailable": true,
+	"SlowDown":           true,
+}
+
+// isTransientS3Error function checks if given error is caused by S3 or
+// network problem that may disappear when the operation is retried
+func isTransientS3Error(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var final finalError
+	if errors.As(err, &final) {
+		return false
+	}
+
+	var response minio.ErrorResponse
+	if errors.As(err, &response) {
+		return response.StatusCode >= http.StatusInternalServerError ||
+			response.StatusCode == http.StatusTooManyRequests ||
+			transientS3Codes[response.Code]
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// s3RetryBackoff function returns delay before given retry (counted from
+// one) without jitter. Delay is doubled with every retry and limited by
+// maximal backoff when it is configured.
+func s3RetryBackoff(s3Configuration S3Configuration, retry int) time.Duration {
+	backoff := s3Configuration.RetryBackoff
+	maxBackoff := s3Configuration.RetryMaxBackoff
+	for i := 1; i < retry; i++ {
+		backoff *= 2
+		if maxBackoff > 0 && backoff >= maxBackoff {
+			break
+		}
+	}
+	if maxBackoff > 0 && backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// retryOperation method performs given S3 operation with timeout configured
+// for it. Operation that failed because of transient error is retried with
+// backoff as many times as configured for the session. Operation can mark
+// its error by finalError to prevent retries.
+func (session *S3Session) retryOperation(ctx context.Context, operation, target string,
+	perform func(context.Context) error) error {
+	for retry := 1; ; retry++ {
+		started := time.Now()
+		err := session.withTimeout(ctx, operation, target, perform)
+		session.recordRequest(operation, err, time.Since(started))
+
+		// cancelled export is not retried
+		if retry > session.configuration.Retries || ctx.Err() != nil ||
+			!isTransientS3Error(err) {
+			var final finalError
+			if errors.As(err, &final) {
+				return final.err
+			}
+			return err
+		}
+
+		session.instrumentation().AddCounter(metricS3Retries,
+			MetricLabels{metricLabelOperation: operation}, 1)
+
+		log.Warn().Err(err).
+			Str(s3OperationKey, operation).
+			Str(s3TargetKey, target).
+			Int(retryAttemptMsg, retry).
+			Msg(retryingS3Operation)
+
+		err = sleepContext(ctx, s3RetryBackoff(session.configuration, retry)+
+			startJitter(session.configuration.RetryJitter))
+		if err != nil {
+			return err
+		}
+	}
+}
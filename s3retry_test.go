@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3retry_test.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestIsTransientS3Error checks the function IsTransientS3Error
+func TestIsTransientS3Error(t *testing.T) {
+	assert.False(t, main.IsTransientS3Error(nil))
+	assert.False(t, main.IsTransientS3Error(errors.New("access denied")))
+
+	assert.True(t, main.IsTransientS3Error(minio.ErrorResponse{
+		StatusCode: http.StatusServiceUnavailable}))
+	assert.True(t, main.IsTransientS3Error(minio.ErrorResponse{
+		StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, main.IsTransientS3Error(minio.ErrorResponse{
+		StatusCode: http.StatusBadRequest, Code: "RequestTimeout"}))
+	assert.False(t, main.IsTransientS3Error(minio.ErrorResponse{
+		StatusCode: http.StatusForbidden, Code: "AccessDenied"}))
+
+	assert.True(t, main.IsTransientS3Error(fmt.Errorf("put: %w", syscall.ECONNRESET)))
+	assert.True(t, main.IsTransientS3Error(io.ErrUnexpectedEOF))
+	assert.True(t, main.IsTransientS3Error(context.DeadlineExceeded))
+}
+
+// TestRetryS3Operation checks that operation failed because of transient
+// error is retried as many times as configured
+func TestRetryS3Operation(t *testing.T) {
+	session := main.NewS3Session(nil, main.S3Configuration{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	})
+	ctx := context.Background()
+	transient := minio.ErrorResponse{StatusCode: http.StatusInternalServerError}
+
+	// operation succeeds after retry
+	attempts := 0
+	err := main.RetryS3Operation(session, ctx, "put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			if attempts < 2 {
+				return transient
+			}
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// retries are exhausted
+	attempts = 0
+	err = main.RetryS3Operation(session, ctx, "put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			return transient
+		})
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 3, attempts)
+
+	// other errors are not retried
+	attempts = 0
+	err = main.RetryS3Operation(session, ctx, "put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			return errors.New("access denied")
+		})
+	assert.EqualError(t, err, "access denied")
+	assert.Equal(t, 1, attempts)
+
+	// operation is not retried when retries are not configured
+	attempts = 0
+	err = main.RetryS3Operation(main.NewS3Session(nil, main.S3Configuration{}), ctx,
+		"put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			return transient
+		})
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// TestRetryS3OperationCancelled checks that cancelled export is not retried
+func TestRetryS3OperationCancelled(t *testing.T) {
+	session := main.NewS3Session(nil,
+		main.S3Configuration{Retries: 5, RetryBackoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := main.RetryS3Operation(session, ctx, "put", "s3://bucket/object",
+		func(context.Context) error {
+			attempts++
+			cancel()
+			return context.Canceled
+		})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+}
+
+// TestPutObjectStreamRetry checks that streamed object is written again
+// when its upload is retried, while error of the write function is not
+// retried
+func TestPutObjectStreamRetry(t *testing.T) {
+	// retries of Minio client itself would hide the failure
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	defer func() {
+		minio.MaxRetry = maxRetry
+	}()
+
+	s3, session := startFakeS3WithConfiguration(t, main.S3Configuration{
+		Retries:      3,
+		RetryBackoff: time.Millisecond,
+	})
+	s3.failedPuts = 1
+	ctx := context.Background()
+
+	writes := 0
+	err := main.PutObjectStream(ctx, session, "bucket", "table.csv",
+		"text/csv", "none", nil, func(output io.Writer) error {
+			writes++
+			_, err := output.Write([]byte("a,b\n1,2\n"))
+			return err
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, writes)
+	assert.Equal(t, "a,b\n1,2\n", string(s3.objects["/bucket/table.csv"]))
+
+	writes = 0
+	err = main.PutObjectStream(ctx, session, "bucket", "table.csv",
+		"text/csv", "none", nil, func(output io.Writer) error {
+			writes++
+			return io.ErrUnexpectedEOF
+		})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 1, writes)
+}
@@ -0,0 +1,403 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of S3 session shared by all
+// operations that read or write objects in S3/Minio storage (connection
+// check, export, upload of operation log etc.). Clients are cached by
+// connection settings, so all uploads use the same client, transport and
+// context.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3session.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// messages
+const (
+	preparingS3Connection = "Preparing connection"
+	s3ConnectionCreated   = "Connection established"
+	s3ConnectionReused    = "Reusing connection"
+	s3EndpointMsg         = "S3 endpoint"
+	s3OperationTimedOut   = "S3 %s of %s timed out after %v: %w"
+	unknownSignature      = "unknown signature version %q, v2 or v4 can be used"
+	unknownBucketLookup   = "unknown bucket lookup %q, auto, dns or path can be used"
+)
+
+// signature versions of S3 requests
+const (
+	s3SignatureV2 = "v2"
+	s3SignatureV4 = "v4"
+)
+
+// bucket lookup types
+const (
+	s3BucketLookupAuto = "auto"
+	s3BucketLookupDNS  = "dns"
+	s3BucketLookupPath = "path"
+)
+
+// S3 operations with configurable timeouts
+const (
+	s3StatOperation         = "stat"
+	s3PutOperation          = "put"
+	s3BucketExistsOperation = "bucket check"
+	s3MakeBucketOperation   = "bucket creation"
+)
+
+// S3Session represents connection to S3/Minio storage together with
+// configuration of bucket and prefix objects are stored into
+type S3Session struct {
+	client        *minio.Client
+	httpClient    *http.Client
+	configuration S3Configuration
+	// artifacts is log stored objects are recorded into, checksums enables
+	// checksum sidecars of stored objects and CSV objects are converted by
+	// profile when it is set
+	artifacts *ArtifactLog
+	checksums bool
+	profile   *OutputProfile
+	// metrics is backend S3 requests are reported into
+	metrics Metrics
+}
+
+// s3ClientKey contains connection settings clients are cached by
+type s3ClientKey struct {
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	useSSL          bool
+	signature       string
+	bucketLookup    string
+	tlsMinVersion   string
+	tlsCipherSuites string
+	region          string
+}
+
+// s3Connection contains Minio client together with HTTP client sharing its
+// transport, the latter sends requests Minio client can't construct
+type s3Connection struct {
+	client     *minio.Client
+	httpClient *http.Client
+}
+
+// clients cached by connection settings and context shared by all sessions
+var (
+	s3ClientsMutex sync.Mutex
+	s3Clients      = map[s3ClientKey]s3Connection{}
+	s3Context      = context.Background()
+)
+
+// buckets created (or found) by sessions indexed by endpoint and bucket
+// name, so existence of bucket is checked only once per run
+var (
+	s3BucketsMutex   sync.Mutex
+	s3CreatedBuckets = map[string]bool{}
+)
+
+// s3Endpoint function returns address of S3 endpoint including port when
+// it is configured
+func s3Endpoint(s3Configuration S3Configuration) string {
+	if s3Configuration.EndpointPort == 0 {
+		return s3Configuration.EndpointURL
+	}
+	return fmt.Sprintf("%s:%d", s3Configuration.EndpointURL, s3Configuration.EndpointPort)
+}
+
+// OpenS3Session function returns session connected to S3/Minio storage
+// selected by configuration. Client constructed for the same connection
+// settings before is reused.
+func OpenS3Session(configuration *ConfigStruct) (*S3Session, error) {
+	// check if configuration structure has been provided
+	if configuration == nil {
+		err := ErrNilConfiguration
+		log.Error().Err(err).Msg(configurationError)
+		return nil, err
+	}
+
+	// retrieve S3/Minio configuration
+	s3Configuration := GetS3Configuration(configuration)
+
+	connection, err := s3Client(s3ClientKey{
+		endpoint:        s3Endpoint(s3Configuration),
+		accessKeyID:     s3Configuration.AccessKeyID,
+		secretAccessKey: s3Configuration.SecretAccessKey,
+		useSSL:          s3Configuration.UseSSL,
+		signature:       s3Configuration.SignatureVersion,
+		bucketLookup:    s3Configuration.BucketLookup,
+		tlsMinVersion:   s3Configuration.TLSMinVersion,
+		tlsCipherSuites: strings.Join(s3Configuration.TLSCipherSuites, ","),
+		region:          s3Configuration.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	session := &S3Session{
+		client:        connection.client,
+		httpClient:    connection.httpClient,
+		configuration: s3Configuration,
+	}
+
+	if s3Configuration.CreateBucket {
+		err = session.createBucket()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// openS3Session method returns session connected to S3/Minio storage
+// selected by configuration. Objects stored by the session are recorded as
+// artifacts of the export, they get checksum sidecars when enabled and CSV
+// objects are formatted by profile selected for S3.
+func (storage DBStorage) openS3Session(configuration *ConfigStruct) (*S3Session, error) {
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	session.artifacts = storage.artifacts
+	session.checksums = storage.checksums
+	session.profile = outputProfile(configuration, s3Output)
+	session.metrics = storage.metrics
+	return session, nil
+}
+
+// createBucket method creates configured bucket when it does not exist.
+// Bucket is checked only by the first session connected to it.
+func (session *S3Session) createBucket() error {
+	key := s3Endpoint(session.configuration) + "/" + session.configuration.Bucket
+
+	s3BucketsMutex.Lock()
+	defer s3BucketsMutex.Unlock()
+
+	if s3CreatedBuckets[key] {
+		return nil
+	}
+
+	err := s3CreateBucket(s3Context, session, session.configuration.Bucket,
+		session.configuration.Region)
+	if err != nil {
+		return err
+	}
+
+	s3CreatedBuckets[key] = true
+	return nil
+}
+
+// s3Client function returns client cached for given connection settings or
+// constructs new one
+func s3Client(key s3ClientKey) (s3Connection, error) {
+	s3ClientsMutex.Lock()
+	defer s3ClientsMutex.Unlock()
+
+	if connection, found := s3Clients[key]; found {
+		log.Debug().Str(s3EndpointMsg, key.endpoint).Msg(s3ConnectionReused)
+		return connection, nil
+	}
+
+	log.Info().Str(s3EndpointMsg, key.endpoint).Msg(preparingS3Connection)
+
+	transport, err := minio.DefaultTransport(key.useSSL)
+	if err != nil {
+		log.Error().Err(err).Msg(unableToInitializeConnection)
+		return s3Connection{}, err
+	}
+
+	// configured TLS settings are applied on top of defaults of Minio client
+	transport.TLSClientConfig, err = configureTLS(transport.TLSClientConfig, key.tls())
+	if err != nil {
+		log.Error().Err(err).Msg(unableToInitializeConnection)
+		return s3Connection{}, err
+	}
+
+	creds, err := s3Credentials(key)
+	if err != nil {
+		log.Error().Err(err).Msg(unableToInitializeConnection)
+		return s3Connection{}, err
+	}
+
+	bucketLookup, err := s3BucketLookup(key.bucketLookup)
+	if err != nil {
+		log.Error().Err(err).Msg(unableToInitializeConnection)
+		return s3Connection{}, err
+	}
+
+	// initialize Minio client object
+	client, err := minio.New(key.endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       key.useSSL,
+		Transport:    transport,
+		BucketLookup: bucketLookup,
+		Region:       key.region,
+	})
+
+	// check if client has been constructed properly
+	if err != nil {
+		log.Error().Err(err).Msg(unableToInitializeConnection)
+		return s3Connection{}, err
+	}
+
+	connection := s3Connection{
+		client:     client,
+		httpClient: &http.Client{Transport: transport},
+	}
+	s3Clients[key] = connection
+
+	log.Info().Msg(s3ConnectionCreated)
+	return connection, nil
+}
+
+// tls method returns TLS settings clients are cached by
+func (key s3ClientKey) tls() TLSConfiguration {
+	config := TLSConfiguration{MinVersion: key.tlsMinVersion}
+	if key.tlsCipherSuites != "" {
+		config.CipherSuites = strings.Split(key.tlsCipherSuites, ",")
+	}
+	return config
+}
+
+// checkS3SignatureVersion function checks if given signature version of S3
+// requests is supported. Empty value means signature v4.
+func checkS3SignatureVersion(signature string) error {
+	switch signature {
+	case "", s3SignatureV4, s3SignatureV2:
+		return nil
+	default:
+		return fmt.Errorf(unknownSignature, signature)
+	}
+}
+
+// s3Credentials function returns static credentials that sign requests by
+// selected signature version
+func s3Credentials(key s3ClientKey) (*credentials.Credentials, error) {
+	err := checkS3SignatureVersion(key.signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.signature == s3SignatureV2 {
+		return credentials.NewStaticV2(key.accessKeyID, key.secretAccessKey, ""), nil
+	}
+	return credentials.NewStaticV4(key.accessKeyID, key.secretAccessKey, ""), nil
+}
+
+// s3BucketLookup function converts configured bucket lookup type into type
+// used by Minio client. Empty value means that the lookup type is detected
+// automatically.
+func s3BucketLookup(lookup string) (minio.BucketLookupType, error) {
+	switch lookup {
+	case "", s3BucketLookupAuto:
+		return minio.BucketLookupAuto, nil
+	case s3BucketLookupDNS:
+		return minio.BucketLookupDNS, nil
+	case s3BucketLookupPath:
+		return minio.BucketLookupPath, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf(unknownBucketLookup, lookup)
+	}
+}
+
+// operationTimeout method returns timeout of given S3 operation configured
+// for the session, zero is returned when no timeout is configured
+func (session *S3Session) operationTimeout(operation string) time.Duration {
+	switch operation {
+	case s3StatOperation:
+		return session.configuration.StatTimeout
+	case s3PutOperation:
+		return session.configuration.PutTimeout
+	case s3BucketExistsOperation, s3MakeBucketOperation:
+		return session.configuration.BucketExistsTimeout
+	default:
+		return 0
+	}
+}
+
+// withTimeout method performs given S3 operation with timeout configured
+// for it. Error returned by operation that timed out describes the operation
+// and its target (bucket or object), so hung endpoint can be recognized.
+func (session *S3Session) withTimeout(ctx context.Context, operation, target string,
+	perform func(context.Context) error) error {
+	timeout := session.operationTimeout(operation)
+	if timeout <= 0 {
+		return perform(ctx)
+	}
+
+	operationCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := perform(operationCtx)
+
+	// only timeout of this operation is reported, not cancellation of the
+	// whole export
+	if err != nil && ctx.Err() == nil &&
+		errors.Is(operationCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf(s3OperationTimedOut, operation, target, timeout, err)
+	}
+
+	return err
+}
+
+// Client method returns Minio client used by the session
+func (session *S3Session) Client() *minio.Client {
+	return session.client
+}
+
+// HTTPClient method returns HTTP client sharing transport with Minio client
+// of the session, it sends requests the Minio client does not support
+func (session *S3Session) HTTPClient() *http.Client {
+	return session.httpClient
+}
+
+// Context method returns context shared by all operations of the session
+func (session *S3Session) Context() context.Context {
+	return s3Context
+}
+
+// Bucket method returns name of configured bucket
+func (session *S3Session) Bucket() string {
+	return session.configuration.Bucket
+}
+
+// Prefix method returns configured prefix of object names
+func (session *S3Session) Prefix() string {
+	return session.configuration.Prefix
+}
+
+// ObjectName method returns name of object with configured prefix
+func (session *S3Session) ObjectName(name string) string {
+	return setObjectPrefix(session.configuration.Prefix, name)
+}
@@ -0,0 +1,292 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3session_test.html
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// s3SessionConfiguration helper function returns configuration of S3
+// connection used by tests
+func s3SessionConfiguration(port uint, bucket, prefix string) *main.ConfigStruct {
+	return &main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:     "localhost",
+			EndpointPort:    port,
+			AccessKeyID:     "foobar",
+			SecretAccessKey: "foobar",
+			Bucket:          bucket,
+			Prefix:          prefix,
+		},
+	}
+}
+
+// TestOpenS3Session checks that session contains configured bucket and
+// prefix
+func TestOpenS3Session(t *testing.T) {
+	session, err := main.OpenS3Session(s3SessionConfiguration(1234, "test", "prefix"))
+	assert.NoError(t, err)
+
+	assert.NotNil(t, session.Client())
+	assert.NotNil(t, session.Context())
+	assert.Equal(t, "test", session.Bucket())
+	assert.Equal(t, "prefix", session.Prefix())
+	assert.Equal(t, "prefix/report.csv", session.ObjectName("report.csv"))
+}
+
+// TestOpenS3SessionNilConfiguration checks that nil configuration is
+// refused
+func TestOpenS3SessionNilConfiguration(t *testing.T) {
+	session, err := main.OpenS3Session(nil)
+	assert.EqualError(t, err, "Configuration is nil")
+	assert.Nil(t, session)
+}
+
+// TestOpenS3SessionReusesClient checks that client is shared by sessions
+// with the same connection settings
+func TestOpenS3SessionReusesClient(t *testing.T) {
+	first, err := main.OpenS3Session(s3SessionConfiguration(1235, "test", "first"))
+	assert.NoError(t, err)
+
+	// bucket and prefix are not part of connection settings
+	second, err := main.OpenS3Session(s3SessionConfiguration(1235, "other", "second"))
+	assert.NoError(t, err)
+
+	assert.Same(t, first.Client(), second.Client())
+	assert.Equal(t, first.Context(), second.Context())
+	assert.Equal(t, "other", second.Bucket())
+	assert.Equal(t, "second", second.Prefix())
+
+	// client returned by NewS3Connection is shared too
+	client, _, err := main.NewS3Connection(s3SessionConfiguration(1235, "test", ""))
+	assert.NoError(t, err)
+	assert.Same(t, first.Client(), client)
+
+	// different endpoint needs its own client
+	third, err := main.OpenS3Session(s3SessionConfiguration(1236, "test", "first"))
+	assert.NoError(t, err)
+	assert.NotSame(t, first.Client(), third.Client())
+}
+
+// TestOpenS3SessionSignatureV2 checks that requests are signed by selected
+// signature version and bucket is addressed in path style
+func TestOpenS3SessionSignatureV2(t *testing.T) {
+	var authorization, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// region of the bucket is read before the first request
+		if _, found := r.URL.Query()["location"]; found {
+			_, _ = io.WriteString(w, `<LocationConstraint>us-east-1</LocationConstraint>`)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.Path
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	assert.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := s3SessionConfiguration(uint(portNumber), "test", "")
+	configuration.S3.EndpointURL = host
+	configuration.S3.SignatureVersion = "v2"
+	configuration.S3.BucketLookup = "path"
+
+	session, err := main.OpenS3Session(configuration)
+	assert.NoError(t, err)
+
+	found, err := session.Client().BucketExists(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	assert.True(t, strings.HasPrefix(authorization, "AWS foobar:"), authorization)
+	assert.Equal(t, "/test/", path)
+
+	// signature v4 is used by default, it needs its own client
+	configuration.S3.SignatureVersion = ""
+	session, err = main.OpenS3Session(configuration)
+	assert.NoError(t, err)
+
+	_, err = session.Client().BucketExists(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256"), authorization)
+}
+
+// TestOpenS3SessionUnknownSignature checks that unsupported signature
+// version and bucket lookup are refused
+func TestOpenS3SessionUnknownSignature(t *testing.T) {
+	configuration := s3SessionConfiguration(1237, "test", "")
+	configuration.S3.SignatureVersion = "v3"
+
+	_, err := main.OpenS3Session(configuration)
+	assert.EqualError(t, err, `unknown signature version "v3", v2 or v4 can be used`)
+
+	configuration.S3.SignatureVersion = "v2"
+	configuration.S3.BucketLookup = "virtual"
+
+	_, err = main.OpenS3Session(configuration)
+	assert.EqualError(t, err, `unknown bucket lookup "virtual", auto, dns or path can be used`)
+}
+
+// startHangingS3Server helper function starts S3 server that never responds
+// and returns its host and port
+func startHangingS3Server(t *testing.T) (string, uint) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	assert.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	return host, uint(portNumber)
+}
+
+// TestS3OperationTimeouts checks that S3 operations fail when hung endpoint
+// does not respond in configured time
+func TestS3OperationTimeouts(t *testing.T) {
+	host, port := startHangingS3Server(t)
+
+	session, err := main.OpenS3Session(&main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:         host,
+			EndpointPort:        port,
+			StatTimeout:         50 * time.Millisecond,
+			PutTimeout:          60 * time.Millisecond,
+			BucketExistsTimeout: 70 * time.Millisecond,
+		},
+	})
+	assert.NoError(t, err)
+
+	ctx := session.Context()
+
+	_, err = main.S3BucketExists(ctx, session, "bucket")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "S3 bucket check of bucket timed out after 70ms")
+
+	_, err = main.S3ObjectExists(ctx, session, "bucket", "report.csv")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "S3 stat of s3://bucket/report.csv timed out after 50ms")
+
+	err = main.PutObject(ctx, session, "bucket", "report.csv", "text/csv",
+		[]byte("id\n1\n"), "none", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "S3 put of s3://bucket/report.csv timed out after 60ms")
+
+	err = main.PutObjectStream(ctx, session, "bucket", "rule_hit.csv", "text/csv", "none", nil,
+		func(output io.Writer) error {
+			_, err := io.WriteString(output, "id\n1\n")
+			return err
+		})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "S3 put of s3://bucket/rule_hit.csv timed out after 60ms")
+}
+
+// TestS3OperationTimeoutsWithContext checks that timeouts of session are
+// applied to given context and that cancellation of the context itself is
+// not reported as timeout of operation
+func TestS3OperationTimeoutsWithContext(t *testing.T) {
+	host, port := startHangingS3Server(t)
+
+	session, err := main.OpenS3Session(&main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:         host,
+			EndpointPort:        port,
+			BucketExistsTimeout: time.Hour,
+		},
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = main.S3BucketExists(ctx, session, "bucket")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "timed out after")
+}
+
+// TestOpenS3SessionCreateBucket checks that missing bucket is created when
+// it is enabled by configuration
+func TestOpenS3SessionCreateBucket(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	configuration := &main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:  address,
+			Bucket:       "created",
+			CreateBucket: true,
+			Region:       "us-east-1",
+		},
+	}
+
+	_, err := main.OpenS3Session(configuration)
+	assert.NoError(t, err)
+	assert.Contains(t, s3.objects, "/created/")
+
+	// existence of bucket is checked only by the first session
+	delete(s3.objects, "/created/")
+	_, err = main.OpenS3Session(configuration)
+	assert.NoError(t, err)
+	assert.NotContains(t, s3.objects, "/created/")
+}
+
+// TestOpenS3SessionExistingBucket checks that existing bucket is not
+// created again and that bucket is not created when it is not enabled
+func TestOpenS3SessionExistingBucket(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+	s3.objects["/existing/"] = []byte("bucket")
+
+	_, err := main.OpenS3Session(&main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL:  address,
+			Bucket:       "existing",
+			CreateBucket: true,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bucket"), s3.objects["/existing/"])
+
+	_, err = main.OpenS3Session(&main.ConfigStruct{
+		S3: main.S3Configuration{
+			EndpointURL: address,
+			Bucket:      "missing",
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, s3.objects, "/missing/")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains settings of multipart uploads into S3. Tables
+// are streamed into S3 by multipart upload, size of one part and number of
+// parts uploaded concurrently can be configured. Parts are kept in memory
+// during upload and each part is retried separately by Minio client, so the
+// part size is trade-off between memory consumption and amount of data sent
+// again over flaky links.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3upload.html
+
+import (
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// limits of size of one part of multipart upload given by S3 API, the
+// largest object consists of 10000 parts
+const (
+	defaultPartSize = 16 * 1024 * 1024
+	minPartSize     = 5 * 1024 * 1024
+	maxPartSize     = 5 * 1024 * 1024 * 1024
+)
+
+// messages
+const (
+	partSizeOutOfRange = "must be between %d and %d bytes, found %d"
+)
+
+// checkPartSize function checks configured size of one part of multipart
+// upload, zero selects the default size
+func checkPartSize(size int64) error {
+	if size != 0 && (size < minPartSize || size > maxPartSize) {
+		return fmt.Errorf(partSizeOutOfRange, minPartSize, maxPartSize, size)
+	}
+	return nil
+}
+
+// partSize method returns size of one part of multipart upload configured
+// for the session
+func (session *S3Session) partSize() int {
+	if session.configuration.PartSize > 0 {
+		return int(session.configuration.PartSize)
+	}
+	return defaultPartSize
+}
+
+// setUploadOptions method sets part size and number of concurrently
+// uploaded parts configured for the session into given options. Parts of
+// object of unknown size are buffered concurrently only when more than one
+// part can be uploaded at once, so memory consumption is part size times
+// concurrency.
+func (session *S3Session) setUploadOptions(options *minio.PutObjectOptions) {
+	options.PartSize = uint64(session.partSize())
+	options.NumThreads = 1
+	if session.configuration.UploadConcurrency > 1 {
+		options.NumThreads = uint(session.configuration.UploadConcurrency)
+	}
+	options.ConcurrentStreamParts = options.NumThreads > 1
+}
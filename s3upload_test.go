@@ -0,0 +1,76 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/s3upload_test.html
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+const mebibyte = 1024 * 1024
+
+// TestSetUploadOptions checks the function SetUploadOptions
+func TestSetUploadOptions(t *testing.T) {
+	// defaults are used when nothing is configured
+	var options minio.PutObjectOptions
+	main.SetUploadOptions(main.NewS3Session(nil, main.S3Configuration{}), &options)
+	assert.Equal(t, uint64(16*mebibyte), options.PartSize)
+	assert.Equal(t, uint(1), options.NumThreads)
+	assert.False(t, options.ConcurrentStreamParts)
+
+	options = minio.PutObjectOptions{}
+	main.SetUploadOptions(main.NewS3Session(nil,
+		main.S3Configuration{PartSize: 8 * mebibyte, UploadConcurrency: 3}), &options)
+	assert.Equal(t, uint64(8*mebibyte), options.PartSize)
+	assert.Equal(t, uint(3), options.NumThreads)
+	assert.True(t, options.ConcurrentStreamParts)
+}
+
+// TestPutObjectStreamPartSize checks that streamed object is uploaded in
+// parts of configured size
+func TestPutObjectStreamPartSize(t *testing.T) {
+	for _, concurrency := range []int{0, 2} {
+		s3, session := startFakeS3WithConfiguration(t, main.S3Configuration{
+			PartSize:          5 * mebibyte,
+			UploadConcurrency: concurrency,
+		})
+
+		data := bytes.Repeat([]byte("0123456789abcdef"), 12*mebibyte/16)
+		err := main.PutObjectStream(context.Background(), session, "bucket", "table.csv",
+			"text/csv", "none", nil, func(output io.Writer) error {
+				_, err := output.Write(data)
+				return err
+			})
+		assert.NoError(t, err)
+
+		assert.Equal(t, data, s3.objects["/bucket/table.csv"])
+		assert.ElementsMatch(t, []int{5 * mebibyte, 5 * mebibyte, 2 * mebibyte}, s3.partSizes)
+	}
+}
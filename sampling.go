@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains random sampling of exported tables. Instead of
+// the whole content, only randomly selected fraction of rows is exported from
+// each table, which is useful for representative datasets for local
+// development. PostgreSQL samples rows by TABLESAMPLE clause, other databases
+// by filter on random number.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sampling.html
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// messages
+const (
+	wrongSample = "sample must be greater than 0 and at most 1, found %v"
+)
+
+// samplingResolution is number of distinct values of random number compared
+// against sampled fraction when TABLESAMPLE is not supported
+const samplingResolution = 1000000
+
+// checkSample function checks if fraction of rows selected by command line
+// flag is valid. Zero means that the whole tables are exported.
+func checkSample(sample float64) error {
+	if sample == 0 {
+		return nil
+	}
+
+	if math.IsNaN(sample) || sample < 0 || sample > 1 {
+		return fmt.Errorf(wrongSample, sample)
+	}
+
+	return nil
+}
+
+// sampledTable method returns source of rows used in FROM clause of query
+// reading content of given table. When sampling is enabled, only randomly
+// selected fraction of rows is returned by the source.
+func (storage DBStorage) sampledTable(tableName TableName) string {
+	if storage.sample <= 0 || storage.sample >= 1 {
+		return string(tableName)
+	}
+
+	if storage.dbDriverType == DBDriverPostgres {
+		percentage := strconv.FormatFloat(storage.sample*100, 'f', -1, 64)
+		return fmt.Sprintf("%s TABLESAMPLE BERNOULLI (%s)", string(tableName), percentage)
+	}
+
+	threshold := int64(math.Round(storage.sample * samplingResolution))
+
+	// it is not possible to use parameter for table name
+	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G201
+	return fmt.Sprintf("(SELECT * FROM %s WHERE abs(random() %% %d) < %d)",
+		string(tableName), samplingResolution, threshold)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sampling_test.html
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestCheckSample checks the function checkSample
+func TestCheckSample(t *testing.T) {
+	for _, sample := range []float64{0, 0.01, 0.5, 1} {
+		assert.NoError(t, main.CheckSample(sample))
+	}
+
+	assert.EqualError(t, main.CheckSample(-0.1),
+		"sample must be greater than 0 and at most 1, found -0.1")
+	assert.EqualError(t, main.CheckSample(1.5),
+		"sample must be greater than 0 and at most 1, found 1.5")
+	assert.Error(t, main.CheckSample(math.NaN()))
+}
+
+// TestReadTableWithSample checks that PostgreSQL table is sampled by
+// TABLESAMPLE clause
+func TestReadTableWithSample(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	rows := mock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("INT4", int64(0)))
+	rows.AddRow(1)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT * FROM table_name TABLESAMPLE BERNOULLI (1) LIMIT 10")).
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetSample(storage, 0.01)
+
+	_, err := storage.ReadTable(context.Background(), "table_name", 10)
+	assert.NoError(t, err)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestPerformDataExportSQLiteSample checks that random fraction of rows is
+// exported from SQLite tables
+func TestPerformDataExportSQLiteSample(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY)",
+		`WITH RECURSIVE ids(id) AS
+		(SELECT 1 UNION ALL SELECT id + 1 FROM ids WHERE id < 1000)
+		INSERT INTO report SELECT id FROM ids`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+	}
+
+	exportedRows := func(sample float64) int {
+		directory := t.TempDir()
+		cliFlags := main.CliFlags{
+			Output:          "file",
+			Format:          "csv",
+			OutputDirectory: directory,
+			Limit:           NoLimits,
+			Sample:          sample,
+		}
+
+		code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+			&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+
+		data, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+		assert.NoError(t, err)
+
+		// header is not counted
+		return strings.Count(string(data), "\n") - 1
+	}
+
+	assert.Equal(t, 1000, exportedRows(1))
+
+	sampled := exportedRows(0.5)
+	assert.Greater(t, sampled, 350)
+	assert.Less(t, sampled, 650)
+}
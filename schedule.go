@@ -0,0 +1,227 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains start delay of scheduled exports. Exports of more
+// environments are usually scheduled at the same time, so random jitter can
+// be added before the export starts. The export can also be deferred while
+// the database is busy - when there are too many active connections or when
+// the replica lags too much behind primary database.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/schedule.html
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// SQL statements used to check load of database
+const (
+	// connections of the exporter itself are not counted
+	selectActiveConnections = `
+        SELECT count(*)
+          FROM pg_stat_activity
+         WHERE state = 'active'
+           AND pid <> pg_backend_pid()`
+
+	// lag is zero on primary database and on replica that has replayed
+	// all received changes (there might be no new transactions)
+	selectReplicationLag = `
+        SELECT CASE WHEN NOT pg_is_in_recovery()
+                      OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+                    THEN 0
+                    ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+               END`
+)
+
+// default delay between checks of database load
+const defaultLoadCheckInterval = time.Minute
+
+// messages
+const (
+	startJitterMsg         = "Export start delayed by jitter"
+	delayMsg               = "delay"
+	databaseBusyMsg        = "Database is busy, export is deferred"
+	databaseLoadCheckError = "Unable to check load of database"
+	databaseLoadMsg        = "Load of database"
+	reasonMsg              = "reason"
+	activeConnectionsMsg   = "active connections"
+	replicationLagMsg      = "replication lag"
+	tooManyConnections     = "%d active connections, at most %d allowed"
+	replicationLagTooHigh  = "replication lag %v, at most %v allowed"
+	databaseStillBusy      = "still busy after %v: %s"
+)
+
+// errDatabaseBusy is returned when the export has been deferred for too long
+var errDatabaseBusy = errors.New("database is busy")
+
+// ReadActiveConnections method reads number of active connections to
+// database
+func (storage DBStorage) ReadActiveConnections(ctx context.Context) (int, error) {
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := storage.connection.QueryRowContext(ctx, selectActiveConnections).Scan(&count)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectActiveConnections).Msg(sqlStatementExecutionError)
+		return 0, err
+	}
+	return count, nil
+}
+
+// ReadReplicationLag method reads how long the replica lags behind primary
+// database. Zero is returned for primary database.
+func (storage DBStorage) ReadReplicationLag(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	var seconds float64
+	err := storage.connection.QueryRowContext(ctx, selectReplicationLag).Scan(&seconds)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectReplicationLag).Msg(sqlStatementExecutionError)
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// loadCheckEnabled function checks if load of database needs to be checked
+// before the export starts
+func loadCheckEnabled(config ScheduleConfiguration) bool {
+	return config.MaxActiveConnections > 0 || config.MaxReplicationLag > 0
+}
+
+// databaseBusy method checks load of database. Reason is returned when the
+// database is busy, empty string otherwise.
+func (storage DBStorage) databaseBusy(ctx context.Context, config ScheduleConfiguration) (string, error) {
+	if config.MaxActiveConnections > 0 {
+		connections, err := storage.ReadActiveConnections(ctx)
+		if err != nil {
+			return "", err
+		}
+		storage.logger.Debug().Int(activeConnectionsMsg, connections).Msg(databaseLoadMsg)
+
+		if connections > config.MaxActiveConnections {
+			return fmt.Sprintf(tooManyConnections, connections, config.MaxActiveConnections), nil
+		}
+	}
+
+	if config.MaxReplicationLag > 0 {
+		lag, err := storage.ReadReplicationLag(ctx)
+		if err != nil {
+			return "", err
+		}
+		storage.logger.Debug().Dur(replicationLagMsg, lag).Msg(databaseLoadMsg)
+
+		if lag > config.MaxReplicationLag {
+			return fmt.Sprintf(replicationLagTooHigh, lag, config.MaxReplicationLag), nil
+		}
+	}
+
+	return "", nil
+}
+
+// startJitter function returns random delay from interval <0, maximum)
+func startJitter(maximum time.Duration) time.Duration {
+	if maximum <= 0 {
+		return 0
+	}
+	// #nosec G404 -- jitter does not need to be cryptographically secure
+	return time.Duration(rand.Int63n(int64(maximum)))
+}
+
+// sleepContext function waits for given time or until the context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitForStart function delays the start of export by random jitter and
+// then waits while the database is busy. Error is returned when the database
+// is busy for longer than configured maximal start delay.
+func waitForStart(ctx context.Context, storage *DBStorage, config ScheduleConfiguration,
+	operationLogger *zerolog.Logger) error {
+	jitter := startJitter(config.StartJitter)
+	if jitter > 0 {
+		for _, l := range []*zerolog.Logger{&storage.logger, operationLogger} {
+			l.Info().Dur(delayMsg, jitter).Msg(startJitterMsg)
+		}
+
+		err := sleepContext(ctx, jitter)
+		if err != nil {
+			return err
+		}
+	}
+
+	// load is checked on PostgreSQL only
+	if !loadCheckEnabled(config) || storage.dbDriverType != DBDriverPostgres {
+		return nil
+	}
+
+	interval := config.LoadCheckInterval
+	if interval <= 0 {
+		interval = defaultLoadCheckInterval
+	}
+
+	started := time.Now()
+
+	for {
+		reason, err := storage.databaseBusy(ctx, config)
+		if err != nil {
+			operationLogger.Err(err).Msg(databaseLoadCheckError)
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+
+		waited := time.Since(started)
+		if config.MaxStartDelay > 0 && waited+interval > config.MaxStartDelay {
+			return fmt.Errorf("%w: "+databaseStillBusy, errDatabaseBusy,
+				waited.Round(time.Second), reason)
+		}
+
+		for _, l := range []*zerolog.Logger{&storage.logger, operationLogger} {
+			l.Warn().Str(reasonMsg, reason).Dur(delayMsg, interval).Msg(databaseBusyMsg)
+		}
+
+		err = sleepContext(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/schedule_test.html
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// SQL statements checked by tests
+const (
+	activeConnectionsQuery = `SELECT count\(\*\)\s+FROM pg_stat_activity`
+	replicationLagQuery    = `SELECT CASE WHEN NOT pg_is_in_recovery\(\)`
+)
+
+// TestStartJitter checks that random delay is selected from given interval
+func TestStartJitter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), main.StartJitter(0))
+	assert.Equal(t, time.Duration(0), main.StartJitter(-time.Second))
+
+	for i := 0; i < 100; i++ {
+		jitter := main.StartJitter(time.Second)
+		assert.GreaterOrEqual(t, jitter, time.Duration(0))
+		assert.Less(t, jitter, time.Second)
+	}
+}
+
+// TestReadActiveConnections checks the method ReadActiveConnections
+func TestReadActiveConnections(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(activeConnectionsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	connections, err := storage.ReadActiveConnections(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 42, connections)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestReadReplicationLag checks the method ReadReplicationLag
+func TestReadReplicationLag(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(90.5))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	lag, err := storage.ReadReplicationLag(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 90500*time.Millisecond, lag)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWaitForStartNoLoadCheck checks that load is not checked when it is
+// disabled or when database is not PostgreSQL
+func TestWaitForStartNoLoadCheck(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	err := main.WaitForStart(context.Background(), storage,
+		main.ScheduleConfiguration{StartJitter: time.Millisecond}, &log.Logger)
+	assert.NoError(t, err)
+
+	storage = main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
+	err = main.WaitForStart(context.Background(), storage,
+		main.ScheduleConfiguration{MaxActiveConnections: 1}, &log.Logger)
+	assert.NoError(t, err)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWaitForStartDeferred checks that export is deferred while the
+// database is busy
+func TestWaitForStartDeferred(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(activeConnectionsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(600))
+	mock.ExpectQuery(activeConnectionsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(replicationLagQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(1))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	err := main.WaitForStart(context.Background(), storage, main.ScheduleConfiguration{
+		MaxActiveConnections: 5,
+		MaxReplicationLag:    time.Minute,
+		LoadCheckInterval:    time.Millisecond,
+	}, &log.Logger)
+	assert.NoError(t, err)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWaitForStartStillBusy checks that export fails when the database is
+// busy for longer than maximal start delay
+func TestWaitForStartStillBusy(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+
+	mock.ExpectQuery(activeConnectionsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	err := main.WaitForStart(context.Background(), storage, main.ScheduleConfiguration{
+		MaxActiveConnections: 5,
+		LoadCheckInterval:    time.Minute,
+		MaxStartDelay:        time.Second,
+	}, &log.Logger)
+	assert.ErrorIs(t, err, main.ErrDatabaseBusy)
+	assert.Contains(t, err.Error(), "10 active connections, at most 5 allowed")
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestWaitForStartCanceled checks that waiting is stopped when the context
+// is canceled
+func TestWaitForStartCanceled(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	err := main.WaitForStart(ctx, storage,
+		main.ScheduleConfiguration{StartJitter: time.Hour}, &log.Logger)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains handling of tables from more database schemas.
+// Schemas tables are exported from can be selected in configuration
+// (schemas option) or on command line (-schema flag). When tables from more
+// schemas or from schema other than public are exported, table names are
+// qualified by schema (schema.table), so the tables are found regardless of
+// search path, files and objects are named schema.table.csv and same-named
+// tables from different schemas don't overwrite each other.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/schemas.html
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaSeparator separates schema and table in schema-qualified table name
+const schemaSeparator = "."
+
+// configKeySeparator separates schema and table in keys of per-table
+// configuration sections (masking, casts, limits etc.). Dot can't be used
+// there, because viper splits configuration keys by dots.
+const configKeySeparator = ":"
+
+// defaultSchema is schema where tables are searched for when their names are
+// not qualified
+const defaultSchema = "public"
+
+// SQL statements
+const (
+	// Select all tables from selected schemas together with their schemas,
+	// placeholders for schema names are appended
+	selectListOfTablesInSelectedSchemas = `
+           SELECT schemaname, tablename
+             FROM pg_catalog.pg_tables
+            WHERE schemaname IN (%s);
+   `
+)
+
+// messages
+const (
+	tableNamesCollide = "tables %s would be exported into the same file or object"
+)
+
+// Schema method returns schema of schema-qualified table name, empty string
+// is returned for table name without schema
+func (tableName TableName) Schema() string {
+	schema, _, found := strings.Cut(string(tableName), schemaSeparator)
+	if !found {
+		return ""
+	}
+	return schema
+}
+
+// Name method returns table name without schema
+func (tableName TableName) Name() string {
+	_, name, found := strings.Cut(string(tableName), schemaSeparator)
+	if !found {
+		return string(tableName)
+	}
+	return name
+}
+
+// configKey method returns key of per-table configuration of given table,
+// schema and table are separated by configKeySeparator (schema:table)
+func (tableName TableName) configKey() string {
+	schema := tableName.Schema()
+	if schema == "" {
+		return string(tableName)
+	}
+	return schema + configKeySeparator + tableName.Name()
+}
+
+// lookupTableConfig function returns per-table configuration of given
+// table. Configuration stored under schema-qualified key (schema:table) is
+// preferred, configuration stored under table name without schema applies to
+// same-named tables from all schemas.
+func lookupTableConfig[V any](configuration map[string]V, tableName TableName) (V, bool) {
+	if value, found := configuration[tableName.configKey()]; found {
+		return value, true
+	}
+	value, found := configuration[tableName.Name()]
+	return value, found
+}
+
+// quoteTableName function quotes schema and name of given table as separate
+// identifiers, so schema-qualified table is created in its schema
+func quoteTableName(tableName TableName) string {
+	schema := tableName.Schema()
+	if schema == "" {
+		return quoteIdentifier(string(tableName))
+	}
+	return quoteIdentifier(schema) + schemaSeparator + quoteIdentifier(tableName.Name())
+}
+
+// createSchemaStatement function returns statement that creates schema of
+// given table, empty string is returned for table without schema
+func createSchemaStatement(tableName TableName) string {
+	schema := tableName.Schema()
+	if schema == "" {
+		return ""
+	}
+	return "CREATE SCHEMA IF NOT EXISTS " + quoteIdentifier(schema) + ";\n"
+}
+
+// selectListOfTablesInSchemas function returns query that reads list of
+// tables from selected schemas together with its parameters
+func selectListOfTablesInSchemas(schemas []string) (string, []interface{}) {
+	placeholders := make([]string, len(schemas))
+	args := make([]interface{}, len(schemas))
+
+	for i, schema := range schemas {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = schema
+	}
+
+	return fmt.Sprintf(selectListOfTablesInSelectedSchemas,
+		strings.Join(placeholders, ", ")), args
+}
+
+// qualifyTableNames function qualifies table names by their schemas when
+// tables come from more schemas or from schema other than public. Names are
+// kept as they are when all tables are stored in public schema (or when
+// schemas are not known, as for SQLite).
+func qualifyTableNames(schemas []string, tableNames []TableName) []TableName {
+	distinct := make(map[string]struct{})
+	for _, schema := range schemas {
+		distinct[schema] = struct{}{}
+	}
+
+	if len(distinct) == 0 {
+		return tableNames
+	}
+
+	if len(distinct) == 1 {
+		if _, found := distinct[defaultSchema]; found {
+			return tableNames
+		}
+		if _, found := distinct[""]; found {
+			return tableNames
+		}
+	}
+
+	qualified := make([]TableName, len(tableNames))
+	for i, tableName := range tableNames {
+		qualified[i] = TableName(schemas[i] + schemaSeparator + string(tableName))
+	}
+	return qualified
+}
+
+// checkTableNameCollisions function checks that no two tables would be
+// exported into the same file or object. Names are compared case
+// insensitively, because files are exported into case insensitive file
+// systems too.
+func checkTableNameCollisions(tableNames []TableName) error {
+	outputs := make(map[string][]string)
+	for _, tableName := range tableNames {
+		output := strings.ToLower(string(tableName))
+		outputs[output] = append(outputs[output], string(tableName))
+	}
+
+	var collisions []string
+	for _, tables := range outputs {
+		if len(tables) > 1 {
+			collisions = append(collisions, strings.Join(tables, " and "))
+		}
+	}
+
+	if len(collisions) == 0 {
+		return nil
+	}
+
+	// all collisions are reported in stable order
+	sort.Strings(collisions)
+	return fmt.Errorf(tableNamesCollide, strings.Join(collisions, ", "))
+}
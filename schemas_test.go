@@ -0,0 +1,258 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/schemas_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestTableNameSchema checks the method TableName.Schema
+func TestTableNameSchema(t *testing.T) {
+	assert.Equal(t, "public", main.TableName("public.report").Schema())
+	assert.Equal(t, "", main.TableName("report").Schema())
+}
+
+// TestTableNameName checks the method TableName.Name
+func TestTableNameName(t *testing.T) {
+	assert.Equal(t, "report", main.TableName("public.report").Name())
+	assert.Equal(t, "report", main.TableName("report").Name())
+}
+
+// TestReadListOfTablesOneSchema checks that table names are not qualified
+// when all tables are stored in one schema
+func TestReadListOfTablesOneSchema(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "report")
+	rows.AddRow("public", "rule_hit")
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readListOfTablesQueryPostgres).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	tableNames, err := storage.ReadListOfTables(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TableName{"report", "rule_hit"}, tableNames)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadListOfTablesMoreSchemas checks that table names are qualified by
+// schema when tables are stored in more schemas
+func TestReadListOfTablesMoreSchemas(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "report")
+	rows.AddRow("archive", "report")
+	rows.AddRow("public", "rule_hit")
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readListOfTablesQueryPostgres).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	tableNames, err := storage.ReadListOfTables(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TableName{
+		"public.report", "archive.report", "public.rule_hit"}, tableNames)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// expected query to read list of tables from selected schemas
+const readListOfTablesInSchemasQuery = `SELECT schemaname, tablename\s+FROM pg_catalog.pg_tables\s+WHERE schemaname IN \(\$1, \$2\)`
+
+// TestReadListOfTablesSelectedSchemas checks that tables are read from
+// selected schemas only
+func TestReadListOfTablesSelectedSchemas(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "report")
+	rows.AddRow("archive", "report")
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readListOfTablesInSchemasQuery).
+		WithArgs("public", "archive").
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	config := testConfig
+	config.Schemas = []string{"public", "archive"}
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &config)
+
+	// call the tested method
+	tableNames, err := storage.ReadListOfTables(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TableName{"public.report", "archive.report"}, tableNames)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadListOfTablesOtherSchema checks that table names are qualified
+// when all tables are stored in schema other than public
+func TestReadListOfTablesOtherSchema(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("archive", "report")
+	rows.AddRow("archive", "rule_hit")
+
+	// expected query performed by tested function
+	mock.ExpectQuery(`WHERE schemaname IN \(\$1\)`).
+		WithArgs("archive").
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	config := testConfig
+	config.Schemas = []string{"archive"}
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &config)
+
+	// call the tested method
+	tableNames, err := storage.ReadListOfTables(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.TableName{"archive.report", "archive.rule_hit"}, tableNames)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestCheckTableNameCollisionsNoCollision checks the function
+// checkTableNameCollisions for tables with distinct names
+func TestCheckTableNameCollisionsNoCollision(t *testing.T) {
+	err := main.CheckTableNameCollisions([]main.TableName{
+		"public.report", "archive.report", "report"})
+	assert.NoError(t, err)
+}
+
+// TestCheckTableNameCollisions checks the function checkTableNameCollisions
+// for tables that would be exported into the same file
+func TestCheckTableNameCollisions(t *testing.T) {
+	err := main.CheckTableNameCollisions([]main.TableName{
+		"public.Report", "public.rule_hit", "public.report", "RULE_HIT"})
+	assert.EqualError(t, err,
+		"tables public.Report and public.report would be exported into the same file or object")
+}
+
+// TestLookupTableConfig checks that per-table configuration is found for
+// schema-qualified tables too
+func TestLookupTableConfig(t *testing.T) {
+	configuration := map[string]string{
+		"report":         "any schema",
+		"archive:report": "archive schema",
+		"rule_hit":       "any schema",
+	}
+
+	for tableName, expected := range map[main.TableName]string{
+		"report":          "any schema",
+		"archive.report":  "archive schema",
+		"public.report":   "any schema",
+		"public.rule_hit": "any schema",
+	} {
+		value, found := main.LookupTableConfig(configuration, tableName)
+		assert.True(t, found, tableName)
+		assert.Equal(t, expected, value, tableName)
+	}
+
+	_, found := main.LookupTableConfig(configuration, "archive.rule_disable")
+	assert.False(t, found)
+}
+
+// TestQuoteTableName checks that schema and name of table are quoted as
+// separate identifiers
+func TestQuoteTableName(t *testing.T) {
+	assert.Equal(t, `"report"`, main.QuoteTableName("report"))
+	assert.Equal(t, `"archive"."report"`, main.QuoteTableName("archive.report"))
+	assert.Equal(t, `"odd""schema"."report"`, main.QuoteTableName(`odd"schema.report`))
+}
+
+// TestLoadConfigurationSchemaQualifiedKeys checks that per-table
+// configuration of schema-qualified tables can be loaded
+func TestLoadConfigurationSchemaQualifiedKeys(t *testing.T) {
+	os.Clearenv()
+
+	configFile := filepath.Join(t.TempDir(), "schema_qualified_keys.toml")
+	err := os.WriteFile(configFile, []byte(`
+[storage]
+db_driver = "sqlite3"
+sqlite_datasource = ":memory:"
+
+[masking."archive:report"]
+org_id = "drop"
+
+[limits]
+"archive:rule_hit" = 10
+`), 0o600)
+	assert.NoError(t, err)
+
+	mustSetEnv(t, "INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE", configFile)
+	configuration, err := main.LoadConfiguration("INSIGHTS_RESULTS_AGGREGATOR_EXPORTER_CONFIG_FILE", "foobar")
+	assert.NoError(t, err)
+
+	assert.Equal(t, main.MaskingConfiguration{"archive:report": {"org_id": "drop"}},
+		configuration.Masking)
+	assert.Equal(t, main.LimitsConfiguration{"archive:rule_hit": 10},
+		configuration.Limits)
+}
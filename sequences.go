@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of sequences and their current values.
+// Sequences are exported together with metadata into `_sequences.csv`, so
+// generation of IDs can be resumed correctly when the data are restored into
+// fresh database. Sequences are read from PostgreSQL only.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sequences.html
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// name of file or object with sequences
+const sequencesFile = "_sequences.csv"
+
+// SQL statement used to read all sequences with their current values.
+// last_value is NULL for sequences that have not been used yet.
+const selectSequences = `
+        SELECT schemaname, sequencename, last_value
+          FROM pg_catalog.pg_sequences
+         ORDER BY schemaname, sequencename`
+
+// messages
+const (
+	exportingSequences  = "Exporting sequences"
+	readSequencesFailed = "Read sequences failed"
+)
+
+// SequenceInfo contains schema-qualified name of sequence and its current
+// value. Value is not valid when the sequence has not been used yet.
+type SequenceInfo struct {
+	Name      string
+	LastValue sql.NullInt64
+}
+
+// ReadSequences method reads all sequences with their current values. Empty
+// list is returned for databases other than PostgreSQL.
+func (storage DBStorage) ReadSequences(ctx context.Context) ([]SequenceInfo, error) {
+	sequences := make([]SequenceInfo, 0)
+
+	if storage.dbDriverType != DBDriverPostgres {
+		return sequences, nil
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectSequences)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, selectSequences).Msg(sqlStatementExecutionError)
+		return sequences, err
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			schema   string
+			sequence SequenceInfo
+		)
+
+		err := rows.Scan(&schema, &sequence.Name, &sequence.LastValue)
+		if err != nil {
+			return sequences, err
+		}
+
+		// sequences are restored by qualified names
+		sequence.Name = schema + schemaSeparator + sequence.Name
+		sequences = append(sequences, sequence)
+	}
+
+	return sequences, rows.Err()
+}
+
+// SequencesToCSV function exports list of sequences and their current
+// values into CSV. Empty value is written for sequences that have not been
+// used yet.
+func SequencesToCSV(buffer io.Writer, sequences []SequenceInfo) error {
+	if buffer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	writer, release := newCSVWriter(buffer)
+	defer release()
+
+	err := writer.Write([]string{"Sequence name", "Last value"})
+	if err != nil {
+		return err
+	}
+
+	for _, sequence := range sequences {
+		lastValue := ""
+		if sequence.LastValue.Valid {
+			lastValue = strconv.FormatInt(sequence.LastValue.Int64, 10)
+		}
+
+		err := writer.Write([]string{sequence.Name, lastValue})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	// check for any error during export to CSV
+	return writer.Error()
+}
+
+// sequencesIntoCSV method reads all sequences and serializes them into CSV
+func (storage DBStorage) sequencesIntoCSV(ctx context.Context) ([]byte, error) {
+	sequences, err := storage.ReadSequences(ctx)
+	if err != nil {
+		storage.logger.Err(err).Msg(readSequencesFailed)
+		return nil, err
+	}
+
+	buffer := new(bytes.Buffer)
+	err = SequencesToCSV(buffer, sequences)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sequences_test.html
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// expected query to read sequences
+const readSequencesQuery = `SELECT schemaname, sequencename, last_value\s+FROM pg_catalog.pg_sequences`
+
+// TestReadSequences checks the method ReadSequences
+func TestReadSequences(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "sequencename", "last_value"})
+	rows.AddRow("public", "report_id_seq", 42)
+	rows.AddRow("public", "unused_seq", nil)
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readSequencesQuery).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	sequences, err := storage.ReadSequences(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []main.SequenceInfo{
+		{Name: "public.report_id_seq", LastValue: sql.NullInt64{Int64: 42, Valid: true}},
+		{Name: "public.unused_seq"},
+	}, sequences)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadSequencesError checks the method ReadSequences when the query
+// fails
+func TestReadSequencesError(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// expected query performed by tested method
+	mock.ExpectQuery(readSequencesQuery).WillReturnError(errors.New("permission denied"))
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	_, err := storage.ReadSequences(context.Background())
+	assert.EqualError(t, err, "permission denied")
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestReadSequencesSQLite checks that no sequences are read from SQLite
+func TestReadSequencesSQLite(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
+
+	// call the tested method
+	sequences, err := storage.ReadSequences(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, sequences)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
+// TestSequencesToCSV checks the function SequencesToCSV
+func TestSequencesToCSV(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.SequencesToCSV(buffer, []main.SequenceInfo{
+		{Name: "public.report_id_seq", LastValue: sql.NullInt64{Int64: 42, Valid: true}},
+		{Name: "public.unused_seq"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Sequence name,Last value\npublic.report_id_seq,42\npublic.unused_seq,\n",
+		buffer.String())
+}
+
+// TestSequencesToCSVNilBuffer checks the function SequencesToCSV with nil
+// buffer
+func TestSequencesToCSVNilBuffer(t *testing.T) {
+	err := main.SequencesToCSV(nil, nil)
+	assert.Error(t, err)
+}
+
+// TestPerformDataExportSequences checks that sequences are exported with
+// metadata and that they can be skipped
+func TestPerformDataExportSequences(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report"),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "_sequences.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Sequence name,Last value\n", string(content))
+
+	directory = t.TempDir()
+	cliFlags.OutputDirectory = directory
+	cliFlags.SkipArtifacts = "sequences"
+
+	code, err = main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.NoFileExists(t, filepath.Join(directory, "_sequences.csv"))
+}
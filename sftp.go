@@ -0,0 +1,262 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains upload of exported files into remote SFTP
+// server. All files (tables, list of tables, metadata, disabled rules and
+// operation log) are exported into temporary directory first and then they
+// are uploaded one by one or bundled into one archive.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sftp.html
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSH port used when no other port is configured
+const defaultSFTPPort = 22
+
+// messages
+const (
+	sftpAuthenticationNotSet = "password or private key file needs to be set"
+	sftpHostKeyCheckNotSet   = "known hosts file needs to be set or host key check disabled"
+	sftpHostKeyIgnored       = "Host key of SFTP server is not checked"
+	uploadingFileIntoSFTP    = "Uploading file into SFTP server"
+	remoteFileMsg            = "Remote file"
+)
+
+// sftpAddress function returns address of SFTP server in host:port form
+func sftpAddress(config SFTPConfiguration) string {
+	port := config.Port
+	if port == 0 {
+		port = defaultSFTPPort
+	}
+	return fmt.Sprintf("%s:%d", config.Host, port)
+}
+
+// sftpAuthMethods function constructs authentication methods from password
+// and private key configured for SFTP server
+func sftpAuthMethods(config SFTPConfiguration) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if config.PrivateKeyFile != "" {
+		// disable "G304 (CWE-22): Potential file inclusion via variable"
+		key, err := os.ReadFile(config.PrivateKeyFile) // #nosec G304
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if config.Password != "" {
+		methods = append(methods, ssh.Password(config.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New(sftpAuthenticationNotSet)
+	}
+
+	return methods, nil
+}
+
+// sftpHostKeyCallback function constructs callback that checks host key of
+// SFTP server against configured known_hosts file
+func sftpHostKeyCallback(config SFTPConfiguration) (ssh.HostKeyCallback, error) {
+	if config.KnownHostsFile != "" {
+		return knownhosts.New(config.KnownHostsFile)
+	}
+
+	if config.InsecureIgnoreHostKey {
+		log.Warn().Str("host", config.Host).Msg(sftpHostKeyIgnored)
+		// disable "G106 (CWE-322): Use of ssh InsecureIgnoreHostKey should be audited"
+		return ssh.InsecureIgnoreHostKey(), nil // #nosec G106
+	}
+
+	return nil, errors.New(sftpHostKeyCheckNotSet)
+}
+
+// NewSFTPConnection function initializes connection to SFTP server. Returned
+// function needs to be called to close the connection.
+func NewSFTPConnection(configuration *ConfigStruct) (*sftp.Client, func(), error) {
+	// check if configuration structure has been provided
+	if configuration == nil {
+		err := ErrNilConfiguration
+		log.Error().Err(err).Msg(configurationError)
+		return nil, nil, err
+	}
+
+	config := GetSFTPConfiguration(configuration)
+
+	authMethods, err := sftpAuthMethods(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	address := sftpAddress(config)
+	log.Info().Str("SFTP server", address).Msg("Preparing connection")
+
+	sshClient, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            config.Username,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, nil, err
+	}
+
+	log.Info().Msg("Connection established")
+	return client, func() {
+		if err := client.Close(); err != nil {
+			log.Err(err).Msg("Close SFTP connection")
+		}
+		if err := sshClient.Close(); err != nil {
+			log.Err(err).Msg("Close SSH connection")
+		}
+	}, nil
+}
+
+// uploadFileIntoSFTP function uploads content of local file into remote file
+// on SFTP server
+func uploadFileIntoSFTP(client *sftp.Client, localFile, remoteFile string) error {
+	log.Info().Str(remoteFileMsg, remoteFile).Msg(uploadingFileIntoSFTP)
+
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	fin, err := os.Open(localFile) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = fin.Close()
+	}()
+
+	fout, err := client.Create(remoteFile)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fout, fin)
+	if err != nil {
+		// error during copy is more important than error during close
+		_ = fout.Close()
+		return err
+	}
+
+	return fout.Close()
+}
+
+// storeFilesIntoSFTP function uploads all files from given directory into
+// remote directory on SFTP server. When bundle is selected, the files are
+// bundled into one archive before upload, the archive is encrypted when
+// encryption is given.
+func storeFilesIntoSFTP(client *sftp.Client, bundle, directory,
+	remoteDirectory string, encryption *Encryption) error {
+	if remoteDirectory != "" {
+		err := client.MkdirAll(remoteDirectory)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bundle != "" {
+		// bundle can't be stored into the directory it is constructed from
+		bundleDirectory, err := os.MkdirTemp("", "export-sftp")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.RemoveAll(bundleDirectory); err != nil {
+				log.Err(err).Msg("Remove bundle directory")
+			}
+		}()
+
+		fileName := bundleFile + bundleExtension(bundle)
+		localFile := filepath.Join(bundleDirectory, fileName)
+
+		err = storeBundleIntoFile(localFile, bundle, directory, encryption)
+		if err != nil {
+			return err
+		}
+
+		fileName += encryption.Extension()
+		return uploadFileIntoSFTP(client, localFile+encryption.Extension(),
+			path.Join(remoteDirectory, fileName))
+	}
+
+	fileNames, err := bundleFiles(directory)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		err := uploadFileIntoSFTP(client, filepath.Join(directory, fileName),
+			path.Join(remoteDirectory, fileName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeExportIntoSFTP function uploads all exported files from given
+// directory into SFTP server
+func storeExportIntoSFTP(configuration *ConfigStruct, bundle, directory string) error {
+	encryption, err := configuredEncryption(configuration)
+	if err != nil {
+		return err
+	}
+
+	client, closer, err := NewSFTPConnection(configuration)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	return storeFilesIntoSFTP(client, bundle, directory,
+		GetSFTPConfiguration(configuration).Directory, encryption)
+}
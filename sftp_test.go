@@ -0,0 +1,232 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sftp_test.html
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+const (
+	sftpTestUser     = "exporter"
+	sftpTestPassword = "secret"
+)
+
+// startSFTPServer helper function starts SFTP server that accepts one user
+// authenticated by password. Address of the server and its host key are
+// returned.
+func startSFTPServer(t *testing.T) (string, int, ssh.PublicKey) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == sftpTestUser && string(password) == sftpTestPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port, hostKey.PublicKey()
+}
+
+// serveSFTP helper function handles one SSH connection with SFTP subsystem
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for request := range channelRequests {
+				ok := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				_ = request.Reply(ok, nil)
+			}
+		}()
+
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		go func() {
+			_ = server.Serve()
+			_ = server.Close()
+		}()
+	}
+}
+
+// sftpTestConfiguration helper function constructs configuration of SFTP
+// server started for test
+func sftpTestConfiguration(t *testing.T, remoteDirectory string) *main.ConfigStruct {
+	host, port, hostKey := startSFTPServer(t)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := "[" + host + "]:" + strconv.Itoa(port) + " " + string(ssh.MarshalAuthorizedKey(hostKey))
+	assert.NoError(t, os.WriteFile(knownHosts, []byte(line), 0600))
+
+	return &main.ConfigStruct{
+		SFTP: main.SFTPConfiguration{
+			Host:           host,
+			Port:           port,
+			Username:       sftpTestUser,
+			Password:       sftpTestPassword,
+			KnownHostsFile: knownHosts,
+			Directory:      remoteDirectory,
+		},
+	}
+}
+
+// TestStoreExportIntoSFTP checks that all exported files are uploaded into
+// remote directory
+func TestStoreExportIntoSFTP(t *testing.T) {
+	remoteDirectory := filepath.Join(t.TempDir(), "drop", "export")
+	configuration := sftpTestConfiguration(t, remoteDirectory)
+
+	directory := prepareBundleDirectory(t)
+
+	err := main.StoreExportIntoSFTP(configuration, "", directory)
+	assert.NoError(t, err)
+
+	for name, content := range bundledFiles {
+		uploaded, err := os.ReadFile(filepath.Join(remoteDirectory, name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(uploaded))
+	}
+
+	// directories are not uploaded
+	assert.NoDirExists(t, filepath.Join(remoteDirectory, "subdirectory"))
+}
+
+// TestStoreBundleIntoSFTP checks that bundle with all exported files is
+// uploaded
+func TestStoreBundleIntoSFTP(t *testing.T) {
+	remoteDirectory := t.TempDir()
+	configuration := sftpTestConfiguration(t, remoteDirectory)
+
+	err := main.StoreExportIntoSFTP(configuration, "zip", prepareBundleDirectory(t))
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(remoteDirectory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "export.zip", entries[0].Name())
+}
+
+// TestStoreExportIntoSFTPWrongPassword checks that authentication failure
+// is reported
+func TestStoreExportIntoSFTPWrongPassword(t *testing.T) {
+	configuration := sftpTestConfiguration(t, t.TempDir())
+	configuration.SFTP.Password = "wrong"
+
+	err := main.StoreExportIntoSFTP(configuration, "", prepareBundleDirectory(t))
+	assert.Error(t, err)
+}
+
+// TestStoreExportIntoSFTPUnknownHostKey checks that server with host key
+// not found in known_hosts file is refused
+func TestStoreExportIntoSFTPUnknownHostKey(t *testing.T) {
+	remoteDirectory := t.TempDir()
+	configuration := sftpTestConfiguration(t, remoteDirectory)
+
+	// known_hosts file for other server
+	other := sftpTestConfiguration(t, remoteDirectory)
+	configuration.SFTP.KnownHostsFile = other.SFTP.KnownHostsFile
+
+	err := main.StoreExportIntoSFTP(configuration, "", prepareBundleDirectory(t))
+	assert.Error(t, err)
+
+	// host key check can be disabled
+	configuration.SFTP.KnownHostsFile = ""
+	configuration.SFTP.InsecureIgnoreHostKey = true
+
+	err = main.StoreExportIntoSFTP(configuration, "", prepareBundleDirectory(t))
+	assert.NoError(t, err)
+}
+
+// TestNewSFTPConnectionImproperConfiguration checks that configuration
+// without authentication or host key check is refused
+func TestNewSFTPConnectionImproperConfiguration(t *testing.T) {
+	_, _, err := main.NewSFTPConnection(nil)
+	assert.Error(t, err)
+
+	configuration := &main.ConfigStruct{
+		SFTP: main.SFTPConfiguration{
+			Host:           "localhost",
+			Username:       sftpTestUser,
+			KnownHostsFile: "known_hosts",
+		},
+	}
+	_, _, err = main.NewSFTPConnection(configuration)
+	assert.EqualError(t, err, "password or private key file needs to be set")
+
+	configuration.SFTP.Password = sftpTestPassword
+	configuration.SFTP.KnownHostsFile = ""
+	_, _, err = main.NewSFTPConnection(configuration)
+	assert.EqualError(t, err, "known hosts file needs to be set or host key check disabled")
+}
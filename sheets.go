@@ -0,0 +1,393 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains export of small reports into Google Sheets.
+// Metadata (number of records in tables), summary of the run and rules
+// disabled by more users are written into sheets of selected spreadsheet at
+// the end of the run, so spreadsheet used for weekly review updates itself.
+// Exporter authenticates as service account: JWT signed by its private key
+// is exchanged for access token.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sheets.html
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Google Sheets API and authentication
+const (
+	defaultSheetsEndpoint = "https://sheets.googleapis.com"
+	defaultTokenURI       = "https://oauth2.googleapis.com/token"
+	sheetsScope           = "https://www.googleapis.com/auth/spreadsheets"
+	jwtBearerGrantType    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	defaultSheetsTimeout  = 30 * time.Second
+	accessTokenLifetime   = time.Hour
+)
+
+// names of sheets reports are written into
+const (
+	metadataSheet      = "metadata"
+	summarySheet       = "summary"
+	disabledRulesSheet = "disabled_rules"
+)
+
+// messages
+const (
+	updatingSheets        = "Updating reports in Google Sheets"
+	updateSheetsFailed    = "Updating reports in Google Sheets failed"
+	sheetMsg              = "sheet"
+	sheetsStatusError     = "Google Sheets API returned status %d: %s"
+	tokenStatusError      = "token endpoint returned status %d: %s"
+	noPrivateKey          = "service account file does not contain PEM encoded private key"
+	notRSAPrivateKey      = "private key of service account is not RSA key"
+	noAccessToken         = "token endpoint did not return access token"
+	serviceAccountMissing = "service account file needs to be set for spreadsheet"
+)
+
+// SheetReport represents content of one sheet, values are written as they
+// are, the first row contains column names
+type SheetReport struct {
+	Sheet  string
+	Values [][]string
+}
+
+// serviceAccount contains fields of service account JSON key file needed to
+// get access token
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// sheetsEnabled function checks whether reports are written into Google
+// Sheets
+func sheetsEnabled(config GoogleSheetsConfiguration) bool {
+	return config.SpreadsheetID != ""
+}
+
+// checkGoogleSheets method checks configuration of Google Sheets spreadsheet
+func (c *configurationChecker) checkGoogleSheets(config GoogleSheetsConfiguration) {
+	if !sheetsEnabled(config) {
+		return
+	}
+
+	if strings.TrimSpace(config.ServiceAccountFile) == "" {
+		c.report("google_sheets.service_account_file", serviceAccountMissing)
+	}
+	if config.Timeout < 0 {
+		c.report("google_sheets.timeout",
+			fmt.Sprintf(durationMustNotBeNegative, config.Timeout))
+	}
+
+	c.checkTLS("google_sheets", sheetsTLS(config))
+}
+
+// sheetReports function returns reports written into sheets. Summary is
+// written always, metadata and disabled rules only when they have been
+// exported by the run.
+func sheetReports(summary *Summary) []SheetReport {
+	var reports []SheetReport
+
+	if records := summary.TableRecords(); len(records) > 0 {
+		values := [][]string{{"Table name", "Records"}}
+		for _, record := range records {
+			values = append(values, []string{string(record.Table), strconv.Itoa(record.Records)})
+		}
+		reports = append(reports, SheetReport{Sheet: metadataSheet, Values: values})
+	}
+
+	total := summary.TotalDuration()
+	values := [][]string{{"Stage", "Duration", "Share"}}
+	for _, stage := range stages {
+		duration := summary.Duration(stage)
+		values = append(values, []string{stage,
+			duration.Round(time.Millisecond).String(),
+			fmt.Sprintf("%.1f%%", share(duration, total))})
+	}
+	values = append(values,
+		[]string{wholeRun, total.Round(time.Millisecond).String(), fmt.Sprintf("%.1f%%", share(total, total))},
+		[]string{"Finished", summary.Finished().UTC().Format(time.RFC3339), ""},
+		[]string{"Exported tables", strconv.Itoa(summary.ExportedTables()), ""},
+		[]string{"Exported rows", strconv.Itoa(summary.ExportedRows()), ""},
+		[]string{"Rejected rows", strconv.Itoa(summary.RejectedRows()), ""})
+	reports = append(reports, SheetReport{Sheet: summarySheet, Values: values})
+
+	if disabledRules := summary.DisabledRules(); disabledRules != nil {
+		values := [][]string{{"Rule", "Count"}}
+		for _, disabledRule := range disabledRules {
+			values = append(values, []string{disabledRule.Rule, strconv.Itoa(disabledRule.Count)})
+		}
+		reports = append(reports, SheetReport{Sheet: disabledRulesSheet, Values: values})
+	}
+
+	return reports
+}
+
+// readServiceAccount function reads service account JSON key file
+func readServiceAccount(fileName string) (serviceAccount, error) {
+	var account serviceAccount
+
+	// file is selected by administrator in configuration file
+	// #nosec G304
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return account, err
+	}
+
+	err = json.Unmarshal(content, &account)
+	if err != nil {
+		return account, err
+	}
+
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+	return account, nil
+}
+
+// privateKey method parses RSA private key of service account
+func (account serviceAccount) privateKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New(noPrivateKey)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		// older keys are stored in PKCS #1 form
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(notRSAPrivateKey)
+	}
+	return rsaKey, nil
+}
+
+// assertion method constructs JWT signed by private key of service account
+// that is exchanged for access token
+func (account serviceAccount) assertion(now time.Time) (string, error) {
+	key, err := account.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(accessTokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+// accessToken method exchanges signed JWT for access token
+func (account serviceAccount) accessToken(ctx context.Context, client *http.Client) (string, error) {
+	assertion, err := account.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {jwtBearerGrantType},
+		"assertion":  {assertion},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = doJSONRequest(client, request, &token, tokenStatusError)
+	if err != nil {
+		return "", err
+	}
+
+	if token.AccessToken == "" {
+		return "", errors.New(noAccessToken)
+	}
+	return token.AccessToken, nil
+}
+
+// doJSONRequest function performs HTTP request by given client and decodes
+// JSON response into given value. Body of unsuccessful response is part of
+// returned error.
+func doJSONRequest(client *http.Client, request *http.Request, value interface{},
+	statusError string) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		body := new(bytes.Buffer)
+		_, _ = body.ReadFrom(response.Body)
+		return fmt.Errorf(statusError, response.StatusCode, strings.TrimSpace(body.String()))
+	}
+
+	if value == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// sheetsClient updates values in sheets of one spreadsheet
+type sheetsClient struct {
+	endpoint      string
+	spreadsheetID string
+	token         string
+	httpClient    *http.Client
+}
+
+// valuesURL method returns URL of values in given sheet, suffix selects
+// method of the API
+func (client sheetsClient) valuesURL(sheet, suffix string) string {
+	return strings.TrimSuffix(client.endpoint, "/") + "/v4/spreadsheets/" +
+		url.PathEscape(client.spreadsheetID) + "/values/" + url.PathEscape(sheet) + suffix
+}
+
+// call method calls Google Sheets API with given JSON body
+func (client sheetsClient) call(ctx context.Context, method, address string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, address, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+client.token)
+
+	return doJSONRequest(client.httpClient, request, nil, sheetsStatusError)
+}
+
+// UpdateSheet method replaces content of sheet by given report. The sheet
+// is cleared first, so no rows of longer previous report are kept.
+func (client sheetsClient) UpdateSheet(ctx context.Context, report SheetReport) error {
+	err := client.call(ctx, http.MethodPost, client.valuesURL(report.Sheet, ":clear"),
+		struct{}{})
+	if err != nil {
+		return err
+	}
+
+	return client.call(ctx, http.MethodPut,
+		client.valuesURL(report.Sheet, "?valueInputOption=RAW"),
+		map[string]interface{}{
+			"range":          report.Sheet,
+			"majorDimension": "ROWS",
+			"values":         report.Values,
+		})
+}
+
+// storeReportsIntoSheets function writes small reports produced by the run
+// into sheets of configured spreadsheet
+func storeReportsIntoSheets(ctx context.Context, configuration *ConfigStruct,
+	summary *Summary) (int, error) {
+	config := GetGoogleSheetsConfiguration(configuration)
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultSheetsTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	account, err := readServiceAccount(config.ServiceAccountFile)
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	tlsClient, err := httpClient(sheetsTLS(config))
+	if err != nil {
+		return ExitStatusConfigurationError, err
+	}
+
+	token, err := account.accessToken(ctx, tlsClient)
+	if err != nil {
+		return ExitStatusSheetsError, err
+	}
+
+	client := sheetsClient{
+		endpoint:      config.EndpointURL,
+		spreadsheetID: config.SpreadsheetID,
+		token:         token,
+		httpClient:    tlsClient,
+	}
+	if client.endpoint == "" {
+		client.endpoint = defaultSheetsEndpoint
+	}
+
+	for _, report := range sheetReports(summary) {
+		err := client.UpdateSheet(ctx, report)
+		if err != nil {
+			return ExitStatusSheetsError, fmt.Errorf("%s %s: %w", sheetMsg, report.Sheet, err)
+		}
+	}
+
+	return ExitStatusOK, nil
+}
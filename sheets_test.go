@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sheets_test.html
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// fakeSheets is fake Google Sheets API with token endpoint, it records
+// values written into sheets
+type fakeSheets struct {
+	t         *testing.T
+	key       *rsa.PublicKey
+	mutex     sync.Mutex
+	cleared   []string
+	values    map[string][][]string
+	badSheets map[string]bool
+}
+
+// ServeHTTP method handles requests to token endpoint and to sheets
+func (fake *fakeSheets) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if request.URL.Path == "/token" {
+		assert.NoError(fake.t, request.ParseForm())
+		assert.Equal(fake.t, "urn:ietf:params:oauth:grant-type:jwt-bearer",
+			request.Form.Get("grant_type"))
+
+		// assertion needs to be signed by key of service account
+		parts := strings.Split(request.Form.Get("assertion"), ".")
+		assert.Len(fake.t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.NoError(fake.t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.NoError(fake.t, rsa.VerifyPKCS1v15(fake.key, crypto.SHA256, digest[:], signature))
+
+		_, _ = writer.Write([]byte(`{"access_token": "token", "token_type": "Bearer"}`))
+		return
+	}
+
+	assert.Equal(fake.t, "Bearer token", request.Header.Get("Authorization"))
+
+	// path is /v4/spreadsheets/{id}/values/{sheet}[:clear]
+	prefix := "/v4/spreadsheets/spreadsheet/values/"
+	assert.True(fake.t, strings.HasPrefix(request.URL.Path, prefix), request.URL.Path)
+	sheet := strings.TrimPrefix(request.URL.Path, prefix)
+
+	if fake.badSheets[strings.TrimSuffix(sheet, ":clear")] {
+		writer.WriteHeader(http.StatusBadRequest)
+		_, _ = writer.Write([]byte(`{"error": "Unable to parse range"}`))
+		return
+	}
+
+	if strings.HasSuffix(sheet, ":clear") {
+		assert.Equal(fake.t, http.MethodPost, request.Method)
+		fake.cleared = append(fake.cleared, strings.TrimSuffix(sheet, ":clear"))
+		_, _ = writer.Write([]byte(`{}`))
+		return
+	}
+
+	assert.Equal(fake.t, http.MethodPut, request.Method)
+	assert.Equal(fake.t, "RAW", request.URL.Query().Get("valueInputOption"))
+
+	var body struct {
+		Values [][]string `json:"values"`
+	}
+	content, err := io.ReadAll(request.Body)
+	assert.NoError(fake.t, err)
+	assert.NoError(fake.t, json.Unmarshal(content, &body))
+	fake.values[sheet] = body.Values
+	_, _ = writer.Write([]byte(`{}`))
+}
+
+// startFakeSheets function starts fake Google Sheets API and returns
+// configuration that uses it
+func startFakeSheets(t *testing.T) (*fakeSheets, main.ConfigStruct) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	fake := &fakeSheets{
+		t:         t,
+		key:       &key.PublicKey,
+		values:    make(map[string][][]string),
+		badSheets: make(map[string]bool),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	encoded, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	account, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "exporter@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encoded})),
+		"token_uri":    server.URL + "/token",
+	})
+	assert.NoError(t, err)
+
+	accountFile := filepath.Join(t.TempDir(), "account.json")
+	assert.NoError(t, os.WriteFile(accountFile, account, 0o600))
+
+	return fake, main.ConfigStruct{
+		GoogleSheets: main.GoogleSheetsConfiguration{
+			SpreadsheetID:      "spreadsheet",
+			ServiceAccountFile: accountFile,
+			EndpointURL:        server.URL,
+		},
+	}
+}
+
+// TestStoreReportsIntoSheets checks that metadata, summary and disabled
+// rules are written into their sheets
+func TestStoreReportsIntoSheets(t *testing.T) {
+	fake, configuration := startFakeSheets(t)
+
+	summary := main.NewSummary()
+	summary.RecordTableRecords("rule_hit", 12)
+	summary.RecordTableRecords("report", 3)
+	summary.RecordDisabledRules([]main.DisabledRuleInfo{{Rule: "rule1", Count: 2}})
+	summary.AddExportedTable()
+	summary.AddExportedRows(15)
+	summary.Finish()
+
+	code, err := main.StoreReportsIntoSheets(context.Background(), &configuration, summary)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, []string{"metadata", "summary", "disabled_rules"}, fake.cleared)
+	assert.Equal(t, [][]string{
+		{"Table name", "Records"},
+		{"report", "3"},
+		{"rule_hit", "12"},
+	}, fake.values["metadata"])
+	assert.Equal(t, [][]string{
+		{"Rule", "Count"},
+		{"rule1", "2"},
+	}, fake.values["disabled_rules"])
+
+	assert.Equal(t, []string{"Stage", "Duration", "Share"}, fake.values["summary"][0])
+	assert.Contains(t, fake.values["summary"], []string{"Exported tables", "1", ""})
+	assert.Contains(t, fake.values["summary"], []string{"Exported rows", "15", ""})
+}
+
+// TestStoreReportsIntoSheetsSummaryOnly checks that sheets with reports not
+// exported by the run are not changed
+func TestStoreReportsIntoSheetsSummaryOnly(t *testing.T) {
+	fake, configuration := startFakeSheets(t)
+
+	code, err := main.StoreReportsIntoSheets(context.Background(), &configuration, main.NewSummary())
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, []string{"summary"}, fake.cleared)
+}
+
+// TestStoreReportsIntoSheetsFailure checks that error returned by Google
+// Sheets API is reported
+func TestStoreReportsIntoSheetsFailure(t *testing.T) {
+	fake, configuration := startFakeSheets(t)
+	fake.badSheets["summary"] = true
+
+	code, err := main.StoreReportsIntoSheets(context.Background(), &configuration, main.NewSummary())
+	assert.EqualError(t, err, `sheet summary: Google Sheets API returned status 400: {"error": "Unable to parse range"}`)
+	assert.Equal(t, main.ExitStatusSheetsError, code)
+
+	// missing service account file
+	configuration.GoogleSheets.ServiceAccountFile = filepath.Join(t.TempDir(), "missing.json")
+	code, err = main.StoreReportsIntoSheets(context.Background(), &configuration, main.NewSummary())
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusConfigurationError, code)
+}
+
+// TestPerformDataExportRecordsReports checks that metadata and disabled
+// rules exported by the run are recorded in summary
+func TestPerformDataExportRecordsReports(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report"),
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: t.TempDir(),
+		ExportMetadata:  true,
+		SkipArtifacts:   "sequences,constraints",
+	}
+
+	summary := main.NewSummary()
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, summary, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, []main.TableRecords{{Table: "report", Records: 0}}, summary.TableRecords())
+	assert.Nil(t, summary.DisabledRules())
+}
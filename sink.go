@@ -0,0 +1,754 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains sinks - outputs exported objects (tables,
+// metadata, list of disabled rules, archives and operation log) are written
+// into. Sinks are registered by name, so new output can be added just by
+// implementing Sink interface and registering its constructor; the export
+// itself is the same for all sinks.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sink.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// messages
+const (
+	unknownSink         = "Unknown sink %s"
+	sinkRegisteredTwice = "Sink %s is already registered"
+	storeObjectFailed   = "Store object into output failed"
+	createSinksFailed   = "Unable to prepare outputs"
+	objectMsg           = "object"
+)
+
+// error returned to table reader when sink stopped reading the table
+var errSinkFailed = errors.New("sink failed")
+
+// ObjectMeta contains information about object written into sink
+type ObjectMeta struct {
+	ContentType string
+
+	// Table is name of exported table stored in the object, it is empty
+	// for other objects (metadata, archives, logs etc.)
+	Table TableName
+}
+
+// Sink is an output exported objects are written into
+type Sink interface {
+	// Name method returns name the sink is registered with
+	Name() string
+
+	// WriteObject method writes all data from given reader into object (or
+	// file) with selected name
+	WriteObject(name string, r io.Reader, meta ObjectMeta) error
+
+	// FailureStatus method returns exit status used when object can't be
+	// written
+	FailureStatus() int
+}
+
+// SinkOptions contains settings shared by all sinks in one export
+type SinkOptions struct {
+	// Directory is directory exported files are written into
+	Directory string
+
+	// Directories contains directories files with exported tables are
+	// distributed into (round-robin), Directory is used when it is empty
+	Directories []string
+
+	// Compression is codec all exported objects are compressed by
+	Compression string
+
+	// Encryption contains public keys all exported objects are encrypted
+	// for, objects are not encrypted when it is nil
+	Encryption *Encryption
+
+	// Artifacts is log all written objects are recorded into, objects are
+	// not recorded when it is nil
+	Artifacts *ArtifactLog
+
+	// Checksums enables sidecar with SHA-256 checksum of each written object
+	Checksums bool
+
+	// Metrics is backend requests and uploaded bytes are reported into, they
+	// are not reported when it is nil
+	Metrics Metrics
+}
+
+// SinkFactory constructs sink from configuration
+type SinkFactory func(configuration *ConfigStruct, options SinkOptions) (Sink, error)
+
+// all registered sinks indexed by their names
+var sinkFactories = map[string]SinkFactory{
+	fileOutput: newFileSink,
+	s3Output:   newS3Sink,
+}
+
+// RegisterSink function registers new sink under given name. It is expected
+// to be called during initialization only.
+func RegisterSink(name string, factory SinkFactory) {
+	if _, found := sinkFactories[name]; found {
+		panic(fmt.Sprintf(sinkRegisteredTwice, name))
+	}
+	sinkFactories[name] = factory
+}
+
+// isSinkRegistered function checks if sink with given name is registered
+func isSinkRegistered(name string) bool {
+	_, found := sinkFactories[name]
+	return found
+}
+
+// NewSink function constructs sink registered under given name
+func NewSink(configuration *ConfigStruct, name string,
+	options SinkOptions) (Sink, error) {
+	factory, found := sinkFactories[name]
+	if !found {
+		return nil, fmt.Errorf(unknownSink, name)
+	}
+	return factory(configuration, options)
+}
+
+// newSinks function constructs sinks for all selected outputs
+func newSinks(configuration *ConfigStruct, outputs []string,
+	options SinkOptions) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(outputs))
+
+	for _, output := range outputs {
+		sink, err := NewSink(configuration, output, options)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// fileSink writes exported objects as files into selected directory. Files
+// with exported tables can be distributed into more directories.
+type fileSink struct {
+	mutex       sync.Mutex
+	directory   string
+	stripes     []string
+	next        int
+	compression string
+	encryption  *Encryption
+	artifacts   *ArtifactLog
+	checksums   bool
+	profile     *OutputProfile
+}
+
+// newFileSink function constructs sink writing into local files
+func newFileSink(configuration *ConfigStruct, options SinkOptions) (Sink, error) {
+	return &fileSink{
+		directory:   options.Directory,
+		stripes:     options.Directories,
+		compression: options.Compression,
+		encryption:  options.Encryption,
+		artifacts:   options.Artifacts,
+		checksums:   options.Checksums,
+		profile:     outputProfile(configuration, fileOutput),
+	}, nil
+}
+
+// Name method returns name the sink is registered with
+func (s *fileSink) Name() string {
+	return fileOutput
+}
+
+// objectDirectory method selects directory the object is written into.
+// Tables are distributed into all directories in round-robin fashion.
+func (s *fileSink) objectDirectory(meta ObjectMeta) string {
+	if meta.Table == "" || len(s.stripes) == 0 {
+		return s.directory
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	directory := s.stripes[s.next%len(s.stripes)]
+	s.next++
+	return directory
+}
+
+// WriteObject method writes all data from given reader into file in the
+// directory
+func (s *fileSink) WriteObject(name string, r io.Reader, meta ObjectMeta) error {
+	fileName := filepath.Join(s.objectDirectory(meta), name)
+	fout, err := createCompressedFile(fileName, s.compression, s.encryption)
+	if err != nil {
+		return err
+	}
+
+	// CSV files are formatted by profile selected for files
+	if s.profile != nil && meta.ContentType == csvContentType {
+		err = s.profile.Convert(r, fout)
+	} else {
+		_, err = io.Copy(fout, r)
+	}
+	if err != nil {
+		// error during write is more important than error during close
+		_ = fout.Close()
+		return err
+	}
+
+	// close the file and check if close operation was ok
+	err = fout.Close()
+	if err != nil {
+		return err
+	}
+
+	// checksum of the file is computed only when the file is recorded as
+	// artifact or when checksum sidecar is written
+	if s.artifacts == nil && !s.checksums {
+		return nil
+	}
+
+	fileName += artifactExtension(s.compression, s.encryption)
+	digest, err := fileDigest(fileName)
+	if err != nil {
+		return err
+	}
+
+	digest.Record(s.artifacts, fileName)
+
+	if s.checksums {
+		return writeChecksumFile(fileName, digest.hash.Sum(nil))
+	}
+	return nil
+}
+
+// FailureStatus method returns exit status used when file can't be written
+func (s *fileSink) FailureStatus() int {
+	return ExitStatusIOError
+}
+
+// s3Sink uploads exported objects into S3 bucket under selected prefix
+type s3Sink struct {
+	session     *S3Session
+	compression string
+	encryption  *Encryption
+}
+
+// newS3Sink function constructs sink uploading into configured S3 bucket
+func newS3Sink(configuration *ConfigStruct, options SinkOptions) (Sink, error) {
+	session, err := OpenS3Session(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	// uploaded objects are recorded and checked the same way as files,
+	// CSV objects are formatted by profile selected for S3
+	session.artifacts = options.Artifacts
+	session.checksums = options.Checksums
+	session.profile = outputProfile(configuration, s3Output)
+	session.metrics = options.Metrics
+
+	return s3Sink{
+		session:     session,
+		compression: options.Compression,
+		encryption:  options.Encryption,
+	}, nil
+}
+
+// Name method returns name the sink is registered with
+func (s s3Sink) Name() string {
+	return s3Output
+}
+
+// WriteObject method uploads all data from given reader into S3 object.
+// Data already in memory are uploaded at once, other data are streamed.
+func (s s3Sink) WriteObject(name string, r io.Reader, meta ObjectMeta) error {
+	objectName := s.session.ObjectName(name)
+
+	if buffer, ok := r.(*bytes.Reader); ok {
+		data := make([]byte, buffer.Len())
+		_, err := io.ReadFull(buffer, data)
+		if err != nil {
+			return err
+		}
+		return putObject(s.session.Context(), s.session, s.session.Bucket(), objectName,
+			meta.ContentType, data, s.compression, s.encryption)
+	}
+
+	return putObjectStream(s.session.Context(), s.session, s.session.Bucket(), objectName,
+		meta.ContentType, s.compression, s.encryption,
+		func(output io.Writer) error {
+			_, err := io.Copy(output, r)
+			return err
+		})
+}
+
+// FailureStatus method returns exit status used when object can't be
+// uploaded
+func (s s3Sink) FailureStatus() int {
+	return ExitStatusS3Error
+}
+
+// storeObjectIntoSinks function stores the same data into all sinks. Exit
+// status of the sink that failed is returned together with error.
+func storeObjectIntoSinks(sinks []Sink, objectName string,
+	meta ObjectMeta, data []byte) (int, error) {
+	for _, sink := range sinks {
+		err := sink.WriteObject(objectName, bytes.NewReader(data), meta)
+		if err != nil {
+			return sink.FailureStatus(), fmt.Errorf("%s: %v", sink.Name(), err)
+		}
+	}
+	return ExitStatusOK, nil
+}
+
+// storeTableIntoSinks method stores specified table into all sinks. Table is
+// streamed into single sink directly, for more sinks it is read and
+// serialized only once into buffer.
+func (storage DBStorage) storeTableIntoSinks(ctx context.Context, sinks []Sink,
+	tableName TableName, limit int, format string) (int, error) {
+	objectName := storage.tableObjectName(tableName, format)
+	meta := ObjectMeta{
+		ContentType: contentType(format),
+		Table:       tableName,
+	}
+
+	if len(sinks) != 1 {
+		buffer, err := storage.storeTableIntoBuffer(ctx, tableName, limit, format)
+		if err != nil {
+			return ExitStatusStorageError, err
+		}
+		defer putBuffer(buffer)
+
+		defer storage.summary.MeasureStage(stageUpload)()
+		return storeObjectIntoSinks(sinks, objectName, meta, buffer.Bytes())
+	}
+
+	reader, writer := io.Pipe()
+	readErr := make(chan error, 1)
+
+	go func() {
+		err := storage.storeTableIntoWriter(ctx, writer, tableName, limit, format)
+		_ = writer.CloseWithError(err)
+		readErr <- err
+	}()
+
+	sink := sinks[0]
+	err := sink.WriteObject(objectName, reader, meta)
+
+	// stop reading the table when sink failed before whole table was written
+	if err != nil {
+		_ = reader.CloseWithError(errSinkFailed)
+	} else {
+		_ = reader.Close()
+	}
+
+	// database error is the cause of failed write too
+	if err := <-readErr; err != nil && !errors.Is(err, errSinkFailed) {
+		return ExitStatusStorageError, err
+	}
+	if err != nil {
+		return sink.FailureStatus(), fmt.Errorf("%s: %v", sink.Name(), err)
+	}
+	return ExitStatusOK, nil
+}
+
+// performDataExportToSinks function exports all tables and metadata info
+// files into all given sinks
+func performDataExportToSinks(ctx context.Context, storage *DBStorage, sinks []Sink,
+	exportMetadata bool, exportDisabledRules bool,
+	operationLogger *zerolog.Logger, limit int,
+	ignoredTables IgnoredTables, format string,
+	skipped SkippedArtifacts, summary *Summary) (int, error) {
+	// helper function to store object into all sinks and log possible
+	// error
+	store := func(objectName, contentType string, data []byte) (int, error) {
+		exitStatus, err := storeObjectIntoSinks(sinks, objectName,
+			ObjectMeta{ContentType: contentType}, data)
+		if err != nil {
+			storage.logger.Err(err).Str(objectMsg, objectName).Msg(storeObjectFailed)
+			operationLogger.Err(err).Str(objectMsg, objectName).Msg(storeObjectFailed)
+		}
+		return exitStatus, err
+	}
+
+	operationLogger.Info().Msg(readingListOfTables)
+
+	stopMeasuring := summary.MeasureStage(stageDiscovery)
+	tableNames, err := storage.ReadListOfTables(ctx)
+	stopMeasuring()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// only selected tables are exported
+	tableNames, err = storage.selectTables(tableNames)
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	storage.logger.Info().Int("count", len(tableNames)).Msg(listOfTablesMsg)
+
+	// log into terminal
+	printTables(&storage.logger, tableNames)
+
+	if exportMetadata {
+		operationLogger.Info().Msg(exportingMetadata)
+		stopMeasuring := summary.MeasureStage(stageMetadata)
+
+		// export list of all tables
+		if skipped.Contains(tablesListArtifact) {
+			logSkippedArtifact(operationLogger, tablesListArtifact)
+		} else {
+			buffer := getBuffer()
+			defer putBuffer(buffer)
+			err = TableNamesToCSV(buffer, tableNames)
+			if err != nil {
+				stopMeasuring()
+				return ExitStatusIOError, err
+			}
+			exitStatus, err := store(listOfTables, "text/csv", buffer.Bytes())
+			if err != nil {
+				stopMeasuring()
+				return exitStatus, err
+			}
+		}
+
+		// export tables metadata, records are counted only once
+		if skipped.Contains(metadataArtifact) {
+			logSkippedArtifact(operationLogger, metadataArtifact)
+		} else {
+			buffer := getBuffer()
+			defer putBuffer(buffer)
+			err = writeTableMetadata(ctx, buffer, tableNames, *storage, format)
+			if err != nil {
+				stopMeasuring()
+				const msg = "Read tables metadata failed"
+				storage.logger.Err(err).Msg(msg)
+				operationLogger.Err(err).Msg(msg)
+				return ExitStatusStorageError, err
+			}
+			exitStatus, err := store(reportName(metadataTable, format),
+				reportContentType(format), buffer.Bytes())
+			if err != nil {
+				stopMeasuring()
+				return exitStatus, err
+			}
+		}
+
+		// export sequences and their current values
+		if skipped.Contains(sequencesArtifact) {
+			logSkippedArtifact(operationLogger, sequencesArtifact)
+		} else {
+			operationLogger.Info().Msg(exportingSequences)
+			data, err := storage.sequencesIntoCSV(ctx)
+			if err != nil {
+				stopMeasuring()
+				operationLogger.Err(err).Msg(readSequencesFailed)
+				return ExitStatusStorageError, err
+			}
+			exitStatus, err := store(sequencesFile, csvContentType, data)
+			if err != nil {
+				stopMeasuring()
+				return exitStatus, err
+			}
+		}
+
+		// export constraints and indexes of all tables
+		if skipped.Contains(constraintsArtifact) {
+			logSkippedArtifact(operationLogger, constraintsArtifact)
+		} else {
+			operationLogger.Info().Msg(exportingConstraints)
+			data, err := storage.constraintsIntoCSV(ctx, tableNames)
+			if err != nil {
+				stopMeasuring()
+				operationLogger.Err(err).Msg(readConstraintsFailed)
+				return ExitStatusStorageError, err
+			}
+			exitStatus, err := store(constraintsFile, csvContentType, data)
+			if err != nil {
+				stopMeasuring()
+				return exitStatus, err
+			}
+		}
+		stopMeasuring()
+	}
+
+	if exportDisabledRules && skipped.Contains(disabledRulesArtifact) {
+		logSkippedArtifact(operationLogger, disabledRulesArtifact)
+	} else if exportDisabledRules {
+		operationLogger.Info().Msg(exportingDisabledRules)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		disabledRulesInfo, err := storage.ReadDisabledRules(ctx)
+		if err != nil {
+			stopMeasuring()
+			storage.logger.Err(err).Msg(readDisabledRulesInfoFailed)
+			operationLogger.Err(err).Msg(readDisabledRulesInfoFailed)
+			return ExitStatusStorageError, err
+		}
+		summary.RecordDisabledRules(disabledRulesInfo)
+
+		buffer := getBuffer()
+		defer putBuffer(buffer)
+		err = writeDisabledRules(buffer, disabledRulesInfo, format)
+		if err != nil {
+			stopMeasuring()
+			return ExitStatusIOError, err
+		}
+		exitStatus, err := store(reportName(disabledRules, format),
+			reportContentType(format), buffer.Bytes())
+		if err == nil && storage.disabledRulesDetails {
+			// rules disabled by more users per organization
+			operationLogger.Info().Msg(exportingDisabledRulesDetails)
+			var data []byte
+			data, exitStatus, err = storage.disabledRulesDetailsReport(ctx, format)
+			if err != nil {
+				operationLogger.Err(err).Msg(storeDisabledRulesDetailsFailed)
+			} else {
+				exitStatus, err = store(reportName(disabledRulesDetails, format),
+					reportContentType(format), data)
+			}
+		}
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	if storage.ruleHits && skipped.Contains(ruleHitsArtifact) {
+		logSkippedArtifact(operationLogger, ruleHitsArtifact)
+	} else if storage.ruleHits {
+		operationLogger.Info().Msg(exportingRuleHits)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.ruleHitsReport(ctx, format)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeRuleHitsFailed)
+		} else {
+			exitStatus, err = store(reportName(ruleHitsFile, format),
+				reportContentType(format), data)
+		}
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	if storage.orgSummary && skipped.Contains(orgSummaryArtifact) {
+		logSkippedArtifact(operationLogger, orgSummaryArtifact)
+	} else if storage.orgSummary {
+		operationLogger.Info().Msg(exportingOrgSummary)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.orgSummaryReport(ctx, format)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeOrgSummaryFailed)
+		} else {
+			exitStatus, err = store(reportName(orgSummaryFile, format),
+				reportContentType(format), data)
+		}
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	if storage.trendReport && skipped.Contains(trendArtifact) {
+		logSkippedArtifact(operationLogger, trendArtifact)
+	} else if storage.trendReport {
+		operationLogger.Info().Msg(exportingRecommendationsTrend)
+		stopMeasuring := summary.MeasureStage(stageReports)
+
+		data, exitStatus, err := storage.recommendationsTrendReport(ctx, format)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeRecommendationsTrendFailed)
+		} else {
+			exitStatus, err = store(reportName(recommendationsTrendFile, format),
+				reportContentType(format), data)
+		}
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	if len(storage.queries) > 0 && skipped.Contains(queriesArtifact) {
+		logSkippedArtifact(operationLogger, queriesArtifact)
+	} else if len(storage.queries) > 0 {
+		stopMeasuring := summary.MeasureStage(stageReports)
+		exitStatus, err := storage.exportCustomQueries(ctx, operationLogger,
+			func(objectName string, data []byte) (int, error) {
+				return store(objectName, csvContentType, data)
+			})
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	operationLogger.Info().Msg(exportingTables)
+
+	// some formats store all tables into one archive
+	archive, err := newTableArchive(format)
+	if err != nil {
+		storage.logger.Err(err).Msg(createArchiveFailed)
+		operationLogger.Err(err).Msg(createArchiveFailed)
+		return ExitStatusIOError, err
+	}
+	defer closeArchive(archive)
+
+	// table that failed might have been partially written into archive
+	if storage.keepGoing && archive != nil {
+		storage.logger.Warn().Msg(keepGoingWithArchive)
+		operationLogger.Warn().Msg(keepGoingWithArchive)
+		storage.keepGoing = false
+	}
+
+	// read content of all tables and perform export
+	for _, tableName := range tableNames {
+		// ignore table if specified by user
+		if _, found := ignoredTables[string(tableName)]; found {
+			operationLogger.Info().
+				Str(tableNameMsg, string(tableName)).
+				Msg(tableIsIgnored)
+			continue
+		}
+		operationLogger.Info().
+			Str(tableNameMsg, string(tableName)).
+			Msg(exportingTable)
+
+		// all messages logged during table export contain its name
+		tableStorage := storage.WithLogger(storage.logger.With().
+			Str(tableNameMsg, string(tableName)).Logger())
+		tableStorage.explainTable(ctx, operationLogger, tableName, limit)
+
+		if archive != nil {
+			err = tableStorage.StoreTableIntoArchive(ctx, archive, tableName, limit)
+			if err != nil {
+				const msg = "Store table into archive failed"
+				tableStorage.logger.Err(err).Msg(msg)
+				operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+					Msg(msg)
+				return ExitStatusStorageError, err
+			}
+		} else {
+			exitStatus, err := tableStorage.storeTableIntoSinks(ctx, sinks,
+				tableName, limit, format)
+			if err != nil {
+				const msg = "Store table into output failed"
+				tableStorage.logger.Err(err).Msg(msg)
+				operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+					Msg(msg)
+				if storage.continueAfterFailure(operationLogger, tableName,
+					exitStatus, err) {
+					continue
+				}
+				return exitStatus, err
+			}
+		}
+		if storage.csvSchemaSidecars && format == csvFormat && archive == nil {
+			exitStatus, err := tableStorage.storeSchemaSidecarIntoSinks(ctx,
+				sinks, tableName)
+			if err != nil {
+				storage.logger.Err(err).Msg(storeSchemaSidecarFailed)
+				operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+					Msg(storeSchemaSidecarFailed)
+				if storage.continueAfterFailure(operationLogger, tableName,
+					exitStatus, err) {
+					continue
+				}
+				return exitStatus, err
+			}
+		}
+		exitStatus, err := storeRejectsIntoSinks(sinks, storage.quarantine, tableName)
+		if err != nil {
+			storage.logger.Err(err).Msg(storeRejectsFailed)
+			operationLogger.Err(err).Str(tableNameMsg, string(tableName)).
+				Msg(storeRejectsFailed)
+			if storage.continueAfterFailure(operationLogger, tableName,
+				exitStatus, err) {
+				continue
+			}
+			return exitStatus, err
+		}
+		logTableAudit(&storage.logger, operationLogger, storage.audit, tableName)
+		logRejects(&storage.logger, operationLogger, storage.quarantine, tableName)
+		summary.AddExportedTable()
+	}
+
+	// columns are flagged when all tables have been exported
+	if storage.profile != nil {
+		data, err := columnFlagsIntoCSV(storage.profile.Flags())
+		if err != nil {
+			storage.logger.Err(err).Msg(storeColumnFlagsFailed)
+			operationLogger.Err(err).Msg(storeColumnFlagsFailed)
+			return ExitStatusIOError, err
+		}
+		exitStatus, err := store(columnFlagsFile, csvContentType, data)
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	if archive != nil {
+		stopMeasuring := summary.MeasureStage(stageUpload)
+		buffer := new(bytes.Buffer)
+		err = archive.Write(buffer)
+		if err != nil {
+			stopMeasuring()
+			const msg = "Write archive failed"
+			storage.logger.Err(err).Msg(msg)
+			operationLogger.Err(err).Msg(msg)
+			return ExitStatusIOError, err
+		}
+		exitStatus, err := store(archiveFile+fileExtension(format),
+			contentType(format), buffer.Bytes())
+		stopMeasuring()
+		if err != nil {
+			return exitStatus, err
+		}
+	}
+
+	operationLogger.Info().Msg(closingConnectionToStorage)
+
+	// we have finished, let's close the connection to database
+	err = storage.Close()
+	if err != nil {
+		storage.logger.Err(err).Msg(operationFailedMessage)
+		operationLogger.Err(err).Msg(operationFailedMessage)
+		return ExitStatusStorageError, err
+	}
+
+	// default exit value + no error
+	return ExitStatusOK, nil
+}
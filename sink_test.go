@@ -0,0 +1,260 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sink_test.html
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// memorySink stores written objects in memory
+type memorySink struct {
+	mutex   sync.Mutex
+	objects map[string]string
+	types   map[string]string
+}
+
+// memory sink shared by all exports in tests
+var memory = &memorySink{}
+
+// failingSink refuses to write any object
+type failingSink struct{}
+
+func init() {
+	main.RegisterSink("memory", func(_ *main.ConfigStruct, _ main.SinkOptions) (main.Sink, error) {
+		return memory, nil
+	})
+	main.RegisterSink("failing", func(_ *main.ConfigStruct, _ main.SinkOptions) (main.Sink, error) {
+		return failingSink{}, nil
+	})
+}
+
+// reset method removes all stored objects
+func (s *memorySink) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects = make(map[string]string)
+	s.types = make(map[string]string)
+}
+
+func (s *memorySink) Name() string {
+	return "memory"
+}
+
+func (s *memorySink) WriteObject(name string, r io.Reader, meta main.ObjectMeta) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[name] = string(data)
+	s.types[name] = meta.ContentType
+	return nil
+}
+
+func (s *memorySink) FailureStatus() int {
+	return main.ExitStatusIOError
+}
+
+func (s failingSink) Name() string {
+	return "failing"
+}
+
+func (s failingSink) WriteObject(_ string, _ io.Reader, _ main.ObjectMeta) error {
+	return errors.New("disk full")
+}
+
+func (s failingSink) FailureStatus() int {
+	return main.ExitStatusIOError
+}
+
+// TestNewSinkUnknown checks that unknown sink is refused
+func TestNewSinkUnknown(t *testing.T) {
+	_, err := main.NewSink(&main.ConfigStruct{}, "nfs", main.SinkOptions{})
+	assert.EqualError(t, err, "Unknown sink nfs")
+}
+
+// TestRegisterSinkTwice checks that sink name can be registered only once
+func TestRegisterSinkTwice(t *testing.T) {
+	assert.Panics(t, func() {
+		main.RegisterSink("file", nil)
+	})
+}
+
+// TestFileSinkWriteObject checks that file sink writes objects into files in
+// selected directory
+func TestFileSinkWriteObject(t *testing.T) {
+	directory := t.TempDir()
+
+	sink, err := main.NewSink(&main.ConfigStruct{}, "file",
+		main.SinkOptions{Directory: directory})
+	assert.NoError(t, err)
+	assert.Equal(t, "file", sink.Name())
+
+	err = sink.WriteObject("object.txt", strings.NewReader("hello"),
+		main.ObjectMeta{ContentType: "text/plain"})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(directory, "object.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+}
+
+// TestFileSinkStriping checks that files with tables are distributed into
+// all directories and other files are written into the main directory
+func TestFileSinkStriping(t *testing.T) {
+	directory := t.TempDir()
+	stripes := []string{t.TempDir(), t.TempDir()}
+
+	sink, err := main.NewSink(&main.ConfigStruct{}, "file", main.SinkOptions{
+		Directory:   directory,
+		Directories: stripes,
+	})
+	assert.NoError(t, err)
+
+	objects := []struct {
+		name      string
+		table     main.TableName
+		directory string
+	}{
+		{"_tables.csv", "", directory},
+		{"first.csv", "first", stripes[0]},
+		{"second.csv", "second", stripes[1]},
+		{"third.csv", "third", stripes[0]},
+	}
+
+	for _, object := range objects {
+		err = sink.WriteObject(object.name, strings.NewReader(object.name),
+			main.ObjectMeta{Table: object.table})
+		assert.NoError(t, err)
+	}
+
+	for _, object := range objects {
+		content, err := os.ReadFile(filepath.Join(object.directory, object.name))
+		assert.NoError(t, err)
+		assert.Equal(t, object.name, string(content))
+	}
+}
+
+// TestExportedIntoSinks checks which outputs are exported by generic sinks
+// export
+func TestExportedIntoSinks(t *testing.T) {
+	assert.False(t, main.ExportedIntoSinks("file"))
+	assert.False(t, main.ExportedIntoSinks("S3"))
+	assert.False(t, main.ExportedIntoSinks("kafka"))
+	assert.True(t, main.ExportedIntoSinks("memory"))
+	assert.True(t, main.ExportedIntoSinks("file,S3"))
+}
+
+// TestPerformDataExportToFileSink checks that tables and metadata are
+// exported into files
+func TestPerformDataExportToFileSink(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "id,report\n1,first\n2,second\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(directory, "_tables.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Table name\nreport\n", string(content))
+}
+
+// TestPerformDataExportToRegisteredSink checks that export can be performed
+// into sink registered by name
+func TestPerformDataExportToRegisteredSink(t *testing.T) {
+	memory.reset()
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "memory",
+		Format: "ndjson",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, "{\"id\":1,\"report\":\"first\"}\n{\"id\":2,\"report\":\"second\"}\n",
+		memory.objects["report.ndjson"])
+	assert.Equal(t, "application/x-ndjson", memory.types["report.ndjson"])
+}
+
+// TestPerformDataExportToFailingSink checks that exit status of sink that
+// failed is returned
+func TestPerformDataExportToFailingSink(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+	}
+
+	cliFlags := main.CliFlags{
+		Output: "failing",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags, &log.Logger,
+		&log.Logger, main.NewSummary(), nil, nil)
+	assert.EqualError(t, err, "failing: disk full")
+	assert.Equal(t, main.ExitStatusIOError, code)
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of export split into public and
+// restricted artifact sets. Tables are classified in configuration, tables
+// of each set are exported under their own prefix (or into their own
+// bucket) and columns of public tables are masked by SQL expressions.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/split.html
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// Names of artifact sets
+const (
+	publicArtifactSet     = "public"
+	restrictedArtifactSet = "restricted"
+)
+
+// messages
+const (
+	exportingArtifactSet     = "Exporting artifact set"
+	artifactSetMsg           = "artifact set"
+	splitUnsupportedOutput   = "export can be split into artifact sets only when exported into S3 or files, found %s"
+	artifactSetsCollide      = "public and restricted artifact sets are stored into the same location %s"
+	createSetDirectoryFailed = "Unable to create directory for artifact set"
+)
+
+// artifactSet represents one set of artifacts produced by split export
+type artifactSet struct {
+	name   string
+	bucket string
+	prefix string
+}
+
+// splitConfigured function checks if export needs to be split into public
+// and restricted artifact sets
+func splitConfigured(split SplitConfiguration) bool {
+	return len(split.PublicTables) > 0
+}
+
+// artifactSets function returns sets the export is split into. Restricted
+// set is exported first.
+func artifactSets(configuration *ConfigStruct) []artifactSet {
+	split := GetSplitConfiguration(configuration)
+	bucket := GetS3Configuration(configuration).Bucket
+
+	bucketOrDefault := func(setBucket string) string {
+		if setBucket != "" {
+			return setBucket
+		}
+		return bucket
+	}
+
+	return []artifactSet{
+		{
+			name:   restrictedArtifactSet,
+			bucket: bucketOrDefault(split.RestrictedBucket),
+			prefix: split.RestrictedPrefix,
+		},
+		{
+			name:   publicArtifactSet,
+			bucket: bucketOrDefault(split.PublicBucket),
+			prefix: split.PublicPrefix,
+		},
+	}
+}
+
+// checkArtifactSets function checks if artifact sets are stored into
+// different locations. Buckets are not taken into account for exports into
+// files.
+func checkArtifactSets(sets []artifactSet, output string) error {
+	location := func(set artifactSet) string {
+		if output == s3Output {
+			return set.bucket + "/" + set.prefix
+		}
+		return set.prefix
+	}
+
+	if location(sets[0]) == location(sets[1]) {
+		return fmt.Errorf(artifactSetsCollide, location(sets[0]))
+	}
+	return nil
+}
+
+// mergeCasts function returns casts with masks applied on top of them, mask
+// replaces cast of the same column
+func mergeCasts(casts, masks CastsConfiguration) CastsConfiguration {
+	merged := make(CastsConfiguration, len(casts)+len(masks))
+
+	for _, source := range []CastsConfiguration{casts, masks} {
+		for table, columns := range source {
+			if merged[table] == nil {
+				merged[table] = make(map[string]string, len(columns))
+			}
+			for column, expression := range columns {
+				merged[table][column] = expression
+			}
+		}
+	}
+
+	return merged
+}
+
+// configuration method returns configuration used to export the artifact
+// set. Objects are stored under prefix of the set appended to configured
+// prefix, public tables are masked and custom queries (that can read any
+// table) are exported with restricted set only.
+func (set artifactSet) configuration(configuration *ConfigStruct) *ConfigStruct {
+	setConfiguration := *configuration
+
+	setConfiguration.S3.Bucket = set.bucket
+	setConfiguration.S3.Prefix = path.Join(configuration.S3.Prefix, set.prefix)
+
+	directories := make([]string, 0, len(configuration.Export.Directories))
+	for _, directory := range configuration.Export.Directories {
+		directories = append(directories, filepath.Join(directory, set.prefix))
+	}
+	setConfiguration.Export.Directories = directories
+
+	if set.name == publicArtifactSet {
+		setConfiguration.Casts = mergeCasts(configuration.Casts,
+			GetSplitConfiguration(configuration).PublicMasks)
+		setConfiguration.Queries = nil
+	}
+
+	return &setConfiguration
+}
+
+// flags method returns command line flags used to export the artifact set.
+// Files are exported into subdirectory named by prefix of the set, list of
+// disabled rules is exported with restricted set only.
+func (set artifactSet) flags(cliFlags CliFlags) CliFlags {
+	cliFlags.ArtifactSet = set.name
+
+	if cliFlags.Output == fileOutput {
+		cliFlags.OutputDirectory = filepath.Join(cliFlags.OutputDirectory, set.prefix)
+	}
+
+	if set.name == publicArtifactSet {
+		cliFlags.ExportDisabledRules = false
+		cliFlags.DisabledRulesTrend = 0
+	}
+
+	return cliFlags
+}
+
+// createDirectories method creates directories files of the artifact set
+// are exported into
+func (set artifactSet) createDirectories(configuration *ConfigStruct, cliFlags CliFlags) error {
+	directories := append([]string{cliFlags.OutputDirectory},
+		configuration.Export.Directories...)
+
+	for _, directory := range directories {
+		if directory == "" {
+			continue
+		}
+		err := os.MkdirAll(directory, 0o750)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// artifactSetTableFilter function restricts given table filter to tables of
+// selected artifact set. Filter is not changed when the export is not split.
+func artifactSetTableFilter(filter *TableFilter, set string, split SplitConfiguration) (*TableFilter, error) {
+	switch set {
+	case publicArtifactSet:
+		return filter.Within(split.PublicTables)
+	case restrictedArtifactSet:
+		return filter.Without(split.PublicTables)
+	default:
+		return filter, nil
+	}
+}
+
+// performSplitDataExport function exports restricted and public artifact
+// sets one after another into their own locations
+func performSplitDataExport(ctx context.Context, configuration *ConfigStruct, cliFlags CliFlags,
+	logger, operationLogger *zerolog.Logger, summary *Summary, progress *Progress,
+	artifacts *ArtifactLog, metrics Metrics) (int, error) {
+	output := exportOutput(cliFlags)
+	if exportedIntoDirectory(cliFlags) || (output != s3Output && output != fileOutput) {
+		err := fmt.Errorf(splitUnsupportedOutput, cliFlags.Output)
+		operationLogger.Err(err).Msg("Wrong output type selected")
+		return ExitStatusConfigurationError, err
+	}
+
+	sets := artifactSets(configuration)
+	err := checkArtifactSets(sets, output)
+	if err != nil {
+		operationLogger.Err(err).Msg("Wrong artifact sets configured")
+		return ExitStatusConfigurationError, err
+	}
+
+	for _, set := range sets {
+		setConfiguration := set.configuration(configuration)
+		setFlags := set.flags(cliFlags)
+
+		logger.Info().Str(artifactSetMsg, set.name).Msg(exportingArtifactSet)
+		operationLogger.Info().Str(artifactSetMsg, set.name).Msg(exportingArtifactSet)
+
+		if output == fileOutput {
+			err := set.createDirectories(setConfiguration, setFlags)
+			if err != nil {
+				operationLogger.Err(err).Msg(createSetDirectoryFailed)
+				return ExitStatusIOError, err
+			}
+		}
+
+		status, err := performDataExportOfSet(ctx, setConfiguration, setFlags,
+			logger, operationLogger, summary, progress, artifacts, metrics)
+		if status != ExitStatusOK || err != nil {
+			return status, err
+		}
+	}
+
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/split_test.html
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// splitConfiguration returns configuration of export split into public and
+// restricted artifact sets, report table is public and its report column is
+// masked
+func splitConfiguration(t *testing.T) main.ConfigStruct {
+	return main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareSQLiteDatabase(t),
+		},
+		Split: main.SplitConfiguration{
+			PublicTables:     []string{"report"},
+			PublicPrefix:     "public",
+			RestrictedPrefix: "restricted",
+			PublicMasks: main.CastsConfiguration{
+				"report": {"report": "'***'"},
+			},
+		},
+	}
+}
+
+// TestPerformSplitDataExportToFiles checks that public and restricted
+// tables are exported into their own directories and public tables are
+// masked
+func TestPerformSplitDataExportToFiles(t *testing.T) {
+	configuration := splitConfiguration(t)
+
+	// restricted table is exported too
+	connection, err := sql.Open("sqlite3", configuration.Storage.SQLiteDataSource)
+	assert.NoError(t, err)
+	_, err = connection.Exec(`CREATE TABLE rule_hit (id INTEGER)`)
+	assert.NoError(t, err)
+	assert.NoError(t, connection.Close())
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "public", "report.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "id,report\n1,***\n2,***\n", string(content))
+
+	assert.FileExists(t, filepath.Join(directory, "restricted", "rule_hit.csv"))
+	assert.NoFileExists(t, filepath.Join(directory, "restricted", "report.csv"))
+	assert.NoFileExists(t, filepath.Join(directory, "public", "rule_hit.csv"))
+}
+
+// TestPerformSplitDataExportToS3 checks that artifact sets are stored under
+// their own prefixes and list of disabled rules is not part of public set
+func TestPerformSplitDataExportToS3(t *testing.T) {
+	s3, address := startFakeS3Server(t)
+
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	endpointPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	configuration := splitConfiguration(t)
+	configuration.S3 = main.S3Configuration{
+		EndpointURL:  host,
+		EndpointPort: uint(endpointPort),
+		Bucket:       "bucket",
+		Prefix:       "export",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "S3", ExportMetadata: true},
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.Equal(t, "id,report\n1,***\n2,***\n",
+		string(s3.objects["/bucket/export/public/report.csv"]))
+	assert.Contains(t, s3.objects, "/bucket/export/public/_metadata.csv")
+	assert.Contains(t, s3.objects, "/bucket/export/restricted/_metadata.csv")
+	assert.NotContains(t, s3.objects, "/bucket/export/restricted/report.csv")
+}
+
+// TestPerformSplitDataExportWrongOutput checks that split export is refused
+// for outputs other than S3 and files
+func TestPerformSplitDataExportWrongOutput(t *testing.T) {
+	configuration := splitConfiguration(t)
+
+	code, err := main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "kafka"}, &log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusConfigurationError, code)
+
+	configuration.Split.PublicPrefix = "restricted"
+	code, err = main.PerformDataExport(context.Background(), &configuration,
+		main.CliFlags{Output: "file", OutputDirectory: t.TempDir()},
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.EqualError(t, err,
+		"public and restricted artifact sets are stored into the same location restricted")
+	assert.Equal(t, main.ExitStatusConfigurationError, code)
+}
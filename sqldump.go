@@ -0,0 +1,195 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains table writer that renders table content as SQL
+// dump - CREATE TABLE statement followed by INSERT statement for each row.
+// Such dump can be replayed into another PostgreSQL instance by psql.
+// Identity and generated columns are handled as described in identity.go.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqldump.html
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// postgresTypes maps database type names returned by driver to type names
+// used in CREATE TABLE statement
+var postgresTypes = map[string]string{
+	"INT2":        "smallint",
+	"INT4":        "integer",
+	"INT8":        "bigint",
+	"FLOAT4":      "real",
+	"FLOAT8":      "double precision",
+	"NUMERIC":     "numeric",
+	"BOOL":        "boolean",
+	"VARCHAR":     "varchar",
+	"BPCHAR":      "char",
+	"TEXT":        "text",
+	"UUID":        "uuid",
+	"JSON":        "json",
+	"JSONB":       "jsonb",
+	"BYTEA":       "bytea",
+	"DATE":        "date",
+	"TIMESTAMP":   "timestamp",
+	"TIMESTAMPTZ": "timestamp with time zone",
+}
+
+// sqlType function returns SQL type for given database type name. Unknown
+// types are passed as is, text is used when the type is not known at all.
+func sqlType(databaseType string) string {
+	if databaseType == "" {
+		return "text"
+	}
+
+	if t, found := postgresTypes[databaseType]; found {
+		return t
+	}
+
+	return strings.ToLower(databaseType)
+}
+
+// quoteIdentifier function quotes table or column name so it can be used in
+// SQL statement
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// sqlLiteral function converts value read from database into SQL literal
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int, int32, int64, float32, float64:
+		return fmt.Sprintf("%v", v)
+	case []byte:
+		return fmt.Sprintf(`'\x%x'`, v)
+	default:
+		return "'" + strings.ReplaceAll(fmt.Sprintf("%v", v), "'", "''") + "'"
+	}
+}
+
+// sqlDumpTableWriter writes table content as SQL statements
+type sqlDumpTableWriter struct {
+	writer    io.Writer
+	tableName TableName
+	columns   []Column
+	insert    string
+}
+
+// newSQLDumpTableWriter function constructs SQL dump table writer for given
+// table
+func newSQLDumpTableWriter(writer io.Writer, tableName TableName, columns []Column) *sqlDumpTableWriter {
+	return &sqlDumpTableWriter{
+		writer:    writer,
+		tableName: tableName,
+		columns:   columns,
+	}
+}
+
+// WriteHeader method writes CREATE TABLE statement and prepares the first
+// part of INSERT statements
+func (w *sqlDumpTableWriter) WriteHeader(colNames []string) error {
+	var statement strings.Builder
+
+	statement.WriteString(createSchemaStatement(w.tableName))
+	statement.WriteString("CREATE TABLE ")
+	statement.WriteString(quoteTableName(w.tableName))
+	statement.WriteString(" (\n")
+
+	for i, column := range w.columns {
+		statement.WriteString("    ")
+		statement.WriteString(quoteIdentifier(column.Name))
+		statement.WriteString(" ")
+		statement.WriteString(sqlType(column.DatabaseType))
+		if column.Identity != "" {
+			statement.WriteString(" GENERATED " + column.Identity + " AS IDENTITY")
+		}
+		if i < len(w.columns)-1 {
+			statement.WriteString(",")
+		}
+		statement.WriteString("\n")
+	}
+
+	statement.WriteString(");\n\n")
+
+	// values of identity columns defined as ALWAYS need to be overridden
+	overriding := ""
+	quotedNames := make([]string, 0, len(colNames))
+	for _, colName := range w.insertedColumns(colNames) {
+		quotedNames = append(quotedNames, quoteIdentifier(colName))
+		if w.column(colName).Identity == identityAlways {
+			overriding = " OVERRIDING SYSTEM VALUE"
+		}
+	}
+	w.insert = fmt.Sprintf("INSERT INTO %s (%s)%s VALUES (",
+		quoteTableName(w.tableName), strings.Join(quotedNames, ", "),
+		overriding)
+
+	_, err := io.WriteString(w.writer, statement.String())
+	return err
+}
+
+// column method returns column with given name, empty column is returned for
+// unknown name
+func (w *sqlDumpTableWriter) column(colName string) Column {
+	for _, column := range w.columns {
+		if column.Name == colName {
+			return column
+		}
+	}
+	return Column{Name: colName}
+}
+
+// insertedColumns method returns names of columns that are inserted by
+// INSERT statements
+func (w *sqlDumpTableWriter) insertedColumns(colNames []string) []string {
+	inserted := make([]string, 0, len(colNames))
+	for _, colName := range colNames {
+		if !w.column(colName).Omitted {
+			inserted = append(inserted, colName)
+		}
+	}
+	return inserted
+}
+
+// WriteRow method writes one row as INSERT statement
+func (w *sqlDumpTableWriter) WriteRow(colNames []string, row M) error {
+	values := make([]string, 0, len(colNames))
+	for _, colName := range w.insertedColumns(colNames) {
+		values = append(values, sqlLiteral(row[colName]))
+	}
+
+	_, err := io.WriteString(w.writer, w.insert+strings.Join(values, ", ")+");\n")
+	return err
+}
+
+// Flush method does nothing, all statements have been written already
+func (w *sqlDumpTableWriter) Flush() error {
+	return nil
+}
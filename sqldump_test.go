@@ -0,0 +1,153 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqldump_test.html
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestSQLDumpTableWriter checks writing table as SQL statements
+func TestSQLDumpTableWriter(t *testing.T) {
+	output := writeTestTable(t, "sqldump", testRows)
+
+	expected := `CREATE TABLE "test_table" (
+    "id" integer,
+    "name" varchar,
+    "valid" boolean
+);
+
+INSERT INTO "test_table" ("id", "name", "valid") VALUES (1, 'foo', TRUE);
+INSERT INTO "test_table" ("id", "name", "valid") VALUES (2, 'bar "baz"', FALSE);
+`
+	assert.Equal(t, expected, output)
+}
+
+// TestSQLDumpTableWriterEmptyTable checks that only CREATE TABLE statement is
+// written for empty table
+func TestSQLDumpTableWriterEmptyTable(t *testing.T) {
+	output := writeTestTable(t, "sqldump", nil)
+
+	assert.Contains(t, output, `CREATE TABLE "test_table"`)
+	assert.NotContains(t, output, "INSERT")
+}
+
+// TestSQLDumpTableWriterIdentityColumns checks that values of identity
+// columns are overridden and generated columns are not inserted
+func TestSQLDumpTableWriterIdentityColumns(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	writer, err := main.NewTableWriter("sqldump", buffer, "test_table", []main.Column{
+		{Name: "id", DatabaseType: "INT4", Identity: "ALWAYS"},
+		{Name: "name", DatabaseType: "VARCHAR"},
+		{Name: "valid", DatabaseType: "BOOL", Omitted: true},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	assert.NoError(t, writer.WriteRow(testColumns, testRows[0]))
+	assert.NoError(t, writer.Flush())
+
+	expected := `CREATE TABLE "test_table" (
+    "id" integer GENERATED ALWAYS AS IDENTITY,
+    "name" varchar,
+    "valid" boolean
+);
+
+INSERT INTO "test_table" ("id", "name") OVERRIDING SYSTEM VALUE VALUES (1, 'foo');
+`
+	assert.Equal(t, expected, buffer.String())
+}
+
+// TestSQLDumpTableWriterSkippedIdentityColumns checks that skipped identity
+// columns are not inserted
+func TestSQLDumpTableWriterSkippedIdentityColumns(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	writer, err := main.NewTableWriter("sqldump", buffer, "test_table", []main.Column{
+		{Name: "id", DatabaseType: "INT4", Identity: "BY DEFAULT", Omitted: true},
+		{Name: "name", DatabaseType: "VARCHAR"},
+		{Name: "valid", DatabaseType: "BOOL"},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	assert.NoError(t, writer.WriteRow(testColumns, testRows[0]))
+	assert.NoError(t, writer.Flush())
+
+	assert.Contains(t, buffer.String(), `"id" integer GENERATED BY DEFAULT AS IDENTITY,`)
+	assert.Contains(t, buffer.String(),
+		`INSERT INTO "test_table" ("name", "valid") VALUES ('foo', TRUE);`)
+}
+
+// TestSQLLiteral checks conversion of values into SQL literals
+func TestSQLLiteral(t *testing.T) {
+	assert.Equal(t, "NULL", main.SQLLiteral(nil))
+	assert.Equal(t, "TRUE", main.SQLLiteral(true))
+	assert.Equal(t, "42", main.SQLLiteral(int64(42)))
+	assert.Equal(t, "1.5", main.SQLLiteral(1.5))
+	assert.Equal(t, "'it''s'", main.SQLLiteral("it's"))
+	assert.Equal(t, `'\x0aff'`, main.SQLLiteral([]byte{0x0a, 0xff}))
+}
+
+// TestSQLType checks mapping of database types into SQL types
+func TestSQLType(t *testing.T) {
+	assert.Equal(t, "bigint", main.SQLType("INT8"))
+	assert.Equal(t, "timestamp with time zone", main.SQLType("TIMESTAMPTZ"))
+	assert.Equal(t, "inet", main.SQLType("INET"))
+	assert.Equal(t, "text", main.SQLType(""))
+}
+
+// TestQuoteIdentifier checks quoting of table and column names
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"report"`, main.QuoteIdentifier("report"))
+	assert.Equal(t, `"a""b"`, main.QuoteIdentifier(`a"b`))
+}
+
+// TestSQLDumpTableWriterSchemaQualifiedTable checks that schema-qualified
+// table is created in its schema
+func TestSQLDumpTableWriterSchemaQualifiedTable(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	writer, err := main.NewTableWriter("sqldump", buffer, "archive.report", []main.Column{
+		{Name: "id", DatabaseType: "INT4"},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader([]string{"id"}))
+	assert.NoError(t, writer.WriteRow([]string{"id"}, main.M{"id": 1}))
+	assert.NoError(t, writer.Flush())
+
+	expected := `CREATE SCHEMA IF NOT EXISTS "archive";
+CREATE TABLE "archive"."report" (
+    "id" integer
+);
+
+INSERT INTO "archive"."report" ("id") VALUES (1);
+`
+	assert.Equal(t, expected, buffer.String())
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains handling of column types read from SQLite
+// databases. SQLite reports column types as they are declared in CREATE TABLE
+// statement (for example INTEGER or VARCHAR(255)), so they are converted into
+// names of PostgreSQL types the rest of exporter works with. Conversion
+// follows the rules used by SQLite to determine type affinity of columns.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlite.html
+
+import (
+	"strings"
+)
+
+// sqliteColumnType function converts type of column declared in SQLite
+// database into name of corresponding PostgreSQL type. Types without
+// PostgreSQL counterpart used by exporter are returned as they are.
+func sqliteColumnType(declaredType string) string {
+	declaredType = strings.ToUpper(strings.TrimSpace(declaredType))
+
+	switch {
+	case declaredType == "BOOL" || declaredType == "BOOLEAN":
+		return "BOOL"
+	case strings.HasPrefix(declaredType, "TIMESTAMP") ||
+		strings.HasPrefix(declaredType, "DATETIME"):
+		return "TIMESTAMP"
+	case strings.Contains(declaredType, "INT"):
+		return "INT4"
+	case strings.Contains(declaredType, "CHAR") ||
+		strings.Contains(declaredType, "CLOB") ||
+		strings.Contains(declaredType, "TEXT"):
+		return "TEXT"
+	default:
+		return declaredType
+	}
+}
+
+// columnTypeName function returns name of database type of column read by
+// given driver, types of SQLite columns are converted into PostgreSQL types
+func columnTypeName(driver DBDriver, databaseType string) string {
+	if driver == DBDriverSQLite3 {
+		return sqliteColumnType(databaseType)
+	}
+	return databaseType
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlite_test.html
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// newSQLiteTestDatabase helper function creates SQLite database file in
+// temporary directory of the test, performs given statements in it and
+// returns its data source
+func newSQLiteTestDatabase(t *testing.T, statements ...string) string {
+	dataSource := filepath.Join(t.TempDir(), "aggregator.db")
+
+	connection, err := sql.Open("sqlite3", dataSource)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	for _, statement := range statements {
+		_, err = connection.Exec(statement)
+		assert.NoError(t, err)
+	}
+
+	return dataSource
+}
+
+// TestSQLiteColumnType checks the function sqliteColumnType
+func TestSQLiteColumnType(t *testing.T) {
+	assert.Equal(t, "BOOL", main.SQLiteColumnType("boolean"))
+	assert.Equal(t, "BOOL", main.SQLiteColumnType("BOOL"))
+	assert.Equal(t, "INT4", main.SQLiteColumnType("INTEGER"))
+	assert.Equal(t, "INT4", main.SQLiteColumnType("bigint"))
+	assert.Equal(t, "TIMESTAMP", main.SQLiteColumnType("TIMESTAMP"))
+	assert.Equal(t, "TIMESTAMP", main.SQLiteColumnType("datetime"))
+	assert.Equal(t, "TEXT", main.SQLiteColumnType("VARCHAR(255)"))
+	assert.Equal(t, "TEXT", main.SQLiteColumnType("text"))
+	assert.Equal(t, "REAL", main.SQLiteColumnType("real"))
+	assert.Equal(t, "", main.SQLiteColumnType(""))
+}
+
+// TestPerformDataExportSQLite checks that tables with typed columns are
+// exported from SQLite database
+func TestPerformDataExportSQLite(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE rule_toggle (
+		org_id INTEGER NOT NULL,
+		rule_id VARCHAR NOT NULL,
+		disabled BOOLEAN,
+		updated_at TIMESTAMP)`,
+		`INSERT INTO rule_toggle VALUES
+		(42, 'rule1', true, '2024-01-02 03:04:05'),
+		(43, 'rule2', false, NULL)`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "ndjson",
+		OutputDirectory: directory,
+		ExportMetadata:  true,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	// types of values need to be preserved
+	content, err := os.ReadFile(filepath.Join(directory, "rule_toggle.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"org_id":42,"rule_id":"rule1","disabled":true,"updated_at":"2024-01-02T03:04:05Z"}`+"\n"+
+			`{"org_id":43,"rule_id":"rule2","disabled":false,"updated_at":null}`+"\n",
+		string(content))
+
+	// list of tables is read from SQLite catalog
+	content, err = os.ReadFile(filepath.Join(directory, "_tables.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Table name\nrule_toggle\n", string(content))
+}
+
+// TestPerformDataExportSQLiteCSVNullValue checks that NULL is distinguished
+// from empty string in table exported into CSV
+func TestPerformDataExportSQLiteCSVNullValue(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		`CREATE TABLE rule_toggle (
+		org_id INTEGER,
+		rule_id VARCHAR,
+		disabled BOOLEAN)`,
+		`INSERT INTO rule_toggle VALUES
+		(42, '', NULL),
+		(NULL, NULL, false),
+		(43, '\N', true)`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			CSVNullValue: `\N`,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "rule_toggle.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id,rule_id,disabled\n"+
+		"42,,\\N\n"+
+		"\\N,\\N,false\n"+
+		"43,\"\\N\",true\n", string(content))
+}
+
+// TestPerformDataExportSQLiteTableLimits checks that limits configured for
+// tables are combined with limit selected on command line
+func TestPerformDataExportSQLiteTableLimits(t *testing.T) {
+	var statements []string
+	for _, table := range []string{"report", "rule_hit", "cluster"} {
+		statements = append(statements,
+			"CREATE TABLE "+table+" (id INTEGER PRIMARY KEY)",
+			"INSERT INTO "+table+" VALUES (1), (2), (3), (4)")
+	}
+	dataSource := newSQLiteTestDatabase(t, statements...)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			OrderRows: true,
+		},
+		Limits: main.LimitsConfiguration{
+			"report":   1,
+			"rule_hit": 3,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		Limit:           2,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	expected := map[string]string{
+		"report":   "id\n1\n",
+		"rule_hit": "id\n1\n2\n",
+		"cluster":  "id\n1\n2\n",
+	}
+	for table, content := range expected {
+		data, err := os.ReadFile(filepath.Join(directory, table+".csv"))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data), table)
+	}
+}
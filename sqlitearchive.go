@@ -0,0 +1,219 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains archive that stores all exported tables into one
+// SQLite database file. Such file is portable snapshot of aggregator database
+// that can be queried by sqlite3 tool or by any SQLite client.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlitearchive.html
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// messages
+const (
+	sqliteArchiveOnly = "Format sqlite can be used to export all tables into one database file only"
+	tableNotCreated   = "Table %s has not been created"
+)
+
+// SQLiteArchive represents SQLite database file with exported tables. The
+// database is stored in temporary file until the archive is closed.
+type SQLiteArchive struct {
+	fileName   string
+	connection *sql.DB
+}
+
+// sqliteTableWriter writes content of one table into SQLite database. All
+// rows are inserted in one transaction.
+type sqliteTableWriter struct {
+	connection  *sql.DB
+	tableName   TableName
+	columns     []Column
+	transaction *sql.Tx
+	statement   *sql.Stmt
+}
+
+// NewSQLiteArchive function constructs new empty SQLite archive
+func NewSQLiteArchive() (*SQLiteArchive, error) {
+	file, err := os.CreateTemp("", "export-*"+SQLiteFileExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := file.Name()
+
+	// file will be filled in by SQLite driver
+	err = file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		_ = os.Remove(fileName)
+		return nil, err
+	}
+
+	return &SQLiteArchive{
+		fileName:   fileName,
+		connection: connection,
+	}, nil
+}
+
+// sqliteType function returns SQLite column type for given database type
+// name. NUMERIC values are stored as text, because conversion into REAL would
+// lose precision.
+func sqliteType(databaseType string) string {
+	switch databaseType {
+	case "INT2", "INT4", "INT8", "BOOL":
+		return "INTEGER"
+	case "FLOAT4", "FLOAT8":
+		return "REAL"
+	case "BYTEA":
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+// AddTable method adds new table into archive. The table is created when its
+// header is written.
+func (archive *SQLiteArchive) AddTable(tableName TableName, columns []Column) (TableWriter, error) {
+	return &sqliteTableWriter{
+		connection: archive.connection,
+		tableName:  tableName,
+		columns:    columns,
+	}, nil
+}
+
+// Write method writes the whole database file into given writer
+func (archive *SQLiteArchive) Write(writer io.Writer) error {
+	if writer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	// disable "G304 (CWE-22): Potential file inclusion via variable"
+	file, err := os.Open(archive.fileName) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	if err != nil {
+		// error during copy is more important than error during close
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Close method closes the database and removes temporary file
+func (archive *SQLiteArchive) Close() error {
+	err := archive.connection.Close()
+	if err != nil {
+		_ = os.Remove(archive.fileName)
+		return err
+	}
+
+	return os.Remove(archive.fileName)
+}
+
+// WriteHeader method creates the table and prepares INSERT statement
+func (w *sqliteTableWriter) WriteHeader(colNames []string) error {
+	definitions := make([]string, 0, len(w.columns))
+	for _, column := range w.columns {
+		definitions = append(definitions,
+			quoteIdentifier(column.Name)+" "+sqliteType(column.DatabaseType))
+	}
+
+	// SQLite has no schemas (qualifier selects attached database), so
+	// schema stays part of name of table created in the archive and
+	// same-named tables from different schemas don't collide
+	tableName := quoteIdentifier(string(w.tableName))
+
+	_, err := w.connection.Exec(fmt.Sprintf("CREATE TABLE %s (%s)",
+		tableName, strings.Join(definitions, ", ")))
+	if err != nil {
+		return err
+	}
+
+	quotedNames := make([]string, 0, len(colNames))
+	placeholders := make([]string, 0, len(colNames))
+	for _, colName := range colNames {
+		quotedNames = append(quotedNames, quoteIdentifier(colName))
+		placeholders = append(placeholders, "?")
+	}
+
+	w.transaction, err = w.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	// disable "G201 (CWE-89): SQL string formatting"
+	w.statement, err = w.transaction.Prepare(fmt.Sprintf( // #nosec G201
+		"INSERT INTO %s (%s) VALUES (%s)", tableName,
+		strings.Join(quotedNames, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		_ = w.transaction.Rollback()
+		w.transaction = nil
+		return err
+	}
+
+	return nil
+}
+
+// WriteRow method inserts one row into table
+func (w *sqliteTableWriter) WriteRow(colNames []string, row M) error {
+	if w.statement == nil {
+		return fmt.Errorf(tableNotCreated, w.tableName)
+	}
+
+	values := make([]interface{}, 0, len(colNames))
+	for _, colName := range colNames {
+		values = append(values, row[colName])
+	}
+
+	_, err := w.statement.Exec(values...)
+	return err
+}
+
+// Flush method commits all inserted rows
+func (w *sqliteTableWriter) Flush() error {
+	if w.transaction == nil {
+		return fmt.Errorf(tableNotCreated, w.tableName)
+	}
+
+	err := w.statement.Close()
+	if err != nil {
+		_ = w.transaction.Rollback()
+		return err
+	}
+
+	return w.transaction.Commit()
+}
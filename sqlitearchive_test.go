@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlitearchive_test.html
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// writeArchive helper function writes archive into file and opens it as
+// SQLite database
+func writeArchive(t *testing.T, archive main.TableArchive) *sql.DB {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, archive.Write(buffer))
+
+	fileName := filepath.Join(t.TempDir(), "export.sqlite")
+	assert.NoError(t, os.WriteFile(fileName, buffer.Bytes(), 0600))
+
+	connection, err := sql.Open("sqlite3", fileName)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, connection.Close())
+	})
+
+	return connection
+}
+
+// TestSQLiteArchive checks that tables written into archive can be read
+// back from SQLite database file
+func TestSQLiteArchive(t *testing.T) {
+	archive, err := main.NewSQLiteArchive()
+	assert.NoError(t, err)
+
+	writer, err := archive.AddTable("test_table", testTableColumns)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	for _, row := range testRows {
+		assert.NoError(t, writer.WriteRow(testColumns, row))
+	}
+	assert.NoError(t, writer.Flush())
+
+	// second table without rows
+	writer, err = archive.AddTable("empty table", testTableColumns)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(testColumns))
+	assert.NoError(t, writer.Flush())
+
+	connection := writeArchive(t, archive)
+	assert.NoError(t, archive.Close())
+
+	rows, err := connection.Query(`SELECT id, name, valid FROM test_table ORDER BY id`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, rows.Close())
+	}()
+
+	for _, expected := range testRows {
+		var id int64
+		var name string
+		var valid bool
+
+		assert.True(t, rows.Next())
+		assert.NoError(t, rows.Scan(&id, &name, &valid))
+		assert.Equal(t, expected["id"], id)
+		assert.Equal(t, expected["name"], name)
+		assert.Equal(t, expected["valid"], valid)
+	}
+	assert.False(t, rows.Next())
+
+	var count int
+	err = connection.QueryRow(`SELECT COUNT(*) FROM "empty table"`).Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// TestSQLiteArchiveColumnTypes checks types of columns in created tables
+func TestSQLiteArchiveColumnTypes(t *testing.T) {
+	archive, err := main.NewSQLiteArchive()
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, archive.Close())
+	}()
+
+	columns := []main.Column{
+		{Name: "id", DatabaseType: "INT8"},
+		{Name: "score", DatabaseType: "FLOAT8"},
+		{Name: "data", DatabaseType: "BYTEA"},
+		{Name: "created", DatabaseType: "TIMESTAMP"},
+	}
+	writer, err := archive.AddTable("types", columns)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader([]string{"id", "score", "data", "created"}))
+	assert.NoError(t, writer.Flush())
+
+	connection := writeArchive(t, archive)
+
+	rows, err := connection.Query(`SELECT name, type FROM pragma_table_info('types')`)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, rows.Close())
+	}()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var name, columnType string
+		assert.NoError(t, rows.Scan(&name, &columnType))
+		types[name] = columnType
+	}
+
+	assert.Equal(t, map[string]string{
+		"id":      "INTEGER",
+		"score":   "REAL",
+		"data":    "BLOB",
+		"created": "TEXT",
+	}, types)
+}
+
+// TestSQLiteArchiveRowWithoutHeader checks that row can not be written
+// before table is created
+func TestSQLiteArchiveRowWithoutHeader(t *testing.T) {
+	archive, err := main.NewSQLiteArchive()
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, archive.Close())
+	}()
+
+	writer, err := archive.AddTable("test_table", testTableColumns)
+	assert.NoError(t, err)
+
+	assert.Error(t, writer.WriteRow(testColumns, testRows[0]))
+	assert.Error(t, writer.Flush())
+}
+
+// TestSQLiteArchiveWriteNilWriter checks that nil writer is refused
+func TestSQLiteArchiveWriteNilWriter(t *testing.T) {
+	archive, err := main.NewSQLiteArchive()
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, archive.Close())
+	}()
+
+	assert.Error(t, archive.Write(nil))
+}
+
+// TestNewTableWriterSQLite checks that sqlite format can not be used for one
+// table only
+func TestNewTableWriterSQLite(t *testing.T) {
+	_, err := main.NewTableWriter("sqlite", new(bytes.Buffer), "test_table", testTableColumns)
+	assert.Error(t, err)
+}
@@ -0,0 +1,361 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains validation of SQL provided by users. Custom
+// queries must be exactly one SELECT statement and SQL expressions used in
+// casts and public masks must not contain statements at all. Keywords and
+// functions that modify the database are rejected, so the configuration
+// can't be abused to change aggregator database. Custom queries are
+// additionally explained by database (dry run) before they are performed.
+// Custom queries, their plans and reads of tables with casts are performed
+// in read-only transactions, so database refuses any modification even when
+// the SQL passes validation.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlsandbox.html
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// messages
+const (
+	emptyStatement        = "statement is empty"
+	multipleStatements    = "only one statement can be used"
+	statementInExpression = "expression can't contain statement separator"
+	notSelectStatement    = "only SELECT statement can be used, found %s"
+	forbiddenKeyword      = "keyword %s is not allowed"
+	forbiddenFunction     = "function %s is not allowed"
+	unterminatedLiteral   = "unterminated string literal or quoted identifier"
+	unterminatedComment   = "unterminated comment"
+	queryDryRunFailed     = "dry run of query failed: %w"
+	beginReadOnlyFailed   = "Unable to begin read-only transaction for user provided SQL"
+	rollbackReadOnlyError = "Unable to finish read-only transaction for user provided SQL"
+)
+
+// keywords that can start read-only query
+var readOnlyStatements = map[string]bool{
+	"select": true,
+	"with":   true,
+}
+
+// keywords of statements and clauses that modify database or its schema.
+// Other statements can't be used, because query must start by SELECT or
+// WITH and expression can't contain statement separator, but these
+// keywords can be embedded into SELECT (SELECT INTO, FOR UPDATE or
+// data-modifying WITH clause)
+var forbiddenKeywords = map[string]bool{
+	"alter":    true,
+	"copy":     true,
+	"create":   true,
+	"delete":   true,
+	"drop":     true,
+	"grant":    true,
+	"insert":   true,
+	"into":     true,
+	"merge":    true,
+	"revoke":   true,
+	"truncate": true,
+	"update":   true,
+}
+
+// functions with side effects, they are rejected when they are called
+var forbiddenFunctions = map[string]bool{
+	"dblink_exec":           true,
+	"lo_create":             true,
+	"lo_export":             true,
+	"lo_from_bytea":         true,
+	"lo_import":             true,
+	"lo_put":                true,
+	"lo_unlink":             true,
+	"nextval":               true,
+	"pg_advisory_lock":      true,
+	"pg_advisory_xact_lock": true,
+	"pg_cancel_backend":     true,
+	"pg_ls_dir":             true,
+	"pg_read_binary_file":   true,
+	"pg_read_file":          true,
+	"pg_reload_conf":        true,
+	"pg_stat_file":          true,
+	"pg_terminate_backend":  true,
+	"set_config":            true,
+	"setval":                true,
+}
+
+// sqlToken is one word or separator of SQL statement, string literals and
+// comments are skipped. Quoted identifiers are words too, so quoted names of
+// functions are checked the same way as unquoted ones.
+type sqlToken struct {
+	word      string
+	separator bool
+	call      bool
+	quoted    bool
+}
+
+// dollarQuoteTag function returns tag of PostgreSQL dollar quoted string
+// starting at given position (for example $$ or $body$), empty tag is
+// returned when no dollar quoted string starts there
+func dollarQuoteTag(statement string, start int) string {
+	for i := start + 1; i < len(statement); i++ {
+		c := rune(statement[i])
+		if c == '$' {
+			return statement[start : i+1]
+		}
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// quotedEnd function returns position just after string literal or quoted
+// identifier starting at given position. Doubled quote is escaped quote,
+// backslash escapes any character in PostgreSQL escape strings (E'...').
+func quotedEnd(statement string, start int, backslashEscapes bool) (int, error) {
+	quote := statement[start]
+	for end := start + 1; end < len(statement); end++ {
+		switch statement[end] {
+		case '\\':
+			if backslashEscapes {
+				end++
+			}
+		case quote:
+			if end+1 < len(statement) && statement[end+1] == quote {
+				end++
+				continue
+			}
+			return end + 1, nil
+		}
+	}
+	return 0, errors.New(unterminatedLiteral)
+}
+
+// isEscapeStringPrefix function checks if PostgreSQL escape string (E'...')
+// starts at given position
+func isEscapeStringPrefix(statement string, start int) bool {
+	if start+1 >= len(statement) || statement[start+1] != '\'' {
+		return false
+	}
+	if statement[start] != 'e' && statement[start] != 'E' {
+		return false
+	}
+	// E needs to start a word, otherwise it ends another word
+	if start > 0 {
+		previous := rune(statement[start-1])
+		if previous == '_' || previous == '$' || unicode.IsLetter(previous) ||
+			unicode.IsDigit(previous) {
+			return false
+		}
+	}
+	return true
+}
+
+// wordToken function constructs token from given word, whitespace is allowed
+// between function name and parenthesis
+func wordToken(word, rest string) sqlToken {
+	next := strings.TrimLeftFunc(rest, unicode.IsSpace)
+	return sqlToken{
+		word: strings.ToLower(word),
+		call: strings.HasPrefix(next, "("),
+	}
+}
+
+// tokenizeSQL function splits given SQL into words and statement
+// separators. Words are lower-cased, function calls are marked.
+func tokenizeSQL(statement string) ([]sqlToken, error) {
+	var tokens []sqlToken
+
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case isEscapeStringPrefix(statement, i):
+			end, err := quotedEnd(statement, i+1, true)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '\'':
+			end, err := quotedEnd(statement, i, false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '"' || c == '`':
+			end, err := quotedEnd(statement, i, false)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.ReplaceAll(statement[i+1:end-1], string(c)+string(c), string(c))
+			token := wordToken(name, statement[end:])
+			token.quoted = true
+			tokens = append(tokens, token)
+			i = end
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end + 1
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New(unterminatedComment)
+			}
+			i += end + 4
+		case c == '$' && dollarQuoteTag(statement, i) != "":
+			tag := dollarQuoteTag(statement, i)
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				return nil, errors.New(unterminatedLiteral)
+			}
+			i += len(tag) + end + len(tag)
+		case c == ';':
+			tokens = append(tokens, sqlToken{separator: true})
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(statement) && (statement[end] == '_' || statement[end] == '$' ||
+				unicode.IsLetter(rune(statement[end])) || unicode.IsDigit(rune(statement[end]))) {
+				end++
+			}
+			tokens = append(tokens, wordToken(statement[i:end], statement[end:]))
+			i = end
+		default:
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// checkTokens function checks that given tokens contain neither forbidden
+// keywords nor calls of forbidden functions. Quoted identifiers are never
+// keywords, but quoted function names are checked.
+func checkTokens(tokens []sqlToken) error {
+	for _, token := range tokens {
+		if token.call && forbiddenFunctions[token.word] {
+			return fmt.Errorf(forbiddenFunction, token.word)
+		}
+		if !token.quoted && forbiddenKeywords[token.word] {
+			return fmt.Errorf(forbiddenKeyword, strings.ToUpper(token.word))
+		}
+	}
+	return nil
+}
+
+// singleStatementTokens function splits given SQL into tokens and checks
+// that it contains exactly one statement (trailing separator is allowed)
+func singleStatementTokens(sqlStatement string) ([]sqlToken, error) {
+	tokens, err := tokenizeSQL(sqlStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	// trailing separators are ignored
+	for len(tokens) > 0 && tokens[len(tokens)-1].separator {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(emptyStatement)
+	}
+
+	for _, token := range tokens {
+		if token.separator {
+			return nil, errors.New(multipleStatements)
+		}
+	}
+	return tokens, nil
+}
+
+// checkReadOnlyQuery function checks that given custom query is exactly one
+// SELECT statement (trailing separator is allowed) that does not modify
+// database
+func checkReadOnlyQuery(sqlStatement string) error {
+	tokens, err := singleStatementTokens(sqlStatement)
+	if err != nil {
+		return err
+	}
+
+	if tokens[0].quoted || !readOnlyStatements[tokens[0].word] {
+		return fmt.Errorf(notSelectStatement, strings.ToUpper(tokens[0].word))
+	}
+
+	return checkTokens(tokens)
+}
+
+// checkSQLExpression function checks that given SQL expression (used in
+// casts and masks) contains no statement and does not modify database
+func checkSQLExpression(expression string) error {
+	tokens, err := tokenizeSQL(expression)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.separator {
+			return errors.New(statementInExpression)
+		}
+	}
+
+	return checkTokens(tokens)
+}
+
+// validateCustomQuery method checks that given custom query is read-only
+// and lets database explain it without performing it, so syntax errors and
+// unknown tables are found before the query is performed
+func (storage DBStorage) validateCustomQuery(ctx context.Context, sqlStatement string) error {
+	err := checkReadOnlyQuery(sqlStatement)
+	if err != nil {
+		return &RejectedQueryError{Reason: err.Error()}
+	}
+
+	_, err = storage.ExplainQuery(ctx, sqlStatement)
+	if err != nil {
+		return fmt.Errorf(queryDryRunFailed, err)
+	}
+	return nil
+}
+
+// withReadOnlyTransaction method performs given function in read-only
+// transaction, it is used for queries containing user provided SQL. The
+// transaction is always rolled back, nothing is changed by it.
+func (storage DBStorage) withReadOnlyTransaction(ctx context.Context,
+	perform func(transaction *sql.Tx) error) error {
+	transaction, err := storage.connection.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(beginReadOnlyFailed)
+		return err
+	}
+
+	defer func() {
+		err := transaction.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			storage.logger.Error().Err(err).Msg(rollbackReadOnlyError)
+		}
+	}()
+
+	return perform(transaction)
+}
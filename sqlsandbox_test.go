@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/sqlsandbox_test.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestCheckReadOnlyQuery checks the function CheckReadOnlyQuery
+func TestCheckReadOnlyQuery(t *testing.T) {
+	accepted := []string{
+		"SELECT 1",
+		"select org_id, count(*) from rule_hit group by org_id;",
+		"WITH hits AS (SELECT * FROM rule_hit) SELECT count(*) FROM hits",
+		// keywords in literals, quoted identifiers and comments are ignored
+		"SELECT 'DELETE FROM report; DROP TABLE report' AS note",
+		`SELECT "update" FROM report -- INSERT INTO report`,
+		"SELECT /* ; */ $body$ DROP TABLE x; $body$",
+		"SELECT 'it''s; fine'",
+		"SELECT updated_at, inserted FROM report",
+		`SELECT E'it\'s; fine', e'\\'`,
+		`SELECT "a""b;" FROM "Report"`,
+	}
+	for _, query := range accepted {
+		assert.NoError(t, main.CheckReadOnlyQuery(query), query)
+	}
+
+	rejected := map[string]string{
+		"":                                 "statement is empty",
+		" ; ":                              "statement is empty",
+		"SELECT 1; SELECT 2":               "only one statement can be used",
+		"SELECT 1;; DROP TABLE report":     "only one statement can be used",
+		"UPDATE report SET report = ''":    "only SELECT statement can be used, found UPDATE",
+		"VACUUM":                           "only SELECT statement can be used, found VACUUM",
+		"SELECT * INTO backup FROM report": "keyword INTO is not allowed",
+		"SELECT * FROM report FOR UPDATE":  "keyword UPDATE is not allowed",
+		"SELECT nextval ('report_seq')":    "function nextval is not allowed",
+		"SELECT pg_terminate_backend(1)":   "function pg_terminate_backend is not allowed",
+		"SELECT 'unterminated":             "unterminated string literal or quoted identifier",
+		"SELECT $$ unterminated":           "unterminated string literal or quoted identifier",
+		"SELECT 1 /* unterminated":         "unterminated comment",
+		"WITH x AS (INSERT INTO t VALUES(1)) SELECT 1": "keyword INSERT is not allowed",
+		// backslash escapes quote in escape string
+		`SELECT E'\''; DELETE FROM report; --'`: "only one statement can be used",
+		`SELECT e'\\'; DELETE FROM report; --'`: "only one statement can be used",
+		`SELECT E'unterminated\'`:               "unterminated string literal or quoted identifier",
+		// quoted and schema-qualified names of functions
+		`SELECT "setval"('report_seq', 1)`:          "function setval is not allowed",
+		`SELECT pg_catalog."nextval"('report_seq')`: "function nextval is not allowed",
+		"SELECT pg_read_file('/etc/passwd')":        "function pg_read_file is not allowed",
+		"SELECT lo_from_bytea(0, 'data')":           "function lo_from_bytea is not allowed",
+		`"select" FROM report`:                      "only SELECT statement can be used, found SELECT",
+	}
+	for query, expected := range rejected {
+		assert.EqualError(t, main.CheckReadOnlyQuery(query), expected, query)
+	}
+}
+
+// TestCheckSQLExpression checks the function CheckSQLExpression
+func TestCheckSQLExpression(t *testing.T) {
+	assert.NoError(t, main.CheckSQLExpression("report::text"))
+	assert.NoError(t, main.CheckSQLExpression("encode(template_data::text::bytea, 'hex')"))
+	assert.NoError(t, main.CheckSQLExpression("md5(cluster || ';')"))
+
+	assert.EqualError(t, main.CheckSQLExpression("report; DROP TABLE report"),
+		"expression can't contain statement separator")
+	assert.EqualError(t, main.CheckSQLExpression("(SELECT 1 FROM report FOR UPDATE)"),
+		"keyword UPDATE is not allowed")
+	assert.EqualError(t, main.CheckSQLExpression("set_config('role', 'admin', false)"),
+		"function set_config is not allowed")
+}
+
+// TestStoreQueryResultIntoCSVRejected checks that query that is not
+// read-only is not performed at all
+func TestStoreQueryResultIntoCSVRejected(t *testing.T) {
+	// prepare new mocked connection to database, no query is expected
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	err := storage.StoreQueryResultIntoCSV(context.Background(), new(bytes.Buffer),
+		"purge", "DELETE FROM rule_hit")
+	assert.EqualError(t, err,
+		"query is rejected: only SELECT statement can be used, found DELETE")
+	assert.ErrorIs(t, err, main.ErrQueryRejected)
+
+	var rejected *main.RejectedQueryError
+	assert.True(t, errors.As(err, &rejected))
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
+
+// TestStoreQueryResultIntoCSVDryRunError checks that query that can't be
+// explained by database is not performed
+func TestStoreQueryResultIntoCSVDryRunError(t *testing.T) {
+	connection, mock := mustCreateMockConnection(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("EXPLAIN SELECT \\* FROM missing").
+		WillReturnError(errors.New(`relation "missing" does not exist`))
+	mock.ExpectRollback()
+	mock.ExpectClose()
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	err := storage.StoreQueryResultIntoCSV(context.Background(), new(bytes.Buffer),
+		"missing", "SELECT * FROM missing")
+	assert.EqualError(t, err, `dry run of query failed: relation "missing" does not exist`)
+	assert.NotErrorIs(t, err, main.ErrQueryRejected)
+
+	checkConnectionClose(t, connection)
+	checkAllExpectations(t, mock)
+}
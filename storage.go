@@ -26,19 +26,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"database/sql"
 
 	_ "github.com/lib/pq"           // PostgreSQL database driver
 	_ "github.com/mattn/go-sqlite3" // SQLite database driver
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-
-	"github.com/minio/minio-go/v7"
 )
 
 // Driver types
@@ -57,14 +58,16 @@ const (
 	readTableContentFailed      = "Read table content failed"
 	readListOfRecordsFailed     = "Unable to read list of records"
 	writeOneRowToCSV            = "Write one row to CSV"
+	writeOneRowToOutput         = "Write one row to output"
 	sqlStatementExecuted        = "SQL statement"
 )
 
 // SQL statements
 const (
-	// Select all public tables from open database
+	// Select all public tables from open database together with their
+	// schemas
 	selectListOfTablesInPostgres = `
-           SELECT tablename
+           SELECT schemaname, tablename
              FROM pg_catalog.pg_tables
             WHERE schemaname != 'information_schema'
               AND schemaname != 'pg_catalog';
@@ -108,8 +111,8 @@ var (
 type Storage interface {
 	Close() error
 
-	ReadListOfTables() ([]TableName, error)
-	ReadTable(tableName string, limit int) error
+	ReadListOfTables(ctx context.Context) ([]TableName, error)
+	ReadTable(ctx context.Context, tableName TableName, limit int) error
 }
 
 // DBStorage is an implementation of Storage interface that use selected SQL like database
@@ -120,6 +123,75 @@ type DBStorage struct {
 	connection   *sql.DB
 	dbDriverType DBDriver
 	config       *StorageConfiguration
+	// copyConnection is used by COPY TO commands of all exported tables
+	copyConnection *copyConnection
+	summary        *Summary
+	progress       *Progress
+	// metrics is backend counters and timers are reported into
+	metrics      Metrics
+	compression  string
+	encryption   *Encryption
+	artifacts    *ArtifactLog
+	checksums    bool
+	directory    string
+	directories  []string
+	changes      *ChangeDetection
+	contentIndex *ContentIndex
+	quarantine   *Quarantine
+	invalidUTF8  string
+	casts        CastsConfiguration
+	queries      QueriesConfiguration
+	// identityColumns selects handling of identity columns in SQL dump
+	identityColumns string
+	// csvSchemaSidecars enables export of schema sidecar files
+	csvSchemaSidecars bool
+	// csvNullValue is written into CSV instead of NULL
+	csvNullValue string
+	// inferenceRows is number of rows sampled to infer types of columns
+	// without type
+	inferenceRows int
+	// orderRows enables ordering of rows of all tables by primary key
+	orderRows bool
+	// ordering contains columns rows of selected tables are ordered by
+	ordering OrderingConfiguration
+	// limits contains maximal numbers of rows exported from selected tables
+	limits LimitsConfiguration
+	// masking contains masking methods of anonymized columns
+	masking MaskingConfiguration
+	// objectNames contains names of files or objects selected tables are
+	// exported into
+	objectNames ObjectNamesConfiguration
+	// disabledRulesDetails enables detailed report of disabled rules
+	disabledRulesDetails bool
+	// ruleHits enables report of rule hits per organization
+	ruleHits bool
+	// orgSummary enables summary of clusters and reports per organization
+	orgSummary bool
+	// trendReport enables time series of rule hits and disabled rules
+	// counted by trendPeriod over trendWindow
+	trendReport bool
+	trendWindow time.Duration
+	trendPeriod string
+	// reportsOnly disables export of tables, only reports are exported
+	reportsOnly bool
+	// checkpointTables enables upload of tables with checkpoints and
+	// resumeTables continues uploads recorded by interrupted run
+	checkpointTables bool
+	resumeTables     bool
+	// upload is multipart upload with checkpoints table is written into,
+	// it is nil when table is not uploaded with checkpoints
+	upload *resumableUpload
+	// pseudonymKey is key pseudonyms of masked values are computed with
+	pseudonymKey    []byte
+	sample          float64
+	audit           *ExportAudit
+	profile         *ColumnProfile
+	verifyRowCounts bool
+	keepGoing       bool
+	breaker         *CircuitBreaker
+	tableFilter     *TableFilter
+	watermarks      *Watermarks
+	logger          zerolog.Logger
 }
 
 // NewStorage function creates and initializes a new instance of Storage interface
@@ -153,12 +225,37 @@ func NewStorage(configuration *StorageConfiguration) (*DBStorage, error) {
 // NewFromConnection function creates and initializes a new instance of Storage interface from prepared connection
 func NewFromConnection(connection *sql.DB, dbDriverType DBDriver, config *StorageConfiguration) *DBStorage {
 	return &DBStorage{
-		connection:   connection,
-		dbDriverType: dbDriverType,
-		config:       config,
+		connection:     connection,
+		dbDriverType:   dbDriverType,
+		config:         config,
+		copyConnection: &copyConnection{},
+		logger:         log.Logger,
 	}
 }
 
+// WithLogger method returns copy of storage that logs by given logger. It
+// allows to scope log messages to one export run or to one table.
+func (storage DBStorage) WithLogger(logger zerolog.Logger) *DBStorage {
+	storage.logger = logger
+	return &storage
+}
+
+// detached method returns copy of storage used by export phase that runs
+// concurrently with export of tables. Collectors shared by the whole run
+// (summary, progress, metrics and circuit breaker) are synchronized, so they
+// are kept. State of export of tables (quarantine, audit, column profile,
+// watermarks, change detection and content index) is not shared, so the
+// phases can't change each other's state.
+func (storage DBStorage) detached() *DBStorage {
+	storage.quarantine = nil
+	storage.audit = nil
+	storage.profile = nil
+	storage.watermarks = nil
+	storage.changes = nil
+	storage.contentIndex = nil
+	return &storage
+}
+
 // initAndGetDriver initializes driver(with logs if logSQLQueries is true),
 // checks if it's supported and returns driver type, driver name, dataSource and error
 func initAndGetDriver(configuration *StorageConfiguration) (driverType DBDriver, driverName, dataSource string, err error) {
@@ -180,7 +277,7 @@ func initAndGetDriver(configuration *StorageConfiguration) (driverType DBDriver,
 			configuration.PGParams,
 		)
 	default:
-		err = fmt.Errorf("driver %v is not supported", driverName)
+		err = &UnsupportedDriverError{Driver: driverName}
 		return
 	}
 
@@ -190,22 +287,38 @@ func initAndGetDriver(configuration *StorageConfiguration) (driverType DBDriver,
 // Close method closes the connection to database. Needs to be called at the
 // end of application lifecycle.
 func (storage DBStorage) Close() error {
-	log.Info().Msg("Closing connection to data storage")
+	storage.logger.Info().Msg("Closing connection to data storage")
+
+	// connection used by COPY TO commands is opened on demand
+	err := storage.copyConnection.Close()
+	if err != nil {
+		storage.logger.Error().Err(err).Msg("Can not close connection to data storage")
+		return err
+	}
 
 	// try to close the connection
 	if storage.connection != nil {
 		err := storage.connection.Close()
 		if err != nil {
-			log.Error().Err(err).Msg("Can not close connection to data storage")
+			storage.logger.Error().Err(err).Msg("Can not close connection to data storage")
 			return err
 		}
 	}
 	return nil
 }
 
+// queryContext method derives context of one database query from given
+// context. The query is canceled when configured query timeout elapses.
+func (storage DBStorage) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if storage.config == nil || storage.config.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, storage.config.QueryTimeout)
+}
+
 // ReadListOfTables method reads names of all public tables stored in opened
 // database.
-func (storage DBStorage) ReadListOfTables() ([]TableName, error) {
+func (storage DBStorage) ReadListOfTables(ctx context.Context) ([]TableName, error) {
 	// slice to make list of tables
 	var tableList = make([]TableName, 0)
 
@@ -216,10 +329,20 @@ func (storage DBStorage) ReadListOfTables() ([]TableName, error) {
 	case DBDriverPostgres:
 		selectListOfTables = selectListOfTablesInPostgres
 	default:
-		return tableList, fmt.Errorf("Invalid DB driver")
+		return tableList, ErrUnsupportedDriver
 	}
 
-	rows, err := storage.connection.Query(selectListOfTables)
+	// tables can be read from selected schemas only
+	var args []interface{}
+	if storage.dbDriverType == DBDriverPostgres && storage.config != nil &&
+		len(storage.config.Schemas) > 0 {
+		selectListOfTables, args = selectListOfTablesInSchemas(storage.config.Schemas)
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectListOfTables, args...)
 	if err != nil {
 		return tableList, err
 	}
@@ -227,37 +350,47 @@ func (storage DBStorage) ReadListOfTables() ([]TableName, error) {
 	defer func() {
 		err := rows.Close()
 		if err != nil {
-			log.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
 		}
 	}()
 
+	// schemas of tables (PostgreSQL only)
+	var schemas []string
+
 	// read all table names
 	for rows.Next() {
 		var tableName TableName
+		var schema string
 
-		err := rows.Scan(&tableName)
+		if storage.dbDriverType == DBDriverPostgres {
+			err = rows.Scan(&schema, &tableName)
+		} else {
+			err = rows.Scan(&tableName)
+		}
 		if err != nil {
 			if closeErr := rows.Close(); closeErr != nil {
-				log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
+				storage.logger.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
 			}
 			return tableList, err
 		}
 		tableList = append(tableList, tableName)
+		schemas = append(schemas, schema)
 	}
 
-	return tableList, nil
+	// tables from more schemas are qualified by schema
+	return qualifyTableNames(schemas, tableList), nil
 }
 
 // logColumnTypes is helper function to print column names and types for
 // selected table.
-func logColumnTypes(tableName TableName, columnTypes []*sql.ColumnType) {
-	log.Info().
+func logColumnTypes(logger *zerolog.Logger, tableName TableName, columnTypes []*sql.ColumnType) {
+	logger.Info().
 		Str("table columns", string(tableName)).
 		Int("columns", len(columnTypes)).
 		Msg("table metadata")
 
 	for i, columnType := range columnTypes {
-		log.Info().
+		logger.Info().
 			Str("name", columnType.Name()).
 			Str("type", columnType.DatabaseTypeName()).
 			Int("column", i+1).Msg("column type")
@@ -266,33 +399,55 @@ func logColumnTypes(tableName TableName, columnTypes []*sql.ColumnType) {
 
 // logRecordCount is helper function to print number of records stored in
 // given database table.
-func logRecordCount(tableName TableName, count int) {
-	log.Info().
+func logRecordCount(logger *zerolog.Logger, tableName TableName, count int) {
+	logger.Info().
 		Str("table name", string(tableName)).
 		Int("record count", count).
 		Msg("records in table")
 }
 
 // fillInScanArgs prepares arguments for the Scan method to retrieve row from
-// selected table.
+// selected table. Column types are interpreted according to database driver.
+// Types that can not be represented by plain strings and numbers without loss
+// of information are scanned by scanners defined in columntypes.go.
 //
 // Based on:
 // https://stackoverflow.com/questions/42774467/how-to-convert-sql-rows-to-typed-json-in-golang#60386531
-func fillInScanArgs(columnTypes []*sql.ColumnType) []interface{} {
+func fillInScanArgs(driver DBDriver, columnTypes []*sql.ColumnType) []interface{} {
 	count := len(columnTypes)
 
 	// data structure to scan one row
 	scanArgs := make([]interface{}, count)
 
 	for i, v := range columnTypes {
-		switch v.DatabaseTypeName() {
-		case "VARCHAR", "TEXT", "UUID", "TIMESTAMP":
+		databaseType := columnTypeName(driver, v.DatabaseTypeName())
+
+		switch databaseType {
+		case "VARCHAR", "TEXT", "UUID", "TIMESTAMP", "TIMESTAMPTZ":
 			scanArgs[i] = new(sql.NullString)
 		case "BOOL":
 			scanArgs[i] = new(sql.NullBool)
-		case "INT4":
+		case "INT2", "INT4", "INT8":
 			scanArgs[i] = new(sql.NullInt64)
+		case "FLOAT4", "FLOAT8":
+			scanArgs[i] = new(sql.NullFloat64)
+		case "NUMERIC":
+			scanArgs[i] = new(numericScanner)
+		case "JSON", "JSONB":
+			scanArgs[i] = new(jsonScanner)
+		case "BYTEA":
+			scanArgs[i] = new(byteaScanner)
+		case "DATE":
+			scanArgs[i] = &temporalScanner{layout: dateLayout}
+		case "TIME":
+			scanArgs[i] = &temporalScanner{layout: timeLayout}
+		case "TIMETZ":
+			scanArgs[i] = &temporalScanner{layout: timeTZLayout}
 		default:
+			if isArrayType(databaseType) {
+				scanArgs[i] = &arrayScanner{elementType: databaseType[1:]}
+				continue
+			}
 			scanArgs[i] = new(sql.NullString)
 		}
 	}
@@ -301,7 +456,7 @@ func fillInScanArgs(columnTypes []*sql.ColumnType) []interface{} {
 }
 
 // fillInMasterData fills the structure by row data read from database from
-// selected table.
+// selected table. NULL values are stored as nil.
 //
 // Based on:
 // https://stackoverflow.com/questions/42774467/how-to-convert-sql-rows-to-typed-json-in-golang#60386531
@@ -311,28 +466,33 @@ func fillInMasterData(columnTypes []*sql.ColumnType, scanArgs []interface{}) map
 	// fill-in the data structure by row data
 	for i, v := range columnTypes {
 
+		if z, ok := (scanArgs[i]).(columnScanner); ok {
+			masterData[v.Name()] = z.rowValue()
+			continue
+		}
+
 		if z, ok := (scanArgs[i]).(*sql.NullBool); ok {
-			masterData[v.Name()] = z.Bool
+			masterData[v.Name()] = nullableValue(z.Valid, z.Bool)
 			continue
 		}
 
 		if z, ok := (scanArgs[i]).(*sql.NullString); ok {
-			masterData[v.Name()] = z.String
+			masterData[v.Name()] = nullableValue(z.Valid, z.String)
 			continue
 		}
 
 		if z, ok := (scanArgs[i]).(*sql.NullInt64); ok {
-			masterData[v.Name()] = z.Int64
+			masterData[v.Name()] = nullableValue(z.Valid, z.Int64)
 			continue
 		}
 
 		if z, ok := (scanArgs[i]).(*sql.NullFloat64); ok {
-			masterData[v.Name()] = z.Float64
+			masterData[v.Name()] = nullableValue(z.Valid, z.Float64)
 			continue
 		}
 
 		if z, ok := (scanArgs[i]).(*sql.NullInt32); ok {
-			masterData[v.Name()] = z.Int32
+			masterData[v.Name()] = nullableValue(z.Valid, z.Int32)
 			continue
 		}
 
@@ -342,6 +502,15 @@ func fillInMasterData(columnTypes []*sql.ColumnType, scanArgs []interface{}) map
 	return masterData
 }
 
+// nullableValue function returns the scanned value or nil for NULL, so NULL
+// can be distinguished from empty string or zero in exported data
+func nullableValue(valid bool, value interface{}) interface{} {
+	if !valid {
+		return nil
+	}
+	return value
+}
+
 // select1FromTable is helper function to construct query to database - read
 // one record from given table.
 func select1FromTable(tableName TableName) string {
@@ -360,6 +529,15 @@ func selectCountFromTable(tableName TableName) string {
 	return fmt.Sprintf("SELECT count(*) FROM %s", string(tableName))
 }
 
+// selectNothingFromTable is helper function to construct query to database -
+// read no records from given table. It is used to check the SELECT permission.
+func selectNothingFromTable(tableName TableName) string {
+	// it is not possible to use parameter for table name or a key
+	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
+	// #nosec G201
+	return fmt.Sprintf("SELECT * FROM %s LIMIT 0", string(tableName))
+}
+
 func selectAllFromTable(tableName TableName) string {
 	// it is not possible to use parameter for table name or a key
 	// disable "G201 (CWE-89): SQL string formatting (Confidence: HIGH, Severity: MEDIUM)"
@@ -368,27 +546,131 @@ func selectAllFromTable(tableName TableName) string {
 }
 
 // ReadTable method reads the whole content of selected table.
-func (storage DBStorage) ReadTable(tableName TableName, limit int) ([]M, error) {
-	sqlStatement := selectAllFromTable(tableName)
+func (storage DBStorage) ReadTable(ctx context.Context, tableName TableName, limit int) ([]M, error) {
+	var finalRows []M
+
+	err := storage.scanTable(ctx, tableName, limit, func(row M) error {
+		finalRows = append(finalRows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return finalRows, nil
+}
+
+// tableLimit method returns maximal number of rows exported from given
+// table. Limit configured for the table is used when it is lower than given
+// limit or when given limit is not set (zero or negative).
+func (storage DBStorage) tableLimit(tableName TableName, limit int) int {
+	tableLimit, found := lookupTableConfig(storage.limits, tableName)
+	if !found || tableLimit <= 0 {
+		return limit
+	}
+
+	if limit <= 0 || tableLimit < limit {
+		return tableLimit
+	}
+	return limit
+}
+
+// scanTable method reads the whole content of selected table by one query
+// and passes rows one by one to given function
+func (storage DBStorage) scanTable(ctx context.Context, tableName TableName, limit int,
+	process func(M) error) error {
+	limit = storage.tableLimit(tableName, limit)
+
+	sqlStatement, err := storage.tableContentQuery(ctx, tableName, limit)
+	if err != nil {
+		return err
+	}
+
+	return storage.scanRows(ctx, tableName, sqlStatement, process, nil)
+}
+
+// tableContentQuery method constructs query to read content of selected
+// table with at most given number of rows
+func (storage DBStorage) tableContentQuery(ctx context.Context, tableName TableName,
+	limit int) (string, error) {
+	sqlStatement, err := storage.selectTableContent(ctx, tableName)
+	if err != nil {
+		return "", err
+	}
 
 	storage.applySelectiveExport(&sqlStatement, tableName)
 
+	err = storage.applyOrdering(ctx, &sqlStatement, tableName)
+	if err != nil {
+		return "", err
+	}
+
 	if limit > 0 {
 		sqlStatement += fmt.Sprintf(" LIMIT %d", limit)
 	}
 
-	log.Info().Str(sqlStatementExecuted, sqlStatement).Msg("Performing")
+	return sqlStatement, nil
+}
+
+// queryRows method performs given query with arguments and reads all rows
+// returned from database. Rows rejected by quarantine are passed to given
+// function (when it is not nil) with values as read from database.
+func (storage DBStorage) queryRows(ctx context.Context, tableName TableName, sqlStatement string,
+	reject func(M), args ...interface{}) ([]M, error) {
+	var finalRows []M
 
-	rows, err := storage.connection.Query(sqlStatement)
+	err := storage.scanRows(ctx, tableName, sqlStatement, func(row M) error {
+		finalRows = append(finalRows, row)
+		return nil
+	}, reject, args...)
 	if err != nil {
-		log.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
 		return nil, err
 	}
 
+	return finalRows, nil
+}
+
+// scanRows method performs given query with arguments and passes rows
+// returned from database one by one to given function, so they don't need to
+// be kept in memory. Rows rejected by quarantine are passed to reject
+// function (when it is not nil) with values as read from database.
+func (storage DBStorage) scanRows(ctx context.Context, tableName TableName, sqlStatement string,
+	process func(M) error, reject func(M), args ...interface{}) error {
+	// SQL expressions of casts are provided by users, so table with casts
+	// is read in read-only transaction
+	if casts, _ := lookupTableConfig(storage.casts, tableName); len(casts) > 0 {
+		return storage.withReadOnlyTransaction(ctx, func(transaction *sql.Tx) error {
+			return storage.scanRowsWith(ctx, transaction, tableName, sqlStatement,
+				process, reject, args...)
+		})
+	}
+
+	return storage.scanRowsWith(ctx, storage.connection, tableName, sqlStatement,
+		process, reject, args...)
+}
+
+// scanRowsWith method performs given query with arguments by given
+// connection or transaction and passes rows returned from database one by one
+// to given function. Rows rejected by quarantine are passed to reject
+// function (when it is not nil) with values as read from database.
+func (storage DBStorage) scanRowsWith(ctx context.Context, querier rowsQuerier,
+	tableName TableName, sqlStatement string, process func(M) error,
+	reject func(M), args ...interface{}) error {
+	storage.logger.Info().Str(sqlStatementExecuted, sqlStatement).Msg("Performing")
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := querier.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return err
+	}
+
 	defer func() {
 		err := rows.Close()
 		if err != nil {
-			log.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
 		}
 	}()
 
@@ -396,27 +678,44 @@ func (storage DBStorage) ReadTable(tableName TableName, limit int) ([]M, error)
 	columnTypes, err := rows.ColumnTypes()
 
 	if err != nil {
-		log.Error().Err(err).Msg(unableToRetrieveColumnTypes)
-		return nil, err
+		storage.logger.Error().Err(err).Msg(unableToRetrieveColumnTypes)
+		return err
 	}
 
-	logColumnTypes(tableName, columnTypes)
+	logColumnTypes(&storage.logger, tableName, columnTypes)
 
-	// prepare data structure to hold raw values
-	var finalRows []M
+	// rows that can't be scanned are quarantined with masked values
+	masks, err := storage.tableMasks(tableName)
+	if err != nil {
+		return err
+	}
 
 	// read table row by row
 	for rows.Next() {
 		// prepare arguments for the Scan method to retrieve row from
 		// selected table.
-		scanArgs := fillInScanArgs(columnTypes)
+		scanArgs := fillInScanArgs(storage.dbDriverType, columnTypes)
 
 		// do the actual scan of row read from database
 		err := rows.Scan(scanArgs...)
 
+		if err != nil && storage.quarantine.Enabled() {
+			// row is quarantined with values as read from database,
+			// masked columns are masked the same way as exported rows
+			colNames := getColumnNames(columnTypes)
+			storage.logger.Warn().Err(err).Msg(rowRejected)
+			raw := scanRawRow(rows, colNames)
+			rejectedNames, rejected := maskRejectedRow(masks, colNames, raw)
+			storage.quarantine.Reject(tableName, rejectedNames, rejected, err)
+			if reject != nil {
+				reject(raw)
+			}
+			continue
+		}
+
 		if err != nil {
-			log.Error().Err(err).Msg("Unable to scan row")
-			return nil, err
+			storage.logger.Error().Err(err).Msg("Unable to scan row")
+			return err
 		}
 
 		// it is now needed to check each element of values for nil
@@ -424,100 +723,233 @@ func (storage DBStorage) ReadTable(tableName TableName, limit int) ([]M, error)
 		// able to fetch the column into a typed variable if needed
 		masterData := fillInMasterData(columnTypes, scanArgs)
 
-		// TODO: make the export part there
-		// println(masterData)
-		finalRows = append(finalRows, masterData)
+		err = process(masterData)
+		if err != nil {
+			return err
+		}
 	}
-	return finalRows, nil
+
+	// reading can be interrupted by connection error or timeout
+	return rows.Err()
 }
 
-// StoreTable function stores specified table into S3/Minio
+// StoreTable function stores specified table into S3/Minio in selected
+// output format. Table is streamed into S3, it is serialized into buffer
+// only when hash of its content is needed (content-addressed layout and
+// detection of unchanged tables).
 func (storage DBStorage) StoreTable(ctx context.Context,
-	minioClient *minio.Client, bucketName, prefix string, tableName TableName,
-	limit int) error {
-	columnTypes, err := storage.RetrieveColumnTypes(tableName)
+	session *S3Session, bucketName, prefix string, tableName TableName,
+	limit int, format string) error {
+	objectName := setObjectPrefix(prefix, storage.tableObjectName(tableName, format))
+
+	// table is streamed into S3 while it is read from database unless its
+	// content needs to be known before upload
+	if storage.contentIndex == nil && storage.changes == nil {
+		// table read in chunks can be resumed in the middle
+		if storage.checkpointsApplicable(session, format) {
+			return storage.storeTableWithCheckpoints(ctx, session, bucketName,
+				prefix, objectName, tableName, limit, format)
+		}
+		return putObjectStream(ctx, session, bucketName, objectName,
+			contentType(format), storage.compression, storage.encryption,
+			func(output io.Writer) error {
+				return storage.storeTableIntoWriter(ctx, output, tableName,
+					limit, format)
+			})
+	}
+
+	buffer, err := storage.storeTableIntoBuffer(ctx, tableName, limit, format)
 	if err != nil {
 		return err
 	}
+	defer putBuffer(buffer)
 
-	colNames := getColumnNames(columnTypes)
+	// in content-addressed layout the object is named by hash of its
+	// content and object with the same content is uploaded only once
+	if storage.contentIndex != nil {
+		contentObject, upload, err := storage.contentAddressedObject(ctx,
+			session, bucketName, prefix, tableName, format, buffer.Bytes())
+		if err != nil {
+			return err
+		}
 
-	buffer := new(bytes.Buffer)
+		if !upload {
+			return nil
+		}
+		objectName = contentObject
+	}
 
-	// initialize CSV writer
-	writer := csv.NewWriter(buffer)
+	// upload of table that has not been changed since previous export is
+	// skipped, the object stored by previous export is referenced instead
+	if storage.changes != nil {
+		storedObject := objectName + artifactExtension(storage.compression, storage.encryption)
+		hash := contentHash(buffer.Bytes())
+
+		unchanged, err := storage.unchangedTable(ctx, session, bucketName,
+			tableName, storedObject, hash)
+		if err != nil {
+			return err
+		}
+
+		storage.changes.Record(tableName, storedObject, hash)
+
+		// audit of sensitive table is part of manifest too
+		if tableAudit, found := storage.audit.Table(tableName); found {
+			storage.changes.RecordAudit(tableName, tableAudit)
+		}
+
+		if unchanged {
+			return nil
+		}
+	}
+
+	// measure time spent by uploading data into S3
+	stopMeasuring := storage.summary.MeasureStage(stageUpload)
+
+	// exact object size is passed to S3, see
+	// https://docs.min.io/docs/golang-client-api-reference#PutObject
+	err = putObject(ctx, session, bucketName, objectName,
+		contentType(format), buffer.Bytes(), storage.compression, storage.encryption)
+	stopMeasuring()
+	return err
+}
+
+// storeTableIntoBuffer method serializes specified table into buffer taken
+// from pool in selected output format. Buffer needs to be returned into pool
+// by putBuffer when its content is not used anymore.
+func (storage DBStorage) storeTableIntoBuffer(ctx context.Context, tableName TableName,
+	limit int, format string) (*bytes.Buffer, error) {
+	buffer := getBuffer()
+
+	err := storage.storeTableIntoWriter(ctx, buffer, tableName, limit, format)
+	if err != nil {
+		putBuffer(buffer)
+		return nil, err
+	}
+
+	return buffer, nil
+}
 
-	err = writeColumnNames(writer, colNames)
+// storeTableIntoWriter method serializes specified table into given writer
+// in selected output format
+func (storage DBStorage) storeTableIntoWriter(ctx context.Context, output io.Writer,
+	tableName TableName, limit int, format string) error {
+	// check the output format before anything is read or written
+	err := checkFormat(format)
 	if err != nil {
 		return err
 	}
 
-	err = storage.WriteTableContent(writer, tableName, colNames, limit)
+	columnTypes, err := storage.RetrieveColumnTypes(ctx, tableName)
 	if err != nil {
 		return err
 	}
 
-	writer.Flush()
+	colNames := getColumnNames(columnTypes)
 
-	reader := io.Reader(buffer)
+	// PostgreSQL can format CSV itself
+	if storage.copyToApplicable(tableName, format) {
+		return storage.copyTableContent(ctx, output, tableName, colNames, limit)
+	}
 
-	// Compute exact object size instead of using default value -1
-	//
-	// Warning: possible problems with large tables and 32bit architecture
-	// Warning: passing -1 will allocate a large amount of memory
-	//
-	// Previous warning taken from:
-	// https://docs.min.io/docs/golang-client-api-reference#PutObject
-	size := buffer.Len()
+	columns, err := storage.tableColumns(ctx, tableName, columnTypes, format)
+	if err != nil {
+		return err
+	}
 
-	options := minio.PutObjectOptions{ContentType: "text/csv"}
-	objectName := setObjectPrefix(prefix, string(tableName)) + CSVFileExtension
-	_, err = minioClient.PutObject(ctx, bucketName, objectName, reader, int64(size), options)
+	// initialize writer for selected output format
+	writer, err := storage.newTableWriter(format, output, tableName, columns)
 	if err != nil {
 		return err
 	}
 
-	// reset buffer before it will be garbage collected
-	buffer.Reset()
-	return nil
+	// table resumed in the middle is continued without header
+	upload := storage.upload
+	upload.SetWriter(writer)
+	if !upload.Resumed() {
+		err = writer.WriteHeader(colNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = storage.WriteTableContent(ctx, writer, tableName, colNames, limit)
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
 }
 
-// StoreTableIntoFile function stores specified table into selected file
-func (storage DBStorage) StoreTableIntoFile(tableName TableName,
-	limit int) error {
-	columnTypes, err := storage.RetrieveColumnTypes(tableName)
+// StoreTableIntoFile function stores specified table into selected file in
+// selected output format
+func (storage DBStorage) StoreTableIntoFile(ctx context.Context, tableName TableName,
+	limit int, format string) error {
+	fileName := filepath.Join(storage.directory, storage.tableObjectName(tableName, format))
+	return storage.storeTableIntoNamedFile(ctx, fileName, tableName, limit, format,
+		storage.compression, storage.encryption)
+}
+
+// storeTableIntoNamedFile method stores specified table into file with given
+// name in selected output format. Data are compressed by given codec and
+// encrypted when encryption is given.
+func (storage DBStorage) storeTableIntoNamedFile(ctx context.Context, fileName string,
+	tableName TableName, limit int, format, compression string,
+	encryption *Encryption) error {
+	// check the output format before anything is read or written
+	err := checkFormat(format)
+	if err != nil {
+		return err
+	}
+
+	columnTypes, err := storage.RetrieveColumnTypes(ctx, tableName)
 	if err != nil {
 		return err
 	}
 
 	colNames := getColumnNames(columnTypes)
 
-	fileName := string(tableName) + CSVFileExtension
+	columns, err := storage.tableColumns(ctx, tableName, columnTypes, format)
+	if err != nil {
+		return err
+	}
 
-	// open new CSV file to be filled in
-	// disable "G304 (CWE-22): Potential file inclusion via variable"
-	fout, err := os.Create(fileName) // #nosec G304
+	// open new file to be filled in
+	fout, err := createCompressedFile(fileName, compression, encryption)
 	if err != nil {
 		return err
 	}
 
-	// initialize CSV writer
-	writer := csv.NewWriter(fout)
+	// PostgreSQL can format CSV itself
+	if storage.copyToApplicable(tableName, format) {
+		err = storage.copyTableContent(ctx, fout, tableName, colNames, limit)
+		if err != nil {
+			return err
+		}
+		return fout.Close()
+	}
 
-	err = writeColumnNames(writer, colNames)
+	// initialize writer for selected output format
+	writer, err := storage.newTableWriter(format, fout, tableName, columns)
 	if err != nil {
 		return err
 	}
 
-	err = storage.WriteTableContent(writer, tableName, colNames, limit)
+	err = writer.WriteHeader(colNames)
 	if err != nil {
 		return err
 	}
 
-	writer.Flush()
+	err = storage.WriteTableContent(ctx, writer, tableName, colNames, limit)
+	if err != nil {
+		return err
+	}
 
-	// check for any error during export to CSV
-	err = writer.Error()
+	// measure time spent by writing data into file
+	defer storage.summary.MeasureStage(stageUpload)()
+
+	// check for any error during export
+	err = writer.Flush()
 	if err != nil {
 		return err
 	}
@@ -531,15 +963,54 @@ func (storage DBStorage) StoreTableIntoFile(tableName TableName,
 	return nil
 }
 
+// StoreTableIntoArchive method stores specified table into given archive
+// with all exported tables (workbook, SQLite database etc.)
+func (storage DBStorage) StoreTableIntoArchive(ctx context.Context, archive TableArchive,
+	tableName TableName, limit int) error {
+	columnTypes, err := storage.RetrieveColumnTypes(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	colNames := getColumnNames(columnTypes)
+
+	writer, err := archive.AddTable(tableName, getColumns(storage.dbDriverType, columnTypes))
+	if err != nil {
+		return err
+	}
+
+	err = writer.WriteHeader(colNames)
+	if err != nil {
+		return err
+	}
+
+	err = storage.WriteTableContent(ctx, writer, tableName, colNames, limit)
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
 // ReadRecordsCount method reads number of records stored in given database
 // table.
-func (storage DBStorage) ReadRecordsCount(tableName TableName) (int, error) {
+func (storage DBStorage) ReadRecordsCount(ctx context.Context, tableName TableName) (int, error) {
+	return storage.readRecordsCount(ctx, storage.connection, tableName)
+}
+
+// readRecordsCount method reads number of records stored in given database
+// table by given connection or transaction.
+func (storage DBStorage) readRecordsCount(ctx context.Context, querier rowsQuerier,
+	tableName TableName) (int, error) {
 	sqlStatement := selectCountFromTable(tableName)
 
 	storage.applySelectiveExport(&sqlStatement, tableName)
 
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
 	// try to query DB
-	row := storage.connection.QueryRow(sqlStatement)
+	row := querier.QueryRowContext(ctx, sqlStatement)
 
 	var count int
 
@@ -549,85 +1020,243 @@ func (storage DBStorage) ReadRecordsCount(tableName TableName) (int, error) {
 	}
 
 	// everything seems to be ok
-	logRecordCount(tableName, count)
+	logRecordCount(&storage.logger, tableName, count)
 	return count, nil
 }
 
-// RetrieveColumnTypes read column types from given table
-func (storage DBStorage) RetrieveColumnTypes(tableName TableName) ([]*sql.ColumnType, error) {
+// CheckSelectPermission method checks if it is possible to read records from
+// given table. No records are really read.
+func (storage DBStorage) CheckSelectPermission(ctx context.Context, tableName TableName) error {
+	sqlStatement := selectNothingFromTable(tableName)
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	// try to query DB
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return err
+	}
+
+	// close query
+	return rows.Close()
+}
+
+// RetrieveColumnTypes read column types from given table. Read that failed
+// because of transient database error is retried. Columns dropped by masking
+// are not returned.
+func (storage DBStorage) RetrieveColumnTypes(ctx context.Context, tableName TableName) ([]*sql.ColumnType, error) {
+	var columnTypes []*sql.ColumnType
+
+	err := storage.withRetry(func() error {
+		var err error
+		columnTypes, err = storage.retrieveColumnTypes(ctx, tableName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// columns dropped by masking are not exported
+	return storage.dropMaskedColumns(tableName, columnTypes)
+}
+
+// retrieveColumnTypes method reads column types from given table
+func (storage DBStorage) retrieveColumnTypes(ctx context.Context, tableName TableName) ([]*sql.ColumnType, error) {
 	sqlStatement := select1FromTable(tableName)
 
+	// types of casted columns are given by SQL expressions
+	if casts, _ := lookupTableConfig(storage.casts, tableName); len(casts) > 0 {
+		selectContent, err := storage.selectTableContent(ctx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		sqlStatement = selectContent + " LIMIT 1"
+	}
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
 	// try to query DB
-	rows, err := storage.connection.Query(sqlStatement)
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
 	if err != nil {
-		log.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
 		return nil, err
 	}
 
 	// try to retrieve column types
 	columnTypes, err := rows.ColumnTypes()
 	if err != nil {
-		log.Error().Err(err).Msg(unableToRetrieveColumnTypes)
+		storage.logger.Error().Err(err).Msg(unableToRetrieveColumnTypes)
 		return nil, err
 	}
 
 	// close query
 	err = rows.Close()
 	if err != nil {
-		log.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
 		return nil, err
 	}
 
 	// everything seems to be ok
-	logColumnTypes(tableName, columnTypes)
+	logColumnTypes(&storage.logger, tableName, columnTypes)
 	return columnTypes, nil
 }
 
-// WriteTableContent method writes content of whole table into given CSV
-// writera (may be file or S3 bucke)
-func (storage DBStorage) WriteTableContent(writer *csv.Writer,
+// WriteTableContent method writes content of whole table into given table
+// writer (that writes into file or S3 bucket). Rows are written as they are
+// read from database, so the whole table is not kept in memory. Read that
+// failed is retried only when no row has been written yet.
+func (storage DBStorage) WriteTableContent(ctx context.Context, writer TableWriter,
 	tableName TableName, colNames []string, limit int) error {
-	// now we know column types, time to perform export
-	finalRows, err := storage.ReadTable(tableName, limit)
+	auditor, err := storage.newTableAuditor(ctx, tableName)
 	if err != nil {
-		log.Error().Err(err).Msg(readTableContentFailed)
 		return err
 	}
 
-	for _, finalRow := range finalRows {
-		var columns []string
-		for _, colName := range colNames {
-			value := finalRow[colName]
-			str := fmt.Sprintf("%v", value)
-			columns = append(columns, str)
+	tracker, err := storage.newWatermarkTracker(colNames, tableName)
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(readTableContentFailed)
+		return err
+	}
+	storage.logWatermark(tableName)
+
+	masks, err := storage.tableMasks(tableName)
+	if err != nil {
+		storage.logger.Error().Err(err).Msg(readTableContentFailed)
+		return err
+	}
+
+	profiler := storage.profile.newTableProfiler(colNames)
+
+	// progress of the export is tracked row by row
+	storage.progress.StartTable(tableName)
+
+	// all rejected rows are counted in summary
+	defer func() {
+		storage.summary.AddRejectedRows(storage.quarantine.Count(tableName))
+	}()
+
+	// rows are written while they are read from database, time spent by
+	// writing them is measured separately
+	started := time.Now()
+	var conversion time.Duration
+	defer func() {
+		storage.summary.AddDuration(stageDataRead, time.Since(started)-conversion)
+		storage.summary.AddDuration(stageConversion, conversion)
+	}()
+
+	// number of records is read in the same snapshot as rows
+	verified := storage.rowCountVerified(tableName)
+	expectedRows := 0
+
+	exportedRows := 0
+	var writeErr error
+
+	// write one row, row that can't be converted is quarantined
+	writeRow := func(row M) error {
+		writeStarted := time.Now()
+
+		// anonymized values are written, watermark is tracked by
+		// original values
+		exported := row
+		if masks != nil {
+			exported = maskRow(masks, row)
+		}
+
+		err := validateUTF8(colNames, exported, storage.invalidUTF8)
+		if err == nil {
+			err = writer.WriteRow(colNames, exported)
+		}
+		conversion += time.Since(writeStarted)
+
+		var conversionErr RowConversionError
+		if err != nil && storage.quarantine.Enabled() &&
+			errors.As(err, &conversionErr) {
+			storage.logger.Warn().Err(err).Msg(rowRejected)
+			// masked values are quarantined, never the original ones
+			storage.quarantine.Reject(tableName, colNames, exported, err)
+			return nil
 		}
-		err = writer.Write(columns)
+
 		if err != nil {
-			log.Error().Err(err).Msg(writeOneRowToCSV)
+			storage.logger.Error().Err(err).Msg(writeOneRowToOutput)
+			writeErr = err
 			return err
 		}
+
+		auditor.Add(colNames, exported)
+		profiler.Add(exported)
+		tracker.Add(row)
+		storage.progress.AddRows(1)
+		exportedRows++
+		return nil
 	}
+
+	err = storage.withRetry(func() error {
+		// rows rejected by failed read are read again
+		storage.quarantine.Reset(tableName)
+		auditor.Reset()
+		profiler.Reset()
+		tracker.Reset()
+
+		var err error
+		if verified {
+			expectedRows, err = storage.readTableInSnapshot(ctx, tableName, limit, writeRow)
+		} else {
+			err = storage.streamTableContent(ctx, tableName, limit, writeRow)
+		}
+
+		// rows that have been written already can't be taken back, so
+		// the table can't be read again
+		if err != nil && (writeErr != nil || exportedRows > 0) {
+			return finalError{err}
+		}
+		return err
+	})
+	if err != nil {
+		if writeErr == nil {
+			storage.logger.Error().Err(err).Msg(readTableContentFailed)
+		}
+		return err
+	}
+
+	if verified {
+		storage.verifyRowCount(tableName, expectedRows, exportedRows)
+	}
+
+	storage.recordTableAudit(tableName, auditor)
+	storage.profile.Record(tableName, profiler)
+	storage.recordWatermark(tableName, limit, tracker)
+	duration := time.Since(started)
+	storage.recordTableMetrics(tableName, exportedRows,
+		storage.quarantine.Count(tableName), duration)
+	storage.summary.AddExportedRows(exportedRows)
+	storage.summary.RecordTable(tableName, colNames, exportedRows)
+	storage.summary.RecordTableThroughput(tableName, exportedRows, duration)
+	storage.progress.FinishTable()
 	return nil
 }
 
 // StoreTableMetadataIntoFile method stores metadata about given tables into
 // file.
-func (storage DBStorage) StoreTableMetadataIntoFile(fileName string, tableNames []TableName) error {
+func (storage DBStorage) StoreTableMetadataIntoFile(ctx context.Context, fileName string, tableNames []TableName) error {
 	// open new CSV file to be filled in
-	// disable "G304 (CWE-22): Potential file inclusion via variable"
-	fout, err := os.Create(fileName) // #nosec G304
+	fout, err := createCompressedFile(fileName, storage.compression, storage.encryption)
 	if err != nil {
 		return err
 	}
 
-	err = TableMetadataToCSV(fout, tableNames, storage)
+	err = TableMetadataToCSV(ctx, fout, tableNames, storage)
 	if err != nil {
 		// logging has been performed already
 		return err
 	}
 
 	// initialize CSV writer
-	writer := csv.NewWriter(fout)
+	writer, release := newCSVWriter(fout)
+	defer release()
 
 	// check for any error during export to CSV
 	err = writer.Error()
@@ -647,22 +1276,29 @@ func (storage DBStorage) StoreTableMetadataIntoFile(fileName string, tableNames
 // StoreTableMetadataIntoS3 method stores metadata about given tables into
 // S3 or Minio.
 func (storage DBStorage) StoreTableMetadataIntoS3(ctx context.Context,
-	minioClient *minio.Client, bucketName string, objectName string,
+	session *S3Session, bucketName string, objectName string,
 	tableNames []TableName) error {
+	return storage.storeTableMetadataIntoS3(ctx, session, bucketName,
+		objectName, tableNames, csvFormat)
+}
 
-	buffer := new(bytes.Buffer)
+// storeTableMetadataIntoS3 method stores metadata about given tables into
+// S3 or Minio in report format for selected output format.
+func (storage DBStorage) storeTableMetadataIntoS3(ctx context.Context,
+	session *S3Session, bucketName string, objectName string,
+	tableNames []TableName, format string) error {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 
-	err := TableMetadataToCSV(buffer, tableNames, storage)
+	err := writeTableMetadata(ctx, buffer, tableNames, storage, format)
 	if err != nil {
 		// logging has been performed already
 		return err
 	}
 
-	// write CSV data into S3 bucket or Minio bucket
-	reader := io.Reader(buffer)
-
-	options := minio.PutObjectOptions{ContentType: "text/csv"}
-	_, err = minioClient.PutObject(ctx, bucketName, objectName, reader, -1, options)
+	// write report into S3 bucket or Minio bucket
+	err = putObject(ctx, session, bucketName, objectName,
+		reportContentType(format), buffer.Bytes(), storage.compression, storage.encryption)
 	if err != nil {
 		return err
 	}
@@ -680,6 +1316,20 @@ func getColumnNames(columnTypes []*sql.ColumnType) []string {
 	return colNames
 }
 
+// getColumns function returns names and database types of all columns read
+// by given driver
+func getColumns(driver DBDriver, columnTypes []*sql.ColumnType) []Column {
+	columns := make([]Column, 0, len(columnTypes))
+	for _, columnType := range columnTypes {
+		columns = append(columns, Column{
+			Name:         columnType.Name(),
+			DatabaseType: columnTypeName(driver, columnType.DatabaseTypeName()),
+		})
+	}
+
+	return columns
+}
+
 func writeColumnNames(writer *csv.Writer, colNames []string) error {
 	err := writer.Write(colNames)
 	if err != nil {
@@ -690,11 +1340,14 @@ func writeColumnNames(writer *csv.Writer, colNames []string) error {
 }
 
 // ReadDisabledRules method reads rules disabled by more than one user
-func (storage DBStorage) ReadDisabledRules() ([]DisabledRuleInfo, error) {
+func (storage DBStorage) ReadDisabledRules(ctx context.Context) ([]DisabledRuleInfo, error) {
 	// slice to make list of disabled rule
 	var disabledRulesInfo = make([]DisabledRuleInfo, 0)
 
-	rows, err := storage.connection.Query(selectDisabledRules)
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, selectDisabledRules)
 	if err != nil {
 		return disabledRulesInfo, err
 	}
@@ -702,7 +1355,7 @@ func (storage DBStorage) ReadDisabledRules() ([]DisabledRuleInfo, error) {
 	defer func() {
 		err := rows.Close()
 		if err != nil {
-			log.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
 		}
 	}()
 
@@ -713,7 +1366,7 @@ func (storage DBStorage) ReadDisabledRules() ([]DisabledRuleInfo, error) {
 		err := rows.Scan(&disabledRuleInfo.Rule, &disabledRuleInfo.Count)
 		if err != nil {
 			if closeErr := rows.Close(); closeErr != nil {
-				log.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
+				storage.logger.Error().Err(closeErr).Msg(unableToCloseDBRowsHandle)
 			}
 			return disabledRulesInfo, err
 		}
@@ -726,15 +1379,24 @@ func (storage DBStorage) ReadDisabledRules() ([]DisabledRuleInfo, error) {
 // check whether table is allowed to be exported selectively by org_id
 func selectiveExportAllowed(tablename TableName) bool {
 	for i := range selectiveExportAllowedTables {
-		if tablename == selectiveExportAllowedTables[i] {
+		if TableName(tablename.Name()) == selectiveExportAllowedTables[i] {
 			return true
 		}
 	}
 	return false
 }
 
+// orgIDFilterApplied method checks whether records from given table are
+// filtered by organization ID
+func (storage DBStorage) orgIDFilterApplied(tablename TableName) bool {
+	return storage.config.EnableOrgIDFiltering && selectiveExportAllowed(tablename)
+}
+
 func (storage DBStorage) applySelectiveExport(sqlStatement *string, tablename TableName) {
-	if storage.config.EnableOrgIDFiltering && selectiveExportAllowed(tablename) {
+	if storage.orgIDFilterApplied(tablename) {
 		*sqlStatement += fmt.Sprintf(whereOrgIDFilter, strings.Join(storage.config.OrganizationsToExport, "','"))
 	}
+
+	// only rows above watermark of previous run are exported
+	storage.applyWatermark(sqlStatement, tablename)
 }
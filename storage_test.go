@@ -23,13 +23,17 @@ package main_test
 // https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/storage_test.html
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"database/sql"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 
 	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
@@ -138,7 +142,7 @@ const (
 	readRecordCountQuery          = "SELECT count\\(\\*\\) FROM TESTED_TABLE"
 	readDisabledRulesQuery        = "SELECT rule_id, count\\(rule_id\\) AS rule_count FROM rule_disable GROUP BY rule_id HAVING count\\(rule_id\\)\\>1 ORDER BY rule_count DESC;"
 	readListOfTablesQueryPostgres = `
-           SELECT tablename
+           SELECT schemaname, tablename
              FROM pg_catalog.pg_tables
             WHERE schemaname != 'information_schema'
               AND schemaname != 'pg_catalog';
@@ -172,7 +176,7 @@ func TestReadRecordCount(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	count, err := storage.ReadRecordsCount("TESTED_TABLE")
+	count, err := storage.ReadRecordsCount(context.Background(), "TESTED_TABLE")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -188,6 +192,42 @@ func TestReadRecordCount(t *testing.T) {
 	checkAllExpectations(t, mock)
 }
 
+// TestWithLogger checks that messages are logged by logger given to the
+// method WithLogger and that the original storage is not changed
+func TestWithLogger(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rowsCount := sqlmock.NewRows([]string{"count"})
+	rowsCount.AddRow(100)
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readRecordCountQuery).WillReturnRows(rowsCount)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	var buffer bytes.Buffer
+	logger := zerolog.New(&buffer).With().Str("run ID", "0123456789abcdef").Logger()
+	scoped := storage.WithLogger(logger)
+	assert.NotSame(t, storage, scoped)
+
+	// call the tested method
+	_, err := scoped.ReadRecordsCount(context.Background(), "TESTED_TABLE")
+	assert.NoError(t, err)
+
+	assert.Contains(t, buffer.String(), `"run ID":"0123456789abcdef"`)
+	assert.Contains(t, buffer.String(), `"record count":100`)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
 func TestReadRecordCountScanError(t *testing.T) {
 	// prepare new mocked connection to database
 	connection, mock := mustCreateMockConnection(t)
@@ -204,7 +244,7 @@ func TestReadRecordCountScanError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadRecordsCount("TESTED_TABLE")
+	_, err := storage.ReadRecordsCount(context.Background(), "TESTED_TABLE")
 	if err == nil {
 		t.Errorf("error is expected")
 	}
@@ -231,7 +271,7 @@ func TestReadRecordCountOnError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	count, err := storage.ReadRecordsCount("TESTED_TABLE")
+	count, err := storage.ReadRecordsCount(context.Background(), "TESTED_TABLE")
 	if err != mockedError {
 		t.Errorf("different error was returned: %v", err)
 	}
@@ -269,7 +309,7 @@ func TestReadRecordCountSelectiveExportDisallowedTable(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method
-	count, err := storage.ReadRecordsCount("TESTED_TABLE")
+	count, err := storage.ReadRecordsCount(context.Background(), "TESTED_TABLE")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -308,7 +348,7 @@ func TestReadRecordCountSelectiveExportAllowedTableSingleOrgID(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method
-	count, err := storage.ReadRecordsCount("report")
+	count, err := storage.ReadRecordsCount(context.Background(), "report")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -347,7 +387,7 @@ func TestReadRecordCountSelectiveExportAllowedTable(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method
-	count, err := storage.ReadRecordsCount("report")
+	count, err := storage.ReadRecordsCount(context.Background(), "report")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -369,10 +409,10 @@ func TestReadListOfTables(t *testing.T) {
 	connection, mock := mustCreateMockConnection(t)
 
 	// prepare mocked result for SQL query
-	rows := sqlmock.NewRows([]string{"tablename"})
-	rows.AddRow("foo")
-	rows.AddRow("bar")
-	rows.AddRow("baz")
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "foo")
+	rows.AddRow("public", "bar")
+	rows.AddRow("public", "baz")
 
 	// expected query performed by tested function
 	mock.ExpectQuery(readListOfTablesQueryPostgres).WillReturnRows(rows)
@@ -382,7 +422,7 @@ func TestReadListOfTables(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	tableNames, err := storage.ReadListOfTables()
+	tableNames, err := storage.ReadListOfTables(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -417,7 +457,7 @@ func TestReadListOfTablesSQLiteDriver(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverSQLite3, &testConfig)
 
 	// call the tested method
-	tableNames, err := storage.ReadListOfTables()
+	tableNames, err := storage.ReadListOfTables(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -442,7 +482,7 @@ func TestReadListOfTablesInvalidDriver(t *testing.T) {
 	storage := main.NewFromConnection(connection, 2+main.DBDriverSQLite3, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadListOfTables()
+	_, err := storage.ReadListOfTables(context.Background())
 	if err == nil {
 		t.Errorf("error was expected")
 	}
@@ -467,7 +507,7 @@ func TestReadListOfTablesOnError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadListOfTables()
+	_, err := storage.ReadListOfTables(context.Background())
 	if err != mockedError {
 		t.Errorf("different error was returned: %v", err)
 	}
@@ -485,10 +525,10 @@ func TestReadListOfTablesScanError(t *testing.T) {
 	connection, mock := mustCreateMockConnection(t)
 
 	// prepare mocked result for SQL query
-	rows := sqlmock.NewRows([]string{"tablename"})
-	rows.AddRow(1)
-	rows.AddRow(2)
-	rows.AddRow(3)
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", 1)
+	rows.AddRow("public", 2)
+	rows.AddRow("public", 3)
 
 	// expected query performed by tested function
 	mock.ExpectQuery(readListOfTablesQueryPostgres).WillReturnRows(rows)
@@ -498,7 +538,7 @@ func TestReadListOfTablesScanError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadListOfTables()
+	_, err := storage.ReadListOfTables(context.Background())
 	if err == nil {
 		t.Errorf("error is expected")
 	}
@@ -510,6 +550,63 @@ func TestReadListOfTablesScanError(t *testing.T) {
 	checkAllExpectations(t, mock)
 }
 
+// check that the function ReadListOfTables is canceled when query takes
+// longer than configured timeout
+func TestReadListOfTablesQueryTimeout(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	rows := sqlmock.NewRows([]string{"schemaname", "tablename"})
+	rows.AddRow("public", "foo")
+
+	// expected query performed by tested function never finishes in time
+	mock.ExpectQuery(readListOfTablesQueryPostgres).
+		WillDelayFor(time.Minute).
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database with query timeout
+	config := testConfig
+	config.QueryTimeout = 10 * time.Millisecond
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &config)
+
+	// call the tested method
+	start := time.Now()
+	_, err := storage.ReadListOfTables(context.Background())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Minute)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+}
+
+// check that the function ReadListOfTables is stopped when the export is
+// canceled
+func TestReadListOfTablesCanceled(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// query is not sent to database at all
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// export is canceled before the query is performed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := storage.ReadListOfTables(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
 // check the function ReadTable
 func TestReadTable(t *testing.T) {
 	// prepare new mocked connection to database
@@ -536,7 +633,7 @@ func TestReadTable(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	values, err := storage.ReadTable("table_name", NoLimits)
+	values, err := storage.ReadTable(context.Background(), "table_name", NoLimits)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -584,7 +681,7 @@ func TestReadTableWithLimits(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	values, err := storage.ReadTable("table_name", 2)
+	values, err := storage.ReadTable(context.Background(), "table_name", 2)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -605,6 +702,36 @@ func TestReadTableWithLimits(t *testing.T) {
 	checkAllExpectations(t, mock)
 }
 
+// check the function ReadTable with limit configured for the table, the
+// lower of configured and given limit is used
+func TestReadTableWithTableLimits(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	for _, query := range []string{" LIMIT 3", " LIMIT 2", " LIMIT 3"} {
+		rows := mock.NewRowsWithColumnDefinition(
+			sqlmock.NewColumn("id").OfType("INT4", int64(0)))
+		rows.AddRow(1)
+		mock.ExpectQuery(readTableQuery + query).WillReturnRows(rows)
+	}
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+	main.SetLimits(storage, main.LimitsConfiguration{"table_name": 3, "other_table": 1})
+
+	for _, limit := range []int{NoLimits, 2, 5} {
+		_, err := storage.ReadTable(context.Background(), "table_name", limit)
+		assert.NoError(t, err)
+	}
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
 // check the function ReadTable with selective export enabled
 func TestReadTableWithSelectiveExportDisallowedTable(t *testing.T) {
 	config := &testConfig
@@ -634,7 +761,7 @@ func TestReadTableWithSelectiveExportDisallowedTable(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method, table name must be in predefined list
-	values, err := storage.ReadTable("table_name", NoLimits)
+	values, err := storage.ReadTable(context.Background(), "table_name", NoLimits)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -684,7 +811,7 @@ func TestReadTableWithSelectiveExportAllowedTableSingleOrgID(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method, table name must be in predefined list
-	values, err := storage.ReadTable("report", NoLimits)
+	values, err := storage.ReadTable(context.Background(), "report", NoLimits)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -734,7 +861,7 @@ func TestReadTableWithSelectiveExportAllowedTable(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method, table name must be in predefined list
-	values, err := storage.ReadTable("report", NoLimits)
+	values, err := storage.ReadTable(context.Background(), "report", NoLimits)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -784,7 +911,7 @@ func TestReadTableWithSelectiveExportAllowedTableWithLimits(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, config)
 
 	// call the tested method, table name must be in predefined list, limit == 2
-	values, err := storage.ReadTable("report", 2)
+	values, err := storage.ReadTable(context.Background(), "report", 2)
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -821,7 +948,7 @@ func TestReadTableOnError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadTable("table_name", NoLimits)
+	_, err := storage.ReadTable(context.Background(), "table_name", NoLimits)
 	if err != mockedError {
 		t.Errorf("different error was returned: %v", err)
 	}
@@ -858,7 +985,7 @@ func TestRetrieveColumnTypes(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	types, err := storage.RetrieveColumnTypes("table_name")
+	types, err := storage.RetrieveColumnTypes(context.Background(), "table_name")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -894,7 +1021,7 @@ func TestRetrieveColumnTypesOnError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.RetrieveColumnTypes("table_name")
+	_, err := storage.RetrieveColumnTypes(context.Background(), "table_name")
 
 	if err != mockedError {
 		t.Errorf("different error was returned: %v", err)
@@ -937,7 +1064,7 @@ func TestStoreTableIntoFile(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	err := storage.StoreTableIntoFile("table_name", NoLimits)
+	err := storage.StoreTableIntoFile(context.Background(), "table_name", NoLimits, "csv")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -991,7 +1118,7 @@ func TestStoreTableIntoFileWithLimit(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	err := storage.StoreTableIntoFile("table_name", 2)
+	err := storage.StoreTableIntoFile(context.Background(), "table_name", 2, "csv")
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -1015,6 +1142,120 @@ func TestStoreTableIntoFileWithLimit(t *testing.T) {
 	assert.Equal(t, expected, string(content))
 }
 
+// check the function StoreTableIntoFile for JSON output format
+func TestStoreTableIntoFileJSONFormat(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	column1 := sqlmock.NewColumn("id").OfType("INT4", int64(0))
+	column2 := sqlmock.NewColumn("text").OfType("VARCHAR", "")
+	column3 := sqlmock.NewColumn("valid").OfType("BOOL", false)
+
+	// columns of different types
+	rows := mock.NewRowsWithColumnDefinition(column1, column2, column3)
+
+	rows.AddRow(1, "foo", true)
+	rows.AddRow(2, "bar", false)
+
+	// expected query performed by tested function
+	mock.ExpectQuery(readColumnTypesQuery).WillReturnRows(rows)
+
+	// expected query performed by tested function
+	expectedQuery2 := "SELECT \\* FROM table_name"
+
+	mock.ExpectQuery(expectedQuery2).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	err := storage.StoreTableIntoFile(context.Background(), "table_name", NoLimits, "json")
+	if err != nil {
+		t.Errorf("error was not expected %s", err)
+	}
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+
+	// check generated file
+	content, err := os.ReadFile("table_name.json")
+	if err != nil {
+		t.Errorf("error during reading file %s", err)
+	}
+	mustDeleteFile(t, "table_name.json")
+
+	expected := `[
+{"id":1,"text":"foo","valid":true},
+{"id":2,"text":"bar","valid":false}
+]
+`
+	assert.Equal(t, expected, string(content))
+}
+
+// check the function StoreTableIntoArchive
+func TestStoreTableIntoArchive(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// prepare mocked result for SQL query
+	column1 := sqlmock.NewColumn("id").OfType("INT4", int64(0))
+	column2 := sqlmock.NewColumn("text").OfType("VARCHAR", "")
+
+	rows := mock.NewRowsWithColumnDefinition(column1, column2)
+	rows.AddRow(1, "foo")
+
+	// expected queries performed by tested function
+	mock.ExpectQuery(readColumnTypesQuery).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT \\* FROM table_name").WillReturnRows(rows)
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	workbook := main.NewWorkbook()
+	err := storage.StoreTableIntoArchive(context.Background(), workbook, "table_name", NoLimits)
+	assert.NoError(t, err)
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+
+	// table needs to be stored as sheet
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, workbook.Write(buffer))
+	assert.True(t, bytes.HasPrefix(buffer.Bytes(), []byte("PK")))
+}
+
+// check the function StoreTableIntoFile for unknown output format
+func TestStoreTableIntoFileUnknownFormat(t *testing.T) {
+	// prepare new mocked connection to database
+	connection, mock := mustCreateMockConnection(t)
+
+	// no query is expected to be performed
+	mock.ExpectClose()
+
+	// prepare connection to mocked database
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
+
+	// call the tested method
+	err := storage.StoreTableIntoFile(context.Background(), "table_name", NoLimits, "xml")
+	assert.EqualError(t, err, "Unknown output format: xml")
+
+	// connection to mocked DB needs to be closed properly
+	checkConnectionClose(t, connection)
+
+	// check if all expectations were met
+	checkAllExpectations(t, mock)
+}
+
 // check the function ReadDisabledRules
 func TestReadDisabledRules(t *testing.T) {
 	// prepare new mocked connection to database
@@ -1034,7 +1275,7 @@ func TestReadDisabledRules(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	results, err := storage.ReadDisabledRules()
+	results, err := storage.ReadDisabledRules(context.Background())
 	if err != nil {
 		t.Errorf("error was not expected %s", err)
 	}
@@ -1075,7 +1316,7 @@ func TestReadDisabledRulesOnError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadDisabledRules()
+	_, err := storage.ReadDisabledRules(context.Background())
 
 	if err != mockedError {
 		t.Errorf("different error was returned: %v", err)
@@ -1108,7 +1349,7 @@ func TestReadDisabledRulesScanError(t *testing.T) {
 	storage := main.NewFromConnection(connection, main.DBDriverPostgres, &testConfig)
 
 	// call the tested method
-	_, err := storage.ReadDisabledRules()
+	_, err := storage.ReadDisabledRules(context.Background())
 	if err == nil {
 		t.Errorf("error was expected")
 	}
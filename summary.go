@@ -0,0 +1,745 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/summary.html
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Stages of the export that are measured separately
+const (
+	stageDiscovery  = "discovery"
+	stageMetadata   = "metadata"
+	stageReports    = "reports"
+	stageDataRead   = "data read"
+	stageConversion = "conversion"
+	stageUpload     = "upload"
+)
+
+// wholeRun is a label used for the duration of whole run in summary table
+const wholeRun = "whole run"
+
+// stages contains all measured stages in the order in which they are
+// displayed in summary table
+var stages = []string{
+	stageDiscovery,
+	stageMetadata,
+	stageReports,
+	stageDataRead,
+	stageConversion,
+	stageUpload,
+}
+
+// Summary represents summary of one export run. It contains the duration of
+// the whole run and the time spent in individual stages, so it is possible to
+// tell whether the slowness is on database side or on object store side.
+//
+// All methods can be called on nil pointer - in this case they do nothing.
+// Methods are safe to be called from several goroutines.
+type Summary struct {
+	mutex           sync.Mutex
+	started         time.Time
+	finished        time.Time
+	durations       map[string]time.Duration
+	exportedTables  int
+	exportedRows    int
+	rejectedRows    int
+	tables          map[TableName]TableSummary
+	warnings        []Warning
+	hooksSucceeded  int
+	hooksFailed     int
+	mismatches      []RowCountMismatch
+	failedTables    []FailedTable
+	records         map[TableName]int
+	disabledRules   []DisabledRuleInfo
+	exitStatus      *RunExitStatus
+	throughputs     map[TableName]TableThroughput
+	recommendations []Recommendation
+	presignedURLs   []PresignedURL
+}
+
+// TableRecords contains number of records stored in one table, it is read
+// when metadata are exported
+type TableRecords struct {
+	Table   TableName
+	Records int
+}
+
+// TableSummary contains columns and number of rows exported from one table
+type TableSummary struct {
+	Name    TableName `json:"name"`
+	Columns []string  `json:"columns"`
+	Rows    int       `json:"rows"`
+}
+
+// TableThroughput contains number of rows exported from one table and time
+// spent by reading, converting and storing them
+type TableThroughput struct {
+	Table    TableName
+	Rows     int
+	Duration time.Duration
+}
+
+// RowsPerSecond method returns number of rows exported from the table in
+// one second
+func (throughput TableThroughput) RowsPerSecond() float64 {
+	if throughput.Duration <= 0 {
+		return 0.0
+	}
+	return float64(throughput.Rows) / throughput.Duration.Seconds()
+}
+
+// Warning represents warning logged during the run together with number of
+// times it has been logged
+type Warning struct {
+	Message string
+	Count   int
+}
+
+// NewSummary function constructs new summary and starts measuring the
+// duration of whole run
+func NewSummary() *Summary {
+	return &Summary{
+		started:     time.Now(),
+		durations:   make(map[string]time.Duration, len(stages)),
+		tables:      make(map[TableName]TableSummary),
+		records:     make(map[TableName]int),
+		throughputs: make(map[TableName]TableThroughput),
+	}
+}
+
+// AddDuration method adds given duration to the selected stage
+func (summary *Summary) AddDuration(stage string, duration time.Duration) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.durations[stage] += duration
+}
+
+// MeasureStage method starts measuring the selected stage. Returned function
+// needs to be called when the stage is finished, usually via defer:
+//
+//	defer summary.MeasureStage(stageMetadata)()
+func (summary *Summary) MeasureStage(stage string) func() {
+	started := time.Now()
+	return func() {
+		summary.AddDuration(stage, time.Since(started))
+	}
+}
+
+// Duration method returns the time spent in selected stage
+func (summary *Summary) Duration(stage string) time.Duration {
+	if summary == nil {
+		return 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.durations[stage]
+}
+
+// AddExportedTable method records that one table has been exported
+func (summary *Summary) AddExportedTable() {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.exportedTables++
+}
+
+// AddExportedRows method records that given number of rows has been exported
+func (summary *Summary) AddExportedRows(rows int) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.exportedRows += rows
+}
+
+// AddRejectedRows method records that given number of rows has been
+// rejected (quarantined) instead of exported
+func (summary *Summary) AddRejectedRows(rows int) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.rejectedRows += rows
+}
+
+// RecordTable method records columns and number of rows exported from given
+// table
+func (summary *Summary) RecordTable(tableName TableName, columns []string, rows int) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.tables[tableName] = TableSummary{
+		Name:    tableName,
+		Columns: columns,
+		Rows:    rows,
+	}
+}
+
+// Tables method returns summaries of all exported tables ordered by table
+// name
+func (summary *Summary) Tables() []TableSummary {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	tables := make([]TableSummary, 0, len(summary.tables))
+	for _, table := range summary.tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+	return tables
+}
+
+// RecordTableThroughput method records number of rows exported from given
+// table and time spent by its export
+func (summary *Summary) RecordTableThroughput(tableName TableName, rows int, duration time.Duration) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.throughputs[tableName] = TableThroughput{
+		Table:    tableName,
+		Rows:     rows,
+		Duration: duration,
+	}
+}
+
+// Throughputs method returns throughputs of all exported tables ordered by
+// table name
+func (summary *Summary) Throughputs() []TableThroughput {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	throughputs := make([]TableThroughput, 0, len(summary.throughputs))
+	for _, throughput := range summary.throughputs {
+		throughputs = append(throughputs, throughput)
+	}
+	sort.Slice(throughputs, func(i, j int) bool {
+		return throughputs[i].Table < throughputs[j].Table
+	})
+	return throughputs
+}
+
+// SetRecommendations method records tuning recommendations computed when
+// the run is finished
+func (summary *Summary) SetRecommendations(recommendations []Recommendation) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.recommendations = append([]Recommendation(nil), recommendations...)
+}
+
+// Recommendations method returns tuning recommendations of the run
+func (summary *Summary) Recommendations() []Recommendation {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return append([]Recommendation(nil), summary.recommendations...)
+}
+
+// AddPresignedURL method records presigned URL generated for exported object
+func (summary *Summary) AddPresignedURL(url PresignedURL) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.presignedURLs = append(summary.presignedURLs, url)
+}
+
+// PresignedURLs method returns presigned URLs of all exported objects in
+// order they have been generated
+func (summary *Summary) PresignedURLs() []PresignedURL {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return append([]PresignedURL(nil), summary.presignedURLs...)
+}
+
+// AddWarning method records warning logged during the run, the same
+// warnings are recorded only once
+func (summary *Summary) AddWarning(message string) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	for i := range summary.warnings {
+		if summary.warnings[i].Message == message {
+			summary.warnings[i].Count++
+			return
+		}
+	}
+	summary.warnings = append(summary.warnings, Warning{Message: message, Count: 1})
+}
+
+// AddHookInvocation method records result of one invocation of
+// post-processing hook
+func (summary *Summary) AddHookInvocation(succeeded bool) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	if succeeded {
+		summary.hooksSucceeded++
+	} else {
+		summary.hooksFailed++
+	}
+}
+
+// HookInvocations method returns the number of succeeded and failed
+// invocations of post-processing hooks
+func (summary *Summary) HookInvocations() (succeeded, failed int) {
+	if summary == nil {
+		return 0, 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.hooksSucceeded, summary.hooksFailed
+}
+
+// AddRowCountMismatch method records table with number of exported rows
+// different from number of its records
+func (summary *Summary) AddRowCountMismatch(mismatch RowCountMismatch) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.mismatches = append(summary.mismatches, mismatch)
+}
+
+// RowCountMismatches method returns all tables with number of exported rows
+// different from number of their records
+func (summary *Summary) RowCountMismatches() []RowCountMismatch {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return append([]RowCountMismatch(nil), summary.mismatches...)
+}
+
+// AddFailedTable method records table that has not been exported because
+// of error
+func (summary *Summary) AddFailedTable(failure FailedTable) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.failedTables = append(summary.failedTables, failure)
+}
+
+// FailedTables method returns all tables that have not been exported because
+// of error
+func (summary *Summary) FailedTables() []FailedTable {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return append([]FailedTable(nil), summary.failedTables...)
+}
+
+// RecordTableRecords method records number of records stored in given table
+func (summary *Summary) RecordTableRecords(tableName TableName, records int) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.records[tableName] = records
+}
+
+// TableRecords method returns numbers of records of all tables with exported
+// metadata ordered by table name
+func (summary *Summary) TableRecords() []TableRecords {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	records := make([]TableRecords, 0, len(summary.records))
+	for tableName, count := range summary.records {
+		records = append(records, TableRecords{Table: tableName, Records: count})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Table < records[j].Table
+	})
+	return records
+}
+
+// RecordDisabledRules method records rules disabled by more users exported
+// by the run
+func (summary *Summary) RecordDisabledRules(disabledRules []DisabledRuleInfo) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	// empty list is distinguished from rules that have not been exported
+	summary.disabledRules = make([]DisabledRuleInfo, len(disabledRules))
+	copy(summary.disabledRules, disabledRules)
+}
+
+// DisabledRules method returns rules disabled by more users exported by the
+// run, nil is returned when they have not been exported
+func (summary *Summary) DisabledRules() []DisabledRuleInfo {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.disabledRules
+}
+
+// Warnings method returns all warnings in order they have been logged
+func (summary *Summary) Warnings() []Warning {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return append([]Warning(nil), summary.warnings...)
+}
+
+// WarningHook method returns logger hook that records all logged warnings
+// into summary
+func (summary *Summary) WarningHook() zerolog.Hook {
+	return zerolog.HookFunc(func(_ *zerolog.Event, level zerolog.Level, message string) {
+		if level == zerolog.WarnLevel {
+			summary.AddWarning(message)
+		}
+	})
+}
+
+// ExportedTables method returns number of exported tables
+func (summary *Summary) ExportedTables() int {
+	if summary == nil {
+		return 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.exportedTables
+}
+
+// ExportedRows method returns number of rows in all exported tables
+func (summary *Summary) ExportedRows() int {
+	if summary == nil {
+		return 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.exportedRows
+}
+
+// RejectedRows method returns number of rows rejected in all exported tables
+func (summary *Summary) RejectedRows() int {
+	if summary == nil {
+		return 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.rejectedRows
+}
+
+// Finish method stops measuring the duration of whole run
+func (summary *Summary) Finish() {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.finished = time.Now()
+}
+
+// SetExitStatus method records exit status of the run together with error
+// describing its reason
+func (summary *Summary) SetExitStatus(code int, err error) {
+	if summary == nil {
+		return
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.exitStatus = newRunExitStatus(code, err)
+}
+
+// ExitStatus method returns exit status of the run, nil is returned when it
+// has not been recorded yet
+func (summary *Summary) ExitStatus() *RunExitStatus {
+	if summary == nil {
+		return nil
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.exitStatus
+}
+
+// Started method returns time when the run has been started
+func (summary *Summary) Started() time.Time {
+	if summary == nil {
+		return time.Time{}
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.started
+}
+
+// Finished method returns time when the run has been finished
+func (summary *Summary) Finished() time.Time {
+	if summary == nil {
+		return time.Time{}
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.finished
+}
+
+// TotalDuration method returns the duration of whole run. If the run has not
+// been finished yet, the duration measured so far is returned.
+func (summary *Summary) TotalDuration() time.Duration {
+	if summary == nil {
+		return 0
+	}
+
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	if summary.finished.IsZero() {
+		return time.Since(summary.started)
+	}
+	return summary.finished.Sub(summary.started)
+}
+
+// share is helper function to compute the share of stage duration on whole
+// run duration in percents
+func share(duration, total time.Duration) float64 {
+	if total <= 0 {
+		return 0.0
+	}
+	return 100.0 * float64(duration) / float64(total)
+}
+
+// printSummary function prints summary table with durations of all stages
+// into given writer
+func printSummary(writer io.Writer, summary *Summary) error {
+	total := summary.TotalDuration()
+
+	// columns are aligned by tab writer
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	_, err := fmt.Fprintln(tw, "Stage\tDuration\tShare\t")
+	if err != nil {
+		return err
+	}
+
+	for _, stage := range stages {
+		duration := summary.Duration(stage)
+		_, err := fmt.Fprintf(tw, "%s\t%v\t%.1f%%\t\n",
+			stage, duration.Round(time.Millisecond), share(duration, total))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(tw, "%s\t%v\t%.1f%%\t\n",
+		wholeRun, total.Round(time.Millisecond), share(total, total))
+	if err != nil {
+		return err
+	}
+
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer, "Exported tables: %d, exported rows: %d\n",
+		summary.ExportedTables(), summary.ExportedRows())
+	if err != nil {
+		return err
+	}
+
+	// rejected rows are reported only when there are any
+	if summary.RejectedRows() > 0 {
+		_, err = fmt.Fprintf(writer, "Rejected rows: %d\n", summary.RejectedRows())
+		if err != nil {
+			return err
+		}
+	}
+
+	// tables exported only partially are reported one by one
+	for _, mismatch := range summary.RowCountMismatches() {
+		_, err = fmt.Fprintf(writer, "Row count mismatch in table %s: %d expected, %d exported\n",
+			mismatch.Table, mismatch.Expected, mismatch.Exported)
+		if err != nil {
+			return err
+		}
+	}
+
+	// tables that have not been exported are reported one by one
+	for _, failure := range summary.FailedTables() {
+		_, err = fmt.Fprintf(writer, "Export of table %s failed: %s\n",
+			failure.Table, failure.Error)
+		if err != nil {
+			return err
+		}
+	}
+
+	// hooks are reported only when they have been invoked
+	if succeeded, failed := summary.HookInvocations(); succeeded+failed > 0 {
+		_, err = fmt.Fprintf(writer, "Post-processing hooks: %d succeeded, %d failed\n",
+			succeeded, failed)
+		if err != nil {
+			return err
+		}
+	}
+
+	// settings are recommended only when the run revealed a bottleneck
+	recommendations := summary.Recommendations()
+	if len(recommendations) > 0 {
+		_, err = fmt.Fprintln(writer, "Tuning recommendations:")
+		if err != nil {
+			return err
+		}
+	}
+	for _, recommendation := range recommendations {
+		_, err = fmt.Fprintf(writer, "  %s\n", recommendation)
+		if err != nil {
+			return err
+		}
+	}
+
+	// exported objects can be shared by presigned URLs
+	urls := summary.PresignedURLs()
+	if len(urls) > 0 {
+		_, err = fmt.Fprintf(writer, "Presigned URLs (valid until %s):\n",
+			urls[0].Expires.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+	for _, url := range urls {
+		_, err = fmt.Fprintf(writer, "  %s: %s\n", url.Location, url.URL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/summary_test.html
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestSummaryAddDuration checks that durations are accumulated per stage
+func TestSummaryAddDuration(t *testing.T) {
+	summary := main.NewSummary()
+
+	summary.AddDuration(main.StageDataRead, 2*time.Second)
+	summary.AddDuration(main.StageDataRead, 3*time.Second)
+	summary.AddDuration(main.StageConversion, time.Second)
+
+	assert.Equal(t, 5*time.Second, summary.Duration(main.StageDataRead))
+	assert.Equal(t, time.Second, summary.Duration(main.StageConversion))
+	assert.Equal(t, time.Duration(0), summary.Duration(main.StageDiscovery))
+}
+
+// TestSummaryMeasureStage checks the method MeasureStage
+func TestSummaryMeasureStage(t *testing.T) {
+	summary := main.NewSummary()
+
+	stopMeasuring := summary.MeasureStage(main.StageDiscovery)
+	time.Sleep(10 * time.Millisecond)
+	stopMeasuring()
+
+	assert.GreaterOrEqual(t, summary.Duration(main.StageDiscovery), 10*time.Millisecond)
+}
+
+// TestSummaryFinish checks that the duration of whole run is frozen when the
+// run is finished
+func TestSummaryFinish(t *testing.T) {
+	summary := main.NewSummary()
+	summary.Finish()
+
+	total := summary.TotalDuration()
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, total, summary.TotalDuration())
+}
+
+// TestSummaryExportedTables checks that exported tables and rows are counted
+func TestSummaryExportedTables(t *testing.T) {
+	summary := main.NewSummary()
+
+	summary.AddExportedRows(10)
+	summary.AddExportedTable()
+	summary.AddExportedRows(5)
+	summary.AddExportedTable()
+
+	assert.Equal(t, 2, summary.ExportedTables())
+	assert.Equal(t, 15, summary.ExportedRows())
+}
+
+// TestSummaryTables checks that exported tables are recorded and returned
+// ordered by name
+func TestSummaryTables(t *testing.T) {
+	summary := main.NewSummary()
+
+	summary.RecordTable("rule_hit", []string{"org_id"}, 5)
+	summary.RecordTable("report", []string{"id", "report"}, 10)
+
+	assert.Equal(t, []main.TableSummary{
+		{Name: "report", Columns: []string{"id", "report"}, Rows: 10},
+		{Name: "rule_hit", Columns: []string{"org_id"}, Rows: 5},
+	}, summary.Tables())
+}
+
+// TestSummaryWarnings checks that warnings are recorded once in order they
+// have been logged
+func TestSummaryWarnings(t *testing.T) {
+	summary := main.NewSummary()
+	logger := zerolog.New(io.Discard).Hook(summary.WarningHook())
+
+	logger.Warn().Msg("first")
+	logger.Info().Msg("not a warning")
+	logger.Warn().Msg("second")
+	logger.Warn().Msg("first")
+
+	assert.Equal(t, []main.Warning{
+		{Message: "first", Count: 2},
+		{Message: "second", Count: 1},
+	}, summary.Warnings())
+}
+
+// TestNilSummary checks that all methods can be called on nil summary
+func TestNilSummary(t *testing.T) {
+	var summary *main.Summary
+
+	summary.AddDuration(main.StageDataRead, time.Second)
+	summary.MeasureStage(main.StageDataRead)()
+	summary.AddExportedTable()
+	summary.AddExportedRows(1)
+	summary.RecordTable("report", nil, 1)
+	summary.AddWarning("warning")
+	summary.Finish()
+
+	assert.Equal(t, time.Duration(0), summary.Duration(main.StageDataRead))
+	assert.Equal(t, time.Duration(0), summary.TotalDuration())
+	assert.Equal(t, 0, summary.ExportedTables())
+	assert.Equal(t, 0, summary.ExportedRows())
+	assert.True(t, summary.Finished().IsZero())
+	assert.Empty(t, summary.Tables())
+	assert.Empty(t, summary.Warnings())
+}
+
+// TestPrintSummary checks the function printSummary
+func TestPrintSummary(t *testing.T) {
+	summary := main.NewSummary()
+	summary.AddDuration(main.StageDataRead, 1500*time.Millisecond)
+	summary.Finish()
+
+	buffer := new(bytes.Buffer)
+	err := main.PrintSummary(buffer, summary)
+	assert.NoError(t, err)
+
+	output := buffer.String()
+	for _, expected := range []string{
+		"Stage", "Duration", "Share",
+		"discovery", "metadata", "reports",
+		"data read", "conversion", "upload",
+		"whole run", "1.5s", "100.0%",
+		"Exported tables: 0, exported rows: 0",
+	} {
+		assert.Contains(t, output, expected)
+	}
+}
+
+// TestPrintSummaryRejectedRows checks that rejected rows are printed only
+// when some rows have been rejected
+func TestPrintSummaryRejectedRows(t *testing.T) {
+	summary := main.NewSummary()
+	summary.Finish()
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.NotContains(t, buffer.String(), "Rejected rows")
+
+	summary.AddRejectedRows(2)
+	summary.AddRejectedRows(1)
+	assert.Equal(t, 3, summary.RejectedRows())
+
+	buffer.Reset()
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.Contains(t, buffer.String(), "Rejected rows: 3\n")
+}
+
+// TestPrintSummaryHooks checks that invocations of post-processing hooks are
+// printed only when any hook has been invoked
+func TestPrintSummaryHooks(t *testing.T) {
+	summary := main.NewSummary()
+	summary.Finish()
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.NotContains(t, buffer.String(), "Post-processing hooks")
+
+	summary.AddHookInvocation(true)
+	summary.AddHookInvocation(true)
+	summary.AddHookInvocation(false)
+
+	buffer.Reset()
+	assert.NoError(t, main.PrintSummary(buffer, summary))
+	assert.Contains(t, buffer.String(), "Post-processing hooks: 2 succeeded, 1 failed\n")
+}
+
+// TestSummaryThroughputs checks that throughputs of tables are recorded and
+// returned in order of table names
+func TestSummaryThroughputs(t *testing.T) {
+	summary := main.NewSummary()
+	summary.RecordTableThroughput("rule_hit", 300, 3*time.Second)
+	summary.RecordTableThroughput("report", 100, 0)
+
+	throughputs := summary.Throughputs()
+	assert.Len(t, throughputs, 2)
+	assert.Equal(t, main.TableName("report"), throughputs[0].Table)
+	assert.Equal(t, 0.0, throughputs[0].RowsPerSecond())
+	assert.Equal(t, main.TableName("rule_hit"), throughputs[1].Table)
+	assert.Equal(t, 100.0, throughputs[1].RowsPerSecond())
+}
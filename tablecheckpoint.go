@@ -0,0 +1,405 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains resume of interrupted export in the middle of
+// table. Table read in chunks ordered by primary key is uploaded into S3 by
+// multipart upload with parts aligned to chunks. Checkpoint with ID of the
+// upload, uploaded parts and the last key exported is stored into S3 after
+// every part, so export resumed by -resume flag continues the upload with
+// rows following that key instead of reading the whole table again. Parts
+// are compressed separately, which is allowed by all supported codecs, and
+// only formats with one row per line can be continued this way.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tablecheckpoint.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointDirectory is directory under export prefix that contains
+// checkpoints of tables being uploaded
+const checkpointDirectory = "_checkpoints/"
+
+// checkpointContentType is content type of S3 object with checkpoint
+const checkpointContentType = "application/json"
+
+// messages
+const (
+	resumingTableUpload   = "Resuming upload of table after the last exported key"
+	abortingTableUpload   = "Upload of table interrupted by previous run is not resumed"
+	abortUploadFailed     = "Unable to abort upload of table"
+	removeCheckpointError = "Unable to remove checkpoint of table"
+	lastKeyMsg            = "last key"
+	uploadedPartsMsg      = "uploaded parts"
+)
+
+// CheckpointPart describes one part of multipart upload of table
+type CheckpointPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// TableCheckpoint contains state of multipart upload of one table. Rows up
+// to the last key (including) are stored in uploaded parts.
+type TableCheckpoint struct {
+	Object   string           `json:"object"`
+	UploadID string           `json:"upload_id"`
+	Parts    []CheckpointPart `json:"parts"`
+	LastKey  string           `json:"last_key,omitempty"`
+}
+
+// rowFlusher is implemented by table writers that buffer rows, buffered rows
+// are flushed at the end of every chunk
+type rowFlusher interface {
+	FlushRows() error
+}
+
+// resumableUpload is multipart upload of table with checkpoints. Data
+// written into the upload are buffered and uploaded as one part when a
+// chunk read from database ends and the buffer is large enough. Table that
+// is not read in chunks is uploaded in parts too, but it can't be resumed.
+//
+// All methods except Write can be called on nil pointer - in this case
+// they do nothing.
+type resumableUpload struct {
+	ctx            context.Context
+	session        *S3Session
+	core           minio.Core
+	bucketName     string
+	checkpointName string
+	compression    string
+	partSize       int
+	checkpoint     TableCheckpoint
+	stored         bool
+	resumed        bool
+	chunked        bool
+	writer         TableWriter
+	buffer         bytes.Buffer
+}
+
+// checkpointsApplicable method checks if table in given format can be
+// uploaded with checkpoints by given session. Checksums of whole object
+// can't be computed from parts uploaded by previous run and encrypted parts
+// can't be joined into one message.
+func (storage DBStorage) checkpointsApplicable(session *S3Session, format string) bool {
+	return storage.checkpointTables &&
+		(format == csvFormat || format == ndjsonFormat) &&
+		storage.encryption == nil &&
+		session.profile == nil &&
+		session.artifacts == nil &&
+		!session.checksums
+}
+
+// checkpointKey function converts key of the last exported row into form
+// stored in checkpoint
+func checkpointKey(key interface{}) string {
+	if bytes, ok := key.([]byte); ok {
+		return string(bytes)
+	}
+	return fmt.Sprint(key)
+}
+
+// readCheckpoint function reads checkpoint stored in given object, false is
+// returned when the checkpoint does not exist
+func readCheckpoint(ctx context.Context, session *S3Session,
+	bucketName, objectName string) (TableCheckpoint, bool, error) {
+	var checkpoint TableCheckpoint
+
+	object, err := session.client.GetObject(ctx, bucketName, objectName,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return checkpoint, false, err
+	}
+
+	defer func() {
+		_ = object.Close()
+	}()
+
+	err = json.NewDecoder(object).Decode(&checkpoint)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == noSuchKeyErrorCode {
+			return checkpoint, false, nil
+		}
+		return checkpoint, false, err
+	}
+
+	return checkpoint, true, nil
+}
+
+// openResumableUpload method continues upload recorded in checkpoint of
+// given table when export is resumed, new multipart upload is started
+// otherwise
+func (storage DBStorage) openResumableUpload(ctx context.Context,
+	session *S3Session, bucketName, objectName, checkpointName,
+	format string) (*resumableUpload, error) {
+	upload := &resumableUpload{
+		ctx:            ctx,
+		session:        session,
+		core:           minio.Core{Client: session.client},
+		bucketName:     bucketName,
+		checkpointName: checkpointName,
+		compression:    storage.compression,
+		partSize:       session.partSize(),
+	}
+
+	previous, found, err := readCheckpoint(ctx, session, bucketName, checkpointName)
+	if err != nil {
+		return nil, err
+	}
+	upload.stored = found
+
+	if found && storage.resumeTables && previous.Object == objectName && previous.LastKey != "" {
+		storage.logger.Info().
+			Str(lastKeyMsg, previous.LastKey).
+			Int(uploadedPartsMsg, len(previous.Parts)).
+			Msg(resumingTableUpload)
+		upload.checkpoint = previous
+		upload.resumed = true
+		return upload, nil
+	}
+
+	// parts uploaded by previous run are not needed anymore
+	if found {
+		storage.logger.Warn().Msg(abortingTableUpload)
+		err := upload.core.AbortMultipartUpload(ctx, bucketName, previous.Object,
+			previous.UploadID)
+		if err != nil {
+			storage.logger.Warn().Err(err).Msg(abortUploadFailed)
+		}
+	}
+
+	uploadID, err := upload.core.NewMultipartUpload(ctx, bucketName, objectName,
+		minio.PutObjectOptions{
+			ContentType:     contentType(format),
+			ContentEncoding: contentEncoding(storage.compression, storage.encryption),
+		})
+	if err != nil {
+		return nil, err
+	}
+	upload.checkpoint = TableCheckpoint{Object: objectName, UploadID: uploadID}
+
+	return upload, nil
+}
+
+// storeTableWithCheckpoints method stores specified table into S3 by
+// multipart upload with checkpoints, so its export can be resumed in the
+// middle. Parts uploaded so far are kept when the export fails after
+// checkpoint has been stored.
+func (storage DBStorage) storeTableWithCheckpoints(ctx context.Context,
+	session *S3Session, bucketName, prefix, objectName string,
+	tableName TableName, limit int, format string) error {
+	objectName += artifactExtension(storage.compression, storage.encryption)
+	checkpointName := setObjectPrefix(prefix, checkpointDirectory+string(tableName)+".json")
+
+	upload, err := storage.openResumableUpload(ctx, session, bucketName,
+		objectName, checkpointName, format)
+	if err != nil {
+		return err
+	}
+
+	// table is written into the upload and chunks read from database are
+	// recorded in its checkpoint
+	storage.upload = upload
+	err = storage.storeTableIntoWriter(ctx, upload, tableName, limit, format)
+	if err != nil {
+		// without checkpoint the upload can't be resumed
+		if upload.checkpoint.LastKey == "" {
+			upload.Abort()
+		}
+		return err
+	}
+
+	return upload.Complete()
+}
+
+// Write method adds data into the part being uploaded. Table that is not
+// read in chunks is uploaded whenever the part is large enough.
+func (upload *resumableUpload) Write(data []byte) (int, error) {
+	written, _ := upload.buffer.Write(data)
+
+	if !upload.chunked && upload.buffer.Len() >= upload.partSize {
+		return written, upload.uploadPart()
+	}
+	return written, nil
+}
+
+// SetWriter method sets table writer that writes into the upload, rows
+// buffered by the writer are flushed at the end of every chunk
+func (upload *resumableUpload) SetWriter(writer TableWriter) {
+	if upload == nil {
+		return
+	}
+	upload.writer = writer
+}
+
+// Resumed method checks if the upload continues upload of previous run
+func (upload *resumableUpload) Resumed() bool {
+	return upload != nil && upload.resumed
+}
+
+// StartKey method starts reading table in chunks, key of the last row
+// exported by previous run is returned for resumed upload
+func (upload *resumableUpload) StartKey() string {
+	if upload == nil {
+		return ""
+	}
+	upload.chunked = true
+	return upload.checkpoint.LastKey
+}
+
+// ChunkRead method is called when all rows of one chunk have been written
+// into the upload. Part is uploaded when it is large enough and checkpoint
+// with given key of the last row is stored.
+func (upload *resumableUpload) ChunkRead(lastKey interface{}) error {
+	if upload == nil || lastKey == nil {
+		return nil
+	}
+
+	if flusher, ok := upload.writer.(rowFlusher); ok {
+		err := flusher.FlushRows()
+		if err != nil {
+			return err
+		}
+	}
+
+	if upload.buffer.Len() < upload.partSize {
+		return nil
+	}
+
+	err := upload.uploadPart()
+	if err != nil {
+		return err
+	}
+
+	upload.checkpoint.LastKey = checkpointKey(lastKey)
+	return upload.storeCheckpoint()
+}
+
+// uploadPart method uploads buffered data as next part
+func (upload *resumableUpload) uploadPart() error {
+	data, err := compressData(upload.compression, nil, upload.buffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	number := len(upload.checkpoint.Parts) + 1
+	objectName := upload.checkpoint.Object
+
+	var part minio.ObjectPart
+	err = upload.session.retryOperation(upload.ctx, s3PutOperation,
+		s3ArtifactLocation(upload.bucketName, objectName),
+		func(ctx context.Context) error {
+			var err error
+			part, err = upload.core.PutObjectPart(ctx, upload.bucketName,
+				objectName, upload.checkpoint.UploadID, number,
+				bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	upload.session.instrumentation().AddCounter(metricS3UploadedBytes,
+		MetricLabels{metricLabelOperation: s3PutOperation}, float64(len(data)))
+
+	upload.checkpoint.Parts = append(upload.checkpoint.Parts,
+		CheckpointPart{Number: number, ETag: part.ETag})
+	upload.buffer.Reset()
+	return nil
+}
+
+// storeCheckpoint method stores current state of the upload
+func (upload *resumableUpload) storeCheckpoint() error {
+	data, err := json.Marshal(upload.checkpoint)
+	if err != nil {
+		return err
+	}
+
+	err = upload.session.retryOperation(upload.ctx, s3PutOperation,
+		s3ArtifactLocation(upload.bucketName, upload.checkpointName),
+		func(ctx context.Context) error {
+			_, err := upload.core.Client.PutObject(ctx, upload.bucketName,
+				upload.checkpointName, bytes.NewReader(data), int64(len(data)),
+				minio.PutObjectOptions{ContentType: checkpointContentType})
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	upload.stored = true
+	return nil
+}
+
+// Complete method uploads the rest of data, joins all parts into the table
+// object and removes checkpoint of the table
+func (upload *resumableUpload) Complete() error {
+	// empty table is uploaded as one empty part
+	if upload.buffer.Len() > 0 || len(upload.checkpoint.Parts) == 0 {
+		err := upload.uploadPart()
+		if err != nil {
+			return err
+		}
+	}
+
+	parts := make([]minio.CompletePart, 0, len(upload.checkpoint.Parts))
+	for _, part := range upload.checkpoint.Parts {
+		parts = append(parts, minio.CompletePart{PartNumber: part.Number, ETag: part.ETag})
+	}
+
+	objectName := upload.checkpoint.Object
+	err := upload.session.retryOperation(upload.ctx, s3PutOperation,
+		s3ArtifactLocation(upload.bucketName, objectName),
+		func(ctx context.Context) error {
+			_, err := upload.core.CompleteMultipartUpload(ctx, upload.bucketName,
+				objectName, upload.checkpoint.UploadID, parts, minio.PutObjectOptions{})
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	// table has been exported completely, checkpoint is not needed
+	if upload.stored {
+		err := upload.core.Client.RemoveObject(upload.ctx, upload.bucketName,
+			upload.checkpointName, minio.RemoveObjectOptions{})
+		if err != nil {
+			log.Warn().Err(err).Msg(removeCheckpointError)
+		}
+	}
+	return nil
+}
+
+// Abort method aborts the upload, parts uploaded so far are removed
+func (upload *resumableUpload) Abort() {
+	err := upload.core.AbortMultipartUpload(upload.ctx, upload.bucketName,
+		upload.checkpoint.Object, upload.checkpoint.UploadID)
+	if err != nil {
+		log.Warn().Err(err).Msg(abortUploadFailed)
+	}
+}
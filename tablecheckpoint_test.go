@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tablecheckpoint_test.html
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+const checkpointObject = "/bucket/prefix/_checkpoints/table_name.json"
+
+// expectChunkedTable function sets expectations of reading column types and
+// primary key of table read in chunks
+func expectChunkedTable(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT \* FROM table_name LIMIT 1`).
+		WillReturnRows(rangeRows(mock))
+	keyRows := sqlmock.NewRows([]string{"attname", "format_type"}).AddRow("id", "integer")
+	mock.ExpectQuery(readPrimaryKeyQuery).WithArgs("table_name").WillReturnRows(keyRows)
+}
+
+// csvRows helper function returns rows with given IDs formatted as CSV
+func csvRows(first, last int) string {
+	var rows strings.Builder
+	for _, id := range sequence(first, last) {
+		fmt.Fprintf(&rows, "%d,row\n", id)
+	}
+	return rows.String()
+}
+
+// TestStoreTableWithCheckpoints checks that table interrupted in the middle
+// is continued after the last key recorded in checkpoint
+func TestStoreTableWithCheckpoints(t *testing.T) {
+	// every chunk is uploaded as one part
+	s3, session := startFakeS3WithConfiguration(t, main.S3Configuration{PartSize: 1})
+	ctx := context.Background()
+
+	// the first run is interrupted by failed read of the third chunk
+	connection, mock := mustCreateMockConnection(t)
+	expectChunkedTable(mock)
+	mock.ExpectQuery(`SELECT \* FROM table_name ORDER BY "id" LIMIT 100$`).
+		WillReturnRows(rangeRows(mock, sequence(1, 100)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 100$`).
+		WithArgs(100).WillReturnRows(rangeRows(mock, sequence(101, 200)...))
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 100$`).
+		WithArgs(200).WillReturnError(errors.New("database is gone"))
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+	main.SetTableCheckpoints(storage, true, false)
+
+	err := storage.StoreTable(ctx, session, "bucket", "prefix", "table_name",
+		NoLimits, "csv")
+	assert.EqualError(t, err, "database is gone")
+	checkAllExpectations(t, mock)
+
+	var checkpoint main.TableCheckpoint
+	assert.NoError(t, json.Unmarshal(s3.objects[checkpointObject], &checkpoint))
+	assert.Equal(t, "prefix/table_name.csv", checkpoint.Object)
+	assert.Equal(t, "200", checkpoint.LastKey)
+	assert.Len(t, checkpoint.Parts, 2)
+	assert.NotContains(t, s3.objects, "/bucket/prefix/table_name.csv")
+
+	// resumed run reads only rows following the last key
+	connection, mock = mustCreateMockConnection(t)
+	expectChunkedTable(mock)
+	mock.ExpectQuery(`SELECT \* FROM table_name WHERE "id" > \$1 ORDER BY "id" LIMIT 100$`).
+		WithArgs("200").WillReturnRows(rangeRows(mock, 201, 202))
+
+	storage = main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+	main.SetTableCheckpoints(storage, true, true)
+
+	err = storage.StoreTable(ctx, session, "bucket", "prefix", "table_name",
+		NoLimits, "csv")
+	assert.NoError(t, err)
+	checkAllExpectations(t, mock)
+
+	assert.Equal(t, "id,text\n"+csvRows(1, 202),
+		string(s3.objects["/bucket/prefix/table_name.csv"]))
+	assert.NotContains(t, s3.objects, checkpointObject)
+	assert.Empty(t, s3.uploads)
+}
+
+// TestStoreTableWithCheckpointsNotResumed checks that upload interrupted by
+// previous run is aborted when the export is not resumed
+func TestStoreTableWithCheckpointsNotResumed(t *testing.T) {
+	s3, session := startFakeS3WithConfiguration(t, main.S3Configuration{PartSize: 1})
+	ctx := context.Background()
+
+	checkpoint, err := json.Marshal(main.TableCheckpoint{
+		Object:   "prefix/table_name.csv",
+		UploadID: "interrupted",
+		Parts:    []main.CheckpointPart{{Number: 1, ETag: "part1"}},
+		LastKey:  "100",
+	})
+	assert.NoError(t, err)
+	s3.objects[checkpointObject] = checkpoint
+	s3.uploads["interrupted"] = map[int][]byte{1: []byte(csvRows(1, 100))}
+
+	connection, mock := mustCreateMockConnection(t)
+	expectChunkedTable(mock)
+	mock.ExpectQuery(`SELECT \* FROM table_name ORDER BY "id" LIMIT 100$`).
+		WillReturnRows(rangeRows(mock, 1, 2))
+
+	storage := main.NewFromConnection(connection, main.DBDriverPostgres, chunkedConfig(100))
+	main.SetTableCheckpoints(storage, true, false)
+
+	err = storage.StoreTable(ctx, session, "bucket", "prefix", "table_name",
+		NoLimits, "csv")
+	assert.NoError(t, err)
+	checkAllExpectations(t, mock)
+
+	assert.Equal(t, "id,text\n1,row\n2,row\n",
+		string(s3.objects["/bucket/prefix/table_name.csv"]))
+	assert.NotContains(t, s3.objects, checkpointObject)
+	assert.Empty(t, s3.uploads)
+}
@@ -0,0 +1,355 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains selection of exported tables. Tables to be
+// exported and tables excluded from export can be selected on command line
+// (-tables and -exclude-tables flags) or in configuration file. Each item can
+// be exact table name, glob pattern (for example rule_*) or regular
+// expression enclosed in slashes (for example /^rule_(hit|toggle)$/). List of
+// tables read from database is filtered before the export starts. Tables to be
+// exported can be read from file (-tables-file flag) or from standard input
+// (-tables=-) too, one item per line.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tablefilter.html
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// characters that make table name glob pattern
+const globCharacters = "*?["
+
+// readFromStdin is value of -tables and -tables-file flags that selects
+// reading of list of tables from standard input
+const readFromStdin = "-"
+
+// prefix of comment lines in file with list of tables
+const tableListComment = "#"
+
+// messages
+const (
+	tablesSelected        = "Tables selected for export"
+	selectedTableNotFound = "No table selected for export by"
+	selectedTablesMsg     = "selected"
+	tablePatternMsg       = "pattern"
+	wrongTablePattern     = "wrong table pattern %s: %v"
+	tablesFileConflict    = "-tables and -tables-file can't be used together"
+	onlyReportsExported   = "Only reports are exported, no table is selected"
+)
+
+// tableMatcher matches table names by exact name, glob pattern or regular
+// expression
+type tableMatcher struct {
+	pattern string
+	glob    bool
+	regexp  *regexp.Regexp
+}
+
+// newTableMatcher function constructs matcher for given item of table list
+func newTableMatcher(pattern string) (tableMatcher, error) {
+	matcher := tableMatcher{pattern: pattern}
+
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		compiled, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return matcher, fmt.Errorf(wrongTablePattern, pattern, err)
+		}
+		matcher.regexp = compiled
+	case strings.ContainsAny(pattern, globCharacters):
+		// syntax of glob is checked by matching any name
+		if _, err := path.Match(pattern, ""); err != nil {
+			return matcher, fmt.Errorf(wrongTablePattern, pattern, err)
+		}
+		matcher.glob = true
+	}
+
+	return matcher, nil
+}
+
+// Matches method checks if given table name is matched
+func (matcher tableMatcher) Matches(tableName TableName) bool {
+	switch {
+	case matcher.regexp != nil:
+		return matcher.regexp.MatchString(string(tableName))
+	case matcher.glob:
+		// syntax has been checked already
+		matched, _ := path.Match(matcher.pattern, string(tableName))
+		return matched
+	default:
+		return matcher.pattern == string(tableName)
+	}
+}
+
+// matchesAnyOf method checks if any of given tables is matched
+func (matcher tableMatcher) matchesAnyOf(tableNames []TableName) bool {
+	for _, tableName := range tableNames {
+		if matcher.Matches(tableName) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTableMatchers function constructs matchers for all items of table list
+func newTableMatchers(patterns []string) ([]tableMatcher, error) {
+	matchers := make([]tableMatcher, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		matcher, err := newTableMatcher(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	return matchers, nil
+}
+
+// matchesAny function checks if any of given matchers matches selected table
+func matchesAny(matchers []tableMatcher, tableName TableName) bool {
+	for _, matcher := range matchers {
+		if matcher.Matches(tableName) {
+			return true
+		}
+	}
+	return false
+}
+
+// TableFilter selects tables to be exported. When list of included tables
+// is not empty, only tables matched by this list are exported; excluded
+// tables are never exported.
+//
+// All methods can be called on nil pointer - in this case all tables are
+// selected.
+type TableFilter struct {
+	included []tableMatcher
+	excluded []tableMatcher
+
+	// required contains tables (names or patterns) selected tables need
+	// to be matched by, any table is selected when it is empty
+	required []tableMatcher
+}
+
+// NewTableFilter function constructs filter with given lists of included and
+// excluded tables (names or patterns). Nil is returned when both lists are
+// empty.
+func NewTableFilter(included, excluded []string) (*TableFilter, error) {
+	if len(included) == 0 && len(excluded) == 0 {
+		return nil, nil
+	}
+
+	includedMatchers, err := newTableMatchers(included)
+	if err != nil {
+		return nil, err
+	}
+
+	excludedMatchers, err := newTableMatchers(excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TableFilter{
+		included: includedMatchers,
+		excluded: excludedMatchers,
+	}, nil
+}
+
+// parseTableList function splits comma-separated list of tables, spaces
+// around table names and empty items are ignored
+func parseTableList(input string) []string {
+	var tables []string
+
+	for _, table := range strings.Split(input, ",") {
+		table = strings.TrimSpace(table)
+		if table != "" {
+			tables = append(tables, table)
+		}
+	}
+
+	return tables
+}
+
+// readTableList function reads list of tables from given reader. Each line
+// contains one table name or pattern, so regular expressions can contain
+// commas. Empty lines and lines starting with # are ignored.
+func readTableList(reader io.Reader) ([]string, error) {
+	var tables []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		table := strings.TrimSpace(scanner.Text())
+		if table != "" && !strings.HasPrefix(table, tableListComment) {
+			tables = append(tables, table)
+		}
+	}
+
+	return tables, scanner.Err()
+}
+
+// readTableListFile function reads list of tables from file with given name
+// or from given standard input
+func readTableListFile(fileName string, stdin io.Reader) ([]string, error) {
+	if fileName == readFromStdin {
+		return readTableList(stdin)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return readTableList(file)
+}
+
+// selectedTablesFromFlags function returns list of tables selected on
+// command line by -tables or -tables-file flag. List can be read from
+// standard input when the flag is set to -.
+func selectedTablesFromFlags(cliFlags CliFlags, stdin io.Reader) ([]string, error) {
+	switch {
+	case cliFlags.TablesFile != "" && cliFlags.Tables != "":
+		return nil, errors.New(tablesFileConflict)
+	case cliFlags.TablesFile != "":
+		return readTableListFile(cliFlags.TablesFile, stdin)
+	case strings.TrimSpace(cliFlags.Tables) == readFromStdin:
+		return readTableList(stdin)
+	default:
+		return parseTableList(cliFlags.Tables), nil
+	}
+}
+
+// Within method returns filter that selects only tables selected by this
+// filter that are matched by any of given names or patterns too
+func (filter *TableFilter) Within(tables []string) (*TableFilter, error) {
+	matchers, err := newTableMatchers(tables)
+	if err != nil {
+		return nil, err
+	}
+
+	within := &TableFilter{}
+	if filter != nil {
+		*within = *filter
+	}
+	within.required = append(append([]tableMatcher{}, within.required...), matchers...)
+
+	return within, nil
+}
+
+// Without method returns filter that selects only tables selected by this
+// filter that are not matched by any of given names or patterns
+func (filter *TableFilter) Without(tables []string) (*TableFilter, error) {
+	matchers, err := newTableMatchers(tables)
+	if err != nil {
+		return nil, err
+	}
+
+	without := &TableFilter{}
+	if filter != nil {
+		*without = *filter
+	}
+	without.excluded = append(append([]tableMatcher{}, without.excluded...), matchers...)
+
+	return without, nil
+}
+
+// Selected method checks if given table needs to be exported
+func (filter *TableFilter) Selected(tableName TableName) bool {
+	if filter == nil {
+		return true
+	}
+
+	if matchesAny(filter.excluded, tableName) {
+		return false
+	}
+
+	if len(filter.required) > 0 && !matchesAny(filter.required, tableName) {
+		return false
+	}
+
+	return len(filter.included) == 0 || matchesAny(filter.included, tableName)
+}
+
+// Filter method returns selected tables from given list, order of tables is
+// kept. Items of included list that don't match any table from given list
+// are returned too.
+func (filter *TableFilter) Filter(tableNames []TableName) ([]TableName, []string) {
+	if filter == nil {
+		return tableNames, nil
+	}
+
+	selected := make([]TableName, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		if filter.Selected(tableName) {
+			selected = append(selected, tableName)
+		}
+	}
+
+	var missing []string
+	for _, matcher := range filter.included {
+		if !matcher.matchesAnyOf(tableNames) {
+			missing = append(missing, matcher.pattern)
+		}
+	}
+
+	return selected, missing
+}
+
+// selectTables method selects tables to be exported from list of tables
+// read from database. Included names or patterns that don't match any
+// existing table are logged. Error is returned when two selected tables
+// would be exported into the same file or object. No table is selected when
+// only reports are exported.
+func (storage DBStorage) selectTables(tableNames []TableName) ([]TableName, error) {
+	if storage.reportsOnly {
+		storage.logger.Info().Int("count", len(tableNames)).Msg(onlyReportsExported)
+		return nil, nil
+	}
+
+	if storage.tableFilter == nil {
+		storage.progress.AddTables(len(tableNames))
+		return tableNames, checkTableNameCollisions(tableNames)
+	}
+
+	selected, missing := storage.tableFilter.Filter(tableNames)
+	storage.progress.AddTables(len(selected))
+
+	for _, table := range missing {
+		storage.logger.Warn().Str(tablePatternMsg, table).Msg(selectedTableNotFound)
+	}
+
+	storage.logger.Info().
+		Int(selectedTablesMsg, len(selected)).
+		Int("count", len(tableNames)).
+		Msg(tablesSelected)
+
+	return selected, checkTableNameCollisions(selected)
+}
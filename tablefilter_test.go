@@ -0,0 +1,243 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tablefilter_test.html
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// prepareDatabaseWithTables helper function creates SQLite database file
+// with given empty tables
+func prepareDatabaseWithTables(t *testing.T, tables ...string) string {
+	statements := make([]string, 0, len(tables))
+	for _, table := range tables {
+		statements = append(statements, `CREATE TABLE `+table+` (id INTEGER)`)
+	}
+
+	return newSQLiteTestDatabase(t, statements...)
+}
+
+// TestParseTableList checks the function parseTableList
+func TestParseTableList(t *testing.T) {
+	assert.Equal(t, []string{"report", "rule_hit"}, main.ParseTableList("report, rule_hit,"))
+	assert.Empty(t, main.ParseTableList(""))
+	assert.Empty(t, main.ParseTableList(" , "))
+}
+
+// TestReadTableList checks the function readTableList
+func TestReadTableList(t *testing.T) {
+	tables, err := main.ReadTableList(strings.NewReader(
+		"# tables exported daily\nreport\n\n  rule_hit  \n/^rule_(hit|toggle){1,2}$/\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "rule_hit", "/^rule_(hit|toggle){1,2}$/"}, tables)
+}
+
+// TestSelectedTablesFromFlags checks that tables are read from comma-separated
+// flag, file or standard input
+func TestSelectedTablesFromFlags(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tables.txt")
+	assert.NoError(t, os.WriteFile(fileName, []byte("report\nrule_*\n"), 0o600))
+
+	stdin := strings.NewReader("rule_hit\n")
+
+	tables, err := main.SelectedTablesFromFlags(main.CliFlags{Tables: "report,rule_hit"}, stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "rule_hit"}, tables)
+
+	tables, err = main.SelectedTablesFromFlags(main.CliFlags{TablesFile: fileName}, stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"report", "rule_*"}, tables)
+
+	tables, err = main.SelectedTablesFromFlags(main.CliFlags{Tables: "-"}, stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rule_hit"}, tables)
+
+	tables, err = main.SelectedTablesFromFlags(main.CliFlags{}, stdin)
+	assert.NoError(t, err)
+	assert.Empty(t, tables)
+}
+
+// TestSelectedTablesFromFlagsErrors checks that missing file and both flags
+// used together are reported
+func TestSelectedTablesFromFlagsErrors(t *testing.T) {
+	_, err := main.SelectedTablesFromFlags(main.CliFlags{
+		TablesFile: filepath.Join(t.TempDir(), "missing.txt"),
+	}, strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, err = main.SelectedTablesFromFlags(main.CliFlags{
+		Tables:     "report",
+		TablesFile: "tables.txt",
+	}, strings.NewReader(""))
+	assert.EqualError(t, err, "-tables and -tables-file can't be used together")
+}
+
+// TestTableFilterNil checks that all tables are selected by disabled filter
+func TestTableFilterNil(t *testing.T) {
+	filter, err := main.NewTableFilter(nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	assert.True(t, filter.Selected("report"))
+
+	tables := []main.TableName{"report", "rule_hit"}
+	selected, missing := filter.Filter(tables)
+	assert.Equal(t, tables, selected)
+	assert.Empty(t, missing)
+}
+
+// TestTableFilterIncluded checks that only included tables are selected
+func TestTableFilterIncluded(t *testing.T) {
+	filter, err := main.NewTableFilter([]string{"rule_hit", "report", "unknown"}, nil)
+	assert.NoError(t, err)
+
+	assert.True(t, filter.Selected("report"))
+	assert.False(t, filter.Selected("advisor_ratings"))
+
+	selected, missing := filter.Filter([]main.TableName{"advisor_ratings", "report", "rule_hit"})
+	assert.Equal(t, []main.TableName{"report", "rule_hit"}, selected)
+	assert.Equal(t, []string{"unknown"}, missing)
+}
+
+// TestTableFilterExcluded checks that excluded tables are never selected
+func TestTableFilterExcluded(t *testing.T) {
+	filter, err := main.NewTableFilter([]string{"report", "rule_hit"}, []string{"rule_hit"})
+	assert.NoError(t, err)
+
+	selected, missing := filter.Filter([]main.TableName{"advisor_ratings", "report", "rule_hit"})
+	assert.Equal(t, []main.TableName{"report"}, selected)
+	assert.Empty(t, missing)
+
+	filter, err = main.NewTableFilter(nil, []string{"report"})
+	assert.NoError(t, err)
+	selected, _ = filter.Filter([]main.TableName{"advisor_ratings", "report", "rule_hit"})
+	assert.Equal(t, []main.TableName{"advisor_ratings", "rule_hit"}, selected)
+}
+
+// TestTableFilterWithinAndWithout checks that filter can be restricted to
+// tables matched by given patterns or to other tables
+func TestTableFilterWithinAndWithout(t *testing.T) {
+	tables := []main.TableName{"advisor_ratings", "report", "rule_hit"}
+
+	var filter *main.TableFilter
+	within, err := filter.Within([]string{"rule_*", "report"})
+	assert.NoError(t, err)
+	selected, _ := within.Filter(tables)
+	assert.Equal(t, []main.TableName{"report", "rule_hit"}, selected)
+
+	without, err := filter.Without([]string{"rule_*", "report"})
+	assert.NoError(t, err)
+	selected, _ = without.Filter(tables)
+	assert.Equal(t, []main.TableName{"advisor_ratings"}, selected)
+
+	// tables selected by the original filter are restricted further
+	filter, err = main.NewTableFilter([]string{"advisor_ratings", "report"}, nil)
+	assert.NoError(t, err)
+	within, err = filter.Within([]string{"rule_*", "report"})
+	assert.NoError(t, err)
+	selected, _ = within.Filter(tables)
+	assert.Equal(t, []main.TableName{"report"}, selected)
+
+	// the original filter is not changed
+	selected, _ = filter.Filter(tables)
+	assert.Equal(t, []main.TableName{"advisor_ratings", "report"}, selected)
+
+	_, err = filter.Within([]string{"/[/"})
+	assert.Error(t, err)
+}
+
+// TestTableFilterGlob checks that tables can be selected by glob patterns
+func TestTableFilterGlob(t *testing.T) {
+	filter, err := main.NewTableFilter([]string{"rule_*", "report", "cluster_?"},
+		[]string{"*_toggle"})
+	assert.NoError(t, err)
+
+	selected, missing := filter.Filter([]main.TableName{
+		"advisor_ratings", "report", "rule_hit", "rule_disable", "rule_toggle"})
+	assert.Equal(t, []main.TableName{"report", "rule_hit", "rule_disable"}, selected)
+	assert.Equal(t, []string{"cluster_?"}, missing)
+}
+
+// TestTableFilterRegexp checks that tables can be selected by regular
+// expressions enclosed in slashes
+func TestTableFilterRegexp(t *testing.T) {
+	filter, err := main.NewTableFilter([]string{"/^rule_(hit|toggle)$/"},
+		[]string{"/toggle/"})
+	assert.NoError(t, err)
+
+	selected, missing := filter.Filter([]main.TableName{
+		"report", "rule_hit", "rule_hits", "rule_toggle"})
+	assert.Equal(t, []main.TableName{"rule_hit"}, selected)
+	assert.Empty(t, missing)
+}
+
+// TestTableFilterWrongPattern checks that wrong patterns are refused
+func TestTableFilterWrongPattern(t *testing.T) {
+	_, err := main.NewTableFilter([]string{"/rule_(/"}, nil)
+	assert.Error(t, err)
+
+	_, err = main.NewTableFilter(nil, []string{"rule_[a-"})
+	assert.Error(t, err)
+}
+
+// TestPerformDataExportSelectedTables checks that only selected tables are
+// exported and that tables selected on command line override configuration
+func TestPerformDataExportSelectedTables(t *testing.T) {
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report", "rule_hit", "advisor_ratings"),
+		},
+		Export: main.ExportConfiguration{
+			Tables:        []string{"advisor_ratings"},
+			ExcludeTables: []string{"rule_hit"},
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+		Tables:          "report,rule_hit",
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	assert.FileExists(t, filepath.Join(directory, "report.csv"))
+	for _, table := range []string{"rule_hit", "advisor_ratings"} {
+		_, err := os.Stat(filepath.Join(directory, table+".csv"))
+		assert.True(t, os.IsNotExist(err), table)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains TLS settings of connections to destinations
+// (S3, webhooks, Google Sheets). Minimal TLS version and allowed cipher
+// suites can be configured for each destination separately, so hardening
+// baseline can be satisfied without rebuilding the binary.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tlsconfig.html
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// messages
+const (
+	unknownTLSVersion     = "unknown TLS version %q, 1.0, 1.1, 1.2 or 1.3 can be used"
+	unknownTLSCipherSuite = "unknown or insecure cipher suite %q"
+)
+
+// tlsVersions contains TLS versions that can be configured
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfiguration contains TLS settings of connection to one destination,
+// empty values mean defaults
+type TLSConfiguration struct {
+	MinVersion   string
+	CipherSuites []string
+}
+
+// HTTP clients cached by TLS settings
+var (
+	httpClientsMutex sync.Mutex
+	httpClients      = map[string]*http.Client{}
+)
+
+// s3TLS function returns TLS settings of connection to S3
+func s3TLS(config S3Configuration) TLSConfiguration {
+	return TLSConfiguration{MinVersion: config.TLSMinVersion, CipherSuites: config.TLSCipherSuites}
+}
+
+// hooksTLS function returns TLS settings of connection to webhook
+func hooksTLS(config HooksConfiguration) TLSConfiguration {
+	return TLSConfiguration{MinVersion: config.TLSMinVersion, CipherSuites: config.TLSCipherSuites}
+}
+
+// sheetsTLS function returns TLS settings of connection to Google Sheets
+func sheetsTLS(config GoogleSheetsConfiguration) TLSConfiguration {
+	return TLSConfiguration{MinVersion: config.TLSMinVersion, CipherSuites: config.TLSCipherSuites}
+}
+
+// tlsConfigured function checks whether any TLS setting differs from
+// defaults
+func tlsConfigured(config TLSConfiguration) bool {
+	return config.MinVersion != "" || len(config.CipherSuites) > 0
+}
+
+// tlsVersion function converts configured TLS version into its identifier
+func tlsVersion(version string) (uint16, error) {
+	id, found := tlsVersions[version]
+	if !found {
+		return 0, fmt.Errorf(unknownTLSVersion, version)
+	}
+	return id, nil
+}
+
+// tlsCipherSuite function converts name of cipher suite into its
+// identifier. Only cipher suites without known security issues can be used.
+func tlsCipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf(unknownTLSCipherSuite, name)
+}
+
+// checkTLS method checks TLS settings of destination configured in given
+// section
+func (c *configurationChecker) checkTLS(section string, config TLSConfiguration) {
+	if config.MinVersion != "" {
+		if _, err := tlsVersion(config.MinVersion); err != nil {
+			c.report(section+".tls_min_version", err.Error())
+		}
+	}
+
+	for i, name := range config.CipherSuites {
+		if _, err := tlsCipherSuite(name); err != nil {
+			c.report(fmt.Sprintf("%s.tls_cipher_suites[%d]", section, i), err.Error())
+		}
+	}
+}
+
+// configureTLS function applies TLS settings on top of given TLS
+// configuration. Given configuration is not changed, nil can be passed
+// when there is none.
+func configureTLS(base *tls.Config, config TLSConfiguration) (*tls.Config, error) {
+	if !tlsConfigured(config) {
+		return base, nil
+	}
+
+	tlsConfig := &tls.Config{
+		// default of crypto/tls package, it is overridden by configuration
+		MinVersion: tls.VersionTLS12,
+	}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+
+	if config.MinVersion != "" {
+		version, err := tlsVersion(config.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	// cipher suites are not configurable for TLS 1.3
+	if len(config.CipherSuites) > 0 {
+		tlsConfig.CipherSuites = make([]uint16, 0, len(config.CipherSuites))
+		for _, name := range config.CipherSuites {
+			suite, err := tlsCipherSuite(name)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// httpClient function returns HTTP client using given TLS settings. Default
+// client is used when no TLS setting is configured, other clients are
+// cached, so connections are reused.
+func httpClient(config TLSConfiguration) (*http.Client, error) {
+	if !tlsConfigured(config) {
+		return http.DefaultClient, nil
+	}
+
+	key := config.MinVersion + "/" + strings.Join(config.CipherSuites, ",")
+
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+
+	if client, found := httpClients[key]; found {
+		return client, nil
+	}
+
+	tlsConfig, err := configureTLS(nil, config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client := &http.Client{Transport: transport}
+	httpClients[key] = client
+	return client, nil
+}
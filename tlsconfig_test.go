@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/tlsconfig_test.html
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestConfigureTLS checks the function ConfigureTLS
+func TestConfigureTLS(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: "s3"}
+
+	// nothing is configured
+	tlsConfig, err := main.ConfigureTLS(base, main.TLSConfiguration{})
+	assert.NoError(t, err)
+	assert.Same(t, base, tlsConfig)
+
+	tlsConfig, err = main.ConfigureTLS(base, main.TLSConfiguration{
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, tlsConfig.CipherSuites)
+	assert.Equal(t, "s3", tlsConfig.ServerName)
+
+	// base configuration is not changed
+	assert.Equal(t, uint16(tls.VersionTLS12), base.MinVersion)
+	assert.Nil(t, base.CipherSuites)
+
+	// TLS 1.2 is used by default when there is no base configuration
+	tlsConfig, err = main.ConfigureTLS(nil, main.TLSConfiguration{
+		CipherSuites: []string{"TLS_AES_128_GCM_SHA256"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+}
+
+// TestConfigureTLSErrors checks that unknown TLS version and insecure cipher
+// suites are refused
+func TestConfigureTLSErrors(t *testing.T) {
+	_, err := main.ConfigureTLS(nil, main.TLSConfiguration{MinVersion: "1.4"})
+	assert.EqualError(t, err, `unknown TLS version "1.4", 1.0, 1.1, 1.2 or 1.3 can be used`)
+
+	_, err = main.ConfigureTLS(nil, main.TLSConfiguration{
+		CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+	})
+	assert.EqualError(t, err, `unknown or insecure cipher suite "TLS_RSA_WITH_RC4_128_SHA"`)
+}
+
+// TestHTTPClient checks that HTTP clients with TLS settings are cached and
+// that minimal TLS version is enforced
+func TestHTTPClient(t *testing.T) {
+	client, err := main.HTTPClient(main.TLSConfiguration{})
+	assert.NoError(t, err)
+	assert.Same(t, http.DefaultClient, client)
+
+	client, err = main.HTTPClient(main.TLSConfiguration{MinVersion: "1.3"})
+	assert.NoError(t, err)
+	assert.NotSame(t, http.DefaultClient, client)
+
+	cached, err := main.HTTPClient(main.TLSConfiguration{MinVersion: "1.3"})
+	assert.NoError(t, err)
+	assert.Same(t, client, cached)
+
+	// server supports TLS 1.2 at most
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	_, err = client.Get(server.URL)
+	assert.ErrorContains(t, err, "protocol version")
+
+	_, err = main.HTTPClient(main.TLSConfiguration{MinVersion: "2"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains trend of rules disabled by more users. Lists of
+// disabled rules stored into S3 by previous runs (under other prefixes) are
+// read from the bucket and counts of each rule over the last runs are
+// exported into one CSV, so they don't need to be stitched together manually.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/trend.html
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// name of object with trend of disabled rules
+const disabledRulesTrend = "_disabled_rules_trend.csv"
+
+// messages
+const (
+	readingDisabledRulesTrend = "Reading disabled rules stored by previous runs"
+	storeTrendFailed          = "Store disabled rules trend failed"
+	wrongDisabledRulesHeader  = "unexpected header of disabled rules CSV"
+	wrongDisabledRulesObject  = "disabled rule without rule name or count in JSON"
+)
+
+// DisabledRulesRun contains rules disabled by more users as found by one
+// export run. Run is labeled by prefix its objects have been stored under.
+type DisabledRulesRun struct {
+	Label string
+	Rules []DisabledRuleInfo
+}
+
+// DisabledRulesFromCSV function reads list of disabled rules in the form
+// written by DisabledRulesToCSV. Lists converted by output profile are read
+// too.
+func DisabledRulesFromCSV(reader io.Reader) ([]DisabledRuleInfo, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte(byteOrderMark))
+
+	csvReader := csv.NewReader(bytes.NewReader(data))
+	csvReader.Comma = csvDelimiter(data)
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || len(records[0]) != 2 {
+		return nil, errors.New(wrongDisabledRulesHeader)
+	}
+
+	disabledRulesInfo := make([]DisabledRuleInfo, 0, len(records)-1)
+	for _, record := range records[1:] {
+		count, err := strconv.Atoi(record[1])
+		if err != nil {
+			return nil, err
+		}
+		disabledRulesInfo = append(disabledRulesInfo, DisabledRuleInfo{
+			Rule:  record[0],
+			Count: count,
+		})
+	}
+
+	return disabledRulesInfo, nil
+}
+
+// DisabledRulesTrendToCSV function exports counts of disabled rules found by
+// given runs into CSV. There is one column per run (from the oldest one) and
+// one row per rule; zero is written when the rule has not been disabled by
+// more users in given run.
+func DisabledRulesTrendToCSV(buffer io.Writer, runs []DisabledRulesRun) error {
+	writer, release := newCSVWriter(buffer)
+	defer release()
+
+	header := []string{"Rule"}
+	counts := make(map[string][]int)
+
+	for i, run := range runs {
+		header = append(header, run.Label)
+		for _, disabledRuleInfo := range run.Rules {
+			if _, found := counts[disabledRuleInfo.Rule]; !found {
+				counts[disabledRuleInfo.Rule] = make([]int, len(runs))
+			}
+			counts[disabledRuleInfo.Rule][i] = disabledRuleInfo.Count
+		}
+	}
+
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	// rules are written in stable order
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	for _, rule := range rules {
+		record := []string{rule}
+		for _, count := range counts[rule] {
+			record = append(record, strconv.Itoa(count))
+		}
+
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// isDisabledRulesObject function checks if object with given name contains
+// list of disabled rules (in any report format and with any compression)
+func isDisabledRulesObject(objectName string) bool {
+	name := path.Base(objectName)
+	name = strings.TrimSuffix(name, compressionExtension(objectCompression(name)))
+
+	for _, format := range []string{csvFormat, jsonFormat, ndjsonFormat} {
+		if name == reportName(disabledRules, format) {
+			return true
+		}
+	}
+	return false
+}
+
+// runLabel function returns label of run that stored given object
+func runLabel(objectName string) string {
+	return path.Dir(objectName)
+}
+
+// listPreviousDisabledRules function lists objects with disabled rules stored
+// by previous runs, from the oldest one. Objects stored under prefixes next
+// to the selected one are searched.
+func listPreviousDisabledRules(ctx context.Context, session *S3Session,
+	bucketName, prefix, currentObject string) ([]minio.ObjectInfo, error) {
+	options := minio.ListObjectsOptions{
+		Recursive: true,
+	}
+	if parent := path.Dir(strings.TrimSuffix(prefix, "/")); parent != "." {
+		options.Prefix = parent + "/"
+	}
+
+	var objects []minio.ObjectInfo
+	for object := range session.client.ListObjects(ctx, bucketName, options) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// the current run is not stored yet or it is overwritten
+		if isDisabledRulesObject(object.Key) && runLabel(object.Key) != runLabel(currentObject) {
+			objects = append(objects, object)
+		}
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].LastModified.Equal(objects[j].LastModified) {
+			return objects[i].Key < objects[j].Key
+		}
+		return objects[i].LastModified.Before(objects[j].LastModified)
+	})
+
+	return objects, nil
+}
+
+// readDisabledRulesFromS3 function reads list of disabled rules stored in
+// given object
+func readDisabledRulesFromS3(ctx context.Context, session *S3Session,
+	bucketName, objectName string) ([]DisabledRuleInfo, error) {
+	object, err := session.client.GetObject(ctx, bucketName, objectName,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = object.Close()
+	}()
+
+	decompressor, err := newDecompressor(objectCompression(objectName), object)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = decompressor.Close()
+	}()
+
+	return disabledRulesFromReport(objectName, decompressor)
+}
+
+// storeDisabledRulesTrendIntoS3 function reads lists of disabled rules
+// stored by previous runs and stores trend over given number of runs
+// (including the current one) under selected prefix
+func storeDisabledRulesTrendIntoS3(ctx context.Context, session *S3Session,
+	bucketName, prefix string, disabledRulesInfo []DisabledRuleInfo,
+	runs int, compression string, encryption *Encryption) error {
+	currentObject := setObjectPrefix(prefix, disabledRules)
+
+	objects, err := listPreviousDisabledRules(ctx, session, bucketName,
+		prefix, currentObject)
+	if err != nil {
+		return err
+	}
+
+	// only the last runs are part of trend
+	if len(objects) > runs-1 {
+		objects = objects[len(objects)-(runs-1):]
+	}
+
+	trend := make([]DisabledRulesRun, 0, len(objects)+1)
+	for _, object := range objects {
+		previous, err := readDisabledRulesFromS3(ctx, session, bucketName, object.Key)
+		if err != nil {
+			return err
+		}
+		trend = append(trend, DisabledRulesRun{
+			Label: runLabel(object.Key),
+			Rules: previous,
+		})
+	}
+
+	trend = append(trend, DisabledRulesRun{
+		Label: runLabel(currentObject),
+		Rules: disabledRulesInfo,
+	})
+
+	buffer := new(bytes.Buffer)
+	err = DisabledRulesTrendToCSV(buffer, trend)
+	if err != nil {
+		return err
+	}
+
+	return putObject(ctx, session, bucketName,
+		setObjectPrefix(prefix, disabledRulesTrend), csvContentType,
+		buffer.Bytes(), compression, encryption)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/trend_test.html
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestDisabledRulesFromCSV checks that list of disabled rules written into
+// CSV can be read back
+func TestDisabledRulesFromCSV(t *testing.T) {
+	disabledRules := []main.DisabledRuleInfo{
+		{Rule: "rule.a", Count: 2},
+		{Rule: "rule.b", Count: 5},
+	}
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, main.DisabledRulesToCSV(buffer, disabledRules))
+
+	read, err := main.DisabledRulesFromCSV(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, disabledRules, read)
+}
+
+// TestDisabledRulesFromCSVWrongContent checks that CSV with other content is
+// refused
+func TestDisabledRulesFromCSVWrongContent(t *testing.T) {
+	_, err := main.DisabledRulesFromCSV(strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, err = main.DisabledRulesFromCSV(strings.NewReader("org_id,cluster_id,report\n"))
+	assert.Error(t, err)
+
+	_, err = main.DisabledRulesFromCSV(strings.NewReader("Rule,Count\nrule.a,many\n"))
+	assert.Error(t, err)
+}
+
+// TestDisabledRulesTrendToCSV checks that counts of rules are written per
+// run
+func TestDisabledRulesTrendToCSV(t *testing.T) {
+	buffer := new(bytes.Buffer)
+
+	err := main.DisabledRulesTrendToCSV(buffer, []main.DisabledRulesRun{
+		{Label: "2024-01-01", Rules: []main.DisabledRuleInfo{{Rule: "rule.b", Count: 2}}},
+		{Label: "2024-01-02", Rules: []main.DisabledRuleInfo{
+			{Rule: "rule.b", Count: 3},
+			{Rule: "rule.a", Count: 4},
+		}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Rule,2024-01-01,2024-01-02\n"+
+		"rule.a,0,4\n"+
+		"rule.b,2,3\n", buffer.String())
+}
+
+// TestStoreDisabledRulesTrendIntoS3 checks that lists of disabled rules
+// stored by the last runs are read from S3 and trend is stored under the
+// current prefix
+func TestStoreDisabledRulesTrendIntoS3(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	previous := func(count int) []byte {
+		buffer := new(bytes.Buffer)
+		assert.NoError(t, main.DisabledRulesToCSV(buffer,
+			[]main.DisabledRuleInfo{{Rule: "rule.a", Count: count}}))
+		return buffer.Bytes()
+	}
+
+	compressed, err := main.CompressData("gzip", nil, previous(2))
+	assert.NoError(t, err)
+
+	s3.objects["/bucket/exports/2024-01-01/_disabled_rules.csv"] = previous(1)
+	s3.objects["/bucket/exports/2024-01-02/_disabled_rules.csv.gz"] = compressed
+	s3.objects["/bucket/exports/2024-01-02/report.csv"] = []byte("id\n")
+	s3.objects["/bucket/exports/2024-01-03/_disabled_rules.csv"] = previous(3)
+	s3.objects["/bucket/other/2024-01-01/_disabled_rules.csv"] = previous(10)
+
+	err = main.StoreDisabledRulesTrendIntoS3(context.Background(), session,
+		"bucket", "exports/2024-01-04",
+		[]main.DisabledRuleInfo{{Rule: "rule.a", Count: 4}, {Rule: "rule.b", Count: 1}},
+		3, "none", nil)
+	assert.NoError(t, err)
+
+	trend, found := s3.objects["/bucket/exports/2024-01-04/_disabled_rules_trend.csv"]
+	assert.True(t, found)
+	assert.Equal(t, "Rule,exports/2024-01-02,exports/2024-01-03,exports/2024-01-04\n"+
+		"rule.a,2,3,4\n"+
+		"rule.b,0,0,1\n", string(trend))
+}
+
+// TestStoreDisabledRulesTrendIntoS3FirstRun checks that trend contains the
+// current run only when no previous run has been found
+func TestStoreDisabledRulesTrendIntoS3FirstRun(t *testing.T) {
+	s3, session := startFakeS3(t)
+
+	err := main.StoreDisabledRulesTrendIntoS3(context.Background(), session,
+		"bucket", "2024-01-01", []main.DisabledRuleInfo{{Rule: "rule.a", Count: 4}},
+		5, "none", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Rule,2024-01-01\nrule.a,4\n",
+		string(s3.objects["/bucket/2024-01-01/_disabled_rules_trend.csv"]))
+}
@@ -0,0 +1,238 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains inference of column types from sampled rows.
+// Some columns are reported by database driver without type (SQLite columns
+// declared without type, PostgreSQL types defined by extensions). Types of
+// such columns are inferred from values read from first rows of the table
+// and the inference is recorded in schema sidecar file, so readers don't
+// treat these columns as strings silently.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/typeinference.html
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTypeInferenceRows is number of rows sampled to infer column types
+// when it is not configured
+const defaultTypeInferenceRows = 100
+
+// messages
+const (
+	columnTypeInferred    = "Type of column is not known, it is inferred from sampled rows"
+	columnTypeNotInferred = "Type of column is not known and it can't be inferred from sampled rows"
+	inferredTypeMsg       = "inferred type"
+	sampledRowsMsg        = "sampled rows"
+	columnNameMsg         = "column"
+)
+
+// timestampLayouts contains layouts of textual values recognized as
+// timestamps
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// InferredColumn represents column with type inferred from sampled rows
+type InferredColumn struct {
+	Column
+	// InferredType is database type inferred from sampled values, it is
+	// empty when all sampled values are NULL
+	InferredType string
+	// SampledRows is number of rows the type has been inferred from
+	SampledRows int
+}
+
+// typeInferenceRows method returns number of rows sampled to infer column
+// types
+func (storage DBStorage) typeInferenceRows() int {
+	if storage.inferenceRows > 0 {
+		return storage.inferenceRows
+	}
+	return defaultTypeInferenceRows
+}
+
+// valueType function returns database type of value read from database
+// without type information. Textual values are parsed to find the most
+// specific type.
+func valueType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "BOOL"
+	case int64, int32, int:
+		return "INT8"
+	case float64, float32:
+		return "FLOAT8"
+	case time.Time:
+		return "TIMESTAMP"
+	case []byte:
+		return textType(string(v))
+	case string:
+		return textType(v)
+	default:
+		return "TEXT"
+	}
+}
+
+// textType function returns database type of textual value
+func textType(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "INT8"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "FLOAT8"
+	}
+	if value == "true" || value == "false" {
+		return "BOOL"
+	}
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return "TIMESTAMP"
+		}
+	}
+
+	trimmed := strings.TrimSpace(value)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) &&
+		json.Valid([]byte(trimmed)) {
+		return "JSON"
+	}
+	return "TEXT"
+}
+
+// mergeTypes function returns type that can represent values of both given
+// types. Integers are widened into floating point numbers, all other mixed
+// types are represented as text.
+func mergeTypes(first, second string) string {
+	switch {
+	case first == "" || first == second:
+		return second
+	case (first == "INT8" && second == "FLOAT8") || (first == "FLOAT8" && second == "INT8"):
+		return "FLOAT8"
+	default:
+		return "TEXT"
+	}
+}
+
+// inferColumnTypes method infers types of given columns reported without
+// type from rows sampled from given table. Inference is logged, so it is not
+// applied silently.
+func (storage DBStorage) inferColumnTypes(ctx context.Context, tableName TableName,
+	columns []Column) ([]InferredColumn, error) {
+	inferred := make([]InferredColumn, len(columns))
+	unknown := make(map[string]int)
+	for i, column := range columns {
+		inferred[i] = InferredColumn{Column: column}
+		if column.DatabaseType == "" {
+			unknown[column.Name] = i
+		}
+	}
+
+	// nothing to infer
+	if len(unknown) == 0 {
+		return inferred, nil
+	}
+
+	sqlStatement, err := storage.selectTableContent(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	storage.applySelectiveExport(&sqlStatement, tableName)
+	sqlStatement += fmt.Sprintf(" LIMIT %d", storage.typeInferenceRows())
+
+	ctx, cancel := storage.queryContext(ctx)
+	defer cancel()
+
+	rows, err := storage.connection.QueryContext(ctx, sqlStatement)
+	if err != nil {
+		storage.logger.Error().Err(err).Str(sqlStatementExecuted, sqlStatement).Msg(sqlStatementExecutionError)
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			storage.logger.Error().Err(err).Msg(unableToCloseDBRowsHandle)
+		}
+	}()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	// values are read without type conversion
+	values := make([]interface{}, len(names))
+	scanArgs := make([]interface{}, len(names))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	sampled := 0
+	for rows.Next() {
+		err := rows.Scan(scanArgs...)
+		if err != nil {
+			return nil, err
+		}
+		sampled++
+
+		for i, name := range names {
+			index, found := unknown[name]
+			if !found || values[i] == nil {
+				continue
+			}
+			inferred[index].InferredType = mergeTypes(inferred[index].InferredType,
+				valueType(values[i]))
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// columns are logged in the order they are defined in table
+	for i := range inferred {
+		if _, found := unknown[inferred[i].Name]; !found {
+			continue
+		}
+		inferred[i].SampledRows = sampled
+
+		message := columnTypeInferred
+		if inferred[i].InferredType == "" {
+			message = columnTypeNotInferred
+		}
+		storage.logger.Warn().
+			Str(tableNameMsg, string(tableName)).
+			Str(columnNameMsg, inferred[i].Name).
+			Str(inferredTypeMsg, inferred[i].InferredType).
+			Int(sampledRowsMsg, sampled).
+			Msg(message)
+	}
+
+	return inferred, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/typeinference_test.html
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestValueType checks inference of type of one value
+func TestValueType(t *testing.T) {
+	assert.Equal(t, "BOOL", main.ValueType(true))
+	assert.Equal(t, "INT8", main.ValueType(int64(42)))
+	assert.Equal(t, "FLOAT8", main.ValueType(1.5))
+	assert.Equal(t, "TIMESTAMP", main.ValueType(time.Now()))
+	assert.Equal(t, "INT8", main.ValueType([]byte("-42")))
+	assert.Equal(t, "FLOAT8", main.ValueType("1e3"))
+	assert.Equal(t, "BOOL", main.ValueType("false"))
+	assert.Equal(t, "TIMESTAMP", main.ValueType("2024-01-02T03:04:05Z"))
+	assert.Equal(t, "TIMESTAMP", main.ValueType("2024-01-02 03:04:05"))
+	assert.Equal(t, "JSON", main.ValueType(`{"rule": "rule1"}`))
+	assert.Equal(t, "JSON", main.ValueType(`[1, 2]`))
+	assert.Equal(t, "TEXT", main.ValueType("{not json"))
+	assert.Equal(t, "TEXT", main.ValueType("cluster"))
+}
+
+// TestMergeTypes checks that types of values from more rows are merged
+func TestMergeTypes(t *testing.T) {
+	assert.Equal(t, "INT8", main.MergeTypes("", "INT8"))
+	assert.Equal(t, "INT8", main.MergeTypes("INT8", "INT8"))
+	assert.Equal(t, "FLOAT8", main.MergeTypes("INT8", "FLOAT8"))
+	assert.Equal(t, "FLOAT8", main.MergeTypes("FLOAT8", "INT8"))
+	assert.Equal(t, "TEXT", main.MergeTypes("BOOL", "INT8"))
+	assert.Equal(t, "TEXT", main.MergeTypes("TEXT", "JSON"))
+}
+
+// TestPerformDataExportSchemaSidecarsInferredTypes checks that types of
+// columns without declared type are inferred from sampled rows and recorded
+// in schema sidecar file
+func TestPerformDataExportSchemaSidecarsInferredTypes(t *testing.T) {
+	dataSource := newSQLiteTestDatabase(t,
+		"CREATE TABLE report (id INTEGER PRIMARY KEY, hits, ratio, note, reported_at, missing)",
+		`INSERT INTO report VALUES
+		(1, 42, 1.5, 'first', '2024-01-02 03:04:05', NULL),
+		(2, 43, 2, 'second', '2024-01-03 03:04:05', NULL)`)
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			CSVSchemaSidecars: true,
+			TypeInferenceRows: 10,
+		},
+	}
+
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		Format:          "csv",
+		OutputDirectory: directory,
+		Limit:           NoLimits,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv.schema.json"))
+	assert.NoError(t, err)
+
+	var schema main.CSVSchema
+	assert.NoError(t, json.Unmarshal(content, &schema))
+
+	assert.Equal(t, []main.CSVColumnSchema{
+		{Name: "id", DatabaseType: "INT4", Type: "integer"},
+		{Name: "hits", Type: "integer", Inferred: true, InferredType: "INT8", SampledRows: 2},
+		{Name: "ratio", Type: "number", Inferred: true, InferredType: "FLOAT8", SampledRows: 2},
+		{Name: "note", Type: "string", Inferred: true, InferredType: "TEXT", SampledRows: 2},
+		{Name: "reported_at", Type: "timestamp", Inferred: true, InferredType: "TIMESTAMP", SampledRows: 2},
+		{Name: "missing", Type: "string", Inferred: true, SampledRows: 2},
+	}, schema.Columns)
+}
@@ -39,14 +39,44 @@ type CliFlags struct {
 	ShowVersion         bool
 	ShowAuthors         bool
 	ShowConfiguration   bool
+	ShowExitStatuses    bool
 	PrintSummaryTable   bool
 	Output              string
+	Format              string
 	CheckS3Connection   bool
+	CheckPermissions    bool
 	ExportMetadata      bool
 	ExportDisabledRules bool
+	DisabledRulesTrend  int
+	ExportRuleHits      bool
+	ExportOrgSummary    bool
+	ExportTrend         bool
+	ReportsOnly         bool
 	ExportLog           bool
+	ExportConfig        bool
+	ExportDigest        bool
+	ExportManifest      bool
 	Limit               int
+	Sample              float64
 	IgnoredTables       string
+	Tables              string
+	TablesFile          string
+	ExcludeTables       string
+	SkipArtifacts       string
+	Bundle              string
+	Resume              bool
+	KeepGoing           bool
+	Prefix              string
+	Schema              string
+
+	// OutputDirectory is directory where exported files are written. It is
+	// not set by command line flag, temporary directory is used when the
+	// export is bundled.
+	OutputDirectory string
+
+	// ArtifactSet is name of artifact set (public or restricted) exported
+	// when the export is split. It is not set by command line flag.
+	ArtifactSet string
 }
 
 // M represents a map with string keys and any value
@@ -54,3 +84,13 @@ type M map[string]interface{}
 
 // IgnoredTables represents set of ignored tables
 type IgnoredTables map[string]struct{}
+
+// SkippedArtifacts represents set of artifacts (list of tables, metadata,
+// disabled rules, operation log) that won't be exported
+type SkippedArtifacts map[string]struct{}
+
+// Contains method checks if given artifact is skipped
+func (s SkippedArtifacts) Contains(artifact string) bool {
+	_, found := s[artifact]
+	return found
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains machine-readable version information. When
+// `-version -format json` is specified, version, commit, build time, Go
+// version and supported features are printed as JSON, so deployment tooling
+// can check that the right binary is running.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/version.html
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"runtime"
+)
+
+// value of build information that has not been set during build
+const notSet = "*not set*"
+
+// Build information set by linker flags (see build.sh)
+var (
+	BuildVersion = notSet
+	BuildTime    = notSet
+	BuildBranch  = notSet
+	BuildCommit  = notSet
+)
+
+// VersionInfo contains version of the exporter and features supported by
+// the binary
+type VersionInfo struct {
+	Version      string   `json:"version"`
+	Commit       string   `json:"commit"`
+	Branch       string   `json:"branch"`
+	BuildTime    string   `json:"build_time"`
+	GoVersion    string   `json:"go_version"`
+	Drivers      []string `json:"drivers"`
+	Formats      []string `json:"formats"`
+	Outputs      []string `json:"outputs"`
+	Compressions []string `json:"compressions"`
+}
+
+// GetVersionInfo function returns version and features of the running
+// binary. Drivers are the database drivers registered in the binary.
+func GetVersionInfo() VersionInfo {
+	return VersionInfo{
+		Version:   BuildVersion,
+		Commit:    BuildCommit,
+		Branch:    BuildBranch,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Drivers:   sql.Drivers(),
+		Formats:   supportedFormats,
+		Outputs:   supportedOutputs,
+		Compressions: []string{
+			noCompression, gzipCompression, zstdCompression, lz4Compression,
+		},
+	}
+}
+
+// showVersionInfo function displays version information in JSON format
+func showVersionInfo() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(GetVersionInfo())
+}
@@ -0,0 +1,440 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains incremental export. Timestamp or serial column
+// can be configured for tables in [incremental] section. The highest value of
+// this column exported from each table (watermark) is recorded into state
+// stored in local file or S3 object and only rows with higher values are
+// exported by subsequent runs. State is updated only when all tables have
+// been exported. Watermark of table is not moved when only part of its rows
+// is exported (because of limit or sampling).
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/watermark.html
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// watermarkContentType is content type of S3 object with watermarks
+const watermarkContentType = "application/json"
+
+// messages
+const (
+	exportingAboveWatermark = "Exporting rows above watermark"
+	watermarksStored        = "Watermarks stored"
+	readWatermarksFailed    = "Read watermarks failed"
+	storeWatermarksFailed   = "Store watermarks failed"
+	watermarkColumnMsg      = "column"
+	watermarkMsg            = "watermark"
+	watermarkStateConflict  = "watermark_state_file and watermark_state_object can't be used together"
+	watermarkStateNotSet    = "state file or object needs to be set for incremental export"
+	watermarkColumnNotFound = "column %s configured for incremental export not found in table %s"
+	watermarkNotMoved       = "Watermark is not moved, because only part of rows has been exported"
+)
+
+// watermarkTimeLayouts are layouts of timestamps read from database as
+// strings (PostgreSQL TIMESTAMP and TIMESTAMPTZ columns are scanned as
+// strings in RFC 3339 format, SQLite stores timestamps as text)
+var watermarkTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// TableWatermark contains the highest value of column exported from one
+// table
+type TableWatermark struct {
+	Column    string `json:"column"`
+	Watermark string `json:"watermark"`
+}
+
+// WatermarkState contains watermarks of all incrementally exported tables
+type WatermarkState struct {
+	Tables map[TableName]TableWatermark `json:"tables"`
+}
+
+// Watermarks contains watermarks read from state of previous run and
+// watermarks recorded by current run.
+//
+// All methods can be called on nil pointer - in this case all tables are
+// exported as a whole. Methods are safe to be called from several
+// goroutines.
+type Watermarks struct {
+	mutex    sync.Mutex
+	columns  IncrementalConfiguration
+	previous map[TableName]TableWatermark
+	current  map[TableName]TableWatermark
+}
+
+// NewWatermarks function constructs watermarks of tables with configured
+// columns. Watermarks recorded for other column than configured are
+// ignored. Nil is returned when no table is exported incrementally.
+func NewWatermarks(columns IncrementalConfiguration, previous WatermarkState) *Watermarks {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	watermarks := &Watermarks{
+		columns:  columns,
+		previous: make(map[TableName]TableWatermark),
+		current:  make(map[TableName]TableWatermark),
+	}
+
+	for tableName, watermark := range previous.Tables {
+		if column, _ := lookupTableConfig(columns, tableName); column == watermark.Column {
+			watermarks.previous[tableName] = watermark
+		}
+	}
+
+	return watermarks
+}
+
+// Column method returns column used to export given table incrementally,
+// empty string is returned for tables exported as a whole
+func (watermarks *Watermarks) Column(tableName TableName) string {
+	if watermarks == nil {
+		return ""
+	}
+
+	column, _ := lookupTableConfig(watermarks.columns, tableName)
+	return column
+}
+
+// Previous method returns watermark of given table recorded by previous run
+func (watermarks *Watermarks) Previous(tableName TableName) (TableWatermark, bool) {
+	if watermarks == nil {
+		return TableWatermark{}, false
+	}
+
+	watermarks.mutex.Lock()
+	defer watermarks.mutex.Unlock()
+
+	watermark, found := watermarks.previous[tableName]
+	return watermark, found
+}
+
+// Record method records watermark of given table reached by current run
+func (watermarks *Watermarks) Record(tableName TableName, watermark TableWatermark) {
+	if watermarks == nil {
+		return
+	}
+
+	watermarks.mutex.Lock()
+	defer watermarks.mutex.Unlock()
+
+	watermarks.current[tableName] = watermark
+}
+
+// State method returns state to be stored for next run. Watermarks of
+// tables with no new rows are kept from previous run.
+func (watermarks *Watermarks) State() WatermarkState {
+	state := WatermarkState{Tables: make(map[TableName]TableWatermark)}
+	if watermarks == nil {
+		return state
+	}
+
+	watermarks.mutex.Lock()
+	defer watermarks.mutex.Unlock()
+
+	for tableName, watermark := range watermarks.previous {
+		state.Tables[tableName] = watermark
+	}
+	for tableName, watermark := range watermarks.current {
+		state.Tables[tableName] = watermark
+	}
+
+	return state
+}
+
+// watermarkTracker tracks the highest value of column exported from one
+// table, so the rows don't need to be kept in memory. Nil tracker is used
+// for tables exported as a whole.
+type watermarkTracker struct {
+	column  string
+	highest interface{}
+}
+
+// Add method records one exported row
+func (tracker *watermarkTracker) Add(row M) {
+	if tracker == nil {
+		return
+	}
+
+	// rows with NULL in the column are exported, but never move watermark
+	value := row[tracker.column]
+	if value != nil && (tracker.highest == nil || watermarkLess(tracker.highest, value)) {
+		tracker.highest = value
+	}
+}
+
+// watermarkTime function converts value of watermark column into time, it
+// is used for timestamps read as strings
+func watermarkTime(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		for _, layout := range watermarkTimeLayouts {
+			timestamp, err := time.Parse(layout, value)
+			if err == nil {
+				return timestamp, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// watermarkLess function compares two values of watermark column.
+// Timestamps (including timestamps read as strings) are compared
+// chronologically, other values as primary keys. Strings can't be compared
+// as timestamps, because fractional seconds are omitted when they are zero.
+func watermarkLess(a, b interface{}) bool {
+	timeA, okA := watermarkTime(a)
+	timeB, okB := watermarkTime(b)
+	if okA && okB {
+		return timeA.Before(timeB)
+	}
+
+	return keyLess(a, b)
+}
+
+// watermarkValue function converts value of watermark column into form that
+// is stored in state and used in SQL literal
+func watermarkValue(value interface{}) string {
+	if timestamp, ok := value.(time.Time); ok {
+		return timestamp.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Reset method forgets all recorded rows, it is used when the table is read
+// again
+func (tracker *watermarkTracker) Reset() {
+	if tracker == nil {
+		return
+	}
+
+	tracker.highest = nil
+}
+
+// newWatermarkTracker method constructs tracker of given table. Nil is
+// returned for tables exported as a whole.
+func (storage DBStorage) newWatermarkTracker(colNames []string,
+	tableName TableName) (*watermarkTracker, error) {
+	column := storage.watermarks.Column(tableName)
+	if column == "" {
+		return nil, nil
+	}
+
+	for _, colName := range colNames {
+		if colName == column {
+			return &watermarkTracker{column: column}, nil
+		}
+	}
+
+	return nil, fmt.Errorf(watermarkColumnNotFound, column, tableName)
+}
+
+// partialExport method checks whether only part of rows of given table is
+// exported, because of limit of rows (given or configured for the table) or
+// because of random sampling
+func (storage DBStorage) partialExport(tableName TableName, limit int) bool {
+	return storage.tableLimit(tableName, limit) > 0 ||
+		storage.sample > 0 && storage.sample < 1
+}
+
+// recordWatermark method records watermark reached when all rows of given
+// table have been exported. Watermark of previous run is kept when no new
+// row has been exported or when only part of rows has been exported, rows
+// that have not been exported would be skipped by next run otherwise.
+func (storage DBStorage) recordWatermark(tableName TableName, limit int,
+	tracker *watermarkTracker) {
+	if tracker == nil || tracker.highest == nil {
+		return
+	}
+
+	if storage.partialExport(tableName, limit) {
+		storage.logger.Warn().Str(watermarkColumnMsg, tracker.column).
+			Msg(watermarkNotMoved)
+		return
+	}
+
+	storage.watermarks.Record(tableName, TableWatermark{
+		Column:    tracker.column,
+		Watermark: watermarkValue(tracker.highest),
+	})
+}
+
+// watermarkApplied method checks whether only rows above watermark are read
+// from given table
+func (storage DBStorage) watermarkApplied(tableName TableName) bool {
+	_, found := storage.watermarks.Previous(tableName)
+	return found
+}
+
+// applyWatermark method appends condition selecting rows above watermark of
+// previous run into given query. Query might be filtered by organization ID
+// already.
+func (storage DBStorage) applyWatermark(sqlStatement *string, tableName TableName) {
+	watermark, found := storage.watermarks.Previous(tableName)
+	if !found {
+		return
+	}
+
+	keyword := " WHERE "
+	if storage.orgIDFilterApplied(tableName) {
+		keyword = " AND "
+	}
+
+	*sqlStatement += keyword + quoteIdentifier(watermark.Column) + " > " +
+		sqlLiteral(watermark.Watermark)
+}
+
+// logWatermark method logs watermark rows of given table are exported above
+func (storage DBStorage) logWatermark(tableName TableName) {
+	watermark, found := storage.watermarks.Previous(tableName)
+	if !found {
+		return
+	}
+
+	storage.logger.Info().
+		Str(watermarkColumnMsg, watermark.Column).
+		Str(watermarkMsg, watermark.Watermark).
+		Msg(exportingAboveWatermark)
+}
+
+// readWatermarkState function reads watermarks from given reader
+func readWatermarkState(reader io.Reader) (WatermarkState, error) {
+	var state WatermarkState
+	err := json.NewDecoder(reader).Decode(&state)
+	return state, err
+}
+
+// loadWatermarks function reads state of previous run from configured file
+// or S3 object and constructs watermarks of incrementally exported tables.
+// All tables are exported as a whole when no state has been stored yet.
+func loadWatermarks(ctx context.Context, configuration *ConfigStruct) (*Watermarks, int, error) {
+	columns := GetIncrementalConfiguration(configuration)
+	if len(columns) == 0 {
+		return nil, ExitStatusOK, nil
+	}
+
+	exportConfiguration := GetExportConfiguration(configuration)
+	var state WatermarkState
+
+	switch {
+	case exportConfiguration.WatermarkStateFile != "":
+		file, err := os.Open(exportConfiguration.WatermarkStateFile)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, ExitStatusIOError, err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		state, err = readWatermarkState(file)
+		if err != nil {
+			return nil, ExitStatusIOError, err
+		}
+	case exportConfiguration.WatermarkStateObject != "":
+		session, err := OpenS3Session(configuration)
+		if err != nil {
+			return nil, ExitStatusS3Error, err
+		}
+
+		object, err := session.Client().GetObject(ctx, session.Bucket(),
+			exportConfiguration.WatermarkStateObject, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, ExitStatusS3Error, err
+		}
+		defer func() {
+			_ = object.Close()
+		}()
+
+		state, err = readWatermarkState(object)
+		if err != nil && minio.ToErrorResponse(err).Code != noSuchKeyErrorCode {
+			return nil, ExitStatusS3Error, err
+		}
+	default:
+		return nil, ExitStatusConfigurationError, errors.New(watermarkStateNotSet)
+	}
+
+	return NewWatermarks(columns, state), ExitStatusOK, nil
+}
+
+// storeWatermarks function stores watermarks reached by current run into
+// configured file or S3 object
+func storeWatermarks(ctx context.Context, configuration *ConfigStruct,
+	watermarks *Watermarks, operationLogger *zerolog.Logger) (int, error) {
+	if watermarks == nil {
+		return ExitStatusOK, nil
+	}
+
+	data, err := json.MarshalIndent(watermarks.State(), "", "  ")
+	if err != nil {
+		return ExitStatusIOError, err
+	}
+
+	exportConfiguration := GetExportConfiguration(configuration)
+
+	if exportConfiguration.WatermarkStateFile != "" {
+		err = os.WriteFile(exportConfiguration.WatermarkStateFile, data, 0o600)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeWatermarksFailed)
+			return ExitStatusIOError, err
+		}
+	} else {
+		session, err := OpenS3Session(configuration)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeWatermarksFailed)
+			return ExitStatusS3Error, err
+		}
+
+		// state is never compressed, so it can be read by next run
+		// regardless of selected codec
+		err = putObject(ctx, session, session.Bucket(),
+			exportConfiguration.WatermarkStateObject, watermarkContentType,
+			data, noCompression, nil)
+		if err != nil {
+			operationLogger.Err(err).Msg(storeWatermarksFailed)
+			return ExitStatusS3Error, err
+		}
+	}
+
+	operationLogger.Info().Msg(watermarksStored)
+	return ExitStatusOK, nil
+}
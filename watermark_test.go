@@ -0,0 +1,254 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/watermark_test.html
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// TestNewWatermarksNotConfigured checks that all tables are exported as a
+// whole when no column is configured
+func TestNewWatermarksNotConfigured(t *testing.T) {
+	watermarks := main.NewWatermarks(nil, main.WatermarkState{})
+	assert.Nil(t, watermarks)
+
+	assert.Empty(t, watermarks.Column("report"))
+	_, found := watermarks.Previous("report")
+	assert.False(t, found)
+
+	// nothing is recorded by nil watermarks
+	watermarks.Record("report", main.TableWatermark{Column: "id", Watermark: "1"})
+	assert.Empty(t, watermarks.State().Tables)
+}
+
+// TestNewWatermarksColumnChanged checks that watermark recorded for other
+// column than configured is ignored
+func TestNewWatermarksColumnChanged(t *testing.T) {
+	previous := main.WatermarkState{
+		Tables: map[main.TableName]main.TableWatermark{
+			"report":   {Column: "id", Watermark: "10"},
+			"rule_hit": {Column: "id", Watermark: "20"},
+		},
+	}
+
+	watermarks := main.NewWatermarks(main.IncrementalConfiguration{
+		"report":   "reported_at",
+		"rule_hit": "id",
+	}, previous)
+
+	assert.Equal(t, "reported_at", watermarks.Column("report"))
+	_, found := watermarks.Previous("report")
+	assert.False(t, found)
+
+	watermark, found := watermarks.Previous("rule_hit")
+	assert.True(t, found)
+	assert.Equal(t, "20", watermark.Watermark)
+}
+
+// TestWatermarksState checks that watermarks of tables with no new rows are
+// kept from previous run
+func TestWatermarksState(t *testing.T) {
+	previous := main.WatermarkState{
+		Tables: map[main.TableName]main.TableWatermark{
+			"report":   {Column: "id", Watermark: "10"},
+			"rule_hit": {Column: "id", Watermark: "20"},
+		},
+	}
+
+	watermarks := main.NewWatermarks(main.IncrementalConfiguration{
+		"report":   "id",
+		"rule_hit": "id",
+	}, previous)
+	watermarks.Record("report", main.TableWatermark{Column: "id", Watermark: "15"})
+
+	assert.Equal(t, map[main.TableName]main.TableWatermark{
+		"report":   {Column: "id", Watermark: "15"},
+		"rule_hit": {Column: "id", Watermark: "20"},
+	}, watermarks.State().Tables)
+}
+
+// TestWatermarkLess checks that timestamps read as strings are compared
+// chronologically
+func TestWatermarkLess(t *testing.T) {
+	assert.True(t, main.WatermarkLess(int64(9), int64(10)))
+	assert.False(t, main.WatermarkLess(int64(10), int64(9)))
+
+	// fractional seconds are omitted when they are zero
+	assert.True(t, main.WatermarkLess("2024-05-01T10:00:00Z", "2024-05-01T10:00:00.5Z"))
+	assert.False(t, main.WatermarkLess("2024-05-01T10:00:00.5Z", "2024-05-01T10:00:00Z"))
+	assert.True(t, main.WatermarkLess("2024-05-01T12:00:00+02:00", "2024-05-01T10:00:00.1Z"))
+	assert.True(t, main.WatermarkLess("2024-05-01 10:00:00", "2024-05-01 10:00:00.25"))
+	assert.True(t, main.WatermarkLess(
+		time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), "2024-05-01T10:00:00.5Z"))
+}
+
+// insertReportRows function inserts rows with given IDs into report table
+func insertReportRows(t *testing.T, dataSource string, ids ...int) {
+	connection, err := sql.Open("sqlite3", dataSource)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, connection.Close())
+	}()
+
+	for _, id := range ids {
+		_, err = connection.Exec(`INSERT INTO report (id) VALUES ($1)`, id)
+		assert.NoError(t, err)
+	}
+}
+
+// performIncrementalExport function exports report table incrementally into
+// new directory and returns content of exported CSV file
+func performIncrementalExport(t *testing.T, configuration *main.ConfigStruct) string {
+	directory := t.TempDir()
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: directory,
+	}
+
+	code, err := main.PerformDataExport(context.Background(), configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+
+	content, err := os.ReadFile(filepath.Join(directory, "report.csv"))
+	assert.NoError(t, err)
+	return string(content)
+}
+
+// readWatermarkStateFile function reads watermarks stored into given file
+func readWatermarkStateFile(t *testing.T, fileName string) main.WatermarkState {
+	content, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+
+	var state main.WatermarkState
+	assert.NoError(t, json.Unmarshal(content, &state))
+	return state
+}
+
+// TestPerformDataExportIncremental checks that only rows newer than
+// watermark of previous run are exported
+func TestPerformDataExportIncremental(t *testing.T) {
+	dataSource := prepareDatabaseWithTables(t, "report")
+	stateFile := filepath.Join(t.TempDir(), "watermarks.json")
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			WatermarkStateFile: stateFile,
+		},
+		Incremental: main.IncrementalConfiguration{
+			"report": "id",
+		},
+	}
+
+	// no state has been stored yet, so the whole table is exported
+	insertReportRows(t, dataSource, 1, 2, 3)
+	assert.Equal(t, "id\n1\n2\n3\n", performIncrementalExport(t, &configuration))
+	assert.Equal(t, main.TableWatermark{Column: "id", Watermark: "3"},
+		readWatermarkStateFile(t, stateFile).Tables["report"])
+
+	insertReportRows(t, dataSource, 4, 5)
+	assert.Equal(t, "id\n4\n5\n", performIncrementalExport(t, &configuration))
+	assert.Equal(t, main.TableWatermark{Column: "id", Watermark: "5"},
+		readWatermarkStateFile(t, stateFile).Tables["report"])
+
+	// watermark is kept when there are no new rows
+	assert.Equal(t, "id\n", performIncrementalExport(t, &configuration))
+	assert.Equal(t, main.TableWatermark{Column: "id", Watermark: "5"},
+		readWatermarkStateFile(t, stateFile).Tables["report"])
+}
+
+// TestPerformDataExportIncrementalLimit checks that watermark is not moved
+// when only part of rows is exported, so the rest is exported by next run
+func TestPerformDataExportIncrementalLimit(t *testing.T) {
+	dataSource := prepareDatabaseWithTables(t, "report")
+	stateFile := filepath.Join(t.TempDir(), "watermarks.json")
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: dataSource,
+		},
+		Export: main.ExportConfiguration{
+			WatermarkStateFile: stateFile,
+		},
+		Incremental: main.IncrementalConfiguration{
+			"report": "id",
+		},
+		Limits: main.LimitsConfiguration{
+			"report": 2,
+		},
+	}
+
+	insertReportRows(t, dataSource, 1, 2, 3)
+	assert.Equal(t, "id\n1\n2\n", performIncrementalExport(t, &configuration))
+	assert.NotContains(t, readWatermarkStateFile(t, stateFile).Tables, main.TableName("report"))
+
+	// all rows are exported when limit is removed
+	configuration.Limits = nil
+	assert.Equal(t, "id\n1\n2\n3\n", performIncrementalExport(t, &configuration))
+	assert.Equal(t, main.TableWatermark{Column: "id", Watermark: "3"},
+		readWatermarkStateFile(t, stateFile).Tables["report"])
+}
+
+// TestPerformDataExportIncrementalUnknownColumn checks that export fails
+// and watermarks are not stored when configured column does not exist
+func TestPerformDataExportIncrementalUnknownColumn(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "watermarks.json")
+
+	configuration := main.ConfigStruct{
+		Storage: main.StorageConfiguration{
+			Driver:           "sqlite3",
+			SQLiteDataSource: prepareDatabaseWithTables(t, "report"),
+		},
+		Export: main.ExportConfiguration{
+			WatermarkStateFile: stateFile,
+		},
+		Incremental: main.IncrementalConfiguration{
+			"report": "updated_at",
+		},
+	}
+	cliFlags := main.CliFlags{
+		Output:          "file",
+		OutputDirectory: t.TempDir(),
+	}
+
+	_, err := main.PerformDataExport(context.Background(), &configuration, cliFlags,
+		&log.Logger, &log.Logger, main.NewSummary(), nil, nil)
+	assert.Error(t, err)
+	assert.NoFileExists(t, stateFile)
+}
@@ -0,0 +1,360 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of minimal XLSX (Office Open XML
+// spreadsheet) writer. All exported tables are stored as sheets of one
+// workbook, so the export can be opened in Excel or LibreOffice directly.
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/xlsx.html
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits given by XLSX format
+const (
+	maxSheetNameLength = 31
+	maxSheetRows       = 1048576
+	maxCellLength      = 32767
+)
+
+// messages
+const (
+	tooManyRowsInSheet = "Sheet %s has more than %d rows"
+	cellTooLong        = "Value of column %s has %d characters, cell can contain at most %d"
+	workbookFormatOnly = "Format xlsx can be used to export all tables into one workbook only"
+)
+
+// static parts of XLSX package
+const (
+	xlsxContentTypesHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+`
+	xlsxRootRelationships = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>
+`
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="1"><fill><patternFill patternType="none"/></fill></fills>
+<borders count="1"><border/></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/></cellXfs>
+</styleSheet>
+`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>
+`
+)
+
+// Workbook represents XLSX workbook with exported tables. Content of all
+// sheets is kept in memory until the workbook is written.
+type Workbook struct {
+	sheets []*WorkbookSheet
+	names  map[string]struct{}
+}
+
+// WorkbookSheet represents one sheet in workbook. It implements TableWriter
+// interface, so table content can be written into it as into any other
+// output format.
+type WorkbookSheet struct {
+	name string
+	data bytes.Buffer
+	rows int
+}
+
+// NewWorkbook function constructs new empty workbook
+func NewWorkbook() *Workbook {
+	return &Workbook{
+		names: make(map[string]struct{}),
+	}
+}
+
+// sheetName function converts table name into valid and unique sheet name.
+// Sheet names are limited to 31 characters and can not contain some
+// characters.
+func (workbook *Workbook) sheetName(tableName string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, tableName)
+
+	if name == "" {
+		name = "Sheet"
+	}
+
+	candidate := truncateRunes(name, maxSheetNameLength)
+	for i := 2; ; i++ {
+		// sheet names are case insensitive
+		if _, found := workbook.names[strings.ToLower(candidate)]; !found {
+			break
+		}
+		suffix := "~" + strconv.Itoa(i)
+		candidate = truncateRunes(name, maxSheetNameLength-len(suffix)) + suffix
+	}
+
+	workbook.names[strings.ToLower(candidate)] = struct{}{}
+	return candidate
+}
+
+// truncateRunes function truncates string to given number of characters
+func truncateRunes(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length])
+}
+
+// AddSheet method adds new sheet for given table into workbook
+func (workbook *Workbook) AddSheet(tableName string) *WorkbookSheet {
+	sheet := &WorkbookSheet{
+		name: workbook.sheetName(tableName),
+	}
+	workbook.sheets = append(workbook.sheets, sheet)
+	return sheet
+}
+
+// AddTable method adds new sheet for given table into workbook. It is part
+// of TableArchive interface.
+func (workbook *Workbook) AddTable(tableName TableName, _ []Column) (TableWriter, error) {
+	return workbook.AddSheet(string(tableName)), nil
+}
+
+// Close method does nothing, workbook is kept in memory only
+func (workbook *Workbook) Close() error {
+	return nil
+}
+
+// Name method returns name of sheet
+func (sheet *WorkbookSheet) Name() string {
+	return sheet.name
+}
+
+// WriteHeader method writes column names into the first row
+func (sheet *WorkbookSheet) WriteHeader(colNames []string) error {
+	values := make([]interface{}, 0, len(colNames))
+	for _, colName := range colNames {
+		values = append(values, colName)
+	}
+	return sheet.writeCells(values)
+}
+
+// WriteRow method writes one table row into sheet
+func (sheet *WorkbookSheet) WriteRow(colNames []string, row M) error {
+	values := make([]interface{}, 0, len(colNames))
+	for _, colName := range colNames {
+		value := row[colName]
+
+		// longer text would be truncated by spreadsheet applications
+		if s, ok := value.(string); ok && utf8.RuneCountInString(s) > maxCellLength {
+			return RowConversionError{fmt.Errorf(cellTooLong, colName,
+				utf8.RuneCountInString(s), maxCellLength)}
+		}
+		values = append(values, value)
+	}
+	return sheet.writeCells(values)
+}
+
+// Flush method does nothing, sheet is written together with whole workbook
+func (sheet *WorkbookSheet) Flush() error {
+	return nil
+}
+
+// writeCells method writes one row with given cell values
+func (sheet *WorkbookSheet) writeCells(values []interface{}) error {
+	if sheet.rows >= maxSheetRows {
+		return fmt.Errorf(tooManyRowsInSheet, sheet.name, maxSheetRows)
+	}
+	sheet.rows++
+
+	fmt.Fprintf(&sheet.data, `<row r="%d">`, sheet.rows)
+
+	for i, value := range values {
+		ref := columnLetters(i) + strconv.Itoa(sheet.rows)
+
+		switch v := value.(type) {
+		case nil:
+			// empty cells are not written at all
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			fmt.Fprintf(&sheet.data, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		case int, int32, int64, float32, float64:
+			fmt.Fprintf(&sheet.data, `<c r="%s"><v>%v</v></c>`, ref, v)
+		default:
+			fmt.Fprintf(&sheet.data, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			err := xml.EscapeText(&sheet.data, []byte(fmt.Sprintf("%v", v)))
+			if err != nil {
+				return err
+			}
+			sheet.data.WriteString(`</t></is></c>`)
+		}
+	}
+
+	sheet.data.WriteString(`</row>`)
+	return nil
+}
+
+// columnLetters function converts zero-based column index into column name
+// used in cell references (A, B, ..., Z, AA, AB, ...)
+func columnLetters(index int) string {
+	letters := ""
+	for index >= 0 {
+		letters = string(rune('A'+index%26)) + letters
+		index = index/26 - 1
+	}
+	return letters
+}
+
+// Write method writes the whole workbook in XLSX format into given writer
+func (workbook *Workbook) Write(writer io.Writer) error {
+	if writer == nil {
+		return errors.New(bufferIsNil)
+	}
+
+	// workbook needs to contain at least one sheet
+	if len(workbook.sheets) == 0 {
+		workbook.AddSheet("Sheet1")
+	}
+
+	archive := zip.NewWriter(writer)
+
+	// list of all parts with their content
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", workbook.contentTypes()},
+		{"_rels/.rels", xlsxRootRelationships},
+		{"xl/workbook.xml", workbook.workbookXML()},
+		{"xl/_rels/workbook.xml.rels", workbook.relationships()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+
+	for _, part := range parts {
+		err := writeZipEntry(archive, part.name, []byte(part.content))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, sheet := range workbook.sheets {
+		content := make([]byte, 0, len(xlsxSheetHeader)+sheet.data.Len()+len(xlsxSheetFooter))
+		content = append(content, xlsxSheetHeader...)
+		content = append(content, sheet.data.Bytes()...)
+		content = append(content, xlsxSheetFooter...)
+
+		err := writeZipEntry(archive, fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// writeZipEntry function writes one compressed file into ZIP archive
+func writeZipEntry(archive *zip.Writer, name string, content []byte) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(content)
+	return err
+}
+
+// contentTypes method returns content of [Content_Types].xml part
+func (workbook *Workbook) contentTypes() string {
+	var builder strings.Builder
+
+	builder.WriteString(xlsxContentTypesHeader)
+	for i := range workbook.sheets {
+		fmt.Fprintf(&builder, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", i+1)
+	}
+	builder.WriteString("</Types>\n")
+
+	return builder.String()
+}
+
+// workbookXML method returns content of xl/workbook.xml part with list of
+// all sheets
+func (workbook *Workbook) workbookXML() string {
+	var builder strings.Builder
+
+	builder.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	for i, sheet := range workbook.sheets {
+		builder.WriteString(`<sheet name="`)
+		// error can not happen when writing into strings.Builder
+		_ = xml.EscapeText(&builder, []byte(sheet.name))
+		fmt.Fprintf(&builder, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+
+	builder.WriteString("</sheets></workbook>\n")
+	return builder.String()
+}
+
+// relationships method returns content of xl/_rels/workbook.xml.rels part
+func (workbook *Workbook) relationships() string {
+	var builder strings.Builder
+
+	builder.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+`)
+
+	for i := range workbook.sheets {
+		fmt.Fprintf(&builder, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`+"\n", i+1, i+1)
+	}
+
+	// styles need to be referenced too
+	fmt.Fprintf(&builder, `<Relationship Id="rId%d" `+
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" `+
+		`Target="styles.xml"/>`+"\n", len(workbook.sheets)+1)
+
+	builder.WriteString("</Relationships>\n")
+	return builder.String()
+}
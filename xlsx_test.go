@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Generated documentation is available at:
+// https://pkg.go.dev/github.com/RedHatInsights/insights-results-aggregator-exporter
+//
+// Documentation in literate-programming-style is available at:
+// https://redhatinsights.github.io/insights-results-aggregator-exporter/packages/xlsx_test.html
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-results-aggregator-exporter"
+)
+
+// readWorkbook helper function writes workbook and returns content of all
+// parts stored in XLSX package
+func readWorkbook(t *testing.T, workbook *main.Workbook) map[string]string {
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, workbook.Write(buffer))
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	assert.NoError(t, err)
+
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+
+		// all parts need to be well-formed XML documents
+		assert.NoError(t, xml.Unmarshal(content, new(interface{})), file.Name)
+
+		parts[file.Name] = string(content)
+	}
+
+	return parts
+}
+
+// TestWorkbookWrite checks that workbook with two sheets is written properly
+func TestWorkbookWrite(t *testing.T) {
+	workbook := main.NewWorkbook()
+
+	sheet := workbook.AddSheet("first_table")
+	assert.NoError(t, sheet.WriteHeader(testColumns))
+	for _, row := range testRows {
+		assert.NoError(t, sheet.WriteRow(testColumns, row))
+	}
+	assert.NoError(t, sheet.Flush())
+
+	workbook.AddSheet("second_table")
+
+	parts := readWorkbook(t, workbook)
+
+	for _, name := range []string{
+		"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels", "xl/styles.xml",
+		"xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml",
+	} {
+		assert.Contains(t, parts, name)
+	}
+
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="first_table" sheetId="1" r:id="rId1"/>`)
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="second_table" sheetId="2" r:id="rId2"/>`)
+	assert.Contains(t, parts["[Content_Types].xml"], `/xl/worksheets/sheet2.xml`)
+
+	sheet1 := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet1, `<c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	assert.Contains(t, sheet1, `<c r="A2"><v>1</v></c>`)
+	assert.Contains(t, sheet1, `<c r="B3" t="inlineStr"><is><t xml:space="preserve">bar &#34;baz&#34;</t></is></c>`)
+	assert.Contains(t, sheet1, `<c r="C2" t="b"><v>1</v></c>`)
+}
+
+// TestWorkbookWriteEmpty checks that empty workbook contains one sheet
+func TestWorkbookWriteEmpty(t *testing.T) {
+	parts := readWorkbook(t, main.NewWorkbook())
+	assert.Contains(t, parts, "xl/worksheets/sheet1.xml")
+}
+
+// TestWorkbookWriteNilWriter checks that nil writer is refused
+func TestWorkbookWriteNilWriter(t *testing.T) {
+	err := main.NewWorkbook().Write(nil)
+	assert.EqualError(t, err, "Buffer is nil")
+}
+
+// TestWorkbookSheetNames checks that sheet names are valid and unique
+func TestWorkbookSheetNames(t *testing.T) {
+	workbook := main.NewWorkbook()
+
+	assert.Equal(t, "report", workbook.AddSheet("report").Name())
+	assert.Equal(t, "REPORT~2", workbook.AddSheet("REPORT").Name())
+	assert.Equal(t, "a_b_c", workbook.AddSheet("a/b:c").Name())
+
+	longName := "cluster_user_rule_disable_feedback"
+	assert.Equal(t, "cluster_user_rule_disable_feedb", workbook.AddSheet(longName).Name())
+	assert.Equal(t, "cluster_user_rule_disable_fee~2", workbook.AddSheet(longName).Name())
+}
+
+// TestColumnLetters checks conversion of column index into column name
+func TestColumnLetters(t *testing.T) {
+	assert.Equal(t, "A", main.ColumnLetters(0))
+	assert.Equal(t, "Z", main.ColumnLetters(25))
+	assert.Equal(t, "AA", main.ColumnLetters(26))
+	assert.Equal(t, "AZ", main.ColumnLetters(51))
+	assert.Equal(t, "BA", main.ColumnLetters(52))
+}
+
+// TestNewTableWriterXLSX checks that xlsx format can not be used for one
+// table
+func TestNewTableWriterXLSX(t *testing.T) {
+	_, err := main.NewTableWriter("xlsx", new(bytes.Buffer), "test_table", testTableColumns)
+	assert.Error(t, err)
+}